@@ -0,0 +1,71 @@
+// remotelist-crdt roda um nó do modo multi-mestre (remotelist/pkg/crdt).
+// Todos os nós aceitam Append, InsertAt e Remove ao mesmo tempo; as operações
+// chegam aos outros por anti-entropy e todos convergem para o mesmo conteúdo.
+//
+// Uso (três nós, cada um em um terminal):
+//
+//	go run ./cmd/remotelist-crdt -id a -listen localhost:5101 -peers localhost:5102,localhost:5103 -log a.log
+//	go run ./cmd/remotelist-crdt -id b -listen localhost:5102 -peers localhost:5101,localhost:5103 -log b.log
+//	go run ./cmd/remotelist-crdt -id c -listen localhost:5103 -peers localhost:5101,localhost:5102 -log c.log
+//
+// Os clientes (remotelist-shell, por exemplo) podem se conectar em qualquer um.
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"net/rpc"
+	"strings"
+	"time"
+
+	"remotelist/pkg/crdt"
+)
+
+func main() {
+	id := flag.String("id", "", "identificador único e estável deste nó (padrão: -listen)")
+	listen := flag.String("listen", "localhost:5101", "endereço deste nó")
+	peers := flag.String("peers", "", "outros nós, separados por vírgula")
+	logPath := flag.String("log", "crdt.log", "log de operações deste nó")
+	interval := flag.Duration("sync-interval", time.Second, "intervalo entre rodadas de anti-entropy")
+	flag.Parse()
+
+	if *id == "" {
+		*id = *listen
+	}
+	var peerList []string
+	if *peers != "" {
+		peerList = strings.Split(*peers, ",")
+	}
+	node, err := crdt.NewNode(crdt.Config{
+		Replica:      *id,
+		Peers:        peerList,
+		Path:         *logPath,
+		SyncInterval: *interval,
+	})
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+
+	rpcs := rpc.NewServer()
+	if err := rpcs.RegisterName("RemoteList", node); err != nil {
+		log.Fatalf("Falha ao registrar o serviço RPC: %v", err)
+	}
+	if err := rpcs.RegisterName("CRDT", node.Replica()); err != nil {
+		log.Fatalf("Falha ao registrar o serviço RPC: %v", err)
+	}
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("Erro ao escutar em %s: %v", *listen, err)
+	}
+	log.Printf("Nó escutando em %s", *listen)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Printf("Erro ao aceitar conexão: %v", err)
+			continue
+		}
+		go rpcs.ServeConn(conn)
+	}
+}
@@ -0,0 +1,285 @@
+// remotelist-load é um gerador de carga para o servidor RemoteList.
+// Cada cliente tem a sua própria conexão e escolhe, a cada operação, o tipo
+// (conforme -mix) e a lista (uniforme ou com listas "quentes", conforme -skew).
+// Ao final são mostrados a vazão e as latências p50/p99/p999 por operação.
+//
+// Uso:
+//
+//	go run ./cmd/remotelist-load -addr localhost:5000 -clients 16 -lists 64 -duration 30s
+//	go run ./cmd/remotelist-load -embedded -mix append=20,get=70,size=10 -skew 1.3
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"remotelist/pkg"
+)
+
+var opNames = []string{"append", "get", "remove", "size"}
+
+// result acumula as amostras de um cliente.
+type result struct {
+	latencies map[string][]time.Duration
+	appErrors map[string]int // erros da aplicação (ex: lista vazia, índice fora)
+	netErrors int            // falhas de conexão/transporte
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:5000", "endereço do servidor")
+	embedded := flag.Bool("embedded", false, "sobe um servidor próprio em um diretório temporário em vez de usar -addr")
+	clients := flag.Int("clients", 8, "quantidade de clientes (uma conexão cada)")
+	numLists := flag.Int("lists", 16, "quantidade de listas")
+	duration := flag.Duration("duration", 10*time.Second, "duração da medição")
+	mixFlag := flag.String("mix", "append=40,get=40,remove=10,size=10", "proporção das operações")
+	skew := flag.Float64("skew", 0, "expoente Zipf (> 1) para concentrar o tráfego nas primeiras listas; 0 = uniforme")
+	prefill := flag.Int("prefill", 100, "elementos adicionados a cada lista antes da medição")
+	prefix := flag.String("prefix", "load", "prefixo dos nomes das listas")
+	flag.Parse()
+
+	mix, err := parseMix(*mixFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *skew != 0 && *skew <= 1 {
+		log.Fatal("-skew precisa ser 0 (uniforme) ou maior que 1")
+	}
+
+	if *embedded {
+		serverAddr, stop, err := startEmbeddedServer()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer stop()
+		*addr = serverAddr
+	}
+
+	listIDs := make([]string, *numLists)
+	for i := range listIDs {
+		listIDs[i] = fmt.Sprintf("%s-%d", *prefix, i)
+	}
+	if err := prefillLists(*addr, listIDs, *prefill); err != nil {
+		log.Fatal(err)
+	}
+
+	results := make([]*result, *clients)
+	var wg sync.WaitGroup
+	deadline := time.Now().Add(*duration)
+	for c := 0; c < *clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			results[c] = runClient(*addr, listIDs, mix, *skew, *prefill, deadline, int64(c))
+		}(c)
+	}
+	wg.Wait()
+
+	report(results, *duration, *clients, *numLists, *skew)
+}
+
+// parseMix interpreta "append=40,get=40,..." em pesos cumulativos.
+func parseMix(s string) (map[string]int, error) {
+	mix := make(map[string]int)
+	total := 0
+	for _, part := range strings.Split(s, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("mix inválido %q (use op=peso)", part)
+		}
+		w, err := strconv.Atoi(weight)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("peso inválido em %q", part)
+		}
+		known := false
+		for _, op := range opNames {
+			known = known || op == name
+		}
+		if !known {
+			return nil, fmt.Errorf("operação desconhecida %q", name)
+		}
+		mix[name] = w
+		total += w
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("mix sem nenhuma operação")
+	}
+	return mix, nil
+}
+
+// pickOp sorteia uma operação de acordo com os pesos.
+func pickOp(r *rand.Rand, mix map[string]int) string {
+	total := 0
+	for _, op := range opNames {
+		total += mix[op]
+	}
+	n := r.Intn(total)
+	for _, op := range opNames {
+		if n < mix[op] {
+			return op
+		}
+		n -= mix[op]
+	}
+	return opNames[0]
+}
+
+func prefillLists(addr string, listIDs []string, n int) error {
+	client, err := rpc.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	for _, id := range listIDs {
+		for i := 0; i < n; i++ {
+			var reply remotelist.AppendReply
+			if err := client.Call("RemoteList.Append", remotelist.AppendArgs{ListID: id, Value: i}, &reply); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runClient executa operações até o prazo e devolve as amostras coletadas.
+func runClient(addr string, listIDs []string, mix map[string]int, skew float64, prefill int, deadline time.Time, seed int64) *result {
+	res := &result{latencies: make(map[string][]time.Duration), appErrors: make(map[string]int)}
+	client, err := rpc.Dial("tcp", addr)
+	if err != nil {
+		res.netErrors++
+		return res
+	}
+	defer client.Close()
+
+	r := rand.New(rand.NewSource(time.Now().UnixNano() + seed))
+	var zipf *rand.Zipf
+	if skew > 1 {
+		zipf = rand.NewZipf(r, skew, 1, uint64(len(listIDs)-1))
+	}
+
+	for time.Now().Before(deadline) {
+		listID := listIDs[r.Intn(len(listIDs))]
+		if zipf != nil {
+			listID = listIDs[zipf.Uint64()]
+		}
+		op := pickOp(r, mix)
+
+		start := time.Now()
+		switch op {
+		case "append":
+			err = client.Call("RemoteList.Append", remotelist.AppendArgs{ListID: listID, Value: r.Int()}, &remotelist.AppendReply{})
+		case "get":
+			err = client.Call("RemoteList.Get", remotelist.GetArgs{ListID: listID, Index: r.Intn(prefill + 1)}, &remotelist.GetReply{})
+		case "remove":
+			err = client.Call("RemoteList.Remove", remotelist.RemoveArgs{ListID: listID}, &remotelist.RemoveReply{})
+		case "size":
+			err = client.Call("RemoteList.Size", remotelist.SizeArgs{ListID: listID}, &remotelist.SizeReply{})
+		}
+		elapsed := time.Since(start)
+
+		if _, isServerErr := err.(rpc.ServerError); err != nil && !isServerErr {
+			res.netErrors++
+			return res
+		}
+		if err != nil {
+			res.appErrors[op]++
+		}
+		res.latencies[op] = append(res.latencies[op], elapsed)
+	}
+	return res
+}
+
+func report(results []*result, duration time.Duration, clients, lists int, skew float64) {
+	merged := make(map[string][]time.Duration)
+	var all []time.Duration
+	appErrors := make(map[string]int)
+	netErrors := 0
+	for _, res := range results {
+		for op, samples := range res.latencies {
+			merged[op] = append(merged[op], samples...)
+			all = append(all, samples...)
+		}
+		for op, n := range res.appErrors {
+			appErrors[op] += n
+		}
+		netErrors += res.netErrors
+	}
+
+	dist := "uniforme"
+	if skew > 1 {
+		dist = fmt.Sprintf("zipf s=%.2f", skew)
+	}
+	fmt.Printf("%d clientes, %d listas (%s), %v\n", clients, lists, dist, duration)
+	fmt.Printf("%-8s %10s %12s %12s %12s %12s %10s\n", "op", "total", "ops/s", "p50", "p99", "p999", "erros")
+	for _, op := range opNames {
+		if samples := merged[op]; len(samples) > 0 {
+			printLine(op, samples, duration, appErrors[op])
+		}
+	}
+	if len(all) > 0 {
+		total := 0
+		for _, n := range appErrors {
+			total += n
+		}
+		printLine("total", all, duration, total)
+	}
+	if netErrors > 0 {
+		fmt.Printf("%d clientes pararam por erro de conexão\n", netErrors)
+	}
+}
+
+func printLine(name string, samples []time.Duration, duration time.Duration, errors int) {
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	fmt.Printf("%-8s %10d %12.1f %12v %12v %12v %10d\n", name, len(samples),
+		float64(len(samples))/duration.Seconds(),
+		percentile(samples, 0.50), percentile(samples, 0.99), percentile(samples, 0.999), errors)
+}
+
+// percentile assume as amostras ordenadas.
+func percentile(samples []time.Duration, p float64) time.Duration {
+	return samples[int(p*float64(len(samples)-1))]
+}
+
+// startEmbeddedServer sobe um RemoteList em um diretório temporário, ouvindo
+// em uma porta livre, e devolve o endereço e uma função para desligá-lo.
+func startEmbeddedServer() (string, func(), error) {
+	dir, err := os.MkdirTemp("", "remotelist-load")
+	if err != nil {
+		return "", nil, err
+	}
+	rl, err := remotelist.NewRemoteListWithConfig(remotelist.Config{Dir: dir})
+	if err != nil {
+		return "", nil, err
+	}
+	rpcs := rpc.NewServer()
+	if err := rpcs.Register(rl); err != nil {
+		return "", nil, err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go rpcs.ServeConn(conn)
+		}
+	}()
+	stop := func() {
+		l.Close()
+		rl.Close()
+		os.RemoveAll(dir)
+	}
+	return l.Addr().String(), stop, nil
+}
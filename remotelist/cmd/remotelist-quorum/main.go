@@ -0,0 +1,72 @@
+// remotelist-quorum roda um nó do modo de replicação sem líder (remotelist/pkg/quorum).
+// Cada lista fica em N nós; qualquer nó atende os clientes, esperando R
+// respostas nas leituras e W confirmações nas escritas.
+//
+// Uso (três nós, cada um em um terminal):
+//
+//	go run ./cmd/remotelist-quorum -listen localhost:5001 -nodes localhost:5001,localhost:5002,localhost:5003 -db q1.db
+//	go run ./cmd/remotelist-quorum -listen localhost:5002 -nodes localhost:5001,localhost:5002,localhost:5003 -db q2.db
+//	go run ./cmd/remotelist-quorum -listen localhost:5003 -nodes localhost:5001,localhost:5002,localhost:5003 -db q3.db
+//
+// Os clientes (remotelist-shell, por exemplo) podem se conectar em qualquer um.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"os"
+	"strings"
+
+	"remotelist/pkg/quorum"
+)
+
+func main() {
+	listen := flag.String("listen", "localhost:5001", "endereço deste nó (o mesmo que aparece em -nodes)")
+	nodes := flag.String("nodes", "", "todos os nós, separados por vírgula")
+	n := flag.Int("n", 3, "réplicas de cada lista")
+	r := flag.Int("r", 2, "respostas esperadas por uma leitura")
+	w := flag.Int("w", 2, "confirmações esperadas por uma escrita")
+	db := flag.String("db", "quorum.db", "banco bbolt deste nó")
+	flag.Parse()
+
+	if *nodes == "" {
+		fmt.Fprintln(os.Stderr, "informe os nós com -nodes")
+		os.Exit(2)
+	}
+	node, err := quorum.NewNode(quorum.Config{
+		Self:  *listen,
+		Nodes: strings.Split(*nodes, ","),
+		N:     *n,
+		R:     *r,
+		W:     *w,
+		Path:  *db,
+	})
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+
+	rpcs := rpc.NewServer()
+	if err := rpcs.RegisterName("RemoteList", node); err != nil {
+		log.Fatalf("Falha ao registrar o serviço RPC: %v", err)
+	}
+	if err := rpcs.RegisterName("Quorum", node.Replica()); err != nil {
+		log.Fatalf("Falha ao registrar o serviço RPC: %v", err)
+	}
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("Erro ao escutar em %s: %v", *listen, err)
+	}
+	log.Printf("Nó escutando em %s", *listen)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Printf("Erro ao aceitar conexão: %v", err)
+			continue
+		}
+		go rpcs.ServeConn(conn)
+	}
+}
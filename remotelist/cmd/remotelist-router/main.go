@@ -0,0 +1,160 @@
+// remotelist-router distribui as listas entre vários servidores RemoteList
+// com um anel de hash consistente. Os clientes se conectam ao roteador como
+// se ele fosse um servidor único.
+//
+// Uso:
+//
+//	go run ./cmd/remotelist-router -listen localhost:5000 -nodes localhost:5001,localhost:5002
+//
+// Para colocar um servidor novo no anel com o roteador no ar (as listas que
+// passam a ser dele são migradas na hora):
+//
+//	go run ./cmd/remotelist-router -admin localhost:5000 -add localhost:5003
+//	go run ./cmd/remotelist-router -admin localhost:5000 -status
+//
+// O roteador também coordena transações entre servidores (Transact e Move,
+// com commit em duas fases); as decisões ficam no arquivo de -txlog. Com mais
+// de um roteador, cada um precisa do seu -txlog e de um -coordinator só dele.
+// Sem -coordinator, o identificador é derivado da máquina, de -listen e do
+// caminho de -txlog; ele precisa ser o mesmo a cada reinício, para a
+// recuperação achar as transações deste roteador, então fixe -coordinator
+// antes de mudar um desses.
+//
+// A migração e as transações usam o serviço Peer dos servidores, que só atende
+// conexões locais ou com a chave de REMOTELIST_PEER_KEY (a mesma nos
+// servidores e no roteador).
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"remotelist/pkg"
+	"remotelist/pkg/shard"
+)
+
+func main() {
+	listen := flag.String("listen", "localhost:5000", "endereço do roteador")
+	nodes := flag.String("nodes", "", "servidores RemoteList, separados por vírgula")
+	vnodes := flag.Int("vnodes", shard.DefaultVNodes, "nós virtuais por servidor")
+	admin := flag.String("admin", "", "endereço de um roteador no ar, para -add, -status ou -rebalance")
+	add := flag.String("add", "", "com -admin: servidor a colocar no anel")
+	status := flag.Bool("status", false, "com -admin: mostra os servidores do anel")
+	rebalance := flag.Bool("rebalance", false, "com -admin: tenta de novo as migrações pendentes")
+	txlog := flag.String("txlog", "router-tx.log", "log do coordenador de transações (vazio desabilita Transact e Move)")
+	coordID := flag.String("coordinator", "", "identificador deste roteador nos IDs de transação (vazio = derivado da máquina, de -listen e de -txlog)")
+	maxConns := flag.Int("max-conns", 0, "conexões atendidas ao mesmo tempo; as outras esperam (0 = sem limite)")
+	idleTimeout := flag.Duration("idle-timeout", 0, "fecha conexões sem pedidos por esse tempo (0 = nunca)")
+	readTimeout := flag.Duration("read-timeout", 0, "tempo máximo para um pedido chegar por inteiro (0 = sem limite)")
+	flag.Parse()
+
+	if *admin != "" {
+		if err := runAdmin(*admin, *add, *status, *rebalance); err != nil {
+			fmt.Fprintln(os.Stderr, "erro:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *nodes == "" {
+		fmt.Fprintln(os.Stderr, "informe os servidores com -nodes")
+		os.Exit(2)
+	}
+	if *coordID == "" && *txlog != "" {
+		id, err := defaultCoordinatorID(*listen, *txlog)
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		*coordID = id
+		log.Printf("Coordenador de transações %s (use -coordinator para fixá-lo).", id)
+	}
+	router, err := shard.NewRouter(shard.Config{
+		Nodes:         strings.Split(*nodes, ","),
+		VNodes:        *vnodes,
+		TxLog:         *txlog,
+		CoordinatorID: *coordID,
+		PeerKey:       os.Getenv("REMOTELIST_PEER_KEY"),
+	})
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+
+	rpcs := rpc.NewServer()
+	if err := rpcs.RegisterName("RemoteList", router); err != nil {
+		log.Fatalf("Falha ao registrar o serviço RPC: %v", err)
+	}
+	if err := rpcs.RegisterName("Router", &shard.Admin{Router: router}); err != nil {
+		log.Fatalf("Falha ao registrar o serviço RPC: %v", err)
+	}
+
+	conns := remotelist.NewConnServer(rpcs, remotelist.ConnConfig{MaxConns: *maxConns, IdleTimeout: *idleTimeout, ReadTimeout: *readTimeout})
+	if err := rpcs.RegisterName("Conns", conns); err != nil {
+		log.Fatalf("Falha ao registrar o serviço RPC: %v", err)
+	}
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("Erro ao escutar em %s: %v", *listen, err)
+	}
+	log.Printf("Roteador escutando em %s, servidores: %s", *listen, strings.Join(router.Nodes(), ", "))
+	if err := conns.Serve(l); err != nil {
+		log.Fatalf("Erro ao aceitar conexões: %v", err)
+	}
+}
+
+// defaultCoordinatorID deriva o identificador do coordenador da máquina, do
+// endereço do roteador e do caminho absoluto do log de transações: dois
+// roteadores só o dividem se dividirem também o log.
+func defaultCoordinatorID(listen, txlog string) (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("sem -coordinator e sem o nome da máquina: %w", err)
+	}
+	path, err := filepath.Abs(txlog)
+	if err != nil {
+		return "", err
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\x00%s\x00%s", host, listen, path)
+	return fmt.Sprintf("router-%016x", h.Sum64()), nil
+}
+
+// runAdmin envia um comando de administração a um roteador no ar.
+func runAdmin(addr, add string, status, rebalance bool) error {
+	client, err := rpc.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	switch {
+	case add != "":
+		var reply shard.AddNodeReply
+		err := client.Call("Router.AddNode", shard.AddNodeArgs{Addr: add}, &reply)
+		fmt.Printf("%d listas migradas para %s\n", reply.Moved, add)
+		return err
+	case rebalance:
+		var reply shard.RebalanceReply
+		err := client.Call("Router.Rebalance", shard.RebalanceArgs{}, &reply)
+		fmt.Printf("%d listas pendentes\n", reply.Pending)
+		return err
+	case status:
+		var reply shard.StatusReply
+		if err := client.Call("Router.Status", shard.StatusArgs{}, &reply); err != nil {
+			return err
+		}
+		for _, node := range reply.Nodes {
+			fmt.Println(node)
+		}
+		fmt.Printf("%d listas pendentes\n", reply.Pending)
+		return nil
+	}
+	return fmt.Errorf("informe -add, -status ou -rebalance")
+}
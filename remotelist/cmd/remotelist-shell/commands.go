@@ -0,0 +1,1122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"remotelist/pkg"
+	"remotelist/pkg/crdt"
+)
+
+func (sh *shell) appendCmd(args []string) error {
+	if len(args) < 2 {
+		return errors.New("uso: append <lista> <valor> [valor...]")
+	}
+	values := make([]int, 0, len(args)-1)
+	for _, a := range args[1:] {
+		v, err := strconv.Atoi(a)
+		if err != nil {
+			return fmt.Errorf("valor inválido %q", a)
+		}
+		values = append(values, v)
+	}
+	for _, v := range values {
+		var reply remotelist.AppendReply
+		if err := sh.call("Append", remotelist.AppendArgs{ListID: args[0], Value: v}, &reply); err != nil {
+			return err
+		}
+		sh.read.Token = max(sh.read.Token, reply.Token)
+	}
+	sh.print(map[string]any{"op": "append", "list": args[0], "values": values, "ok": true},
+		fmt.Sprintf("ok (%d valores)", len(values)))
+	return nil
+}
+
+func (sh *shell) getCmd(args []string) error {
+	if len(args) != 2 {
+		return errors.New("uso: get <lista> <índice>")
+	}
+	index, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("índice inválido %q", args[1])
+	}
+	var reply remotelist.GetReply
+	if err := sh.call("Get", remotelist.GetArgs{ListID: args[0], Index: index, Read: sh.read}, &reply); err != nil {
+		return err
+	}
+	sh.print(map[string]any{"op": "get", "list": args[0], "index": index, "value": reply.Value},
+		strconv.Itoa(reply.Value))
+	return nil
+}
+
+func (sh *shell) getAtCmd(args []string) error {
+	if len(args) != 3 {
+		return errors.New("uso: getat <lista> <índice> <RFC3339|seq>")
+	}
+	index, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("índice inválido %q", args[1])
+	}
+	req := remotelist.GetAtArgs{ListID: args[0], Index: index}
+	if seq, err := strconv.ParseUint(args[2], 10, 64); err == nil {
+		req.AsOfSeq = seq
+	} else if t, err := time.Parse(time.RFC3339, args[2]); err == nil {
+		req.AsOf = t
+	} else {
+		return fmt.Errorf("instante inválido %q", args[2])
+	}
+	var reply remotelist.GetAtReply
+	if err := sh.call("GetAt", req, &reply); err != nil {
+		return err
+	}
+	sh.print(map[string]any{"op": "getat", "list": args[0], "index": index, "at": args[2], "value": reply.Value},
+		strconv.Itoa(reply.Value))
+	return nil
+}
+
+func (sh *shell) insertAtCmd(args []string) error {
+	if len(args) != 3 {
+		return errors.New("uso: insertat <lista> <índice> <valor>")
+	}
+	index, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("índice inválido %q", args[1])
+	}
+	value, err := strconv.Atoi(args[2])
+	if err != nil {
+		return fmt.Errorf("valor inválido %q", args[2])
+	}
+	var reply crdt.InsertAtReply
+	if err := sh.call("InsertAt", crdt.InsertAtArgs{ListID: args[0], Index: index, Value: value}, &reply); err != nil {
+		return err
+	}
+	sh.print(map[string]any{"op": "insertat", "list": args[0], "index": index, "value": value, "ok": true}, "ok")
+	return nil
+}
+
+func (sh *shell) removeCmd(args []string) error {
+	if len(args) != 1 {
+		return errors.New("uso: remove <lista>")
+	}
+	var reply remotelist.RemoveReply
+	if err := sh.call("Remove", remotelist.RemoveArgs{ListID: args[0]}, &reply); err != nil {
+		return err
+	}
+	sh.read.Token = max(sh.read.Token, reply.Token)
+	sh.print(map[string]any{"op": "remove", "list": args[0], "value": reply.Value}, strconv.Itoa(reply.Value))
+	return nil
+}
+
+func (sh *shell) sizeCmd(args []string) error {
+	if len(args) != 1 {
+		return errors.New("uso: size <lista>")
+	}
+	var reply remotelist.SizeReply
+	if err := sh.call("Size", remotelist.SizeArgs{ListID: args[0], Read: sh.read}, &reply); err != nil {
+		return err
+	}
+	sh.print(map[string]any{"op": "size", "list": args[0], "size": reply.Size}, strconv.Itoa(reply.Size))
+	return nil
+}
+
+func (sh *shell) expireCmd(args []string) error {
+	if len(args) != 2 {
+		return errors.New("uso: expire <lista> <duração>")
+	}
+	d, err := time.ParseDuration(args[1])
+	if err != nil || d <= 0 {
+		return fmt.Errorf("duração inválida %q", args[1])
+	}
+	var reply remotelist.SetTTLReply
+	if err := sh.call("SetTTL", remotelist.SetTTLArgs{ListID: args[0], TTL: d}, &reply); err != nil {
+		return err
+	}
+	sh.read.Token = max(sh.read.Token, reply.Token)
+	sh.print(map[string]any{"op": "expire", "list": args[0], "expires": reply.Expires.Format(time.RFC3339Nano)},
+		"expira em "+reply.Expires.Format(time.RFC3339))
+	return nil
+}
+
+func (sh *shell) persistCmd(args []string) error {
+	if len(args) != 1 {
+		return errors.New("uso: persist <lista>")
+	}
+	var reply remotelist.PersistReply
+	if err := sh.call("Persist", remotelist.PersistArgs{ListID: args[0]}, &reply); err != nil {
+		return err
+	}
+	sh.read.Token = max(sh.read.Token, reply.Token)
+	text := "ok"
+	if !reply.HadTTL {
+		text = "a lista não tinha prazo"
+	}
+	sh.print(map[string]any{"op": "persist", "list": args[0], "had_ttl": reply.HadTTL}, text)
+	return nil
+}
+
+func (sh *shell) ttlCmd(args []string) error {
+	if len(args) != 1 {
+		return errors.New("uso: ttl <lista>")
+	}
+	var reply remotelist.TTLReply
+	if err := sh.call("TTL", remotelist.TTLArgs{ListID: args[0], Read: sh.read}, &reply); err != nil {
+		return err
+	}
+	if !reply.HasTTL {
+		sh.print(map[string]any{"op": "ttl", "list": args[0], "ttl_ms": -1}, "sem prazo")
+		return nil
+	}
+	sh.print(map[string]any{"op": "ttl", "list": args[0], "ttl_ms": reply.TTL.Milliseconds(), "expires": reply.Expires.Format(time.RFC3339Nano)},
+		reply.TTL.Round(time.Millisecond).String())
+	return nil
+}
+
+func (sh *shell) scheduleCmd(args []string) error {
+	if len(args) != 3 {
+		return errors.New("uso: schedule <lista> <valor> <duração|instante>")
+	}
+	value, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("valor inválido %q", args[1])
+	}
+	schedArgs := remotelist.ScheduleArgs{ListID: args[0], Value: value}
+	if d, err := time.ParseDuration(args[2]); err == nil && d >= 0 {
+		schedArgs.Delay = d
+	} else if at, err := time.Parse(time.RFC3339, args[2]); err == nil {
+		schedArgs.At = at
+	} else {
+		return fmt.Errorf("quando inválido %q: use uma duração (ex: 30s) ou um instante RFC3339", args[2])
+	}
+	var reply remotelist.ScheduleReply
+	if err := sh.call("Schedule", schedArgs, &reply); err != nil {
+		return err
+	}
+	sh.read.Token = max(sh.read.Token, reply.Token)
+	sh.print(map[string]any{"op": "schedule", "list": args[0], "id": reply.ID, "due": reply.Due.Format(time.RFC3339Nano)},
+		fmt.Sprintf("item %d entra em %s", reply.ID, reply.Due.Format(time.RFC3339)))
+	return nil
+}
+
+func (sh *shell) unscheduleCmd(args []string) error {
+	if len(args) != 2 {
+		return errors.New("uso: unschedule <lista> <id>")
+	}
+	id, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("ID inválido %q", args[1])
+	}
+	var reply remotelist.UnscheduleReply
+	if err := sh.call("Unschedule", remotelist.UnscheduleArgs{ListID: args[0], ID: id}, &reply); err != nil {
+		return err
+	}
+	sh.read.Token = max(sh.read.Token, reply.Token)
+	text := "ok"
+	if !reply.Found {
+		text = "o item não está agendado (já entrou na lista?)"
+	}
+	sh.print(map[string]any{"op": "unschedule", "list": args[0], "id": id, "found": reply.Found}, text)
+	return nil
+}
+
+func (sh *shell) scheduledCmd(args []string) error {
+	if len(args) != 1 {
+		return errors.New("uso: scheduled <lista>")
+	}
+	var reply remotelist.ScheduledReply
+	if err := sh.call("Scheduled", remotelist.ScheduledArgs{ListID: args[0], Read: sh.read}, &reply); err != nil {
+		return err
+	}
+	items := make([]map[string]any, 0, len(reply.Items))
+	lines := make([]string, 0, len(reply.Items))
+	for _, it := range reply.Items {
+		items = append(items, map[string]any{"id": it.ID, "value": it.Value, "due": it.Due.Format(time.RFC3339Nano)})
+		lines = append(lines, fmt.Sprintf("%d %d em %s", it.ID, it.Value, it.Due.Format(time.RFC3339)))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "(nenhum)")
+	}
+	sh.print(map[string]any{"op": "scheduled", "list": args[0], "items": items}, strings.Join(lines, "\n"))
+	return nil
+}
+
+func (sh *shell) listsCmd(args []string) error {
+	if len(args) != 0 {
+		return errors.New("uso: lists")
+	}
+	var reply remotelist.ListsReply
+	if err := sh.call("Lists", remotelist.ListsArgs{Read: sh.read}, &reply); err != nil {
+		return err
+	}
+	if reply.ListIDs == nil {
+		reply.ListIDs = []string{}
+	}
+	text := strings.Join(reply.ListIDs, "\n")
+	if len(reply.ListIDs) == 0 {
+		text = "(nenhuma lista)"
+	}
+	sh.print(map[string]any{"op": "lists", "lists": reply.ListIDs}, text)
+	return nil
+}
+
+func (sh *shell) setWriteCmd(cmd string, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("uso: %s <set> <membro>", cmd)
+	}
+	member, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("membro inválido %q", args[1])
+	}
+	var changed bool
+	var token uint64
+	if cmd == "sadd" {
+		var reply remotelist.SetAddReply
+		err = sh.call("SetAdd", remotelist.SetAddArgs{Key: args[0], Member: member}, &reply)
+		changed, token = reply.Added, reply.Token
+	} else {
+		var reply remotelist.SetRemoveReply
+		err = sh.call("SetRemove", remotelist.SetRemoveArgs{Key: args[0], Member: member}, &reply)
+		changed, token = reply.Removed, reply.Token
+	}
+	if err != nil {
+		return err
+	}
+	sh.read.Token = max(sh.read.Token, token)
+	text := "ok"
+	if !changed {
+		text = "ok (nada mudou)"
+	}
+	sh.print(map[string]any{"op": cmd, "set": args[0], "member": member, "changed": changed}, text)
+	return nil
+}
+
+func (sh *shell) setContainsCmd(args []string) error {
+	if len(args) != 2 {
+		return errors.New("uso: scontains <set> <membro>")
+	}
+	member, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("membro inválido %q", args[1])
+	}
+	var reply remotelist.SetContainsReply
+	if err := sh.call("SetContains", remotelist.SetContainsArgs{Key: args[0], Member: member, Read: sh.read}, &reply); err != nil {
+		return err
+	}
+	text := "não"
+	if reply.Contains {
+		text = "sim"
+	}
+	sh.print(map[string]any{"op": "scontains", "set": args[0], "member": member, "contains": reply.Contains}, text)
+	return nil
+}
+
+func (sh *shell) setMembersCmd(args []string) error {
+	if len(args) != 1 {
+		return errors.New("uso: smembers <set>")
+	}
+	var reply remotelist.SetMembersReply
+	if err := sh.call("SetMembers", remotelist.SetMembersArgs{Key: args[0], Read: sh.read}, &reply); err != nil {
+		return err
+	}
+	if reply.Members == nil {
+		reply.Members = []int{}
+	}
+	sh.print(map[string]any{"op": "smembers", "set": args[0], "members": reply.Members}, fmt.Sprint(reply.Members))
+	return nil
+}
+
+func (sh *shell) mapSetCmd(args []string) error {
+	if len(args) != 3 {
+		return errors.New("uso: hset <map> <campo> <valor>")
+	}
+	v, err := strconv.Atoi(args[2])
+	if err != nil {
+		return fmt.Errorf("valor inválido %q", args[2])
+	}
+	var reply remotelist.MapSetReply
+	if err := sh.call("MapSet", remotelist.MapSetArgs{Key: args[0], Field: args[1], Value: v}, &reply); err != nil {
+		return err
+	}
+	sh.read.Token = max(sh.read.Token, reply.Token)
+	text := "ok (campo novo)"
+	if !reply.Created {
+		text = "ok (campo sobrescrito)"
+	}
+	sh.print(map[string]any{"op": "hset", "map": args[0], "field": args[1], "value": v, "created": reply.Created}, text)
+	return nil
+}
+
+func (sh *shell) mapGetCmd(args []string) error {
+	if len(args) != 2 {
+		return errors.New("uso: hget <map> <campo>")
+	}
+	var reply remotelist.MapGetReply
+	if err := sh.call("MapGet", remotelist.MapGetArgs{Key: args[0], Field: args[1], Read: sh.read}, &reply); err != nil {
+		return err
+	}
+	text := strconv.Itoa(reply.Value)
+	if !reply.Found {
+		text = "(campo inexistente)"
+	}
+	sh.print(map[string]any{"op": "hget", "map": args[0], "field": args[1], "value": reply.Value, "found": reply.Found}, text)
+	return nil
+}
+
+func (sh *shell) mapDeleteCmd(args []string) error {
+	if len(args) != 2 {
+		return errors.New("uso: hdel <map> <campo>")
+	}
+	var reply remotelist.MapDeleteReply
+	if err := sh.call("MapDelete", remotelist.MapDeleteArgs{Key: args[0], Field: args[1]}, &reply); err != nil {
+		return err
+	}
+	sh.read.Token = max(sh.read.Token, reply.Token)
+	text := "ok"
+	if !reply.Deleted {
+		text = "ok (campo inexistente)"
+	}
+	sh.print(map[string]any{"op": "hdel", "map": args[0], "field": args[1], "deleted": reply.Deleted}, text)
+	return nil
+}
+
+func (sh *shell) counterCmd(cmd string, args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return fmt.Errorf("uso: %s <contador> [quanto]", cmd)
+	}
+	by := 1
+	if len(args) == 2 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v <= 0 {
+			return fmt.Errorf("quantidade inválida %q", args[1])
+		}
+		by = v
+	}
+	method := "CounterIncr"
+	if cmd == "decr" {
+		method = "CounterDecr"
+	}
+	var reply remotelist.CounterReply
+	if err := sh.call(method, remotelist.CounterArgs{Key: args[0], By: by}, &reply); err != nil {
+		return err
+	}
+	sh.read.Token = max(sh.read.Token, reply.Token)
+	sh.print(map[string]any{"op": cmd, "counter": args[0], "value": reply.Value}, strconv.Itoa(reply.Value))
+	return nil
+}
+
+func (sh *shell) counterGetCmd(args []string) error {
+	if len(args) != 1 {
+		return errors.New("uso: counter <contador>")
+	}
+	var reply remotelist.CounterGetReply
+	if err := sh.call("CounterGet", remotelist.CounterGetArgs{Key: args[0], Read: sh.read}, &reply); err != nil {
+		return err
+	}
+	sh.print(map[string]any{"op": "counter", "counter": args[0], "value": reply.Value}, strconv.Itoa(reply.Value))
+	return nil
+}
+
+func (sh *shell) sortedAddCmd(args []string) error {
+	if len(args) != 3 {
+		return errors.New("uso: zadd <sorted> <membro> <pontuação>")
+	}
+	member, err1 := strconv.Atoi(args[1])
+	score, err2 := strconv.Atoi(args[2])
+	if err1 != nil || err2 != nil {
+		return errors.New("membro e pontuação precisam ser inteiros")
+	}
+	var reply remotelist.SortedAddReply
+	if err := sh.call("SortedAdd", remotelist.SortedAddArgs{Key: args[0], Member: member, Score: score}, &reply); err != nil {
+		return err
+	}
+	sh.read.Token = max(sh.read.Token, reply.Token)
+	text := "ok (membro novo)"
+	if !reply.Added {
+		text = "ok (pontuação atualizada)"
+	}
+	sh.print(map[string]any{"op": "zadd", "sorted": args[0], "member": member, "score": score, "added": reply.Added}, text)
+	return nil
+}
+
+func (sh *shell) sortedPopCmd(cmd string, args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return fmt.Errorf("uso: %s <sorted> [n]", cmd)
+	}
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("quantidade inválida %q", args[1])
+		}
+		count = n
+	}
+	method := "SortedPopMin"
+	if cmd == "zpopmax" {
+		method = "SortedPopMax"
+	}
+	var reply remotelist.SortedPopReply
+	if err := sh.call(method, remotelist.SortedPopArgs{Key: args[0], Count: count}, &reply); err != nil {
+		return err
+	}
+	sh.read.Token = max(sh.read.Token, reply.Token)
+	sh.printScored(cmd, args[0], reply.Items, -1)
+	return nil
+}
+
+func (sh *shell) sortedRangeCmd(args []string) error {
+	if len(args) < 1 {
+		return errors.New("uso: zrange <sorted> [asc|desc] [min=N] [max=N] [offset=N] [limit=N]")
+	}
+	var view remotelist.ViewArgs
+	if err := parseViewOptions(args[1:], &view, true); err != nil {
+		return err
+	}
+	if view.Filter.Parity != "" {
+		return fmt.Errorf("zrange não filtra por paridade (%q)", view.Filter.Parity)
+	}
+	rangeArgs := remotelist.SortedRangeArgs{Key: args[0], Min: view.Filter.Min, Max: view.Filter.Max,
+		Reverse: view.Order == remotelist.OrderDesc, Offset: view.Offset, Limit: view.Limit, Read: sh.read}
+	var reply remotelist.SortedRangeReply
+	if err := sh.call("SortedRange", rangeArgs, &reply); err != nil {
+		return err
+	}
+	sh.printScored("zrange", args[0], reply.Items, reply.Total)
+	return nil
+}
+
+// printScored mostra membros de um sorted set, um "membro pontuação" por
+// linha; total negativo não é mostrado.
+func (sh *shell) printScored(op, key string, items []remotelist.ScoredMember, total int) {
+	if items == nil {
+		items = []remotelist.ScoredMember{}
+	}
+	lines := make([]string, 0, len(items)+1)
+	for _, it := range items {
+		lines = append(lines, fmt.Sprintf("%d %d", it.Member, it.Score))
+	}
+	obj := map[string]any{"op": op, "sorted": key, "items": items}
+	if total >= 0 {
+		obj["total"] = total
+		lines = append(lines, fmt.Sprintf("(%d de %d)", len(items), total))
+	} else if len(items) == 0 {
+		lines = append(lines, "(vazio)")
+	}
+	sh.print(obj, strings.Join(lines, "\n"))
+}
+
+func (sh *shell) sortedRankCmd(args []string) error {
+	if len(args) != 2 && !(len(args) == 3 && args[2] == remotelist.OrderDesc) {
+		return errors.New("uso: zrank <sorted> <membro> [desc]")
+	}
+	member, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("membro inválido %q", args[1])
+	}
+	var reply remotelist.SortedRankReply
+	if err := sh.call("SortedRank", remotelist.SortedRankArgs{Key: args[0], Member: member, Reverse: len(args) == 3, Read: sh.read}, &reply); err != nil {
+		return err
+	}
+	text := fmt.Sprintf("posição %d, pontuação %d", reply.Rank, reply.Score)
+	if !reply.Found {
+		text = "(membro inexistente)"
+	}
+	sh.print(map[string]any{"op": "zrank", "sorted": args[0], "member": member, "found": reply.Found, "rank": reply.Rank, "score": reply.Score}, text)
+	return nil
+}
+
+func (sh *shell) streamAppendCmd(args []string) error {
+	if len(args) != 2 && !(len(args) == 3 && strings.HasPrefix(args[2], "maxlen=")) {
+		return errors.New("uso: xadd <stream> <valor> [maxlen=N]")
+	}
+	value, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("valor inválido %q", args[1])
+	}
+	appendArgs := remotelist.StreamAppendArgs{Key: args[0], Value: value}
+	if len(args) == 3 {
+		if appendArgs.MaxLen, err = strconv.Atoi(strings.TrimPrefix(args[2], "maxlen=")); err != nil || appendArgs.MaxLen <= 0 {
+			return fmt.Errorf("maxlen inválido %q", args[2])
+		}
+	}
+	var reply remotelist.StreamAppendReply
+	if err := sh.call("StreamAppend", appendArgs, &reply); err != nil {
+		return err
+	}
+	sh.read.Token = max(sh.read.Token, reply.Token)
+	sh.print(map[string]any{"op": "xadd", "stream": args[0], "id": reply.ID}, strconv.FormatUint(reply.ID, 10))
+	return nil
+}
+
+func (sh *shell) streamReadCmd(args []string) error {
+	if len(args) < 1 || len(args) > 3 {
+		return errors.New("uso: xread <stream> [de] [n]")
+	}
+	readArgs := remotelist.StreamReadArgs{Key: args[0], Read: sh.read}
+	if len(args) >= 2 {
+		from, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("ID inválido %q", args[1])
+		}
+		readArgs.From = from
+	}
+	if len(args) == 3 {
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("quantidade inválida %q", args[2])
+		}
+		readArgs.Count = n
+	}
+	var reply remotelist.StreamReadReply
+	if err := sh.call("StreamRead", readArgs, &reply); err != nil {
+		return err
+	}
+	sh.printEntries("xread", args[0], reply.Entries, map[string]any{"first": reply.First, "next": reply.Next},
+		fmt.Sprintf("(guardadas de %d a %d)", reply.First, reply.Next-1))
+	return nil
+}
+
+func (sh *shell) streamTrimCmd(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return errors.New("uso: xtrim <stream> [maxlen=N] [maxage=D]")
+	}
+	trimArgs := remotelist.StreamTrimArgs{Key: args[0]}
+	for _, opt := range args[1:] {
+		var err error
+		switch {
+		case strings.HasPrefix(opt, "maxlen="):
+			trimArgs.MaxLen, err = strconv.Atoi(strings.TrimPrefix(opt, "maxlen="))
+		case strings.HasPrefix(opt, "maxage="):
+			trimArgs.MaxAge, err = time.ParseDuration(strings.TrimPrefix(opt, "maxage="))
+		default:
+			err = errors.New("opção desconhecida")
+		}
+		if err != nil {
+			return fmt.Errorf("opção inválida %q: %v", opt, err)
+		}
+	}
+	var reply remotelist.StreamTrimReply
+	if err := sh.call("StreamTrim", trimArgs, &reply); err != nil {
+		return err
+	}
+	sh.read.Token = max(sh.read.Token, reply.Token)
+	sh.print(map[string]any{"op": "xtrim", "stream": args[0], "removed": reply.Removed}, fmt.Sprintf("%d removidas", reply.Removed))
+	return nil
+}
+
+func (sh *shell) streamGroupCmd(args []string) error {
+	if len(args) != 2 && len(args) != 3 {
+		return errors.New("uso: xgroup <stream> <grupo> [de|$]")
+	}
+	groupArgs := remotelist.StreamGroupCreateArgs{Key: args[0], Group: args[1]}
+	if len(args) == 3 {
+		if args[2] == "$" {
+			groupArgs.Latest = true
+		} else {
+			from, err := strconv.ParseUint(args[2], 10, 64)
+			if err != nil {
+				return fmt.Errorf("ID inválido %q", args[2])
+			}
+			groupArgs.From = from
+		}
+	}
+	var reply remotelist.StreamGroupCreateReply
+	if err := sh.call("StreamGroupCreate", groupArgs, &reply); err != nil {
+		return err
+	}
+	sh.read.Token = max(sh.read.Token, reply.Token)
+	sh.print(map[string]any{"op": "xgroup", "stream": args[0], "group": args[1]}, "ok")
+	return nil
+}
+
+func (sh *shell) streamReadGroupCmd(args []string) error {
+	if len(args) != 3 && len(args) != 4 {
+		return errors.New("uso: xreadgroup <stream> <grupo> <consumidor> [n]")
+	}
+	readArgs := remotelist.StreamReadGroupArgs{Key: args[0], Group: args[1], Consumer: args[2]}
+	if len(args) == 4 {
+		n, err := strconv.Atoi(args[3])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("quantidade inválida %q", args[3])
+		}
+		readArgs.Count = n
+	}
+	var reply remotelist.StreamReadGroupReply
+	if err := sh.call("StreamReadGroup", readArgs, &reply); err != nil {
+		return err
+	}
+	sh.read.Token = max(sh.read.Token, reply.Token)
+	sh.printEntries("xreadgroup", args[0], reply.Entries, map[string]any{"group": args[1], "consumer": args[2]}, "")
+	return nil
+}
+
+func (sh *shell) streamAckCmd(args []string) error {
+	if len(args) < 3 {
+		return errors.New("uso: xack <stream> <grupo> <id> [id...]")
+	}
+	ackArgs := remotelist.StreamAckArgs{Key: args[0], Group: args[1]}
+	for _, a := range args[2:] {
+		id, err := strconv.ParseUint(a, 10, 64)
+		if err != nil {
+			return fmt.Errorf("ID inválido %q", a)
+		}
+		ackArgs.IDs = append(ackArgs.IDs, id)
+	}
+	var reply remotelist.StreamAckReply
+	if err := sh.call("StreamAck", ackArgs, &reply); err != nil {
+		return err
+	}
+	sh.read.Token = max(sh.read.Token, reply.Token)
+	sh.print(map[string]any{"op": "xack", "stream": args[0], "group": args[1], "acked": reply.Acked}, fmt.Sprintf("%d confirmadas", reply.Acked))
+	return nil
+}
+
+func (sh *shell) streamClaimCmd(args []string) error {
+	if len(args) != 4 && len(args) != 5 {
+		return errors.New("uso: xclaim <stream> <grupo> <consumidor> <parada> [n]")
+	}
+	idle, err := time.ParseDuration(args[3])
+	if err != nil || idle < 0 {
+		return fmt.Errorf("duração inválida %q", args[3])
+	}
+	claimArgs := remotelist.StreamClaimArgs{Key: args[0], Group: args[1], Consumer: args[2], MinIdle: idle}
+	if len(args) == 5 {
+		n, err := strconv.Atoi(args[4])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("quantidade inválida %q", args[4])
+		}
+		claimArgs.Count = n
+	}
+	var reply remotelist.StreamClaimReply
+	if err := sh.call("StreamClaim", claimArgs, &reply); err != nil {
+		return err
+	}
+	sh.read.Token = max(sh.read.Token, reply.Token)
+	sh.printEntries("xclaim", args[0], reply.Entries, map[string]any{"group": args[1], "consumer": args[2]}, "")
+	return nil
+}
+
+func (sh *shell) streamPendingCmd(args []string) error {
+	if len(args) != 2 {
+		return errors.New("uso: xpending <stream> <grupo>")
+	}
+	var reply remotelist.StreamPendingReply
+	if err := sh.call("StreamPending", remotelist.StreamPendingArgs{Key: args[0], Group: args[1], Read: sh.read}, &reply); err != nil {
+		return err
+	}
+	pending := reply.Pending
+	if pending == nil {
+		pending = []remotelist.PendingEntry{}
+	}
+	lines := make([]string, 0, len(pending)+len(reply.Consumers)+1)
+	for _, p := range pending {
+		lines = append(lines, fmt.Sprintf("%d %s há %v (%d entregas)", p.ID, p.Consumer, time.Since(p.Delivered).Round(time.Millisecond), p.Deliveries))
+	}
+	names := make([]string, 0, len(reply.Consumers))
+	for name := range reply.Consumers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("consumidor %s: confirmado até %d", name, reply.Consumers[name]))
+	}
+	lines = append(lines, fmt.Sprintf("(entregue até %d, confirmado até %d)", reply.Delivered, reply.Committed))
+	sh.print(map[string]any{"op": "xpending", "stream": args[0], "group": args[1], "pending": pending,
+		"consumers": reply.Consumers, "delivered": reply.Delivered, "committed": reply.Committed}, strings.Join(lines, "\n"))
+	return nil
+}
+
+// printEntries mostra entradas de um stream, um "ID instante valor" por linha,
+// mais os campos extras e o rodapé (se houver).
+func (sh *shell) printEntries(op, key string, entries []remotelist.StreamEntry, extra map[string]any, footer string) {
+	if entries == nil {
+		entries = []remotelist.StreamEntry{}
+	}
+	lines := make([]string, 0, len(entries)+1)
+	for _, e := range entries {
+		lines = append(lines, fmt.Sprintf("%d %s %d", e.ID, e.Time.Format(time.RFC3339Nano), e.Value))
+	}
+	if len(entries) == 0 {
+		lines = append(lines, "(vazio)")
+	}
+	if footer != "" {
+		lines = append(lines, footer)
+	}
+	obj := map[string]any{"op": op, "stream": key, "entries": entries}
+	maps.Copy(obj, extra)
+	sh.print(obj, strings.Join(lines, "\n"))
+}
+
+func (sh *shell) typeCmd(args []string) error {
+	if len(args) != 1 {
+		return errors.New("uso: type <chave>")
+	}
+	var reply remotelist.KeyTypeReply
+	if err := sh.call("KeyType", remotelist.KeyTypeArgs{Key: args[0], Read: sh.read}, &reply); err != nil {
+		return err
+	}
+	text := reply.Type
+	if !reply.Exists {
+		text = "(chave inexistente)"
+	}
+	sh.print(map[string]any{"op": "type", "key": args[0], "exists": reply.Exists, "type": reply.Type}, text)
+	return nil
+}
+
+func (sh *shell) keysCmd(args []string) error {
+	if len(args) != 0 {
+		return errors.New("uso: keys")
+	}
+	var reply remotelist.KeysReply
+	if err := sh.call("Keys", remotelist.KeysArgs{Read: sh.read}, &reply); err != nil {
+		return err
+	}
+	keys := make([]map[string]any, 0, len(reply.Keys))
+	lines := make([]string, 0, len(reply.Keys))
+	for _, k := range reply.Keys {
+		keys = append(keys, map[string]any{"key": k.Key, "type": k.Type})
+		lines = append(lines, fmt.Sprintf("%-8s %s", k.Type, k.Key))
+	}
+	text := strings.Join(lines, "\n")
+	if len(lines) == 0 {
+		text = "(nenhuma chave)"
+	}
+	sh.print(map[string]any{"op": "keys", "keys": keys}, text)
+	return nil
+}
+
+func (sh *shell) statsCmd(args []string) error {
+	if len(args) < 1 {
+		return errors.New("uso: stats <lista> [even|odd] [min=N] [max=N]")
+	}
+	var view remotelist.ViewArgs
+	if err := parseViewOptions(args[1:], &view, false); err != nil {
+		return err
+	}
+	var reply remotelist.StatsReply
+	if err := sh.call("Stats", remotelist.StatsArgs{ListID: args[0], Filter: view.Filter, Read: sh.read}, &reply); err != nil {
+		return err
+	}
+	obj := map[string]any{"op": "stats", "list": args[0], "count": reply.Count}
+	if reply.Count == 0 {
+		sh.print(obj, "nenhum elemento")
+		return nil
+	}
+	obj["sum"], obj["min"], obj["max"], obj["mean"], obj["median"] = reply.Sum, reply.Min, reply.Max, reply.Mean, reply.Median
+	sum := fmt.Sprint(reply.Sum)
+	if reply.SumOverflow {
+		obj["sum"], obj["sum_overflow"] = nil, true
+		sum = "fora do int"
+	}
+	sh.print(obj, fmt.Sprintf("contagem %d, soma %s, mínimo %d, máximo %d, média %g, mediana %g",
+		reply.Count, sum, reply.Min, reply.Max, reply.Mean, reply.Median))
+	return nil
+}
+
+func (sh *shell) viewCmd(args []string) error {
+	if len(args) < 1 {
+		return errors.New("uso: view <lista> [asc|desc] [even|odd] [min=N] [max=N] [offset=N] [limit=N]")
+	}
+	view := remotelist.ViewArgs{ListID: args[0], Read: sh.read}
+	if err := parseViewOptions(args[1:], &view, true); err != nil {
+		return err
+	}
+	var reply remotelist.ViewReply
+	if err := sh.call("View", view, &reply); err != nil {
+		return err
+	}
+	if reply.Values == nil {
+		reply.Values = []int{}
+	}
+	text := fmt.Sprint(reply.Values)
+	if end := view.Offset + len(reply.Values); end < reply.Total {
+		text += fmt.Sprintf(" (%d de %d; continue com offset=%d)", len(reply.Values), reply.Total, end)
+	}
+	sh.print(map[string]any{"op": "view", "list": args[0], "values": reply.Values, "total": reply.Total}, text)
+	return nil
+}
+
+// parseViewOptions lê os filtros e, se page, a ordem e a página de view.
+func parseViewOptions(opts []string, view *remotelist.ViewArgs, page bool) error {
+	for _, opt := range opts {
+		key, value, hasValue := strings.Cut(opt, "=")
+		switch {
+		case !hasValue && (key == remotelist.ParityEven || key == remotelist.ParityOdd):
+			view.Filter.Parity = key
+		case !hasValue && page && (key == remotelist.OrderAsc || key == remotelist.OrderDesc):
+			view.Order = key
+		case hasValue && (key == "min" || key == "max" || (page && (key == "offset" || key == "limit"))):
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("valor inválido em %q", opt)
+			}
+			switch key {
+			case "min":
+				view.Filter.Min = &n
+			case "max":
+				view.Filter.Max = &n
+			case "offset":
+				view.Offset = n
+			case "limit":
+				view.Limit = n
+			}
+		default:
+			return fmt.Errorf("opção desconhecida %q", opt)
+		}
+	}
+	return nil
+}
+
+// watchCmd consulta a lista periodicamente e mostra o que mudou até o
+// usuário apertar Ctrl+C.
+func (sh *shell) watchCmd(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("uso: watch <lista> [intervalo, ex: 500ms]")
+	}
+	interval := time.Second
+	if len(args) == 2 {
+		d, err := time.ParseDuration(args[1])
+		if err != nil || d <= 0 {
+			return fmt.Errorf("intervalo inválido %q", args[1])
+		}
+		interval = d
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	defer signal.Stop(stop)
+	return sh.watch(args[0], interval, stop)
+}
+
+// watch compara o conteúdo da lista a cada intervalo com o da consulta
+// anterior, até stop. Comparar só o tamanho deixaria passar uma remoção
+// seguida de um append, ou um elemento trocado no lugar: aqui, depois do
+// trecho em comum, o que sumiu aparece como "-" e o que entrou como "+".
+func (sh *shell) watch(listID string, interval time.Duration, stop <-chan os.Signal) error {
+	last, err := sh.contents(listID)
+	if err != nil {
+		return err
+	}
+	if !sh.jsonOut {
+		fmt.Fprintf(sh.out, "acompanhando %s (%d elementos); Ctrl+C para parar\n", listID, len(last))
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+		values, err := sh.contents(listID)
+		if err != nil {
+			return err
+		}
+		common := 0
+		for common < len(last) && common < len(values) && last[common] == values[common] {
+			common++
+		}
+		for i := len(last) - 1; i >= common; i-- {
+			sh.print(map[string]any{"op": "watch", "list": listID, "event": "remove", "index": i, "value": last[i]},
+				fmt.Sprintf("- [%d] %d", i, last[i]))
+		}
+		for i := common; i < len(values); i++ {
+			sh.print(map[string]any{"op": "watch", "list": listID, "event": "append", "index": i, "value": values[i]},
+				fmt.Sprintf("+ [%d] %d", i, values[i]))
+		}
+		last = values
+	}
+}
+
+// contents lê a lista inteira, em páginas do maior tamanho que View aceita.
+// Uma lista que não existe (ou que expirou) está vazia, como em size.
+func (sh *shell) contents(listID string) ([]int, error) {
+	const pageSize = 10000
+	values := []int{}
+	for {
+		var reply remotelist.ViewReply
+		view := remotelist.ViewArgs{ListID: listID, Offset: len(values), Limit: pageSize, Read: sh.read}
+		if err := sh.call("View", view, &reply); err != nil {
+			if err.Error() == "lista não encontrada" {
+				return []int{}, nil
+			}
+			return nil, err
+		}
+		values = append(values, reply.Values...)
+		if len(reply.Values) == 0 || len(values) >= reply.Total {
+			return values, nil
+		}
+	}
+}
+
+func (sh *shell) replicationCmd(args []string) error {
+	if len(args) != 0 {
+		return errors.New("uso: replication")
+	}
+	var reply remotelist.ReplicationStatusReply
+	if err := sh.call("ReplicationStatus", remotelist.ReplicationStatusArgs{}, &reply); err != nil {
+		return err
+	}
+	role := "primário"
+	if reply.Backup {
+		role = "backup"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s, último registro %d", role, reply.LastSeq)
+	obj := map[string]any{"op": "replication", "role": role, "last_seq": reply.LastSeq}
+	if reply.Backup {
+		staleness := "desconhecido (nunca esteve em dia)"
+		if reply.Lag.Staleness >= 0 {
+			staleness = reply.Lag.Staleness.Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(&b, "\n  primário em %d, faltam %d registros, em dia há %s", reply.Lag.PrimarySeq, reply.Lag.Records, staleness)
+		obj["primary_seq"] = reply.Lag.PrimarySeq
+		obj["lag_records"] = reply.Lag.Records
+		obj["staleness_ms"] = int64(-1)
+		if reply.Lag.Staleness >= 0 {
+			obj["staleness_ms"] = reply.Lag.Staleness.Milliseconds()
+		}
+	}
+	backups := make([]map[string]any, 0, len(reply.Backups))
+	for _, st := range reply.Backups {
+		state := "desconectado"
+		if st.Connected {
+			state = "conectado"
+		}
+		fmt.Fprintf(&b, "\n  %s: %s, confirmou %d, atraso %d", st.Addr, state, st.AckedSeq, st.Lag)
+		backups = append(backups, map[string]any{"addr": st.Addr, "connected": st.Connected, "acked_seq": st.AckedSeq, "lag": st.Lag})
+	}
+	obj["backups"] = backups
+	sh.print(obj, b.String())
+	return nil
+}
+
+func (sh *shell) limitsCmd(args []string) error {
+	if len(args) != 0 {
+		return errors.New("uso: limits")
+	}
+	var reply remotelist.LimitStatsReply
+	if err := sh.call("LimitStats", remotelist.LimitStatsArgs{}, &reply); err != nil {
+		return err
+	}
+	if sh.jsonOut {
+		sh.print(map[string]any{"op": "limits", "elements": reply.Elements, "max_list": reply.MaxListElements,
+			"max_total": reply.MaxTotalElements, "rate": reply.RateLimit, "rate_burst": reply.RateBurst,
+			"clients": reply.Clients, "lists": reply.Lists}, "")
+		return nil
+	}
+	limit := func(v int) string {
+		if v == 0 {
+			return "sem limite"
+		}
+		return strconv.Itoa(v)
+	}
+	fmt.Fprintf(sh.out, "elementos: %d (máximo %s; por lista %s)\n", reply.Elements, limit(reply.MaxTotalElements), limit(reply.MaxListElements))
+	if reply.RateLimit > 0 {
+		fmt.Fprintf(sh.out, "pedidos por conexão: %g/s, rajada %d\n", reply.RateLimit, reply.RateBurst)
+	} else {
+		fmt.Fprintln(sh.out, "pedidos por conexão: sem limite")
+	}
+	for _, group := range []struct {
+		title    string
+		counters map[string]remotelist.LimitCounters
+	}{{"clientes", reply.Clients}, {"listas", reply.Lists}} {
+		if len(group.counters) == 0 {
+			continue
+		}
+		keys := make([]string, 0, len(group.counters))
+		for k := range group.counters {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(sh.out, "recusas por %s:\n", strings.TrimSuffix(group.title, "s"))
+		for _, k := range keys {
+			c := group.counters[k]
+			fmt.Fprintf(sh.out, "  %-24s %s=%d %s=%d %s=%d\n", k,
+				remotelist.CodeListFull, c.ListFull, remotelist.CodeStoreFull, c.StoreFull, remotelist.CodeRateLimited, c.RateLimited)
+		}
+	}
+	return nil
+}
+
+func (sh *shell) connsCmd(args []string) error {
+	if len(args) != 0 {
+		return errors.New("uso: conns")
+	}
+	var reply remotelist.ConnectionsReply
+	if err := sh.call("Conns.Connections", remotelist.ConnectionsArgs{}, &reply); err != nil {
+		return err
+	}
+	if sh.jsonOut {
+		sh.print(map[string]any{"op": "conns", "conns": reply.Conns, "max_conns": reply.MaxConns,
+			"idle_timeout": reply.IdleTimeout.String(), "read_timeout": reply.ReadTimeout.String(),
+			"accepted": reply.Accepted, "waited": reply.Waited, "idle_closed": reply.IdleClosed,
+			"read_timeouts": reply.ReadTimeouts, "accept_errors": reply.AcceptErrors}, "")
+		return nil
+	}
+	limit := "sem limite"
+	if reply.MaxConns > 0 {
+		limit = strconv.Itoa(reply.MaxConns)
+	}
+	fmt.Fprintf(sh.out, "%d conexões abertas (máximo %s), %d aceitas desde o início\n", len(reply.Conns), limit, reply.Accepted)
+	fmt.Fprintf(sh.out, "fechadas por ociosidade: %d, por timeout: %d; esperas no limite: %d; erros no accept: %d\n",
+		reply.IdleClosed, reply.ReadTimeouts, reply.Waited, reply.AcceptErrors)
+	now := time.Now()
+	for _, c := range reply.Conns {
+		fmt.Fprintf(sh.out, "  #%-4d %-22s %-10s aberta há %v, sem uso há %v, %d pedidos, %d pendentes, último %s\n",
+			c.ID, c.Remote, c.State, now.Sub(c.Opened).Round(time.Second), now.Sub(c.LastUsed).Round(time.Second),
+			c.Requests, c.Pending, c.Method)
+	}
+	return nil
+}
+
+func (sh *shell) promoteCmd(args []string) error {
+	var reply remotelist.PromoteReply
+	if err := sh.call("Promote", remotelist.PromoteArgs{Backups: args}, &reply); err != nil {
+		return err
+	}
+	sh.print(map[string]any{"op": "promote", "last_seq": reply.LastSeq, "backups": args},
+		fmt.Sprintf("promovido a primário no registro %d", reply.LastSeq))
+	return nil
+}
+
+func (sh *shell) consistencyCmd(args []string) error {
+	usage := errors.New("uso: consistency eventual|strong|session|bounded <atraso máximo, ex: 2s>")
+	if len(args) == 0 || len(args) > 2 || (len(args) == 2) != (args[0] == "bounded") {
+		return usage
+	}
+	read := remotelist.ReadOptions{Token: sh.read.Token}
+	switch args[0] {
+	case "eventual":
+		read.Consistency = remotelist.Eventual
+	case "strong":
+		read.Consistency = remotelist.Strong
+	case "session":
+		read.Consistency = remotelist.ReadYourWrites
+	case "bounded":
+		d, err := time.ParseDuration(args[1])
+		if err != nil || d < 0 {
+			return fmt.Errorf("atraso inválido %q", args[1])
+		}
+		read.Consistency, read.MaxLag = remotelist.BoundedStaleness, d
+	default:
+		return usage
+	}
+	sh.read = read
+	sh.print(map[string]any{"op": "consistency", "level": read.Consistency.String(), "max_lag_ms": read.MaxLag.Milliseconds()}, "ok")
+	return nil
+}
+
+func (sh *shell) formatCmd(args []string) error {
+	if len(args) != 1 || (args[0] != "text" && args[0] != "json") {
+		return errors.New("uso: format text|json")
+	}
+	sh.jsonOut = args[0] == "json"
+	return nil
+}
@@ -0,0 +1,98 @@
+// remotelist-shell é um cliente interativo (REPL) para um servidor RemoteList.
+//
+// Uso:
+//
+//	go run ./cmd/remotelist-shell -addr localhost:5000
+//	echo "size minhaLista" | go run ./cmd/remotelist-shell -json
+//
+// Digite "help" para ver os comandos. Tab completa comandos e nomes de listas;
+// as setas percorrem o histórico, que é salvo entre execuções.
+//
+// main só lê as flags e cuida do terminal; a sessão (conexão, leitura e
+// despacho dos comandos) está em shell.go e os comandos em commands.go.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/peterh/liner"
+)
+
+func main() {
+	defaultAddr := os.Getenv("REMOTELIST_ADDR")
+	if defaultAddr == "" {
+		defaultAddr = "localhost:5000"
+	}
+	addr := flag.String("addr", defaultAddr, "endereço do servidor (ou variável REMOTELIST_ADDR)")
+	jsonOut := flag.Bool("json", false, "saída em JSON, uma linha por comando")
+	historyFile := flag.String("history", defaultHistoryFile(), "arquivo de histórico (vazio desliga)")
+	flag.Parse()
+
+	sh := &shell{addr: *addr, jsonOut: *jsonOut, out: os.Stdout}
+	if err := sh.connect(); err != nil {
+		fmt.Fprintf(os.Stderr, "não foi possível conectar em %s: %v\n", *addr, err)
+		os.Exit(1)
+	}
+	defer sh.client.Close()
+
+	// Com a entrada vinda de um pipe ou arquivo não há prompt nem histórico:
+	// cada linha é um comando, o que facilita usar o shell em scripts.
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice == 0 {
+		if err := sh.script(os.Stdin); err != nil {
+			fmt.Fprintf(os.Stderr, "erro ao ler a entrada: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	line := liner.NewLiner()
+	defer line.Close()
+	line.SetCtrlCAborts(true)
+	line.SetCompleter(sh.complete)
+
+	if *historyFile != "" {
+		if f, err := os.Open(*historyFile); err == nil {
+			line.ReadHistory(f)
+			f.Close()
+		}
+	}
+
+	fmt.Fprintf(sh.out, "Conectado a %s. Digite \"help\" para ver os comandos.\n", sh.addr)
+
+	for {
+		input, err := line.Prompt("remotelist> ")
+		if err == liner.ErrPromptAborted {
+			continue
+		}
+		if err != nil { // io.EOF (Ctrl+D ou fim da entrada)
+			break
+		}
+		input = strings.TrimSpace(input)
+		if input == "" {
+			continue
+		}
+		line.AppendHistory(input)
+		if sh.exec(input) {
+			break
+		}
+	}
+
+	if *historyFile != "" {
+		if f, err := os.Create(*historyFile); err == nil {
+			line.WriteHistory(f)
+			f.Close()
+		}
+	}
+}
+
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".remotelist_history")
+}
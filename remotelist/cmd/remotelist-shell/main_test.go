@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/rpc"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"remotelist/pkg"
+)
+
+// Os comandos do shell contra um servidor RemoteList neste processo, com o
+// disco em memória.
+
+// syncBuffer é a saída do shell, lida pelo teste enquanto watch escreve.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// newShell sobe um servidor e devolve um shell conectado a ele.
+func newShell(t *testing.T, jsonOut bool) (*shell, *syncBuffer) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	mem := remotelist.NewMemFS()
+	mem.MkdirAll("data", 0755)
+	rl, err := remotelist.NewRemoteListWithConfig(remotelist.Config{Dir: "data", FS: mem, SnapshotInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rl.Close() })
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("RemoteList", rl); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go srv.Accept(l)
+
+	out := &syncBuffer{}
+	sh := &shell{addr: l.Addr().String(), jsonOut: jsonOut, out: out}
+	if err := sh.connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sh.client.Close() })
+	return sh, out
+}
+
+// Uma linha por comando; comentários e linhas vazias são pulados e exit
+// encerra a leitura.
+func TestScript(t *testing.T) {
+	sh, out := newShell(t, false)
+	input := "# um comentário\n" +
+		"append l 1 2 3\n" +
+		"\n" +
+		"  size l  \n" +
+		"remove l\n" +
+		"view l desc\n" +
+		"get l\n" +
+		"append l x\n" +
+		"view l nada\n" +
+		"voar\n" +
+		"exit\n" +
+		"append l 4\n"
+	if err := sh.script(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	want := "ok (3 valores)\n" +
+		"3\n" +
+		"3\n" +
+		"[2 1]\n" +
+		"erro: uso: get <lista> <índice>\n" +
+		"erro: valor inválido \"x\"\n" +
+		"erro: opção desconhecida \"nada\"\n" +
+		"erro: comando desconhecido \"voar\" (digite help)\n"
+	if got := out.String(); got != want {
+		t.Fatalf("saída:\n%s\nesperado:\n%s", got, want)
+	}
+	if sh.exec("size l"); !strings.HasSuffix(out.String(), "\n2\n") {
+		t.Fatalf("o append depois do exit foi executado:\n%s", out)
+	}
+	for _, line := range []string{"exit", "  quit "} {
+		if !sh.exec(line) {
+			t.Errorf("exec(%q) não pediu para sair", line)
+		}
+	}
+}
+
+// Com -json cada comando vira um objeto numa linha, inclusive os erros.
+func TestJSON(t *testing.T) {
+	sh, out := newShell(t, true)
+	if err := sh.script(strings.NewReader("append l 7\nget l 0\nget l 5\n")); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("%d linhas, esperado 3:\n%s", len(lines), out)
+	}
+	var got []map[string]any
+	for _, line := range lines {
+		var obj map[string]any
+		if err := json.Unmarshal([]byte(line), &obj); err != nil {
+			t.Fatalf("linha %q: %v", line, err)
+		}
+		got = append(got, obj)
+	}
+	if got[0]["op"] != "append" || got[0]["ok"] != true {
+		t.Errorf("append: %v", got[0])
+	}
+	if got[1]["op"] != "get" || got[1]["value"] != 7.0 {
+		t.Errorf("get: %v", got[1])
+	}
+	if got[2]["op"] != "get" || got[2]["error"] == nil {
+		t.Errorf("get fora da lista: %v", got[2])
+	}
+}
+
+// watch compara o conteúdo: uma remoção seguida de um append, com o tamanho
+// igual ao de antes, aparece como as duas coisas.
+func TestWatch(t *testing.T) {
+	sh, out := newShell(t, false)
+	if err := sh.call("Append", remotelist.AppendArgs{ListID: "l", Value: 1}, &remotelist.AppendReply{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// O watch usa a sua própria conexão, como um segundo terminal.
+	watcher := &shell{addr: sh.addr, out: out}
+	if err := watcher.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.client.Close()
+	stop := make(chan os.Signal)
+	done := make(chan error, 1)
+	go func() { done <- watcher.watch("l", 10*time.Millisecond, stop) }()
+
+	wait := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !strings.Contains(out.String(), want) {
+			if time.Now().After(deadline) {
+				t.Fatalf("watch sem %q:\n%s", want, out)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	wait("acompanhando l (1 elementos)")
+	sh.exec("append l 2")
+	wait("+ [1] 2\n")
+	sh.exec("remove l")
+	sh.exec("append l 3")
+	wait("+ [1] 3\n")
+	sh.exec("remove l")
+	sh.exec("remove l")
+	wait("- [0] 1\n")
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") {
+			events = append(events, line)
+		}
+	}
+	want := []string{"+ [1] 2", "- [1] 2", "+ [1] 3", "- [1] 3", "- [0] 1"}
+	if strings.Join(events, "|") != strings.Join(want, "|") {
+		t.Fatalf("eventos %q, esperado %q", events, want)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"strings"
+
+	"remotelist/pkg"
+)
+
+var commands = []string{"append", "get", "getat", "insertat", "remove", "size", "lists", "stats", "view", "expire", "persist", "ttl", "schedule", "unschedule", "scheduled", "sadd", "srem", "scontains", "smembers", "hset", "hget", "hdel", "incr", "decr", "counter", "zadd", "zpopmin", "zpopmax", "zrange", "zrank", "xadd", "xread", "xtrim", "xgroup", "xreadgroup", "xack", "xclaim", "xpending", "type", "keys", "watch", "replication", "limits", "conns", "promote", "consistency", "format", "help", "exit"}
+
+const helpText = `comandos:
+  append <lista> <valor> [valor...]   adiciona valores ao final da lista
+  get <lista> <índice>                lê um elemento
+  getat <lista> <índice> <quando>     lê um elemento no passado (RFC3339 ou número de sequência)
+  insertat <lista> <índice> <valor>   insere um valor na posição (só no remotelist-crdt)
+  remove <lista>                      remove e mostra o último elemento
+  size <lista>                        mostra o tamanho da lista
+  lists                               mostra todas as listas
+  stats <lista> [filtro...]           contagem, soma, mínimo, máximo, média e mediana
+  view <lista> [asc|desc] [filtro...] [offset=N] [limit=N]
+                                      uma página da lista, ordenada ou não (a lista não muda)
+                                      filtros: even, odd, min=N, max=N
+  expire <lista> <duração>            apaga a lista depois da duração (ex: 30s, 5m)
+  persist <lista>                     tira o prazo da lista
+  ttl <lista>                         mostra quanto falta para a lista expirar
+  schedule <lista> <valor> <quando>   agenda o valor para entrar na lista depois de uma
+                                      duração (ex: 30s) ou num instante (RFC3339)
+  unschedule <lista> <id>             cancela um item agendado
+  scheduled <lista>                   mostra os itens agendados, em ordem de disparo
+  sadd|srem <set> <membro>            coloca ou tira um membro do set
+  scontains <set> <membro>            diz se o membro está no set
+  smembers <set>                      mostra os membros do set, em ordem
+  hset <map> <campo> <valor>          grava um campo do map
+  hget|hdel <map> <campo>             lê ou apaga um campo do map
+  incr|decr <contador> [quanto]       soma ou subtrai (1, se omitido) e mostra o valor
+  counter <contador>                  mostra o valor do contador
+  zadd <sorted> <membro> <pontuação>  coloca o membro (ou muda a pontuação dele)
+  zpopmin|zpopmax <sorted> [n]        tira os n (ou 1) membros de menor ou maior pontuação
+  zrange <sorted> [asc|desc] [min=N] [max=N] [offset=N] [limit=N]
+                                      uma página dos membros, por pontuação
+  zrank <sorted> <membro> [desc]      posição (a partir de 0) e pontuação do membro
+  xadd <stream> <valor> [maxlen=N]    acrescenta uma entrada e mostra o ID dela
+  xread <stream> [de] [n]             lê até n entradas a partir do ID 'de'
+  xtrim <stream> [maxlen=N] [maxage=D]
+                                      corta as entradas mais antigas
+  xgroup <stream> <grupo> [de|$]      cria um grupo que recebe a partir do ID 'de' ($ = só as novas)
+  xreadgroup <stream> <grupo> <consumidor> [n]
+                                      entrega ao consumidor as próximas entradas do grupo
+  xack <stream> <grupo> <id> [id...]  confirma entradas pendentes
+  xclaim <stream> <grupo> <consumidor> <parada> [n]
+                                      toma as pendentes entregues há pelo menos 'parada'
+  xpending <stream> <grupo>           mostra as pendentes e os offsets confirmados
+  type <chave>                        mostra o tipo da chave: list, set, map, counter, sorted ou stream
+  keys                                mostra todas as chaves, com o tipo
+  watch <lista> [intervalo]           mostra o que entra e sai da lista (Ctrl+C para parar)
+  replication                         mostra o papel do servidor e o atraso dos backups
+  limits                              mostra os limites e quem já foi recusado por eles
+  conns                               mostra as conexões abertas no servidor
+  promote [backup...]                 transforma o backup em primário (pare o primário antes)
+  consistency <nível> [atraso]        nível das leituras em réplicas: eventual, strong,
+                                      bounded <atraso máximo> ou session (lê as próprias escritas)
+  format text|json                    muda o formato da saída
+  help                                mostra esta ajuda
+  exit                                sai`
+
+// shell guarda a conexão e as preferências da sessão.
+type shell struct {
+	addr    string
+	client  *rpc.Client
+	jsonOut bool
+	out     io.Writer
+	read    remotelist.ReadOptions // Nível das leituras; Token acompanha as escritas da sessão
+}
+
+func (sh *shell) connect() error {
+	client, err := rpc.Dial("tcp", sh.addr)
+	if err != nil {
+		return err
+	}
+	sh.client = client
+	return nil
+}
+
+// call faz a chamada RPC, reconectando uma vez se a conexão tiver caído. Um
+// método sem serviço ("Append") é do serviço RemoteList.
+func (sh *shell) call(method string, args, reply any) error {
+	if !strings.Contains(method, ".") {
+		method = "RemoteList." + method
+	}
+	err := sh.client.Call(method, args, reply)
+	if err == rpc.ErrShutdown || errors.Is(err, io.ErrUnexpectedEOF) {
+		sh.client.Close()
+		if cerr := sh.connect(); cerr != nil {
+			return fmt.Errorf("conexão perdida: %w", cerr)
+		}
+		err = sh.client.Call(method, args, reply)
+	}
+	return err
+}
+
+// complete sugere comandos na primeira palavra e nomes de chaves na segunda.
+func (sh *shell) complete(line string) []string {
+	fields := strings.Fields(line)
+	endsWithSpace := strings.HasSuffix(line, " ")
+
+	if len(fields) == 0 || (len(fields) == 1 && !endsWithSpace) {
+		prefix := ""
+		if len(fields) == 1 {
+			prefix = fields[0]
+		}
+		var out []string
+		for _, c := range commands {
+			if strings.HasPrefix(c, prefix) {
+				out = append(out, c+" ")
+			}
+		}
+		return out
+	}
+
+	if !((len(fields) == 1 && endsWithSpace) || (len(fields) == 2 && !endsWithSpace)) {
+		return nil
+	}
+	prefix := ""
+	if len(fields) == 2 {
+		prefix = fields[1]
+	}
+	var reply remotelist.KeysReply
+	if err := sh.call("Keys", remotelist.KeysArgs{}, &reply); err != nil {
+		return nil
+	}
+	var out []string
+	for _, k := range reply.Keys {
+		if strings.HasPrefix(k.Key, prefix) {
+			out = append(out, fields[0]+" "+k.Key+" ")
+		}
+	}
+	return out
+}
+
+// script executa um comando por linha de r até o fim da entrada ou até um
+// exit.
+func (sh *shell) script(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if sh.exec(scanner.Text()) {
+			break
+		}
+	}
+	return scanner.Err()
+}
+
+// exec executa uma linha digitada. Linhas vazias e comentários (#) são
+// ignorados; devolve true se a linha pede para sair.
+func (sh *shell) exec(input string) (quit bool) {
+	input = strings.TrimSpace(input)
+	switch {
+	case input == "" || strings.HasPrefix(input, "#"):
+		return false
+	case input == "exit" || input == "quit":
+		return true
+	}
+	sh.run(strings.Fields(input))
+	return false
+}
+
+// run executa um comando já separado em palavras.
+func (sh *shell) run(fields []string) {
+	cmd, args := fields[0], fields[1:]
+	var err error
+	switch cmd {
+	case "append":
+		err = sh.appendCmd(args)
+	case "get":
+		err = sh.getCmd(args)
+	case "getat":
+		err = sh.getAtCmd(args)
+	case "insertat":
+		err = sh.insertAtCmd(args)
+	case "remove":
+		err = sh.removeCmd(args)
+	case "size":
+		err = sh.sizeCmd(args)
+	case "lists":
+		err = sh.listsCmd(args)
+	case "stats":
+		err = sh.statsCmd(args)
+	case "view":
+		err = sh.viewCmd(args)
+	case "expire":
+		err = sh.expireCmd(args)
+	case "persist":
+		err = sh.persistCmd(args)
+	case "ttl":
+		err = sh.ttlCmd(args)
+	case "schedule":
+		err = sh.scheduleCmd(args)
+	case "unschedule":
+		err = sh.unscheduleCmd(args)
+	case "scheduled":
+		err = sh.scheduledCmd(args)
+	case "sadd", "srem":
+		err = sh.setWriteCmd(cmd, args)
+	case "scontains":
+		err = sh.setContainsCmd(args)
+	case "smembers":
+		err = sh.setMembersCmd(args)
+	case "hset":
+		err = sh.mapSetCmd(args)
+	case "hget":
+		err = sh.mapGetCmd(args)
+	case "hdel":
+		err = sh.mapDeleteCmd(args)
+	case "incr", "decr":
+		err = sh.counterCmd(cmd, args)
+	case "counter":
+		err = sh.counterGetCmd(args)
+	case "zadd":
+		err = sh.sortedAddCmd(args)
+	case "zpopmin", "zpopmax":
+		err = sh.sortedPopCmd(cmd, args)
+	case "zrange":
+		err = sh.sortedRangeCmd(args)
+	case "zrank":
+		err = sh.sortedRankCmd(args)
+	case "xadd":
+		err = sh.streamAppendCmd(args)
+	case "xread":
+		err = sh.streamReadCmd(args)
+	case "xtrim":
+		err = sh.streamTrimCmd(args)
+	case "xgroup":
+		err = sh.streamGroupCmd(args)
+	case "xreadgroup":
+		err = sh.streamReadGroupCmd(args)
+	case "xack":
+		err = sh.streamAckCmd(args)
+	case "xclaim":
+		err = sh.streamClaimCmd(args)
+	case "xpending":
+		err = sh.streamPendingCmd(args)
+	case "type":
+		err = sh.typeCmd(args)
+	case "keys":
+		err = sh.keysCmd(args)
+	case "watch":
+		err = sh.watchCmd(args)
+	case "replication":
+		err = sh.replicationCmd(args)
+	case "limits":
+		err = sh.limitsCmd(args)
+	case "conns":
+		err = sh.connsCmd(args)
+	case "promote":
+		err = sh.promoteCmd(args)
+	case "consistency":
+		err = sh.consistencyCmd(args)
+	case "format":
+		err = sh.formatCmd(args)
+	case "help":
+		fmt.Fprintln(sh.out, helpText)
+	default:
+		err = fmt.Errorf("comando desconhecido %q (digite help)", cmd)
+	}
+	if err != nil {
+		sh.print(map[string]any{"op": cmd, "error": err.Error()}, "erro: "+err.Error())
+	}
+}
+
+// print mostra o resultado no formato escolhido.
+func (sh *shell) print(obj map[string]any, text string) {
+	if sh.jsonOut {
+		enc := json.NewEncoder(sh.out)
+		enc.SetEscapeHTML(false)
+		enc.Encode(obj)
+		return
+	}
+	fmt.Fprintln(sh.out, text)
+}
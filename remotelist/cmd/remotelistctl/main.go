@@ -0,0 +1,371 @@
+// remotelistctl inspeciona e repara, offline, os arquivos de dados do RemoteList
+// (remotelist.json e os segmentos remotelist.log.NNNNNN).
+// O servidor não deve estar rodando sobre o mesmo diretório durante 'compact'.
+//
+// Uso:
+//
+//	remotelistctl dump    [-dir D]
+//	remotelistctl verify  [-dir D]
+//	remotelistctl compact [-dir D] [-archive A] [-compress gzip|zstd]
+//	remotelistctl export  [-dir D] -list ID [-json]
+//	remotelistctl diff    snapshotA.json snapshotB.json
+//
+// Arquivos cifrados são lidos com a chave de REMOTELIST_KEY_FILE ou
+// REMOTELIST_KEY (e as antigas de REMOTELIST_OLD_KEYS). 'compact' grava o
+// snapshot novo com a chave atual, o que também serve para trocar a chave
+// com o servidor parado.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"remotelist/pkg"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executa o comando de args e devolve o código de saída: 0, 1 se o
+// comando falhou e 2 se os argumentos estão errados.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) < 1 {
+		return usage(stderr)
+	}
+	// As mensagens de log do carregamento (as mesmas do servidor) poluiriam a saída;
+	// os problemas encontrados são reportados pelos próprios comandos.
+	log.SetOutput(io.Discard)
+
+	c := &ctl{out: stdout, errOut: stderr}
+	cmd, args := args[0], args[1:]
+	var err error
+	switch cmd {
+	case "dump":
+		err = c.dump(args)
+	case "verify":
+		err = c.verify(args)
+	case "compact":
+		err = c.compact(args)
+	case "export":
+		err = c.export(args)
+	case "diff":
+		err = c.diff(args)
+	default:
+		return usage(stderr)
+	}
+	var bad usageError
+	if errors.As(err, &bad) {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "erro:", err)
+		return 1
+	}
+	return 0
+}
+
+func usage(w io.Writer) int {
+	fmt.Fprintln(w, `uso: remotelistctl <comando> [opções]
+
+comandos:
+  dump     mostra o snapshot e todos os registros do log
+  verify   aplica o log sobre o snapshot e aponta linhas inválidas
+  compact  grava um snapshot novo com todo o log aplicado e descarta os segmentos
+  export   imprime uma única lista
+  diff     compara dois arquivos de snapshot`)
+	return 2
+}
+
+// ctl guarda as saídas dos comandos.
+type ctl struct {
+	out, errOut io.Writer
+}
+
+// usageError é um erro nos argumentos, já explicado em errOut pelo pacote flag.
+type usageError struct{ error }
+
+// parse interpreta as opções de um comando; os erros vão para errOut.
+func (c *ctl) parse(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(c.errOut)
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	return nil
+}
+
+// dump imprime o cabeçalho e as listas do snapshot, seguidos dos registros do log.
+func (c *ctl) dump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	dir := fs.String("dir", ".", "diretório de dados")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+
+	st, err := remotelist.LoadOffline(*dir)
+	if err != nil {
+		return err
+	}
+
+	if st.HasSnapshot {
+		fmt.Fprintf(c.out, "== snapshot (próximo segmento %d, último registro %d, criado em %s)\n",
+			st.Snapshot.NextSegment, st.Snapshot.LastSeq, formatTime(st.Snapshot.CreatedAt))
+		if st.Snapshot.Version >= 2 {
+			compression := st.Snapshot.Compression
+			if compression == "" {
+				compression = "nenhuma"
+			}
+			fmt.Fprintf(c.out, "== formato %d, compressão %s, %s\n", st.Snapshot.Version, compression, st.Snapshot.Checksum)
+		} else {
+			fmt.Fprintf(c.out, "== formato %d (antigo, sem checksum)\n", st.Snapshot.Version)
+		}
+		for _, id := range sortedIDs(st.SnapshotLists) {
+			fmt.Fprintf(c.out, "%s %v\n", id, st.SnapshotLists[id])
+		}
+	} else {
+		fmt.Fprintln(c.out, "== sem snapshot")
+	}
+
+	for _, path := range st.Segments {
+		fmt.Fprintf(c.out, "== %s\n", path)
+		err := remotelist.ReadLogFile(path, func(lineNo int, line string, rec remotelist.LogRecord, err error) error {
+			if err != nil {
+				fmt.Fprintf(c.out, "%6d  INVÁLIDA (%v): %s\n", lineNo, err, line)
+				return nil
+			}
+			fmt.Fprintf(c.out, "%6d  seq=%-8d %s  %s %s", lineNo, rec.Seq, formatTime(rec.Time), rec.Op, rec.ListID)
+			switch rec.Op {
+			case "APPEND", "FIRE", "SADD", "SREM", "INCR", "ZPOPMIN", "ZPOPMAX", "XADD":
+				fmt.Fprintf(c.out, " %d", rec.Value)
+			case "DELAY":
+				fmt.Fprintf(c.out, " %d para %s", rec.Value, formatTime(rec.Due))
+			case "UNDELAY":
+				fmt.Fprintf(c.out, " %d", rec.Target)
+			case "ZADD":
+				fmt.Fprintf(c.out, " %d %d", rec.Value, rec.Score)
+			case "XTRIM":
+				fmt.Fprintf(c.out, " a partir de %d", rec.Target)
+			case "XGROUP":
+				fmt.Fprintf(c.out, " %s depois de %d", rec.Field, rec.Target)
+			case "XREADGROUP":
+				fmt.Fprintf(c.out, " %s %s %d", rec.Field, rec.Consumer, rec.Value)
+			case "XACK", "XCLAIM":
+				fmt.Fprintf(c.out, " %s %s %v", rec.Field, rec.Consumer, rec.Values)
+			case "HSET":
+				fmt.Fprintf(c.out, " %s %d", rec.Field, rec.Value)
+			case "HDEL":
+				fmt.Fprintf(c.out, " %s", rec.Field)
+			case "REPLACE":
+				fmt.Fprintf(c.out, " (%d valores)", len(rec.Values))
+			case "EXPIRE":
+				fmt.Fprintf(c.out, " até %s", formatTime(rec.Expires))
+			case "RESTORE":
+				fmt.Fprintf(c.out, " (%s)", restoredKind(rec))
+			case "ABORT":
+				fmt.Fprintf(c.out, "%d", rec.Target)
+			case "PREPARE", "COMMIT", "ROLLBACK":
+				fmt.Fprintf(c.out, "%s", rec.TxID)
+				for _, op := range rec.TxOps {
+					fmt.Fprintf(c.out, " %s %s", op.Op, op.ListID)
+					if op.Op == "APPEND" {
+						fmt.Fprintf(c.out, " %d", op.Value)
+					}
+				}
+			}
+			fmt.Fprintln(c.out)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verify faz o replay completo e lista as linhas que o servidor pularia.
+func (c *ctl) verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	dir := fs.String("dir", ".", "diretório de dados")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+
+	st, err := remotelist.LoadOffline(*dir)
+	if err != nil {
+		return err
+	}
+	for _, p := range st.Problems {
+		fmt.Fprintf(c.out, "segmento %d, linha %d: %v: %s\n", p.Segment, p.Line, p.Err, p.Text)
+	}
+	for _, tx := range st.Prepared {
+		fmt.Fprintf(c.out, "transação %s preparada e ainda não decidida (%d operações)\n", tx.ID, len(tx.Ops))
+	}
+	fmt.Fprintf(c.out, "%d listas, %d arquivos de log, último registro %d, %d problemas\n",
+		len(st.Lists), len(st.Segments), st.LastSeq, len(st.Problems))
+	if len(st.Problems) > 0 {
+		return fmt.Errorf("o log tem %d linhas que não podem ser aplicadas", len(st.Problems))
+	}
+	return nil
+}
+
+// compact transforma o estado atual (snapshot + log) em um snapshot novo.
+func (c *ctl) compact(args []string) error {
+	fs := flag.NewFlagSet("compact", flag.ContinueOnError)
+	dir := fs.String("dir", ".", "diretório de dados")
+	archive := fs.String("archive", "", "move os segmentos compactados para este diretório em vez de apagá-los")
+	compress := fs.String("compress", "", "compressão do snapshot novo: gzip, zstd ou vazio (nenhuma)")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+
+	st, err := remotelist.CompactOffline(*dir, *archive, *compress)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "snapshot gravado com %d listas até o registro %d; %d arquivos de log compactados\n",
+		len(st.Lists), st.LastSeq, len(st.Segments))
+	if len(st.Problems) > 0 {
+		fmt.Fprintf(c.out, "atenção: %d linhas inválidas foram descartadas (use 'verify' antes para vê-las)\n", len(st.Problems))
+	}
+	return nil
+}
+
+// export imprime uma lista, um valor por linha ou como array JSON.
+func (c *ctl) export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	dir := fs.String("dir", ".", "diretório de dados")
+	listID := fs.String("list", "", "lista a exportar")
+	asJSON := fs.Bool("json", false, "imprime como array JSON")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if *listID == "" {
+		return fmt.Errorf("informe -list")
+	}
+
+	st, err := remotelist.LoadOffline(*dir)
+	if err != nil {
+		return err
+	}
+	data, ok := st.Lists[*listID]
+	if !ok {
+		return fmt.Errorf("lista %q não encontrada", *listID)
+	}
+	if *asJSON {
+		return json.NewEncoder(c.out).Encode(data)
+	}
+	for _, v := range data {
+		fmt.Fprintln(c.out, v)
+	}
+	return nil
+}
+
+// diff compara as listas de dois snapshots.
+func (c *ctl) diff(args []string) error {
+	if len(args) != 2 {
+		fmt.Fprintln(c.errOut, "uso: remotelistctl diff snapshotA.json snapshotB.json")
+		return usageError{errors.New("diff precisa de dois arquivos")}
+	}
+	_, a, err := remotelist.ReadSnapshotFile(args[0])
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	_, b, err := remotelist.ReadSnapshotFile(args[1])
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[1], err)
+	}
+
+	ids := make(map[string]bool)
+	for id := range a {
+		ids[id] = true
+	}
+	for id := range b {
+		ids[id] = true
+	}
+	all := make([]string, 0, len(ids))
+	for id := range ids {
+		all = append(all, id)
+	}
+	sort.Strings(all)
+
+	differences := 0
+	for _, id := range all {
+		la, inA := a[id]
+		lb, inB := b[id]
+		switch {
+		case !inA:
+			fmt.Fprintf(c.out, "+ %s (só no segundo, %d elementos)\n", id, len(lb))
+		case !inB:
+			fmt.Fprintf(c.out, "- %s (só no primeiro, %d elementos)\n", id, len(la))
+		default:
+			i := firstDifference(la, lb)
+			if i < 0 {
+				continue
+			}
+			fmt.Fprintf(c.out, "~ %s: %d x %d elementos, primeira diferença no índice %d\n", id, len(la), len(lb), i)
+		}
+		differences++
+	}
+	if differences == 0 {
+		fmt.Fprintln(c.out, "snapshots iguais")
+	}
+	return nil
+}
+
+// firstDifference devolve o primeiro índice em que as listas diferem, ou -1.
+func firstDifference(a, b []int) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	if len(a) != len(b) {
+		return n
+	}
+	return -1
+}
+
+func sortedIDs(lists map[string][]int) []string {
+	ids := make([]string, 0, len(lists))
+	for id := range lists {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// restoredKind diz o tipo da chave gravada num registro RESTORE.
+func restoredKind(rec remotelist.LogRecord) string {
+	s, id := rec.State, rec.ListID
+	if members, ok := s.Sets[id]; ok {
+		return fmt.Sprintf("set, %d membros", len(members))
+	}
+	if hash, ok := s.Maps[id]; ok {
+		return fmt.Sprintf("map, %d campos", len(hash))
+	}
+	if items, ok := s.Sorted[id]; ok {
+		return fmt.Sprintf("sorted, %d membros", len(items))
+	}
+	if st, ok := s.Streams[id]; ok {
+		return fmt.Sprintf("stream, %d entradas", len(st.Entries))
+	}
+	return fmt.Sprintf("counter, %d", s.Counters[id])
+}
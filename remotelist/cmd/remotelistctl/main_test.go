@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Os comandos contra testdata/data: um snapshot com as listas a e b e o set s,
+// e um segmento com mais quatro registros e uma linha inválida no meio.
+
+const fixture = "testdata/data"
+
+// ctlRun roda remotelistctl com args e devolve o código de saída e as saídas.
+func ctlRun(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// copyFixture copia o diretório de dados para um diretório temporário, para
+// os comandos que o alteram.
+func copyFixture(t *testing.T) string {
+	dir := t.TempDir()
+	entries, err := os.ReadDir(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(fixture, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, e.Name()), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args   []string
+		code   int
+		stderr string
+	}{
+		{nil, 2, "uso: remotelistctl"},
+		{[]string{"desconhecido"}, 2, "comandos:"},
+		{[]string{"dump", "-nada"}, 2, "flag provided but not defined"},
+		{[]string{"export", "-dir", fixture}, 1, "informe -list"},
+		{[]string{"export", "-dir", fixture, "-list", "z"}, 1, `lista "z" não encontrada`},
+		{[]string{"diff", "só-um.json"}, 2, "uso: remotelistctl diff"},
+		{[]string{"verify", "-dir", "testdata/não-existe"}, 1, "erro:"},
+	} {
+		code, _, stderr := ctlRun(tc.args...)
+		if code != tc.code || !strings.Contains(stderr, tc.stderr) {
+			t.Errorf("%q: código %d, stderr %q; esperado %d com %q", tc.args, code, stderr, tc.code, tc.stderr)
+		}
+	}
+}
+
+func TestDump(t *testing.T) {
+	code, out, _ := ctlRun("dump", "-dir", fixture)
+	if code != 0 {
+		t.Fatalf("código %d:\n%s", code, out)
+	}
+	for _, want := range []string{
+		"== snapshot (próximo segmento 2, último registro 4,",
+		"== formato 2, compressão nenhuma, crc32c:",
+		"\na [1 2]\nb [10]\n",
+		"seq=5 ",
+		"APPEND a 3\n",
+		"INVÁLIDA (operação de log desconhecida \"LIXO\"): LIXO x\n",
+		"REMOVE b\n",
+		"APPEND c 8\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("dump sem %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	code, out, stderr := ctlRun("verify", "-dir", fixture)
+	if code != 1 || !strings.Contains(stderr, "1 linhas que não podem ser aplicadas") {
+		t.Fatalf("código %d, stderr %q", code, stderr)
+	}
+	for _, want := range []string{
+		"segmento 2, linha 3: operação de log desconhecida \"LIXO\": LIXO x\n",
+		"3 listas, 1 arquivos de log, último registro 8, 1 problemas\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("verify sem %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-list", "a"}, "1\n2\n3\n"},
+		{[]string{"-list", "a", "-json"}, "[1,2,3]\n"},
+		{[]string{"-list", "b", "-json"}, "[]\n"},
+		{[]string{"-list", "c"}, "7\n8\n"},
+	} {
+		code, out, stderr := ctlRun(append([]string{"export", "-dir", fixture}, tc.args...)...)
+		if code != 0 || out != tc.want {
+			t.Errorf("export %q: código %d, saída %q (stderr %q); esperado %q", tc.args, code, out, stderr, tc.want)
+		}
+	}
+}
+
+// compact grava o estado num snapshot novo e descarta o segmento, com a
+// linha inválida; diff compara o snapshot antigo com o novo.
+func TestCompactDiff(t *testing.T) {
+	dir := copyFixture(t)
+	old := filepath.Join(t.TempDir(), "antigo.json")
+	data, err := os.ReadFile(filepath.Join(dir, "remotelist.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(old, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	code, out, stderr := ctlRun("compact", "-dir", dir, "-compress", "gzip")
+	if code != 0 || !strings.Contains(out, "3 listas até o registro 8") || !strings.Contains(out, "1 linhas inválidas foram descartadas") {
+		t.Fatalf("compact: código %d, saída %q, stderr %q", code, out, stderr)
+	}
+	if code, out, _ := ctlRun("verify", "-dir", dir); code != 0 || !strings.Contains(out, "3 listas, 0 arquivos de log, último registro 8, 0 problemas") {
+		t.Fatalf("verify depois do compact: código %d, saída %q", code, out)
+	}
+	if code, out, _ := ctlRun("export", "-dir", dir, "-list", "c", "-json"); code != 0 || out != "[7,8]\n" {
+		t.Fatalf("export depois do compact: código %d, saída %q", code, out)
+	}
+
+	code, out, _ = ctlRun("diff", old, filepath.Join(dir, "remotelist.json"))
+	want := "~ a: 2 x 3 elementos, primeira diferença no índice 2\n" +
+		"~ b: 1 x 0 elementos, primeira diferença no índice 0\n" +
+		"+ c (só no segundo, 2 elementos)\n"
+	if code != 0 || out != want {
+		t.Fatalf("diff: código %d, saída\n%s\nesperado\n%s", code, out, want)
+	}
+	if code, out, _ := ctlRun("diff", old, old); code != 0 || out != "snapshots iguais\n" {
+		t.Fatalf("diff do mesmo arquivo: código %d, saída %q", code, out)
+	}
+}
@@ -0,0 +1,198 @@
+// snapshotbench mede quanto tempo os escritores ficam parados enquanto o
+// servidor cria snapshots de listas grandes. No fim, mostra o tamanho do
+// último snapshot e quanto tempo e memória a leitura dele custa.
+//
+// Uso: go run ./cmd/snapshotbench -lists 8 -size 1000000 -writers 8 -snapshots 5 -compress zstd
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"remotelist/pkg"
+)
+
+func main() {
+	numLists := flag.Int("lists", 8, "quantidade de listas")
+	listSize := flag.Int("size", 1000000, "elementos iniciais em cada lista")
+	writers := flag.Int("writers", 8, "goroutines escrevendo (uma por lista, em rodízio)")
+	snapshots := flag.Int("snapshots", 5, "quantidade de snapshots durante a medição")
+	compress := flag.String("compress", "", "compressão do snapshot: gzip, zstd ou vazio (nenhuma)")
+	flag.Parse()
+
+	dir, err := os.MkdirTemp("", "snapshotbench")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Gera o estado inicial direto no arquivo de snapshot; fazer isso com Append
+	// pagaria um fsync por elemento.
+	if err := writeInitialSnapshot(dir, *numLists, *listSize); err != nil {
+		log.Fatal(err)
+	}
+
+	log.SetOutput(os.Stderr)
+	cfg := remotelist.Config{Dir: dir, SnapshotInterval: -1, SnapshotCompression: *compress}
+	rl, err := remotelist.NewRemoteListWithConfig(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pauses := &pauseLog{}
+	log.SetOutput(pauses)
+
+	var snapshotting atomic.Bool
+	var mu sync.Mutex
+	var during, idle []time.Duration
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for w := 0; w < *writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			args := remotelist.AppendArgs{ListID: fmt.Sprintf("lista%d", w%*numLists), Value: w}
+			var reply remotelist.AppendReply
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				inSnapshot := snapshotting.Load()
+				start := time.Now()
+				if err := rl.Append(args, &reply); err != nil {
+					log.Fatal(err)
+				}
+				elapsed := time.Since(start)
+
+				mu.Lock()
+				if inSnapshot || snapshotting.Load() {
+					during = append(during, elapsed)
+				} else {
+					idle = append(idle, elapsed)
+				}
+				mu.Unlock()
+			}
+		}(w)
+	}
+
+	var snapshotTimes []time.Duration
+	for i := 0; i < *snapshots; i++ {
+		time.Sleep(200 * time.Millisecond)
+		snapshotting.Store(true)
+		start := time.Now()
+		if err := rl.Snapshot(); err != nil {
+			log.Fatal(err)
+		}
+		snapshotTimes = append(snapshotTimes, time.Since(start))
+		snapshotting.Store(false)
+	}
+	time.Sleep(200 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+	rl.Close()
+
+	fmt.Printf("estado: %d listas x %d elementos\n", *numLists, *listSize)
+	for i, d := range snapshotTimes {
+		fmt.Printf("snapshot %d: %v no total\n", i+1, d)
+	}
+	for _, line := range pauses.lines {
+		fmt.Print(line)
+	}
+	report("Append sem snapshot", idle)
+	report("Append durante snapshot", during)
+
+	if err := reportLoad(cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// reportLoad mostra o tamanho do snapshot e o custo de reabrir o servidor
+// sobre ele (só o último segmento do log, quase vazio, é aplicado por cima).
+func reportLoad(cfg remotelist.Config) error {
+	info, err := os.Stat(filepath.Join(cfg.Dir, "remotelist.json"))
+	if err != nil {
+		return err
+	}
+	compression := cfg.SnapshotCompression
+	if compression == "" {
+		compression = "nenhuma"
+	}
+	fmt.Printf("snapshot: %d bytes (compressão %s)\n", info.Size(), compression)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	rl, err := remotelist.NewRemoteListWithConfig(cfg)
+	if err != nil {
+		return err
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	rl.Close()
+	fmt.Printf("leitura: %v, %d MiB alocados\n", elapsed, (after.TotalAlloc-before.TotalAlloc)>>20)
+	return nil
+}
+
+// writeInitialSnapshot grava o remotelist.json no formato 0 (só as listas),
+// que o servidor ainda lê; o primeiro snapshot da medição já sai no atual.
+func writeInitialSnapshot(dir string, numLists, listSize int) error {
+	data := make(map[string][]int, numLists)
+	for l := 0; l < numLists; l++ {
+		values := make([]int, listSize)
+		for i := range values {
+			values[i] = i
+		}
+		data[fmt.Sprintf("lista%d", l)] = values
+	}
+	f, err := os.Create(filepath.Join(dir, "remotelist.json"))
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// report imprime as latências observadas pelos escritores.
+func report(name string, samples []time.Duration) {
+	if len(samples) == 0 {
+		fmt.Printf("%s: nenhuma amostra\n", name)
+		return
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	pct := func(p float64) time.Duration {
+		return samples[int(p*float64(len(samples)-1))]
+	}
+	fmt.Printf("%s: n=%d p50=%v p99=%v max=%v\n", name, len(samples), pct(0.50), pct(0.99), samples[len(samples)-1])
+}
+
+// pauseLog guarda apenas as linhas de log do servidor que informam a pausa
+// dos escritores em cada snapshot.
+type pauseLog struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (p *pauseLog) Write(b []byte) (int, error) {
+	if strings.Contains(string(b), "escritores bloqueados") {
+		p.mu.Lock()
+		p.lines = append(p.lines, string(b))
+		p.mu.Unlock()
+	}
+	return len(b), nil
+}
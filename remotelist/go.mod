@@ -1,3 +1,14 @@
 module remotelist
 
 go 1.21.5
+
+require (
+	github.com/klauspost/compress v1.17.11
+	github.com/peterh/liner v1.2.2
+	go.etcd.io/bbolt v1.3.9
+)
+
+require (
+	github.com/mattn/go-runewidth v0.0.3 // indirect
+	golang.org/x/sys v0.4.0 // indirect
+)
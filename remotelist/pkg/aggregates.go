@@ -0,0 +1,295 @@
+package remotelist
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// --- Agregados e visões ordenadas ---
+//
+// Stats e View calculam no servidor o que o cliente faria lendo a lista
+// elemento por elemento (as estatísticas, a ordenação e o filtro de pares do
+// miniprojeto). Os elementos são lidos numa só passagem, sob o lock de leitura
+// da lista, e o resultado vale para aquele instante; a ordenação e a mediana
+// são feitas sobre a cópia, já sem segurar os escritores. View só copia os
+// elementos que podem cair na página (os Offset+Limit primeiros na ordem
+// pedida, escolhidos durante a leitura); os outros só são contados. A ordem
+// gravada na lista nunca muda.
+//
+// Filter escolhe os elementos considerados: por paridade e por um intervalo
+// fechado [Min, Max]. O Filter zero considera todos.
+
+// Paridades de Filter.
+const (
+	ParityEven = "even"
+	ParityOdd  = "odd"
+)
+
+// Ordens de View.
+const (
+	OrderStored = ""     // A ordem da lista
+	OrderAsc    = "asc"  // Crescente
+	OrderDesc   = "desc" // Decrescente
+)
+
+// Tamanho da página de View quando Limit é zero, e o máximo aceito.
+const (
+	defaultPageSize = 100
+	maxPageSize     = 10000
+)
+
+type Filter struct {
+	Parity string // "", ParityEven ou ParityOdd
+	Min    *int   // Menor valor aceito (nil = sem limite)
+	Max    *int   // Maior valor aceito (nil = sem limite)
+}
+
+func (f Filter) validate() error {
+	switch f.Parity {
+	case "", ParityEven, ParityOdd:
+	default:
+		return fmt.Errorf("paridade inválida: %q (use %q ou %q)", f.Parity, ParityEven, ParityOdd)
+	}
+	if f.Min != nil && f.Max != nil && *f.Min > *f.Max {
+		return fmt.Errorf("intervalo vazio: mínimo %d maior que o máximo %d", *f.Min, *f.Max)
+	}
+	return nil
+}
+
+func (f Filter) match(v int) bool {
+	switch {
+	case f.Parity == ParityEven && v%2 != 0,
+		f.Parity == ParityOdd && v%2 == 0,
+		f.Min != nil && v < *f.Min,
+		f.Max != nil && v > *f.Max:
+		return false
+	}
+	return true
+}
+
+type StatsArgs struct {
+	ListID string
+	Filter Filter
+	Read   ReadOptions
+}
+
+// StatsReply traz os agregados dos elementos que passaram pelo filtro. Com
+// Count zero, os outros campos ficam zerados.
+type StatsReply struct {
+	Count  int
+	Sum    int // Com SumOverflow, só os bits de baixo da soma
+	Min    int
+	Max    int
+	Mean   float64 // Calculada com a soma inteira, mesmo com SumOverflow
+	Median float64 // Com Count par, a média dos dois do meio
+
+	// SumOverflow diz que a soma não cabe num int.
+	SumOverflow bool
+}
+
+type ViewArgs struct {
+	ListID string
+	Filter Filter
+	Order  string // OrderStored, OrderAsc ou OrderDesc
+	Offset int    // Quantos elementos pular, já filtrados e ordenados
+	Limit  int    // Tamanho da página (0 = defaultPageSize; no máximo maxPageSize)
+	Read   ReadOptions
+}
+type ViewReply struct {
+	Values []int
+	Total  int // Elementos que passaram pelo filtro, para paginar
+}
+
+// Stats calcula Count, Sum, Min, Max, Mean e Median da lista 'list_id'.
+func (rl *RemoteList) Stats(args StatsArgs, reply *StatsReply) error {
+	if forwarded, err := rl.routeRead(args.Read, "Stats", args, reply); forwarded {
+		return err
+	}
+	values, err := rl.matching(args.ListID, args.Filter)
+	if err != nil {
+		return err
+	}
+	*reply = StatsReply{Count: len(values)}
+	if len(values) == 0 {
+		return nil
+	}
+	// A soma de verdade é Sum + carry*2^64: carry conta as voltas do int.
+	carry := 0
+	for _, v := range values {
+		sum := reply.Sum + v
+		if v > 0 && sum < reply.Sum {
+			carry++
+		} else if v < 0 && sum > reply.Sum {
+			carry--
+		}
+		reply.Sum = sum
+	}
+	reply.SumOverflow = carry != 0
+	sort.Ints(values)
+	n := len(values)
+	reply.Min, reply.Max = values[0], values[n-1]
+	reply.Mean = (float64(carry)*(1<<64) + float64(reply.Sum)) / float64(n)
+	if n%2 == 1 {
+		reply.Median = float64(values[n/2])
+	} else {
+		reply.Median = (float64(values[n/2-1]) + float64(values[n/2])) / 2
+	}
+	return nil
+}
+
+// View devolve uma página dos elementos da lista 'list_id' que passam pelo
+// filtro, na ordem pedida.
+func (rl *RemoteList) View(args ViewArgs, reply *ViewReply) error {
+	if forwarded, err := rl.routeRead(args.Read, "View", args, reply); forwarded {
+		return err
+	}
+	if args.Offset < 0 || args.Limit < 0 {
+		return fmt.Errorf("página inválida: offset %d, limit %d", args.Offset, args.Limit)
+	}
+	limit := args.Limit
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+	limit = min(limit, maxPageSize)
+
+	var less func(a, b int) bool
+	switch args.Order {
+	case OrderStored:
+	case OrderAsc:
+		less = func(a, b int) bool { return a < b }
+	case OrderDesc:
+		less = func(a, b int) bool { return a > b }
+	default:
+		return fmt.Errorf("ordem inválida: %q (use %q ou %q)", args.Order, OrderAsc, OrderDesc)
+	}
+	// Só os keep primeiros na ordem pedida podem cair na página.
+	keep := limit
+	if args.Offset < math.MaxInt-limit {
+		keep += args.Offset
+	} else {
+		keep = math.MaxInt
+	}
+	var top *topK
+	if less != nil {
+		top = &topK{k: keep, less: less}
+	}
+	var values []int
+	total, err := rl.scan(args.ListID, args.Filter, func(v int) {
+		switch {
+		case top != nil:
+			top.add(v)
+		case len(values) < keep:
+			values = append(values, v)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if top != nil {
+		values = top.sorted()
+	}
+	reply.Total = total
+	start := min(args.Offset, len(values))
+	end := min(start+limit, len(values))
+	reply.Values = values[start:end]
+	return nil
+}
+
+// topK guarda os k primeiros elementos na ordem less: um heap com o pior dos
+// guardados na raiz, trocado quando chega um melhor.
+type topK struct {
+	k    int
+	less func(a, b int) bool
+	heap []int
+}
+
+func (t *topK) add(v int) {
+	if len(t.heap) < t.k {
+		t.heap = append(t.heap, v)
+		t.up(len(t.heap) - 1)
+		return
+	}
+	if t.k > 0 && t.less(v, t.heap[0]) {
+		t.heap[0] = v
+		t.down(0)
+	}
+}
+
+// worse diz se o elemento i do heap vem depois do j na ordem less.
+func (t *topK) worse(i, j int) bool {
+	return t.less(t.heap[j], t.heap[i])
+}
+
+func (t *topK) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !t.worse(i, parent) {
+			return
+		}
+		t.heap[i], t.heap[parent] = t.heap[parent], t.heap[i]
+		i = parent
+	}
+}
+
+func (t *topK) down(i int) {
+	for {
+		worst := i
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < len(t.heap) && t.worse(child, worst) {
+				worst = child
+			}
+		}
+		if worst == i {
+			return
+		}
+		t.heap[i], t.heap[worst] = t.heap[worst], t.heap[i]
+		i = worst
+	}
+}
+
+// sorted devolve os guardados na ordem less.
+func (t *topK) sorted() []int {
+	sort.Slice(t.heap, func(i, j int) bool { return t.less(t.heap[i], t.heap[j]) })
+	return t.heap
+}
+
+// matching copia, na ordem da lista, os elementos de 'listID' que passam por f.
+func (rl *RemoteList) matching(listID string, f Filter) ([]int, error) {
+	var out []int
+	if _, err := rl.scan(listID, f, func(v int) { out = append(out, v) }); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// scan passa a fn, na ordem da lista e sob o lock de leitura dela, os
+// elementos de 'listID' que passam por f, e devolve quantos foram.
+func (rl *RemoteList) scan(listID string, f Filter, fn func(v int)) (int, error) {
+	if err := f.validate(); err != nil {
+		return 0, err
+	}
+	ml, exists := rl.getList(listID)
+	if !exists {
+		return 0, errListNotFound
+	}
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+	if ml.deleted || ml.expired(time.Now()) {
+		return 0, errListNotFound
+	}
+	if ml.kind != KindList {
+		return 0, errWrongType(listID, ml.kind, KindList)
+	}
+	n := 0
+	for _, chunk := range ml.chunks {
+		for _, v := range chunk {
+			if f.match(v) {
+				fn(v)
+				n++
+			}
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,329 @@
+package remotelist_test
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"remotelist/pkg"
+)
+
+// Stats e View comparados com o mesmo cálculo feito no cliente, sobre listas
+// aleatórias, com um servidor neste processo (sistema de arquivos em memória,
+// chamadas por RPC).
+
+// newAggCluster sobe o servidor, derrubado no fim do teste. A semente dos
+// valores aleatórios vem do relógio e vai para o log do teste.
+func newAggCluster(t *testing.T) *aggCluster {
+	seed := time.Now().UnixNano()
+	t.Logf("semente %d", seed)
+	mem := remotelist.NewMemFS()
+	mem.MkdirAll(dataDir, 0755)
+	rl, err := remotelist.NewRemoteListWithConfig(remotelist.Config{Dir: dataDir, FS: mem, SnapshotInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &aggCluster{rl: rl, rpc: rpc.NewServer(), rnd: rand.New(rand.NewSource(seed))}
+	if err := c.rpc.RegisterName("RemoteList", rl); err != nil {
+		rl.Close()
+		t.Fatal(err)
+	}
+	c.cl = c.dial()
+	t.Cleanup(c.close)
+	return c
+}
+
+// --- Servidor e cliente ---
+
+type aggCluster struct {
+	rl  *remotelist.RemoteList
+	rpc *rpc.Server
+	cl  *rpc.Client
+	rnd *rand.Rand
+}
+
+// dial abre um cliente ligado ao servidor por um net.Pipe.
+func (c *aggCluster) dial() *rpc.Client {
+	client, server := net.Pipe()
+	go c.rpc.ServeConn(server)
+	return rpc.NewClient(client)
+}
+
+func (c *aggCluster) close() {
+	c.cl.Close()
+	c.rl.Close()
+}
+
+// fill grava n valores aleatórios em [-lim, lim] na lista e os devolve.
+func (c *aggCluster) fill(list string, n, lim int) ([]int, error) {
+	values := make([]int, n)
+	for i := range values {
+		values[i] = c.rnd.Intn(2*lim+1) - lim
+		if err := c.cl.Call("RemoteList.Append", remotelist.AppendArgs{ListID: list, Value: values[i]}, &remotelist.AppendReply{}); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// filters são os filtros conferidos em cada cenário.
+func filters() []remotelist.Filter {
+	lo, hi, none := -10, 25, 1000
+	return []remotelist.Filter{
+		{},
+		{Parity: remotelist.ParityEven},
+		{Parity: remotelist.ParityOdd},
+		{Min: &lo},
+		{Max: &hi},
+		{Min: &lo, Max: &hi},
+		{Parity: remotelist.ParityOdd, Min: &lo, Max: &hi},
+		{Min: &none},
+	}
+}
+
+// apply é o filtro feito no cliente.
+func apply(values []int, f remotelist.Filter) []int {
+	out := []int{}
+	for _, v := range values {
+		if (f.Parity == remotelist.ParityEven && v%2 != 0) || (f.Parity == remotelist.ParityOdd && v%2 == 0) ||
+			(f.Min != nil && v < *f.Min) || (f.Max != nil && v > *f.Max) {
+			continue
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+// expectedStats são os agregados calculados no cliente.
+func expectedStats(values []int) remotelist.StatsReply {
+	st := remotelist.StatsReply{Count: len(values)}
+	if len(values) == 0 {
+		return st
+	}
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+	st.Min, st.Max = sorted[0], sorted[len(sorted)-1]
+	for _, v := range values {
+		st.Sum += v
+	}
+	st.Mean = float64(st.Sum) / float64(len(values))
+	mid := len(sorted) / 2
+	st.Median = float64(sorted[mid])
+	if len(sorted)%2 == 0 {
+		st.Median = float64(sorted[mid-1]+sorted[mid]) / 2
+	}
+	return st
+}
+
+func describe(f remotelist.Filter) string {
+	s := fmt.Sprintf("paridade %q", f.Parity)
+	if f.Min != nil {
+		s += fmt.Sprintf(" min %d", *f.Min)
+	}
+	if f.Max != nil {
+		s += fmt.Sprintf(" max %d", *f.Max)
+	}
+	return s
+}
+
+// --- Cenários ---
+
+func TestAggStats(t *testing.T) {
+	c := newAggCluster(t)
+	for _, n := range []int{1, 2, 7, 1500, 3000} {
+		list := fmt.Sprintf("l%d", n)
+		values, err := c.fill(list, n, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range filters() {
+			var got remotelist.StatsReply
+			if err := c.cl.Call("RemoteList.Stats", remotelist.StatsArgs{ListID: list, Filter: f}, &got); err != nil {
+				t.Fatalf("%s, %s: %v", list, describe(f), err)
+			}
+			if want := expectedStats(apply(values, f)); got != want {
+				t.Fatalf("%s, %s: %+v, esperado %+v", list, describe(f), got, want)
+			}
+		}
+	}
+}
+
+func TestAggView(t *testing.T) {
+	c := newAggCluster(t)
+	values, err := c.fill("l", 2500, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range filters() {
+		want := apply(values, f)
+		for _, order := range []string{remotelist.OrderStored, remotelist.OrderAsc, remotelist.OrderDesc} {
+			expected := append([]int{}, want...)
+			switch order {
+			case remotelist.OrderAsc:
+				sort.Ints(expected)
+			case remotelist.OrderDesc:
+				sort.Sort(sort.Reverse(sort.IntSlice(expected)))
+			}
+			// Páginas de tamanho aleatório até o fim, e uma além dele.
+			got := []int{}
+			for offset := 0; ; {
+				args := remotelist.ViewArgs{ListID: "l", Filter: f, Order: order, Offset: offset, Limit: c.rnd.Intn(300)}
+				var reply remotelist.ViewReply
+				if err := c.cl.Call("RemoteList.View", args, &reply); err != nil {
+					t.Fatalf("%s, ordem %q: %v", describe(f), order, err)
+				}
+				if reply.Total != len(want) {
+					t.Fatalf("%s, ordem %q: total %d, esperado %d", describe(f), order, reply.Total, len(want))
+				}
+				if len(reply.Values) == 0 {
+					break
+				}
+				got = append(got, reply.Values...)
+				offset += len(reply.Values)
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Fatalf("%s, ordem %q: páginas não batem com a lista filtrada", describe(f), order)
+			}
+		}
+	}
+	// A ordem gravada não mudou.
+	for i, v := range values {
+		var reply remotelist.GetReply
+		if err := c.cl.Call("RemoteList.Get", remotelist.GetArgs{ListID: "l", Index: i}, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.Value != v {
+			t.Fatalf("Get(%d) = %d depois das visões, esperado %d", i, reply.Value, v)
+		}
+	}
+}
+
+func TestAggErrors(t *testing.T) {
+	c := newAggCluster(t)
+	if _, err := c.fill("l", 10, 10); err != nil {
+		t.Fatal(err)
+	}
+	lo, hi := 5, 1
+	calls := []struct {
+		what   string
+		method string
+		args   any
+		reply  any
+	}{
+		{"Stats de lista inexistente", "Stats", remotelist.StatsArgs{ListID: "nada"}, &remotelist.StatsReply{}},
+		{"View de lista inexistente", "View", remotelist.ViewArgs{ListID: "nada"}, &remotelist.ViewReply{}},
+		{"paridade inválida", "Stats", remotelist.StatsArgs{ListID: "l", Filter: remotelist.Filter{Parity: "prime"}}, &remotelist.StatsReply{}},
+		{"intervalo vazio", "View", remotelist.ViewArgs{ListID: "l", Filter: remotelist.Filter{Min: &lo, Max: &hi}}, &remotelist.ViewReply{}},
+		{"ordem inválida", "View", remotelist.ViewArgs{ListID: "l", Order: "random"}, &remotelist.ViewReply{}},
+		{"offset negativo", "View", remotelist.ViewArgs{ListID: "l", Offset: -1}, &remotelist.ViewReply{}},
+	}
+	for _, call := range calls {
+		if err := c.cl.Call("RemoteList."+call.method, call.args, call.reply); err == nil {
+			t.Fatalf("%s: sem erro", call.what)
+		}
+	}
+	// Sem Limit, a página tem o tamanho padrão; um offset além do fim é vazio.
+	var reply remotelist.ViewReply
+	if err := c.cl.Call("RemoteList.View", remotelist.ViewArgs{ListID: "l", Offset: 10}, &reply); err != nil || len(reply.Values) != 0 || reply.Total != 10 {
+		t.Fatalf("offset no fim: %v, %+v", err, reply)
+	}
+}
+
+// Uma soma que não cabe num int é avisada, sem estragar a média; uma que só
+// passa do int no meio do caminho não é. Um offset no limite do int não estoura
+// a conta da página.
+func TestAggOverflow(t *testing.T) {
+	c := newAggCluster(t)
+	for _, tc := range []struct {
+		list     string
+		values   []int
+		sum      int
+		overflow bool
+		mean     float64
+	}{
+		{"acima", []int{math.MaxInt, math.MaxInt, -5}, -7, true, (2*float64(math.MaxInt) - 5) / 3},
+		{"abaixo", []int{math.MinInt, math.MinInt, 4}, 4, true, (2*float64(math.MinInt) + 4) / 3},
+		{"volta", []int{math.MaxInt, 1, -10}, math.MaxInt - 9, false, float64(math.MaxInt-9) / 3},
+	} {
+		for _, v := range tc.values {
+			if err := c.cl.Call("RemoteList.Append", remotelist.AppendArgs{ListID: tc.list, Value: v}, &remotelist.AppendReply{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var st remotelist.StatsReply
+		if err := c.cl.Call("RemoteList.Stats", remotelist.StatsArgs{ListID: tc.list}, &st); err != nil {
+			t.Fatal(err)
+		}
+		if st.Sum != tc.sum || st.SumOverflow != tc.overflow || math.Abs(st.Mean-tc.mean) > math.Abs(tc.mean)*1e-12 {
+			t.Fatalf("%s: soma %d (estouro %v), média %g; esperado %d (%v), %g", tc.list, st.Sum, st.SumOverflow, st.Mean, tc.sum, tc.overflow, tc.mean)
+		}
+	}
+	for _, order := range []string{remotelist.OrderStored, remotelist.OrderAsc} {
+		var reply remotelist.ViewReply
+		args := remotelist.ViewArgs{ListID: "acima", Order: order, Offset: math.MaxInt, Limit: 10}
+		if err := c.cl.Call("RemoteList.View", args, &reply); err != nil || len(reply.Values) != 0 || reply.Total != 3 {
+			t.Fatalf("ordem %q, offset máximo: %v, %+v", order, err, reply)
+		}
+	}
+}
+
+func TestAggConcurrent(t *testing.T) {
+	c := newAggCluster(t)
+	const n = 3000
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cl := c.dial()
+		defer cl.Close()
+		for i := 1; i <= n; i++ {
+			if err := cl.Call("RemoteList.Append", remotelist.AppendArgs{ListID: "l", Value: i}, &remotelist.AppendReply{}); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cl := c.dial()
+			defer cl.Close()
+			for last := 0; last < n; {
+				var st remotelist.StatsReply
+				err := cl.Call("RemoteList.Stats", remotelist.StatsArgs{ListID: "l"}, &st)
+				if err != nil && err.Error() == "lista não encontrada" {
+					continue
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+				// Os valores 1..k: qualquer outra soma mistura dois instantes.
+				if want := (remotelist.StatsReply{Count: st.Count, Sum: st.Count * (st.Count + 1) / 2, Min: 1, Max: st.Count,
+					Mean: float64(st.Count+1) / 2, Median: float64(st.Count+1) / 2}); st != want {
+					errs <- fmt.Errorf("Stats com %d elementos: %+v", st.Count, st)
+					return
+				}
+				if st.Count < last {
+					errs <- fmt.Errorf("a contagem voltou de %d para %d", last, st.Count)
+					return
+				}
+				last = st.Count
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,264 @@
+package remotelist_test
+
+// Benchmarks dos métodos do RemoteList chamados em processo, sem RPC, para
+// medir o efeito de mudanças em locks, fsync e snapshot:
+//
+//	go test ./pkg -run '^$' -bench 'Get|Size' -benchtime 3s -cpu 1,4
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"remotelist/pkg"
+)
+
+// newList cria um RemoteList em um diretório temporário, já com as listas
+// dadas gravadas no snapshot (sem pagar um fsync por elemento).
+func newList(b *testing.B, initial map[string][]int) *remotelist.RemoteList {
+	b.Helper()
+	rl, _ := newListDir(b, initial)
+	return rl
+}
+
+// newListDir é como newList, mas também devolve o diretório de dados.
+func newListDir(b *testing.B, initial map[string][]int) (*remotelist.RemoteList, string) {
+	b.Helper()
+	dir := b.TempDir()
+
+	if initial != nil {
+		f, err := os.Create(filepath.Join(dir, "remotelist.json"))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := json.NewEncoder(f).Encode(initial); err != nil {
+			b.Fatal(err)
+		}
+		f.Close()
+	}
+
+	rl, err := remotelist.NewRemoteListWithConfig(remotelist.Config{Dir: dir, SnapshotInterval: -1})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { rl.Close() })
+	return rl, dir
+}
+
+func sequence(n int) []int {
+	values := make([]int, n)
+	for i := range values {
+		values[i] = i
+	}
+	return values
+}
+
+func BenchmarkAppend(b *testing.B) {
+	rl := newList(b, nil)
+	var reply remotelist.AppendReply
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := rl.Append(remotelist.AppendArgs{ListID: "l", Value: i}, &reply); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAppendParallelDistinctLists(b *testing.B) {
+	rl := newList(b, nil)
+	var next atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		listID := fmt.Sprintf("l%d", next.Add(1))
+		var reply remotelist.AppendReply
+		for i := 0; pb.Next(); i++ {
+			if err := rl.Append(remotelist.AppendArgs{ListID: listID, Value: i}, &reply); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkAppendParallelSameList(b *testing.B) {
+	rl := newList(b, nil)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var reply remotelist.AppendReply
+		for i := 0; pb.Next(); i++ {
+			if err := rl.Append(remotelist.AppendArgs{ListID: "l", Value: i}, &reply); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGet(b *testing.B) {
+	const size = 100000
+	rl := newList(b, map[string][]int{"l": sequence(size)})
+	var reply remotelist.GetReply
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := rl.Get(remotelist.GetArgs{ListID: "l", Index: i % size}, &reply); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetParallel(b *testing.B) {
+	const size = 100000
+	rl := newList(b, map[string][]int{"l": sequence(size)})
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var reply remotelist.GetReply
+		for i := 0; pb.Next(); i++ {
+			if err := rl.Get(remotelist.GetArgs{ListID: "l", Index: i % size}, &reply); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkSize(b *testing.B) {
+	rl := newList(b, map[string][]int{"l": sequence(10)})
+	var reply remotelist.SizeReply
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rl.Size(remotelist.SizeArgs{ListID: "l"}, &reply)
+	}
+}
+
+func BenchmarkSizeParallel(b *testing.B) {
+	rl := newList(b, map[string][]int{"l": sequence(10)})
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var reply remotelist.SizeReply
+		for pb.Next() {
+			rl.Size(remotelist.SizeArgs{ListID: "l"}, &reply)
+		}
+	})
+}
+
+func BenchmarkRemove(b *testing.B) {
+	rl := newList(b, map[string][]int{"l": sequence(b.N)})
+	var reply remotelist.RemoveReply
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := rl.Remove(remotelist.RemoveArgs{ListID: "l"}, &reply); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSnapshot1M mede um snapshot completo de 1 milhão de elementos em 10 listas.
+func BenchmarkSnapshot1M(b *testing.B) {
+	initial := make(map[string][]int)
+	for i := 0; i < 10; i++ {
+		initial[fmt.Sprintf("l%d", i)] = sequence(100000)
+	}
+	rl := newList(b, initial)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := rl.Snapshot(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkOpen1M mede a inicialização sobre um snapshot de 1 milhão de elementos.
+func BenchmarkOpen1M(b *testing.B) {
+	initial := make(map[string][]int)
+	for i := 0; i < 10; i++ {
+		initial[fmt.Sprintf("l%d", i)] = sequence(100000)
+	}
+	rl, dir := newListDir(b, initial)
+	if err := rl.Snapshot(); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rl, err := remotelist.NewRemoteListWithConfig(remotelist.Config{Dir: dir, SnapshotInterval: -1})
+		if err != nil {
+			b.Fatal(err)
+		}
+		rl.Close()
+	}
+}
+
+// newBoltList cria um BoltList em um diretório temporário, já com as listas dadas.
+func newBoltList(b *testing.B, initial map[string][]int) (*remotelist.BoltList, string) {
+	b.Helper()
+	dir := b.TempDir()
+	path := filepath.Join(dir, "remotelist.db")
+	bl, err := remotelist.OpenBoltList(remotelist.BoltConfig{Path: path})
+	if err != nil {
+		b.Fatal(err)
+	}
+	if initial != nil {
+		if err := bl.Import(initial); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.Cleanup(func() { bl.Close() })
+	return bl, path
+}
+
+func BenchmarkBoltAppend(b *testing.B) {
+	bl, _ := newBoltList(b, nil)
+	var reply remotelist.AppendReply
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := bl.Append(remotelist.AppendArgs{ListID: "l", Value: i}, &reply); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBoltAppendParallelDistinctLists(b *testing.B) {
+	bl, _ := newBoltList(b, nil)
+	var next atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		listID := fmt.Sprintf("l%d", next.Add(1))
+		var reply remotelist.AppendReply
+		for i := 0; pb.Next(); i++ {
+			if err := bl.Append(remotelist.AppendArgs{ListID: listID, Value: i}, &reply); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkBoltGet(b *testing.B) {
+	const size = 100000
+	bl, _ := newBoltList(b, map[string][]int{"l": sequence(size)})
+	var reply remotelist.GetReply
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := bl.Get(remotelist.GetArgs{ListID: "l", Index: i % size}, &reply); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkBoltOpen1M mede a inicialização sobre um banco de 1 milhão de elementos.
+func BenchmarkBoltOpen1M(b *testing.B) {
+	initial := make(map[string][]int)
+	for i := 0; i < 10; i++ {
+		initial[fmt.Sprintf("l%d", i)] = sequence(100000)
+	}
+	bl, path := newBoltList(b, initial)
+	bl.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bl, err := remotelist.OpenBoltList(remotelist.BoltConfig{Path: path})
+		if err != nil {
+			b.Fatal(err)
+		}
+		var reply remotelist.SizeReply
+		bl.Size(remotelist.SizeArgs{ListID: "l0"}, &reply)
+		bl.Close()
+	}
+}
@@ -0,0 +1,396 @@
+package remotelist
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// --- Backend bbolt ---
+//
+// BoltList oferece os mesmos métodos RPC do RemoteList, mas guarda as listas
+// em um banco bbolt (B+tree em um único arquivo mapeado em memória) em vez de
+// manter tudo na RAM com WAL e snapshot JSON. Cada lista é um bucket cujas
+// chaves são os índices (uint64 big-endian, então a ordem das chaves é a ordem
+// da lista) e os valores são os elementos. Abrir o banco não lê os dados: o
+// tempo de inicialização não depende do tamanho do conjunto.
+//
+// Cada escrita é uma transação confirmada com fsync antes da resposta, como o
+// WAL. Com BoltConfig.MaxBatchDelay, escritas concorrentes são agrupadas
+// (db.Batch) em uma transação só.
+
+// boltFile é o nome padrão do banco dentro do diretório de dados.
+const boltFile = "remotelist.db"
+
+// bucketLists é o bucket raiz; cada lista é um bucket dentro dele, com o nome
+// da lista depois de boltPrefix (ver boltBucket).
+var bucketLists = []byte("lists")
+
+// bucketMeta guarda o que não é lista. importedKey marca que os dados antigos
+// do diretório já foram importados (ver ImportOffline).
+var (
+	bucketMeta  = []byte("meta")
+	importedKey = []byte("imported")
+)
+
+// boltPrefix vem antes do nome de cada lista no nome do bucket: o bbolt não
+// aceita um bucket de nome vazio, e a lista "" é válida como no RemoteList.
+const boltPrefix = "l"
+
+// BoltConfig reúne os parâmetros do BoltList. O valor zero usa os padrões.
+type BoltConfig struct {
+	// Path é o arquivo do banco ("" = remotelist.db no diretório atual).
+	Path string
+	// MaxBatchDelay, se maior que zero, é quanto uma escrita espera por outras
+	// para dividirem a mesma transação e o mesmo fsync. Ajuda com muitos
+	// clientes escrevendo ao mesmo tempo, mas atrasa um cliente sozinho.
+	MaxBatchDelay time.Duration
+}
+
+// BoltList é o serviço RemoteList sobre bbolt. Registre-o com
+// rpc.RegisterName("RemoteList", ...) para que os clientes não percebam a troca.
+type BoltList struct {
+	db    *bolt.DB
+	batch bool
+}
+
+// OpenBoltList abre (ou cria) o banco descrito em cfg.
+func OpenBoltList(cfg BoltConfig) (*BoltList, error) {
+	if cfg.Path == "" {
+		cfg.Path = boltFile
+	}
+	db, err := bolt.Open(cfg.Path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("falha ao abrir banco %s: %w", cfg.Path, err)
+	}
+	batch := cfg.MaxBatchDelay > 0
+	if batch {
+		db.MaxBatchDelay = cfg.MaxBatchDelay
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(bucketMeta); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(bucketLists)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("falha ao preparar banco %s: %w", cfg.Path, err)
+	}
+	log.Printf("Serviço RemoteList (bbolt) iniciado sobre %s.", cfg.Path)
+	return &BoltList{db: db, batch: batch}, nil
+}
+
+// Close fecha o banco.
+func (bl *BoltList) Close() error {
+	return bl.db.Close()
+}
+
+// update executa uma transação de escrita, agrupada com outras se configurado.
+// Em um lote, fn pode ser executada mais de uma vez: ela só deve depender
+// dos argumentos e da transação.
+func (bl *BoltList) update(fn func(tx *bolt.Tx) error) error {
+	if bl.batch {
+		return bl.db.Batch(fn)
+	}
+	return bl.db.Update(fn)
+}
+
+// Imported diz se os dados antigos do diretório já foram importados.
+func (bl *BoltList) Imported() (bool, error) {
+	var imported bool
+	err := bl.db.View(func(tx *bolt.Tx) error {
+		imported = tx.Bucket(bucketMeta).Get(importedKey) != nil
+		return nil
+	})
+	return imported, err
+}
+
+// ImportOffline importa as listas de st (ver LoadOffline) uma vez só: a
+// importação fica marcada no banco, e as chamadas seguintes não fazem nada,
+// mesmo que as listas tenham sido apagadas depois. Um banco que já tem listas
+// e não tem a marca é de antes dela: só recebe a marca.
+//
+// Chaves já expiradas em now ficam de fora. O que o bbolt não guarda (chaves
+// que não são listas, TTLs, itens agendados e transações preparadas) é um
+// erro, e nada é importado: descartar esses dados em silêncio seria perdê-los.
+// Devolve quantas listas foram importadas.
+func (bl *BoltList) ImportOffline(st *OfflineState, now time.Time) (int, error) {
+	imported := 0
+	err := bl.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(bucketMeta)
+		if meta.Get(importedKey) != nil {
+			return nil
+		}
+		if k, _ := tx.Bucket(bucketLists).Cursor().First(); k == nil {
+			lists, err := offlineLists(st, now)
+			if err != nil {
+				return err
+			}
+			if err := importLists(tx, lists); err != nil {
+				return err
+			}
+			imported = len(lists)
+		}
+		return meta.Put(importedKey, []byte(now.UTC().Format(time.RFC3339)))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return imported, nil
+}
+
+// offlineLists separa as listas de st que não expiraram em now, ou devolve um
+// erro com o que o bbolt não tem como guardar.
+func offlineLists(st *OfflineState, now time.Time) (map[string][]int, error) {
+	live := func(id string) bool {
+		at, ok := st.Expires[id]
+		return !ok || at.After(now)
+	}
+	var problems []string
+	lost := func(format string, ids []string) {
+		for _, id := range ids {
+			if live(id) {
+				problems = append(problems, fmt.Sprintf(format, id))
+			}
+		}
+	}
+	lost("set %q", keysOf(st.Structures.Sets))
+	lost("map %q", keysOf(st.Structures.Maps))
+	lost("contador %q", keysOf(st.Structures.Counters))
+	lost("sorted set %q", keysOf(st.Structures.Sorted))
+	lost("stream %q", keysOf(st.Structures.Streams))
+	lost("itens agendados em %q", keysOf(st.Delayed))
+	for id := range st.Expires {
+		if _, isList := st.Lists[id]; isList && live(id) {
+			problems = append(problems, fmt.Sprintf("TTL em %q", id))
+		}
+	}
+	for _, ptx := range st.Prepared {
+		problems = append(problems, fmt.Sprintf("transação preparada %q", ptx.ID))
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		if len(problems) > 5 {
+			problems = append(problems[:5], fmt.Sprintf("e mais %d", len(problems)-5))
+		}
+		return nil, fmt.Errorf("os dados de %s têm o que o bbolt não guarda (%s); use o armazenamento wal ou remova esses dados antes",
+			st.Dir, strings.Join(problems, ", "))
+	}
+	lists := make(map[string][]int, len(st.Lists))
+	for id, values := range st.Lists {
+		if live(id) {
+			lists[id] = values
+		}
+	}
+	return lists, nil
+}
+
+// keysOf devolve as chaves de m.
+func keysOf[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Import grava as listas dadas no banco, substituindo listas de mesmo nome.
+func (bl *BoltList) Import(lists map[string][]int) error {
+	return bl.db.Update(func(tx *bolt.Tx) error {
+		return importLists(tx, lists)
+	})
+}
+
+func importLists(tx *bolt.Tx, lists map[string][]int) error {
+	root := tx.Bucket(bucketLists)
+	for id, data := range lists {
+		if err := validBoltListID(id); err != nil {
+			return err
+		}
+		if root.Bucket(boltBucket(id)) != nil {
+			if err := root.DeleteBucket(boltBucket(id)); err != nil {
+				return err
+			}
+		}
+		b, err := root.CreateBucket(boltBucket(id))
+		if err != nil {
+			return err
+		}
+		// Chaves inseridas em ordem crescente: preenche as páginas por completo.
+		b.FillPercent = 1.0
+		for i, v := range data {
+			if err := b.Put(boltKey(uint64(i)), boltValue(v)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// --- Métodos RPC (mesma semântica do RemoteList) ---
+
+// Append adiciona um valor ao final da lista, criando-a se não existir.
+func (bl *BoltList) Append(args AppendArgs, reply *AppendReply) error {
+	if err := validBoltListID(args.ListID); err != nil {
+		return err
+	}
+	var created bool
+	err := bl.update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(bucketLists)
+		b := root.Bucket(boltBucket(args.ListID))
+		created = b == nil
+		if created {
+			var err error
+			if b, err = root.CreateBucket(boltBucket(args.ListID)); err != nil {
+				return err
+			}
+		}
+		b.FillPercent = 1.0 // Só se acrescenta no fim
+		return b.Put(boltKey(boltLen(b)), boltValue(args.Value))
+	})
+	if err != nil {
+		log.Printf("Erro crítico de persistência (Append): %v", err)
+		return fmt.Errorf("falha ao gravar no disco (bbolt): %w", err)
+	}
+	if created {
+		log.Printf("Lista '%s' criada dinamicamente.", args.ListID)
+	}
+	reply.Success = true
+	return nil
+}
+
+// Get retorna o valor no índice 'i' da lista.
+func (bl *BoltList) Get(args GetArgs, reply *GetReply) error {
+	return bl.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketLists).Bucket(boltBucket(args.ListID))
+		if b == nil {
+			return errors.New("lista não encontrada")
+		}
+		if args.Index < 0 || uint64(args.Index) >= boltLen(b) {
+			return errors.New("índice fora dos limites")
+		}
+		reply.Value = boltDecode(b.Get(boltKey(uint64(args.Index))))
+		return nil
+	})
+}
+
+// errBoltUser marca erros da operação (lista vazia, inexistente), que não
+// devem ser logados como falha de persistência.
+type errBoltUser struct{ error }
+
+// Remove remove e retorna o último elemento da lista.
+func (bl *BoltList) Remove(args RemoveArgs, reply *RemoveReply) error {
+	var value int
+	err := bl.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketLists).Bucket(boltBucket(args.ListID))
+		if b == nil {
+			return errBoltUser{errors.New("lista não encontrada")}
+		}
+		c := b.Cursor()
+		k, v := c.Last()
+		if k == nil {
+			return errBoltUser{errors.New("lista vazia")}
+		}
+		value = boltDecode(v)
+		return c.Delete()
+	})
+	var userErr errBoltUser
+	if errors.As(err, &userErr) {
+		return userErr.error
+	}
+	if err != nil {
+		log.Printf("Erro crítico de persistência (Remove): %v", err)
+		return fmt.Errorf("falha ao gravar no disco (bbolt): %w", err)
+	}
+	reply.Value = value
+	return nil
+}
+
+// Size retorna o número de elementos da lista (0 se ela não existir).
+func (bl *BoltList) Size(args SizeArgs, reply *SizeReply) error {
+	return bl.db.View(func(tx *bolt.Tx) error {
+		reply.Size = 0
+		if b := tx.Bucket(bucketLists).Bucket(boltBucket(args.ListID)); b != nil {
+			reply.Size = int(boltLen(b))
+		}
+		return nil
+	})
+}
+
+// Lists retorna os nomes de todas as listas, em ordem alfabética
+// (a ordem dos buckets no bbolt já é a ordem dos bytes dos nomes).
+func (bl *BoltList) Lists(args ListsArgs, reply *ListsReply) error {
+	return bl.db.View(func(tx *bolt.Tx) error {
+		ids := []string{}
+		err := tx.Bucket(bucketLists).ForEach(func(k, v []byte) error {
+			if v == nil { // Só buckets
+				ids = append(ids, string(k[len(boltPrefix):]))
+			}
+			return nil
+		})
+		reply.ListIDs = ids
+		return err
+	})
+}
+
+// Keys é Lists com o tipo de cada chave: o bolt só guarda listas.
+func (bl *BoltList) Keys(args KeysArgs, reply *KeysReply) error {
+	var lists ListsReply
+	if err := bl.Lists(ListsArgs{}, &lists); err != nil {
+		return err
+	}
+	reply.Keys = make([]KeyInfo, len(lists.ListIDs))
+	for i, id := range lists.ListIDs {
+		reply.Keys[i] = KeyInfo{Key: id, Type: KindList.String()}
+	}
+	return nil
+}
+
+// --- Codificação ---
+
+// boltBucket devolve o nome do bucket da lista id.
+func boltBucket(id string) []byte {
+	return []byte(boltPrefix + id)
+}
+
+// validBoltListID recusa nomes que o bbolt não aceita como bucket.
+func validBoltListID(id string) error {
+	if len(boltPrefix)+len(id) > bolt.MaxKeySize {
+		return errors.New("nome de lista longo demais")
+	}
+	return nil
+}
+
+// boltLen devolve o tamanho da lista: a última chave mais um.
+// Os índices não têm buracos porque só se remove do fim.
+func boltLen(b *bolt.Bucket) uint64 {
+	k, _ := b.Cursor().Last()
+	if k == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(k) + 1
+}
+
+func boltKey(i uint64) []byte {
+	var k [8]byte
+	binary.BigEndian.PutUint64(k[:], i)
+	return k[:]
+}
+
+func boltValue(v int) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(int64(v)))
+	return b[:]
+}
+
+func boltDecode(b []byte) int {
+	return int(int64(binary.BigEndian.Uint64(b)))
+}
@@ -0,0 +1,134 @@
+package remotelist_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"remotelist/pkg"
+)
+
+// A lista de nome vazio vale no bolt como no RemoteList, inclusive depois de
+// reabrir o banco.
+func TestBoltEmptyID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "remotelist.db")
+	bl, err := remotelist.OpenBoltList(remotelist.BoltConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"", "a", ""} {
+		if err := bl.Append(remotelist.AppendArgs{ListID: id, Value: len(id) + 1}, &remotelist.AppendReply{}); err != nil {
+			t.Fatalf("Append(%q): %v", id, err)
+		}
+	}
+	if err := bl.Append(remotelist.AppendArgs{ListID: strings.Repeat("x", 1<<16)}, &remotelist.AppendReply{}); err == nil {
+		t.Fatal("Append com nome longo demais: sem erro")
+	}
+	bl.Close()
+
+	if bl, err = remotelist.OpenBoltList(remotelist.BoltConfig{Path: path}); err != nil {
+		t.Fatal(err)
+	}
+	defer bl.Close()
+	var lists remotelist.ListsReply
+	if err := bl.Lists(remotelist.ListsArgs{}, &lists); err != nil || !reflect.DeepEqual(lists.ListIDs, []string{"", "a"}) {
+		t.Fatalf("Lists = %q, %v; esperado [\"\" \"a\"]", lists.ListIDs, err)
+	}
+	var data remotelist.ExportListReply
+	if err := bl.Peer().ExportList(remotelist.ExportListArgs{ListID: ""}, &data); err != nil || !reflect.DeepEqual(data.Values, []int{1, 1}) {
+		t.Fatalf("ExportList(\"\") = %v, %v; esperado [1 1]", data.Values, err)
+	}
+	var removed remotelist.RemoveReply
+	if err := bl.Remove(remotelist.RemoveArgs{ListID: ""}, &removed); err != nil || removed.Value != 1 {
+		t.Fatalf("Remove(\"\") = %d, %v", removed.Value, err)
+	}
+	var deleted remotelist.DeleteListReply
+	if err := bl.Peer().DeleteList(remotelist.DeleteListArgs{ListID: ""}, &deleted); err != nil || !deleted.Existed {
+		t.Fatalf("DeleteList(\"\") = %+v, %v", deleted, err)
+	}
+	var size remotelist.SizeReply
+	if err := bl.Size(remotelist.SizeArgs{ListID: ""}, &size); err != nil || size.Size != 0 {
+		t.Fatalf("Size(\"\") depois do DeleteList = %d, %v", size.Size, err)
+	}
+}
+
+// Os dados antigos do diretório entram no banco uma vez só: depois de
+// importados, apagar as listas não as traz de volta na próxima abertura.
+func TestBoltImportOffline(t *testing.T) {
+	now := time.Now()
+	open := func() *remotelist.BoltList {
+		bl, err := remotelist.OpenBoltList(remotelist.BoltConfig{Path: filepath.Join(t.TempDir(), "remotelist.db")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { bl.Close() })
+		return bl
+	}
+	lists := func(bl *remotelist.BoltList) []string {
+		var reply remotelist.ListsReply
+		if err := bl.Lists(remotelist.ListsArgs{}, &reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply.ListIDs
+	}
+
+	// As chaves expiradas ficam de fora, inclusive as que o bbolt não guarda.
+	st := &remotelist.OfflineState{
+		Dir:        "antigo",
+		Lists:      map[string][]int{"a": {1, 2}, "velha": {3}},
+		Expires:    map[string]time.Time{"velha": now.Add(-time.Minute), "s": now.Add(-time.Second)},
+		Structures: remotelist.Structures{Sets: map[string][]int{"s": {5}}},
+	}
+	bl := open()
+	if n, err := bl.ImportOffline(st, now); err != nil || n != 1 {
+		t.Fatalf("ImportOffline = %d, %v; esperado 1 lista", n, err)
+	}
+	if got := lists(bl); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Fatalf("listas importadas %q, esperado [a]", got)
+	}
+	if err := bl.Peer().DeleteList(remotelist.DeleteListArgs{ListID: "a"}, &remotelist.DeleteListReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if imported, err := bl.Imported(); err != nil || !imported {
+		t.Fatalf("Imported = %v, %v depois da importação", imported, err)
+	}
+	if n, err := bl.ImportOffline(st, now); err != nil || n != 0 || len(lists(bl)) != 0 {
+		t.Fatalf("segunda importação: %d listas, %v; banco com %q", n, err, lists(bl))
+	}
+
+	// O que o bbolt não guarda é um erro, e nada é importado nem marcado.
+	for name, st := range map[string]*remotelist.OfflineState{
+		"set":       {Lists: map[string][]int{"a": {1}}, Structures: remotelist.Structures{Sets: map[string][]int{"s": {5}}}},
+		"contador":  {Structures: remotelist.Structures{Counters: map[string]int{"c": 1}}},
+		"stream":    {Structures: remotelist.Structures{Streams: map[string]remotelist.StreamState{"x": {}}}},
+		"ttl":       {Lists: map[string][]int{"a": {1}}, Expires: map[string]time.Time{"a": now.Add(time.Hour)}},
+		"agendado":  {Lists: map[string][]int{"a": {1}}, Delayed: map[string][]remotelist.DelayedItem{"a": {{ID: 1, Value: 2, Due: now}}}},
+		"transação": {Prepared: []remotelist.PreparedTx{{ID: "tx1"}}},
+	} {
+		bl := open()
+		if _, err := bl.ImportOffline(st, now); err == nil || !strings.Contains(err.Error(), "o bbolt não guarda") {
+			t.Errorf("%s: ImportOffline = %v; esperado erro", name, err)
+		}
+		if imported, _ := bl.Imported(); imported || len(lists(bl)) != 0 {
+			t.Errorf("%s: banco alterado por uma importação recusada: %q", name, lists(bl))
+		}
+	}
+
+	// Um banco com listas e sem a marca é de antes dela: não é sobrescrito.
+	bl = open()
+	if err := bl.Append(remotelist.AppendArgs{ListID: "a", Value: 9}, &remotelist.AppendReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := bl.ImportOffline(&remotelist.OfflineState{Lists: map[string][]int{"a": {1}, "b": {2}}}, now); err != nil || n != 0 {
+		t.Fatalf("ImportOffline num banco com listas = %d, %v", n, err)
+	}
+	var data remotelist.ExportListReply
+	if err := bl.Peer().ExportList(remotelist.ExportListArgs{ListID: "a"}, &data); err != nil || !reflect.DeepEqual(data.Values, []int{9}) || len(lists(bl)) != 1 {
+		t.Fatalf("banco com listas alterado pela importação: a = %v, %v; listas %q", data.Values, err, lists(bl))
+	}
+	if imported, _ := bl.Imported(); !imported {
+		t.Fatal("banco com listas sem a marca depois de ImportOffline")
+	}
+}
@@ -0,0 +1,498 @@
+package remotelist
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// --- Conexões do servidor ---
+//
+// ConnServer substitui o laço de Accept com "go rpcs.ServeConn(conn)", que
+// atende quantas conexões chegarem e deixa um cliente lento ou abandonado
+// segurar a conexão (e a goroutine) para sempre. ConnConfig define:
+//
+//   - MaxConns: conexões atendidas ao mesmo tempo. Acima disso o Accept
+//     espera uma conexão fechar; os clientes novos ficam na fila do sistema;
+//   - IdleTimeout: tempo sem pedidos, com nenhum pedido sendo atendido, depois
+//     do qual a conexão é fechada;
+//   - ReadTimeout: tempo para um pedido chegar por inteiro depois do primeiro
+//     byte; WriteTimeout, para uma resposta ser escrita.
+//
+// Erros seguidos no Accept (falta de descritores, por exemplo) esperam cada vez
+// mais antes da próxima tentativa, de acceptMinDelay até acceptMaxDelay.
+//
+// A tabela de conexões é um serviço RPC à parte: registrado com o nome
+// "Conns", o método Connections lista cada conexão, há quanto tempo está aberta
+// e ociosa e quantos pedidos atendeu.
+
+// Espera entre erros seguidos no Accept.
+const (
+	acceptMinDelay = 5 * time.Millisecond
+	acceptMaxDelay = time.Second
+)
+
+// Estados de uma conexão em ConnInfo.
+const (
+	ConnIdle    = "ociosa"    // Esperando um pedido
+	ConnReading = "lendo"     // Lendo um pedido
+	ConnBusy    = "atendendo" // Com pedidos sendo atendidos
+	ConnClosing = "fechando"  // Fechada por um timeout ou erro, esperando os pedidos em curso
+)
+
+var (
+	errIdleTimeout = errors.New("conexão ociosa por tempo demais")
+	errReadTimeout = errors.New("pedido não chegou a tempo")
+)
+
+type ConnConfig struct {
+	MaxConns     int           // Conexões simultâneas (0 = sem limite)
+	IdleTimeout  time.Duration // Fecha a conexão sem pedidos por esse tempo (0 = nunca)
+	ReadTimeout  time.Duration // Tempo máximo para ler um pedido (0 = sem limite)
+	WriteTimeout time.Duration // Tempo máximo para escrever uma resposta (0 = sem limite)
+
+	// Limits, se não for nil, aplica às conexões o limite de pedidos e as
+	// métricas de recusas do servidor (ver RemoteList.ServeConn).
+	Limits *RemoteList
+	// PeerKey é a chave do serviço Peer nas conexões (ver peer.go); vazia,
+	// vale a de Limits, se houver.
+	PeerKey string
+}
+
+// ConnServer atende as conexões de um rpc.Server com os limites de ConnConfig.
+type ConnServer struct {
+	srv *rpc.Server
+	cfg ConnConfig
+	sem chan struct{} // nil = sem limite de conexões
+
+	accepted     atomic.Uint64
+	waited       atomic.Uint64 // Vezes em que o Accept esperou por MaxConns
+	idleClosed   atomic.Uint64
+	readTimeouts atomic.Uint64
+	acceptErrors atomic.Uint64
+
+	mu        sync.Mutex
+	nextID    uint64
+	conns     map[uint64]*serverCodec
+	listeners []net.Listener
+	closed    bool
+}
+
+func NewConnServer(srv *rpc.Server, cfg ConnConfig) *ConnServer {
+	s := &ConnServer{srv: srv, cfg: cfg, conns: make(map[uint64]*serverCodec)}
+	if cfg.MaxConns > 0 {
+		s.sem = make(chan struct{}, cfg.MaxConns)
+	}
+	return s
+}
+
+// Serve aceita conexões de l até ele (ou o ConnServer) ser fechado.
+func (s *ConnServer) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	s.listeners = append(s.listeners, l)
+	s.mu.Unlock()
+
+	var delay time.Duration
+	for {
+		if s.sem != nil {
+			select {
+			case s.sem <- struct{}{}:
+			default:
+				s.waited.Add(1)
+				log.Printf("Limite de %d conexões atingido; novas conexões esperam.", s.cfg.MaxConns)
+				s.sem <- struct{}{}
+			}
+		}
+		conn, err := l.Accept()
+		if err != nil {
+			s.release()
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			s.acceptErrors.Add(1)
+			delay = min(max(2*delay, acceptMinDelay), acceptMaxDelay)
+			log.Printf("Erro ao aceitar conexão: %v; tentando de novo em %v", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		s.accepted.Add(1)
+		log.Printf("Nova conexão de %s", conn.RemoteAddr())
+		go s.serveConn(conn)
+	}
+}
+
+func (s *ConnServer) release() {
+	if s.sem != nil {
+		<-s.sem
+	}
+}
+
+func (s *ConnServer) serveConn(conn net.Conn) {
+	defer s.release()
+	c := newServerCodec(conn, s.cfg.Limits)
+	if s.cfg.PeerKey != "" {
+		c.peerKey = s.cfg.PeerKey
+	}
+	c.idle, c.read, c.write = s.cfg.IdleTimeout, s.cfg.ReadTimeout, s.cfg.WriteTimeout
+	c.onTimeout = func(err error) {
+		if errors.Is(err, errIdleTimeout) {
+			s.idleClosed.Add(1)
+		} else {
+			s.readTimeouts.Add(1)
+		}
+		log.Printf("Conexão de %s fechada: %v", c.client, err)
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.nextID++
+	c.id = s.nextID
+	s.conns[c.id] = c
+	s.mu.Unlock()
+
+	s.srv.ServeCodec(c)
+
+	s.mu.Lock()
+	delete(s.conns, c.id)
+	s.mu.Unlock()
+}
+
+// Close para de aceitar conexões e fecha as abertas.
+func (s *ConnServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	for _, c := range s.conns {
+		c.conn.Close()
+	}
+	return nil
+}
+
+type ConnInfo struct {
+	ID       uint64
+	Remote   string
+	State    string // ConnIdle, ConnReading, ConnBusy ou ConnClosing
+	Opened   time.Time
+	LastUsed time.Time // Último pedido lido ou resposta escrita
+	Requests uint64    // Pedidos respondidos
+	Pending  int       // Pedidos sendo atendidos
+	Method   string    // Último método pedido
+}
+
+type ConnectionsArgs struct{}
+type ConnectionsReply struct {
+	Conns        []ConnInfo // Em ordem de abertura
+	MaxConns     int
+	IdleTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	Accepted     uint64 // Conexões aceitas desde o início
+	Waited       uint64 // Vezes em que o Accept esperou uma conexão fechar
+	IdleClosed   uint64 // Conexões fechadas por IdleTimeout
+	ReadTimeouts uint64 // Conexões fechadas por ReadTimeout ou WriteTimeout
+	AcceptErrors uint64
+}
+
+// Connections lista as conexões abertas (inclusive a de quem chama).
+func (s *ConnServer) Connections(args ConnectionsArgs, reply *ConnectionsReply) error {
+	s.mu.Lock()
+	for _, c := range s.conns {
+		reply.Conns = append(reply.Conns, c.info())
+	}
+	s.mu.Unlock()
+	sort.Slice(reply.Conns, func(i, j int) bool { return reply.Conns[i].ID < reply.Conns[j].ID })
+
+	reply.MaxConns = s.cfg.MaxConns
+	reply.IdleTimeout, reply.ReadTimeout, reply.WriteTimeout = s.cfg.IdleTimeout, s.cfg.ReadTimeout, s.cfg.WriteTimeout
+	reply.Accepted, reply.Waited = s.accepted.Load(), s.waited.Load()
+	reply.IdleClosed, reply.ReadTimeouts = s.idleClosed.Load(), s.readTimeouts.Load()
+	reply.AcceptErrors = s.acceptErrors.Load()
+	return nil
+}
+
+// --- Codec das conexões ---
+
+// serverCodec é o codec gob do net/rpc com os timeouts de ConnServer e o limite
+// de pedidos de RemoteList.ServeConn. Um pedido acima do limite é lido por
+// inteiro e recusado em ReadRequestBody: o net/rpc responde com o erro e segue
+// para o próximo pedido. Os pedidos do serviço Peer são conferidos do mesmo
+// jeito: só passam numa conexão identificada por Peer.Auth (ou local, sem
+// chave), e não contam no limite de pedidos.
+type serverCodec struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+
+	rl      *RemoteList // nil = sem limites de pedidos
+	client  string
+	bucket  *tokenBucket // nil = sem limite de pedidos
+	peerKey string       // Chave do serviço Peer ("" = só conexões locais)
+	peer    bool         // A conexão se identificou com Peer.Auth
+	call    string       // Método do serviço Peer sendo lido ("" = outro serviço)
+
+	id                uint64
+	idle, read, write time.Duration
+	onTimeout         func(error)
+	opened            time.Time
+
+	mu       sync.Mutex // Protege os campos abaixo, que a goroutine das respostas também usa
+	failed   error      // Timeout no meio de um pedido: a conexão não serve mais
+	state    string
+	lastUsed time.Time
+	requests uint64
+	pending  int
+	method   string
+}
+
+func newServerCodec(conn net.Conn, rl *RemoteList) *serverCodec {
+	br := bufio.NewReader(conn)
+	buf := bufio.NewWriter(conn)
+	now := time.Now()
+	c := &serverCodec{
+		conn:     conn,
+		br:       br,
+		dec:      gob.NewDecoder(br),
+		enc:      gob.NewEncoder(buf),
+		encBuf:   buf,
+		rl:       rl,
+		client:   conn.RemoteAddr().String(),
+		opened:   now,
+		state:    ConnIdle,
+		lastUsed: now,
+	}
+	if rl != nil {
+		c.peerKey = rl.cfg.PeerKey
+		if rl.cfg.RateLimit > 0 {
+			c.bucket = newTokenBucket(rl.cfg.RateLimit, rl.rateBurst())
+		}
+	}
+	return c
+}
+
+func (c *serverCodec) info() ConnInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ConnInfo{ID: c.id, Remote: c.client, State: c.state, Opened: c.opened, LastUsed: c.lastUsed,
+		Requests: c.requests, Pending: c.pending, Method: c.method}
+}
+
+// waitRequest espera o primeiro byte do próximo pedido; o ReadTimeout só
+// começa a contar depois dele. A conexão só conta como ociosa sem pedidos
+// pendentes: uma chamada demorada não a derruba.
+func (c *serverCodec) waitRequest() error {
+	if c.idle <= 0 {
+		if c.read > 0 {
+			c.conn.SetReadDeadline(time.Time{})
+		}
+		_, err := c.br.Peek(1)
+		return err
+	}
+	for {
+		c.mu.Lock()
+		deadline := c.lastUsed.Add(c.idle)
+		if c.pending > 0 {
+			deadline = time.Now().Add(c.idle)
+		}
+		c.mu.Unlock()
+		c.conn.SetReadDeadline(deadline)
+		_, err := c.br.Peek(1)
+		if err == nil {
+			return nil
+		}
+		if !isTimeout(err) {
+			return err
+		}
+		c.mu.Lock()
+		idle := c.pending == 0 && time.Since(c.lastUsed) >= c.idle
+		c.mu.Unlock()
+		if idle {
+			return c.fail(errIdleTimeout)
+		}
+	}
+}
+
+// fail marca a conexão como perdida por um timeout; o net/rpc a fecha depois
+// de responder aos pedidos em curso.
+func (c *serverCodec) fail(err error) error {
+	c.mu.Lock()
+	first := c.failed == nil
+	if first {
+		c.failed, c.state = err, ConnClosing
+	}
+	c.mu.Unlock()
+	if first && c.onTimeout != nil {
+		c.onTimeout(err)
+	}
+	return err
+}
+
+func (c *serverCodec) setState(state string) {
+	c.mu.Lock()
+	c.state = state
+	c.mu.Unlock()
+}
+
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	c.mu.Lock()
+	failed := c.failed
+	c.mu.Unlock()
+	if failed != nil {
+		return failed
+	}
+	if err := c.waitRequest(); err != nil {
+		return err
+	}
+	if c.read > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.read))
+	}
+	c.setState(ConnReading)
+	if err := c.dec.Decode(r); err != nil {
+		if isTimeout(err) {
+			return c.fail(errReadTimeout)
+		}
+		return err
+	}
+	c.call = ""
+	if service, method, _ := strings.Cut(r.ServiceMethod, "."); service == peerService {
+		c.call = method
+	}
+
+	// Daqui em diante o net/rpc sempre responde ao pedido.
+	c.mu.Lock()
+	c.pending++
+	c.method = r.ServiceMethod
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *serverCodec) ReadRequestBody(body any) error {
+	err := c.dec.Decode(body)
+	c.mu.Lock()
+	c.lastUsed = time.Now()
+	c.state = ConnBusy
+	c.mu.Unlock()
+	if err != nil {
+		if isTimeout(err) {
+			// O resto do pedido ficou no caminho: não dá para ler o próximo.
+			return c.fail(errReadTimeout)
+		}
+		return err
+	}
+	if c.call != "" {
+		return c.checkPeer(body)
+	}
+	if c.bucket != nil && !c.bucket.take(time.Now()) {
+		return &LimitError{Code: CodeRateLimited,
+			Msg: fmt.Sprintf("mais de %g pedidos por segundo nesta conexão", c.bucket.rate)}
+	}
+	return nil
+}
+
+// checkPeer confere um pedido do serviço Peer. Peer.Auth com a chave certa
+// identifica a conexão; sem chave configurada, só conexões locais são atendidas.
+func (c *serverCodec) checkPeer(body any) error {
+	if c.peerKey == "" {
+		if !isLoopback(c.conn.RemoteAddr()) {
+			return errPeerRemote
+		}
+		return nil
+	}
+	if c.call == "Auth" {
+		args, ok := body.(*PeerAuthArgs)
+		if !ok || !peerAuth(c.peerKey, args.Key) {
+			return errPeerKey
+		}
+		c.peer = true
+		return nil
+	}
+	if !c.peer {
+		return errPeerAuth
+	}
+	return nil
+}
+
+func (c *serverCodec) WriteResponse(r *rpc.Response, body any) (err error) {
+	defer c.responded()
+	if c.rl != nil {
+		if code := limitCodeOf(r.Error); code != "" {
+			c.rl.limitStats.note(&c.rl.limitStats.clients, c.client, code)
+		}
+	}
+	if c.write > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.write))
+		defer func() {
+			if err != nil && isTimeout(err) {
+				// Cliente que não lê as respostas: a conexão é fechada, o que
+				// também encerra a leitura dos pedidos.
+				c.conn.Close()
+				c.fail(fmt.Errorf("resposta não foi escrita a tempo: %w", err))
+			}
+		}()
+	}
+	if err = c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			// A resposta não pôde ser codificada: a conexão fica inutilizável.
+			log.Println("rpc: erro ao codificar resposta:", err)
+			c.Close()
+		}
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			log.Println("rpc: erro ao codificar corpo da resposta:", err)
+			c.Close()
+		}
+		return
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *serverCodec) responded() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending--
+	c.requests++
+	c.lastUsed = time.Now()
+	if c.pending == 0 && c.state == ConnBusy {
+		c.state = ConnIdle
+	}
+}
+
+func (c *serverCodec) Close() error {
+	if c.closed {
+		// Só fecha a conexão uma vez.
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
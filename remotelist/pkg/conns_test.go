@@ -0,0 +1,478 @@
+package remotelist_test
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+
+	"remotelist/pkg"
+)
+
+// Atendimento das conexões pelo ConnServer (limite de conexões, timeouts,
+// espera entre erros do Accept e a tabela de conexões), com servidores RPC
+// neste processo.
+
+// newConnCluster devolve um connCluster vazio, derrubado no fim do teste.
+func newConnCluster(t *testing.T) *connCluster {
+	c := &connCluster{}
+	t.Cleanup(c.close)
+	return c
+}
+
+// --- Servidor de teste ---
+
+// Check é o serviço de teste: chamadas rápidas, demoradas e com respostas
+// grandes.
+type Check struct{}
+
+func (Check) Echo(args int, reply *int) error {
+	*reply = args
+	return nil
+}
+
+func (Check) Sleep(d time.Duration, reply *bool) error {
+	time.Sleep(d)
+	*reply = true
+	return nil
+}
+
+func (Check) Big(n int, reply *[]byte) error {
+	*reply = make([]byte, n)
+	return nil
+}
+
+type connCluster struct {
+	servers []*remotelist.ConnServer
+	lists   []*remotelist.RemoteList
+	clients []io.Closer
+}
+
+// start sobe um ConnServer com o serviço Check, o serviço Conns e, se
+// cfg.Limits não for nil, o RemoteList.
+func (c *connCluster) start(cfg remotelist.ConnConfig) (*remotelist.ConnServer, string, error) {
+	rpcs := rpc.NewServer()
+	if err := rpcs.RegisterName("Check", Check{}); err != nil {
+		return nil, "", err
+	}
+	if cfg.Limits != nil {
+		if err := rpcs.RegisterName("RemoteList", cfg.Limits); err != nil {
+			return nil, "", err
+		}
+	}
+	s := remotelist.NewConnServer(rpcs, cfg)
+	if err := rpcs.RegisterName("Conns", s); err != nil {
+		return nil, "", err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", err
+	}
+	c.servers = append(c.servers, s)
+	go s.Serve(l)
+	return s, l.Addr().String(), nil
+}
+
+func (c *connCluster) close() {
+	for _, cl := range c.clients {
+		cl.Close()
+	}
+	for _, s := range c.servers {
+		s.Close()
+	}
+	for _, rl := range c.lists {
+		rl.Close()
+	}
+}
+
+func (c *connCluster) dial(addr string) (*rpc.Client, error) {
+	cl, err := rpc.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c.clients = append(c.clients, cl)
+	return cl, nil
+}
+
+// dialRaw abre uma conexão sem cliente RPC, para mandar pedidos pela metade.
+func (c *connCluster) dialRaw(addr string) (net.Conn, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c.clients = append(c.clients, conn)
+	return conn, nil
+}
+
+func echo(cl *rpc.Client, v int) error {
+	var reply int
+	if err := cl.Call("Check.Echo", v, &reply); err != nil {
+		return err
+	}
+	if reply != v {
+		return fmt.Errorf("Echo(%d) devolveu %d", v, reply)
+	}
+	return nil
+}
+
+// callAsync faz um Echo sem esperar a resposta.
+func callAsync(cl *rpc.Client, v int) *rpc.Call {
+	var reply int
+	return cl.Go("Check.Echo", v, &reply, make(chan *rpc.Call, 1))
+}
+
+func connections(cl *rpc.Client) (remotelist.ConnectionsReply, error) {
+	var reply remotelist.ConnectionsReply
+	err := cl.Call("Conns.Connections", remotelist.ConnectionsArgs{}, &reply)
+	return reply, err
+}
+
+// closedWithin espera o servidor fechar a conexão crua conn.
+func closedWithin(conn net.Conn, d time.Duration) error {
+	conn.SetReadDeadline(time.Now().Add(d))
+	buf := make([]byte, 1024)
+	for {
+		_, err := conn.Read(buf)
+		if err == nil {
+			continue
+		}
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			return fmt.Errorf("a conexão continua aberta depois de %v", d)
+		}
+		return nil
+	}
+}
+
+// --- Cenários ---
+
+func TestConnMaxConns(t *testing.T) {
+	c := newConnCluster(t)
+	s, addr, err := c.start(remotelist.ConnConfig{MaxConns: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := c.dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := echo(a, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := echo(b, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// A terceira conexão fica na fila do sistema: o pedido não é atendido.
+	third, err := c.dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := callAsync(third, 3)
+	select {
+	case <-call.Done:
+		t.Fatalf("terceira conexão atendida acima do limite (erro %v)", call.Error)
+	case <-time.After(200 * time.Millisecond):
+	}
+	var reply remotelist.ConnectionsReply
+	if err := s.Connections(remotelist.ConnectionsArgs{}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Conns) != 2 || reply.Waited == 0 {
+		t.Fatalf("%d conexões na tabela e %d esperas, esperado 2 e ao menos 1", len(reply.Conns), reply.Waited)
+	}
+
+	a.Close()
+	select {
+	case <-call.Done:
+		if call.Error != nil {
+			t.Fatalf("terceira conexão: %v", call.Error)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("terceira conexão não foi atendida depois que outra fechou")
+	}
+	if err := echo(b, 4); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnIdle(t *testing.T) {
+	c := newConnCluster(t)
+	const idle = 200 * time.Millisecond
+	s, addr, err := c.start(remotelist.ConnConfig{IdleTimeout: idle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	quiet, err := c.dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy, err := c.dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow, err := c.dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := echo(quiet, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// busy faz pedidos a cada idle/4; slow espera uma chamada de 3*idle e
+	// depois também faz pedidos.
+	var done bool
+	sleep := slow.Go("Check.Sleep", 3*idle, &done, make(chan *rpc.Call, 1))
+	start := time.Now()
+	for time.Since(start) < 4*idle {
+		if err := echo(busy, 1); err != nil {
+			t.Fatalf("conexão em uso fechada: %v", err)
+		}
+		if sleep.Done == nil {
+			if err := echo(slow, 2); err != nil {
+				t.Fatalf("conexão depois da chamada demorada: %v", err)
+			}
+		}
+		select {
+		case <-sleep.Done:
+			if sleep.Error != nil {
+				t.Fatalf("conexão esperando uma chamada demorada: %v", sleep.Error)
+			}
+			sleep.Done = nil
+		case <-time.After(idle / 4):
+		}
+	}
+	if sleep.Done != nil {
+		t.Fatal("a chamada demorada não terminou")
+	}
+	if err := echo(quiet, 3); err == nil {
+		t.Fatalf("conexão ociosa por %v continuou aberta", 4*idle)
+	}
+	var reply remotelist.ConnectionsReply
+	if err := s.Connections(remotelist.ConnectionsArgs{}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.IdleClosed != 1 || len(reply.Conns) != 2 {
+		t.Fatalf("%d conexões fechadas por ociosidade e %d abertas, esperado 1 e 2", reply.IdleClosed, len(reply.Conns))
+	}
+}
+
+func TestConnReadTimeout(t *testing.T) {
+	c := newConnCluster(t)
+	const read = 150 * time.Millisecond
+	s, addr, err := c.start(remotelist.ConnConfig{ReadTimeout: read})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sem bytes, a conexão não tem pedido em curso e fica aberta.
+	silent, err := c.dialRaw(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	half, err := c.dialRaw(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Um cabeçalho gob pela metade.
+	if _, err := half.Write([]byte{0x40}); err != nil {
+		t.Fatal(err)
+	}
+	if err := closedWithin(half, 10*read); err != nil {
+		t.Fatalf("pedido pela metade: %v", err)
+	}
+	if err := closedWithin(silent, 2*read); err == nil {
+		t.Fatal("conexão sem pedidos fechada pelo ReadTimeout")
+	}
+
+	// Um pedido inteiro, depois da espera, é atendido normalmente.
+	silent.SetReadDeadline(time.Time{})
+	cl := rpc.NewClient(silent)
+	if err := echo(cl, 7); err != nil {
+		t.Fatalf("pedido depois da espera: %v", err)
+	}
+	var reply remotelist.ConnectionsReply
+	if err := s.Connections(remotelist.ConnectionsArgs{}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.ReadTimeouts != 1 {
+		t.Fatalf("%d conexões fechadas por timeout, esperado 1", reply.ReadTimeouts)
+	}
+}
+
+func TestConnWriteTimeout(t *testing.T) {
+	c := newConnCluster(t)
+	const write = 200 * time.Millisecond
+	s, addr, err := c.start(remotelist.ConnConfig{WriteTimeout: write})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := c.dialRaw(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pede respostas grandes e nunca as lê: os buffers enchem e a escrita para.
+	enc := gob.NewEncoder(conn)
+	for seq := uint64(0); seq < 16; seq++ {
+		if err := enc.Encode(rpc.Request{ServiceMethod: "Check.Big", Seq: seq}); err != nil {
+			break
+		}
+		if err := enc.Encode(16 << 20); err != nil {
+			break
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var reply remotelist.ConnectionsReply
+		if err := s.Connections(remotelist.ConnectionsArgs{}, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.ReadTimeouts == 1 && len(reply.Conns) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d timeouts e %d conexões abertas, esperado 1 e 0", reply.ReadTimeouts, len(reply.Conns))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// failingListener devolve erros no Accept e depois se diz fechado.
+type failingListener struct {
+	net.Listener
+	errors int
+	times  []time.Time
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.times = append(l.times, time.Now())
+	if len(l.times) > l.errors {
+		return nil, net.ErrClosed
+	}
+	return nil, errors.New("too many open files")
+}
+
+func TestConnBackoff(t *testing.T) {
+	s := remotelist.NewConnServer(rpc.NewServer(), remotelist.ConnConfig{})
+	l := &failingListener{errors: 6}
+	if err := s.Serve(l); err != nil {
+		t.Fatal(err)
+	}
+	// As esperas dobram a partir de 5ms: 5, 10, 20, 40, 80 e 160ms.
+	for i := 1; i < len(l.times); i++ {
+		want := (5 * time.Millisecond) << (i - 1)
+		if got := l.times[i].Sub(l.times[i-1]); got < want || got > want+100*time.Millisecond {
+			t.Fatalf("espera %d: %v, esperado %v", i, got, want)
+		}
+	}
+	var reply remotelist.ConnectionsReply
+	if err := s.Connections(remotelist.ConnectionsArgs{}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.AcceptErrors != 6 {
+		t.Fatalf("%d erros contados, esperado 6", reply.AcceptErrors)
+	}
+}
+
+func TestConnTable(t *testing.T) {
+	c := newConnCluster(t)
+	_, addr, err := c.start(remotelist.ConnConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := c.dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := echo(b, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var done bool
+	if err := b.Call("Check.Sleep", time.Millisecond, &done); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := connections(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Conns) != 2 || reply.Accepted != 2 {
+		t.Fatalf("%d conexões na tabela e %d aceitas, esperado 2 e 2", len(reply.Conns), reply.Accepted)
+	}
+	ca, cb := reply.Conns[0], reply.Conns[1]
+	// A chamada de a ainda está sendo atendida quando a tabela é montada.
+	if ca.Pending != 1 || ca.State != remotelist.ConnBusy || ca.Method != "Conns.Connections" {
+		t.Fatalf("conexão de a: %+v", ca)
+	}
+	if cb.Requests != 4 || cb.Pending != 0 || cb.State != remotelist.ConnIdle || cb.Method != "Check.Sleep" {
+		t.Fatalf("conexão de b: %+v", cb)
+	}
+	if !strings.HasPrefix(cb.Remote, "127.0.0.1:") || cb.Opened.After(cb.LastUsed) {
+		t.Fatalf("conexão de b: %+v", cb)
+	}
+
+	// Uma conexão fechada sai da tabela.
+	b.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		reply, err := connections(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reply.Conns) == 1 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d conexões na tabela depois de fechar b", len(reply.Conns))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConnLimits(t *testing.T) {
+	c := newConnCluster(t)
+	mem := remotelist.NewMemFS()
+	mem.MkdirAll("data", 0755)
+	rl, err := remotelist.NewRemoteListWithConfig(remotelist.Config{Dir: "data", FS: mem, SnapshotInterval: -1, RateLimit: 1, RateBurst: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.lists = append(c.lists, rl)
+	_, addr, err := c.start(remotelist.ConnConfig{Limits: rl, IdleTimeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, err := c.dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		err = cl.Call("RemoteList.Append", remotelist.AppendArgs{ListID: "a", Value: i}, &remotelist.AppendReply{})
+		if i < 2 && err != nil {
+			t.Fatal(err)
+		}
+	}
+	if code := remotelist.LimitCode(err); code != remotelist.CodeRateLimited {
+		t.Fatalf("terceiro Append: erro %v, esperado %s", err, remotelist.CodeRateLimited)
+	}
+	var st remotelist.LimitStatsReply
+	if err := rl.LimitStats(remotelist.LimitStatsArgs{}, &st); err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Clients) != 1 {
+		t.Fatalf("recusas de %d clientes, esperado 1", len(st.Clients))
+	}
+}
@@ -0,0 +1,235 @@
+package remotelist
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// --- Leituras em réplicas ---
+//
+// Um backup (ver replication.go) também serve de réplica de leitura. Get, Size
+// e Lists aceitam um nível de consistência (ReadOptions); o backup atende a
+// leitura localmente quando pode e, quando não, a encaminha ao primário
+// (Config.Primary):
+//
+//   - Eventual (padrão): o que a réplica tiver, sem limite de atraso;
+//   - Strong: sempre no primário;
+//   - BoundedStaleness: na réplica, se ela esteve em dia com o primário há no
+//     máximo MaxLag;
+//   - ReadYourWrites: na réplica, se ela já aplicou o registro Token (o número
+//     devolvido pelas escritas do cliente), esperando até readYourWritesWait.
+//
+// Para a réplica medir o próprio atraso, o primário informa em cada lote o
+// número do seu último registro, e manda um lote vazio quando fica
+// HeartbeatInterval sem nada a enviar. A réplica anota quando termina de
+// aplicar um lote que a deixa em dia; o atraso é o tempo desde então. A medida
+// usa só o relógio da réplica (não depende de relógios sincronizados) e não
+// conta o tempo de rede do lote.
+//
+// No primário, todas as leituras são locais.
+
+// Consistency é o nível de consistência de uma leitura.
+type Consistency int
+
+const (
+	Eventual Consistency = iota
+	Strong
+	BoundedStaleness
+	ReadYourWrites
+)
+
+func (c Consistency) String() string {
+	switch c {
+	case Eventual:
+		return "eventual"
+	case Strong:
+		return "strong"
+	case BoundedStaleness:
+		return "bounded"
+	case ReadYourWrites:
+		return "session"
+	}
+	return fmt.Sprintf("Consistency(%d)", int(c))
+}
+
+// ReadOptions acompanha cada leitura.
+type ReadOptions struct {
+	Consistency Consistency
+	MaxLag      time.Duration // BoundedStaleness: atraso máximo aceito
+	Token       uint64        // ReadYourWrites: maior Token devolvido pelas escritas do cliente
+}
+
+// ReplicaLag é o atraso de uma réplica em relação ao primário.
+type ReplicaLag struct {
+	PrimarySeq uint64        // Último registro que o primário informou ter
+	Records    uint64        // Registros do primário ainda não aplicados
+	Staleness  time.Duration // Tempo desde a última vez em dia (-1 = nunca esteve)
+}
+
+const (
+	// Intervalo padrão entre lotes vazios do primário para os backups.
+	defaultHeartbeatInterval = 250 * time.Millisecond
+	// Quanto uma leitura ReadYourWrites espera a réplica alcançar o Token.
+	readYourWritesWait = 200 * time.Millisecond
+)
+
+var errNoPrimary = errors.New("leitura precisa do primário, mas esta réplica não conhece o endereço dele (Config.Primary)")
+
+// follower é o que um backup sabe do primário.
+type follower struct {
+	mu         sync.Mutex
+	primarySeq uint64
+	caughtUp   time.Time     // Último instante em que a réplica estava em dia
+	changed    chan struct{} // Fechado (e trocado) quando a réplica avança
+	client     *rpc.Client   // Conexão com Config.Primary, aberta na primeira leitura encaminhada
+}
+
+// noteProgress registra que o backup aplicou até applied e que o primário
+// estava em primarySeq.
+func (rl *RemoteList) noteProgress(primarySeq, applied uint64) {
+	f := &rl.follow
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.primarySeq = primarySeq
+	if applied >= primarySeq {
+		f.caughtUp = time.Now()
+	}
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// replicaLag calcula o atraso atual do backup.
+func (rl *RemoteList) replicaLag() ReplicaLag {
+	rl.logLock.Lock()
+	applied := rl.seq
+	rl.logLock.Unlock()
+
+	f := &rl.follow
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lag := ReplicaLag{PrimarySeq: f.primarySeq, Staleness: -1}
+	if f.primarySeq > applied {
+		lag.Records = f.primarySeq - applied
+	}
+	if !f.caughtUp.IsZero() {
+		lag.Staleness = time.Since(f.caughtUp)
+	}
+	return lag
+}
+
+// waitApplied espera o backup aplicar o registro seq, por até timeout.
+func (rl *RemoteList) waitApplied(seq uint64, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		// O canal vem antes do número: um avanço depois da leitura fecha este canal.
+		rl.follow.mu.Lock()
+		changed := rl.follow.changed
+		rl.follow.mu.Unlock()
+		rl.logLock.Lock()
+		applied := rl.seq
+		rl.logLock.Unlock()
+		if applied >= seq {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return false
+		case <-rl.done:
+			return false
+		}
+	}
+}
+
+// routeRead decide onde a leitura é atendida. Devolve true se ela foi
+// encaminhada ao primário, com o resultado já em reply e o erro dele.
+//
+// Num backup, rl.seq avança (com o lock exclusivo do map) antes de os
+// registros serem aplicados, e o leitor só chega às listas depois: uma
+// leitura liberada aqui já vê tudo até o registro conferido.
+func (rl *RemoteList) routeRead(opts ReadOptions, method string, args, reply any) (bool, error) {
+	if !rl.readOnly.Load() {
+		return false, nil
+	}
+	switch opts.Consistency {
+	case Eventual:
+		return false, nil
+	case Strong:
+	case BoundedStaleness:
+		if lag := rl.replicaLag(); lag.Staleness >= 0 && lag.Staleness <= opts.MaxLag {
+			return false, nil
+		}
+	case ReadYourWrites:
+		if rl.waitApplied(opts.Token, readYourWritesWait) {
+			return false, nil
+		}
+	default:
+		return true, fmt.Errorf("nível de consistência inválido: %v", opts.Consistency)
+	}
+	return true, rl.callPrimary(method, args, reply)
+}
+
+// callPrimary faz a chamada no primário, reabrindo a conexão se ela caiu.
+// A conexão é aberta sem f.mu, que noteProgress também usa a cada lote do
+// primário: um primário lento para aceitar não atrasa a replicação. Se duas
+// leituras conectarem ao mesmo tempo, fica a conexão que chegou primeiro.
+func (rl *RemoteList) callPrimary(method string, args, reply any) error {
+	if rl.cfg.Primary == "" {
+		return errNoPrimary
+	}
+	f := &rl.follow
+	f.mu.Lock()
+	client := f.client
+	f.mu.Unlock()
+	if client == nil {
+		conn, err := net.DialTimeout("tcp", rl.cfg.Primary, 5*time.Second)
+		if err != nil {
+			return fmt.Errorf("falha ao conectar no primário %s: %w", rl.cfg.Primary, err)
+		}
+		dialed := rpc.NewClient(conn)
+		f.mu.Lock()
+		select {
+		case <-rl.done:
+			// Close já passou por closeFollower: ninguém fecharia esta conexão.
+			f.mu.Unlock()
+			dialed.Close()
+			return errors.New("servidor encerrado")
+		default:
+		}
+		if f.client == nil {
+			f.client = dialed
+		}
+		client = f.client
+		f.mu.Unlock()
+		if client != dialed {
+			dialed.Close()
+		}
+	}
+
+	err := client.Call("RemoteList."+method, args, reply)
+	if errors.Is(err, rpc.ErrShutdown) || errors.Is(err, io.ErrUnexpectedEOF) {
+		f.mu.Lock()
+		if f.client == client {
+			f.client = nil
+		}
+		f.mu.Unlock()
+		client.Close()
+	}
+	return err
+}
+
+// closeFollower fecha a conexão com o primário.
+func (rl *RemoteList) closeFollower() {
+	rl.follow.mu.Lock()
+	defer rl.follow.mu.Unlock()
+	if rl.follow.client != nil {
+		rl.follow.client.Close()
+		rl.follow.client = nil
+	}
+}
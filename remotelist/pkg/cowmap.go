@@ -0,0 +1,141 @@
+package remotelist
+
+import (
+	"hash/maphash"
+	"maps"
+)
+
+// cowBucketSize é o número médio de chaves em cada balde de um cowMap. Como
+// chunkSize para as listas: é o tamanho da cópia feita na primeira escrita após
+// um snapshot.
+const cowBucketSize = 1024
+
+// cowMap é o map dos sets, maps, sorted sets e grupos de streams, dividido em
+// baldes para o copy-on-write. Um snapshot não copia os dados: ele captura os
+// baldes atuais (capture) e, a partir daí, uma escrita copia só o balde que vai
+// alterar (como ownChunk nos blocos de uma ManagedList). Deve ser usado com o
+// lock da chave dona; as visões de capture(true) podem ser lidas sem lock.
+type cowMap[K comparable, V any] struct {
+	hash    func(K) uint64
+	buckets []map[K]V // len(buckets) é potência de 2
+	owned   []bool    // owned[i]: buckets[i] não é visto por nenhum snapshot
+	shared  bool      // O slice buckets em si também é visto por um snapshot
+	length  int
+}
+
+// cowView é uma fotografia imutável de um cowMap, obtida por capture.
+type cowView[K comparable, V any] struct {
+	buckets []map[K]V
+	length  int
+}
+
+func newCowMap[K comparable, V any](hash func(K) uint64) *cowMap[K, V] {
+	return &cowMap[K, V]{hash: hash, buckets: []map[K]V{{}}, owned: []bool{true}}
+}
+
+// hashSeed é a semente dos hashes das chaves de texto.
+var hashSeed = maphash.MakeSeed()
+
+func hashString(k string) uint64 {
+	return maphash.String(hashSeed, k)
+}
+
+// hashInteger espalha os bits de k (finalizador do splitmix64), para que
+// membros seguidos não caiam todos no mesmo balde.
+func hashInteger[K ~int | ~uint64](k K) uint64 {
+	x := uint64(k)
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+func (m *cowMap[K, V]) bucket(k K) int {
+	return int(m.hash(k) & uint64(len(m.buckets)-1))
+}
+
+func (m *cowMap[K, V]) len() int { return m.length }
+
+func (m *cowMap[K, V]) get(k K) (V, bool) {
+	v, ok := m.buckets[m.bucket(k)][k]
+	return v, ok
+}
+
+func (m *cowMap[K, V]) set(k K, v V) {
+	if _, ok := m.get(k); !ok {
+		if m.length >= len(m.buckets)*cowBucketSize {
+			m.grow()
+		}
+		m.length++
+	}
+	m.own(m.bucket(k))[k] = v
+}
+
+func (m *cowMap[K, V]) delete(k K) {
+	i := m.bucket(k)
+	if _, ok := m.buckets[i][k]; !ok {
+		return
+	}
+	delete(m.own(i), k)
+	m.length--
+}
+
+// each chama fn para cada chave, em ordem qualquer.
+func (m *cowMap[K, V]) each(fn func(K, V)) {
+	m.capture(false).each(fn)
+}
+
+// capture fotografa o map em tempo O(1). Com share, marca os baldes atuais
+// como compartilhados e a visão pode ser lida depois do lock; sem, ela só é
+// segura enquanto o chamador mantém o lock (como captureViewLocked nas listas).
+func (m *cowMap[K, V]) capture(share bool) cowView[K, V] {
+	if share {
+		m.shared = true
+	}
+	return cowView[K, V]{buckets: m.buckets, length: m.length}
+}
+
+// own garante que o balde i pode ser alterado sem afetar um snapshot e o
+// devolve. A primeira escrita após capture copia o slice de baldes (um ponteiro
+// por balde) e o balde alterado.
+func (m *cowMap[K, V]) own(i int) map[K]V {
+	if m.shared {
+		m.buckets = append([]map[K]V(nil), m.buckets...)
+		m.owned = make([]bool, len(m.buckets))
+		m.shared = false
+	}
+	if !m.owned[i] {
+		m.buckets[i] = maps.Clone(m.buckets[i])
+		m.owned[i] = true
+	}
+	return m.buckets[i]
+}
+
+// grow dobra o número de baldes. Os baldes novos não são de nenhum snapshot.
+func (m *cowMap[K, V]) grow() {
+	buckets := make([]map[K]V, 2*len(m.buckets))
+	for i := range buckets {
+		buckets[i] = make(map[K]V, cowBucketSize)
+	}
+	old := m.buckets
+	m.buckets, m.shared = buckets, false
+	m.owned = make([]bool, len(buckets))
+	for i := range m.owned {
+		m.owned[i] = true
+	}
+	for _, b := range old {
+		for k, v := range b {
+			m.buckets[m.bucket(k)][k] = v
+		}
+	}
+}
+
+func (v cowView[K, V]) len() int { return v.length }
+
+// each chama fn para cada chave da visão, em ordem qualquer.
+func (v cowView[K, V]) each(fn func(K, V)) {
+	for _, b := range v.buckets {
+		for k, val := range b {
+			fn(k, val)
+		}
+	}
+}
@@ -0,0 +1,496 @@
+package crdt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"remotelist/pkg"
+)
+
+// Config reúne os parâmetros de um nó.
+type Config struct {
+	// Replica identifica o nó nas operações; precisa ser único e estável
+	// (o mesmo depois de reiniciar).
+	Replica string
+	// Peers são os endereços dos outros nós, de onde o nó puxa operações.
+	Peers []string
+	// Path é o log de operações do nó ("" = crdt.log no diretório atual).
+	Path string
+	// FS é onde o log é gravado (nil = sistema de arquivos do SO).
+	FS remotelist.FS
+	// SyncInterval é o intervalo entre rodadas de anti-entropy (0 = 1s).
+	SyncInterval time.Duration
+	// Timeout limita cada chamada a outro nó (0 = 2s).
+	Timeout time.Duration
+}
+
+// InsertAtArgs insere Value na posição Index (0 = início, tamanho = fim).
+type InsertAtArgs struct {
+	ListID string
+	Index  int
+	Value  int
+}
+type InsertAtReply struct{}
+
+// Node é um nó multi-mestre. Registre-o com rpc.RegisterName("RemoteList", node)
+// para os clientes e node.Replica() como "CRDT" para os outros nós.
+//
+// Todo o estado fica em memória e é reconstruído do log ao iniciar. O log
+// guarda cada operação (local ou recebida) antes de ela ser integrada.
+type Node struct {
+	cfg  Config
+	fsys remotelist.FS
+
+	mu      sync.Mutex
+	lists   map[string]*RGA
+	clock   uint64            // Relógio de Lamport
+	have    map[string]uint64 // Vetor de versão: operações integradas por réplica
+	ops     []Op              // Operações integradas, na ordem de integração
+	pending []Op              // Recebidas, esperando operações anteriores
+	queued  map[opKey]bool    // Índice de pending
+	logFile remotelist.File
+	broken  bool // Uma escrita no log falhou: reescrever antes da próxima
+
+	peers *peers
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+type opKey struct {
+	replica string
+	seq     uint64
+}
+
+// NewNode reconstrói o estado a partir do log e começa a sincronizar com os outros nós.
+func NewNode(cfg Config) (*Node, error) {
+	if cfg.Replica == "" {
+		return nil, errors.New("informe o identificador da réplica")
+	}
+	if cfg.Path == "" {
+		cfg.Path = "crdt.log"
+	}
+	if cfg.SyncInterval == 0 {
+		cfg.SyncInterval = time.Second
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+	n := &Node{
+		cfg:    cfg,
+		fsys:   cfg.FS,
+		lists:  make(map[string]*RGA),
+		have:   make(map[string]uint64),
+		queued: make(map[opKey]bool),
+		peers:  newPeers(cfg.Timeout),
+		done:   make(chan struct{}),
+	}
+	if n.fsys == nil {
+		n.fsys = remotelist.OSFS{}
+	}
+	if err := n.load(); err != nil {
+		return nil, err
+	}
+	if len(cfg.Peers) > 0 {
+		n.wg.Add(1)
+		go n.syncLoop()
+	}
+	log.Printf("Nó CRDT %s iniciado (%d operações, %d pares).", cfg.Replica, len(n.ops), len(cfg.Peers))
+	return n, nil
+}
+
+// Close para a sincronização e fecha o log.
+func (n *Node) Close() error {
+	close(n.done)
+	n.wg.Wait()
+	n.peers.close()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.logFile == nil {
+		return nil
+	}
+	return n.logFile.Close()
+}
+
+// Replica devolve o serviço chamado pelos outros nós ("CRDT").
+func (n *Node) Replica() *Replica {
+	return &Replica{node: n}
+}
+
+// Values devolve o conteúdo da lista (nil se ela não existe aqui).
+func (n *Node) Values(listID string) []int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if r, ok := n.lists[listID]; ok {
+		return r.Values()
+	}
+	return nil
+}
+
+// Version devolve uma cópia do vetor de versão do nó.
+func (n *Node) Version() map[string]uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	out := make(map[string]uint64, len(n.have))
+	for r, seq := range n.have {
+		out[r] = seq
+	}
+	return out
+}
+
+// Pending conta as operações recebidas que ainda esperam dependências.
+func (n *Node) Pending() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.pending)
+}
+
+// Missing devolve as operações integradas aqui que um nó com o vetor de
+// versão have ainda não viu, em ordem de integração (no máximo limit; 0 = todas).
+func (n *Node) Missing(have map[string]uint64, limit int) []Op {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var out []Op
+	for _, op := range n.ops {
+		if op.Seq > have[op.Replica] {
+			out = append(out, op)
+			if limit > 0 && len(out) == limit {
+				break
+			}
+		}
+	}
+	return out
+}
+
+// Receive grava e integra operações de outros nós, em qualquer ordem e com
+// repetições. As que dependem de operações ainda não vistas ficam pendentes.
+func (n *Node) Receive(ops []Op) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var fresh []Op
+	for _, op := range ops {
+		k := opKey{op.Replica, op.Seq}
+		if op.Seq <= n.have[op.Replica] || n.queued[k] {
+			continue
+		}
+		if op.Replica == n.cfg.Replica {
+			// Só este nó gera operações com o seu nome: se ele não a conhece,
+			// o log dele foi perdido e aceitá-la faria o nó reusar números.
+			return fmt.Errorf("operação %d de %s desconhecida pela própria réplica", op.Seq, op.Replica)
+		}
+		n.queued[k] = true
+		fresh = append(fresh, op)
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+	if err := n.persist(fresh); err != nil {
+		for _, op := range fresh {
+			delete(n.queued, opKey{op.Replica, op.Seq})
+		}
+		return err
+	}
+	n.pending = append(n.pending, fresh...)
+	n.drain()
+	return nil
+}
+
+// --- Métodos RPC (mesma interface do RemoteList, mais InsertAt) ---
+
+// Append adiciona um valor ao final da lista, criando-a se não existir.
+func (n *Node) Append(args remotelist.AppendArgs, reply *remotelist.AppendReply) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	op := Op{Kind: OpInsert, Value: args.Value}
+	if r, ok := n.lists[args.ListID]; ok && r.Len() > 0 {
+		op.After, _, _ = r.visibleAt(r.Len() - 1)
+	}
+	if err := n.local(args.ListID, op); err != nil {
+		return err
+	}
+	reply.Success = true
+	return nil
+}
+
+// InsertAt insere um valor na posição Index, criando a lista se não existir.
+func (n *Node) InsertAt(args InsertAtArgs, reply *InsertAtReply) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	size := 0
+	r, ok := n.lists[args.ListID]
+	if ok {
+		size = r.Len()
+	}
+	if args.Index < 0 || args.Index > size {
+		return errors.New("índice fora dos limites")
+	}
+	op := Op{Kind: OpInsert, Value: args.Value}
+	if args.Index > 0 {
+		op.After, _, _ = r.visibleAt(args.Index - 1)
+	}
+	return n.local(args.ListID, op)
+}
+
+// Remove remove e retorna o último elemento da lista.
+func (n *Node) Remove(args remotelist.RemoveArgs, reply *remotelist.RemoveReply) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	r, ok := n.lists[args.ListID]
+	if !ok {
+		return errors.New("lista não encontrada")
+	}
+	if r.Len() == 0 {
+		return errors.New("lista vazia")
+	}
+	target, value, _ := r.visibleAt(r.Len() - 1)
+	if err := n.local(args.ListID, Op{Kind: OpRemove, Target: target}); err != nil {
+		return err
+	}
+	reply.Value = value
+	return nil
+}
+
+// Get retorna o valor no índice Index da lista.
+func (n *Node) Get(args remotelist.GetArgs, reply *remotelist.GetReply) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	r, ok := n.lists[args.ListID]
+	if !ok {
+		return errors.New("lista não encontrada")
+	}
+	_, value, ok := r.visibleAt(args.Index)
+	if args.Index < 0 || !ok {
+		return errors.New("índice fora dos limites")
+	}
+	reply.Value = value
+	return nil
+}
+
+// Size retorna o número de elementos da lista (0 se ela não existe).
+func (n *Node) Size(args remotelist.SizeArgs, reply *remotelist.SizeReply) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if r, ok := n.lists[args.ListID]; ok {
+		reply.Size = r.Len()
+	}
+	return nil
+}
+
+// Lists retorna os nomes das listas conhecidas por este nó, em ordem alfabética.
+func (n *Node) Lists(args remotelist.ListsArgs, reply *remotelist.ListsReply) error {
+	n.mu.Lock()
+	ids := make([]string, 0, len(n.lists))
+	for id := range n.lists {
+		ids = append(ids, id)
+	}
+	n.mu.Unlock()
+	sort.Strings(ids)
+	reply.ListIDs = ids
+	return nil
+}
+
+// --- Integração ---
+
+// local completa, grava e integra uma operação gerada por este nó.
+// Deve ser chamado com n.mu.
+func (n *Node) local(listID string, op Op) error {
+	op.Replica = n.cfg.Replica
+	op.Seq = n.have[n.cfg.Replica] + 1
+	op.List = listID
+	if op.Kind == OpInsert {
+		op.ID = ID{Time: n.clock + 1, Replica: n.cfg.Replica}
+	}
+	if err := n.persist([]Op{op}); err != nil {
+		log.Printf("Erro crítico de persistência (CRDT): %v", err)
+		return fmt.Errorf("falha ao gravar no disco (log CRDT): %w", err)
+	}
+	n.integrate(op)
+	return nil
+}
+
+// integrate aplica uma operação pronta. Deve ser chamado com n.mu.
+func (n *Node) integrate(op Op) {
+	r, ok := n.lists[op.List]
+	if !ok {
+		r = &RGA{}
+		n.lists[op.List] = r
+	}
+	r.apply(op)
+	n.clock = max(n.clock, op.ID.Time)
+	n.have[op.Replica] = op.Seq
+	n.ops = append(n.ops, op)
+}
+
+// drain integra as operações pendentes que ficaram prontas, até não haver
+// mais progresso. Deve ser chamado com n.mu.
+func (n *Node) drain() {
+	for progress := true; progress; {
+		progress = false
+		remaining := n.pending[:0]
+		for _, op := range n.pending {
+			if op.Seq == n.have[op.Replica]+1 && n.ready(op) {
+				n.integrate(op)
+				delete(n.queued, opKey{op.Replica, op.Seq})
+				progress = true
+				continue
+			}
+			remaining = append(remaining, op)
+		}
+		n.pending = remaining
+	}
+}
+
+func (n *Node) ready(op Op) bool {
+	r, ok := n.lists[op.List]
+	if !ok {
+		r = &RGA{}
+	}
+	return r.ready(op)
+}
+
+// --- Log de operações ---
+//
+// Uma operação por linha, em JSON, na ordem em que chegaram. Cada escrita é
+// sincronizada antes de a operação ser integrada (e, sendo local, confirmada
+// ao cliente), então uma queda só pode perder a última linha pela metade.
+
+// load lê o log, descarta uma linha final incompleta e reintegra as operações.
+func (n *Node) load() error {
+	data, err := n.readLog()
+	if err != nil {
+		return err
+	}
+	var ops []Op
+	valid := 0
+	for len(data[valid:]) > 0 {
+		line, _, ok := bytes.Cut(data[valid:], []byte("\n"))
+		var op Op
+		if !ok || json.Unmarshal(line, &op) != nil {
+			break
+		}
+		ops = append(ops, op)
+		valid += len(line) + 1
+	}
+	for _, op := range ops {
+		k := opKey{op.Replica, op.Seq}
+		if op.Seq <= n.have[op.Replica] || n.queued[k] {
+			continue
+		}
+		n.queued[k] = true
+		n.pending = append(n.pending, op)
+	}
+	n.drain()
+	if valid < len(data) {
+		log.Printf("Aviso: log CRDT %s com %d bytes finais inválidos; descartando.", n.cfg.Path, len(data)-valid)
+		return n.rewrite()
+	}
+	return n.openLog()
+}
+
+func (n *Node) readLog() ([]byte, error) {
+	f, err := n.fsys.OpenFile(n.cfg.Path, os.O_RDONLY, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("falha ao abrir log CRDT: %w", err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler log CRDT: %w", err)
+	}
+	return data, nil
+}
+
+func (n *Node) openLog() error {
+	f, err := n.fsys.OpenFile(n.cfg.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("falha ao abrir log CRDT: %w", err)
+	}
+	n.logFile = f
+	return nil
+}
+
+// persist acrescenta as operações ao log e sincroniza. Deve ser chamado com n.mu.
+func (n *Node) persist(ops []Op) error {
+	if n.broken {
+		if err := n.rewrite(); err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	for _, op := range ops {
+		line, err := json.Marshal(op)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if _, err := n.logFile.Write(buf.Bytes()); err != nil {
+		n.broken = true
+		return err
+	}
+	if err := n.logFile.Sync(); err != nil {
+		n.broken = true
+		return err
+	}
+	return nil
+}
+
+// rewrite regrava o log a partir da memória (integradas e pendentes), depois
+// de uma escrita que pode ter deixado uma linha pela metade. O arquivo novo é
+// escrito ao lado e trocado por rename. Deve ser chamado com n.mu.
+func (n *Node) rewrite() error {
+	tmp := n.cfg.Path + ".tmp"
+	f, err := n.fsys.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("falha ao regravar log CRDT: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, list := range [][]Op{n.ops, n.pending} {
+		for _, op := range list {
+			if err := enc.Encode(op); err != nil {
+				f.Close()
+				return err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("falha ao regravar log CRDT: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("falha ao regravar log CRDT: %w", err)
+	}
+	f.Close()
+	if n.logFile != nil {
+		n.logFile.Close()
+		n.logFile = nil
+	}
+	if err := n.fsys.Rename(tmp, n.cfg.Path); err != nil {
+		return fmt.Errorf("falha ao regravar log CRDT: %w", err)
+	}
+	dir := filepath.Dir(n.cfg.Path)
+	if err := n.fsys.SyncDir(dir); err != nil {
+		return fmt.Errorf("falha ao regravar log CRDT: %w", err)
+	}
+	if err := n.openLog(); err != nil {
+		return err
+	}
+	n.broken = false
+	return nil
+}
@@ -0,0 +1,411 @@
+package crdt_test
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"remotelist/pkg"
+	"remotelist/pkg/crdt"
+)
+
+// Listas multi-mestre (remotelist/pkg/crdt): comparação com um modelo,
+// convergência com entregas em qualquer ordem, quedas e nós reais por TCP.
+
+const (
+	numOps   = 300 // Operações por execução
+	replicas = 4   // Nós que geram operações
+)
+
+func newNode(name string, fsys remotelist.FS) (*crdt.Node, error) {
+	return crdt.NewNode(crdt.Config{Replica: name, Path: name + ".crdt.log", FS: fsys})
+}
+
+var listIDs = []string{"a", "b", "c"}
+
+// randomOp aplica uma operação aleatória (Append, InsertAt ou Remove) no nó.
+func randomOp(r *rand.Rand, n *crdt.Node) error {
+	id := listIDs[r.Intn(len(listIDs))]
+	var size remotelist.SizeReply
+	n.Size(remotelist.SizeArgs{ListID: id}, &size)
+	switch k := r.Intn(10); {
+	case k < 2 && size.Size > 0:
+		return n.Remove(remotelist.RemoveArgs{ListID: id}, &remotelist.RemoveReply{})
+	case k < 6:
+		args := crdt.InsertAtArgs{ListID: id, Index: r.Intn(size.Size + 1), Value: r.Intn(1000)}
+		return n.InsertAt(args, &crdt.InsertAtReply{})
+	default:
+		return n.Append(remotelist.AppendArgs{ListID: id, Value: r.Intn(1000)}, &remotelist.AppendReply{})
+	}
+}
+
+// sameState confere que os nós têm o mesmo conteúdo em todas as listas.
+func sameState(nodes []*crdt.Node, names []string) error {
+	for _, id := range listIDs {
+		want := nodes[0].Values(id)
+		for i, n := range nodes[1:] {
+			if got := n.Values(id); !slices.Equal(got, want) {
+				return fmt.Errorf("lista '%s' diverge: %v em %s, %v em %s", id, got, names[i+1], want, names[0])
+			}
+		}
+	}
+	for i, n := range nodes {
+		if p := n.Pending(); p > 0 {
+			return fmt.Errorf("%s ficou com %d operações pendentes", names[i], p)
+		}
+	}
+	return nil
+}
+
+// --- Cenários ---
+
+func TestSequential(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	n, err := newNode("seq", remotelist.NewMemFS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	model := make(map[string][]int)
+	for i := 0; i < numOps*10; i++ {
+		id := listIDs[r.Intn(len(listIDs))]
+		m := model[id]
+		switch k := r.Intn(10); {
+		case k < 3:
+			var reply remotelist.RemoveReply
+			err := n.Remove(remotelist.RemoveArgs{ListID: id}, &reply)
+			if len(m) == 0 {
+				if err == nil {
+					t.Fatalf("Remove(%s) numa lista vazia não falhou", id)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reply.Value != m[len(m)-1] {
+				t.Fatalf("Remove(%s) = %d, esperado %d", id, reply.Value, m[len(m)-1])
+			}
+			model[id] = m[:len(m)-1]
+		case k < 6:
+			idx, v := r.Intn(len(m)+1), r.Intn(1000)
+			if err := n.InsertAt(crdt.InsertAtArgs{ListID: id, Index: idx, Value: v}, &crdt.InsertAtReply{}); err != nil {
+				t.Fatal(err)
+			}
+			model[id] = slices.Insert(m, idx, v)
+		default:
+			v := r.Intn(1000)
+			if err := n.Append(remotelist.AppendArgs{ListID: id, Value: v}, &remotelist.AppendReply{}); err != nil {
+				t.Fatal(err)
+			}
+			model[id] = append(m, v)
+		}
+		if got := n.Values(id); !slices.Equal(got, model[id]) && len(got)+len(model[id]) > 0 {
+			t.Fatalf("operação %d: lista '%s' = %v, esperado %v", i, id, got, model[id])
+		}
+	}
+	for id, m := range model {
+		for i, want := range m {
+			var reply remotelist.GetReply
+			if err := n.Get(remotelist.GetArgs{ListID: id, Index: i}, &reply); err != nil {
+				t.Fatal(err)
+			}
+			if reply.Value != want {
+				t.Fatalf("Get(%s, %d) = %d, esperado %d", id, i, reply.Value, want)
+			}
+		}
+		if err := n.InsertAt(crdt.InsertAtArgs{ListID: id, Index: len(m) + 1}, &crdt.InsertAtReply{}); err == nil {
+			t.Fatalf("InsertAt(%s) além do fim não falhou", id)
+		}
+	}
+}
+
+func TestConvergence(t *testing.T) {
+	trials := 200
+	if testing.Short() {
+		trials = 20
+	}
+	for seed := 0; seed < trials; seed++ {
+		if err := convergenceTrial(int64(seed)); err != nil {
+			t.Fatalf("semente %d: %v", seed, err)
+		}
+	}
+}
+
+// convergenceTrial gera operações concorrentes em vários nós e entrega todas
+// a réplicas novas em ordens diferentes.
+func convergenceTrial(seed int64) error {
+	r := rand.New(rand.NewSource(seed))
+	var nodes []*crdt.Node
+	var names []string
+	defer func() {
+		for _, n := range nodes {
+			n.Close()
+		}
+	}()
+	for i := 0; i < replicas; i++ {
+		name := fmt.Sprintf("r%d", i)
+		n, err := newNode(name, remotelist.NewMemFS())
+		if err != nil {
+			return err
+		}
+		nodes, names = append(nodes, n), append(names, name)
+	}
+	origin := len(nodes)
+
+	// Cada nó só vê parte do que os outros fizeram: operações concorrentes de
+	// verdade, inclusive inserções no mesmo ponto e remoções do mesmo elemento.
+	for i := 0; i < numOps; i++ {
+		if err := randomOp(r, nodes[r.Intn(origin)]); err != nil {
+			return err
+		}
+		if r.Intn(10) == 0 {
+			from, to := nodes[r.Intn(origin)], nodes[r.Intn(origin)]
+			ops := from.Missing(to.Version(), 0)
+			r.Shuffle(len(ops), func(i, j int) { ops[i], ops[j] = ops[j], ops[i] })
+			if err := to.Receive(ops[:r.Intn(len(ops)+1)]); err != nil {
+				return err
+			}
+		}
+	}
+
+	var all []crdt.Op
+	for _, n := range nodes {
+		all = append(all, n.Missing(nil, 0)...)
+	}
+
+	// Réplicas novas recebem tudo em ordens diferentes, em lotes e com repetições.
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("x%d", i)
+		n, err := newNode(name, remotelist.NewMemFS())
+		if err != nil {
+			return err
+		}
+		nodes, names = append(nodes, n), append(names, name)
+		ops := slices.Clone(all)
+		for j := 0; j < len(all)/5; j++ {
+			ops = append(ops, all[r.Intn(len(all))])
+		}
+		r.Shuffle(len(ops), func(i, j int) { ops[i], ops[j] = ops[j], ops[i] })
+		for len(ops) > 0 {
+			k := 1 + r.Intn(min(len(ops), 20))
+			if err := n.Receive(ops[:k]); err != nil {
+				return err
+			}
+			ops = ops[k:]
+		}
+	}
+	for _, n := range nodes[:origin] {
+		ops := slices.Clone(all)
+		r.Shuffle(len(ops), func(i, j int) { ops[i], ops[j] = ops[j], ops[i] })
+		if err := n.Receive(ops); err != nil {
+			return err
+		}
+	}
+	return sameState(nodes, names)
+}
+
+func TestRestart(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	mem := remotelist.NewMemFS()
+	fault := remotelist.NewFaultFS(mem)
+	n, err := newNode("rst", fault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := newNode("peer", remotelist.NewMemFS())
+	if err != nil {
+		n.Close()
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	for i := 0; i < numOps; i++ {
+		if err := randomOp(r, n); err != nil {
+			n.Close()
+			t.Fatal(err)
+		}
+		if err := randomOp(r, peer); err != nil {
+			n.Close()
+			t.Fatal(err)
+		}
+		if i%50 == 0 {
+			n.Receive(peer.Missing(n.Version(), 0))
+		}
+	}
+
+	// Uma escrita interrompida: a operação falha e não aparece em lugar nenhum.
+	before := snapshot(n)
+	fault.Inject(remotelist.Fault{Op: remotelist.FaultWrite, Torn: true})
+	if err := n.Append(remotelist.AppendArgs{ListID: "a", Value: -1}, &remotelist.AppendReply{}); err == nil {
+		n.Close()
+		t.Fatal("Append com falha de disco não devolveu erro")
+	}
+	if fault.Injected() != 1 {
+		n.Close()
+		t.Fatal("nenhuma falha foi injetada")
+	}
+	if got := snapshot(n); got != before {
+		n.Close()
+		t.Fatalf("operação que falhou alterou o estado: %s, esperado %s", got, before)
+	}
+	// A queda acontece antes de o log ser reparado: sobra a linha pela metade.
+	crashed := mem.Crash()
+	n.Close()
+
+	n, err = newNode("rst", crashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := snapshot(n); got != before {
+		n.Close()
+		t.Fatalf("depois da queda: %s, esperado %s", got, before)
+	}
+	// O nó continua numerando as operações de onde parou.
+	for i := 0; i < 20; i++ {
+		if err := randomOp(r, n); err != nil {
+			n.Close()
+			t.Fatal(err)
+		}
+	}
+	if err := peer.Receive(n.Missing(peer.Version(), 0)); err != nil {
+		n.Close()
+		t.Fatal(err)
+	}
+	if err := n.Receive(peer.Missing(n.Version(), 0)); err != nil {
+		n.Close()
+		t.Fatal(err)
+	}
+	before = snapshot(n)
+	crashed = crashed.Crash()
+	n.Close()
+	n, err = newNode("rst", crashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	if got := snapshot(n); got != before {
+		t.Fatalf("depois da segunda queda: %s, esperado %s", got, before)
+	}
+	if err := sameState([]*crdt.Node{n, peer}, []string{"rst", "peer"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// snapshot descreve o conteúdo das listas e o vetor de versão do nó.
+func snapshot(n *crdt.Node) string {
+	out := fmt.Sprint(n.Version())
+	for _, id := range listIDs {
+		out += fmt.Sprintf(" %s=%v", id, n.Values(id))
+	}
+	return out
+}
+
+func TestNetwork(t *testing.T) {
+	const size = 3
+	var addrs []string
+	var listeners []net.Listener
+	for i := 0; i < size; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners = append(listeners, l)
+		addrs = append(addrs, l.Addr().String())
+	}
+	var nodes []*crdt.Node
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+		for _, n := range nodes {
+			n.Close()
+		}
+	}()
+	for i, l := range listeners {
+		peers := slices.Delete(slices.Clone(addrs), i, i+1)
+		n, err := crdt.NewNode(crdt.Config{
+			Replica:      fmt.Sprintf("n%d", i),
+			Peers:        peers,
+			Path:         "net.crdt.log",
+			FS:           remotelist.NewMemFS(),
+			SyncInterval: 20 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, n)
+		rpcs := rpc.NewServer()
+		if err := rpcs.RegisterName("RemoteList", n); err != nil {
+			t.Fatal(err)
+		}
+		if err := rpcs.RegisterName("CRDT", n.Replica()); err != nil {
+			t.Fatal(err)
+		}
+		go rpcs.Accept(l)
+	}
+
+	// Dois clientes por nó escrevem ao mesmo tempo nas mesmas listas.
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*size)
+	for c := 0; c < 2*size; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			client, err := rpc.Dial("tcp", addrs[c%size])
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer client.Close()
+			r := rand.New(rand.NewSource(int64(c)))
+			for i := 0; i < numOps/2; i++ {
+				id := listIDs[r.Intn(len(listIDs))]
+				var err error
+				switch r.Intn(4) {
+				case 0:
+					// A lista pode estar vazia neste nó: o erro é esperado.
+					client.Call("RemoteList.Remove", remotelist.RemoveArgs{ListID: id}, &remotelist.RemoveReply{})
+				case 1:
+					err = client.Call("RemoteList.InsertAt", crdt.InsertAtArgs{ListID: id, Value: c*10000 + i}, &crdt.InsertAtReply{})
+				default:
+					err = client.Call("RemoteList.Append", remotelist.AppendArgs{ListID: id, Value: c*10000 + i}, &remotelist.AppendReply{})
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"n0", "n1", "n2"}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		err := sameState(nodes, names)
+		if err == nil {
+			v := fmt.Sprint(nodes[0].Version())
+			for i, n := range nodes[1:] {
+				if got := fmt.Sprint(n.Version()); got != v {
+					err = fmt.Errorf("vetor de versão diverge: %s em %s, %s em n0", got, names[i+1], v)
+				}
+			}
+		}
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
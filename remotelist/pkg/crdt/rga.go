@@ -0,0 +1,152 @@
+// Package crdt oferece listas multi-mestre: vários servidores aceitam Append,
+// InsertAt e Remove ao mesmo tempo, sem coordenação, e convergem para o mesmo
+// conteúdo em qualquer ordem de entrega das operações.
+//
+// Cada lista é uma RGA (Replicated Growable Array). Todo elemento tem um
+// identificador único (relógio de Lamport, réplica) e é inserido à direita de
+// um elemento existente; remover só marca o elemento (tombstone). Inserções
+// concorrentes no mesmo ponto ficam em ordem decrescente de identificador, o
+// que dá o mesmo resultado em todas as réplicas.
+//
+// As operações de cada réplica são numeradas em sequência. Uma operação só é
+// integrada depois das anteriores da mesma réplica e dos elementos de que
+// depende; até lá fica pendente. A sincronização (anti-entropy) troca vetores
+// de versão: cada réplica pede às outras as operações que ainda não viu.
+package crdt
+
+import (
+	"fmt"
+)
+
+// ID identifica um elemento. O zero é o início da lista.
+type ID struct {
+	Time    uint64 // Relógio de Lamport de quem inseriu
+	Replica string
+}
+
+// Less ordena identificadores: primeiro o relógio, depois a réplica.
+func (id ID) Less(o ID) bool {
+	if id.Time != o.Time {
+		return id.Time < o.Time
+	}
+	return id.Replica < o.Replica
+}
+
+func (id ID) String() string {
+	return fmt.Sprintf("%d@%s", id.Time, id.Replica)
+}
+
+// Tipos de operação.
+const (
+	OpInsert = "insert"
+	OpRemove = "remove"
+)
+
+// Op é uma operação gerada por uma réplica sobre uma lista.
+type Op struct {
+	Replica string // Réplica que gerou a operação
+	Seq     uint64 // Número da operação nessa réplica (1, 2, ...)
+	List    string
+	Kind    string
+	ID      ID  // Insert: identificador do novo elemento
+	After   ID  // Insert: elemento à esquerda (zero = início)
+	Value   int // Insert
+	Target  ID  // Remove: elemento removido
+}
+
+type element struct {
+	id      ID
+	value   int
+	deleted bool
+}
+
+// RGA é uma lista replicada. Não é segura para uso concorrente.
+type RGA struct {
+	elems   []element
+	visible int
+}
+
+// has diz se o elemento já foi integrado (o zero sempre existe).
+func (r *RGA) has(id ID) bool {
+	return id == (ID{}) || r.find(id) >= 0
+}
+
+func (r *RGA) find(id ID) int {
+	for i := range r.elems {
+		if r.elems[i].id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// ready diz se as dependências da operação já estão na lista.
+func (r *RGA) ready(op Op) bool {
+	switch op.Kind {
+	case OpInsert:
+		return r.has(op.After)
+	case OpRemove:
+		return r.has(op.Target)
+	}
+	return true
+}
+
+// apply integra uma operação cujas dependências já estão na lista.
+// Reaplicar uma operação não muda nada.
+func (r *RGA) apply(op Op) {
+	switch op.Kind {
+	case OpInsert:
+		if r.find(op.ID) >= 0 {
+			return
+		}
+		i := 0
+		if op.After != (ID{}) {
+			i = r.find(op.After) + 1
+		}
+		// Pula os elementos inseridos no mesmo ponto por operações com
+		// identificador maior (e tudo que foi inserido depois deles, que tem
+		// relógio ainda maior).
+		for i < len(r.elems) && op.ID.Less(r.elems[i].id) {
+			i++
+		}
+		r.elems = append(r.elems, element{})
+		copy(r.elems[i+1:], r.elems[i:])
+		r.elems[i] = element{id: op.ID, value: op.Value}
+		r.visible++
+	case OpRemove:
+		if i := r.find(op.Target); i >= 0 && !r.elems[i].deleted {
+			r.elems[i].deleted = true
+			r.visible--
+		}
+	}
+}
+
+// Len devolve o número de elementos visíveis.
+func (r *RGA) Len() int {
+	return r.visible
+}
+
+// Values devolve os elementos visíveis, em ordem.
+func (r *RGA) Values() []int {
+	out := make([]int, 0, r.visible)
+	for _, e := range r.elems {
+		if !e.deleted {
+			out = append(out, e.value)
+		}
+	}
+	return out
+}
+
+// visibleAt devolve o identificador do i-ésimo elemento visível.
+func (r *RGA) visibleAt(i int) (ID, int, bool) {
+	for _, e := range r.elems {
+		if e.deleted {
+			continue
+		}
+		if i == 0 {
+			return e.id, e.value, true
+		}
+		i--
+	}
+	return ID{}, 0, false
+}
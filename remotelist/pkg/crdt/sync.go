@@ -0,0 +1,153 @@
+package crdt
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// syncBatch é o máximo de operações devolvidas por chamada de Sync.
+const syncBatch = 4096
+
+// --- Serviço entre nós ---
+
+type SyncArgs struct {
+	Have map[string]uint64 // Vetor de versão de quem pede
+}
+type SyncReply struct {
+	Ops  []Op
+	More bool // Há mais operações além do lote
+}
+
+// Replica é o serviço chamado pelos outros nós ("CRDT").
+type Replica struct {
+	node *Node
+}
+
+// Sync devolve as operações que quem chamou ainda não viu.
+func (r *Replica) Sync(args SyncArgs, reply *SyncReply) error {
+	reply.Ops = r.node.Missing(args.Have, syncBatch+1)
+	if len(reply.Ops) > syncBatch {
+		reply.Ops = reply.Ops[:syncBatch]
+		reply.More = true
+	}
+	return nil
+}
+
+// --- Anti-entropy ---
+//
+// Cada nó puxa periodicamente de cada par as operações que lhe faltam. Como
+// todos puxam de todos, uma operação aceita em qualquer nó chega aos outros
+// assim que eles se enxergam, sem coordenação e sem ordem de entrega.
+
+func (n *Node) syncLoop() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.SyncInterval)
+	defer ticker.Stop()
+	failing := make(map[string]bool)
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+		}
+		for _, addr := range n.cfg.Peers {
+			err := n.SyncWith(addr)
+			if err != nil && !failing[addr] {
+				log.Printf("Anti-entropy com %s falhou: %v", addr, err)
+			} else if err == nil && failing[addr] {
+				log.Printf("Anti-entropy com %s restabelecida.", addr)
+			}
+			failing[addr] = err != nil
+		}
+	}
+}
+
+// SyncWith puxa do nó em addr tudo o que este nó ainda não viu.
+func (n *Node) SyncWith(addr string) error {
+	for {
+		var reply SyncReply
+		if err := n.peers.call(addr, "CRDT.Sync", SyncArgs{Have: n.Version()}, &reply); err != nil {
+			return err
+		}
+		if err := n.Receive(reply.Ops); err != nil {
+			return err
+		}
+		if !reply.More {
+			return nil
+		}
+	}
+}
+
+// errTimeout é devolvido quando um par não responde a tempo.
+var errTimeout = errors.New("nó não respondeu a tempo")
+
+// peers mantém uma conexão por par, reaberta sob demanda.
+type peers struct {
+	timeout time.Duration
+
+	mu    sync.Mutex
+	conns map[string]*rpc.Client
+}
+
+func newPeers(timeout time.Duration) *peers {
+	return &peers{timeout: timeout, conns: make(map[string]*rpc.Client)}
+}
+
+func (p *peers) call(addr, method string, args, reply any) error {
+	client, err := p.client(addr)
+	if err != nil {
+		return err
+	}
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	select {
+	case call := <-client.Go(method, args, reply, make(chan *rpc.Call, 1)).Done:
+		var serverErr rpc.ServerError
+		if call.Error != nil && !errors.As(call.Error, &serverErr) {
+			p.drop(addr, client)
+		}
+		return call.Error
+	case <-timer.C:
+		p.drop(addr, client)
+		return fmt.Errorf("%s: %w", addr, errTimeout)
+	}
+}
+
+func (p *peers) client(addr string) (*rpc.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.conns[addr]; ok {
+		return c, nil
+	}
+	conn, err := net.DialTimeout("tcp", addr, p.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao conectar em %s: %w", addr, err)
+	}
+	c := rpc.NewClient(conn)
+	p.conns[addr] = c
+	return c, nil
+}
+
+// drop descarta a conexão se ela ainda for a que falhou.
+func (p *peers) drop(addr string, failed *rpc.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conns[addr] == failed {
+		failed.Close()
+		delete(p.conns, addr)
+	}
+}
+
+func (p *peers) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for addr, c := range p.conns {
+		c.Close()
+		delete(p.conns, addr)
+	}
+}
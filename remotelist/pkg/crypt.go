@@ -0,0 +1,697 @@
+package remotelist
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// --- Criptografia em repouso ---
+//
+// Com uma chave configurada, o RemoteList grava o log e os snapshots por um
+// EncryptedFS: cada arquivo começa com um cabeçalho (versão, chave usada e um
+// identificador aleatório do arquivo) e cada Write vira um bloco selado com
+// AES-GCM. O identificador do arquivo e a posição do bloco entram como dados
+// autenticados, então trocar, reordenar ou copiar blocos entre arquivos também
+// é detectado na leitura. O Close grava um bloco final, selado como tal e com o
+// número de blocos: sem ele, um arquivo cortado entre dois blocos pareceria
+// inteiro. Só o segmento mais novo do log pode não ter o bloco final (ainda
+// está sendo escrito ou o servidor caiu); na inicialização ele é regravado com
+// o bloco (ver reseal).
+//
+// Com uma chave configurada, um arquivo sem o cabeçalho é recusado: em texto
+// puro, ele poderia ter sido trocado à vontade. Para ligar a criptografia sobre
+// um diretório antigo, Config.MigratePlaintext aceita os arquivos em texto puro
+// na inicialização, e o snapshot feito logo em seguida recifra o estado. A
+// troca de chave é feita por um snapshot que recifra o estado com a chave nova
+// (ver RotateKey); a antiga só é necessária para ler o que ainda não foi
+// recifrado.
+
+// Variáveis de ambiente com a chave (ver LoadKey).
+const (
+	KeyEnv     = "REMOTELIST_KEY"      // Chave em hexadecimal
+	KeyFileEnv = "REMOTELIST_KEY_FILE" // Arquivo com a chave em hexadecimal
+	OldKeysEnv = "REMOTELIST_OLD_KEYS" // Chaves antigas, só para leitura, separadas por vírgula
+)
+
+// ErrDecrypt indica um arquivo cifrado que não pôde ser aberto: dados
+// adulterados ou cortados, chave errada ou chave desconhecida. Também vale para
+// um arquivo em texto puro quando há chave configurada.
+var ErrDecrypt = errors.New("falha ao decifrar (dados adulterados ou chave incorreta)")
+
+var (
+	// errUnsealed é um arquivo cifrado sem o bloco final. Só é aceito no
+	// segmento mais novo do log (ver openTail).
+	errUnsealed  = fmt.Errorf("%w: arquivo cifrado sem o bloco final", ErrDecrypt)
+	errPlaintext = fmt.Errorf("%w: arquivo em texto puro com a criptografia ligada", ErrDecrypt)
+)
+
+const (
+	cryptMagic     = "RLCRYPT1"
+	cryptHeaderLen = len(cryptMagic) + keyIDLen + fileIDLen
+	keyIDLen       = 8
+	fileIDLen      = 16
+	// Um Write maior é dividido em blocos deste tamanho (um snapshot é um
+	// único Write), e a leitura nunca precisa de mais que um bloco em memória.
+	maxFramePlain = 64 << 10
+	frameLenSize  = 4
+)
+
+type keyID [keyIDLen]byte
+
+// ParseKey interpreta uma chave AES em hexadecimal (16, 24 ou 32 bytes).
+func ParseKey(text string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(text))
+	if err != nil {
+		return nil, fmt.Errorf("chave inválida: %w", err)
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return nil, fmt.Errorf("chave inválida: %w", err)
+	}
+	return key, nil
+}
+
+// LoadKey lê a chave de keyFile ou, se ele for "", das variáveis de ambiente
+// REMOTELIST_KEY_FILE e REMOTELIST_KEY, nessa ordem. Sem nenhuma delas
+// devolve nil: os arquivos ficam em texto puro.
+func LoadKey(keyFile string) ([]byte, error) {
+	if keyFile == "" {
+		keyFile = os.Getenv(KeyFileEnv)
+	}
+	if keyFile != "" {
+		content, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao ler arquivo de chave: %w", err)
+		}
+		return ParseKey(string(content))
+	}
+	if text := os.Getenv(KeyEnv); text != "" {
+		return ParseKey(text)
+	}
+	return nil, nil
+}
+
+// LoadOldKeys lê as chaves antigas dos arquivos em keyFiles ou, se não houver
+// nenhum, da variável REMOTELIST_OLD_KEYS.
+func LoadOldKeys(keyFiles []string) ([][]byte, error) {
+	var keys [][]byte
+	for _, path := range keyFiles {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("falha ao ler arquivo de chave: %w", err)
+		}
+		key, err := ParseKey(string(content))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keyFiles) > 0 {
+		return keys, nil
+	}
+	for _, text := range strings.Split(os.Getenv(OldKeysEnv), ",") {
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		key, err := ParseKey(text)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Keyring guarda a chave usada nas escritas e as que ainda valem para leitura.
+type Keyring struct {
+	mu         sync.RWMutex
+	current    keyID
+	hasCurrent bool // Sem chave atual, as escritas ficam em texto puro
+	aeads      map[keyID]cipher.AEAD
+}
+
+// NewKeyring cria um Keyring que cifra com current e também decifra com old.
+// Com current nil, nada é cifrado, mas arquivos cifrados com old podem ser lidos.
+func NewKeyring(current []byte, old ...[]byte) (*Keyring, error) {
+	k := &Keyring{aeads: make(map[keyID]cipher.AEAD)}
+	for _, key := range old {
+		if _, err := k.add(key); err != nil {
+			return nil, err
+		}
+	}
+	if current != nil {
+		if err := k.Rotate(current); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Rotate passa a cifrar com key. As chaves anteriores continuam valendo para leitura.
+func (k *Keyring) Rotate(key []byte) error {
+	id, err := k.add(key)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	k.current, k.hasCurrent = id, true
+	k.mu.Unlock()
+	return nil
+}
+
+func (k *Keyring) add(key []byte) (keyID, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return keyID{}, fmt.Errorf("chave inválida: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return keyID{}, err
+	}
+	id := keyIDOf(key)
+	k.mu.Lock()
+	k.aeads[id] = aead
+	k.mu.Unlock()
+	return id, nil
+}
+
+// keyIDOf identifica uma chave no cabeçalho dos arquivos sem revelá-la.
+func keyIDOf(key []byte) keyID {
+	sum := sha256.Sum256(append([]byte("remotelist-key:"), key...))
+	var id keyID
+	copy(id[:], sum[:])
+	return id
+}
+
+// writer devolve a chave atual (ok falso se não houver).
+func (k *Keyring) writer() (id keyID, aead cipher.AEAD, ok bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current, k.aeads[k.current], k.hasCurrent
+}
+
+// encrypting diz se as escritas são cifradas.
+func (k *Keyring) encrypting() bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.hasCurrent
+}
+
+// reader devolve a chave id e se ela é a atual.
+func (k *Keyring) reader(id keyID) (cipher.AEAD, bool, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	aead, ok := k.aeads[id]
+	if !ok {
+		return nil, false, fmt.Errorf("%w: chave %x desconhecida", ErrDecrypt, id[:])
+	}
+	return aead, k.hasCurrent && id == k.current, nil
+}
+
+// EncryptedFS envolve outro FS cifrando o conteúdo dos arquivos com as chaves
+// de um Keyring. Os nomes e os tamanhos dos arquivos não são protegidos.
+// Sem chave atual, grava em texto puro e só recusa os arquivos cifrados com
+// chaves que não conhece.
+type EncryptedFS struct {
+	FS
+	keys *Keyring
+	// stale fica verdadeiro quando um arquivo em texto puro ou com uma chave
+	// antiga é lido: o estado precisa de um snapshot para ser recifrado.
+	stale atomic.Bool
+	// allowPlain aceita arquivos em texto puro mesmo com chave (migração, ver
+	// Config.MigratePlaintext).
+	allowPlain atomic.Bool
+}
+
+// NewEncryptedFS cria um EncryptedFS sobre inner.
+func NewEncryptedFS(inner FS, keys *Keyring) *EncryptedFS {
+	return &EncryptedFS{FS: inner, keys: keys}
+}
+
+// Keys devolve o Keyring do FS.
+func (e *EncryptedFS) Keys() *Keyring {
+	return e.keys
+}
+
+// takeStale diz se algum arquivo lido desde a última chamada não estava
+// cifrado com a chave atual.
+func (e *EncryptedFS) takeStale() bool {
+	return e.stale.Swap(false)
+}
+
+func (e *EncryptedFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		f, err := e.FS.OpenFile(name, flag, perm)
+		if err != nil {
+			return nil, err
+		}
+		return &decryptingFile{File: f, fs: e, name: name, r: bufio.NewReader(f)}, nil
+	}
+	if !e.keys.encrypting() {
+		return e.FS.OpenFile(name, flag, perm)
+	}
+
+	// Um arquivo que já tem blocos continua com a chave e a numeração dele.
+	var existing *cryptHeader
+	var frames uint64
+	if flag&os.O_TRUNC == 0 {
+		info, err := e.FS.Stat(name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if err == nil && info.Size() > 0 {
+			existing, frames, err = e.scanFrames(name)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	f, err := e.FS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	ef := &encryptingFile{File: f, name: name, frame: frames}
+	if existing != nil {
+		aead, _, err := e.keys.reader(existing.key)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		ef.header, ef.aead, ef.wroteHeader = *existing, aead, true
+	} else {
+		ef.header.key, ef.aead, _ = e.keys.writer()
+		if _, err := io.ReadFull(rand.Reader, ef.header.file[:]); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return ef, nil
+}
+
+// scanFrames lê o cabeçalho de um arquivo cifrado e conta os blocos dele, para
+// que novos blocos possam ser anexados. Um arquivo já fechado com o bloco final
+// não aceita mais nenhum.
+func (e *EncryptedFS) scanFrames(name string) (*cryptHeader, uint64, error) {
+	df, err := e.openTail(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer df.Close()
+	if _, err := io.Copy(io.Discard, df); err != nil {
+		return nil, 0, err
+	}
+	switch {
+	case df.plain:
+		return nil, 0, fmt.Errorf("%s está em texto puro: não é possível anexar blocos cifrados", name)
+	case df.sealed:
+		return nil, 0, fmt.Errorf("%s já tem o bloco final: não é possível anexar blocos", name)
+	case df.torn:
+		return nil, 0, fmt.Errorf("%s termina com um bloco cifrado incompleto", name)
+	}
+	return &df.header, df.frame, nil
+}
+
+// openTail abre name para leitura como o segmento mais novo do log: a falta do
+// bloco final e um bloco cortado no fim são uma escrita interrompida, não um
+// erro.
+func (e *EncryptedFS) openTail(name string) (*decryptingFile, error) {
+	f, err := e.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	df := f.(*decryptingFile)
+	df.tail = true
+	return df, nil
+}
+
+// openTail abre o segmento mais novo do log para leitura (ver
+// EncryptedFS.openTail). Em outros FS é o mesmo que openRead.
+func openTail(fsys FS, name string) (File, error) {
+	if e, ok := fsys.(*EncryptedFS); ok {
+		return e.openTail(name)
+	}
+	return openRead(fsys, name)
+}
+
+// reseal regrava com o bloco final um arquivo cifrado que ficou sem ele: o
+// segmento que estava sendo escrito quando o servidor caiu ou o abandonado
+// depois de uma escrita que falhou (ver repairLog). Fica o que havia até o
+// último bloco inteiro. Arquivos completos, em texto puro ou inexistentes não
+// mudam.
+func (e *EncryptedFS) reseal(name string) error {
+	df, err := e.openTail(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, df)
+	df.Close()
+	if err != nil || !df.cut {
+		return err
+	}
+
+	in, err := e.openTail(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tempFile := name + ".tmp"
+	out, err := createFile(e, tempFile)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		out.Close()
+		e.Remove(tempFile)
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		return fail(err)
+	}
+	if err := out.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := out.Close(); err != nil {
+		e.Remove(tempFile)
+		return err
+	}
+	if err := e.Rename(tempFile, name); err != nil {
+		return err
+	}
+	log.Printf("%s regravado com o bloco final (%d blocos).", name, in.frame)
+	return syncDir(e, filepath.Dir(name))
+}
+
+// cryptHeader é o cabeçalho de um arquivo cifrado.
+type cryptHeader struct {
+	key  keyID
+	file [fileIDLen]byte
+}
+
+func (h cryptHeader) encode() []byte {
+	b := make([]byte, 0, cryptHeaderLen)
+	b = append(b, cryptMagic...)
+	b = append(b, h.key[:]...)
+	return append(b, h.file[:]...)
+}
+
+// additionalData amarra o bloco ao arquivo e à posição dele, e diz se ele é o
+// bloco final.
+func (h cryptHeader) additionalData(frame uint64, final bool) []byte {
+	ad := make([]byte, 0, fileIDLen+9)
+	ad = append(ad, h.file[:]...)
+	ad = binary.BigEndian.AppendUint64(ad, frame)
+	if final {
+		return append(ad, 1)
+	}
+	return append(ad, 0)
+}
+
+// readCryptHeader lê o cabeçalho, se houver. encrypted é falso para um arquivo
+// em texto puro (nada é consumido de r); com encrypted verdadeiro e header nil,
+// o arquivo acaba no meio do cabeçalho (escrita interrompida do primeiro bloco).
+func readCryptHeader(r *bufio.Reader) (header *cryptHeader, encrypted bool, err error) {
+	peek, err := r.Peek(cryptHeaderLen)
+	if err != nil && err != io.EOF && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, false, err
+	}
+	if len(peek) == 0 {
+		return nil, false, nil
+	}
+	n := min(len(peek), len(cryptMagic))
+	if !bytes.Equal(peek[:n], []byte(cryptMagic)[:n]) {
+		return nil, false, nil
+	}
+	if len(peek) < cryptHeaderLen {
+		return nil, true, nil
+	}
+	var h cryptHeader
+	copy(h.key[:], peek[len(cryptMagic):])
+	copy(h.file[:], peek[len(cryptMagic)+keyIDLen:])
+	r.Discard(cryptHeaderLen)
+	return &h, true, nil
+}
+
+// encryptingFile é um arquivo aberto para escrita por um EncryptedFS.
+type encryptingFile struct {
+	File
+	name        string
+	header      cryptHeader
+	aead        cipher.AEAD
+	frame       uint64 // Número do próximo bloco
+	wroteHeader bool
+	failed      bool // Uma escrita falhou: o arquivo fica sem o bloco final
+}
+
+// Write sela p em um ou mais blocos e os grava de uma vez. O cabeçalho vai
+// junto com o primeiro bloco, então um arquivo nunca fica só com ele.
+func (ef *encryptingFile) Write(p []byte) (int, error) {
+	var out []byte
+	if !ef.wroteHeader {
+		out = ef.header.encode()
+	}
+	frame := ef.frame
+	for rest := p; len(rest) > 0; {
+		chunk := rest[:min(len(rest), maxFramePlain)]
+		rest = rest[len(chunk):]
+		var err error
+		if out, err = ef.seal(out, chunk, frame, false); err != nil {
+			return 0, err
+		}
+		frame++
+	}
+	if len(out) == 0 {
+		return 0, nil
+	}
+	// Uma escrita que falha pode deixar um bloco pela metade no fim do arquivo;
+	// quem escreve abandona o arquivo (ver repairLog), então a contagem não importa.
+	if _, err := ef.File.Write(out); err != nil {
+		ef.failed = true
+		return 0, err
+	}
+	ef.wroteHeader = true
+	ef.frame = frame
+	return len(p), nil
+}
+
+// seal acrescenta a out o bloco de número frame com o texto plain.
+func (ef *encryptingFile) seal(out, plain []byte, frame uint64, final bool) ([]byte, error) {
+	nonce := make([]byte, ef.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed := ef.aead.Seal(nonce, nonce, plain, ef.header.additionalData(frame, final))
+	out = binary.BigEndian.AppendUint32(out, uint32(len(sealed)))
+	return append(out, sealed...), nil
+}
+
+// Close grava e sincroniza o bloco final, com o número de blocos do arquivo.
+// Depois de uma escrita que falhou, o arquivo fica sem ele (ver reseal).
+func (ef *encryptingFile) Close() error {
+	if ef.failed {
+		return ef.File.Close()
+	}
+	var out []byte
+	if !ef.wroteHeader {
+		out = ef.header.encode()
+	}
+	out, err := ef.seal(out, binary.BigEndian.AppendUint64(nil, ef.frame), ef.frame, true)
+	if err == nil {
+		_, err = ef.File.Write(out)
+	}
+	if err == nil {
+		err = ef.File.Sync()
+	}
+	if err != nil {
+		ef.File.Close()
+		return fmt.Errorf("%s: falha ao gravar o bloco final: %w", ef.name, err)
+	}
+	return ef.File.Close()
+}
+
+func (ef *encryptingFile) Read(p []byte) (int, error) {
+	return 0, fmt.Errorf("%s: arquivo cifrado aberto para escrita não pode ser lido", ef.name)
+}
+
+// decryptingFile é um arquivo aberto para leitura por um EncryptedFS.
+type decryptingFile struct {
+	File
+	fs      *EncryptedFS
+	name    string
+	r       *bufio.Reader
+	tail    bool // Segmento mais novo do log: pode não ter o bloco final
+	started bool
+	plain   bool // Arquivo sem cabeçalho: lido como está
+	sealed  bool // O bloco final foi lido
+	cut     bool // O arquivo acabou sem o bloco final
+	torn    bool // ... e com um bloco (ou o cabeçalho) pela metade
+	header  cryptHeader
+	aead    cipher.AEAD
+	frame   uint64
+	buf     []byte // Texto decifrado do bloco atual ainda não lido
+	err     error  // Erro (ou io.EOF) que encerra a leitura
+}
+
+func (df *decryptingFile) Read(p []byte) (int, error) {
+	if !df.started {
+		df.started = true
+		df.err = df.start()
+	}
+	if df.plain {
+		return df.r.Read(p)
+	}
+	for len(df.buf) == 0 {
+		if df.err != nil {
+			return 0, df.err
+		}
+		df.buf, df.err = df.next()
+	}
+	n := copy(p, df.buf)
+	df.buf = df.buf[n:]
+	return n, nil
+}
+
+// start lê o cabeçalho e escolhe a chave.
+func (df *decryptingFile) start() error {
+	header, encrypted, err := readCryptHeader(df.r)
+	switch {
+	case err != nil:
+		return err
+	case !encrypted:
+		if !df.fs.keys.encrypting() || df.fs.allowPlain.Load() {
+			df.plain = true
+			if _, err := df.r.Peek(1); err == nil && df.fs.keys.encrypting() {
+				log.Printf("Aviso: %s não está cifrado; será recifrado no próximo snapshot.", df.name)
+				df.fs.stale.Store(true)
+			}
+			return nil
+		}
+		// Com chave, até um arquivo vazio teria o cabeçalho e o bloco final.
+		if _, err := df.r.Peek(1); err == io.EOF {
+			return df.unsealed(false)
+		}
+		return fmt.Errorf("%s: %w", df.name, errPlaintext)
+	case header == nil:
+		return df.unsealed(true)
+	}
+	aead, current, err := df.fs.keys.reader(header.key)
+	if err != nil {
+		return fmt.Errorf("%s: %w", df.name, err)
+	}
+	if !current {
+		df.fs.stale.Store(true)
+	}
+	df.header, df.aead = *header, aead
+	return nil
+}
+
+// next decifra o próximo bloco. O bloco final encerra a leitura com io.EOF; um
+// bloco que não passa na autenticação, ou dados depois do bloco final, são
+// ErrDecrypt.
+func (df *decryptingFile) next() ([]byte, error) {
+	var lenBuf [frameLenSize]byte
+	if _, err := io.ReadFull(df.r, lenBuf[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, df.unsealed(err == io.ErrUnexpectedEOF)
+		}
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(lenBuf[:])
+	overhead := df.aead.NonceSize() + df.aead.Overhead()
+	if n < uint32(overhead) || n > uint32(maxFramePlain+overhead) {
+		return nil, fmt.Errorf("%s, bloco %d: %w", df.name, df.frame, ErrDecrypt)
+	}
+	sealed := make([]byte, n)
+	if _, err := io.ReadFull(df.r, sealed); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, df.unsealed(true)
+		}
+		return nil, err
+	}
+	nonce, ciphertext := sealed[:df.aead.NonceSize()], sealed[df.aead.NonceSize():]
+	plain, err := df.aead.Open(nil, nonce, ciphertext, df.header.additionalData(df.frame, false))
+	if err == nil {
+		df.frame++
+		return plain, nil
+	}
+	count, err := df.aead.Open(nil, nonce, ciphertext, df.header.additionalData(df.frame, true))
+	if err != nil || len(count) != 8 || binary.BigEndian.Uint64(count) != df.frame {
+		return nil, fmt.Errorf("%s, bloco %d: %w", df.name, df.frame, ErrDecrypt)
+	}
+	if _, err := df.r.Peek(1); err != io.EOF {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %w: dados depois do bloco final", df.name, ErrDecrypt)
+	}
+	df.sealed = true
+	return nil, io.EOF
+}
+
+// unsealed trata o fim do arquivo antes do bloco final (torn: no meio de um
+// bloco). No segmento mais novo do log é uma escrita interrompida e a leitura
+// termina no último bloco inteiro; em qualquer outro arquivo, blocos podem ter
+// sido removidos.
+func (df *decryptingFile) unsealed(torn bool) error {
+	df.cut, df.torn = true, torn
+	if !df.tail {
+		return fmt.Errorf("%s, bloco %d: %w", df.name, df.frame, errUnsealed)
+	}
+	if torn {
+		log.Printf("%s termina com um bloco cifrado incompleto (escrita interrompida); ignorado.", df.name)
+	}
+	return io.EOF
+}
+
+// newDataFS devolve o FS dos arquivos de dados: inner, cifrado com key (se
+// houver) e capaz de ler o que foi cifrado com as chaves old.
+func newDataFS(inner FS, key []byte, old [][]byte) (*EncryptedFS, error) {
+	keys, err := NewKeyring(key, old...)
+	if err != nil {
+		return nil, err
+	}
+	return NewEncryptedFS(inner, keys), nil
+}
+
+// offlineFS é o FS das ferramentas offline (remotelistctl, remotelist-restore):
+// o do SO, com as chaves das variáveis de ambiente.
+func offlineFS() (FS, error) {
+	key, err := LoadKey("")
+	if err != nil {
+		return nil, err
+	}
+	old, err := LoadOldKeys(nil)
+	if err != nil {
+		return nil, err
+	}
+	return newDataFS(OSFS{}, key, old)
+}
+
+// RotateKey passa a cifrar com key e grava um snapshot, que recifra o estado
+// com ela; os segmentos gravados com a chave anterior (ou em texto puro, se
+// não havia chave) são apagados ou arquivados pelo snapshot. A chave anterior
+// continua valendo para leitura até o servidor parar: as cópias em
+// Config.ArchiveDir ainda dependem dela.
+func (rl *RemoteList) RotateKey(key []byte) error {
+	if err := rl.crypt.keys.Rotate(key); err != nil {
+		return err
+	}
+	return rl.createSnapshot()
+}
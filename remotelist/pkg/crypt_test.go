@@ -0,0 +1,744 @@
+package remotelist_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"remotelist/pkg"
+)
+
+// Criptografia em repouso do log e dos snapshots, com o RemoteList sobre um
+// MemFS.
+
+// newCryptHarness devolve um harness com o disco vazio, fechado no fim do
+// teste.
+func newCryptHarness(t *testing.T) *cryptHarness {
+	mem := remotelist.NewMemFS()
+	mem.MkdirAll(dataDir, 0755)
+	h := &cryptHarness{mem: mem, ffs: remotelist.NewFaultFS(mem), model: make(map[string][]int)}
+	t.Cleanup(h.close)
+	return h
+}
+
+// Formato dos arquivos cifrados (ver crypt.go): cabeçalho de 32 bytes
+// começando por "RLCRYPT1" e blocos com o tamanho em 4 bytes na frente.
+const (
+	magic     = "RLCRYPT1"
+	headerLen = 32
+)
+
+// Valores fáceis de reconhecer em claro nos arquivos.
+const (
+	secretList  = "segredo"
+	secretValue = 7340033
+)
+
+var (
+	key1 = mustKey("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	key2 = mustKey("f0e0d0c0b0a090807060504030201000f1e1d1c1b1a191817161514131211100")
+)
+
+func mustKey(text string) []byte {
+	key, err := remotelist.ParseKey(text)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// cryptHarness guarda o servidor em teste, o sistema de arquivos dele e o
+// modelo das escritas confirmadas.
+type cryptHarness struct {
+	mem     *remotelist.MemFS
+	ffs     *remotelist.FaultFS
+	rl      *remotelist.RemoteList
+	model   map[string][]int
+	migrate bool // Config.MigratePlaintext
+}
+
+// open abre o servidor com a chave key (nil = sem criptografia) e as antigas old.
+func (h *cryptHarness) open(key []byte, old ...[]byte) error {
+	rl, err := remotelist.NewRemoteListWithConfig(remotelist.Config{
+		Dir: dataDir, FS: h.ffs, SnapshotInterval: -1, EncryptionKey: key, OldEncryptionKeys: old, MigratePlaintext: h.migrate,
+	})
+	if err != nil {
+		return err
+	}
+	h.rl = rl
+	return nil
+}
+
+func (h *cryptHarness) close() {
+	if h.rl != nil {
+		h.rl.Close()
+		h.rl = nil
+	}
+}
+
+// restart fecha o servidor e o reabre com as chaves dadas.
+func (h *cryptHarness) restart(key []byte, old ...[]byte) error {
+	h.close()
+	return h.open(key, old...)
+}
+
+// crash abandona o servidor sem Close e reabre sobre o que estava sincronizado.
+func (h *cryptHarness) crash(key []byte) error {
+	h.kill()
+	return h.open(key)
+}
+
+// kill abandona o servidor sem Close: fica só o que estava sincronizado.
+func (h *cryptHarness) kill() {
+	h.rl = nil
+	h.mem = h.mem.Crash()
+	h.ffs = remotelist.NewFaultFS(h.mem)
+}
+
+// fill faz n Appends confirmados em list.
+func (h *cryptHarness) fill(list string, n int) error {
+	for i := 0; i < n; i++ {
+		if err := h.append(list, secretValue+i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *cryptHarness) append(list string, v int) error {
+	if err := h.rl.Append(remotelist.AppendArgs{ListID: list, Value: v}, &remotelist.AppendReply{}); err != nil {
+		return fmt.Errorf("Append(%s, %d): %w", list, v, err)
+	}
+	h.model[list] = append(h.model[list], v)
+	return nil
+}
+
+// expect confere que o servidor tem exatamente as escritas confirmadas.
+func (h *cryptHarness) expect(when string) error {
+	var lists remotelist.ListsReply
+	h.rl.Lists(remotelist.ListsArgs{}, &lists)
+	got := make(map[string][]int, len(lists.ListIDs))
+	for _, id := range lists.ListIDs {
+		var data remotelist.ExportListReply
+		h.rl.Peer().ExportList(remotelist.ExportListArgs{ListID: id}, &data)
+		got[id] = data.Values
+	}
+	if !reflect.DeepEqual(got, h.model) {
+		return fmt.Errorf("%s: estado %v, esperado %v", when, got, h.model)
+	}
+	return nil
+}
+
+// files devolve o conteúdo de cada arquivo de dados.
+func (h *cryptHarness) files() (map[string][]byte, error) {
+	names, err := h.mem.ReadDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]byte, len(names))
+	for _, name := range names {
+		content, err := h.mem.ReadFile(filepath.Join(dataDir, name))
+		if err != nil {
+			return nil, err
+		}
+		out[name] = content
+	}
+	return out, nil
+}
+
+// segments devolve, em ordem, os nomes dos segmentos do log com registros
+// (mais que o cabeçalho e o bloco final).
+func (h *cryptHarness) segments() ([]string, error) {
+	files, err := h.files()
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for name, content := range files {
+		if strings.HasPrefix(name, "remotelist.log.") && len(frameEnds(content)) > 1 {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// frameEnds devolve onde termina cada bloco inteiro de um arquivo cifrado.
+func frameEnds(content []byte) []int {
+	var ends []int
+	for at := headerLen; at+4 <= len(content); {
+		at += 4 + int(binary.BigEndian.Uint32(content[at:]))
+		if at > len(content) {
+			break
+		}
+		ends = append(ends, at)
+	}
+	return ends
+}
+
+// read devolve o conteúdo do arquivo de dados name.
+func (h *cryptHarness) read(name string) ([]byte, error) {
+	return h.mem.ReadFile(filepath.Join(dataDir, name))
+}
+
+// overwrite troca o conteúdo de um arquivo de dados.
+func (h *cryptHarness) overwrite(name string, content []byte) error {
+	f, err := h.mem.OpenFile(filepath.Join(dataDir, name), os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// encrypted confere que todos os arquivos não vazios estão cifrados e não
+// deixam escapar o nome da lista, os valores nem as operações.
+func (h *cryptHarness) encrypted() error {
+	files, err := h.files()
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("nenhum arquivo de dados")
+	}
+	for name, content := range files {
+		if len(content) == 0 {
+			continue
+		}
+		if !bytes.HasPrefix(content, []byte(magic)) {
+			return fmt.Errorf("%s não começa com o cabeçalho cifrado", name)
+		}
+		for _, leak := range []string{secretList, fmt.Sprint(secretValue), "APPEND", "lists"} {
+			if bytes.Contains(content, []byte(leak)) {
+				return fmt.Errorf("%s contém %q em claro", name, leak)
+			}
+		}
+	}
+	return nil
+}
+
+// expectDecryptError confere que abrir o servidor com as chaves dadas falha
+// com ErrDecrypt.
+func (h *cryptHarness) expectDecryptError(when string, key []byte, old ...[]byte) error {
+	h.close()
+	err := h.open(key, old...)
+	if err == nil {
+		return fmt.Errorf("%s: servidor abriu sem erro", when)
+	}
+	if !errors.Is(err, remotelist.ErrDecrypt) {
+		return fmt.Errorf("%s: erro %v, esperado ErrDecrypt", when, err)
+	}
+	return nil
+}
+
+// TestCryptCiphertext: snapshot e log cifrados, e o estado volta com a mesma
+// chave.
+func TestCryptCiphertext(t *testing.T) {
+	h := newCryptHarness(t)
+	if err := h.open(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill(secretList, 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill(secretList, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.encrypted(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.restart(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expect("depois de reiniciar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.crash(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expect("depois de uma queda"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCryptTornWrite: metade de um bloco chega ao disco e a escrita falha.
+func TestCryptTornWrite(t *testing.T) {
+	h := newCryptHarness(t)
+	if err := h.open(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill(secretList, 3); err != nil {
+		t.Fatal(err)
+	}
+	h.ffs.Inject(remotelist.Fault{Op: remotelist.FaultWrite, Match: "remotelist.log", Torn: true})
+	if err := h.rl.Append(remotelist.AppendArgs{ListID: secretList, Value: 1}, &remotelist.AppendReply{}); err == nil {
+		t.Fatal("Append com escrita pela metade não falhou")
+	}
+	if err := h.fill(secretList, 2); err != nil {
+		t.Fatal(err)
+	}
+	// O segmento abandonado foi regravado sem o bloco cortado e com o bloco
+	// final, e não é mais o mais novo.
+	if err := h.restart(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expect("depois de reiniciar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.crash(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expect("depois da queda"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// flipByte troca um byte no meio do arquivo name.
+func (h *cryptHarness) flipByte(name string) ([]byte, error) {
+	content, err := h.mem.ReadFile(filepath.Join(dataDir, name))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) <= headerLen+8 {
+		return nil, fmt.Errorf("%s pequeno demais (%d bytes)", name, len(content))
+	}
+	tampered := append([]byte(nil), content...)
+	tampered[headerLen+(len(content)-headerLen)/2] ^= 0x40
+	return content, h.overwrite(name, tampered)
+}
+
+// TestCryptTamperSnapshot: um byte trocado no snapshot é detectado e o arquivo
+// original continua lá (nada foi sobrescrito por um estado vazio).
+func TestCryptTamperSnapshot(t *testing.T) {
+	h := newCryptHarness(t)
+	if err := h.open(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill(secretList, 50); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	h.close()
+	original, err := h.flipByte("remotelist.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expectDecryptError("snapshot adulterado", key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.overwrite("remotelist.json", original); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.open(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expect("depois de desfazer a adulteração"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCryptTamperLog: um byte trocado em um segmento é detectado.
+func TestCryptTamperLog(t *testing.T) {
+	h := newCryptHarness(t)
+	if err := h.open(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill(secretList, 10); err != nil {
+		t.Fatal(err)
+	}
+	h.close()
+	segments, err := h.segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 1 {
+		t.Fatalf("segmentos com dados: %v, esperado um", segments)
+	}
+	original, err := h.flipByte(segments[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expectDecryptError("segmento adulterado", key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.overwrite(segments[0], original); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.open(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expect("depois de desfazer a adulteração"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCryptReorder: os dois primeiros blocos do segmento trocam de lugar. Cada um
+// continua íntegro, mas a posição dele faz parte da autenticação.
+func TestCryptReorder(t *testing.T) {
+	h := newCryptHarness(t)
+	if err := h.open(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill(secretList, 2); err != nil {
+		t.Fatal(err)
+	}
+	h.close()
+	segments, err := h.segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 1 {
+		t.Fatalf("segmentos com dados: %v, esperado um", segments)
+	}
+	content, err := h.mem.ReadFile(filepath.Join(dataDir, segments[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := func(at int) int { return 4 + int(binary.BigEndian.Uint32(content[at:])) }
+	first := frame(headerLen)
+	second := frame(headerLen + first)
+	end := headerLen + first + second
+	var swapped []byte
+	swapped = append(swapped, content[:headerLen]...)
+	swapped = append(swapped, content[headerLen+first:end]...)
+	swapped = append(swapped, content[headerLen:headerLen+first]...)
+	swapped = append(swapped, content[end:]...)
+	if err := h.overwrite(segments[0], swapped); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expectDecryptError("blocos trocados", key1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCryptWrongKey: outra chave ou nenhuma chave não abrem os arquivos.
+func TestCryptWrongKey(t *testing.T) {
+	h := newCryptHarness(t)
+	if err := h.open(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill(secretList, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill(secretList, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expectDecryptError("chave errada", key2); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expectDecryptError("sem chave", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.open(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expect("com a chave certa"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCryptRotate: depois de RotateKey, só a chave nova é necessária.
+func TestCryptRotate(t *testing.T) {
+	h := newCryptHarness(t)
+	if err := h.open(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill(secretList, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill(secretList, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.rl.RotateKey(key2); err != nil {
+		t.Fatalf("RotateKey: %v", err)
+	}
+	if err := h.fill(secretList, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.encrypted(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expectDecryptError("chave antiga depois da troca", key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.open(key2); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expect("com a chave nova"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCryptRestartRotate: a troca feita reiniciando com a chave nova e a antiga.
+func TestCryptRestartRotate(t *testing.T) {
+	h := newCryptHarness(t)
+	if err := h.open(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill(secretList, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill(secretList, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expectDecryptError("chave nova sem a antiga", key2); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.restart(key2, key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expect("com as duas chaves"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.restart(key2); err != nil {
+		t.Fatalf("só com a chave nova: %v", err)
+	}
+	if err := h.expect("só com a chave nova"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCryptPlaintext: dados gravados sem chave só são aceitos, e recifrados,
+// com a migração pedida; depois dela, a criptografia vale para tudo.
+func TestCryptPlaintext(t *testing.T) {
+	h := newCryptHarness(t)
+	if err := h.open(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill(secretList, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill(secretList, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expectDecryptError("texto puro sem a migração", key1); err != nil {
+		t.Fatal(err)
+	}
+	h.migrate = true
+	if err := h.open(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expect("com a criptografia ligada"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.encrypted(); err != nil {
+		t.Fatal(err)
+	}
+	h.migrate = false
+	if err := h.restart(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expect("depois de reiniciar"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCryptPlaintextSegment: um segmento cifrado trocado por um em texto puro
+// não é aceito.
+func TestCryptPlaintextSegment(t *testing.T) {
+	h := newCryptHarness(t)
+	if err := h.open(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill(secretList, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill(secretList, 5); err != nil {
+		t.Fatal(err)
+	}
+	h.close()
+	segments, err := h.segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 1 {
+		t.Fatalf("segmentos com dados: %v, esperado um", segments)
+	}
+	if err := h.overwrite(segments[0], []byte("APPEND "+secretList+" 1\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expectDecryptError("segmento em texto puro", key1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCryptTruncate: blocos inteiros removidos do fim de um segmento que não é
+// o mais novo, ou do snapshot, são detectados, inclusive só o bloco final.
+func TestCryptTruncate(t *testing.T) {
+	h := newCryptHarness(t)
+	if err := h.open(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill(secretList, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.restart(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill(secretList, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill(secretList, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.restart(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill(secretList, 2); err != nil {
+		t.Fatal(err)
+	}
+	h.close()
+	segments, err := h.segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 2 {
+		t.Fatalf("segmentos com dados: %v, esperado dois", segments)
+	}
+
+	for _, name := range []string{segments[0], "remotelist.json"} {
+		original, err := h.read(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Sem o bloco final, só com o primeiro bloco e só com o cabeçalho.
+		ends := frameEnds(original)
+		for _, cut := range []int{ends[len(ends)-2], ends[0], headerLen} {
+			if err := h.overwrite(name, original[:cut]); err != nil {
+				t.Fatal(err)
+			}
+			if err := h.expectDecryptError(fmt.Sprintf("%s cortado em %d de %d bytes", name, cut, len(original)), key1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := h.overwrite(name, original); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.open(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expect("com os arquivos inteiros"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCryptTornTail: depois de uma queda, o segmento mais novo não tem o bloco
+// final e pode terminar com um bloco pela metade. O que estava inteiro volta, e
+// o segmento ganha o bloco final antes de deixar de ser o mais novo.
+func TestCryptTornTail(t *testing.T) {
+	h := newCryptHarness(t)
+	if err := h.open(key1); err != nil {
+		t.Fatal(err)
+	}
+	for round := 0; round < 3; round++ {
+		if err := h.fill(secretList, 5); err != nil {
+			t.Fatal(err)
+		}
+		h.kill()
+		segments, err := h.segments()
+		if err != nil {
+			t.Fatal(err)
+		}
+		last := segments[len(segments)-1]
+		content, err := h.read(last)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ends := frameEnds(content)
+		if ends[len(ends)-1] != len(content) {
+			t.Fatalf("rodada %d: %s termina no meio de um bloco", round, last)
+		}
+		// A última escrita chegou pela metade: ela não foi confirmada.
+		cut := ends[len(ends)-2] + (ends[len(ends)-1]-ends[len(ends)-2])/2
+		if err := h.overwrite(last, content[:cut]); err != nil {
+			t.Fatal(err)
+		}
+		h.model[secretList] = h.model[secretList][:len(h.model[secretList])-1]
+		if err := h.open(key1); err != nil {
+			t.Fatalf("rodada %d: %v", round, err)
+		}
+		if err := h.expect(fmt.Sprintf("rodada %d", round)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.restart(key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expect("depois de reiniciar"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCryptOffline: as ferramentas offline usam a chave do ambiente.
+func TestCryptOffline(t *testing.T) {
+	h := newCryptHarness(t)
+	dir := t.TempDir()
+	rl, err := remotelist.NewRemoteListWithConfig(remotelist.Config{Dir: dir, SnapshotInterval: -1, EncryptionKey: key1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.rl = rl
+	if err := h.fill(secretList, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill(secretList, 5); err != nil {
+		t.Fatal(err)
+	}
+	h.close()
+
+	t.Setenv(remotelist.KeyFileEnv, "")
+	t.Setenv(remotelist.KeyEnv, "")
+	if _, err := remotelist.LoadOffline(dir); !errors.Is(err, remotelist.ErrDecrypt) {
+		t.Fatalf("LoadOffline sem chave: %v, esperado ErrDecrypt", err)
+	}
+	t.Setenv(remotelist.KeyEnv, hex.EncodeToString(key1))
+	st, err := remotelist.LoadOffline(dir)
+	if err != nil {
+		t.Fatalf("LoadOffline: %v", err)
+	}
+	if !reflect.DeepEqual(st.Lists, h.model) {
+		t.Fatalf("LoadOffline: listas %v, esperado %v", st.Lists, h.model)
+	}
+}
@@ -0,0 +1,316 @@
+package remotelist
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"time"
+)
+
+// --- Itens agendados ---
+//
+// Schedule grava um valor que só entra na lista (no fim, como um Append) num
+// instante dado ou depois de um atraso: retentativas com backoff, tarefas
+// agendadas. Até lá o item fica no índice de timers da lista, invisível para
+// Get, Size, Remove e View; Scheduled o mostra e Unschedule o cancela.
+//
+// O índice é persistido como o resto da lista: registro DELAY no WAL (o ID do
+// item é o número do registro, único no servidor), os itens no snapshot e no
+// estado enviado a um backup. O instante de disparo é absoluto, como o prazo
+// do TTL: uma reinicialização não o adia nem o antecipa.
+//
+// O scheduler acorda no próximo disparo (ou a cada Config.ScheduleInterval) e
+// move os itens vencidos de cada lista com um único registro FIRE <n>, que
+// leva os n primeiros do índice, em ordem de (disparo, ID), para o fim da
+// lista. Como é um registro só, uma queda deixa cada lote inteiro no índice ou
+// inteiro na lista; o replay nunca dispara nada por conta própria, então
+// nenhum item entra antes da hora e nenhum se perde.
+//
+// Os itens agendados contam nos limites de elementos desde o Schedule: o
+// disparo nunca é recusado. Uma lista segura por uma transação ou vencida
+// (TTL) não dispara; apagá-la descarta os itens. Num backup o scheduler não
+// dispara: os FIRE vêm do primário.
+
+// Intervalo padrão entre as verificações do scheduler.
+const defaultScheduleInterval = time.Second
+
+// DelayedItem é um valor agendado para entrar na lista em Due.
+type DelayedItem struct {
+	ID    uint64    `json:"id"`
+	Value int       `json:"value"`
+	Due   time.Time `json:"due"`
+}
+
+type ScheduleArgs struct {
+	ListID string
+	Value  int
+	Delay  time.Duration // Daqui a quanto tempo o item entra na lista
+	At     time.Time     // Ou o instante exato (se não for zero, Delay é ignorado)
+}
+type ScheduleReply struct {
+	ID    uint64
+	Due   time.Time
+	Token uint64 // Número do registro da escrita, para leituras ReadYourWrites
+}
+
+type UnscheduleArgs struct {
+	ListID string
+	ID     uint64
+}
+type UnscheduleReply struct {
+	Found bool   // false se o item já entrou na lista ou não existe
+	Token uint64 // Número do registro da escrita, para leituras ReadYourWrites
+}
+
+type ScheduledArgs struct {
+	ListID string
+	Read   ReadOptions
+}
+type ScheduledReply struct {
+	Items []DelayedItem // Em ordem de disparo
+}
+
+// Schedule agenda um valor para entrar no fim da lista 'list_id', criando a
+// lista se preciso.
+func (rl *RemoteList) Schedule(args ScheduleArgs, reply *ScheduleReply) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	if args.Delay < 0 {
+		return fmt.Errorf("atraso inválido: %v", args.Delay)
+	}
+	due := args.At
+	if due.IsZero() {
+		due = time.Now().Add(args.Delay)
+	}
+	ml, err := rl.lockOrCreateKey(args.ListID, KindList)
+	if err != nil {
+		return err
+	}
+	defer ml.mu.Unlock()
+	if ml.txn != "" {
+		return errListLocked
+	}
+	if err := rl.reserveElements(args.ListID, ml.size(), 1); err != nil {
+		return err
+	}
+
+	rec, err := rl.logStamped(LogRecord{Op: "DELAY", ListID: args.ListID, Value: args.Value, Due: due})
+	if err != nil {
+		rl.releaseElements(1)
+		log.Printf("Erro crítico de persistência (Schedule): %v", err)
+		return fmt.Errorf("falha ao gravar no disco (WAL): %w", err)
+	}
+	// Sem a leitura monotônica, como o replay o verá.
+	item := DelayedItem{ID: rec.Seq, Value: args.Value, Due: time.Unix(0, due.UnixNano())}
+	ml.schedule(item)
+	rl.wakeScheduler()
+	reply.ID, reply.Due, reply.Token = item.ID, item.Due, rec.Seq
+	return nil
+}
+
+// Unschedule cancela um item agendado da lista 'list_id'.
+func (rl *RemoteList) Unschedule(args UnscheduleArgs, reply *UnscheduleReply) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	ml, err := rl.lockExisting(args.ListID)
+	if err != nil {
+		return err
+	}
+	defer ml.mu.Unlock()
+	if ml.kind != KindList {
+		return errWrongType(args.ListID, ml.kind, KindList)
+	}
+	if !slices.ContainsFunc(ml.delayed, func(it DelayedItem) bool { return it.ID == args.ID }) {
+		return nil
+	}
+
+	seq, err := rl.logRecord(LogRecord{Op: "UNDELAY", ListID: args.ListID, Target: args.ID})
+	if err != nil {
+		log.Printf("Erro crítico de persistência (Unschedule): %v", err)
+		return fmt.Errorf("falha ao gravar no disco (WAL): %w", err)
+	}
+	ml.unschedule(args.ID)
+	rl.releaseElements(1)
+	reply.Found, reply.Token = true, seq
+	return nil
+}
+
+// Scheduled mostra os itens agendados da lista 'list_id'.
+func (rl *RemoteList) Scheduled(args ScheduledArgs, reply *ScheduledReply) error {
+	if forwarded, err := rl.routeRead(args.Read, "Scheduled", args, reply); forwarded {
+		return err
+	}
+	ml, err := rl.rlockKey(args.ListID, KindList)
+	if ml == nil || err != nil {
+		return err
+	}
+	defer ml.mu.RUnlock()
+	reply.Items = append([]DelayedItem(nil), ml.delayed...)
+	return nil
+}
+
+// wakeScheduler avisa o scheduler de um item novo, que pode vencer antes do
+// próximo disparo que ele espera.
+func (rl *RemoteList) wakeScheduler() {
+	select {
+	case rl.scheduleWake <- struct{}{}:
+	default:
+	}
+}
+
+// scheduler dispara os itens agendados: a cada volta move os vencidos e dorme
+// até o próximo disparo, no máximo Config.ScheduleInterval.
+func (rl *RemoteList) scheduler() {
+	interval := rl.cfg.ScheduleInterval
+	if interval == 0 {
+		interval = defaultScheduleInterval
+	}
+	wait := time.Duration(0)
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-rl.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-rl.scheduleWake:
+			timer.Stop()
+		}
+		wait = interval
+		if rl.readOnly.Load() {
+			continue
+		}
+		next, err := rl.fireDue()
+		if err != nil {
+			log.Printf("Erro ao disparar itens agendados: %v", err)
+			continue
+		}
+		if !next.IsZero() {
+			wait = min(wait, max(time.Until(next), 0))
+		}
+	}
+}
+
+// fireDue move os itens vencidos de todas as listas e devolve o próximo
+// disparo (zero se não houver nenhum). Listas que não podem disparar agora
+// (seguras por uma transação ou vencidas) ficam para a próxima volta.
+func (rl *RemoteList) fireDue() (time.Time, error) {
+	now := time.Now()
+	var due []string
+	var next time.Time
+	rl.mapMu.RLock()
+	for id, ml := range rl.lists {
+		ml.mu.RLock()
+		if len(ml.delayed) > 0 && ml.txn == "" && !ml.expired(now) {
+			n := ml.dueCount(now)
+			if n > 0 {
+				due = append(due, id)
+			}
+			if n < len(ml.delayed) && (next.IsZero() || ml.delayed[n].Due.Before(next)) {
+				next = ml.delayed[n].Due
+			}
+		}
+		ml.mu.RUnlock()
+	}
+	rl.mapMu.RUnlock()
+
+	for _, id := range due {
+		if err := rl.fire(id, now); err != nil {
+			return time.Time{}, fmt.Errorf("lista '%s': %w", id, err)
+		}
+	}
+	return next, nil
+}
+
+// fire move para o fim da lista os itens vencidos em now (registro FIRE).
+func (rl *RemoteList) fire(listID string, now time.Time) error {
+	ml, exists := rl.getList(listID)
+	if !exists {
+		return nil
+	}
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	// A lista pode ter mudado desde a varredura.
+	if ml.deleted || ml.kind != KindList || ml.txn != "" || ml.expired(now) {
+		return nil
+	}
+	n := ml.dueCount(now)
+	if n == 0 {
+		return nil
+	}
+
+	if _, err := rl.logRecord(LogRecord{Op: "FIRE", ListID: listID, Value: n}); err != nil {
+		log.Printf("Erro crítico de persistência (scheduler): %v", err)
+		return fmt.Errorf("falha ao gravar no disco (WAL): %w", err)
+	}
+	ml.fire(n)
+	log.Printf("%d itens agendados entraram na lista '%s'.", n, listID)
+	return nil
+}
+
+// schedule coloca o item no índice, depois dos que disparam antes ou junto
+// com ele. Deve ser chamado com ml.mu bloqueado para escrita.
+func (ml *ManagedList) schedule(it DelayedItem) {
+	i := sort.Search(len(ml.delayed), func(i int) bool {
+		d := ml.delayed[i]
+		return d.Due.After(it.Due) || d.Due.Equal(it.Due) && d.ID > it.ID
+	})
+	ml.delayed = slices.Insert(ml.delayed, i, it)
+}
+
+// dueCount é quantos itens do início do índice já venceram em now.
+func (ml *ManagedList) dueCount(now time.Time) int {
+	return sort.Search(len(ml.delayed), func(i int) bool { return ml.delayed[i].Due.After(now) })
+}
+
+// fire move os n primeiros itens do índice para o fim da lista.
+func (ml *ManagedList) fire(n int) {
+	for _, it := range ml.delayed[:n] {
+		ml.push(it.Value)
+	}
+	ml.delayed = slices.Delete(ml.delayed, 0, n)
+}
+
+// unschedule tira o item id do índice e diz se ele estava lá.
+func (ml *ManagedList) unschedule(id uint64) bool {
+	i := slices.IndexFunc(ml.delayed, func(it DelayedItem) bool { return it.ID == id })
+	if i < 0 {
+		return false
+	}
+	ml.delayed = slices.Delete(ml.delayed, i, i+1)
+	return true
+}
+
+// captureDelayed copia os itens agendados das listas, para o snapshot ou para
+// um backup. Deve ser chamado com as listas bloqueadas.
+func captureDelayed(ids []string, lists []*ManagedList) map[string][]DelayedItem {
+	var delayed map[string][]DelayedItem
+	for i, ml := range lists {
+		if len(ml.delayed) == 0 {
+			continue
+		}
+		if delayed == nil {
+			delayed = make(map[string][]DelayedItem)
+		}
+		delayed[ids[i]] = append([]DelayedItem(nil), ml.delayed...)
+	}
+	return delayed
+}
+
+// restoreDelayed devolve às listas os itens de captureDelayed, criando as
+// listas que só tinham itens agendados.
+func restoreDelayed(lists map[string]*ManagedList, delayed map[string][]DelayedItem) {
+	for id, items := range delayed {
+		ml := lists[id]
+		if ml == nil {
+			ml = newManagedList(nil)
+			lists[id] = ml
+		}
+		for _, it := range items {
+			ml.schedule(it)
+		}
+	}
+}
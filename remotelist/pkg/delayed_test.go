@@ -0,0 +1,510 @@
+package remotelist_test
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"remotelist/pkg"
+)
+
+// Itens agendados (ver delayed.go), com o RemoteList sobre um MemFS, que
+// simula quedas.
+
+// --- Leituras ---
+
+func values(rl *remotelist.RemoteList, list string) ([]int, error) {
+	var reply remotelist.ViewReply
+	err := rl.View(remotelist.ViewArgs{ListID: list, Limit: 10000}, &reply)
+	if err != nil && err.Error() == "lista não encontrada" {
+		return []int{}, nil
+	}
+	return append([]int{}, reply.Values...), err
+}
+
+func scheduled(rl *remotelist.RemoteList, list string) ([]remotelist.DelayedItem, error) {
+	var reply remotelist.ScheduledReply
+	err := rl.Scheduled(remotelist.ScheduledArgs{ListID: list}, &reply)
+	return reply.Items, err
+}
+
+func schedule(rl *remotelist.RemoteList, list string, value int, delay time.Duration) (remotelist.ScheduleReply, error) {
+	var reply remotelist.ScheduleReply
+	err := rl.Schedule(remotelist.ScheduleArgs{ListID: list, Value: value, Delay: delay}, &reply)
+	return reply, err
+}
+
+// waitFor repete cond até ela dar certo ou o tempo acabar.
+func waitFor(timeout time.Duration, what string, cond func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := cond()
+		if err != nil || ok {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s: tempo esgotado", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// exactlyOnce confere que cada valor de want está uma vez só, ou na lista ou
+// agendado.
+func exactlyOnce(rl *remotelist.RemoteList, list string, want []int, when string) error {
+	got, err := values(rl, list)
+	if err != nil {
+		return fmt.Errorf("%s: %w", when, err)
+	}
+	items, err := scheduled(rl, list)
+	if err != nil {
+		return fmt.Errorf("%s: %w", when, err)
+	}
+	for _, it := range items {
+		got = append(got, it.Value)
+	}
+	sort.Ints(got)
+	sorted := append([]int{}, want...)
+	sort.Ints(sorted)
+	if !reflect.DeepEqual(got, sorted) {
+		return fmt.Errorf("%s: lista e agendados têm %v, esperado %v", when, got, sorted)
+	}
+	return nil
+}
+
+// --- Cenários ---
+
+func TestSchedFire(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := n.rl
+	if err := rl.Append(remotelist.AppendArgs{ListID: "q", Value: -1}, &remotelist.AppendReply{}); err != nil {
+		t.Fatal(err)
+	}
+	// Agendados fora de ordem, com dois empates (10 e 11, 30 e 31).
+	delays := map[int]time.Duration{40: 400 * time.Millisecond, 10: 100 * time.Millisecond, 30: 250 * time.Millisecond,
+		11: 100 * time.Millisecond, 20: 200 * time.Millisecond, 31: 250 * time.Millisecond}
+	due := make(map[int]time.Time)
+	order := []int{40, 10, 30, 11, 20, 31}
+	now := time.Now()
+	for _, v := range order {
+		if err := rl.Schedule(remotelist.ScheduleArgs{ListID: "q", Value: v, At: now.Add(delays[v])}, &remotelist.ScheduleReply{}); err != nil {
+			t.Fatal(err)
+		}
+		due[v] = now.Add(delays[v])
+	}
+	items, err := scheduled(rl, "q")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pending []int
+	for _, it := range items {
+		pending = append(pending, it.Value)
+	}
+	if want := []int{10, 11, 20, 30, 31, 40}; !reflect.DeepEqual(pending, want) {
+		t.Fatalf("Scheduled: %v, esperado %v", pending, want)
+	}
+	var size remotelist.SizeReply
+	if err := rl.Size(remotelist.SizeArgs{ListID: "q"}, &size); err != nil || size.Size != 1 {
+		t.Fatalf("Size com itens agendados: %d, %v", size.Size, err)
+	}
+
+	// Cada valor, quando aparece, já venceu.
+	seen := map[int]bool{}
+	err = waitFor(2*time.Second, "disparos", func() (bool, error) {
+		got, err := values(rl, "q")
+		if err != nil {
+			return false, err
+		}
+		checked := time.Now()
+		for _, v := range got[1:] {
+			if !seen[v] && checked.Before(due[v]) {
+				return false, fmt.Errorf("valor %d entrou %v antes da hora", v, due[v].Sub(checked))
+			}
+			seen[v] = true
+		}
+		return len(got) == 7, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := values(rl, "q")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{-1, 10, 11, 20, 30, 31, 40}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("lista %v, esperado %v", got, want)
+	}
+	if items, err := scheduled(rl, "q"); err != nil || len(items) != 0 {
+		t.Fatalf("agendados depois dos disparos: %v, %v", items, err)
+	}
+
+	// Um instante no passado dispara logo, e atraso negativo é recusado.
+	if err := rl.Schedule(remotelist.ScheduleArgs{ListID: "q", Value: 50, At: now.Add(-time.Hour)}, &remotelist.ScheduleReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitFor(time.Second, "item do passado", func() (bool, error) {
+		got, err := values(rl, "q")
+		return len(got) == 8, err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := schedule(rl, "q", 1, -time.Second); err == nil {
+		t.Fatal("atraso negativo aceito")
+	}
+	if err := rl.SetAdd(remotelist.SetAddArgs{Key: "s", Member: 1}, &remotelist.SetAddReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := schedule(rl, "s", 1, 0); err == nil {
+		t.Fatal("Schedule num set sem erro")
+	}
+}
+
+func TestSchedCancel(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := n.rl
+	keep, err := schedule(rl, "q", 1, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drop, err := schedule(rl, "q", 2, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var un remotelist.UnscheduleReply
+	if err := rl.Unschedule(remotelist.UnscheduleArgs{ListID: "q", ID: drop.ID}, &un); err != nil || !un.Found {
+		t.Fatalf("Unschedule: %+v, %v", un, err)
+	}
+	un = remotelist.UnscheduleReply{}
+	if err := rl.Unschedule(remotelist.UnscheduleArgs{ListID: "q", ID: drop.ID}, &un); err != nil || un.Found {
+		t.Fatalf("Unschedule repetido: %+v, %v", un, err)
+	}
+	if err := waitFor(time.Second, "disparo", func() (bool, error) {
+		got, err := values(rl, "q")
+		return len(got) > 0, err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got, err := values(rl, "q"); err != nil || !reflect.DeepEqual(got, []int{1}) {
+		t.Fatalf("lista depois do cancelamento: %v, %v", got, err)
+	}
+	un = remotelist.UnscheduleReply{}
+	if err := rl.Unschedule(remotelist.UnscheduleArgs{ListID: "q", ID: keep.ID}, &un); err != nil || un.Found {
+		t.Fatalf("Unschedule de item disparado: %+v, %v", un, err)
+	}
+	// O cancelamento também sobrevive ao replay.
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if got, err := values(n.rl, "q"); err != nil || !reflect.DeepEqual(got, []int{1}) {
+		t.Fatalf("lista depois da queda: %v, %v", got, err)
+	}
+}
+
+func TestSchedRestart(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const delay = 400 * time.Millisecond
+	var want []int
+	var first time.Time // Primeiro disparo
+	for i := 0; i < 20; i++ {
+		reply, err := schedule(n.rl, "q", i, delay+time.Duration(i)*10*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			first = reply.Due
+		}
+		want = append(want, i)
+		if i == 10 {
+			if err := n.rl.Snapshot(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// Duas quedas antes do prazo: nada dispara antes da hora.
+	for i := 0; i < 2; i++ {
+		if err := n.reboot(); err != nil {
+			t.Fatal(err)
+		}
+		if err := exactlyOnce(n.rl, "q", want, "depois da queda"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for time.Now().Before(first) {
+		got, err := values(n.rl, "q")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) > 0 && time.Now().Before(first) {
+			t.Fatalf("%v entraram antes da hora", got)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// No meio dos disparos, outra queda.
+	time.Sleep(80 * time.Millisecond)
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := exactlyOnce(n.rl, "q", want, "queda no meio dos disparos"); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitFor(2*time.Second, "todos os disparos", func() (bool, error) {
+		got, err := values(n.rl, "q")
+		return len(got) == len(want), err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := values(n.rl, "q"); err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("lista %v, esperado %v (%v)", got, want, err)
+	}
+
+	// Um prazo que vence com o servidor parado dispara logo depois da volta.
+	n.cfg.ScheduleInterval = -1
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := schedule(n.rl, "p", 7, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got, err := values(n.rl, "p"); err != nil || len(got) != 0 {
+		t.Fatalf("disparo com o scheduler desligado: %v, %v", got, err)
+	}
+	n.cfg.ScheduleInterval = 0
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitFor(time.Second, "item vencido durante a parada", func() (bool, error) {
+		got, err := values(n.rl, "p")
+		return reflect.DeepEqual(got, []int{7}), err
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSchedCrash(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var want []int
+	for round := 0; round < 10; round++ {
+		// Muitos itens vencendo juntos e espalhados, e uma queda no meio.
+		for i := 0; i < 50; i++ {
+			v := round*100 + i
+			if _, err := schedule(n.rl, fmt.Sprintf("q%d", i%3), v, time.Duration(r.Intn(30))*time.Millisecond); err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, v)
+		}
+		time.Sleep(time.Duration(r.Intn(30)) * time.Millisecond)
+		if round%3 == 0 {
+			if err := n.rl.Snapshot(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := n.reboot(); err != nil {
+			t.Fatal(err)
+		}
+		var all []int
+		for i := 0; i < 3; i++ {
+			list := fmt.Sprintf("q%d", i)
+			got, err := values(n.rl, list)
+			if err != nil {
+				t.Fatal(err)
+			}
+			items, err := scheduled(n.rl, list)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, it := range items {
+				got = append(got, it.Value)
+			}
+			all = append(all, got...)
+		}
+		sort.Ints(all)
+		if !reflect.DeepEqual(all, want) {
+			t.Fatalf("rodada %d: %d itens entre listas e agendados, esperado %d", round, len(all), len(want))
+		}
+	}
+	if err := waitFor(2*time.Second, "todos os disparos", func() (bool, error) {
+		total := 0
+		for i := 0; i < 3; i++ {
+			got, err := values(n.rl, fmt.Sprintf("q%d", i))
+			if err != nil {
+				return false, err
+			}
+			total += len(got)
+		}
+		return total == len(want), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSchedBackup(t *testing.T) {
+	c := newCluster(t)
+	backup, err := c.start(remotelist.Config{Backup: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary, err := c.start(remotelist.Config{Backups: []string{backup.addr}, SyncReplication: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := schedule(primary.rl, "q", 1, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitFor(time.Second, "disparo no backup", func() (bool, error) {
+		got, err := values(backup.rl, "q")
+		return reflect.DeepEqual(got, []int{1}), err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sem o primário, o backup guarda os pendentes e não dispara nada.
+	pending, err := schedule(primary.rl, "q", 2, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary.stop()
+	time.Sleep(200 * time.Millisecond)
+	if err := exactlyOnce(backup.rl, "q", []int{1, 2}, "backup sem primário"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := values(backup.rl, "q"); err != nil || len(got) != 1 {
+		t.Fatalf("backup disparou sozinho: %v, %v", got, err)
+	}
+	// Promovido, dispara o que venceu.
+	if err := backup.rl.Promote(remotelist.PromoteArgs{}, &remotelist.PromoteReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitFor(2*time.Second, "disparo no backup promovido", func() (bool, error) {
+		got, err := values(backup.rl, "q")
+		return reflect.DeepEqual(got, []int{1, 2}), err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if items, err := scheduled(backup.rl, "q"); err != nil || len(items) != 0 {
+		t.Fatalf("item %d ainda agendado: %v, %v", pending.ID, items, err)
+	}
+
+	// Um backup novo, depois do snapshot, recebe o índice no estado instalado.
+	if _, err := schedule(backup.rl, "q", 3, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := backup.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	backup.stop()
+	fresh, err := c.start(remotelist.Config{Backup: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup.cfg.Backup, backup.cfg.Backups = false, []string{fresh.addr}
+	if err := backup.start(backup.cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := backup.rl.Append(remotelist.AppendArgs{ListID: "other", Value: 1}, &remotelist.AppendReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitFor(2*time.Second, "índice no backup novo", func() (bool, error) {
+		items, err := scheduled(fresh.rl, "q")
+		return len(items) == 1 && items[0].Value == 3, err
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSchedLimits(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{MaxListElements: 3, MaxTotalElements: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := n.rl
+	for i := 0; i < 2; i++ {
+		if err := rl.Append(remotelist.AppendArgs{ListID: "q", Value: i}, &remotelist.AppendReply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	late, err := schedule(rl, "q", 9, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = schedule(rl, "q", 10, time.Hour)
+	if code := remotelist.LimitCode(err); code != remotelist.CodeListFull {
+		t.Fatalf("quarto item: erro %v, esperado %s", err, remotelist.CodeListFull)
+	}
+	err = rl.Append(remotelist.AppendArgs{ListID: "q", Value: 10}, &remotelist.AppendReply{})
+	if code := remotelist.LimitCode(err); code != remotelist.CodeListFull {
+		t.Fatalf("append com a lista cheia de agendados: erro %v, esperado %s", err, remotelist.CodeListFull)
+	}
+	if err := rl.Unschedule(remotelist.UnscheduleArgs{ListID: "q", ID: late.ID}, &remotelist.UnscheduleReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := schedule(rl, "q", 11, 20*time.Millisecond); err != nil {
+		t.Fatalf("agendamento depois do cancelamento: %v", err)
+	}
+	if err := waitFor(time.Second, "disparo com a lista no limite", func() (bool, error) {
+		got, err := values(rl, "q")
+		return len(got) == 3, err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := schedule(rl, "r", 12, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	_, err = schedule(rl, "r", 13, time.Hour)
+	if code := remotelist.LimitCode(err); code != remotelist.CodeStoreFull {
+		t.Fatalf("quinto elemento: erro %v, esperado %s", err, remotelist.CodeStoreFull)
+	}
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	var st remotelist.LimitStatsReply
+	if err := n.rl.LimitStats(remotelist.LimitStatsArgs{}, &st); err != nil || st.Elements != 4 {
+		t.Fatalf("elementos depois da queda: %d, %v", st.Elements, err)
+	}
+
+	// A migração leva os itens, com o mesmo instante de disparo.
+	dest, err := c.start(remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := schedule(n.rl, "r", 14, 0); err == nil {
+		t.Fatal("servidor cheio aceitou mais um item")
+	}
+	var data remotelist.ExportListReply
+	if err := n.rl.Peer().ExportList(remotelist.ExportListArgs{ListID: "r"}, &data); err != nil || len(data.Delayed) != 1 {
+		t.Fatalf("ExportList: %+v, %v", data, err)
+	}
+	if err := dest.rl.Peer().ImportList(remotelist.ImportListArgs{ListID: "r", Values: data.Values, Delayed: data.Delayed}, &remotelist.ImportListReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dest.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	items, err := scheduled(dest.rl, "r")
+	if err != nil || len(items) != 1 || items[0].Value != 12 || !items[0].Due.Equal(data.Delayed[0].Due) {
+		t.Fatalf("item migrado: %v, esperado %v (%v)", items, data.Delayed, err)
+	}
+}
@@ -0,0 +1,168 @@
+package remotelist
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+)
+
+// FaultOp é o tipo de operação em que uma falha pode ser injetada.
+type FaultOp string
+
+const (
+	FaultOpen   FaultOp = "open"
+	FaultWrite  FaultOp = "write"
+	FaultSync   FaultOp = "sync"
+	FaultRename FaultOp = "rename"
+	FaultRemove FaultOp = "remove"
+)
+
+// ErrInjected é o erro devolvido pelas falhas do FaultFS sem Err próprio.
+var ErrInjected = errors.New("falha de disco injetada")
+
+// Fault descreve uma falha: a N-ésima chamada de Op (contando só as que casam
+// com Match) falha. Com Torn, uma escrita grava metade dos bytes antes de
+// falhar, como uma escrita interrompida no meio. Com Sticky, todas as chamadas
+// a partir da N-ésima falham.
+type Fault struct {
+	Op     FaultOp
+	Nth    int    // 1 = a próxima chamada
+	Match  string // Parte do caminho do arquivo ("" = qualquer arquivo)
+	Torn   bool
+	Sticky bool
+	Err    error // nil = ErrInjected
+
+	calls int
+}
+
+// FaultFS envolve outro FS e faz operações escolhidas falharem.
+type FaultFS struct {
+	FS
+
+	mu       sync.Mutex
+	faults   []*Fault
+	injected int
+}
+
+// NewFaultFS cria um FaultFS sobre inner, sem nenhuma falha programada.
+func NewFaultFS(inner FS) *FaultFS {
+	return &FaultFS{FS: inner}
+}
+
+// Inject programa uma falha. A contagem de chamadas começa agora.
+func (f *FaultFS) Inject(fault Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if fault.Nth < 1 {
+		fault.Nth = 1
+	}
+	fault.calls = 0
+	f.faults = append(f.faults, &fault)
+}
+
+// Clear remove todas as falhas programadas.
+func (f *FaultFS) Clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = nil
+}
+
+// Injected devolve quantas falhas já foram disparadas.
+func (f *FaultFS) Injected() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.injected
+}
+
+// check conta a chamada e devolve a falha que ela dispara, se houver.
+func (f *FaultFS) check(op FaultOp, path string) *Fault {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var fired *Fault
+	remaining := f.faults[:0]
+	for _, fault := range f.faults {
+		if fault.Op != op || !strings.Contains(path, fault.Match) {
+			remaining = append(remaining, fault)
+			continue
+		}
+		fault.calls++
+		hit := fault.calls == fault.Nth || (fault.Sticky && fault.calls > fault.Nth)
+		if hit && fired == nil {
+			fired = fault
+		}
+		// Uma falha não persistente que já disparou sai da lista.
+		if !(hit && !fault.Sticky) {
+			remaining = append(remaining, fault)
+		}
+	}
+	f.faults = remaining
+	if fired != nil {
+		f.injected++
+	}
+	return fired
+}
+
+func (fault *Fault) error() error {
+	if fault.Err != nil {
+		return fault.Err
+	}
+	return ErrInjected
+}
+
+func (f *FaultFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if fault := f.check(FaultOpen, name); fault != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fault.error()}
+	}
+	file, err := f.FS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{File: file, fs: f, name: name}, nil
+}
+
+func (f *FaultFS) Rename(oldpath, newpath string) error {
+	if fault := f.check(FaultRename, newpath); fault != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fault.error()}
+	}
+	return f.FS.Rename(oldpath, newpath)
+}
+
+func (f *FaultFS) Remove(name string) error {
+	if fault := f.check(FaultRemove, name); fault != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: fault.error()}
+	}
+	return f.FS.Remove(name)
+}
+
+// faultFile é um arquivo aberto por um FaultFS.
+type faultFile struct {
+	File
+	fs   *FaultFS
+	name string
+}
+
+func (ff *faultFile) Write(p []byte) (int, error) {
+	fault := ff.fs.check(FaultWrite, ff.name)
+	if fault == nil {
+		return ff.File.Write(p)
+	}
+	if fault.Torn && len(p) > 1 {
+		n, err := ff.File.Write(p[:len(p)/2])
+		if err != nil {
+			return n, err
+		}
+		return n, &fs.PathError{Op: "write", Path: ff.name, Err: fault.error()}
+	}
+	return 0, &fs.PathError{Op: "write", Path: ff.name, Err: fault.error()}
+}
+
+// Sync com falha não sincroniza nada: o que foi escrito continua sujeito a
+// se perder em uma queda (no MemFS, some no Crash).
+func (ff *faultFile) Sync() error {
+	if fault := ff.fs.check(FaultSync, ff.name); fault != nil {
+		return &fs.PathError{Op: "sync", Path: ff.name, Err: fault.error()}
+	}
+	return ff.File.Sync()
+}
@@ -0,0 +1,444 @@
+package remotelist_test
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"remotelist/pkg"
+)
+
+// Garantias do WAL sob falhas de disco.
+//
+// Cada teste roda o RemoteList sobre um MemFS envolvido por um FaultFS,
+// injeta falhas (escrita, escrita pela metade, fsync, rename) e confere as
+// invariantes do write-ahead log:
+//
+//   - uma operação que retornou erro não mudou a memória;
+//   - toda operação confirmada sobrevive a uma queda (só o que passou por
+//     fsync fica no MemFS) e a um reinício normal;
+//   - nenhuma operação que falhou reaparece depois do replay.
+
+// newFaultHarness abre o servidor sobre um MemFS vazio e o fecha no fim do teste.
+func newFaultHarness(t *testing.T) *faultHarness {
+	mem := remotelist.NewMemFS()
+	mem.MkdirAll(dataDir, 0755)
+	h := &faultHarness{mem: mem, ffs: remotelist.NewFaultFS(mem), model: make(map[string][]int)}
+	if err := h.open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.close)
+	return h
+}
+
+// faultHarness guarda o servidor em teste e o modelo do que ele deve conter:
+// o modelo só muda quando uma operação é confirmada.
+type faultHarness struct {
+	mem   *remotelist.MemFS
+	ffs   *remotelist.FaultFS
+	rl    *remotelist.RemoteList
+	model map[string][]int
+
+	injected int // Falhas disparadas por FaultFS já descartados em quedas
+}
+
+func (h *faultHarness) open() error {
+	rl, err := remotelist.NewRemoteListWithConfig(remotelist.Config{Dir: dataDir, FS: h.ffs, SnapshotInterval: -1})
+	if err != nil {
+		return err
+	}
+	h.rl = rl
+	return nil
+}
+
+func (h *faultHarness) close() {
+	if h.rl != nil {
+		h.rl.Close()
+		h.rl = nil
+	}
+}
+
+// crash abandona o servidor sem Close e reabre sobre o que estava sincronizado.
+func (h *faultHarness) crash() error {
+	h.rl = nil
+	h.injected += h.ffs.Injected()
+	h.mem = h.mem.Crash()
+	h.ffs = remotelist.NewFaultFS(h.mem)
+	return h.open()
+}
+
+// restart fecha o servidor normalmente (sem falhas injetadas) e o reabre.
+func (h *faultHarness) restart() error {
+	h.ffs.Clear()
+	err := h.rl.Close()
+	h.rl = nil
+	if err != nil {
+		return fmt.Errorf("Close: %w", err)
+	}
+	return h.open()
+}
+
+// append chama Append e atualiza o modelo se a operação foi confirmada.
+func (h *faultHarness) append(list string, v int) error {
+	var reply remotelist.AppendReply
+	err := h.rl.Append(remotelist.AppendArgs{ListID: list, Value: v}, &reply)
+	if err == nil {
+		h.model[list] = append(h.model[list], v)
+	}
+	return err
+}
+
+// remove chama Remove e atualiza o modelo se a operação foi confirmada.
+func (h *faultHarness) remove(list string) error {
+	var reply remotelist.RemoveReply
+	err := h.rl.Remove(remotelist.RemoveArgs{ListID: list}, &reply)
+	if err != nil {
+		return err
+	}
+	data := h.model[list]
+	if want := data[len(data)-1]; reply.Value != want {
+		return fmt.Errorf("Remove(%s) = %d, esperado %d", list, reply.Value, want)
+	}
+	h.model[list] = data[:len(data)-1]
+	return nil
+}
+
+// state lê todas as listas do servidor pelos métodos RPC.
+func (h *faultHarness) state() (map[string][]int, error) {
+	var lists remotelist.ListsReply
+	if err := h.rl.Lists(remotelist.ListsArgs{}, &lists); err != nil {
+		return nil, err
+	}
+	out := make(map[string][]int)
+	for _, id := range lists.ListIDs {
+		var size remotelist.SizeReply
+		if err := h.rl.Size(remotelist.SizeArgs{ListID: id}, &size); err != nil {
+			return nil, err
+		}
+		data := []int{}
+		for i := 0; i < size.Size; i++ {
+			var get remotelist.GetReply
+			if err := h.rl.Get(remotelist.GetArgs{ListID: id, Index: i}, &get); err != nil {
+				return nil, err
+			}
+			data = append(data, get.Value)
+		}
+		if len(data) > 0 {
+			out[id] = data
+		}
+	}
+	return out, nil
+}
+
+// verify compara o servidor com o modelo (listas vazias equivalem a inexistentes).
+func (h *faultHarness) verify(when string) error {
+	got, err := h.state()
+	if err != nil {
+		return fmt.Errorf("%s: %w", when, err)
+	}
+	want := make(map[string][]int)
+	for id, data := range h.model {
+		if len(data) > 0 {
+			want[id] = data
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		return fmt.Errorf("%s: estado %v, esperado %v", when, got, want)
+	}
+	return nil
+}
+
+// expectFailure confere que a operação falhou por causa da falha injetada.
+func expectFailure(err error, what string) error {
+	if err == nil {
+		return fmt.Errorf("%s deveria falhar", what)
+	}
+	if !errors.Is(err, remotelist.ErrInjected) {
+		return fmt.Errorf("%s falhou com erro inesperado: %w", what, err)
+	}
+	return nil
+}
+
+// fill confirma alguns valores em duas listas.
+func (h *faultHarness) fill() error {
+	for i := 1; i <= 3; i++ {
+		if err := h.append("a", i); err != nil {
+			return err
+		}
+		if err := h.append("b", 10*i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkAfterRestarts verifica o estado agora, após um reinício normal e após uma queda.
+func (h *faultHarness) checkAfterRestarts() error {
+	if err := h.verify("antes do reinício"); err != nil {
+		return err
+	}
+	if err := h.restart(); err != nil {
+		return err
+	}
+	if err := h.verify("após reinício"); err != nil {
+		return err
+	}
+	if err := h.crash(); err != nil {
+		return err
+	}
+	return h.verify("após queda")
+}
+
+// failOne injeta fault, executa uma operação que deve falhar e confere que
+// a memória não mudou; depois confirma mais operações.
+func (h *faultHarness) failOne(fault remotelist.Fault) error {
+	if err := h.fill(); err != nil {
+		return err
+	}
+	h.ffs.Inject(fault)
+	if err := expectFailure(h.append("a", 99), "Append com falha"); err != nil {
+		return err
+	}
+	if err := h.verify("após a falha"); err != nil {
+		return err
+	}
+	if err := h.append("a", 4); err != nil {
+		return fmt.Errorf("Append após a falha: %w", err)
+	}
+	return h.remove("b")
+}
+
+func TestFaultWriteFailure(t *testing.T) {
+	h := newFaultHarness(t)
+	if err := h.failOne(remotelist.Fault{Op: remotelist.FaultWrite, Match: ".log."}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.checkAfterRestarts(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFaultTornWrite(t *testing.T) {
+	h := newFaultHarness(t)
+	if err := h.failOne(remotelist.Fault{Op: remotelist.FaultWrite, Match: ".log.", Torn: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.checkAfterRestarts(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Um fsync que falhou deixa a linha no arquivo: ela não pode voltar no replay
+// de um reinício normal, em que nada se perde.
+func TestFaultSyncFailure(t *testing.T) {
+	h := newFaultHarness(t)
+	if err := h.failOne(remotelist.Fault{Op: remotelist.FaultSync, Match: ".log."}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.checkAfterRestarts(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A escrita do ABORT também falha: a operação seguinte é recusada sem ser logada.
+func TestFaultRepairFailure(t *testing.T) {
+	h := newFaultHarness(t)
+	if err := h.fill(); err != nil {
+		t.Fatal(err)
+	}
+	h.ffs.Inject(remotelist.Fault{Op: remotelist.FaultWrite, Match: ".log.", Nth: 1, Torn: true})
+	h.ffs.Inject(remotelist.Fault{Op: remotelist.FaultWrite, Match: ".log.", Nth: 2, Torn: true})
+	if err := expectFailure(h.append("a", 99), "primeiro Append"); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectFailure(h.append("a", 98), "segundo Append"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.verify("após as falhas"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.append("a", 4); err != nil {
+		t.Fatalf("Append após o reparo: %v", err)
+	}
+	if err := h.checkAfterRestarts(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A falha é a última operação antes de Close: o ABORT é gravado no fechamento.
+func TestFaultFailureThenClose(t *testing.T) {
+	h := newFaultHarness(t)
+	if err := h.fill(); err != nil {
+		t.Fatal(err)
+	}
+	h.ffs.Inject(remotelist.Fault{Op: remotelist.FaultSync, Match: ".log."})
+	if err := expectFailure(h.remove("a"), "Remove com falha"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.checkAfterRestarts(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A falha é seguida de um snapshot que não chega a ser gravado: o replay usa os
+// segmentos antigos, que precisam conter o ABORT.
+func TestFaultFailureThenSnapshot(t *testing.T) {
+	h := newFaultHarness(t)
+	if err := h.fill(); err != nil {
+		t.Fatal(err)
+	}
+	h.ffs.Inject(remotelist.Fault{Op: remotelist.FaultSync, Match: ".log."})
+	if err := expectFailure(h.append("a", 99), "Append com falha"); err != nil {
+		t.Fatal(err)
+	}
+	h.ffs.Inject(remotelist.Fault{Op: remotelist.FaultRename, Match: "remotelist.json"})
+	if err := expectFailure(h.rl.Snapshot(), "Snapshot"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.checkAfterRestarts(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFaultSnapshotWriteFailure(t *testing.T) {
+	h := newFaultHarness(t)
+	if err := h.fill(); err != nil {
+		t.Fatal(err)
+	}
+	h.ffs.Inject(remotelist.Fault{Op: remotelist.FaultWrite, Match: "remotelist.json", Torn: true})
+	if err := expectFailure(h.rl.Snapshot(), "Snapshot"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.append("a", 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.checkAfterRestarts(); err != nil {
+		t.Fatal(err)
+	}
+	// Um snapshot bom depois da falha continua funcionando.
+	if err := h.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.checkAfterRestarts(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFaultSnapshotRenameFailure(t *testing.T) {
+	h := newFaultHarness(t)
+	if err := h.fill(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.append("b", 40); err != nil {
+		t.Fatal(err)
+	}
+	h.ffs.Inject(remotelist.Fault{Op: remotelist.FaultRename, Match: "remotelist.json"})
+	if err := expectFailure(h.rl.Snapshot(), "Snapshot"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.checkAfterRestarts(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// O segmento novo não pode ser criado: o snapshot falha antes de tocar em
+// qualquer coisa e as escritas continuam no segmento atual.
+func TestFaultSegmentOpenFailure(t *testing.T) {
+	h := newFaultHarness(t)
+	if err := h.fill(); err != nil {
+		t.Fatal(err)
+	}
+	h.ffs.Inject(remotelist.Fault{Op: remotelist.FaultOpen, Match: ".log."})
+	if err := expectFailure(h.rl.Snapshot(), "Snapshot"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.append("a", 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.checkAfterRestarts(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestFaultRandom mistura operações, falhas aleatórias, snapshots, reinícios e quedas,
+// conferindo o modelo depois de cada falha e de cada reinício.
+func TestFaultRandom(t *testing.T) {
+	h := newFaultHarness(t)
+	seed, ops := time.Now().UnixNano(), 5000
+	if testing.Short() {
+		ops = 1000
+	}
+	t.Logf("semente %d", seed)
+	r := rand.New(rand.NewSource(seed))
+	lists := []string{"a", "b", "c", "d"}
+	faultOps := []remotelist.FaultOp{remotelist.FaultWrite, remotelist.FaultSync, remotelist.FaultOpen, remotelist.FaultRename}
+
+	for i := 0; i < ops; i++ {
+		if r.Intn(20) == 0 {
+			h.ffs.Inject(remotelist.Fault{
+				Op:   faultOps[r.Intn(len(faultOps))],
+				Nth:  1 + r.Intn(3),
+				Torn: r.Intn(2) == 0,
+			})
+		}
+
+		list := lists[r.Intn(len(lists))]
+		var err error
+		switch n := r.Intn(100); {
+		case n < 60:
+			err = h.append(list, r.Intn(1000))
+		case n < 90:
+			if len(h.model[list]) == 0 {
+				continue
+			}
+			err = h.remove(list)
+		case n < 95:
+			err = h.rl.Snapshot()
+		case n < 98:
+			err = h.restart()
+			if err == nil {
+				err = h.verify(fmt.Sprintf("op %d: após reinício", i))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		default:
+			if err := h.crash(); err != nil {
+				t.Fatal(err)
+			}
+			if err := h.verify(fmt.Sprintf("op %d: após queda", i)); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		if err != nil {
+			if !errors.Is(err, remotelist.ErrInjected) {
+				t.Fatalf("op %d: erro inesperado: %v", i, err)
+			}
+			if err := h.verify(fmt.Sprintf("op %d: após falha", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	h.ffs.Clear()
+	if h.injected+h.ffs.Injected() == 0 {
+		t.Fatal("nenhuma falha foi injetada")
+	}
+	if err := h.checkAfterRestarts(); err != nil {
+		t.Fatal(err)
+	}
+}
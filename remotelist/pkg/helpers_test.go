@@ -0,0 +1,173 @@
+package remotelist_test
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net"
+	"net/rpc"
+	"os"
+	"testing"
+
+	"remotelist/pkg"
+)
+
+// dataDir é o diretório de dados dos servidores sobre MemFS.
+const dataDir = "data"
+
+// TestMain cala o log do servidor (uma linha por lista criada, por segmento
+// aberto...), que só aparece com -v.
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+// --- Servidores em processo ---
+
+// node é um servidor RemoteList rodando neste processo, com o seu disco em
+// memória (que simula quedas) e a sua porta TCP. O endereço é mantido entre
+// reinícios, para os clientes (e o primário ou os backups) o reencontrarem.
+type node struct {
+	name  string
+	addr  string
+	mem   *remotelist.MemFS
+	fault *remotelist.FaultFS // Entre o RemoteList e mem, se o teste injeta falhas
+	cfg   remotelist.Config
+	rl    *remotelist.RemoteList
+	l     net.Listener
+}
+
+// cluster guarda os servidores de um teste e os clientes abertos para eles.
+type cluster struct {
+	nodes   []*node
+	clients []*rpc.Client
+}
+
+// newCluster devolve um cluster vazio, derrubado no fim do teste.
+func newCluster(t *testing.T) *cluster {
+	c := &cluster{}
+	t.Cleanup(c.close)
+	return c
+}
+
+// add cria um servidor parado, já com a porta reservada.
+func (c *cluster) add(name string, faults bool) (*node, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	n := &node{name: name, addr: l.Addr().String(), mem: remotelist.NewMemFS()}
+	l.Close()
+	if faults {
+		n.fault = remotelist.NewFaultFS(n.mem)
+	}
+	c.nodes = append(c.nodes, n)
+	return n, nil
+}
+
+// start sobe um servidor novo com cfg.
+func (c *cluster) start(cfg remotelist.Config) (*node, error) {
+	n, err := c.add("", false)
+	if err != nil {
+		return nil, err
+	}
+	return n, n.start(cfg)
+}
+
+// start abre o RemoteList com cfg (em memória, sem snapshots automáticos e,
+// se cfg não disser outra coisa, sem o reaper de TTL) e passa a atender no
+// endereço do nó.
+func (n *node) start(cfg remotelist.Config) error {
+	cfg.Dir, cfg.SnapshotInterval = dataDir, -1
+	if cfg.ReapInterval == 0 {
+		cfg.ReapInterval = -1
+	}
+	cfg.FS = n.mem
+	if n.fault != nil {
+		cfg.FS = n.fault
+	}
+	n.mem.MkdirAll(dataDir, 0755)
+	rl, err := remotelist.NewRemoteListWithConfig(cfg)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", n.addr)
+	if err != nil {
+		rl.Close()
+		return err
+	}
+	rpcs := rpc.NewServer()
+	if err := rpcs.RegisterName("RemoteList", rl); err != nil {
+		l.Close()
+		rl.Close()
+		return err
+	}
+	if err := rpcs.RegisterName("Peer", rl.Peer()); err != nil {
+		l.Close()
+		rl.Close()
+		return err
+	}
+	go serve(l, rl, rpcs)
+	n.rl, n.l, n.cfg = rl, l, cfg
+	return nil
+}
+
+// stop fecha a porta (e as conexões) e o RemoteList.
+func (n *node) stop() {
+	if n.rl == nil {
+		return
+	}
+	n.l.Close()
+	n.rl.Close()
+	n.rl = nil
+}
+
+// crash simula uma queda: o disco fica só com o que foi sincronizado, e o
+// servidor fica parado.
+func (n *node) crash() {
+	n.l.Close()
+	crashed := n.mem.Crash()
+	n.rl.Close() // Só para as goroutines; o que ele gravar fica no disco antigo
+	n.mem, n.rl = crashed, nil
+	if n.fault != nil {
+		n.fault = remotelist.NewFaultFS(n.mem)
+	}
+}
+
+// reboot simula uma queda e reabre o servidor, com a mesma configuração,
+// sobre o que foi sincronizado.
+func (n *node) reboot() error {
+	n.crash()
+	return n.start(n.cfg)
+}
+
+// serve atende as conexões da porta até ela ser fechada, e então fecha as que
+// ainda estiverem abertas.
+func serve(l net.Listener, rl *remotelist.RemoteList, rpcs *rpc.Server) {
+	var conns []net.Conn
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conns = append(conns, conn)
+		go rl.ServeConn(rpcs, conn)
+	}
+}
+
+func (c *cluster) close() {
+	for _, cl := range c.clients {
+		cl.Close()
+	}
+	for _, n := range c.nodes {
+		n.stop()
+	}
+}
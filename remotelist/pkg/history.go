@@ -0,0 +1,548 @@
+package remotelist
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Recuperação no tempo (PITR) ---
+//
+// Com Config.ArchiveDir definido, cada snapshot ganha uma cópia
+// remotelist.json.<last_seq> no arquivo e os segmentos do log cobertos por ele
+// são movidos para lá. Qualquer estado passado pode então ser reconstruído a
+// partir do snapshot mais novo anterior ao ponto desejado mais o replay dos
+// segmentos seguintes, parando no ponto desejado.
+
+// RecoveryTarget identifica um ponto da história. Os campos zerados não limitam:
+// Time zero significa "sem limite de tempo" e Seq zero "sem limite de sequência".
+type RecoveryTarget struct {
+	Time time.Time
+	Seq  uint64
+}
+
+// includes diz se o registro aconteceu até o ponto alvo.
+func (t RecoveryTarget) includes(rec LogRecord) bool {
+	if t.Seq != 0 && rec.Seq > t.Seq {
+		return false
+	}
+	if !t.Time.IsZero() && rec.Time.After(t.Time) {
+		return false
+	}
+	return true
+}
+
+// allowsSnapshot diz se o snapshot não contém nada posterior ao ponto alvo.
+func (t RecoveryTarget) allowsSnapshot(s snapshotState) bool {
+	if t.Seq != 0 && s.LastSeq > t.Seq {
+		return false
+	}
+	if !t.Time.IsZero() && s.CreatedAt.After(t.Time) {
+		return false
+	}
+	return true
+}
+
+// GetAtArgs pede o valor de um índice como ele estava em AsOf (ou em AsOfSeq).
+type GetAtArgs struct {
+	ListID  string
+	Index   int
+	AsOf    time.Time
+	AsOfSeq uint64
+}
+type GetAtReply struct {
+	Value int
+}
+
+const (
+	// historyCacheSize é o número de estados reconstruídos guardados pelo GetAt.
+	historyCacheSize = 8
+	// maxHistoryRebuilds limita as reconstruções do GetAt feitas ao mesmo
+	// tempo: cada uma lê um snapshot e segmentos do log inteiros.
+	maxHistoryRebuilds = 2
+)
+
+// historyCache guarda os últimos estados reconstruídos pelo GetAt e os
+// segmentos que as reconstruções em andamento estão lendo.
+type historyCache struct {
+	mu       sync.Mutex
+	entries  []*historyEntry // Do usado mais recentemente ao mais antigo
+	pins     map[*segmentPin]struct{}
+	rebuilds chan struct{} // Vagas para reconstruções (ver maxHistoryRebuilds)
+}
+
+// historyEntry é um estado reconstruído: o snapshot base mais o log até o
+// registro last. Ele vale para qualquer alvo que inclua last mas não stop, o
+// registro seguinte. As listas não mudam depois de reconstruídas.
+type historyEntry struct {
+	base  uint64    // LastSeq do snapshot base
+	at    time.Time // Instante do último registro aplicado, para os TTLs
+	last  LogRecord
+	stop  LogRecord
+	lists map[string]*ManagedList
+}
+
+func (e *historyEntry) covers(target RecoveryTarget) bool {
+	return target.includes(e.last) && !target.includes(e.stop)
+}
+
+// segmentPin marca os segmentos a partir de from como em leitura: retireSegments
+// não os move nem apaga.
+type segmentPin struct {
+	from uint64
+}
+
+// lookup procura no cache um estado que vale para target.
+func (h *historyCache) lookup(target RecoveryTarget) *historyEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, e := range h.entries {
+		if e.covers(target) {
+			copy(h.entries[1:i+1], h.entries[:i])
+			h.entries[0] = e
+			return e
+		}
+	}
+	return nil
+}
+
+// add guarda e no cache, descartando o usado há mais tempo se ele estiver cheio.
+func (h *historyCache) add(e *historyEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, old := range h.entries {
+		if old.base == e.base && old.last.Seq == e.last.Seq {
+			h.entries = append(h.entries[:i], h.entries[i+1:]...)
+			break
+		}
+	}
+	if len(h.entries) == historyCacheSize {
+		h.entries = h.entries[:historyCacheSize-1]
+	}
+	h.entries = append([]*historyEntry{e}, h.entries...)
+}
+
+// pin marca todos os segmentos como em leitura, até raise ou unpin.
+func (h *historyCache) pin() *segmentPin {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.pins == nil {
+		h.pins = make(map[*segmentPin]struct{})
+	}
+	p := &segmentPin{}
+	h.pins[p] = struct{}{}
+	return p
+}
+
+// raise libera os segmentos anteriores a from.
+func (h *historyCache) raise(p *segmentPin, from uint64) {
+	h.mu.Lock()
+	p.from = from
+	h.mu.Unlock()
+}
+
+func (h *historyCache) unpin(p *segmentPin) {
+	h.mu.Lock()
+	delete(h.pins, p)
+	h.mu.Unlock()
+}
+
+// retire chama fn, que move ou apaga o segmento n, se nenhuma reconstrução
+// estiver lendo n. O lock fica com fn: uma reconstrução não começa no meio da
+// mudança, quando o segmento poderia não estar em nenhum dos diretórios.
+// Devolve falso se n estava em leitura (ele fica para o próximo snapshot).
+func (h *historyCache) retire(n uint64, fn func() error) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for p := range h.pins {
+		if n >= p.from {
+			return false, nil
+		}
+	}
+	return true, fn()
+}
+
+// GetAt é a versão somente leitura de Get sobre o estado de um instante passado.
+// Só enxerga além do último snapshot se Config.ArchiveDir estiver definido.
+func (rl *RemoteList) GetAt(args GetAtArgs, reply *GetAtReply) error {
+	target := RecoveryTarget{Time: args.AsOf, Seq: args.AsOfSeq}
+	if target.Time.IsZero() && target.Seq == 0 {
+		return errors.New("informe AsOf ou AsOfSeq")
+	}
+
+	state, err := rl.stateAt(target)
+	if err != nil {
+		return err
+	}
+
+	// Uma lista com o TTL vencido já não existia para os leitores.
+	at := state.at
+	if !target.Time.IsZero() {
+		at = target.Time
+	}
+	ml, exists := state.lists[args.ListID]
+	if !exists || ml.kind != KindList || ml.expired(at) {
+		return errors.New("lista não encontrada")
+	}
+	if args.Index < 0 || args.Index >= ml.len() {
+		return errors.New("índice fora dos limites")
+	}
+	reply.Value = ml.at(args.Index)
+	return nil
+}
+
+// stateAt reconstrói (ou reaproveita do cache) o estado em target. Em vez de
+// parar os snapshots, a reconstrução marca os segmentos que lê (ver
+// historyCache.retire).
+func (rl *RemoteList) stateAt(target RecoveryTarget) (*historyEntry, error) {
+	if e := rl.history.lookup(target); e != nil {
+		return e, nil
+	}
+	rl.history.rebuilds <- struct{}{}
+	defer func() { <-rl.history.rebuilds }()
+	// Outra reconstrução pode ter feito este estado enquanto esta esperava.
+	if e := rl.history.lookup(target); e != nil {
+		return e, nil
+	}
+
+	// O que for logado depois daqui tem número maior e, no primário, instante
+	// posterior a now.
+	rl.logLock.Lock()
+	seq, now := rl.seq, time.Now()
+	rl.logLock.Unlock()
+
+	pin := rl.history.pin()
+	defer rl.history.unpin(pin)
+	r, err := restoreState(rl.fs, rl.cfg.Dir, rl.cfg.ArchiveDir, target, func(base snapshotState) {
+		rl.history.raise(pin, base.NextSegment)
+	})
+	if err != nil {
+		return nil, err
+	}
+	e := &historyEntry{base: r.base, at: r.at, last: r.last, lists: r.lists}
+	if r.stop != nil {
+		e.stop = *r.stop
+	} else {
+		// O log acabou antes do alvo. Num backup, os instantes são os do
+		// primário: só o número do próximo registro é conhecido.
+		e.stop = LogRecord{Seq: max(r.last.Seq, seq) + 1, Time: r.last.Time}
+		if !rl.readOnly.Load() {
+			e.stop.Time = now
+		}
+	}
+	// Um alvo que inclui o próximo registro ainda pode mudar: não vai para o cache.
+	if e.covers(target) {
+		rl.history.add(e)
+	}
+	return e, nil
+}
+
+// archiveSnapshot copia o snapshot recém gravado para o diretório de arquivo.
+func (rl *RemoteList) archiveSnapshot(lastSeq uint64) error {
+	if err := rl.fs.MkdirAll(rl.cfg.ArchiveDir, 0755); err != nil {
+		return fmt.Errorf("falha ao criar diretório de arquivo: %w", err)
+	}
+	content, err := readFile(rl.fs, rl.path(snapshotFile))
+	if err != nil {
+		return err
+	}
+	// Pelo nome temporário, o GetAt nunca vê a cópia pela metade.
+	dst := filepath.Join(rl.cfg.ArchiveDir, archivedSnapshotName(lastSeq))
+	if err := writeFileSync(rl.fs, dst+".tmp", content); err != nil {
+		return fmt.Errorf("falha ao arquivar snapshot: %w", err)
+	}
+	if err := rl.fs.Rename(dst+".tmp", dst); err != nil {
+		return fmt.Errorf("falha ao arquivar snapshot: %w", err)
+	}
+	return syncDir(rl.fs, rl.cfg.ArchiveDir)
+}
+
+// archivedSnapshotName devolve o nome da cópia arquivada de um snapshot.
+func archivedSnapshotName(lastSeq uint64) string {
+	return fmt.Sprintf("%s.%020d", snapshotFile, lastSeq)
+}
+
+// RestoreState reconstrói as listas como estavam no ponto target, usando os
+// snapshots e segmentos do diretório de dados e do diretório de arquivo.
+// Retorna também o número de sequência do último registro aplicado.
+func RestoreState(dataDir, archiveDir string, target RecoveryTarget) (map[string][]int, uint64, error) {
+	fsys, err := offlineFS()
+	if err != nil {
+		return nil, 0, err
+	}
+	r, err := restoreState(fsys, dataDir, archiveDir, target, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	// As listas restauradas não levam o TTL.
+	at := r.at
+	if !target.Time.IsZero() {
+		at = target.Time
+	}
+	out := make(map[string][]int, len(r.lists))
+	for id, ml := range r.lists {
+		if ml.kind == KindList && !ml.expired(at) {
+			out[id] = ml.values()
+		}
+	}
+	return out, r.last.Seq, nil
+}
+
+// restored é um estado reconstruído por restoreState.
+type restored struct {
+	lists map[string]*ManagedList // Todas as chaves, inclusive as com TTL vencido
+	base  uint64                  // LastSeq do snapshot base
+	at    time.Time               // Instante do último registro aplicado
+	last  LogRecord               // Último registro aplicado (Seq e Time; os do snapshot se nenhum)
+	stop  *LogRecord              // Primeiro registro depois do alvo (nil: o log acabou antes)
+}
+
+// restoreState reconstrói o estado em target. onBase, se não for nil, recebe o
+// snapshot base escolhido antes da leitura dos segmentos.
+func restoreState(fsys FS, dataDir, archiveDir string, target RecoveryTarget, onBase func(snapshotState)) (restored, error) {
+	base, err := pickBaseSnapshot(fsys, dataDir, archiveDir, target)
+	if err != nil {
+		return restored{}, err
+	}
+	if onBase != nil {
+		onBase(base)
+	}
+
+	lists := make(map[string]*ManagedList, len(base.Lists))
+	for id, data := range base.Lists {
+		lists[id] = newManagedList(data)
+	}
+	restoreStructures(lists, base.Structures)
+	restoreExpires(lists, base.Expires)
+	restoreDelayed(lists, base.Delayed)
+	at := base.CreatedAt // Instante do estado reconstruído, para os TTLs
+
+	segments, err := historySegments(fsys, dataDir, archiveDir)
+	if err != nil {
+		return restored{}, err
+	}
+	var paths []string
+	for _, seg := range segments {
+		if seg.n >= base.NextSegment {
+			paths = append(paths, seg.path)
+		}
+	}
+	aborted, err := collectAborted(fsys, paths)
+	if err != nil {
+		return restored{}, err
+	}
+
+	expectedSegment := base.NextSegment
+	last := LogRecord{Seq: base.LastSeq, Time: base.CreatedAt}
+	var stop *LogRecord
+	errStop := errors.New("ponto alvo alcançado")
+	for i, seg := range segments {
+		if seg.n < base.NextSegment {
+			continue
+		}
+		// Os segmentos são numerados sem buracos: um número faltando significa que
+		// parte da história entre a base e o ponto alvo não existe mais.
+		// Sem snapshot base, a história pode começar no segmento 0 (log antigo) ou no 1.
+		if seg.n != expectedSegment && !(expectedSegment == 0 && seg.n == 1) {
+			return restored{}, fmt.Errorf("histórico incompleto: falta o segmento %d", expectedSegment)
+		}
+		expectedSegment = seg.n + 1
+		// O mais novo pode ser o segmento que o servidor está escrevendo.
+		open := openRead
+		if i == len(segments)-1 {
+			open = openTail
+		}
+		f, err := open(fsys, seg.path)
+		if err != nil {
+			return restored{}, err
+		}
+		err = scanLog(f, func(lineNo int, line string, rec LogRecord, err error) error {
+			if err != nil {
+				log.Printf("Segmento %d, linha %d inválida (%v), pulando.", seg.n, lineNo, err)
+				return nil
+			}
+			// Registros repetidos (lote reenviado a um backup) valem uma vez só.
+			if rec.Seq != 0 && rec.Seq <= last.Seq {
+				return nil
+			}
+			if !target.includes(rec) {
+				stop = &LogRecord{Seq: rec.Seq, Time: rec.Time}
+				return errStop
+			}
+			if rec.Seq != 0 && aborted[rec.Seq] {
+				last = LogRecord{Seq: rec.Seq, Time: rec.Time}
+				return nil
+			}
+			if err := applyRecord(lists, rec); err != nil {
+				log.Printf("Segmento %d, linha %d ignorada: %v", seg.n, lineNo, err)
+			}
+			if rec.Seq != 0 {
+				last = LogRecord{Seq: rec.Seq, Time: rec.Time}
+			}
+			if rec.Time.After(at) {
+				at = rec.Time
+			}
+			return nil
+		})
+		f.Close()
+		if err == errStop {
+			break
+		}
+		if err != nil {
+			return restored{}, err
+		}
+	}
+
+	// Uma lista com o TTL vencido já não existia para os leitores, mesmo que o
+	// DELETE dela tenha vindo depois: quem lê compara os prazos com at (ou com o
+	// instante alvo).
+	return restored{lists: lists, base: base.LastSeq, at: at, last: last, stop: stop}, nil
+}
+
+// pickBaseSnapshot escolhe o snapshot mais novo que não passa do ponto alvo.
+// Sem nenhum candidato, devolve um estado vazio (a história começa do zero).
+func pickBaseSnapshot(fsys FS, dataDir, archiveDir string, target RecoveryTarget) (snapshotState, error) {
+	// Um snapshot novo pode substituir o atual entre a leitura do cabeçalho e a
+	// do corpo: aí a escolha é refeita.
+	for attempt := 0; attempt < 3; attempt++ {
+		state, path, err := pickBaseSnapshotOnce(fsys, dataDir, archiveDir, target)
+		if err != nil || path == "" {
+			return state, err
+		}
+		f, err := openRead(fsys, path)
+		if err != nil {
+			return snapshotState{}, err
+		}
+		full, err := decodeSnapshot(f)
+		f.Close()
+		if err != nil {
+			return snapshotState{}, fmt.Errorf("snapshot %s: %w", path, err)
+		}
+		if full.LastSeq == state.LastSeq {
+			return full, nil
+		}
+	}
+	return snapshotState{}, errors.New("o snapshot mudou durante a leitura")
+}
+
+// pickBaseSnapshotOnce escolhe o snapshot base pelos cabeçalhos e devolve o
+// cabeçalho e o caminho dele ("" se nenhum serve).
+func pickBaseSnapshotOnce(fsys FS, dataDir, archiveDir string, target RecoveryTarget) (snapshotState, string, error) {
+	var candidates []string
+	candidates = append(candidates, filepath.Join(dirOrDot(dataDir), snapshotFile))
+	if archiveDir != "" {
+		names, err := fsys.ReadDir(archiveDir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return snapshotState{}, "", err
+		}
+		for _, name := range names {
+			suffix, ok := strings.CutPrefix(name, snapshotFile+".")
+			if _, err := strconv.ParseUint(suffix, 10, 64); ok && err == nil {
+				candidates = append(candidates, filepath.Join(archiveDir, name))
+			}
+		}
+	}
+
+	best := snapshotState{Lists: make(map[string][]int)}
+	bestPath, found := "", false
+	for _, path := range candidates {
+		f, err := openRead(fsys, path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return snapshotState{}, "", err
+		}
+		// Só o cabeçalho: o corpo é lido apenas do snapshot escolhido.
+		state, err := decodeSnapshotInfo(f)
+		f.Close()
+		if err != nil {
+			log.Printf("Snapshot %s ignorado: %v", path, err)
+			continue
+		}
+		if !target.allowsSnapshot(state) {
+			continue
+		}
+		if !found || state.LastSeq > best.LastSeq {
+			best, bestPath, found = state, path, true
+		}
+	}
+	return best, bestPath, nil
+}
+
+// historySegment é um segmento do log encontrado no diretório de dados ou no arquivo.
+type historySegment struct {
+	n    uint64
+	path string
+}
+
+// historySegments junta, em ordem, os segmentos dos dois diretórios.
+func historySegments(fsys FS, dataDir, archiveDir string) ([]historySegment, error) {
+	byNumber := make(map[uint64]string)
+	dirs := []string{dirOrDot(dataDir)}
+	if archiveDir != "" {
+		// O diretório de dados vem por último: se um segmento estiver nos dois
+		// (queda no meio do arquivamento), vale a cópia original.
+		dirs = []string{archiveDir, dirOrDot(dataDir)}
+	}
+	for _, dir := range dirs {
+		segments, err := listSegments(fsys, dir)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		for _, n := range segments {
+			byNumber[n] = filepath.Join(dir, segmentName(n))
+		}
+	}
+	out := make([]historySegment, 0, len(byNumber))
+	for n, path := range byNumber {
+		out = append(out, historySegment{n: n, path: path})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].n < out[j].n })
+	return out, nil
+}
+
+// dirOrDot trata "" como o diretório atual.
+func dirOrDot(dir string) string {
+	if dir == "" {
+		return "."
+	}
+	return dir
+}
+
+// WriteRestoredSnapshot grava em dir um snapshot com as listas restauradas,
+// pronto para um servidor iniciar a partir dele, com o corpo comprimido com
+// compression. O diretório não pode ter segmentos de log, que seriam
+// reaplicados por cima do estado restaurado.
+func WriteRestoredSnapshot(dir string, lists map[string][]int, lastSeq uint64, compression string) error {
+	if err := checkCompression(compression); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dirOrDot(dir), 0755); err != nil {
+		return err
+	}
+	segments, err := listSegments(OSFS{}, dir)
+	if err != nil {
+		return err
+	}
+	if len(segments) > 0 {
+		return fmt.Errorf("o diretório %s já tem segmentos de log", dirOrDot(dir))
+	}
+	fsys, err := offlineFS()
+	if err != nil {
+		return err
+	}
+	state := snapshotState{LastSeq: lastSeq, CreatedAt: time.Now(), Lists: lists}
+	return writeSnapshotFile(fsys, filepath.Join(dirOrDot(dir), snapshotFile), state, compression)
+}
@@ -0,0 +1,159 @@
+package remotelist_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"remotelist/pkg"
+)
+
+// Leituras do passado (GetAt) com o arquivo de snapshots e segmentos, com o
+// RemoteList sobre um MemFS.
+
+const archiveDir = "arquivo"
+
+// newHistServer abre um servidor que arquiva os segmentos cobertos pelos
+// snapshots, fechado no fim do teste.
+func newHistServer(t *testing.T) *remotelist.RemoteList {
+	mem := remotelist.NewMemFS()
+	mem.MkdirAll(dataDir, 0755)
+	mem.MkdirAll(archiveDir, 0755)
+	rl, err := remotelist.NewRemoteListWithConfig(remotelist.Config{
+		Dir: dataDir, FS: mem, SnapshotInterval: -1, ArchiveDir: archiveDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rl.Close() })
+	return rl
+}
+
+// appendN grava os valores de from até to-1 na lista "l". Num servidor novo,
+// o registro do valor v tem o número v+1.
+func appendN(rl *remotelist.RemoteList, from, to int) error {
+	for v := from; v < to; v++ {
+		if err := rl.Append(remotelist.AppendArgs{ListID: "l", Value: v}, &remotelist.AppendReply{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkAt confere que, no registro seq, a lista "l" tinha os valores de 0 até
+// seq-1.
+func checkAt(rl *remotelist.RemoteList, seq int) error {
+	var reply remotelist.GetAtReply
+	if err := rl.GetAt(remotelist.GetAtArgs{ListID: "l", Index: seq - 1, AsOfSeq: uint64(seq)}, &reply); err != nil {
+		return fmt.Errorf("seq %d: %w", seq, err)
+	}
+	if reply.Value != seq-1 {
+		return fmt.Errorf("seq %d: último valor %d, esperado %d", seq, reply.Value, seq-1)
+	}
+	if err := rl.GetAt(remotelist.GetAtArgs{ListID: "l", Index: seq, AsOfSeq: uint64(seq)}, &reply); err == nil {
+		return fmt.Errorf("seq %d: a lista tinha mais de %d valores", seq, seq)
+	}
+	return nil
+}
+
+func TestHistGetAt(t *testing.T) {
+	rl := newHistServer(t)
+	for round := 0; round < 3; round++ {
+		if err := appendN(rl, round*50, (round+1)*50); err != nil {
+			t.Fatal(err)
+		}
+		if err := rl.Snapshot(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := appendN(rl, 150, 170); err != nil {
+		t.Fatal(err)
+	}
+
+	// Antes, entre e depois dos snapshots; cada ponto duas vezes (a segunda
+	// vem do cache) e em ordem embaralhada.
+	for _, seq := range []int{1, 49, 50, 51, 120, 150, 151, 170, 1, 120, 49, 170, 10, 11, 12} {
+		if err := checkAt(rl, seq); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Um alvo além do fim vê o estado atual, mas não fica no cache: depois de
+	// novas escritas, ele enxerga as novas.
+	var reply remotelist.GetAtReply
+	if err := rl.GetAt(remotelist.GetAtArgs{ListID: "l", Index: 170, AsOfSeq: 500}, &reply); err == nil {
+		t.Fatal("seq 500 antes das escritas: índice 170 existia")
+	}
+	if err := appendN(rl, 170, 200); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.GetAt(remotelist.GetAtArgs{ListID: "l", Index: 199, AsOfSeq: 500}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Value != 199 {
+		t.Fatalf("seq 500: último valor %d, esperado 199", reply.Value)
+	}
+	if err := checkAt(rl, 185); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Leituras do passado não param os snapshots, que arquivam os segmentos
+// enquanto elas os leem.
+func TestHistConcurrentSnapshots(t *testing.T) {
+	rl := newHistServer(t)
+	if err := appendN(rl, 0, 20); err != nil {
+		t.Fatal(err)
+	}
+
+	const writes = 400
+	var written atomic.Int64
+	written.Store(20)
+	done := make(chan struct{})
+	errs := make(chan error, 5)
+	go func() {
+		defer close(done)
+		for v := 20; v < writes; v += 20 {
+			if err := appendN(rl, v, v+20); err != nil {
+				errs <- err
+				return
+			}
+			written.Store(int64(v + 20))
+			if err := rl.Snapshot(); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// Pontos espalhados por todo o histórico já escrito.
+				if err := checkAt(rl, 1+(i*37+r*11)%int(written.Load())); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	for _, seq := range []int{1, 20, 21, 199, 200, writes} {
+		if err := checkAt(rl, seq); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package remotelist
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Limites por lista, por servidor e por conexão ---
+//
+// Sem limites, um cliente pode crescer uma lista até acabar a memória do
+// servidor. Config define três limites (zero = sem limite):
+//
+//   - MaxListElements: elementos em uma lista (código LIST_FULL);
+//   - MaxTotalElements: elementos somando todas as listas (STORE_FULL);
+//   - RateLimit/RateBurst: pedidos por segundo de cada conexão, num token
+//     bucket com capacidade RateBurst (RATE_LIMITED). Só vale para conexões
+//     atendidas por ServeConn.
+//
+// O erro de um limite começa pelo código ("LIST_FULL: ..."), que chega ao
+// cliente pelo RPC; LimitCode o extrai. Os limites de elementos valem para as
+// escritas que crescem listas (Append, ImportList, PrepareTx); um COMMIT nunca
+// é recusado, pois o participante já votou sim no PrepareTx. Nos backups nada é
+// conferido: eles só repetem o que o primário aceitou.
+//
+// LimitStats conta as recusas por conexão (endereço do cliente) e por lista.
+// Os métodos chamados entre servidores ficam no serviço Peer (ver peer.go), que
+// não tem limite de pedidos; todos os do RemoteList têm. Atrás de um roteador
+// (remotelist/pkg/shard), porém, os clientes dividem a conexão dele.
+
+// Códigos dos erros de limite.
+const (
+	CodeListFull    = "LIST_FULL"
+	CodeStoreFull   = "STORE_FULL"
+	CodeRateLimited = "RATE_LIMITED"
+)
+
+// Máximo de clientes e de listas com contadores próprios em LimitStats; o
+// excedente é somado em otherKey.
+const (
+	maxLimitKeys = 1024
+	otherKey     = "(outros)"
+)
+
+// LimitError é a recusa de uma escrita ou pedido por um limite.
+type LimitError struct {
+	Code string
+	Msg  string
+}
+
+func (e *LimitError) Error() string {
+	return e.Code + ": " + e.Msg
+}
+
+// LimitCode devolve o código do limite que recusou a chamada ("" se err não é
+// um erro de limite). Também funciona com o erro recebido pelo cliente RPC,
+// que só traz o texto.
+func LimitCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	var le *LimitError
+	if errors.As(err, &le) {
+		return le.Code
+	}
+	return limitCodeOf(err.Error())
+}
+
+func limitCodeOf(msg string) string {
+	for _, code := range []string{CodeListFull, CodeStoreFull, CodeRateLimited} {
+		if strings.HasPrefix(msg, code+": ") {
+			return code
+		}
+	}
+	return ""
+}
+
+// LimitCounters conta as recusas de cada limite.
+type LimitCounters struct {
+	ListFull    uint64
+	StoreFull   uint64
+	RateLimited uint64
+}
+
+func (c *LimitCounters) add(code string) {
+	switch code {
+	case CodeListFull:
+		c.ListFull++
+	case CodeStoreFull:
+		c.StoreFull++
+	case CodeRateLimited:
+		c.RateLimited++
+	}
+}
+
+type LimitStatsArgs struct{}
+type LimitStatsReply struct {
+	Elements         int64 // Elementos em todas as listas
+	MaxListElements  int
+	MaxTotalElements int
+	RateLimit        float64
+	RateBurst        int
+	Clients          map[string]LimitCounters // Recusas por endereço de cliente
+	Lists            map[string]LimitCounters // Recusas de LIST_FULL e STORE_FULL por lista
+}
+
+// limitStats guarda as recusas para LimitStats.
+type limitStats struct {
+	mu      sync.Mutex
+	clients map[string]*LimitCounters
+	lists   map[string]*LimitCounters
+}
+
+func (s *limitStats) note(m *map[string]*LimitCounters, key, code string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if *m == nil {
+		*m = make(map[string]*LimitCounters)
+	}
+	c := (*m)[key]
+	if c == nil {
+		if len(*m) >= maxLimitKeys {
+			key = otherKey
+			c = (*m)[key]
+		}
+		if c == nil {
+			c = &LimitCounters{}
+			(*m)[key] = c
+		}
+	}
+	c.add(code)
+}
+
+func (s *limitStats) snapshot(m map[string]*LimitCounters) map[string]LimitCounters {
+	out := make(map[string]LimitCounters, len(m))
+	for k, c := range m {
+		out[k] = *c
+	}
+	return out
+}
+
+// LimitStats informa os limites, o total de elementos e quem já foi recusado.
+func (rl *RemoteList) LimitStats(args LimitStatsArgs, reply *LimitStatsReply) error {
+	reply.Elements = rl.elements.Load()
+	reply.MaxListElements, reply.MaxTotalElements = rl.cfg.MaxListElements, rl.cfg.MaxTotalElements
+	reply.RateLimit, reply.RateBurst = rl.cfg.RateLimit, rl.rateBurst()
+	s := &rl.limitStats
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reply.Clients = s.snapshot(s.clients)
+	reply.Lists = s.snapshot(s.lists)
+	return nil
+}
+
+// reserveElements confere se a lista (com length elementos) pode receber mais
+// n e, se puder, já os soma ao total. Quem reserva e não grava devolve com
+// releaseElements. Deve ser chamado com a lista bloqueada.
+func (rl *RemoteList) reserveElements(listID string, length, n int) error {
+	if n <= 0 || rl.readOnly.Load() {
+		return nil
+	}
+	if limit := rl.cfg.MaxListElements; limit > 0 && length+n > limit {
+		return rl.refuse(listID, &LimitError{Code: CodeListFull,
+			Msg: fmt.Sprintf("a lista '%s' tem %d elementos e o limite é %d", listID, length, limit)})
+	}
+	total := rl.elements.Add(int64(n))
+	if limit := rl.cfg.MaxTotalElements; limit > 0 && total > int64(limit) {
+		rl.elements.Add(-int64(n))
+		return rl.refuse(listID, &LimitError{Code: CodeStoreFull,
+			Msg: fmt.Sprintf("o servidor chegou ao limite de %d elementos", limit)})
+	}
+	return nil
+}
+
+// releaseElements tira n elementos do total.
+func (rl *RemoteList) releaseElements(n int) {
+	rl.elements.Add(-int64(n))
+}
+
+func (rl *RemoteList) refuse(listID string, err *LimitError) error {
+	rl.limitStats.note(&rl.limitStats.lists, listID, err.Code)
+	log.Printf("Escrita em '%s' recusada: %v", listID, err)
+	return err
+}
+
+// countElements recalcula o total de elementos. Deve ser chamado com o map
+// bloqueado (ou antes de o serviço começar a atender).
+func (rl *RemoteList) countElements() {
+	var total int64
+	for _, ml := range rl.lists {
+		ml.mu.RLock()
+		total += int64(ml.size())
+		ml.mu.RUnlock()
+	}
+	rl.elements.Store(total)
+}
+
+func (rl *RemoteList) rateBurst() int {
+	if rl.cfg.RateBurst > 0 {
+		return rl.cfg.RateBurst
+	}
+	return max(1, int(rl.cfg.RateLimit))
+}
+
+// --- Limite de pedidos por conexão ---
+
+// tokenBucket libera até rate pedidos por segundo, com rajadas de até burst.
+// Usado só pela goroutine que lê os pedidos da conexão.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// take consome uma ficha, se houver.
+func (b *tokenBucket) take(now time.Time) bool {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// ServeConn atende a conexão como rpc.Server.ServeConn, aplicando o limite de
+// pedidos por conexão e contando as recusas do cliente em LimitStats. Com um
+// ConnServer, o mesmo vale para ConnConfig.Limits.
+func (rl *RemoteList) ServeConn(srv *rpc.Server, conn net.Conn) {
+	srv.ServeCodec(newServerCodec(conn, rl))
+}
@@ -0,0 +1,466 @@
+package remotelist_test
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+
+	"remotelist/pkg"
+)
+
+// Limites do servidor (elementos por lista, elementos no total e pedidos por
+// segundo de cada conexão), com servidores atendendo por TCP
+// (RemoteList.ServeConn) sobre um sistema de arquivos em memória.
+
+const limitClients = 8 // Clientes concorrentes
+
+// --- Servidores e clientes ---
+
+// dial abre uma conexão de cliente e devolve também o endereço dela, que é
+// como o servidor a identifica em LimitStats.
+func (c *cluster) dial(n *node) (*rpc.Client, string, error) {
+	conn, err := net.Dial("tcp", n.addr)
+	if err != nil {
+		return nil, "", err
+	}
+	cl := rpc.NewClient(conn)
+	c.clients = append(c.clients, cl)
+	return cl, conn.LocalAddr().String(), nil
+}
+
+func appendTo(cl *rpc.Client, list string, v int) error {
+	return cl.Call("RemoteList.Append", remotelist.AppendArgs{ListID: list, Value: v}, &remotelist.AppendReply{})
+}
+
+// expectCode confere que err é a recusa do limite code.
+func expectCode(err error, code, what string) error {
+	if got := remotelist.LimitCode(err); got != code {
+		return fmt.Errorf("%s: erro %v, esperado %s", what, err, code)
+	}
+	return nil
+}
+
+func stats(cl *rpc.Client) (remotelist.LimitStatsReply, error) {
+	var reply remotelist.LimitStatsReply
+	err := cl.Call("RemoteList.LimitStats", remotelist.LimitStatsArgs{}, &reply)
+	return reply, err
+}
+
+// sumSizes soma o tamanho de todas as listas do servidor.
+func sumSizes(cl *rpc.Client) (int64, error) {
+	var lists remotelist.ListsReply
+	if err := cl.Call("RemoteList.Lists", remotelist.ListsArgs{}, &lists); err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, id := range lists.ListIDs {
+		var size remotelist.SizeReply
+		if err := cl.Call("RemoteList.Size", remotelist.SizeArgs{ListID: id}, &size); err != nil {
+			return 0, err
+		}
+		total += int64(size.Size)
+	}
+	return total, nil
+}
+
+// checkTotal confere que o total informado bate com a soma das listas.
+func checkTotal(cl *rpc.Client, want int64) error {
+	st, err := stats(cl)
+	if err != nil {
+		return err
+	}
+	sum, err := sumSizes(cl)
+	if err != nil {
+		return err
+	}
+	if st.Elements != sum || (want >= 0 && sum != want) {
+		return fmt.Errorf("total informado %d, soma das listas %d, esperado %d", st.Elements, sum, want)
+	}
+	return nil
+}
+
+// --- Cenários ---
+
+func TestLimitListFull(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{MaxListElements: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, _, err := c.dial(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := appendTo(cl, "a", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := expectCode(appendTo(cl, "a", 5), remotelist.CodeListFull, "Append na lista cheia"); err != nil {
+		t.Fatal(err)
+	}
+	// Outra lista não é afetada.
+	if err := appendTo(cl, "b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Call("RemoteList.Remove", remotelist.RemoveArgs{ListID: "a"}, &remotelist.RemoveReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendTo(cl, "a", 6); err != nil {
+		t.Fatalf("Append depois do Remove: %v", err)
+	}
+
+	// ImportList conta o conteúdo novo inteiro; PrepareTx, o efeito da transação.
+	err = cl.Call("Peer.ImportList", remotelist.ImportListArgs{ListID: "c", Values: []int{1, 2, 3, 4, 5, 6}}, &remotelist.ImportListReply{})
+	if err := expectCode(err, remotelist.CodeListFull, "ImportList grande demais"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Call("Peer.ImportList", remotelist.ImportListArgs{ListID: "a", Values: []int{1}}, &remotelist.ImportListReply{}); err != nil {
+		t.Fatalf("ImportList menor que o limite: %v", err)
+	}
+	ops := []remotelist.TxOp{{Op: "APPEND", ListID: "b", Value: 1}, {Op: "APPEND", ListID: "b", Value: 2}, {Op: "REMOVE", ListID: "b"}}
+	if err := cl.Call("Peer.PrepareTx", remotelist.PrepareTxArgs{TxID: "tx1", Ops: ops}, &remotelist.PrepareTxReply{}); err != nil {
+		t.Fatalf("PrepareTx dentro do limite: %v", err)
+	}
+	if err := cl.Call("Peer.CommitTx", remotelist.CommitTxArgs{TxID: "tx1"}, &remotelist.CommitTxReply{}); err != nil {
+		t.Fatal(err)
+	}
+	ops = []remotelist.TxOp{{Op: "APPEND", ListID: "b", Value: 1}, {Op: "APPEND", ListID: "b", Value: 2}, {Op: "APPEND", ListID: "b", Value: 3}, {Op: "APPEND", ListID: "b", Value: 4}}
+	err = cl.Call("Peer.PrepareTx", remotelist.PrepareTxArgs{TxID: "tx2", Ops: ops}, &remotelist.PrepareTxReply{})
+	if err := expectCode(err, remotelist.CodeListFull, "PrepareTx que enche a lista"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkTotal(cl, 1+2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLimitStoreFull(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{MaxTotalElements: 10, ReapInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, _, err := c.dial(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := appendTo(cl, fmt.Sprintf("l%d", i%3), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := expectCode(appendTo(cl, "nova", 1), remotelist.CodeStoreFull, "Append no servidor cheio"); err != nil {
+		t.Fatal(err)
+	}
+	err = cl.Call("Peer.ImportList", remotelist.ImportListArgs{ListID: "l0", Values: []int{1, 2, 3, 4, 5}}, &remotelist.ImportListReply{})
+	if err := expectCode(err, remotelist.CodeStoreFull, "ImportList que cresce a lista"); err != nil {
+		t.Fatal(err)
+	}
+	// Encolher uma lista por ImportList libera espaço.
+	if err := cl.Call("Peer.ImportList", remotelist.ImportListArgs{ListID: "l0", Values: []int{1}}, &remotelist.ImportListReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkTotal(cl, 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Call("Peer.DeleteList", remotelist.DeleteListArgs{ListID: "l1"}, &remotelist.DeleteListReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Call("RemoteList.Remove", remotelist.RemoveArgs{ListID: "l2"}, &remotelist.RemoveReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkTotal(cl, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// Uma lista vencida sai do total quando é apagada.
+	var ttl remotelist.SetTTLReply
+	if err := cl.Call("RemoteList.SetTTL", remotelist.SetTTLArgs{ListID: "l2", TTL: 50 * time.Millisecond}, &ttl); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 9; i++ {
+		if err := appendTo(cl, "l2", i); err != nil {
+			t.Fatalf("Append %d depois do TTL: %v", i, err)
+		}
+	}
+	if err := expectCode(appendTo(cl, "l2", 9), remotelist.CodeStoreFull, "Append no servidor cheio outra vez"); err != nil {
+		t.Fatal(err)
+	}
+
+	// O total é recalculado do disco.
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	cl, _, err = c.dial(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkTotal(cl, 10); err != nil {
+		t.Fatalf("depois da queda: %v", err)
+	}
+	if err := expectCode(appendTo(cl, "nova", 1), remotelist.CodeStoreFull, "Append depois da queda"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLimitConcurrent(t *testing.T) {
+	c := newCluster(t)
+	const limit = 500
+	n, err := c.start(remotelist.Config{MaxTotalElements: limit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted, refused := 0, 0
+	errs := make(chan error, limitClients)
+	for i := 0; i < limitClients; i++ {
+		cl, _, err := c.dial(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(i int, cl *rpc.Client) {
+			defer wg.Done()
+			for j := 0; j < 2*limit/limitClients; j++ {
+				list := fmt.Sprintf("l%d", (i+j)%7)
+				var err error
+				if j%5 == 4 {
+					err = cl.Call("RemoteList.Remove", remotelist.RemoveArgs{ListID: list}, &remotelist.RemoveReply{})
+					if err != nil && err.Error() != "lista vazia" && err.Error() != "lista não encontrada" {
+						errs <- err
+						return
+					}
+					continue
+				}
+				err = appendTo(cl, list, j)
+				mu.Lock()
+				switch {
+				case err == nil:
+					accepted++
+				case remotelist.LimitCode(err) == remotelist.CodeStoreFull:
+					refused++
+				default:
+					mu.Unlock()
+					errs <- err
+					return
+				}
+				mu.Unlock()
+			}
+		}(i, cl)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if refused == 0 {
+		t.Fatalf("nenhuma recusa em %d Appends", accepted)
+	}
+	cl, _, err := c.dial(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := sumSizes(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum > limit {
+		t.Fatalf("%d elementos, limite %d", sum, limit)
+	}
+	if err := checkTotal(cl, -1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLimitRateLimit(t *testing.T) {
+	c := newCluster(t)
+	const rate, burst = 20, 5
+	n, err := c.start(remotelist.Config{RateLimit: rate, RateBurst: burst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, _, err := c.dial(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// count faz k chamadas seguidas e devolve quantas passaram.
+	count := func(cl *rpc.Client, method string, args, reply any, k int) (int, error) {
+		ok := 0
+		for i := 0; i < k; i++ {
+			err := cl.Call(method, args, reply)
+			switch {
+			case err == nil:
+				ok++
+			case remotelist.LimitCode(err) != remotelist.CodeRateLimited:
+				return ok, err
+			}
+		}
+		return ok, nil
+	}
+	size := func(cl *rpc.Client, k int) (int, error) {
+		return count(cl, "RemoteList.Size", remotelist.SizeArgs{ListID: "a"}, &remotelist.SizeReply{}, k)
+	}
+
+	// A rajada inteira passa, o resto é recusado (com alguma folga para as
+	// fichas repostas enquanto as chamadas acontecem).
+	ok, err := size(cl, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok < burst || ok > burst+3 {
+		t.Fatalf("%d de 50 chamadas passaram, esperado cerca de %d", ok, burst)
+	}
+	// Outra conexão tem o próprio bucket.
+	other, _, err := c.dial(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := size(other, burst); err != nil || ok != burst {
+		t.Fatalf("outra conexão: %d de %d chamadas passaram (%v)", ok, burst, err)
+	}
+	// O serviço dos servidores pares não tem limite.
+	if ok, err := count(cl, "Peer.PreparedTxs", remotelist.PreparedTxsArgs{}, &remotelist.PreparedTxsReply{}, 30); err != nil || ok != 30 {
+		t.Fatalf("PreparedTxs: %d de 30 chamadas passaram (%v)", ok, err)
+	}
+	// Com o tempo, as fichas voltam até a rajada.
+	time.Sleep(time.Second)
+	ok, err = size(cl, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok < burst || ok > burst+3 {
+		t.Fatalf("depois de esperar: %d de 50 chamadas passaram, esperado cerca de %d", ok, burst)
+	}
+	// No ritmo do limite, nada é recusado.
+	time.Sleep(time.Second)
+	for i := 0; i < 10; i++ {
+		if err := cl.Call("RemoteList.Size", remotelist.SizeArgs{ListID: "a"}, &remotelist.SizeReply{}); err != nil {
+			t.Fatalf("chamada %d no ritmo do limite: %v", i, err)
+		}
+		time.Sleep(2 * time.Second / rate)
+	}
+}
+
+func TestLimitBackup(t *testing.T) {
+	c := newCluster(t)
+	backup, err := c.start(remotelist.Config{Backup: true, MaxTotalElements: 20, RateLimit: 1, RateBurst: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary, err := c.start(remotelist.Config{Backups: []string{backup.addr}, MaxTotalElements: 20, SyncReplication: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, _, err := c.dial(primary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// O backup só aceita um pedido por segundo de clientes, mas o ApplyLog do
+	// primário não passa por esse limite.
+	for i := 0; i < 15; i++ {
+		if err := appendTo(cl, fmt.Sprintf("l%d", i%4), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cl.Call("RemoteList.Remove", remotelist.RemoveArgs{ListID: "l0"}, &remotelist.RemoveReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Call("Peer.ImportList", remotelist.ImportListArgs{ListID: "l1", Values: []int{1}}, &remotelist.ImportListReply{}); err != nil {
+		t.Fatal(err)
+	}
+	ops := []remotelist.TxOp{{Op: "APPEND", ListID: "l3", Value: 1}, {Op: "APPEND", ListID: "l4", Value: 2}}
+	if err := cl.Call("Peer.PrepareTx", remotelist.PrepareTxArgs{TxID: "tx1", Ops: ops}, &remotelist.PrepareTxReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Call("Peer.CommitTx", remotelist.CommitTxArgs{TxID: "tx1"}, &remotelist.CommitTxReply{}); err != nil {
+		t.Fatal(err)
+	}
+	want := int64(15 - 1 - 3 + 2)
+	if err := checkTotal(cl, want); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var st remotelist.LimitStatsReply
+		backup.rl.LimitStats(remotelist.LimitStatsArgs{}, &st)
+		if st.Elements == want {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("o backup conta %d elementos, esperado %d", st.Elements, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	primary.stop()
+	if err := backup.rl.Promote(remotelist.PromoteArgs{}, &remotelist.PromoteReply{}); err != nil {
+		t.Fatal(err)
+	}
+	for i := want; i < 20; i++ {
+		if err := backup.rl.Append(remotelist.AppendArgs{ListID: "x", Value: int(i)}, &remotelist.AppendReply{}); err != nil {
+			t.Fatalf("Append no backup promovido: %v", err)
+		}
+	}
+	err = backup.rl.Append(remotelist.AppendArgs{ListID: "x", Value: 0}, &remotelist.AppendReply{})
+	if err := expectCode(err, remotelist.CodeStoreFull, "Append no backup promovido e cheio"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLimitStats(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{MaxListElements: 1, MaxTotalElements: 2, RateLimit: 1, RateBurst: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, addrA, err := c.dial(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, addrB, err := c.dial(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a enche "x" e é recusado por LIST_FULL; b enche o servidor com "y", é
+	// recusado em "z" por STORE_FULL e depois pelo limite de pedidos.
+	appendTo(a, "x", 1)
+	if err := expectCode(appendTo(a, "x", 2), remotelist.CodeListFull, "Append de a"); err != nil {
+		t.Fatal(err)
+	}
+	appendTo(b, "y", 1)
+	if err := expectCode(appendTo(b, "z", 2), remotelist.CodeStoreFull, "Append de b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectCode(appendTo(b, "z", 3), remotelist.CodeRateLimited, "terceiro pedido de b"); err != nil {
+		t.Fatal(err)
+	}
+
+	var st remotelist.LimitStatsReply
+	if err := n.rl.LimitStats(remotelist.LimitStatsArgs{}, &st); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]remotelist.LimitCounters{
+		addrA: {ListFull: 1},
+		addrB: {StoreFull: 1, RateLimited: 1},
+	}
+	for addr, w := range want {
+		if got := st.Clients[addr]; got != w {
+			t.Fatalf("cliente %s: %+v, esperado %+v", addr, got, w)
+		}
+	}
+	if len(st.Clients) != len(want) {
+		t.Fatalf("clientes com recusas: %v", st.Clients)
+	}
+	if st.Lists["x"] != (remotelist.LimitCounters{ListFull: 1}) || st.Lists["z"] != (remotelist.LimitCounters{StoreFull: 1}) || len(st.Lists) != 2 {
+		t.Fatalf("recusas por lista: %v", st.Lists)
+	}
+	if st.Elements != 2 || st.MaxListElements != 1 || st.MaxTotalElements != 2 || st.RateBurst != 2 {
+		t.Fatal("LimitStats não informa os limites configurados")
+	}
+}
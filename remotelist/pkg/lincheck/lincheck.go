@@ -0,0 +1,238 @@
+// Package lincheck verifica se um histórico de operações concorrentes é
+// linearizável em relação a um modelo sequencial.
+//
+// O algoritmo é o de Wing & Gong com as otimizações de Lowe (cache de pares
+// conjunto-linearizado/estado), o mesmo usado por ferramentas como Knossos e
+// Porcupine. Históricos podem ser particionados (ex: por lista), já que a
+// linearizabilidade é composicional: cada partição é verificada sozinha.
+package lincheck
+
+import (
+	"math"
+	"sort"
+)
+
+// Operation é uma operação observada por um cliente.
+// Call e Return são instantes de um mesmo relógio monotônico. Uma operação
+// cujo resultado é desconhecido (ex: a conexão caiu antes da resposta) deve
+// ter Unknown = true; ela pode ter acontecido em qualquer instante depois de Call.
+type Operation struct {
+	ClientID int
+	Input    any
+	Output   any
+	Call     int64
+	Return   int64
+	Unknown  bool
+}
+
+// Model descreve a especificação sequencial do objeto.
+type Model struct {
+	// Partition separa o histórico em sub-históricos independentes (opcional).
+	Partition func(ops []Operation) [][]Operation
+	// Init devolve o estado inicial.
+	Init func() any
+	// Step aplica input ao estado e diz se output é uma resposta possível.
+	// Para operações desconhecidas, output é nil e qualquer resposta vale.
+	Step func(state, input, output any) (bool, any)
+	// Hash e Equal permitem guardar estados já visitados no cache.
+	Hash  func(state any) uint64
+	Equal func(a, b any) bool
+}
+
+// Result é o resultado da verificação de uma partição.
+type Result struct {
+	Operations   int
+	Linearizable bool
+}
+
+// Check verifica o histórico completo. Só retorna true se todas as partições
+// forem linearizáveis; os resultados individuais vêm em results.
+func Check(model Model, history []Operation) (ok bool, results []Result) {
+	partitions := [][]Operation{history}
+	if model.Partition != nil {
+		partitions = model.Partition(history)
+	}
+	ok = true
+	for _, part := range partitions {
+		lin := checkSingle(model, part)
+		results = append(results, Result{Operations: len(part), Linearizable: lin})
+		ok = ok && lin
+	}
+	return ok, results
+}
+
+// node é um evento (chamada ou retorno) na lista duplamente encadeada do algoritmo.
+type node struct {
+	value any
+	match *node // Para chamadas: o retorno correspondente. Nil em retornos.
+	id    int
+	prev  *node
+	next  *node
+}
+
+// makeEntries ordena chamadas e retornos no tempo e monta a lista encadeada.
+// Em empates, chamadas vêm antes de retornos: as operações são tratadas como
+// concorrentes, que é a interpretação mais permissiva.
+func makeEntries(ops []Operation) *node {
+	type event struct {
+		time   int64
+		isCall bool
+		id     int
+	}
+	events := make([]event, 0, 2*len(ops))
+	for i, op := range ops {
+		ret := op.Return
+		if op.Unknown {
+			ret = math.MaxInt64
+		}
+		events = append(events, event{op.Call, true, i}, event{ret, false, i})
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].time != events[j].time {
+			return events[i].time < events[j].time
+		}
+		return events[i].isCall && !events[j].isCall
+	})
+
+	head := &node{id: -1}
+	calls := make([]*node, len(ops))
+	last := head
+	for _, ev := range events {
+		var n *node
+		if ev.isCall {
+			n = &node{value: ops[ev.id].Input, id: ev.id}
+			calls[ev.id] = n
+		} else {
+			var out any
+			if !ops[ev.id].Unknown {
+				out = ops[ev.id].Output
+			}
+			n = &node{value: out, id: ev.id}
+			calls[ev.id].match = n
+		}
+		n.prev = last
+		last.next = n
+		last = n
+	}
+	return head
+}
+
+// lift retira a chamada e o seu retorno da lista.
+func lift(n *node) {
+	n.prev.next = n.next
+	n.next.prev = n.prev
+	m := n.match
+	m.prev.next = m.next
+	if m.next != nil {
+		m.next.prev = m.prev
+	}
+}
+
+// unlift devolve a chamada e o seu retorno à lista (desfaz lift).
+func unlift(n *node) {
+	m := n.match
+	m.prev.next = m
+	if m.next != nil {
+		m.next.prev = m
+	}
+	n.prev.next = n
+	n.next.prev = n
+}
+
+type bitset []uint64
+
+func newBitset(n int) bitset { return make(bitset, (n+63)/64) }
+
+func (b bitset) set(i int)   { b[i/64] |= 1 << (uint(i) % 64) }
+func (b bitset) clear(i int) { b[i/64] &^= 1 << (uint(i) % 64) }
+
+func (b bitset) clone() bitset {
+	c := make(bitset, len(b))
+	copy(c, b)
+	return c
+}
+
+func (b bitset) equals(o bitset) bool {
+	for i := range b {
+		if b[i] != o[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (b bitset) hash() uint64 {
+	h := uint64(14695981039346656037)
+	for _, w := range b {
+		h ^= w
+		h *= 1099511628211
+	}
+	return h
+}
+
+type cacheEntry struct {
+	linearized bitset
+	state      any
+}
+
+type frame struct {
+	n     *node
+	state any
+}
+
+// checkSingle procura uma ordem linearizável para um sub-histórico.
+func checkSingle(model Model, ops []Operation) bool {
+	if len(ops) == 0 {
+		return true
+	}
+	head := makeEntries(ops)
+	state := model.Init()
+	linearized := newBitset(len(ops))
+	cache := make(map[uint64][]cacheEntry)
+	var calls []frame
+
+	seen := func(lin bitset, st any) bool {
+		h := lin.hash() ^ model.Hash(st)
+		for _, e := range cache[h] {
+			if e.linearized.equals(lin) && model.Equal(e.state, st) {
+				return true
+			}
+		}
+		cache[h] = append(cache[h], cacheEntry{lin, st})
+		return false
+	}
+
+	entry := head.next
+	for head.next != nil {
+		if entry.match != nil {
+			// Tenta linearizar esta chamada agora.
+			ok, newState := model.Step(state, entry.value, entry.match.value)
+			if ok {
+				newLin := linearized.clone()
+				newLin.set(entry.id)
+				if !seen(newLin, newState) {
+					calls = append(calls, frame{entry, state})
+					state = newState
+					linearized.set(entry.id)
+					lift(entry)
+					entry = head.next
+					continue
+				}
+			}
+			entry = entry.next
+			continue
+		}
+
+		// Chegamos a um retorno cuja chamada ainda não foi linearizada: volta atrás.
+		if len(calls) == 0 {
+			return false
+		}
+		top := calls[len(calls)-1]
+		calls = calls[:len(calls)-1]
+		entry, state = top.n, top.state
+		linearized.clear(entry.id)
+		unlift(entry)
+		entry = entry.next
+	}
+	return true
+}
@@ -0,0 +1,356 @@
+package lincheck_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"remotelist/pkg"
+	"remotelist/pkg/lincheck"
+)
+
+// serveEnv faz o binário de teste rodar como o processo servidor.
+const serveEnv = "LINCHECK_SERVE"
+
+// TestCheckRejects confere que o verificador recusa um histórico impossível:
+// um get que vê um valor antes de qualquer append.
+func TestCheckRejects(t *testing.T) {
+	ops := []lincheck.Operation{
+		{ClientID: 0, Input: lincheck.ListInput{ListID: "l", Op: "get"}, Output: lincheck.ListOutput{Value: 7}, Call: 0, Return: 1},
+		{ClientID: 1, Input: lincheck.ListInput{ListID: "l", Op: "append", Value: 7}, Output: lincheck.ListOutput{}, Call: 2, Return: 3},
+	}
+	if ok, _ := lincheck.Check(lincheck.ListModel(), ops); ok {
+		t.Fatal("histórico com leitura do futuro aceito como linearizável")
+	}
+}
+
+// TestCheckFailedRemove confere que um remove recusado com erro (ex: falha no
+// WAL) não muda a lista, como um append recusado; já "lista vazia" com a
+// lista cheia continua impossível.
+func TestCheckFailedRemove(t *testing.T) {
+	history := func(err string) []lincheck.Operation {
+		return []lincheck.Operation{
+			{ClientID: 0, Input: lincheck.ListInput{ListID: "l", Op: "append", Value: 7}, Output: lincheck.ListOutput{}, Call: 0, Return: 1},
+			{ClientID: 1, Input: lincheck.ListInput{ListID: "l", Op: "remove"}, Output: lincheck.ListOutput{Err: err}, Call: 2, Return: 3},
+			{ClientID: 0, Input: lincheck.ListInput{ListID: "l", Op: "get", Index: 0}, Output: lincheck.ListOutput{Value: 7}, Call: 4, Return: 5},
+			{ClientID: 1, Input: lincheck.ListInput{ListID: "l", Op: "remove"}, Output: lincheck.ListOutput{Value: 7}, Call: 6, Return: 7},
+		}
+	}
+	if ok, _ := lincheck.Check(lincheck.ListModel(), history("falha ao gravar no log: disco cheio")); !ok {
+		t.Fatal("remove recusado tratado como se tivesse tirado o elemento")
+	}
+	if ok, _ := lincheck.Check(lincheck.ListModel(), history("lista vazia")); ok {
+		t.Fatal(`"lista vazia" com um elemento na lista aceito como linearizável`)
+	}
+}
+
+// TestWAL e TestBolt verificam, na prática, a consistência forte prometida
+// pelo RemoteList. Um servidor de verdade (um processo filho) recebe vários
+// clientes concorrentes; o instante de chamada e de retorno de cada operação
+// é registrado e, no fim, o histórico precisa ser linearizável em relação a
+// uma lista sequencial.
+//
+// Durante a execução o servidor é reiniciado (SIGTERM, com Close) e derrubado
+// (SIGKILL, simulando uma queda): como cada escrita é confirmada só depois do
+// fsync, o histórico precisa continuar linearizável através deles.
+func TestWAL(t *testing.T)  { checkLinearizable(t, "wal") }
+func TestBolt(t *testing.T) { checkLinearizable(t, "bolt") }
+
+func checkLinearizable(t *testing.T, engine string) {
+	const clients, lists, think = 8, 16, time.Millisecond
+	duration, restarts, crashes := 4*time.Second, 1, 1
+	if testing.Short() {
+		duration = time.Second
+	}
+
+	serverAddr, err := freeAddr()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &serverProcess{dir: t.TempDir(), addr: serverAddr, snapshot: 300 * time.Millisecond, engine: engine}
+	if err := srv.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.kill()
+
+	h := &history{start: time.Now()}
+	deadline := time.Now().Add(duration)
+	var wg sync.WaitGroup
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			runClient(c, serverAddr, lists, think, deadline, h)
+		}(c)
+	}
+
+	// Distribui os reinícios e quedas ao longo da execução, alternando-os.
+	events := make([]string, 0, restarts+crashes)
+	for i := 0; i < restarts || i < crashes; i++ {
+		if i < crashes {
+			events = append(events, "queda")
+		}
+		if i < restarts {
+			events = append(events, "reinício")
+		}
+	}
+	for i, ev := range events {
+		time.Sleep(time.Until(h.start.Add(time.Duration(i+1) * duration / time.Duration(len(events)+1))))
+		t.Logf("%s do servidor", ev)
+		if ev == "queda" {
+			srv.kill()
+		} else {
+			srv.stop()
+		}
+		if err := srv.start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	ops := h.operations()
+	unknown := 0
+	for _, op := range ops {
+		if op.Unknown {
+			unknown++
+		}
+	}
+	t.Logf("%d operações registradas (%d com resultado desconhecido)", len(ops), unknown)
+
+	ok, results := lincheck.Check(lincheck.ListModel(), ops)
+	for i, r := range results {
+		if !r.Linearizable {
+			t.Errorf("partição %d: %d operações, NÃO linearizável", i, r.Operations)
+		}
+	}
+	if !ok {
+		t.Fatal("o histórico viola a linearizabilidade")
+	}
+}
+
+// history coleta as operações de todos os clientes.
+type history struct {
+	start time.Time
+	mu    sync.Mutex
+	ops   []lincheck.Operation
+}
+
+func (h *history) now() int64 { return int64(time.Since(h.start)) }
+
+func (h *history) add(op lincheck.Operation) {
+	h.mu.Lock()
+	h.ops = append(h.ops, op)
+	h.mu.Unlock()
+}
+
+func (h *history) operations() []lincheck.Operation {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]lincheck.Operation(nil), h.ops...)
+}
+
+// runClient executa operações aleatórias até o prazo, reconectando quando o
+// servidor cai. Uma operação interrompida pela queda fica com resultado desconhecido.
+func runClient(id int, addr string, lists int, think time.Duration, deadline time.Time, h *history) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano() + int64(id)))
+	var client *rpc.Client
+	defer func() {
+		if client != nil {
+			client.Close()
+		}
+	}()
+
+	for time.Now().Before(deadline) {
+		time.Sleep(think)
+		if client == nil {
+			c, err := rpc.Dial("tcp", addr)
+			if err != nil {
+				time.Sleep(20 * time.Millisecond)
+				continue
+			}
+			client = c
+		}
+
+		in := lincheck.ListInput{ListID: fmt.Sprintf("lista%d", r.Intn(lists))}
+		switch n := r.Intn(10); {
+		case n < 4:
+			in.Op, in.Value = "append", r.Intn(1000)
+		case n < 6:
+			in.Op = "remove"
+		case n < 8:
+			in.Op, in.Index = "get", r.Intn(8)
+		default:
+			in.Op = "size"
+		}
+
+		op := lincheck.Operation{ClientID: id, Input: in, Call: h.now()}
+		out, err := call(client, in)
+		op.Return = h.now()
+
+		var serverErr rpc.ServerError
+		switch {
+		case err == nil:
+			op.Output = out
+		case errors.As(err, &serverErr):
+			out.Err = err.Error()
+			op.Output = out
+		default:
+			// Falha de transporte: não sabemos se a operação aconteceu.
+			op.Unknown = true
+			client.Close()
+			client = nil
+			if in.Op == "get" || in.Op == "size" {
+				// Uma leitura sem resposta não muda nada nem observou nada.
+				continue
+			}
+		}
+		h.add(op)
+	}
+}
+
+// call executa uma ListInput no servidor.
+func call(client *rpc.Client, in lincheck.ListInput) (lincheck.ListOutput, error) {
+	var out lincheck.ListOutput
+	switch in.Op {
+	case "append":
+		var reply remotelist.AppendReply
+		return out, client.Call("RemoteList.Append", remotelist.AppendArgs{ListID: in.ListID, Value: in.Value}, &reply)
+	case "remove":
+		var reply remotelist.RemoveReply
+		err := client.Call("RemoteList.Remove", remotelist.RemoveArgs{ListID: in.ListID}, &reply)
+		out.Value = reply.Value
+		return out, err
+	case "get":
+		var reply remotelist.GetReply
+		err := client.Call("RemoteList.Get", remotelist.GetArgs{ListID: in.ListID, Index: in.Index}, &reply)
+		out.Value = reply.Value
+		return out, err
+	default:
+		var reply remotelist.SizeReply
+		err := client.Call("RemoteList.Size", remotelist.SizeArgs{ListID: in.ListID}, &reply)
+		out.Size = reply.Size
+		return out, err
+	}
+}
+
+// serverProcess controla o processo filho que roda o servidor.
+type serverProcess struct {
+	dir      string
+	addr     string
+	snapshot time.Duration
+	engine   string
+	cmd      *exec.Cmd
+}
+
+func (s *serverProcess) start() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	s.cmd = exec.Command(exe, "-test.run=^TestServerProcess$")
+	s.cmd.Env = append(os.Environ(),
+		serveEnv+"=1", "LINCHECK_DIR="+s.dir, "LINCHECK_ADDR="+s.addr,
+		"LINCHECK_SNAPSHOT="+s.snapshot.String(), "LINCHECK_ENGINE="+s.engine)
+	s.cmd.Stdout = io.Discard
+	s.cmd.Stderr = io.Discard
+	if err := s.cmd.Start(); err != nil {
+		return err
+	}
+	// Espera o servidor aceitar conexões.
+	for i := 0; i < 200; i++ {
+		if conn, err := net.Dial("tcp", s.addr); err == nil {
+			conn.Close()
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return errors.New("o servidor não subiu a tempo")
+}
+
+// stop pede um desligamento limpo (o servidor fecha o log antes de sair).
+func (s *serverProcess) stop() {
+	s.cmd.Process.Signal(syscall.SIGTERM)
+	s.cmd.Wait()
+}
+
+// kill derruba o servidor sem aviso, como uma queda de energia do processo.
+func (s *serverProcess) kill() {
+	s.cmd.Process.Kill()
+	s.cmd.Wait()
+}
+
+// TestServerProcess é o corpo do processo filho: só roda quando o teste o
+// executa com serveEnv no ambiente.
+func TestServerProcess(t *testing.T) {
+	if os.Getenv(serveEnv) == "" {
+		t.Skip("usado só como processo servidor")
+	}
+	snapshotEvery, err := time.ParseDuration(os.Getenv("LINCHECK_SNAPSHOT"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	runServer(os.Getenv("LINCHECK_DIR"), os.Getenv("LINCHECK_ADDR"), snapshotEvery, os.Getenv("LINCHECK_ENGINE"))
+}
+
+func runServer(dir, addr string, snapshotEvery time.Duration, engine string) {
+	var rl io.Closer
+	var err error
+	switch engine {
+	case "wal":
+		rl, err = remotelist.NewRemoteListWithConfig(remotelist.Config{Dir: dir, SnapshotInterval: snapshotEvery})
+	case "bolt":
+		rl, err = remotelist.OpenBoltList(remotelist.BoltConfig{Path: filepath.Join(dir, "remotelist.db")})
+	default:
+		err = fmt.Errorf("armazenamento desconhecido %q", engine)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	rpcs := rpc.NewServer()
+	if err := rpcs.RegisterName("RemoteList", rl); err != nil {
+		log.Fatal(err)
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-sig
+		l.Close()
+		rl.Close()
+		os.Exit(0)
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go rpcs.ServeConn(conn)
+	}
+}
+
+// freeAddr reserva uma porta livre em localhost.
+func freeAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
@@ -0,0 +1,108 @@
+package lincheck
+
+// ListInput é uma operação do RemoteList sobre uma lista.
+type ListInput struct {
+	ListID string
+	Op     string // "append", "get", "remove" ou "size"
+	Value  int    // append
+	Index  int    // get
+}
+
+// ListOutput é a resposta observada. Err guarda a mensagem de erro do servidor
+// (ex: "lista vazia"), que também é um resultado válido a verificar.
+type ListOutput struct {
+	Value int
+	Size  int
+	Err   string
+}
+
+// ListModel é a especificação sequencial de uma lista do RemoteList.
+// O histórico é particionado por ListID, já que as listas são independentes.
+func ListModel() Model {
+	return Model{
+		Partition: func(ops []Operation) [][]Operation {
+			byList := make(map[string][]Operation)
+			var order []string
+			for _, op := range ops {
+				id := op.Input.(ListInput).ListID
+				if _, ok := byList[id]; !ok {
+					order = append(order, id)
+				}
+				byList[id] = append(byList[id], op)
+			}
+			out := make([][]Operation, 0, len(order))
+			for _, id := range order {
+				out = append(out, byList[id])
+			}
+			return out
+		},
+		Init: func() any { return []int(nil) },
+		Step: func(state, input, output any) (bool, any) {
+			list := state.([]int)
+			in := input.(ListInput)
+			out, known := output.(ListOutput)
+
+			switch in.Op {
+			case "append":
+				if known && out.Err != "" {
+					// Rejeitado antes de tocar na memória (ex: falha no WAL).
+					return true, list
+				}
+				next := make([]int, len(list)+1)
+				copy(next, list)
+				next[len(list)] = in.Value
+				return true, next
+			case "get":
+				if !known {
+					return true, list
+				}
+				if in.Index < 0 || in.Index >= len(list) {
+					return out.Err != "", list
+				}
+				return out.Err == "" && out.Value == list[in.Index], list
+			case "remove":
+				if known && out.Err != "" && !emptyListErr(out.Err) {
+					// Como no append: rejeitado antes de tocar na memória.
+					return true, list
+				}
+				if len(list) == 0 {
+					return !known || out.Err != "", list
+				}
+				if known && (out.Err != "" || out.Value != list[len(list)-1]) {
+					return false, list
+				}
+				return true, list[: len(list)-1 : len(list)-1]
+			case "size":
+				return !known || (out.Err == "" && out.Size == len(list)), list
+			}
+			return false, list
+		},
+		Hash: func(state any) uint64 {
+			h := uint64(14695981039346656037)
+			for _, v := range state.([]int) {
+				h ^= uint64(v)
+				h *= 1099511628211
+			}
+			return h
+		},
+		Equal: func(a, b any) bool {
+			x, y := a.([]int), b.([]int)
+			if len(x) != len(y) {
+				return false
+			}
+			for i := range x {
+				if x[i] != y[i] {
+					return false
+				}
+			}
+			return true
+		},
+	}
+}
+
+// emptyListErr diz se err é a resposta de um remove numa lista vazia, que só
+// vale se a lista está vazia naquele ponto; os outros erros (ex: falha no WAL)
+// não mudam a lista.
+func emptyListErr(err string) bool {
+	return err == "lista vazia" || err == "lista não encontrada"
+}
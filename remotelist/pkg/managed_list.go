@@ -0,0 +1,177 @@
+package remotelist
+
+import (
+	"sync"
+	"time"
+)
+
+// chunkSize é a quantidade de elementos em cada bloco de uma ManagedList.
+// Blocos pequenos deixam barata a cópia feita na primeira escrita após um snapshot.
+const chunkSize = 1024
+
+// ManagedList encapsula uma única lista (ou outra estrutura, ver kind) e seu
+// próprio mutex.
+// Isso permite o bloqueio refinado: operações em listas diferentes
+// (ex: 'listaA' e 'listaB') podem ocorrer em paralelo.
+//
+// Os dados ficam em blocos de até chunkSize elementos (todos cheios, menos o último).
+// Um snapshot não copia os dados: ele captura os blocos atuais (captureView) e,
+// a partir daí, qualquer escrita que fosse alterar um bloco compartilhado copia
+// esse bloco antes (copy-on-write). Assim o tempo em que o snapshot segura a
+// lista não depende do tamanho dela.
+type ManagedList struct {
+	mu sync.RWMutex // Protege os dados desta lista específica; mutex de leitura/escrita
+
+	chunks [][]int
+	length int
+
+	// sharedChunks é quantos blocos (a partir do início) ainda são vistos por um snapshot.
+	sharedChunks int
+	// sharedSpine indica que o slice 'chunks' em si também é visto por um snapshot.
+	sharedSpine bool
+
+	// deleted indica que a lista foi apagada (DeleteList) e saiu do map: quem
+	// obteve o ponteiro antes disso deve tratá-la como inexistente.
+	deleted bool
+
+	// txn é a transação preparada que segura a lista (ver PrepareTx): até o
+	// COMMIT ou o ROLLBACK, só ela pode alterar a lista.
+	txn string
+
+	// expires é quando o TTL da lista vence (zero = sem TTL; ver ttl.go).
+	expires time.Time
+
+	// delayed são os itens agendados da lista, em ordem de disparo (ver delayed.go).
+	delayed []DelayedItem
+
+	// kind é o tipo da chave: uma lista (o zero) ou um set, map, contador,
+	// sorted set ou stream (ver structures.go), que usam os campos abaixo em vez
+	// dos blocos. Um snapshot os vê pelos baldes dos cowMaps (ver cowmap.go).
+	kind    Kind
+	set     *cowMap[int, struct{}]
+	hash    *cowMap[string, int]
+	counter int
+	sorted  *sortedSet
+	stream  *stream
+}
+
+// listView é uma fotografia imutável de uma ManagedList, obtida por captureView.
+type listView struct {
+	chunks [][]int
+	length int
+}
+
+// newManagedList cria uma lista com uma cópia dos valores recebidos.
+func newManagedList(data []int) *ManagedList {
+	ml := &ManagedList{}
+	for _, v := range data {
+		ml.push(v)
+	}
+	return ml
+}
+
+// expired diz se o TTL da lista já venceu em now. Uma lista segura por uma
+// transação não expira antes da decisão. Deve ser chamado com ml.mu bloqueado.
+func (ml *ManagedList) expired(now time.Time) bool {
+	return !ml.expires.IsZero() && !now.Before(ml.expires) && ml.txn == ""
+}
+
+// replace troca todo o conteúdo da lista por uma cópia de data.
+// Deve ser chamado com ml.mu bloqueado para escrita.
+func (ml *ManagedList) replace(data []int) {
+	fresh := newManagedList(data)
+	// Os blocos antigos podem estar com um snapshot; os novos não são de ninguém.
+	ml.chunks, ml.length = fresh.chunks, fresh.length
+	ml.sharedChunks, ml.sharedSpine = 0, false
+}
+
+// len retorna o número de elementos. Deve ser chamado com ml.mu bloqueado.
+func (ml *ManagedList) len() int {
+	return ml.length
+}
+
+// at retorna o elemento do índice i (já validado). Deve ser chamado com ml.mu bloqueado.
+func (ml *ManagedList) at(i int) int {
+	return ml.chunks[i/chunkSize][i%chunkSize]
+}
+
+// push adiciona v ao final. Deve ser chamado com ml.mu bloqueado para escrita.
+func (ml *ManagedList) push(v int) {
+	last := len(ml.chunks) - 1
+	if last < 0 || len(ml.chunks[last]) == chunkSize {
+		ml.ownSpine()
+		chunk := make([]int, 0, chunkSize)
+		ml.chunks = append(ml.chunks, append(chunk, v))
+	} else {
+		ml.ownChunk(last)
+		ml.chunks[last] = append(ml.chunks[last], v)
+	}
+	ml.length++
+}
+
+// pop remove e retorna o último elemento (a lista não pode estar vazia).
+// Deve ser chamado com ml.mu bloqueado para escrita.
+func (ml *ManagedList) pop() int {
+	last := len(ml.chunks) - 1
+	ml.ownChunk(last)
+	chunk := ml.chunks[last]
+	v := chunk[len(chunk)-1]
+	if len(chunk) == 1 {
+		ml.chunks = ml.chunks[:last]
+	} else {
+		ml.chunks[last] = chunk[:len(chunk)-1]
+	}
+	ml.length--
+	return v
+}
+
+// values retorna uma cópia de todos os elementos. Deve ser chamado com ml.mu bloqueado.
+func (ml *ManagedList) values() []int {
+	return ml.captureViewLocked().values()
+}
+
+// captureView fotografa a lista em tempo O(1) e marca os blocos atuais como compartilhados.
+// Deve ser chamado com ml.mu bloqueado para escrita.
+func (ml *ManagedList) captureView() listView {
+	ml.sharedChunks = len(ml.chunks)
+	ml.sharedSpine = true
+	return ml.captureViewLocked()
+}
+
+// captureViewLocked devolve a visão sem marcar compartilhamento; só é seguro
+// enquanto o chamador mantém o lock (leituras imediatas).
+func (ml *ManagedList) captureViewLocked() listView {
+	return listView{chunks: ml.chunks, length: ml.length}
+}
+
+// ownSpine garante que o slice de blocos não é mais visto por nenhum snapshot.
+func (ml *ManagedList) ownSpine() {
+	if !ml.sharedSpine {
+		return
+	}
+	spine := make([][]int, len(ml.chunks), len(ml.chunks)+1)
+	copy(spine, ml.chunks)
+	ml.chunks = spine
+	ml.sharedSpine = false
+}
+
+// ownChunk garante que o bloco i pode ser alterado sem afetar um snapshot em andamento.
+func (ml *ManagedList) ownChunk(i int) {
+	ml.ownSpine()
+	if i >= ml.sharedChunks {
+		return
+	}
+	chunk := make([]int, len(ml.chunks[i]), chunkSize)
+	copy(chunk, ml.chunks[i])
+	ml.chunks[i] = chunk
+	ml.sharedChunks = i
+}
+
+// values copia os elementos da visão para um slice novo.
+func (v listView) values() []int {
+	out := make([]int, 0, v.length)
+	for _, chunk := range v.chunks {
+		out = append(out, chunk...)
+	}
+	return out
+}
@@ -0,0 +1,134 @@
+package remotelist_test
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"remotelist/pkg"
+)
+
+// Snapshots sem bloquear os escritores: a fotografia das listas é tirada com
+// copy-on-write e o arquivo é gravado sem nenhum lock de lista.
+
+// gateFS segura a criação do arquivo temporário do snapshot até release ser
+// fechado, para o teste escrever enquanto o snapshot está sendo gravado.
+type gateFS struct {
+	remotelist.FS
+	once    sync.Once
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (g *gateFS) OpenFile(name string, flag int, perm os.FileMode) (remotelist.File, error) {
+	if strings.HasSuffix(name, ".tmp") && flag&os.O_CREATE != 0 {
+		g.once.Do(func() {
+			close(g.entered)
+			<-g.release
+		})
+	}
+	return g.FS.OpenFile(name, flag, perm)
+}
+
+func TestCOWSnapshotWrites(t *testing.T) {
+	mem := remotelist.NewMemFS()
+	mem.MkdirAll(dataDir, 0755)
+	gate := &gateFS{FS: mem, entered: make(chan struct{}), release: make(chan struct{})}
+	rl, err := remotelist.NewRemoteListWithConfig(remotelist.Config{Dir: dataDir, FS: gate, SnapshotInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if rl != nil {
+			rl.Close()
+		}
+	})
+	before := make([]int, 5000)
+	for i := range before {
+		before[i] = i
+	}
+	if err := appendList(rl, "l", before...); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshotErr := make(chan error, 1)
+	go func() { snapshotErr <- rl.Snapshot() }()
+	select {
+	case <-gate.entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("o snapshot não chegou à gravação do arquivo")
+	}
+
+	// Com o arquivo do snapshot parado, as escritas (numa lista que está na
+	// fotografia e numa nova) têm de terminar.
+	written := make(chan error, 1)
+	go func() {
+		if err := appendList(rl, "l", 5000, 5001, 5002); err != nil {
+			written <- err
+			return
+		}
+		if err := rl.Remove(remotelist.RemoveArgs{ListID: "l"}, &remotelist.RemoveReply{}); err != nil {
+			written <- err
+			return
+		}
+		written <- appendList(rl, "nova", 7)
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		close(gate.release)
+		t.Fatal("escritas bloqueadas durante a gravação do snapshot")
+	}
+	close(gate.release)
+	if err := <-snapshotErr; err != nil {
+		t.Fatal(err)
+	}
+	after := append(append([]int{}, before...), 5000, 5001)
+	if err := expectList(rl, "l", after); err != nil {
+		t.Fatal(err)
+	}
+
+	// As escritas ficaram no segmento aberto pelo snapshot, depois do ponto
+	// dele: sem esse segmento, o snapshot sozinho tem o estado de antes delas.
+	rl.Close()
+	rl = nil
+	segs, err := segments(mem)
+	if err != nil || len(segs) != 1 {
+		t.Fatalf("segmentos depois do snapshot: %v, %v", segs, err)
+	}
+	data, err := mem.ReadFile(dataDir + "/" + segs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 5 {
+		t.Fatalf("segmento novo com %d registros, esperado 5:\n%s", lines, data)
+	}
+	for _, check := range []struct {
+		drop bool
+		l    []int
+		nova []int
+	}{{false, after, []int{7}}, {true, before, nil}} {
+		fsys := mem.Crash()
+		if check.drop {
+			if err := fsys.Remove(dataDir + "/" + segs[0]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if rl, err = remotelist.NewRemoteListWithConfig(remotelist.Config{Dir: dataDir, FS: fsys, SnapshotInterval: -1}); err != nil {
+			t.Fatal(err)
+		}
+		if err := expectList(rl, "l", check.l); err != nil {
+			t.Fatalf("sem o segmento novo = %v: %v", check.drop, err)
+		}
+		if err := expectList(rl, "nova", check.nova); err != nil {
+			t.Fatalf("sem o segmento novo = %v: %v", check.drop, err)
+		}
+		rl.Close()
+		rl = nil
+	}
+}
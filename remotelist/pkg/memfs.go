@@ -0,0 +1,270 @@
+package remotelist
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemFS é um FS em memória que distingue o que foi escrito do que foi
+// sincronizado: Crash devolve uma cópia só com os dados que passaram por
+// Sync, como um disco depois de uma queda de energia. Criações, remoções e
+// renomeações valem imediatamente (como em um sistema de arquivos com journal
+// de metadados); só o conteúdo dos arquivos pode se perder.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memFile
+	dirs  map[string]bool
+}
+
+type memFile struct {
+	data    []byte
+	synced  []byte
+	modTime time.Time
+}
+
+// NewMemFS cria um MemFS vazio.
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files: make(map[string]*memFile),
+		dirs:  map[string]bool{".": true, "/": true},
+	}
+}
+
+// Crash devolve um novo MemFS com o estado que sobreviveria a uma queda:
+// cada arquivo fica só com o conteúdo do último Sync. O MemFS original não muda.
+func (m *MemFS) Crash() *MemFS {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := NewMemFS()
+	for name, f := range m.files {
+		synced := append([]byte(nil), f.synced...)
+		out.files[name] = &memFile{data: synced, synced: append([]byte(nil), synced...), modTime: f.modTime}
+	}
+	for dir := range m.dirs {
+		out.dirs[dir] = true
+	}
+	return out
+}
+
+// ReadFile devolve o conteúdo atual (sincronizado ou não) de um arquivo.
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[filepath.Clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]byte(nil), f.data...), nil
+}
+
+func (m *MemFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = filepath.Clean(name)
+	f, exists := m.files[name]
+	switch {
+	case !exists && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case exists && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case !exists:
+		f = &memFile{modTime: time.Now()}
+		m.files[name] = f
+	}
+	if flag&os.O_TRUNC != 0 {
+		f.data = nil
+	}
+	access := flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR)
+	return &memHandle{
+		fs:       m,
+		name:     name,
+		file:     f,
+		append:   flag&os.O_APPEND != 0,
+		readable: access != os.O_WRONLY,
+		writable: access != os.O_RDONLY,
+	}, nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = filepath.Clean(name)
+	if _, ok := m.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *MemFS) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	f, ok := m.files[oldpath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrNotExist}
+	}
+	delete(m.files, oldpath)
+	m.files[newpath] = f
+	return nil
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = filepath.Clean(name)
+	if f, ok := m.files[name]; ok {
+		return memFileInfo{name: filepath.Base(name), size: int64(len(f.data)), modTime: f.modTime}, nil
+	}
+	if m.dirExists(name) {
+		return memFileInfo{name: filepath.Base(name), dir: true}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *MemFS) MkdirAll(dir string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for dir = filepath.Clean(dir); !m.dirs[dir]; dir = filepath.Dir(dir) {
+		m.dirs[dir] = true
+	}
+	return nil
+}
+
+func (m *MemFS) ReadDir(dir string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dir = filepath.Clean(dir)
+	if !m.dirExists(dir) {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: fs.ErrNotExist}
+	}
+	var names []string
+	for name := range m.files {
+		if filepath.Dir(name) == dir {
+			names = append(names, filepath.Base(name))
+		}
+	}
+	return sortedNames(names), nil
+}
+
+func (m *MemFS) SyncDir(dir string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.dirExists(filepath.Clean(dir)) {
+		return &fs.PathError{Op: "open", Path: dir, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+// dirExists diz se dir foi criado ou contém algum arquivo. Deve ser chamado com m.mu.
+func (m *MemFS) dirExists(dir string) bool {
+	if m.dirs[dir] {
+		return true
+	}
+	prefix := dir + string(filepath.Separator)
+	for name := range m.files {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// memHandle é um arquivo aberto de um MemFS.
+type memHandle struct {
+	fs       *MemFS
+	name     string
+	file     *memFile
+	pos      int
+	append   bool
+	readable bool
+	writable bool
+	closed   bool
+}
+
+var errClosed = errors.New("arquivo fechado")
+
+func (h *memHandle) Read(p []byte) (int, error) {
+	h.fs.mu.Lock()
+	defer h.fs.mu.Unlock()
+	if h.closed {
+		return 0, errClosed
+	}
+	if !h.readable {
+		return 0, &fs.PathError{Op: "read", Path: h.name, Err: fs.ErrPermission}
+	}
+	if h.pos >= len(h.file.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, h.file.data[h.pos:])
+	h.pos += n
+	return n, nil
+}
+
+func (h *memHandle) Write(p []byte) (int, error) {
+	h.fs.mu.Lock()
+	defer h.fs.mu.Unlock()
+	if h.closed {
+		return 0, errClosed
+	}
+	if !h.writable {
+		return 0, &fs.PathError{Op: "write", Path: h.name, Err: fs.ErrPermission}
+	}
+	if h.append {
+		h.pos = len(h.file.data)
+	}
+	if end := h.pos + len(p); end > len(h.file.data) {
+		h.file.data = append(h.file.data, make([]byte, end-len(h.file.data))...)
+	}
+	copy(h.file.data[h.pos:], p)
+	h.pos += len(p)
+	h.file.modTime = time.Now()
+	return len(p), nil
+}
+
+func (h *memHandle) Sync() error {
+	h.fs.mu.Lock()
+	defer h.fs.mu.Unlock()
+	if h.closed {
+		return errClosed
+	}
+	h.file.synced = append(h.file.synced[:0], h.file.data...)
+	return nil
+}
+
+func (h *memHandle) Close() error {
+	h.fs.mu.Lock()
+	defer h.fs.mu.Unlock()
+	if h.closed {
+		return errClosed
+	}
+	h.closed = true
+	return nil
+}
+
+// memFileInfo implementa fs.FileInfo para o MemFS.
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) ModTime() time.Time { return i.modTime }
+func (i memFileInfo) IsDir() bool        { return i.dir }
+func (i memFileInfo) Sys() any           { return nil }
+
+func (i memFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
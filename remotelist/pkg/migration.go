@@ -0,0 +1,249 @@
+package remotelist
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// --- Migração de listas entre servidores ---
+//
+// Métodos do serviço Peer (ver peer.go) usados pelo roteador de shards
+// (remotelist/pkg/shard) para mover uma lista inteira de um servidor para
+// outro: ExportList lê a lista, ImportList a grava no destino (registro REPLACE
+// no WAL) e DeleteList a apaga da origem (registro DELETE). O roteador garante
+// que nenhuma escrita na lista aconteça durante a cópia. O prazo do TTL e os
+// itens agendados (ver delayed.go) vão junto com os valores.
+//
+// As chaves dos outros tipos (ver structures.go) migram do mesmo jeito: o
+// conteúdo vai em Structures, só com a chave, e o destino o grava num registro
+// RESTORE.
+
+type ExportListArgs struct {
+	ListID string
+}
+type ExportListReply struct {
+	Exists     bool
+	Values     []int
+	Expires    time.Time     // Prazo do TTL (zero = sem TTL)
+	Delayed    []DelayedItem // Itens agendados, em ordem de disparo
+	Structures               // A chave, se não for uma lista
+}
+
+type ImportListArgs struct {
+	ListID     string
+	Values     []int
+	Expires    time.Time     // Prazo do TTL (zero = sem TTL)
+	Delayed    []DelayedItem // Itens agendados (recebem IDs novos no destino)
+	Structures               // A chave, se não for uma lista (sem Values nem Delayed)
+}
+type ImportListReply struct{}
+
+type DeleteListArgs struct {
+	ListID string
+}
+type DeleteListReply struct {
+	Existed bool
+}
+
+// exportList retorna todo o conteúdo da chave.
+func (rl *RemoteList) exportList(args ExportListArgs, reply *ExportListReply) error {
+	ml, exists := rl.getList(args.ListID)
+	if !exists {
+		return nil
+	}
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+	if ml.deleted || ml.expired(time.Now()) {
+		return nil
+	}
+	if ml.kind != KindList {
+		reply.Exists, reply.Expires = true, ml.expires
+		reply.Structures = structuresOf([]structView{ml.structView(args.ListID, false)})
+		return nil
+	}
+	// Uma lista segura por uma transação ainda pode mudar no COMMIT: copiá-la
+	// agora levaria para o destino um conteúdo que logo estaria velho.
+	if ml.txn != "" {
+		return errListLocked
+	}
+	reply.Exists = true
+	reply.Values = ml.values()
+	reply.Expires = ml.expires
+	reply.Delayed = append([]DelayedItem(nil), ml.delayed...)
+	return nil
+}
+
+// importList cria a chave (ou substitui todo o seu conteúdo) com o conteúdo dado.
+func (rl *RemoteList) importList(args ImportListArgs, reply *ImportListReply) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	if kind, ok := args.Structures.single(args.ListID); ok {
+		return rl.importStructure(args, kind)
+	}
+	ml, err := rl.lockOrCreateKey(args.ListID, KindList)
+	if err != nil {
+		return err
+	}
+	defer ml.mu.Unlock()
+	if ml.txn != "" {
+		return errListLocked
+	}
+	// O conteúdo novo substitui o antigo: só a diferença conta para os limites.
+	delta := len(args.Values) + len(args.Delayed) - ml.size()
+	if err := rl.reserveElements(args.ListID, ml.size(), delta); err != nil {
+		return err
+	}
+
+	if _, err := rl.logRecord(LogRecord{Op: "REPLACE", ListID: args.ListID, Values: args.Values}); err != nil {
+		rl.releaseElements(max(delta, 0))
+		log.Printf("Erro crítico de persistência (ImportList): %v", err)
+		return fmt.Errorf("falha ao gravar no disco (WAL): %w", err)
+	}
+	ml.replace(args.Values)
+	rl.releaseElements(-min(delta, 0))
+	ml.expires, ml.delayed = time.Time{}, nil // O REPLACE cria uma lista nova no replay
+	// Cada item agendado também vai num registro à parte. Se um deles falhar,
+	// ele e os seguintes ficam de fora, e os limites são devolvidos.
+	for i, it := range args.Delayed {
+		rec, err := rl.logStamped(LogRecord{Op: "DELAY", ListID: args.ListID, Value: it.Value, Due: it.Due})
+		if err != nil {
+			rl.releaseElements(len(args.Delayed) - i)
+			log.Printf("Erro crítico de persistência (ImportList): %v", err)
+			return fmt.Errorf("falha ao gravar no disco (WAL): %w", err)
+		}
+		ml.schedule(DelayedItem{ID: rec.Seq, Value: it.Value, Due: time.Unix(0, it.Due.UnixNano())})
+	}
+	if len(args.Delayed) > 0 {
+		rl.wakeScheduler()
+	}
+	return rl.importExpires(ml, args)
+}
+
+// importStructure é o ImportList de uma chave que não é lista: um registro
+// RESTORE com todo o conteúdo.
+func (rl *RemoteList) importStructure(args ImportListArgs, kind Kind) error {
+	if len(args.Values) > 0 || len(args.Delayed) > 0 {
+		return errors.New("valores e itens agendados só valem para listas")
+	}
+	fresh := make(map[string]*ManagedList, 1)
+	restoreStructures(fresh, args.Structures)
+	ml, err := rl.lockOrCreateKey(args.ListID, kind)
+	if err != nil {
+		return err
+	}
+	defer ml.mu.Unlock()
+	delta := fresh[args.ListID].size() - ml.size()
+	if err := rl.reserveElements(args.ListID, ml.size(), delta); err != nil {
+		return err
+	}
+
+	if _, err := rl.logRecord(LogRecord{Op: "RESTORE", ListID: args.ListID, State: &args.Structures}); err != nil {
+		rl.releaseElements(max(delta, 0))
+		log.Printf("Erro crítico de persistência (ImportList): %v", err)
+		return fmt.Errorf("falha ao gravar no disco (WAL): %w", err)
+	}
+	ml.restore(fresh[args.ListID])
+	rl.releaseElements(-min(delta, 0))
+	return rl.importExpires(ml, args)
+}
+
+// importExpires grava o prazo da chave importada num registro à parte: sem
+// ele, a chave fica sem TTL, como depois de um Persist.
+func (rl *RemoteList) importExpires(ml *ManagedList, args ImportListArgs) error {
+	if args.Expires.IsZero() {
+		return nil
+	}
+	if _, err := rl.logRecord(LogRecord{Op: "EXPIRE", ListID: args.ListID, Expires: args.Expires}); err != nil {
+		log.Printf("Erro crítico de persistência (ImportList): %v", err)
+		return fmt.Errorf("falha ao gravar no disco (WAL): %w", err)
+	}
+	ml.expires = args.Expires
+	return nil
+}
+
+// deleteList apaga a lista. Apagar uma lista inexistente não é erro.
+func (rl *RemoteList) deleteList(args DeleteListArgs, reply *DeleteListReply) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	// O lock do map vem antes do da lista, a mesma ordem do snapshot.
+	rl.mapMu.Lock()
+	defer rl.mapMu.Unlock()
+	ml, exists := rl.lists[args.ListID]
+	if !exists {
+		return nil
+	}
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	if ml.txn != "" {
+		return errListLocked
+	}
+
+	if _, err := rl.logRecord(LogRecord{Op: "DELETE", ListID: args.ListID}); err != nil {
+		log.Printf("Erro crítico de persistência (DeleteList): %v", err)
+		return fmt.Errorf("falha ao gravar no disco (WAL): %w", err)
+	}
+	delete(rl.lists, args.ListID)
+	rl.releaseElements(ml.size())
+	// Quem já tinha o ponteiro vê a lista vazia e marcada como apagada.
+	ml.replace(nil)
+	ml.deleted = true
+	reply.Existed = true
+	log.Printf("Lista '%s' apagada.", args.ListID)
+	return nil
+}
+
+// --- Mesmos métodos no backend bbolt ---
+
+// exportList retorna todo o conteúdo da lista.
+func (bl *BoltList) exportList(args ExportListArgs, reply *ExportListReply) error {
+	return bl.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketLists).Bucket(boltBucket(args.ListID))
+		if b == nil {
+			return nil
+		}
+		reply.Exists = true
+		reply.Values = make([]int, 0, boltLen(b))
+		return b.ForEach(func(k, v []byte) error {
+			reply.Values = append(reply.Values, boltDecode(v))
+			return nil
+		})
+	})
+}
+
+// importList cria a lista (ou substitui todo o seu conteúdo) com os valores dados.
+func (bl *BoltList) importList(args ImportListArgs, reply *ImportListReply) error {
+	if _, ok := args.Structures.single(args.ListID); ok {
+		return errors.New("o armazenamento bolt só guarda listas")
+	}
+	if err := validBoltListID(args.ListID); err != nil {
+		return err
+	}
+	if err := bl.Import(map[string][]int{args.ListID: args.Values}); err != nil {
+		log.Printf("Erro crítico de persistência (ImportList): %v", err)
+		return fmt.Errorf("falha ao gravar no disco (bbolt): %w", err)
+	}
+	return nil
+}
+
+// deleteList apaga a lista. Apagar uma lista inexistente não é erro.
+func (bl *BoltList) deleteList(args DeleteListArgs, reply *DeleteListReply) error {
+	err := bl.update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(bucketLists)
+		reply.Existed = root.Bucket(boltBucket(args.ListID)) != nil
+		if !reply.Existed {
+			return nil
+		}
+		return root.DeleteBucket(boltBucket(args.ListID))
+	})
+	if err != nil {
+		log.Printf("Erro crítico de persistência (DeleteList): %v", err)
+		return fmt.Errorf("falha ao gravar no disco (bbolt): %w", err)
+	}
+	return nil
+}
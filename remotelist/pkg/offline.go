@@ -0,0 +1,212 @@
+package remotelist
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// --- Acesso offline aos arquivos de dados (usado pelo remotelistctl) ---
+//
+// Estas funções abrem um diretório de dados sem iniciar o serviço: nenhum
+// segmento novo é criado e nenhum snapshot é agendado. Elas usam os mesmos
+// loadSnapshot e replayLog do servidor, então o que elas mostram é exatamente
+// o que o servidor carregaria.
+
+// SnapshotInfo é o cabeçalho de um snapshot. Version é a versão do formato
+// (0 e 1 são os antigos, sem cabeçalho, compressão nem checksum).
+type SnapshotInfo struct {
+	Version     int
+	NextSegment uint64
+	LastSeq     uint64
+	CreatedAt   time.Time
+	Compression string
+	Checksum    string
+}
+
+// OfflineState é o estado de um diretório de dados carregado offline.
+type OfflineState struct {
+	Dir           string
+	HasSnapshot   bool
+	Snapshot      SnapshotInfo
+	SnapshotLists map[string][]int // Listas como estão no snapshot
+	Segments      []string         // Arquivos de log aplicados, em ordem
+	Lists         map[string][]int // Listas após o replay do log
+	LastSeq       uint64
+	Prepared      []PreparedTx             // Transações preparadas e não decididas após o replay
+	Decided       []TxDecision             // Últimas transações decididas
+	Expires       map[string]time.Time     // Prazos dos TTLs após o replay
+	Delayed       map[string][]DelayedItem // Itens agendados após o replay
+	Structures    Structures               // Sets, maps e contadores após o replay
+	Problems      []LogProblem             // Linhas do log que não puderam ser aplicadas
+}
+
+// LoadOffline carrega o snapshot e aplica o log de dir, sem alterar nenhum arquivo.
+// Um remotelist.log do formato antigo é lido no lugar do segmento 0.
+// Arquivos cifrados são lidos com as chaves do ambiente (ver LoadKey).
+func LoadOffline(dir string) (*OfflineState, error) {
+	fsys, err := offlineFS()
+	if err != nil {
+		return nil, err
+	}
+	rl := &RemoteList{
+		lists: make(map[string]*ManagedList),
+		cfg:   Config{Dir: dir},
+		fs:    fsys,
+	}
+	st := &OfflineState{Dir: dirOrDot(dir)}
+
+	nextSegment, err := rl.loadSnapshot()
+	if err == nil {
+		info, lists, err := ReadSnapshotFile(rl.path(snapshotFile))
+		if err != nil {
+			return nil, err
+		}
+		st.HasSnapshot, st.Snapshot, st.SnapshotLists = true, info, lists
+	} else if _, statErr := os.Stat(rl.path(snapshotFile)); statErr == nil {
+		// O arquivo existe mas não pôde ser lido: isso é um erro, não "sem snapshot".
+		return nil, err
+	}
+
+	// Log antigo ainda não migrado pelo servidor.
+	if nextSegment == 0 {
+		if f, err := openRead(rl.fs, rl.path(logFile)); err == nil {
+			err := rl.replayLog(f, 0)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			st.Segments = append(st.Segments, rl.path(logFile))
+		}
+	}
+
+	segments, err := listSegments(rl.fs, dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range segments {
+		if n >= nextSegment {
+			st.Segments = append(st.Segments, rl.path(segmentName(n)))
+		}
+	}
+	if err := rl.replaySegments(nextSegment); err != nil {
+		return nil, err
+	}
+
+	st.Lists = make(map[string][]int, len(rl.lists))
+	var ids []string
+	var keys []*ManagedList
+	for id, ml := range rl.lists {
+		if ml.kind == KindList {
+			st.Lists[id] = ml.values()
+		} else {
+			ids, keys = append(ids, id), append(keys, ml)
+		}
+		if !ml.expires.IsZero() {
+			if st.Expires == nil {
+				st.Expires = make(map[string]time.Time)
+			}
+			st.Expires[id] = ml.expires
+		}
+		if len(ml.delayed) > 0 {
+			if st.Delayed == nil {
+				st.Delayed = make(map[string][]DelayedItem)
+			}
+			st.Delayed[id] = ml.delayed
+		}
+	}
+	st.Structures = structuresOf(captureStructures(ids, keys))
+	st.LastSeq = rl.seq
+	st.Prepared, st.Decided = rl.preparedList(), rl.decided.list()
+	st.Problems = rl.replayProblems
+	return st, nil
+}
+
+// ReadSnapshotFile lê um snapshot (formato novo ou antigo) de qualquer caminho.
+func ReadSnapshotFile(path string) (SnapshotInfo, map[string][]int, error) {
+	fsys, err := offlineFS()
+	if err != nil {
+		return SnapshotInfo{}, nil, err
+	}
+	f, err := openRead(fsys, path)
+	if err != nil {
+		return SnapshotInfo{}, nil, err
+	}
+	defer f.Close()
+	state, err := decodeSnapshot(f)
+	if err != nil {
+		return SnapshotInfo{}, nil, err
+	}
+	info := SnapshotInfo{Version: state.Version, NextSegment: state.NextSegment, LastSeq: state.LastSeq, CreatedAt: state.CreatedAt,
+		Compression: state.Compression, Checksum: state.Checksum}
+	return info, state.Lists, nil
+}
+
+// ReadLogFile percorre um arquivo de log chamando fn para cada linha não vazia,
+// com o registro interpretado ou o erro de interpretação. O arquivo pode ser o
+// segmento que um servidor está escrevendo (ver openTail).
+func ReadLogFile(path string, fn func(lineNo int, line string, rec LogRecord, err error) error) error {
+	fsys, err := offlineFS()
+	if err != nil {
+		return err
+	}
+	f, err := openTail(fsys, path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return scanLog(f, fn)
+}
+
+// CompactOffline aplica todo o log de dir sobre o snapshot, grava o resultado
+// como um snapshot novo (com o corpo comprimido com compression) e então apaga
+// (ou move para archiveDir) os segmentos cobertos. O servidor não pode estar
+// rodando sobre o mesmo diretório.
+func CompactOffline(dir, archiveDir, compression string) (*OfflineState, error) {
+	if err := checkCompression(compression); err != nil {
+		return nil, err
+	}
+	fsys, err := offlineFS()
+	if err != nil {
+		return nil, err
+	}
+	rl := &RemoteList{cfg: Config{Dir: dir, ArchiveDir: archiveDir}, fs: fsys}
+	if err := rl.migrateLegacyLog(); err != nil {
+		return nil, fmt.Errorf("falha ao migrar log antigo: %w", err)
+	}
+
+	st, err := LoadOffline(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	segments, err := listSegments(rl.fs, dir)
+	if err != nil {
+		return nil, err
+	}
+	next := st.Snapshot.NextSegment
+	if len(segments) > 0 && segments[len(segments)-1] >= next {
+		next = segments[len(segments)-1] + 1
+	}
+
+	state := snapshotState{NextSegment: next, LastSeq: st.LastSeq, CreatedAt: time.Now(), Lists: st.Lists, Prepared: st.Prepared, Decided: st.Decided, Expires: st.Expires, Delayed: st.Delayed, Structures: st.Structures}
+	if err := writeSnapshotFile(rl.fs, rl.path(snapshotFile), state, compression); err != nil {
+		return nil, err
+	}
+	if archiveDir != "" {
+		if err := rl.archiveSnapshot(st.LastSeq); err != nil {
+			return nil, err
+		}
+		// Depois de uma queda, o último segmento não tem o bloco final e, no
+		// arquivo, deixaria de ser o mais novo.
+		if len(segments) > 0 {
+			if err := rl.resealSegment(segments[len(segments)-1]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := rl.retireSegments(next); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
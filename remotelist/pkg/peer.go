@@ -0,0 +1,145 @@
+package remotelist
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net"
+	"net/rpc"
+	"time"
+)
+
+// --- Serviço dos servidores pares ---
+//
+// Replicação (ApplyLog, InstallState), migração de listas (ExportList,
+// ImportList, DeleteList) e transações (PrepareTx, CommitTx, AbortTx,
+// PreparedTxs) são chamadas pelo primário, pelo roteador e pelo coordenador,
+// nunca pelos clientes: ficam no serviço "Peer", registrado à parte do
+// "RemoteList" (rpc.RegisterName("Peer", rl.Peer())).
+//
+// Numa conexão atendida por RemoteList.ServeConn ou por um ConnServer, o
+// serviço Peer só responde depois de a conexão se identificar com Peer.Auth e
+// a chave dos pares (Config.PeerKey ou ConnConfig.PeerKey); sem chave
+// configurada, só conexões de loopback são atendidas. DialPeer conecta e se
+// identifica. Os pedidos do Peer não passam pelo limite de pedidos da conexão;
+// todos os do RemoteList passam.
+
+// peerService é o nome do serviço dos pares.
+const peerService = "Peer"
+
+var (
+	errPeerKey    = errors.New("chave de servidor par inválida")
+	errPeerAuth   = errors.New("o serviço Peer exige que a conexão se identifique antes (Peer.Auth)")
+	errPeerRemote = errors.New("servidor sem chave de pares (PeerKey): o serviço Peer só atende conexões locais")
+)
+
+type PeerAuthArgs struct {
+	Key string
+}
+type PeerAuthReply struct{}
+
+// Peer é o serviço dos pares sobre um RemoteList.
+type Peer struct {
+	rl *RemoteList
+}
+
+// Peer devolve o serviço dos pares deste servidor.
+func (rl *RemoteList) Peer() *Peer {
+	return &Peer{rl: rl}
+}
+
+// Auth identifica a conexão como de um servidor par. A chave é conferida pelo
+// codec da conexão (ver serverCodec), antes de a chamada chegar aqui.
+func (p *Peer) Auth(args PeerAuthArgs, reply *PeerAuthReply) error {
+	return nil
+}
+
+func (p *Peer) ApplyLog(args ApplyLogArgs, reply *ApplyLogReply) error {
+	return p.rl.applyLog(args, reply)
+}
+
+func (p *Peer) InstallState(args InstallStateArgs, reply *InstallStateReply) error {
+	return p.rl.installState(args, reply)
+}
+
+func (p *Peer) ExportList(args ExportListArgs, reply *ExportListReply) error {
+	return p.rl.exportList(args, reply)
+}
+
+func (p *Peer) ImportList(args ImportListArgs, reply *ImportListReply) error {
+	return p.rl.importList(args, reply)
+}
+
+func (p *Peer) DeleteList(args DeleteListArgs, reply *DeleteListReply) error {
+	return p.rl.deleteList(args, reply)
+}
+
+func (p *Peer) PrepareTx(args PrepareTxArgs, reply *PrepareTxReply) error {
+	return p.rl.prepareTx(args, reply)
+}
+
+func (p *Peer) CommitTx(args CommitTxArgs, reply *CommitTxReply) error {
+	return p.rl.commitTx(args, reply)
+}
+
+func (p *Peer) AbortTx(args AbortTxArgs, reply *AbortTxReply) error {
+	return p.rl.abortTx(args, reply)
+}
+
+func (p *Peer) PreparedTxs(args PreparedTxsArgs, reply *PreparedTxsReply) error {
+	return p.rl.preparedTxs(args, reply)
+}
+
+// BoltPeer é o serviço dos pares sobre um BoltList: só a migração de listas.
+type BoltPeer struct {
+	bl *BoltList
+}
+
+// Peer devolve o serviço dos pares deste banco.
+func (bl *BoltList) Peer() *BoltPeer {
+	return &BoltPeer{bl: bl}
+}
+
+// Auth: ver Peer.Auth.
+func (p *BoltPeer) Auth(args PeerAuthArgs, reply *PeerAuthReply) error {
+	return nil
+}
+
+func (p *BoltPeer) ExportList(args ExportListArgs, reply *ExportListReply) error {
+	return p.bl.exportList(args, reply)
+}
+
+func (p *BoltPeer) ImportList(args ImportListArgs, reply *ImportListReply) error {
+	return p.bl.importList(args, reply)
+}
+
+func (p *BoltPeer) DeleteList(args DeleteListArgs, reply *DeleteListReply) error {
+	return p.bl.deleteList(args, reply)
+}
+
+// DialPeer conecta no servidor addr e, com uma chave, identifica a conexão
+// como de um par.
+func DialPeer(addr, key string, timeout time.Duration) (*rpc.Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	client := rpc.NewClient(conn)
+	if key != "" {
+		if err := client.Call(peerService+".Auth", PeerAuthArgs{Key: key}, &PeerAuthReply{}); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// peerAuth confere a chave de um Peer.Auth.
+func peerAuth(want, got string) bool {
+	return want != "" && subtle.ConstantTimeCompare([]byte(want), []byte(got)) == 1
+}
+
+// isLoopback diz se a conexão vem da própria máquina.
+func isLoopback(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && tcp.IP.IsLoopback()
+}
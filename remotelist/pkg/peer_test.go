@@ -0,0 +1,63 @@
+package remotelist_test
+
+import (
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+
+	"remotelist/pkg"
+)
+
+// O serviço Peer: com PeerKey, só uma conexão identificada por Peer.Auth o
+// usa, sem passar pelo limite de pedidos, que continua valendo para todo o
+// serviço RemoteList, inclusive nessa conexão.
+func TestPeerAuth(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{PeerKey: "segredo", RateLimit: 0.01, RateBurst: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prepared := func(cl *rpc.Client) error {
+		return cl.Call("Peer.PreparedTxs", remotelist.PreparedTxsArgs{}, &remotelist.PreparedTxsReply{})
+	}
+	size := func(cl *rpc.Client) error {
+		return cl.Call("RemoteList.Size", remotelist.SizeArgs{ListID: "a"}, &remotelist.SizeReply{})
+	}
+
+	cl, _, err := c.dial(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := prepared(cl); err == nil || !strings.Contains(err.Error(), "Peer.Auth") {
+		t.Fatalf("Peer sem Auth: %v", err)
+	}
+	if err := cl.Call("Peer.Auth", remotelist.PeerAuthArgs{Key: "errada"}, &remotelist.PeerAuthReply{}); err == nil {
+		t.Fatal("Auth com a chave errada foi aceito")
+	}
+	if err := prepared(cl); err == nil {
+		t.Fatal("Peer atendido depois de um Auth recusado")
+	}
+	if _, err := remotelist.DialPeer(n.addr, "errada", time.Second); err == nil {
+		t.Fatal("DialPeer com a chave errada conectou")
+	}
+
+	peer, err := remotelist.DialPeer(n.addr, "segredo", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	for i := 0; i < 30; i++ {
+		if err := prepared(peer); err != nil {
+			t.Fatalf("chamada %d do par: %v", i, err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := size(peer); err != nil {
+			t.Fatalf("chamada %d dentro da rajada: %v", i, err)
+		}
+	}
+	if err := size(peer); remotelist.LimitCode(err) != remotelist.CodeRateLimited {
+		t.Fatalf("RemoteList numa conexão de par passou do limite: %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+// Package quorum é um modo de replicação sem líder, no estilo Dynamo, para
+// implantações que preferem disponibilidade a consistência imediata.
+//
+// Cada lista é guardada em N réplicas: os N primeiros nós distintos do anel de
+// hash consistente (shard.Ring) a partir do nome dela. Qualquer nó atende os
+// clientes como coordenador: uma leitura espera R respostas e uma escrita
+// espera W confirmações. Cada valor gravado leva um relógio vetorial; versões
+// concorrentes (nenhum relógio domina o outro) são guardadas lado a lado como
+// irmãs até a próxima escrita, que parte do relógio de todas elas e as substitui.
+//
+// Réplicas divergentes são reconciliadas de duas formas:
+//
+//   - hinted handoff: se uma réplica não responde, a escrita vai para o próximo
+//     nó do anel junto com uma dica de quem era o destino; o nó entrega a versão
+//     ao destino quando ele voltar (e só então apaga a dica);
+//   - read repair: depois de responder ao cliente, o coordenador espera as
+//     réplicas restantes e envia a quem estiver atrasado as versões que faltam.
+//
+// As escritas são leitura-modificação-escrita da lista inteira: o modo serve
+// para listas pequenas e disponibilidade, não para listas enormes.
+//
+// Irmãs não são fundidas: escritas concorrentes na mesma lista por
+// coordenadores diferentes (um de cada lado de uma partição, por exemplo)
+// perdem todas menos uma. As leituras mostram a irmã gravada por último (ver
+// resolve), e a próxima escrita parte dela. Quem não pode perder escritas
+// manda as de cada lista sempre pelo mesmo coordenador, ou usa a replicação
+// com primário do RemoteList.
+package quorum
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Clock é um relógio vetorial: contador por nó coordenador.
+type Clock map[string]uint64
+
+// Order é o resultado da comparação de dois relógios.
+type Order int
+
+const (
+	Equal      Order = iota
+	Before           // O primeiro relógio é anterior ao segundo
+	After            // O primeiro relógio é posterior ao segundo
+	Concurrent       // Nenhum domina o outro
+)
+
+// Compare compara c com o.
+func (c Clock) Compare(o Clock) Order {
+	less, greater := false, false
+	for node, n := range c {
+		switch m := o[node]; {
+		case n < m:
+			less = true
+		case n > m:
+			greater = true
+		}
+	}
+	for node, m := range o {
+		if _, ok := c[node]; !ok && m > 0 {
+			less = true
+		}
+	}
+	switch {
+	case less && greater:
+		return Concurrent
+	case less:
+		return Before
+	case greater:
+		return After
+	}
+	return Equal
+}
+
+// Merge devolve um relógio novo com o máximo de cada contador.
+func (c Clock) Merge(o Clock) Clock {
+	out := make(Clock, len(c)+len(o))
+	for node, n := range c {
+		out[node] = n
+	}
+	for node, n := range o {
+		if n > out[node] {
+			out[node] = n
+		}
+	}
+	return out
+}
+
+// String formata o relógio com os nós em ordem, para logs e comparações.
+func (c Clock) String() string {
+	nodes := make([]string, 0, len(c))
+	for node := range c {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	parts := make([]string, len(nodes))
+	for i, node := range nodes {
+		parts[i] = fmt.Sprintf("%s:%d", node, c[node])
+	}
+	return "{" + strings.Join(parts, " ") + "}"
+}
+
+// Version é um valor da lista gravado por um coordenador.
+type Version struct {
+	Clock  Clock
+	Values []int
+	Time   int64  // Instante da escrita (unix nano), usado só para escolher entre irmãs
+	Writer string // Coordenador que gravou
+}
+
+// reconcile junta v às irmãs: descarta as que v domina e não inclui v se alguma
+// já o domina (ou é igual). changed diz se o conjunto mudou.
+func reconcile(siblings []Version, v Version) (out []Version, changed bool) {
+	for _, s := range siblings {
+		switch s.Clock.Compare(v.Clock) {
+		case Equal, After:
+			return siblings, false
+		}
+	}
+	out = make([]Version, 0, len(siblings)+1)
+	for _, s := range siblings {
+		if s.Clock.Compare(v.Clock) != Before {
+			out = append(out, s)
+		}
+	}
+	return append(out, v), true
+}
+
+// resolve escolhe o valor que o coordenador mostra ao cliente e devolve o
+// relógio que cobre todas as irmãs. Entre irmãs concorrentes vence a escrita
+// mais recente (empate pelo nome do coordenador): o resultado é o mesmo em
+// qualquer nó, e a próxima escrita, feita sobre o relógio unido, substitui
+// todas elas. O que só as outras irmãs tinham se perde (last-writer-wins).
+func resolve(siblings []Version) (Version, Clock) {
+	if len(siblings) == 0 {
+		return Version{}, Clock{}
+	}
+	best := siblings[0]
+	clock := Clock{}
+	for _, s := range siblings {
+		clock = clock.Merge(s.Clock)
+		if s.Time > best.Time || (s.Time == best.Time && s.Writer > best.Writer) {
+			best = s
+		}
+	}
+	return best, clock
+}
+
+// signature descreve um conjunto de irmãs independente da ordem, para saber
+// se duas réplicas têm o mesmo conteúdo.
+func signature(siblings []Version) string {
+	parts := make([]string, len(siblings))
+	for i, s := range siblings {
+		parts[i] = s.Clock.String()
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
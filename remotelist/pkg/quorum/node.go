@@ -0,0 +1,513 @@
+package quorum
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"remotelist/pkg"
+	"remotelist/pkg/shard"
+)
+
+// keyStripes é a quantidade de locks por lista no coordenador (ver update).
+const keyStripes = 256
+
+// Config reúne os parâmetros de um nó.
+type Config struct {
+	Self  string   // Endereço deste nó (como os outros o chamam)
+	Nodes []string // Todos os nós, incluindo Self
+	// N é o número de réplicas de cada lista; R e W, quantas respostas uma
+	// leitura e uma escrita esperam. Zero usa 3, 2 e 2 (limitados a len(Nodes)).
+	N, R, W int
+	VNodes  int    // Nós virtuais por nó no anel (0 = shard.DefaultVNodes)
+	Path    string // Banco bbolt do nó ("" = quorum.db no diretório atual)
+	// Timeout limita cada chamada a outra réplica (0 = 2s).
+	Timeout time.Duration
+	// HintInterval é o intervalo entre tentativas de entregar dicas (0 = 1s).
+	HintInterval time.Duration
+	// Transport faz as chamadas aos outros nós (nil = TCP).
+	Transport Transport
+}
+
+// Node é um nó do modo quorum. Registre-o com rpc.RegisterName("RemoteList", node)
+// para os clientes e node.Replica() como "Quorum" para os outros nós.
+type Node struct {
+	cfg       Config
+	ring      *shard.Ring
+	store     *store
+	transport Transport
+
+	locks   [keyStripes]sync.Mutex
+	counter atomic.Uint64 // Último contador usado por este nó nos relógios
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewNode abre o banco do nó e começa a entregar as dicas pendentes.
+func NewNode(cfg Config) (*Node, error) {
+	if cfg.Self == "" {
+		return nil, errors.New("informe o endereço do nó (Self)")
+	}
+	ring, err := shard.NewRing(cfg.VNodes, cfg.Nodes...)
+	if err != nil {
+		return nil, err
+	}
+	if !ring.Has(cfg.Self) {
+		return nil, fmt.Errorf("o nó %s não está na lista de nós", cfg.Self)
+	}
+	cfg.N = defaultCount(cfg.N, 3, len(cfg.Nodes))
+	cfg.R = defaultCount(cfg.R, 2, cfg.N)
+	cfg.W = defaultCount(cfg.W, 2, cfg.N)
+	if cfg.Path == "" {
+		cfg.Path = "quorum.db"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+	if cfg.HintInterval == 0 {
+		cfg.HintInterval = time.Second
+	}
+	if cfg.Transport == nil {
+		cfg.Transport = NewTCPTransport(cfg.Timeout)
+	}
+	st, err := openStore(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	n := &Node{cfg: cfg, ring: ring, store: st, transport: cfg.Transport, done: make(chan struct{})}
+	// Os contadores do nó nos relógios só crescem, mesmo depois de reiniciar:
+	// partem do relógio do sistema em vez de zero.
+	n.counter.Store(uint64(time.Now().UnixNano()))
+	n.wg.Add(1)
+	go n.handoffLoop()
+	log.Printf("Nó quorum %s iniciado (N=%d, R=%d, W=%d, %d nós).", cfg.Self, cfg.N, cfg.R, cfg.W, len(cfg.Nodes))
+	return n, nil
+}
+
+func defaultCount(v, def, max int) int {
+	if v <= 0 {
+		v = def
+	}
+	return min(v, max)
+}
+
+// Close para a entrega de dicas e os reparos e fecha o banco.
+func (n *Node) Close() error {
+	close(n.done)
+	n.wg.Wait()
+	n.transport.Close()
+	return n.store.close()
+}
+
+// Replica devolve o serviço chamado pelos outros nós ("Quorum").
+func (n *Node) Replica() *Replica {
+	return &Replica{node: n}
+}
+
+// LocalVersions devolve as irmãs que este nó guarda para a lista.
+func (n *Node) LocalVersions(key string) ([]Version, error) {
+	return n.store.get(key)
+}
+
+// PendingHints conta as entregas que este nó ainda deve a outros.
+func (n *Node) PendingHints() int {
+	return n.store.pendingHints()
+}
+
+// PreferenceList devolve as N réplicas da lista.
+func (n *Node) PreferenceList(key string) []string {
+	return n.ring.Successors(key, n.cfg.N)
+}
+
+// --- Métodos RPC (mesma interface do RemoteList) ---
+
+func (n *Node) Append(args remotelist.AppendArgs, reply *remotelist.AppendReply) error {
+	err := n.update(args.ListID, func(values []int, exists bool) ([]int, error) {
+		return append(values, args.Value), nil
+	})
+	reply.Success = err == nil
+	return err
+}
+
+func (n *Node) Remove(args remotelist.RemoveArgs, reply *remotelist.RemoveReply) error {
+	return n.update(args.ListID, func(values []int, exists bool) ([]int, error) {
+		if !exists {
+			return nil, errors.New("lista não encontrada")
+		}
+		if len(values) == 0 {
+			return nil, errors.New("lista vazia")
+		}
+		reply.Value = values[len(values)-1]
+		return values[:len(values)-1], nil
+	})
+}
+
+func (n *Node) Get(args remotelist.GetArgs, reply *remotelist.GetReply) error {
+	v, _, exists, err := n.read(args.ListID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New("lista não encontrada")
+	}
+	if args.Index < 0 || args.Index >= len(v.Values) {
+		return errors.New("índice fora dos limites")
+	}
+	reply.Value = v.Values[args.Index]
+	return nil
+}
+
+func (n *Node) Size(args remotelist.SizeArgs, reply *remotelist.SizeReply) error {
+	v, _, _, err := n.read(args.ListID)
+	if err != nil {
+		return err
+	}
+	reply.Size = len(v.Values)
+	return nil
+}
+
+// Lists junta as listas de todos os nós que responderem.
+func (n *Node) Lists(args remotelist.ListsArgs, reply *remotelist.ListsReply) error {
+	seen := make(map[string]bool)
+	answered := 0
+	for _, node := range n.ring.Nodes() {
+		var keys KeysReply
+		if err := n.call(node, "Keys", KeysArgs{}, &keys); err != nil {
+			log.Printf("Lists: nó %s não respondeu: %v", node, err)
+			continue
+		}
+		answered++
+		for _, k := range keys.Keys {
+			seen[k] = true
+		}
+	}
+	if answered == 0 {
+		return errors.New("nenhum nó respondeu")
+	}
+	reply.ListIDs = make([]string, 0, len(seen))
+	for k := range seen {
+		reply.ListIDs = append(reply.ListIDs, k)
+	}
+	sort.Strings(reply.ListIDs)
+	return nil
+}
+
+// --- Coordenação ---
+
+// update lê a lista com quorum R, aplica fn e grava o resultado com quorum W.
+// O relógio novo cobre todas as irmãs lidas, então a escrita as substitui.
+// Escritas do mesmo coordenador na mesma lista são serializadas: duas versões
+// nunca saem daqui com o mesmo relógio.
+func (n *Node) update(key string, fn func(values []int, exists bool) ([]int, error)) error {
+	lock := &n.locks[stripe(key)]
+	lock.Lock()
+	defer lock.Unlock()
+
+	cur, clock, exists, err := n.read(key)
+	if err != nil {
+		return err
+	}
+	values, err := fn(append([]int(nil), cur.Values...), exists)
+	if err != nil {
+		return err
+	}
+	clock = clock.Merge(Clock{n.cfg.Self: n.tick(clock[n.cfg.Self])})
+	return n.write(key, Version{Clock: clock, Values: values, Time: time.Now().UnixNano(), Writer: n.cfg.Self})
+}
+
+// tick devolve o próximo contador deste nó, maior que seen.
+func (n *Node) tick(seen uint64) uint64 {
+	for {
+		cur := n.counter.Load()
+		next := max(cur, seen) + 1
+		if n.counter.CompareAndSwap(cur, next) {
+			return next
+		}
+	}
+}
+
+// spares distribui, entre as réplicas que não responderam, os nós do anel
+// fora da lista de preferência (cada um substitui no máximo uma réplica).
+type spares struct {
+	mu    sync.Mutex
+	nodes []string
+}
+
+func (s *spares) take() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.nodes) == 0 {
+		return "", false
+	}
+	next := s.nodes[0]
+	s.nodes = s.nodes[1:]
+	return next, true
+}
+
+// replicas devolve a lista de preferência da lista e os substitutos dela.
+func (n *Node) replicas(key string) ([]string, *spares) {
+	all := n.ring.Successors(key, len(n.ring.Nodes()))
+	return all[:n.cfg.N], &spares{nodes: all[n.cfg.N:]}
+}
+
+// response é a resposta de uma réplica a uma leitura.
+type response struct {
+	from     string
+	spare    bool // Substituto: pode ter só dicas, não recebe read repair
+	siblings []Version
+	err      error
+}
+
+// read consulta as N réplicas (trocando as que falham por substitutos, que
+// respondem também com as dicas que guardam) e responde com as R primeiras.
+// As demais respostas são esperadas em segundo plano para o read repair.
+func (n *Node) read(key string) (Version, Clock, bool, error) {
+	prefs, spares := n.replicas(key)
+	ch := make(chan response, len(prefs))
+	for _, p := range prefs {
+		go func(p string) {
+			var reply FetchReply
+			r := response{from: p}
+			r.err = n.call(p, "Fetch", FetchArgs{Key: key}, &reply)
+			for r.err != nil {
+				spare, ok := spares.take()
+				if !ok {
+					break
+				}
+				reply = FetchReply{}
+				r = response{from: spare, spare: true}
+				r.err = n.call(spare, "Fetch", FetchArgs{Key: key}, &reply)
+			}
+			r.siblings = reply.Versions
+			ch <- r
+		}(p)
+	}
+
+	var got []response
+	failed := 0
+	for len(got) < n.cfg.R {
+		r := <-ch
+		if r.err != nil {
+			failed++
+			if failed > len(prefs)-n.cfg.R {
+				return Version{}, nil, false, fmt.Errorf("quorum de leitura não alcançado (%d de %d réplicas responderam, R=%d): %w", len(got), len(prefs), n.cfg.R, r.err)
+			}
+			continue
+		}
+		got = append(got, r)
+	}
+
+	var siblings []Version
+	for _, r := range got {
+		for _, v := range r.siblings {
+			siblings, _ = reconcile(siblings, v)
+		}
+	}
+	n.wg.Add(1)
+	go n.repair(key, got, ch, len(prefs)-len(got)-failed)
+
+	v, clock := resolve(siblings)
+	return v, clock, len(siblings) > 0, nil
+}
+
+// repair espera as respostas que faltavam e envia a cada réplica que respondeu
+// as versões que ela não tem.
+func (n *Node) repair(key string, got []response, ch chan response, pending int) {
+	defer n.wg.Done()
+	for ; pending > 0; pending-- {
+		select {
+		case r := <-ch:
+			if r.err == nil {
+				got = append(got, r)
+			}
+		case <-n.done:
+			return
+		}
+	}
+
+	var all []Version
+	for _, r := range got {
+		for _, v := range r.siblings {
+			all, _ = reconcile(all, v)
+		}
+	}
+	want := signature(all)
+	for _, r := range got {
+		if r.spare || signature(r.siblings) == want {
+			continue
+		}
+		var missing []Version
+		for _, v := range all {
+			if _, changed := reconcile(r.siblings, v); changed {
+				missing = append(missing, v)
+			}
+		}
+		if err := n.call(r.from, "Store", StoreArgs{Key: key, Versions: missing}, &StoreReply{}); err != nil {
+			log.Printf("Read repair de '%s' em %s falhou: %v", key, r.from, err)
+			continue
+		}
+		log.Printf("Read repair: '%s' atualizada em %s.", key, r.from)
+	}
+}
+
+// write grava v nas N réplicas e volta depois de W confirmações. Uma réplica
+// que não responde é substituída pelo próximo nó do anel fora da lista de
+// preferência, que guarda a versão como dica para ela (sloppy quorum).
+func (n *Node) write(key string, v Version) error {
+	prefs, spares := n.replicas(key)
+	ch := make(chan error, len(prefs))
+	for _, p := range prefs {
+		go func(p string) {
+			err := n.call(p, "Store", StoreArgs{Key: key, Versions: []Version{v}}, &StoreReply{})
+			for err != nil {
+				spare, ok := spares.take()
+				if !ok {
+					break
+				}
+				err = n.call(spare, "Store", StoreArgs{Key: key, Versions: []Version{v}, HintFor: p}, &StoreReply{})
+				if err == nil {
+					log.Printf("Escrita de '%s' para %s entregue a %s como dica.", key, p, spare)
+				}
+			}
+			ch <- err
+		}(p)
+	}
+
+	acks, failed := 0, 0
+	var lastErr error
+	for acks < n.cfg.W {
+		if err := <-ch; err != nil {
+			failed++
+			lastErr = err
+			if failed > len(prefs)-n.cfg.W {
+				return fmt.Errorf("quorum de escrita não alcançado (%d de %d confirmações, W=%d): %w", acks, len(prefs), n.cfg.W, lastErr)
+			}
+			continue
+		}
+		acks++
+	}
+	return nil
+}
+
+// call chama o método da réplica em addr (direto, se for este nó).
+func (n *Node) call(addr, method string, args, reply any) error {
+	if addr == n.cfg.Self {
+		r := n.Replica()
+		switch method {
+		case "Fetch":
+			return r.Fetch(args.(FetchArgs), reply.(*FetchReply))
+		case "Store":
+			return r.Store(args.(StoreArgs), reply.(*StoreReply))
+		case "Keys":
+			return r.Keys(args.(KeysArgs), reply.(*KeysReply))
+		}
+	}
+	return n.transport.Call(addr, "Quorum."+method, args, reply)
+}
+
+// handoffLoop entrega periodicamente as dicas guardadas aos seus destinos.
+func (n *Node) handoffLoop() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.HintInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+		}
+		n.DeliverHints()
+	}
+}
+
+// DeliverHints tenta entregar agora todas as dicas pendentes e devolve
+// quantas foram entregues.
+func (n *Node) DeliverHints() int {
+	hints, err := n.store.hints()
+	if err != nil {
+		log.Printf("Falha ao ler dicas: %v", err)
+		return 0
+	}
+	delivered := 0
+	for _, h := range hints {
+		if err := n.call(h.target, "Store", StoreArgs{Key: h.key, Versions: h.versions}, &StoreReply{}); err != nil {
+			continue
+		}
+		if err := n.store.removeHint(h); err != nil {
+			log.Printf("Falha ao apagar dica entregue: %v", err)
+			continue
+		}
+		delivered++
+		log.Printf("Dica de '%s' entregue a %s.", h.key, h.target)
+	}
+	return delivered
+}
+
+func stripe(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32() % keyStripes
+}
+
+// --- Serviço entre nós ---
+
+// Replica é o serviço "Quorum", chamado pelos coordenadores.
+type Replica struct {
+	node *Node
+}
+
+type FetchArgs struct {
+	Key string
+}
+type FetchReply struct {
+	Versions []Version
+}
+
+type StoreArgs struct {
+	Key      string
+	Versions []Version
+	HintFor  string // Se definido, guarda como dica para este nó em vez de como réplica
+}
+type StoreReply struct{}
+
+type KeysArgs struct{}
+type KeysReply struct {
+	Keys []string
+}
+
+// Fetch devolve as irmãs guardadas para a lista, junto com as dicas que este
+// nó guarda dela (para um substituto responder no lugar da réplica).
+func (r *Replica) Fetch(args FetchArgs, reply *FetchReply) error {
+	var err error
+	reply.Versions, err = r.node.store.get(args.Key)
+	if err != nil {
+		return err
+	}
+	hinted, err := r.node.store.hintsFor(args.Key)
+	for _, v := range hinted {
+		reply.Versions, _ = reconcile(reply.Versions, v)
+	}
+	return err
+}
+
+// Store junta as versões às guardadas (ou às dicas para HintFor).
+func (r *Replica) Store(args StoreArgs, reply *StoreReply) error {
+	if args.HintFor != "" {
+		return r.node.store.addHint(args.HintFor, args.Key, args.Versions)
+	}
+	_, err := r.node.store.put(args.Key, args.Versions)
+	return err
+}
+
+// Keys devolve as listas guardadas neste nó.
+func (r *Replica) Keys(args KeysArgs, reply *KeysReply) error {
+	var err error
+	reply.Keys, err = r.node.store.keys()
+	return err
+}
@@ -0,0 +1,585 @@
+package quorum_test
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"remotelist/pkg"
+	"remotelist/pkg/quorum"
+)
+
+// Modo quorum com vários nós no mesmo processo, falando por TCP através de um
+// transporte que simula partições de rede.
+
+const numLists = 60 // Listas usadas em cada teste
+
+// newCluster sobe size nós, derrubados no fim do teste.
+func newCluster(t *testing.T, size int) *cluster {
+	dir := t.TempDir()
+	c := &cluster{nw: &network{}}
+	t.Cleanup(c.close)
+	for i := 0; i < size; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := l.Addr().String()
+		l.Close()
+		c.addrs = append(c.addrs, addr)
+		c.nodes = append(c.nodes, &node{addr: addr, path: filepath.Join(dir, fmt.Sprintf("q%d.db", i))})
+	}
+	for _, n := range c.nodes {
+		if err := c.start(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+type cluster struct {
+	addrs []string
+	nodes []*node
+	nw    *network
+}
+
+func (c *cluster) close() {
+	for _, n := range c.nodes {
+		c.stop(n)
+	}
+}
+
+// --- Rede com partições ---
+
+var errPartition = errors.New("partição simulada")
+
+// network decide quais nós se enxergam.
+type network struct {
+	mu    sync.RWMutex
+	group map[string]int // Nós em grupos diferentes não se falam
+}
+
+func (nw *network) partition(groups ...[]string) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.group = make(map[string]int)
+	for i, g := range groups {
+		for _, addr := range g {
+			nw.group[addr] = i
+		}
+	}
+}
+
+func (nw *network) heal() {
+	nw.partition()
+}
+
+func (nw *network) connected(a, b string) bool {
+	nw.mu.RLock()
+	defer nw.mu.RUnlock()
+	return nw.group[a] == nw.group[b]
+}
+
+// link é o transporte de um nó: recusa chamadas para o outro lado da partição.
+type link struct {
+	from  string
+	nw    *network
+	inner *quorum.TCPTransport
+}
+
+func (l *link) Call(addr, method string, args, reply any) error {
+	if !l.nw.connected(l.from, addr) {
+		return errPartition
+	}
+	return l.inner.Call(addr, method, args, reply)
+}
+
+func (l *link) Close() error {
+	return l.inner.Close()
+}
+
+// --- Nós em processo ---
+
+type node struct {
+	addr string
+	path string
+	q    *quorum.Node
+	l    net.Listener
+}
+
+func (c *cluster) start(n *node) error {
+	q, err := quorum.NewNode(quorum.Config{
+		Self:         n.addr,
+		Nodes:        c.addrs,
+		Path:         n.path,
+		Timeout:      500 * time.Millisecond,
+		HintInterval: time.Hour, // Os cenários entregam as dicas na hora certa
+		Transport:    &link{from: n.addr, nw: c.nw, inner: quorum.NewTCPTransport(500 * time.Millisecond)},
+	})
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", n.addr)
+	if err != nil {
+		q.Close()
+		return err
+	}
+	rpcs := rpc.NewServer()
+	if err := rpcs.RegisterName("RemoteList", q); err != nil {
+		return err
+	}
+	if err := rpcs.RegisterName("Quorum", q.Replica()); err != nil {
+		return err
+	}
+	go serve(l, rpcs)
+	n.q, n.l = q, l
+	return nil
+}
+
+func (c *cluster) stop(n *node) {
+	if n.q == nil {
+		return
+	}
+	n.l.Close()
+	n.q.Close()
+	n.q = nil
+}
+
+func serve(l net.Listener, rpcs *rpc.Server) {
+	var conns []net.Conn
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conns = append(conns, conn)
+		go rpcs.ServeConn(conn)
+	}
+}
+
+func (c *cluster) byAddr(addr string) *node {
+	for _, n := range c.nodes {
+		if n.addr == addr {
+			return n
+		}
+	}
+	return nil
+}
+
+func listID(prefix string, i int) string {
+	return fmt.Sprintf("%s-%03d", prefix, i)
+}
+
+// get lê a lista inteira por um coordenador.
+func get(q *quorum.Node, id string) ([]int, error) {
+	var size remotelist.SizeReply
+	if err := q.Size(remotelist.SizeArgs{ListID: id}, &size); err != nil {
+		return nil, err
+	}
+	out := make([]int, 0, size.Size)
+	for i := 0; i < size.Size; i++ {
+		var reply remotelist.GetReply
+		if err := q.Get(remotelist.GetArgs{ListID: id, Index: i}, &reply); err != nil {
+			return nil, err
+		}
+		out = append(out, reply.Value)
+	}
+	return out, nil
+}
+
+// signature descreve as irmãs de uma réplica (os relógios, em ordem).
+func signature(versions []quorum.Version) string {
+	parts := make([]string, len(versions))
+	for i, v := range versions {
+		parts[i] = v.Clock.String()
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// converged confere que cada lista tem o mesmo conteúdo nas suas N réplicas,
+// que nenhum outro nó a guarda e que não sobrou nenhuma dica.
+func (c *cluster) converged(ids []string) error {
+	for _, n := range c.nodes {
+		if pending := n.q.PendingHints(); pending > 0 {
+			return fmt.Errorf("%s ainda tem %d dicas", n.addr, pending)
+		}
+	}
+	for _, id := range ids {
+		prefs := c.nodes[0].q.PreferenceList(id)
+		want := ""
+		for i, addr := range prefs {
+			versions, err := c.byAddr(addr).q.LocalVersions(id)
+			if err != nil {
+				return err
+			}
+			if len(versions) == 0 {
+				return fmt.Errorf("lista '%s' falta na réplica %s", id, addr)
+			}
+			sig := signature(versions)
+			if i == 0 {
+				want = sig
+			} else if sig != want {
+				return fmt.Errorf("lista '%s' diverge: %s em %s, %s em %s", id, sig, addr, want, prefs[0])
+			}
+		}
+		for _, n := range c.nodes {
+			versions, _ := n.q.LocalVersions(id)
+			isPref := false
+			for _, addr := range prefs {
+				isPref = isPref || addr == n.addr
+			}
+			if !isPref && len(versions) > 0 {
+				return fmt.Errorf("lista '%s' guardada fora das réplicas, em %s", id, n.addr)
+			}
+		}
+	}
+	return nil
+}
+
+// reconcile entrega as dicas e lê cada lista (disparando o read repair) até
+// as réplicas convergirem.
+func (c *cluster) reconcile(ids []string) error {
+	deadline := time.Now().Add(15 * time.Second)
+	for {
+		for _, n := range c.nodes {
+			n.q.DeliverHints()
+		}
+		for i, id := range ids {
+			var size remotelist.SizeReply
+			c.nodes[i%len(c.nodes)].q.Size(remotelist.SizeArgs{ListID: id}, &size)
+		}
+		time.Sleep(50 * time.Millisecond) // Os reparos terminam em segundo plano
+		err := c.converged(ids)
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+	}
+}
+
+// --- Cenários ---
+
+func TestBasic(t *testing.T) {
+	c := newCluster(t, 5)
+	r := rand.New(rand.NewSource(1))
+	model := make(map[string][]int)
+	var ids []string
+	for i := 0; i < numLists; i++ {
+		ids = append(ids, listID("basic", i))
+	}
+	for op := 0; op < numLists*10; op++ {
+		id := ids[r.Intn(len(ids))]
+		q := c.nodes[r.Intn(len(c.nodes))].q
+		if len(model[id]) > 0 && r.Intn(4) == 0 {
+			var reply remotelist.RemoveReply
+			if err := q.Remove(remotelist.RemoveArgs{ListID: id}, &reply); err != nil {
+				t.Fatal(err)
+			}
+			want := model[id][len(model[id])-1]
+			model[id] = model[id][:len(model[id])-1]
+			if reply.Value != want {
+				t.Fatalf("Remove(%s) = %d, esperado %d", id, reply.Value, want)
+			}
+			continue
+		}
+		v := r.Intn(1000)
+		if err := q.Append(remotelist.AppendArgs{ListID: id, Value: v}, &remotelist.AppendReply{}); err != nil {
+			t.Fatal(err)
+		}
+		model[id] = append(model[id], v)
+	}
+
+	// R + W > N: qualquer coordenador lê a última escrita.
+	for i, id := range ids {
+		got, err := get(c.nodes[i%len(c.nodes)].q, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(model[id]) && len(model[id])+len(got) > 0 {
+			t.Fatalf("lista '%s' = %v, esperado %v", id, got, model[id])
+		}
+	}
+	var lists remotelist.ListsReply
+	if err := c.nodes[0].q.Lists(remotelist.ListsArgs{}, &lists); err != nil {
+		t.Fatal(err)
+	}
+	if len(lists.ListIDs) != len(model) {
+		t.Fatalf("Lists devolveu %d listas, esperado %d", len(lists.ListIDs), len(model))
+	}
+	var used []string
+	for id := range model {
+		used = append(used, id)
+	}
+	if err := c.reconcile(used); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuorumLoss(t *testing.T) {
+	c := newCluster(t, 5)
+	isolated := c.nodes[0]
+	c.nw.partition([]string{isolated.addr}, c.addrs[1:])
+
+	err := isolated.q.Append(remotelist.AppendArgs{ListID: "x", Value: 1}, &remotelist.AppendReply{})
+	if err == nil || !strings.Contains(err.Error(), "quorum") {
+		t.Fatalf("nó isolado aceitou escrita: %v", err)
+	}
+	if err := isolated.q.Size(remotelist.SizeArgs{ListID: "x"}, &remotelist.SizeReply{}); err == nil {
+		t.Fatalf("nó isolado respondeu leitura sem quorum")
+	}
+
+	// O lado majoritário continua, mesmo para listas que têm o nó isolado entre
+	// as réplicas (o substituto guarda a dica).
+	var ids []string
+	for i := 0; i < numLists; i++ {
+		id := listID("maioria", i)
+		ids = append(ids, id)
+		if err := c.nodes[1+i%4].q.Append(remotelist.AppendArgs{ListID: id, Value: i}, &remotelist.AppendReply{}); err != nil {
+			t.Fatalf("maioria recusou escrita: %v", err)
+		}
+		got, err := get(c.nodes[1+(i+1)%4].q, id)
+		if err != nil || len(got) != 1 || got[0] != i {
+			t.Fatalf("maioria leu '%s' = %v (%v)", id, got, err)
+		}
+	}
+
+	c.nw.heal()
+	if err := c.reconcile(ids); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNodeDown(t *testing.T) {
+	c := newCluster(t, 5)
+	down := c.nodes[2]
+	var ids []string
+	model := make(map[string][]int)
+	write := func(round int) error {
+		for i := 0; i < numLists; i++ {
+			id := listID("down", i)
+			coord := c.nodes[(i+round)%len(c.nodes)]
+			if coord == down {
+				coord = c.nodes[0]
+			}
+			v := round*1000 + i
+			if err := coord.q.Append(remotelist.AppendArgs{ListID: id, Value: v}, &remotelist.AppendReply{}); err != nil {
+				return fmt.Errorf("rodada %d, '%s': %w", round, id, err)
+			}
+			model[id] = append(model[id], v)
+		}
+		return nil
+	}
+	for i := 0; i < numLists; i++ {
+		ids = append(ids, listID("down", i))
+	}
+	if err := write(0); err != nil {
+		t.Fatal(err)
+	}
+
+	c.stop(down)
+	if err := write(1); err != nil {
+		t.Fatal(err)
+	}
+	hints := 0
+	for _, n := range c.nodes {
+		if n.q != nil {
+			hints += n.q.PendingHints()
+		}
+	}
+	if hints == 0 {
+		t.Fatalf("nenhuma dica guardada com %s parado", down.addr)
+	}
+	t.Logf("%d dicas guardadas para %s.", hints, down.addr)
+
+	// O nó volta com o banco antigo; as dicas completam o que ele perdeu.
+	if err := c.start(down); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range c.nodes {
+		n.q.DeliverHints()
+	}
+	for _, id := range ids {
+		prefs := down.q.PreferenceList(id)
+		for _, addr := range prefs {
+			if addr != down.addr {
+				continue
+			}
+			versions, err := down.q.LocalVersions(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(versions) != 1 || fmt.Sprint(versions[0].Values) != fmt.Sprint(model[id]) {
+				t.Fatalf("'%s' em %s depois das dicas: %v, esperado %v", id, addr, versions, model[id])
+			}
+		}
+	}
+	if err := c.reconcile(ids); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPartitionHeal(t *testing.T) {
+	c := newCluster(t, 5)
+	sideA, sideB := c.nodes[:2], c.nodes[2:]
+	c.nw.partition([]string{c.addrs[0], c.addrs[1]}, c.addrs[2:])
+
+	// Listas de um lado só: um cliente por lista, pelo mesmo coordenador, então
+	// nenhuma escrita é concorrente e nenhuma pode se perder.
+	model := make(map[string][]int)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for s, side := range [][]*node{sideA, sideB} {
+		for w := 0; w < 2; w++ {
+			wg.Add(1)
+			go func(s, w int, side []*node) {
+				defer wg.Done()
+				r := rand.New(rand.NewSource(int64(10*s + w)))
+				coord := side[w%len(side)].q
+				for i := 0; i < numLists; i++ {
+					own := listID(fmt.Sprintf("lado%d-cliente%d", s, w), i%(numLists/4))
+					v := r.Intn(1000)
+					if err := coord.Append(remotelist.AppendArgs{ListID: own, Value: v}, &remotelist.AppendReply{}); err != nil {
+						errs <- fmt.Errorf("lado %d: %w", s, err)
+						return
+					}
+					mu.Lock()
+					model[own] = append(model[own], v)
+					mu.Unlock()
+
+					// E listas escritas pelos dois lados ao mesmo tempo.
+					shared := listID("comum", i%(numLists/4))
+					if err := coord.Append(remotelist.AppendArgs{ListID: shared, Value: v}, &remotelist.AppendReply{}); err != nil {
+						errs <- fmt.Errorf("lado %d: %w", s, err)
+						return
+					}
+				}
+			}(s, w, side)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	c.nw.heal()
+	var ids []string
+	for id := range model {
+		ids = append(ids, id)
+	}
+	for i := 0; i < numLists/4; i++ {
+		ids = append(ids, listID("comum", i))
+	}
+	if err := c.reconcile(ids); err != nil {
+		t.Fatal(err)
+	}
+
+	siblings := 0
+	for i := 0; i < numLists/4; i++ {
+		versions, _ := c.byAddr(c.nodes[0].q.PreferenceList(listID("comum", i))[0]).q.LocalVersions(listID("comum", i))
+		if len(versions) > 1 {
+			siblings++
+		}
+	}
+	t.Logf("%d listas comuns ficaram com versões concorrentes.", siblings)
+
+	for id, want := range model {
+		got, err := get(c.nodes[0].q, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("lista '%s' = %v, esperado %v", id, got, want)
+		}
+	}
+
+	// Uma escrita depois da cura parte do relógio de todas as irmãs e as substitui.
+	var shared []string
+	for i := 0; i < numLists/4; i++ {
+		id := listID("comum", i)
+		shared = append(shared, id)
+		if err := c.nodes[i%len(c.nodes)].q.Append(remotelist.AppendArgs{ListID: id, Value: -1}, &remotelist.AppendReply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.reconcile(shared); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range shared {
+		for _, addr := range c.nodes[0].q.PreferenceList(id) {
+			versions, _ := c.byAddr(addr).q.LocalVersions(id)
+			if len(versions) != 1 {
+				t.Fatalf("lista '%s' ainda tem %d versões em %s depois de uma escrita", id, len(versions), addr)
+			}
+		}
+	}
+}
+
+// Irmãs não são fundidas: de dois appends concorrentes por coordenadores dos
+// dois lados de uma partição, só o mais recente sobrevive à cura, e a escrita
+// seguinte parte dele.
+func TestConcurrentWritesLost(t *testing.T) {
+	c := newCluster(t, 5)
+	// O lado de b fica com duas das três réplicas: a leitura dele (R = 2) vê
+	// o primeiro append mesmo respondida por um substituto.
+	prefs := c.nodes[0].q.PreferenceList("perdida")
+	sideA, sideB := []string{prefs[0]}, []string{prefs[1], prefs[2]}
+	for _, addr := range c.addrs {
+		if addr != prefs[0] && addr != prefs[1] && addr != prefs[2] {
+			if len(sideA) < 2 {
+				sideA = append(sideA, addr)
+			} else {
+				sideB = append(sideB, addr)
+			}
+		}
+	}
+	a, b := c.byAddr(sideA[1]), c.byAddr(sideB[2])
+	if err := a.q.Append(remotelist.AppendArgs{ListID: "perdida", Value: 1}, &remotelist.AppendReply{}); err != nil {
+		t.Fatal(err)
+	}
+	// O primeiro append em todas as réplicas, para os dois lados o lerem.
+	if err := c.reconcile([]string{"perdida"}); err != nil {
+		t.Fatal(err)
+	}
+	c.nw.partition(sideA, sideB)
+	if err := a.q.Append(remotelist.AppendArgs{ListID: "perdida", Value: 2}, &remotelist.AppendReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.q.Append(remotelist.AppendArgs{ListID: "perdida", Value: 3}, &remotelist.AppendReply{}); err != nil {
+		t.Fatal(err)
+	}
+
+	c.nw.heal()
+	if err := c.reconcile([]string{"perdida"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range c.nodes {
+		got, err := get(n.q, "perdida")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != "[1 3]" {
+			t.Fatalf("'perdida' lida por %s = %v, esperado [1 3] (o append de %s se perde)", n.addr, got, a.addr)
+		}
+	}
+	if err := a.q.Append(remotelist.AppendArgs{ListID: "perdida", Value: 4}, &remotelist.AppendReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := get(b.q, "perdida"); err != nil || fmt.Sprint(got) != "[1 3 4]" {
+		t.Fatalf("'perdida' depois de uma escrita = %v (%v), esperado [1 3 4]", got, err)
+	}
+}
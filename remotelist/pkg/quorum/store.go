@@ -0,0 +1,208 @@
+package quorum
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// O armazenamento de cada nó é um banco bbolt com dois buckets:
+//
+//   - "versions": nome da lista -> irmãs ([]Version em gob);
+//   - "hints": nome da lista + "\x00" + destino -> irmãs a entregar ao destino.
+//
+// Cada alteração é uma transação com fsync, confirmada antes da resposta.
+
+var (
+	bucketVersions = []byte("versions")
+	bucketHints    = []byte("hints")
+)
+
+type store struct {
+	db *bolt.DB
+}
+
+func openStore(path string) (*store, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("falha ao abrir banco %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(bucketVersions); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(bucketHints)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &store{db: db}, nil
+}
+
+func (s *store) close() error {
+	return s.db.Close()
+}
+
+// get devolve as irmãs guardadas para a lista (nil se ela não existe aqui).
+func (s *store) get(key string) ([]Version, error) {
+	var out []Version
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		out, err = decodeVersions(tx.Bucket(bucketVersions).Get([]byte(key)))
+		return err
+	})
+	return out, err
+}
+
+// put junta as versões às irmãs da lista. changed diz se algo mudou.
+func (s *store) put(key string, versions []Version) (changed bool, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		changed, err = mergeInto(tx.Bucket(bucketVersions), []byte(key), versions)
+		return err
+	})
+	return changed, err
+}
+
+// keys devolve os nomes das listas guardadas neste nó, em ordem.
+func (s *store) keys() ([]string, error) {
+	var out []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketVersions).ForEach(func(k, v []byte) error {
+			out = append(out, string(k))
+			return nil
+		})
+	})
+	return out, err
+}
+
+// hint é uma entrega pendente para outro nó.
+type hint struct {
+	target   string
+	key      string
+	versions []Version
+}
+
+func hintKey(target, key string) []byte {
+	return []byte(key + "\x00" + target)
+}
+
+// addHint guarda versões que deveriam estar em target.
+func (s *store) addHint(target, key string, versions []Version) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		_, err := mergeInto(tx.Bucket(bucketHints), hintKey(target, key), versions)
+		return err
+	})
+}
+
+// hints devolve todas as entregas pendentes.
+func (s *store) hints() ([]hint, error) {
+	var out []hint
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketHints).ForEach(func(k, v []byte) error {
+			key, target, ok := strings.Cut(string(k), "\x00")
+			if !ok {
+				return nil
+			}
+			versions, err := decodeVersions(v)
+			if err != nil {
+				return err
+			}
+			out = append(out, hint{target: target, key: key, versions: versions})
+			return nil
+		})
+	})
+	return out, err
+}
+
+// hintsFor devolve as versões da lista guardadas como dica, para qualquer destino.
+func (s *store) hintsFor(key string) ([]Version, error) {
+	var out []Version
+	prefix := []byte(key + "\x00")
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketHints).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			versions, err := decodeVersions(v)
+			if err != nil {
+				return err
+			}
+			for _, version := range versions {
+				out, _ = reconcile(out, version)
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+// removeHint apaga a dica depois da entrega, a não ser que uma versão nova
+// tenha chegado para o mesmo destino nesse meio tempo.
+func (s *store) removeHint(h hint) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketHints)
+		current, err := decodeVersions(b.Get(hintKey(h.target, h.key)))
+		if err != nil {
+			return err
+		}
+		if signature(current) != signature(h.versions) {
+			return nil
+		}
+		return b.Delete(hintKey(h.target, h.key))
+	})
+}
+
+// pendingHints conta as entregas pendentes.
+func (s *store) pendingHints() int {
+	n := 0
+	s.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(bucketHints).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+// mergeInto junta versions às irmãs guardadas em b[k].
+func mergeInto(b *bolt.Bucket, k []byte, versions []Version) (bool, error) {
+	siblings, err := decodeVersions(b.Get(k))
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	for _, v := range versions {
+		var c bool
+		siblings, c = reconcile(siblings, v)
+		changed = changed || c
+	}
+	if !changed {
+		return false, nil
+	}
+	data, err := encodeVersions(siblings)
+	if err != nil {
+		return false, err
+	}
+	return true, b.Put(k, data)
+}
+
+func encodeVersions(versions []Version) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(versions); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeVersions(data []byte) ([]Version, error) {
+	if data == nil {
+		return nil, nil
+	}
+	var out []Version
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&out); err != nil {
+		return nil, fmt.Errorf("versões corrompidas: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,91 @@
+package quorum
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// Transport faz as chamadas de um nó para os outros. O padrão (TCPTransport)
+// usa net/rpc; os testes trocam por um que simula partições.
+type Transport interface {
+	Call(addr, method string, args, reply any) error
+	Close() error
+}
+
+// errTimeout é devolvido quando uma réplica não responde a tempo.
+var errTimeout = errors.New("réplica não respondeu a tempo")
+
+// TCPTransport mantém uma conexão por nó, reaberta sob demanda, e limita o
+// tempo de cada chamada.
+type TCPTransport struct {
+	timeout time.Duration
+
+	mu    sync.Mutex
+	conns map[string]*rpc.Client
+}
+
+// NewTCPTransport cria o transporte; timeout limita a conexão e cada chamada.
+func NewTCPTransport(timeout time.Duration) *TCPTransport {
+	return &TCPTransport{timeout: timeout, conns: make(map[string]*rpc.Client)}
+}
+
+func (t *TCPTransport) Call(addr, method string, args, reply any) error {
+	client, err := t.client(addr)
+	if err != nil {
+		return err
+	}
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
+	select {
+	case call := <-client.Go(method, args, reply, make(chan *rpc.Call, 1)).Done:
+		var serverErr rpc.ServerError
+		if call.Error != nil && !errors.As(call.Error, &serverErr) {
+			t.drop(addr, client)
+		}
+		return call.Error
+	case <-timer.C:
+		// Uma réplica lenta pode estar com a conexão presa: a próxima chamada usa outra.
+		t.drop(addr, client)
+		return fmt.Errorf("%s: %w", addr, errTimeout)
+	}
+}
+
+func (t *TCPTransport) client(addr string) (*rpc.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c, ok := t.conns[addr]; ok {
+		return c, nil
+	}
+	conn, err := net.DialTimeout("tcp", addr, t.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao conectar em %s: %w", addr, err)
+	}
+	c := rpc.NewClient(conn)
+	t.conns[addr] = c
+	return c, nil
+}
+
+// drop descarta a conexão se ela ainda for a que falhou.
+func (t *TCPTransport) drop(addr string, failed *rpc.Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conns[addr] == failed {
+		failed.Close()
+		delete(t.conns, addr)
+	}
+}
+
+// Close fecha todas as conexões.
+func (t *TCPTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for addr, c := range t.conns {
+		c.Close()
+		delete(t.conns, addr)
+	}
+	return nil
+}
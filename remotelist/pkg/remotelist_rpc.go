@@ -1,27 +1,102 @@
 package remotelist
 
 import (
-	"bufio"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
-	"os"
-	"strconv"
-	"strings"
+	"path/filepath"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Define nomes dos arquivos usados na persistência e o intervalo entre snapshots
 const (
-	// Arquivos de persistência
+	// Arquivos de persistência (o log é dividido em segmentos remotelist.log.NNNNNN)
 	snapshotFile = "remotelist.json"
 	logFile      = "remotelist.log"
-	// Intervalo para salvar snapshots (ex: 30 segundos)
+	// Intervalo padrão para salvar snapshots (ex: 30 segundos)
 	snapshotInterval = 30 * time.Second
 )
 
+// Config reúne os parâmetros do serviço. O valor zero usa os padrões acima.
+type Config struct {
+	// Dir é o diretório dos arquivos de persistência ("" = diretório atual).
+	Dir string
+	// SnapshotInterval é o intervalo entre snapshots automáticos.
+	// Zero usa snapshotInterval; um valor negativo desliga o agendador.
+	SnapshotInterval time.Duration
+	// ArchiveDir, se definido, recebe os segmentos do log já cobertos por um
+	// snapshot em vez de eles serem apagados, além de uma cópia de cada snapshot.
+	// É o que permite a recuperação no tempo (RestoreState e GetAt).
+	ArchiveDir string
+	// FS é onde os arquivos são lidos e gravados (nil = sistema de arquivos do SO).
+	FS FS
+	// EncryptionKey, se definida, cifra o log e os snapshots com AES-GCM (ver
+	// crypt.go; LoadKey a lê de um arquivo ou do ambiente). OldEncryptionKeys
+	// só decifram: um estado lido com uma delas é recifrado por um snapshot
+	// logo na inicialização. Um arquivo cifrado com uma chave desconhecida,
+	// adulterado ou cortado impede a inicialização (ErrDecrypt), assim como um
+	// snapshot corrompido (ErrSnapshotCorrupt).
+	EncryptionKey     []byte
+	OldEncryptionKeys [][]byte
+	// MigratePlaintext aceita, só nesta inicialização, arquivos em texto puro
+	// mesmo com EncryptionKey: é a migração de um diretório gravado sem
+	// criptografia, recifrado pelo snapshot feito em seguida. Sem ela, um
+	// arquivo em texto puro impede a inicialização (ErrDecrypt). As cópias em
+	// ArchiveDir continuam em texto puro.
+	MigratePlaintext bool
+
+	// Backup faz o servidor começar como backup: recusa escritas de clientes e
+	// aplica o WAL enviado pelo primário (ver replication.go).
+	Backup bool
+	// Backups são os endereços para onde o primário envia o seu WAL.
+	Backups []string
+	// SyncReplication faz cada escrita esperar que um backup a grave no disco,
+	// por até ReplicationTimeout (0 = 5s); depois disso basta o disco local.
+	// Sem ela, a escrita é confirmada logo após o fsync local.
+	SyncReplication    bool
+	ReplicationTimeout time.Duration
+	// ReplicationBuffer é quantos registros recentes ficam em memória para
+	// alcançar um backup atrasado (0 = 100000); além disso, o estado inteiro é reenviado.
+	ReplicationBuffer int
+	// HeartbeatInterval é o tempo máximo sem mensagens do primário para um
+	// backup (0 = 250ms); é o que permite ao backup medir o próprio atraso.
+	HeartbeatInterval time.Duration
+	// Primary é o endereço do primário, para onde um backup encaminha as
+	// leituras que não pode atender (ver consistency.go).
+	Primary string
+	// ReapInterval é o intervalo entre as varreduras que apagam as listas com
+	// o TTL vencido (0 = 1s; um valor negativo desliga; ver ttl.go).
+	ReapInterval time.Duration
+	// ScheduleInterval é o tempo máximo entre as verificações dos itens
+	// agendados, além das feitas no prazo de cada um (0 = 1s; um valor
+	// negativo desliga o disparo; ver delayed.go).
+	ScheduleInterval time.Duration
+
+	// Limites (zero = sem limite; ver limits.go): elementos por lista, elementos
+	// no servidor e pedidos por segundo de cada conexão atendida por ServeConn,
+	// com rajadas de até RateBurst (0 = RateLimit).
+	MaxListElements  int
+	MaxTotalElements int
+	RateLimit        float64
+	RateBurst        int
+
+	// PeerKey é a chave que os outros servidores (primário, roteador,
+	// coordenador) apresentam em Peer.Auth para usar o serviço Peer nas
+	// conexões atendidas por ServeConn. Vazia, o serviço Peer só atende
+	// conexões locais (ver peer.go).
+	PeerKey string
+
+	// SnapshotCompression comprime o corpo dos snapshots: CompressionGzip,
+	// CompressionZstd ou CompressionNone (ver snapshot.go). Snapshots
+	// gravados com qualquer uma delas (ou no formato antigo) são lidos.
+	SnapshotCompression string
+}
+
 // --- Structs para Argumentos e Respostas RPC ---
 // Cada operação tem seu par de structs Args/Reply.
 type AppendArgs struct {
@@ -30,11 +105,13 @@ type AppendArgs struct {
 }
 type AppendReply struct {
 	Success bool
+	Token   uint64 // Número do registro da escrita, para leituras ReadYourWrites
 }
 
 type GetArgs struct {
 	ListID string
 	Index  int
+	Read   ReadOptions
 }
 type GetReply struct {
 	Value int
@@ -45,62 +122,181 @@ type RemoveArgs struct {
 }
 type RemoveReply struct {
 	Value int
+	Token uint64 // Número do registro da escrita, para leituras ReadYourWrites
 }
 
 type SizeArgs struct {
 	ListID string
+	Read   ReadOptions
 }
 type SizeReply struct {
 	Size int
 }
 
-// --- Estruturas de Dados do Servidor ---
-
-// ManagedList encapsula uma única lista e seu próprio mutex.
-// Isso permite o bloqueio refinado: operações em listas diferentes; diferentes listas podem ser acessadas em paralelo
-// (ex: 'listaA' e 'listaB') podem ocorrer em paralelo.
-// Cada lista tem seu próprio mutex
-type ManagedList struct {
-	mu   sync.RWMutex // Protege os dados desta lista específica; mutex de leitura/escrita
-	Data []int
+type ListsArgs struct {
+	Read ReadOptions
 }
+type ListsReply struct {
+	ListIDs []string
+}
+
+// --- Estruturas de Dados do Servidor ---
 
 // RemoteList é a estrutura principal do serviço, registrada com o RPC.
 // Ela gerencia o mapa de todas as ManagedLists.
 type RemoteList struct {
-	mapMu sync.RWMutex // Protege o map 'lists' (criação/deleção de listas)
+	mapMu sync.RWMutex            // Protege o map 'lists' (criação/deleção de listas)
 	lists map[string]*ManagedList //guarda todas as listas
 
-	logLock sync.Mutex // Protege o acesso ao arquivo de log (apenas 1 escrita por vez)
-	logFile *os.File
+	fs    FS
+	crypt *EncryptedFS // O mesmo que fs, para a troca de chave (nil nas ferramentas offline)
+
+	logLock   sync.Mutex // Protege o acesso ao arquivo de log (apenas 1 escrita por vez)
+	logFile   File       // Segmento atual do log
+	segment   uint64     // Número do segmento atual
+	seq       uint64     // Último número de sequência (LSN) gravado no log
+	lastTime  time.Time  // Instante do registro seq (zero se desconhecido)
+	failedSeq uint64     // Registro cuja escrita falhou e ainda não foi anulado (0 = log íntegro)
+	abandoned []uint64   // Segmentos abandonados por repairLog ainda sem o bloco final
+
+	replMu         sync.Mutex  // Serializa ApplyLog, InstallState e Promote
+	readOnly       atomic.Bool // Servidor é backup
+	repl           *replicator // Envio do WAL aos backups (nil = sem backups); protegido por logLock e replMu
+	abandonSegment bool        // Backup: o último lote falhou e o segmento não recebe mais nada (logLock)
+	needSnapshot   bool        // Backup: estado instalado ainda não salvo (replMu)
+	follow         follower    // Backup: atraso em relação ao primário (ver consistency.go)
+
+	snapshotMu sync.Mutex   // Garante um snapshot por vez (agendador x Snapshot manual)
+	history    historyCache // Reconstruções do GetAt e segmentos em leitura (ver history.go)
+
+	txMu     sync.Mutex             // Protege prepared e decided
+	prepared map[string]*PreparedTx // Transações preparadas e não decididas (ver txn.go)
+	decided  txDecisions            // Últimas transações decididas
+
+	elements   atomic.Int64 // Elementos somando todas as listas (ver limits.go)
+	limitStats limitStats   // Recusas por limite, para LimitStats
+
+	replayProblems []LogProblem    // Linhas puladas no último replay (usado pelo remotelistctl)
+	aborted        map[uint64]bool // Registros anulados por ABORT, pulados no replay
+
+	cfg  Config
+	done chan struct{} // Fechado por Close para parar as goroutines de background
+	// scheduleWake acorda o scheduler quando um item é agendado (ver delayed.go).
+	scheduleWake chan struct{}
 }
 
 // NewRemoteList é o construtor do nosso serviço; cria o objeto RemoteList
 // Ele inicializa as estruturas e carrega o estado do disco.
 func NewRemoteList() *RemoteList {
+	rl, err := NewRemoteListWithConfig(Config{})
+	if err != nil {
+		// Se não podemos escrever o log, é um erro fatal.
+		log.Fatalf("FATAL: %v", err)
+	}
+	return rl
+}
+
+// NewRemoteListWithConfig cria o serviço usando os parâmetros de cfg.
+// Só retorna erro se não for possível abrir o arquivo de log.
+func NewRemoteListWithConfig(cfg Config) (*RemoteList, error) {
+	if cfg.SnapshotInterval == 0 {
+		cfg.SnapshotInterval = snapshotInterval
+	}
+	if err := checkCompression(cfg.SnapshotCompression); err != nil {
+		return nil, err
+	}
+	crypt, err := newDataFS(fsOrDefault(cfg.FS), cfg.EncryptionKey, cfg.OldEncryptionKeys)
+	if err != nil {
+		return nil, err
+	}
+	crypt.allowPlain.Store(cfg.MigratePlaintext)
 	rl := &RemoteList{
 		lists: make(map[string]*ManagedList),
+		cfg:   cfg,
+		fs:    crypt,
+		crypt: crypt,
+		done:  make(chan struct{}),
+
+		scheduleWake: make(chan struct{}, 1),
 	}
+	rl.follow.changed = make(chan struct{})
+	rl.history.rebuilds = make(chan struct{}, maxHistoryRebuilds)
 
 	// Carrega o estado persistido (snapshot e depois logs)
-	if err := rl.loadFromDisk(); err != nil {
+	if err := rl.loadFromDisk(); unreadableData(err) {
+		// Começar vazio apagaria os dados no próximo snapshot.
+		if rl.logFile != nil {
+			rl.logFile.Close()
+		}
+		return nil, err
+	} else if err != nil {
 		log.Printf("Erro ao carregar dados do disco: %v. Começando com estado vazio.", err)
 		// Garante que o logFile seja criado mesmo se o load falhar
 		if rl.logFile == nil {
-			f, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
-			if err != nil {
-				// Se não podemos escrever o log, é um erro fatal.
-				log.Fatalf("FATAL: Não foi possível abrir o arquivo de log: %v", err)
+			if err := rl.openNextSegment(); err != nil {
+				return nil, fmt.Errorf("não foi possível abrir o arquivo de log: %w", err)
 			}
-			rl.logFile = f
 		}
 	}
 
+	rl.countElements()
+
+	// Algo foi lido em texto puro ou com uma chave antiga: o snapshot recifra
+	// tudo com a chave atual e apaga os segmentos antigos.
+	if rl.crypt.takeStale() {
+		log.Println("Recifrando o estado com a chave atual...")
+		if err := rl.createSnapshot(); err != nil {
+			log.Printf("Erro ao recifrar o estado: %v", err)
+		}
+	}
+	rl.crypt.allowPlain.Store(false)
+
 	// Inicia a goroutine de background para salvar snapshots
-	go rl.snapshotScheduler()
+	if cfg.SnapshotInterval > 0 {
+		go rl.snapshotScheduler()
+	}
+	if cfg.ReapInterval >= 0 {
+		go rl.reaper()
+	}
+	if cfg.ScheduleInterval >= 0 {
+		go rl.scheduler()
+	}
+
+	rl.readOnly.Store(cfg.Backup)
+	if !cfg.Backup && len(cfg.Backups) > 0 {
+		rl.repl = newReplicator(rl, cfg.Backups)
+		rl.repl.start()
+	}
 
 	log.Println("Serviço RemoteList iniciado.")
-	return rl
+	return rl, nil
+}
+
+// Snapshot força a criação de um snapshot fora do agendamento.
+func (rl *RemoteList) Snapshot() error {
+	return rl.createSnapshot()
+}
+
+// Close para o agendador de snapshots e fecha o arquivo de log.
+// O estado em disco continua válido para uma próxima inicialização.
+func (rl *RemoteList) Close() error {
+	close(rl.done)
+	rl.closeFollower()
+	rl.logLock.Lock()
+	defer rl.logLock.Unlock()
+	// Um registro com escrita falha não pode ser reaplicado na próxima inicialização.
+	if rl.failedSeq != 0 {
+		if err := rl.repairLog(); err != nil {
+			rl.logFile.Close()
+			return fmt.Errorf("falha ao anular registro %d: %w", rl.failedSeq, err)
+		}
+	}
+	return rl.logFile.Close()
+}
+
+// path monta o caminho de um arquivo de persistência dentro de cfg.Dir.
+func (rl *RemoteList) path(name string) string {
+	return filepath.Join(rl.cfg.Dir, name)
 }
 
 // --- Métodos RPC ---
@@ -109,30 +305,45 @@ func NewRemoteList() *RemoteList {
 // Cria a lista se ela não existir.
 // trava a lista antes de logar para garantir consistência
 func (rl *RemoteList) Append(args AppendArgs, reply *AppendReply) error {
-	ml := rl.getOrCreateList(args.ListID) //busca ou cria a lista
-
-	// Bloqueia apenas esta lista específica para escrita
-	ml.mu.Lock()
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	// Busca ou cria a lista e bloqueia apenas ela para escrita
+	ml, err := rl.lockOrCreateKey(args.ListID, KindList)
+	if err != nil {
+		return err
+	}
 	defer ml.mu.Unlock()
+	if ml.txn != "" {
+		return errListLocked
+	}
+	if err := rl.reserveElements(args.ListID, ml.size(), 1); err != nil {
+		return err
+	}
 
 	// 1. WAL (Write-Ahead Log): Tenta persistir no disco antes de tudo.
 	// Se der erro aqui (disco cheio, falha de I/O), retorna o erro.
 	// Como não tocou na memória ainda, o estado do servidor continua consistente.
-	err := rl.logOperation("APPEND", args.ListID, &args.Value)
+	seq, err := rl.logOperation("APPEND", args.ListID, &args.Value)
 	if err != nil {
+		rl.releaseElements(1)
 		log.Printf("Erro crítico de persistência (Append): %v", err)
 		return fmt.Errorf("falha ao gravar no disco (WAL): %w", err)
 	}
 
 	// 2. Memória: O disco confirmou a gravação. Agora é seguro alterar a RAM.
-	ml.Data = append(ml.Data, args.Value)
+	ml.push(args.Value)
 
 	reply.Success = true
+	reply.Token = seq
 	return nil
 }
 
 // Get retorna o valor no índice 'i' da lista 'list_id'.
 func (rl *RemoteList) Get(args GetArgs, reply *GetReply) error {
+	if forwarded, err := rl.routeRead(args.Read, "Get", args, reply); forwarded {
+		return err
+	}
 	ml, exists := rl.getList(args.ListID)
 	if !exists {
 		return errors.New("lista não encontrada")
@@ -141,17 +352,26 @@ func (rl *RemoteList) Get(args GetArgs, reply *GetReply) error {
 	// Bloqueia esta lista para leitura
 	ml.mu.RLock() //bloqueio de leitura, permite múltiplos clientes acessando a lista simultaneamente
 	defer ml.mu.RUnlock()
+	if ml.deleted || ml.expired(time.Now()) {
+		return errors.New("lista não encontrada")
+	}
+	if ml.kind != KindList {
+		return errWrongType(args.ListID, ml.kind, KindList)
+	}
 
-	if args.Index < 0 || args.Index >= len(ml.Data) {
+	if args.Index < 0 || args.Index >= ml.len() {
 		return errors.New("índice fora dos limites")
 	}
 
-	reply.Value = ml.Data[args.Index]
+	reply.Value = ml.at(args.Index)
 	return nil
 }
 
 // Remove e retorna o último elemento da lista 'list_id'.
 func (rl *RemoteList) Remove(args RemoveArgs, reply *RemoveReply) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
 	ml, exists := rl.getList(args.ListID)
 	if !exists {
 		return errors.New("lista não encontrada")
@@ -160,31 +380,42 @@ func (rl *RemoteList) Remove(args RemoveArgs, reply *RemoveReply) error {
 	// Bloqueia esta lista para escrita
 	ml.mu.Lock()
 	defer ml.mu.Unlock() // Defer garante que o unlock será chamado
+	if ml.deleted || ml.expired(time.Now()) {
+		return errors.New("lista não encontrada")
+	}
+	if ml.kind != KindList {
+		return errWrongType(args.ListID, ml.kind, KindList)
+	}
 
-	if len(ml.Data) == 0 {
+	if ml.txn != "" {
+		return errListLocked
+	}
+	if ml.len() == 0 {
 		return errors.New("lista vazia")
 	}
 
-	// 1. Preparação: Descobre o valor e índice, mas NÃO remove ainda.
-	lastIndex := len(ml.Data) - 1
-	valueToRemove := ml.Data[lastIndex]
+	// 1. Preparação: A lista não está vazia; nada é removido ainda.
 
 	// 2. WAL: Registra a intenção de remover no disco.
-	err := rl.logOperation("REMOVE", args.ListID, nil)
+	seq, err := rl.logOperation("REMOVE", args.ListID, nil)
 	if err != nil {
 		log.Printf("Erro crítico de persistência (Remove): %v", err)
 		return fmt.Errorf("falha ao gravar no disco (WAL): %w", err)
 	}
 
-	// 3. Memória: O disco confirmou. Agora podemos cortar o slice na RAM.
-	ml.Data = ml.Data[:lastIndex]
-	reply.Value = valueToRemove
+	// 3. Memória: O disco confirmou. Agora podemos remover da RAM.
+	reply.Value = ml.pop()
+	reply.Token = seq
+	rl.releaseElements(1)
 
 	return nil
 }
 
 // Size retorna o número de elementos na lista 'list_id'.
 func (rl *RemoteList) Size(args SizeArgs, reply *SizeReply) error {
+	if forwarded, err := rl.routeRead(args.Read, "Size", args, reply); forwarded {
+		return err
+	}
 	ml, exists := rl.getList(args.ListID)
 	if !exists {
 		// Se a lista não existe, seu tamanho é 0.
@@ -194,9 +425,38 @@ func (rl *RemoteList) Size(args SizeArgs, reply *SizeReply) error {
 
 	// Bloqueia esta lista para leitura
 	ml.mu.RLock()
-	reply.Size = len(ml.Data)
-	ml.mu.RUnlock()
+	defer ml.mu.RUnlock()
+	if ml.expired(time.Now()) {
+		return nil
+	}
+	if ml.kind != KindList && !ml.deleted {
+		return errWrongType(args.ListID, ml.kind, KindList)
+	}
+	reply.Size = ml.len() // Uma lista apagada fica vazia (ver DeleteList)
+	return nil
+}
 
+// Lists retorna os nomes de todas as listas existentes, em ordem alfabética
+// (só as listas; as outras chaves aparecem em Keys).
+func (rl *RemoteList) Lists(args ListsArgs, reply *ListsReply) error {
+	if forwarded, err := rl.routeRead(args.Read, "Lists", args, reply); forwarded {
+		return err
+	}
+	now := time.Now()
+	rl.mapMu.RLock()
+	ids := make([]string, 0, len(rl.lists))
+	for id, ml := range rl.lists {
+		ml.mu.RLock()
+		list := ml.kind == KindList && !ml.expired(now)
+		ml.mu.RUnlock()
+		if list {
+			ids = append(ids, id)
+		}
+	}
+	rl.mapMu.RUnlock()
+
+	sort.Strings(ids)
+	reply.ListIDs = ids
 	return nil
 }
 
@@ -210,8 +470,26 @@ func (rl *RemoteList) getList(listID string) (*ManagedList, bool) {
 	return ml, exists
 }
 
-// getOrCreateList obtém uma lista ou a cria se não existir (escrita no map)
-func (rl *RemoteList) getOrCreateList(listID string) *ManagedList {
+// lockAllKeys bloqueia para escrita todas as chaves, em ordem de nome (a mesma
+// das transações, ver lockTxLists), e as devolve com os nomes na mesma ordem.
+// Deve ser chamado com rl.mapMu bloqueado.
+func (rl *RemoteList) lockAllKeys() ([]string, []*ManagedList) {
+	ids := make([]string, 0, len(rl.lists))
+	for id := range rl.lists {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	locked := make([]*ManagedList, len(ids))
+	for i, id := range ids {
+		locked[i] = rl.lists[id]
+		locked[i].mu.Lock()
+	}
+	return ids, locked
+}
+
+// getOrCreateKey obtém uma chave ou a cria, do tipo kind, se não existir
+// (escrita no map)
+func (rl *RemoteList) getOrCreateKey(listID string, kind Kind) *ManagedList {
 	// Primeiro, tenta com um Read Lock (otimista)
 	rl.mapMu.RLock()
 	ml, exists := rl.lists[listID]
@@ -228,47 +506,147 @@ func (rl *RemoteList) getOrCreateList(listID string) *ManagedList {
 	// enquanto esperávamos pelo Write Lock.
 	ml, exists = rl.lists[listID]
 	if !exists {
-		ml = &ManagedList{
-			Data: make([]int, 0),
-		}
+		ml = newKey(kind)
 		rl.lists[listID] = ml
-		log.Printf("Lista '%s' criada dinamicamente.", listID)
+		log.Printf("Chave '%s' (%s) criada dinamicamente.", listID, kind)
 	}
 	return ml
 }
 
+// lockOrCreateKey obtém (ou cria) a chave e a bloqueia para escrita. Uma
+// chave com o TTL vencido é apagada antes (ver reap) e dá lugar a uma nova; uma
+// chave viva de outro tipo é recusada (WRONGTYPE).
+func (rl *RemoteList) lockOrCreateKey(listID string, kind Kind) (*ManagedList, error) {
+	for {
+		ml := rl.lockLiveKey(listID, kind)
+		if !ml.expired(time.Now()) {
+			if ml.kind != kind {
+				ml.mu.Unlock()
+				return nil, errWrongType(listID, ml.kind, kind)
+			}
+			return ml, nil
+		}
+		ml.mu.Unlock()
+		if err := rl.reap(listID); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// lockLiveKey obtém (ou cria) a chave e a bloqueia para escrita. Se ela foi
+// apagada entre a busca e o lock, busca de novo (e cria uma nova).
+func (rl *RemoteList) lockLiveKey(listID string, kind Kind) *ManagedList {
+	for {
+		ml := rl.getOrCreateKey(listID, kind)
+		ml.mu.Lock()
+		if !ml.deleted {
+			return ml
+		}
+		ml.mu.Unlock()
+	}
+}
+
 // --- Lógica de Persistência (Log e Snapshot) ---
 
-// logOperation escreve uma operação no arquivo de log.
+// logOperation escreve uma operação no arquivo de log e devolve o número do registro.
 // DEVE ser chamado com a lista (ml.mu) já bloqueada, se aplicável.
-func (rl *RemoteList) logOperation(op string, listID string, value *int) error {
+func (rl *RemoteList) logOperation(op string, listID string, value *int) (uint64, error) {
+	rec := LogRecord{Op: op, ListID: listID}
+	if op == "APPEND" {
+		if value == nil {
+			return 0, errors.New("operação de log inválida")
+		}
+		rec.Value = *value
+	}
+	return rl.logRecord(rec)
+}
+
+// logRecord grava rec no log (preenchendo Seq e Time), o sincroniza e devolve
+// o número dele. Com SyncReplication, também espera um backup confirmar o
+// registro. Mesmas regras de logOperation.
+func (rl *RemoteList) logRecord(rec LogRecord) (uint64, error) {
+	rec, err := rl.logStamped(rec)
+	return rec.Seq, err
+}
+
+// logStamped é logRecord devolvendo o registro com o número e o instante com
+// que foi gravado, para quem aplica o instante ao estado (ver streams.go).
+func (rl *RemoteList) logStamped(rec LogRecord) (LogRecord, error) {
+	rec, repl, err := rl.writeRecord(rec)
+	if err != nil {
+		return LogRecord{}, err
+	}
+	if repl != nil && rl.cfg.SyncReplication {
+		repl.waitAcked(rec.Seq)
+	}
+	return rec, nil
+}
+
+// writeRecord é a parte de logRecord feita com rl.logLock. Devolve o registro
+// gravado e o replicador que o recebeu.
+func (rl *RemoteList) writeRecord(rec LogRecord) (LogRecord, *replicator, error) {
 	rl.logLock.Lock()
 	defer rl.logLock.Unlock()
 
-	var line string
-	if op == "APPEND" && value != nil {
-		line = fmt.Sprintf("%s %s %d\n", op, listID, *value)
-	} else if op == "REMOVE" {
-		line = fmt.Sprintf("%s %s\n", op, listID)
-	} else {
-		return errors.New("operação de log inválida")
+	// Nenhum registro local entra no WAL de um backup: lá os números de
+	// sequência são os do primário.
+	if rl.readOnly.Load() {
+		return LogRecord{}, nil, errBackupReadOnly
 	}
 
-	_, err := rl.logFile.WriteString(line)
+	// Uma escrita anterior falhou: o registro dela precisa ser anulado antes.
+	if rl.failedSeq != 0 {
+		if err := rl.repairLog(); err != nil {
+			return LogRecord{}, nil, fmt.Errorf("log indisponível após falha anterior: %w", err)
+		}
+	}
+
+	rec.Seq, rec.Time = rl.seq+1, time.Now()
+	line, err := rec.encode()
 	if err != nil {
-		return err
+		return LogRecord{}, nil, err
+	}
+
+	// O número é consumido mesmo se a escrita falhar: a linha pode ter
+	// chegado ao disco, e números repetidos confundiriam a recuperação no tempo.
+	rl.seq = rec.Seq
+
+	_, err = io.WriteString(rl.logFile, line)
+	if err == nil {
+		// sync: Garante que os dados sejam escritos no disco.
+		// É custoso, mas necessário para persistência real.
+		err = rl.logFile.Sync()
+	}
+	if err != nil {
+		// A linha pode estar no disco (inteira ou não) sem que a memória mude;
+		// repairLog a anula antes da próxima escrita.
+		rl.failedSeq = rec.Seq
+		return LogRecord{}, nil, err
+	}
+	rl.logged(rec)
+	return rec, rl.repl, nil
+}
+
+// logged registra que rec está no disco: atualiza lastTime e o entrega ao
+// replicador. Deve ser chamado com rl.logLock, na ordem dos registros.
+func (rl *RemoteList) logged(rec LogRecord) {
+	rl.lastTime = rec.Time
+	if rl.repl != nil {
+		rl.repl.add(rec)
 	}
-	// sync: Garante que os dados sejam escritos no disco.
-	// É custoso, mas necessário para persistência real.
-	return rl.logFile.Sync()
 }
 
 // snapshotScheduler executa createSnapshot em intervalos definidos.
 func (rl *RemoteList) snapshotScheduler() {
-	ticker := time.NewTicker(snapshotInterval)
+	ticker := time.NewTicker(rl.cfg.SnapshotInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-rl.done:
+			return
+		case <-ticker.C:
+		}
 		log.Println("Iniciando rotina de snapshot...")
 		if err := rl.createSnapshot(); err != nil {
 			log.Printf("Erro ao criar snapshot: %v", err)
@@ -279,218 +657,330 @@ func (rl *RemoteList) snapshotScheduler() {
 }
 
 // createSnapshot salva o estado atual de todas as listas em um arquivo
-// e limpa o arquivo de log de forma atômica em relação aos dados copiados.
+// e limpa o arquivo de log de forma atômica em relação aos dados capturados.
+//
+// Os escritores só ficam parados enquanto cada lista é fotografada (captureView),
+// o que custa O(1) por lista; a cópia e a serialização dos dados acontecem
+// depois, sem locks, graças ao copy-on-write da ManagedList.
 func (rl *RemoteList) createSnapshot() error {
+	rl.snapshotMu.Lock()
+	defer rl.snapshotMu.Unlock()
+
 	// --- FASE 1: Preparação (Coletar referências) ---
 
+	// O read lock do map fica com o snapshot até o log ser limpo: assim nenhuma
+	// lista nova pode ser criada (e logada) sem entrar na fotografia.
 	rl.mapMu.RLock()
-	listIDs := make([]string, 0, len(rl.lists))
-	listsToLock := make([]*ManagedList, 0, len(rl.lists))
-	for id, ml := range rl.lists {
-		listIDs = append(listIDs, id)
-		listsToLock = append(listsToLock, ml)
-	}
-	rl.mapMu.RUnlock()
 
-	// --- FASE 2: Região Crítica (Cópia e Limpeza do Log) ---
+	// --- FASE 2: Região Crítica (Captura e Rotação do Log) ---
 
-	rl.logLock.Lock()
-	defer rl.logLock.Unlock()
+	// Ordem dos locks: listas antes do log, a mesma dos métodos RPC
+	// (que chamam logOperation com a lista já bloqueada).
+	listIDs, listsToLock := rl.lockAllKeys()
 
-	for _, ml := range listsToLock {
-		ml.mu.RLock()
-	}
+	rl.logLock.Lock()
+	pauseStart := time.Now()
 
 	unlockLists := func() {
+		rl.logLock.Unlock()
 		for _, ml := range listsToLock {
-			ml.mu.RUnlock()
+			ml.mu.Unlock()
 		}
+		rl.mapMu.RUnlock()
+		log.Printf("Snapshot: escritores bloqueados por %v (%d listas).", time.Since(pauseStart), len(listsToLock))
 	}
 
-	snapshotData := make(map[string][]int)
+	views := make([]listView, len(listsToLock))
 	for i, ml := range listsToLock {
-		id := listIDs[i]
-		dataCopy := make([]int, len(ml.Data))
-		copy(dataCopy, ml.Data)
-		snapshotData[id] = dataCopy
+		views[i] = ml.captureView()
+	}
+	expires := captureExpires(listIDs, listsToLock)
+	delayed := captureDelayed(listIDs, listsToLock)
+	structs := captureStructures(listIDs, listsToLock)
+	lastSeq := rl.seq
+	createdAt := time.Now()
+	rl.txMu.Lock()
+	prepared, decided := rl.preparedList(), rl.decided.list()
+	rl.txMu.Unlock()
+
+	// Um registro com escrita falha ficaria no log sem estar nas visões: o ABORT
+	// dele precisa estar gravado caso este snapshot não chegue ao disco.
+	var err error
+	if rl.failedSeq != 0 {
+		err = rl.repairLog()
+		lastSeq = rl.seq
+	}
+
+	// Sela o segmento atual: tudo que foi logado até aqui está nas visões.
+	// Nada é truncado; os segmentos antigos só somem depois que o snapshot
+	// estiver gravado (FASE 4).
+	var nextSegment uint64
+	if err == nil {
+		nextSegment, err = rl.rotateSegment()
 	}
-
-	// --- CORREÇÃO PARA WINDOWS AQUI ---
-	
-	// 1. Fecha o arquivo atual (pois o Windows bloqueia Truncate em arquivo O_APPEND)
-	rl.logFile.Close()
-
-	// 2. Trunca (zera) o arquivo diretamente pelo caminho no disco
-	if err := os.Truncate(logFile, 0); err != nil {
-		unlockLists()
-		// Tenta reabrir para não quebrar o servidor totalmente
-		f, _ := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
-		rl.logFile = f
-		return fmt.Errorf("falha ao truncar log: %w", err)
-	}
-
-	// 3. Reabre o arquivo limpo para as próximas operações
-	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	unlockLists()
 	if err != nil {
-		unlockLists()
-		return fmt.Errorf("falha ao reabrir log após truncate: %w", err)
+		return fmt.Errorf("falha ao rotacionar log: %w", err)
 	}
-	rl.logFile = f
-
-	// --- FIM DA CORREÇÃO ---
-
-	unlockLists()
 
 	// --- FASE 3: Persistência (IO Pesado - Sem Locks de Lista) ---
 
-	tempFile := snapshotFile + ".tmp"
-	file, err := os.Create(tempFile)
-	if err != nil {
-		return fmt.Errorf("falha ao criar arquivo temp de snapshot: %w", err)
+	// As visões são imutáveis: os escritores já voltaram a trabalhar
+	// e copiam os blocos que forem alterar.
+	snapshotData := snapshotState{
+		NextSegment: nextSegment,
+		LastSeq:     lastSeq,
+		CreatedAt:   createdAt,
+		Prepared:    prepared,
+		Decided:     decided,
+		Expires:     expires,
+		Delayed:     delayed,
+		views:       namedViews(listIDs, listsToLock, views),
+		structs:     structs,
+	}
+
+	if err := writeSnapshotFile(rl.fs, rl.path(snapshotFile), snapshotData, rl.cfg.SnapshotCompression); err != nil {
+		return err
 	}
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(snapshotData); err != nil {
-		file.Close()
-		os.Remove(tempFile)
-		return fmt.Errorf("falha ao serializar (json) snapshot: %w", err)
-	}
+	// --- FASE 4: Limpeza dos segmentos cobertos pelo snapshot ---
 
-	if err := file.Close(); err != nil {
-		return fmt.Errorf("falha ao fechar arquivo temp: %w", err)
+	if rl.cfg.ArchiveDir != "" {
+		if err := rl.archiveSnapshot(lastSeq); err != nil {
+			return err
+		}
 	}
 
-	if err := os.Rename(tempFile, snapshotFile); err != nil {
-		return fmt.Errorf("falha ao renomear snapshot final: %w", err)
-	}
+	// Se o servidor cair antes disso, o snapshot novo já diz a partir de qual
+	// segmento o replay começa, então os segmentos velhos são apenas ignorados.
+	return rl.retireSegments(nextSegment)
+}
 
-	return nil
+// snapshotState é o conteúdo do remotelist.json (o formato em disco está em snapshot.go).
+// NextSegment é o primeiro segmento do log que NÃO está coberto pelo snapshot;
+// LastSeq é o último registro do log refletido nele, e CreatedAt o instante da captura.
+// Prepared são as transações preparadas e não decididas até LastSeq: os
+// registros PREPARE delas podem estar nos segmentos apagados. Decided são as
+// últimas decisões (ver txDecisions).
+// Expires são os prazos dos TTLs das listas que têm um (ver ttl.go) e Delayed
+// os itens agendados de cada lista (ver delayed.go).
+// As tags JSON são as do formato 1, um único objeto, que ainda é lido.
+type snapshotState struct {
+	NextSegment uint64                   `json:"next_segment"`
+	LastSeq     uint64                   `json:"last_seq"`
+	CreatedAt   time.Time                `json:"created_at"`
+	Lists       map[string][]int         `json:"lists"`
+	Prepared    []PreparedTx             `json:"prepared,omitempty"`
+	Decided     []TxDecision             `json:"decided,omitempty"`
+	Expires     map[string]time.Time     `json:"expires,omitempty"`
+	Delayed     map[string][]DelayedItem `json:"delayed,omitempty"`
+	Structures                           // Sets, maps e contadores ("sets", "maps" e "counters")
+
+	// Só na leitura: versão do formato e, na versão 2, compressão e checksum do arquivo.
+	Version     int    `json:"-"`
+	Compression string `json:"-"`
+	Checksum    string `json:"-"`
+
+	views   []namedView  // Na gravação por createSnapshot, substitui Lists
+	structs []structView // Idem, para Structures (copiadas uma chave por vez)
 }
 
 // loadFromDisk restaura o estado do serviço a partir dos arquivos.
-// Primeiro carrega o snapshot, abre log, depois aplica operações do log.
+// Primeiro carrega o snapshot, depois aplica os segmentos de log posteriores a ele
+// e abre um segmento novo para as próximas escritas.
 func (rl *RemoteList) loadFromDisk() error {
 	// 1. Carregar o Snapshot (se existir)
-	if err := rl.loadSnapshot(); err != nil {
+	nextSegment, err := rl.loadSnapshot()
+	if unreadableData(err) {
+		return fmt.Errorf("falha ao ler snapshot: %w", err)
+	} else if err != nil {
 		log.Printf("Nenhum snapshot encontrado ou erro ao ler: %v", err)
 		// Continua mesmo assim, podemos ter apenas logs.
 	} else {
 		log.Println("Snapshot carregado com sucesso.")
 	}
 
-	// 2. Abrir/Criar o arquivo de Log
-	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
-	if err != nil {
-		return fmt.Errorf("falha ao abrir arquivo de log para replay: %w", err)
+	// 2. Aplicar os segmentos do Log (replay), do mais antigo ao mais novo
+	if err := rl.migrateLegacyLog(); err != nil {
+		return fmt.Errorf("falha ao migrar log antigo: %w", err)
+	}
+	if err := rl.replaySegments(nextSegment); err != nil {
+		return err
 	}
-	rl.logFile = f
-	// O 'defer f.Close()' não pode ser usado aqui, pois rl.logFile
-	// precisa permanecer aberto para operações futuras.
 
-	// 3. Aplicar o Log (replay)
-	if err := rl.replayLog(); err != nil {
-		return fmt.Errorf("falha ao aplicar replay do log: %w", err)
+	// 3. Abrir um segmento novo: um registro incompleto deixado por uma queda
+	// no último segmento nunca é emendado com registros novos. Cifrado, o
+	// último segmento antes ganha o bloco final que a queda não deixou gravar.
+	if err := rl.resealSegment(rl.segment); err != nil {
+		return fmt.Errorf("falha ao fechar o segmento %d do log: %w", rl.segment, err)
+	}
+	if err := rl.openNextSegment(); err != nil {
+		return err
 	}
 
 	log.Println("Logs aplicados (replay) com sucesso.")
 	return nil
 }
 
-// loadSnapshot carrega o map[string][]int do snapshot
-func (rl *RemoteList) loadSnapshot() error {
-	file, err := os.Open(snapshotFile)
+// unreadableData diz se err vem de arquivos que existem mas não podem ser lidos
+// com segurança (cifrados com outra chave, adulterados ou corrompidos).
+func unreadableData(err error) bool {
+	return errors.Is(err, ErrDecrypt) || errors.Is(err, ErrSnapshotCorrupt)
+}
+
+// replaySegments aplica, em ordem, os segmentos a partir de nextSegment
+// (os anteriores estão cobertos pelo snapshot).
+func (rl *RemoteList) replaySegments(nextSegment uint64) error {
+	segments, err := listSegments(rl.fs, rl.cfg.Dir)
+	if err != nil {
+		return fmt.Errorf("falha ao listar segmentos do log: %w", err)
+	}
+	var paths []string
+	for _, n := range segments {
+		if n >= nextSegment {
+			paths = append(paths, rl.path(segmentName(n)))
+		}
+	}
+	rl.aborted, err = collectAborted(rl.fs, paths)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return errors.New("snapshot não encontrado")
+		return fmt.Errorf("falha ao aplicar replay do log: %w", err)
+	}
+	for _, n := range segments {
+		if n < nextSegment {
+			// Coberto pelo snapshot (o servidor caiu antes de apagá-lo).
+			continue
+		}
+		if err := rl.replaySegment(n, n == segments[len(segments)-1]); err != nil {
+			return fmt.Errorf("falha ao aplicar replay do log: %w", err)
 		}
+		rl.segment = n
+	}
+	if rl.segment < nextSegment {
+		rl.segment = nextSegment
+	}
+	return nil
+}
+
+// openNextSegment abre o segmento seguinte ao último conhecido para escrita.
+func (rl *RemoteList) openNextSegment() error {
+	segments, err := listSegments(rl.fs, rl.cfg.Dir)
+	if err != nil {
 		return err
 	}
+	next := rl.segment + 1
+	if len(segments) > 0 && segments[len(segments)-1] >= next {
+		next = segments[len(segments)-1] + 1
+	}
+	if err := rl.openSegment(next); err != nil {
+		return err
+	}
+	return syncDir(rl.fs, rl.cfg.Dir)
+}
+
+// loadSnapshot carrega as listas do snapshot e retorna o primeiro segmento
+// do log que ainda precisa ser aplicado.
+func (rl *RemoteList) loadSnapshot() (uint64, error) {
+	file, err := openRead(rl.fs, rl.path(snapshotFile))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, errors.New("snapshot não encontrado")
+		}
+		return 0, err
+	}
 	defer file.Close()
 
-	var snapshotData map[string][]int
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&snapshotData); err != nil {
-		return fmt.Errorf("falha ao decodificar (json) snapshot: %w", err)
+	// As listas vão direto para ManagedLists, bloco a bloco; só entram no
+	// serviço se o snapshot inteiro (e o checksum) estiver certo.
+	lists := make(map[string]*ManagedList)
+	snapshotData, err := readSnapshot(file, func(id string, chunk []int) {
+		ml := lists[id]
+		if ml == nil {
+			ml = newManagedList(nil)
+			lists[id] = ml
+		}
+		for _, v := range chunk {
+			ml.push(v)
+		}
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	// Converte os dados carregados para a estrutura de memória (ManagedList)
 	// Não precisamos de locks aqui, pois o servidor está iniciando.
 	rl.mapMu.Lock()
-	for id, data := range snapshotData {
-		rl.lists[id] = &ManagedList{
-			Data: data,
-		}
+	for id, ml := range lists {
+		rl.lists[id] = ml
 	}
+	restoreStructures(rl.lists, snapshotData.Structures)
+	restoreExpires(rl.lists, snapshotData.Expires)
+	restoreDelayed(rl.lists, snapshotData.Delayed)
+	rl.restorePrepared(rl.lists, snapshotData.Prepared, snapshotData.Decided)
 	rl.mapMu.Unlock()
-	return nil
+	rl.seq = snapshotData.LastSeq
+	return snapshotData.NextSegment, nil
 }
 
-// replayLog lê o arquivo de log (rl.logFile) do início
-// e aplica as operações em memória.
-func (rl *RemoteList) replayLog() error {
-	// Volta ao início do arquivo para leitura
-	if _, err := rl.logFile.Seek(0, 0); err != nil {
-		return fmt.Errorf("falha ao buscar início do log: %w", err)
+// replaySegment aplica em memória as operações do segmento n do log (last: o
+// segmento mais novo, ver openTail).
+func (rl *RemoteList) replaySegment(n uint64, last bool) error {
+	open := openRead
+	if last {
+		open = openTail
+	}
+	f, err := open(rl.fs, rl.path(segmentName(n)))
+	if err != nil {
+		return err
 	}
+	defer f.Close()
+	return rl.replayLog(f, n)
+}
+
+// LogProblem descreve uma linha do log que não pôde ser aplicada no replay.
+type LogProblem struct {
+	Segment uint64
+	Line    int
+	Text    string
+	Err     error
+}
 
-	scanner := bufio.NewScanner(rl.logFile)
+// replayLog lê um arquivo de log (o segmento 'segment') do início
+// e aplica as operações em memória. Linhas que não puderem ser aplicadas são
+// puladas e guardadas em rl.replayProblems.
+func (rl *RemoteList) replayLog(r io.Reader, segment uint64) error {
 	logCount := 0
-	for scanner.Scan() {
-		line := scanner.Text()
-		parts := strings.Fields(line) // strings.Fields lida com espaços
-		if len(parts) < 2 {
-			log.Printf("Linha de log mal formatada, pulando: %s", line)
-			continue
+	err := scanLog(r, func(lineNo int, line string, rec LogRecord, err error) error {
+		if err != nil {
+			log.Printf("Linha de log inválida (%v), pulando: %s", err, line)
+			rl.replayProblems = append(rl.replayProblems, LogProblem{Segment: segment, Line: lineNo, Text: line, Err: err})
+			return nil
 		}
-
-		op := parts[0]
-		listID := parts[1]
-
-		// Pega ou cria a lista (sem locks, estamos no init)
-		ml, exists := rl.lists[listID]
-		if !exists {
-			ml = &ManagedList{Data: make([]int, 0)}
-			rl.lists[listID] = ml
+		// Um backup pode ter o mesmo registro duas vezes (lote reenviado pelo
+		// primário depois de uma falha de escrita): vale o primeiro.
+		if rec.Seq != 0 && rec.Seq <= rl.seq {
+			return nil
 		}
-
-		switch op {
-		case "APPEND":
-			if len(parts) < 3 {
-				log.Printf("Log APPEND mal formatado, pulando: %s", line)
-				continue
-			}
-			val, err := strconv.Atoi(parts[2])
-			if err != nil {
-				log.Printf("Log APPEND com valor inválido, pulando: %s", line)
-				continue
-			}
-			// Aplica a operação diretamente
-			ml.Data = append(ml.Data, val)
-			logCount++
-
-		case "REMOVE":
-			if len(ml.Data) > 0 {
-				ml.Data = ml.Data[:len(ml.Data)-1]
-				logCount++
-			} else {
-				log.Printf("Log REMOVE em lista vazia ('%s'), ignorando.", listID)
-			}
-
-		default:
-			log.Printf("Operação de log desconhecida, pulando: %s", line)
+		if rec.Seq != 0 && rl.aborted[rec.Seq] {
+			log.Printf("Registro %d anulado por ABORT, pulando.", rec.Seq)
+			rl.seq, rl.lastTime = rec.Seq, rec.Time
+			return nil
 		}
+		// Aplica a operação diretamente (sem locks, estamos no init)
+		if err := applyRecord(rl.lists, rec); err != nil {
+			log.Printf("Log ignorado: %v", err)
+			rl.replayProblems = append(rl.replayProblems, LogProblem{Segment: segment, Line: lineNo, Text: line, Err: err})
+			return nil
+		}
+		rl.trackTxn(rl.lists, rec)
+		if rec.Seq > rl.seq {
+			rl.seq, rl.lastTime = rec.Seq, rec.Time
+		}
+		logCount++
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("erro ao escanear arquivo de log: %w", err)
-	}
-
-	// Volta ao final do arquivo para futuras escritas (Append)
-	if _, err := rl.logFile.Seek(0, 2); err != nil {
-		return fmt.Errorf("falha ao buscar fim do log: %w", err)
-	}
-	
 	log.Printf("%d operações de log aplicadas.", logCount)
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,666 @@
+package remotelist
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/rpc"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Replicação primário-backup ---
+//
+// O primário envia aos backups cada registro que chega ao seu WAL (depois do
+// fsync), na ordem do número de sequência. O backup grava os registros no próprio
+// WAL e os aplica com applyRecord, o mesmo caminho do replay: o log dele é uma
+// cópia do log do primário, com os mesmos números.
+//
+// Quando o primário não consegue provar que o histórico do backup é um prefixo
+// do seu (backup novo, primário reiniciado, backup atrasado demais ou que seguiu
+// outro primário), ele envia o estado inteiro (InstallState) e continua dali.
+//
+// O backup recusa escritas de clientes e atende leituras, com o nível de
+// consistência pedido (ver consistency.go). Promote o transforma em primário; o antigo primário precisa ser
+// parado antes (não há eleição nem proteção contra dois primários).
+
+const (
+	// Registros mantidos em memória para alcançar backups atrasados.
+	defaultReplicationBuffer = 100000
+	// Tempo que uma escrita espera a confirmação de um backup (SyncReplication).
+	defaultReplicationTimeout = 5 * time.Second
+	// Quantos registros vão em cada chamada ApplyLog.
+	replicationBatch = 512
+)
+
+var (
+	errBackupReadOnly = errors.New("servidor em modo backup: escritas só no primário")
+	errNotBackup      = errors.New("este servidor não é um backup")
+	errBackupBehind   = errors.New("backup atrasado além do buffer de replicação")
+)
+
+type ApplyLogArgs struct {
+	PrevSeq    uint64      // Último registro que o primário sabe que o backup tem
+	Records    []LogRecord // Registros seguintes, em ordem (vazio = só sinal de vida)
+	PrimarySeq uint64      // Último registro do primário, para o backup medir o atraso
+}
+type ApplyLogReply struct {
+	LastSeq uint64
+}
+
+type InstallStateArgs struct {
+	Lists      map[string][]int
+	Prepared   []PreparedTx             // Transações preparadas e não decididas
+	Decided    []TxDecision             // Últimas transações decididas
+	Expires    map[string]time.Time     // Prazos dos TTLs (ver ttl.go)
+	Delayed    map[string][]DelayedItem // Itens agendados (ver delayed.go)
+	Structures                          // Sets, maps e contadores (ver structures.go)
+	LastSeq    uint64
+	LastTime   time.Time
+}
+type InstallStateReply struct{}
+
+type PromoteArgs struct {
+	Backups []string // Backups que passam a receber o WAL do novo primário
+}
+type PromoteReply struct {
+	LastSeq uint64
+}
+
+type ReplicationStatusArgs struct{}
+type ReplicationStatusReply struct {
+	Backup   bool      // true se o servidor é um backup
+	LastSeq  uint64    // Último registro do WAL local
+	LastTime time.Time // Instante desse registro (zero se desconhecido)
+	Backups  []BackupStatus
+	Lag      ReplicaLag // Só no backup: atraso em relação ao primário
+}
+
+// BackupStatus é o que o primário sabe de um backup.
+type BackupStatus struct {
+	Addr      string
+	Connected bool
+	AckedSeq  uint64 // Último registro confirmado (gravado no disco do backup)
+	Lag       uint64 // Registros que o backup ainda não confirmou
+}
+
+// --- Lado do backup ---
+
+// checkWritable recusa escritas de clientes em um backup.
+func (rl *RemoteList) checkWritable() error {
+	if rl.readOnly.Load() {
+		return errBackupReadOnly
+	}
+	return nil
+}
+
+// ReplicationStatus informa o papel do servidor e a posição do seu WAL.
+// No primário também informa o estado de cada backup.
+func (rl *RemoteList) ReplicationStatus(args ReplicationStatusArgs, reply *ReplicationStatusReply) error {
+	rl.logLock.Lock()
+	reply.LastSeq, reply.LastTime = rl.seq, rl.lastTime
+	rl.logLock.Unlock()
+	reply.Backup = rl.readOnly.Load()
+
+	if reply.Backup {
+		reply.Lag = rl.replicaLag()
+	}
+
+	rl.replMu.Lock()
+	r := rl.repl
+	rl.replMu.Unlock()
+	if r != nil {
+		reply.Backups = r.status(reply.LastSeq)
+	}
+	return nil
+}
+
+// applyLog grava no WAL e aplica em memória os registros enviados pelo primário.
+// Só responde depois do fsync: a resposta é a confirmação usada pelo primário.
+func (rl *RemoteList) applyLog(args ApplyLogArgs, reply *ApplyLogReply) error {
+	rl.replMu.Lock()
+	defer rl.replMu.Unlock()
+	if !rl.readOnly.Load() {
+		return errNotBackup
+	}
+	if rl.needSnapshot {
+		return errors.New("estado instalado ainda não foi salvo; reenvie o estado")
+	}
+
+	// Um lote vazio só informa a posição do primário: não precisa parar os leitores.
+	if len(args.Records) == 0 {
+		rl.logLock.Lock()
+		reply.LastSeq = rl.seq
+		rl.logLock.Unlock()
+		if reply.LastSeq != args.PrevSeq {
+			return fmt.Errorf("registros fora de sequência: backup está em %d, lote começa depois de %d", reply.LastSeq, args.PrevSeq)
+		}
+		rl.noteProgress(args.PrimarySeq, reply.LastSeq)
+		return nil
+	}
+
+	// O lock exclusivo do map segura leitores e o snapshot só durante o lote.
+	rl.mapMu.Lock()
+	defer rl.mapMu.Unlock()
+
+	rl.logLock.Lock()
+	reply.LastSeq = rl.seq
+	if rl.seq != args.PrevSeq {
+		rl.logLock.Unlock()
+		return fmt.Errorf("registros fora de sequência: backup está em %d, lote começa depois de %d", reply.LastSeq, args.PrevSeq)
+	}
+	if err := rl.writeReplicated(args.PrevSeq, args.Records); err != nil {
+		rl.logLock.Unlock()
+		// O backup continua sabendo onde o primário está: o atraso dele cresce.
+		rl.noteProgress(args.PrimarySeq, reply.LastSeq)
+		log.Printf("Erro crítico de persistência (ApplyLog): %v", err)
+		return fmt.Errorf("falha ao gravar no disco (WAL): %w", err)
+	}
+	last := args.Records[len(args.Records)-1]
+	rl.seq, rl.lastTime = last.Seq, last.Time
+	rl.logLock.Unlock()
+
+	for _, rec := range args.Records {
+		rl.applyReplicated(rec)
+	}
+	reply.LastSeq = last.Seq
+	rl.noteProgress(args.PrimarySeq, last.Seq)
+	return nil
+}
+
+// writeReplicated grava e sincroniza um lote vindo do primário.
+// Deve ser chamado com rl.logLock.
+func (rl *RemoteList) writeReplicated(prev uint64, records []LogRecord) error {
+	// Uma escrita anterior falhou: o segmento pode terminar com uma linha pela
+	// metade, que não pode ser emendada com as próximas.
+	if rl.abandonSegment {
+		if _, err := rl.rotateSegment(); err != nil {
+			return err
+		}
+		rl.abandonSegment = false
+	}
+
+	var b strings.Builder
+	for _, rec := range records {
+		if rec.Seq <= prev {
+			return fmt.Errorf("registro %d fora de ordem", rec.Seq)
+		}
+		prev = rec.Seq
+		line, err := rec.encode()
+		if err != nil {
+			return err
+		}
+		b.WriteString(line)
+	}
+
+	_, err := io.WriteString(rl.logFile, b.String())
+	if err == nil {
+		err = rl.logFile.Sync()
+	}
+	if err != nil {
+		// Não há ABORT aqui: o número do próximo registro é do primário. As linhas
+		// que tenham chegado ao disco são registros confirmados por ele, e o
+		// reenvio (mesmos números) é pulado no replay como repetição.
+		rl.abandonSegment = true
+		return err
+	}
+	return nil
+}
+
+// applyReplicated aplica um registro já gravado. Deve ser chamado com o
+// lock exclusivo de rl.mapMu, sem o rl.logLock.
+func (rl *RemoteList) applyReplicated(rec LogRecord) {
+	ml := rl.lists[rec.ListID]
+	if ml != nil && rec.TxID == "" {
+		ml.mu.Lock()
+		defer ml.mu.Unlock()
+	}
+	// Os registros de transação tocam várias listas, que leitores podem estar usando.
+	touched := rl.txLists(rec)
+	for _, id := range touched {
+		if other := rl.lists[id]; other != nil {
+			other.mu.Lock()
+			defer other.mu.Unlock()
+		}
+	}
+	if rec.TxID == "" {
+		touched = []string{rec.ListID}
+	}
+	before := rl.lengths(touched)
+	if err := applyRecord(rl.lists, rec); err != nil {
+		log.Printf("Registro %d do primário ignorado: %v", rec.Seq, err)
+		return
+	}
+	rl.elements.Add(int64(rl.lengths(touched) - before))
+	rl.trackTxn(rl.lists, rec)
+	// applyRecord troca a lista no map; quem já tinha o ponteiro antigo precisa
+	// ver o mesmo conteúdo (REPLACE) ou saber que ela deixou de existir (DELETE).
+	switch {
+	case ml == nil:
+	case rec.Op == "REPLACE":
+		ml.replace(rec.Values)
+		ml.expires = time.Time{}
+	case rec.Op == "DELETE":
+		ml.replace(nil)
+		ml.deleted = true
+	}
+}
+
+// lengths soma o tamanho das chaves ids que estão no map. Usado com as listas
+// bloqueadas, para manter o total de elementos (ver limits.go).
+func (rl *RemoteList) lengths(ids []string) int {
+	n := 0
+	for _, id := range ids {
+		if ml := rl.lists[id]; ml != nil {
+			n += ml.size()
+		}
+	}
+	return n
+}
+
+// installState substitui todo o estado do backup pelo do primário e o salva
+// em um snapshot. Usado quando o histórico do backup não pode ser continuado.
+func (rl *RemoteList) installState(args InstallStateArgs, reply *InstallStateReply) error {
+	rl.replMu.Lock()
+	defer rl.replMu.Unlock()
+	if !rl.readOnly.Load() {
+		return errNotBackup
+	}
+
+	rl.mapMu.Lock()
+	for _, ml := range rl.lists {
+		ml.mu.Lock()
+		ml.replace(nil)
+		ml.deleted = true
+		ml.mu.Unlock()
+	}
+	rl.lists = make(map[string]*ManagedList, len(args.Lists))
+	for id, values := range args.Lists {
+		rl.lists[id] = newManagedList(values)
+	}
+	restoreStructures(rl.lists, args.Structures)
+	restoreExpires(rl.lists, args.Expires)
+	restoreDelayed(rl.lists, args.Delayed)
+	rl.restorePrepared(rl.lists, args.Prepared, args.Decided)
+	rl.countElements()
+	rl.logLock.Lock()
+	rl.seq, rl.lastTime = args.LastSeq, args.LastTime
+	rl.logLock.Unlock()
+	rl.mapMu.Unlock()
+
+	// Até o snapshot chegar ao disco, o WAL local descreve outro histórico:
+	// nenhum registro novo é aceito por cima dele.
+	rl.needSnapshot = true
+	if err := rl.createSnapshot(); err != nil {
+		return fmt.Errorf("falha ao salvar estado recebido: %w", err)
+	}
+	rl.needSnapshot = false
+	rl.noteProgress(args.LastSeq, args.LastSeq)
+	log.Printf("Estado do primário instalado: %d listas, registro %d.", len(args.Lists), args.LastSeq)
+	return nil
+}
+
+// Promote transforma o backup em primário. O antigo primário já deve estar
+// parado: os backups continuam aceitando registros de quem os enviar.
+func (rl *RemoteList) Promote(args PromoteArgs, reply *PromoteReply) error {
+	rl.replMu.Lock()
+	defer rl.replMu.Unlock()
+	if !rl.readOnly.Load() {
+		return errors.New("este servidor já é o primário")
+	}
+
+	// O snapshot cobre tudo que está em memória e aposenta os segmentos com
+	// lotes que falharam: os próximos números de sequência passam a ser deste
+	// servidor, e não podem coincidir com restos de registros do primário antigo.
+	if err := rl.createSnapshot(); err != nil {
+		return fmt.Errorf("falha ao promover: %w", err)
+	}
+	rl.needSnapshot = false
+
+	rl.logLock.Lock()
+	reply.LastSeq = rl.seq
+	rl.abandonSegment = false
+	if len(args.Backups) > 0 {
+		rl.repl = newReplicator(rl, args.Backups)
+	}
+	rl.readOnly.Store(false)
+	rl.logLock.Unlock()
+
+	if rl.repl != nil {
+		rl.repl.start()
+	}
+	rl.wakeScheduler() // Itens que venceram enquanto era backup
+	log.Printf("Servidor promovido a primário no registro %d.", reply.LastSeq)
+	return nil
+}
+
+// --- Lado do primário ---
+
+// replicator envia o WAL do primário aos backups.
+type replicator struct {
+	rl      *RemoteList
+	backups []string
+
+	mu      sync.Mutex
+	records []LogRecord // Registros recentes, em ordem
+	dropped uint64      // Registros até este número não estão mais em records
+	changed chan struct{}
+	acked   map[string]uint64
+	online  map[string]bool
+	ackCh   chan struct{} // Fechado (e trocado) a cada confirmação
+	slow    bool          // Última espera por confirmação estourou o tempo
+}
+
+// newReplicator cria o replicador. Deve ser chamado com rl.logLock.
+func newReplicator(rl *RemoteList, backups []string) *replicator {
+	return &replicator{
+		rl:      rl,
+		backups: backups,
+		dropped: rl.seq,
+		changed: make(chan struct{}),
+		acked:   make(map[string]uint64),
+		online:  make(map[string]bool),
+		ackCh:   make(chan struct{}),
+	}
+}
+
+func (r *replicator) start() {
+	for _, addr := range r.backups {
+		go r.ship(addr)
+	}
+}
+
+// add guarda um registro gravado para envio. Chamado com rl.logLock,
+// na ordem dos números de sequência.
+func (r *replicator) add(rec LogRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, rec)
+	limit := r.rl.cfg.ReplicationBuffer
+	if limit <= 0 {
+		limit = defaultReplicationBuffer
+	}
+	if len(r.records) > limit {
+		// Descarta a metade mais antiga de uma vez, para não copiar a cada registro.
+		cut := len(r.records) / 2
+		r.dropped = r.records[cut-1].Seq
+		r.records = append([]LogRecord(nil), r.records[cut:]...)
+	}
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// since devolve até replicationBatch registros posteriores a seq, esperando
+// até wait se ainda não houver nenhum (e então devolve um lote vazio), junto
+// com o número do último registro disponível. Devolve errBackupBehind se os
+// registros já saíram do buffer.
+func (r *replicator) since(seq uint64, wait time.Duration) ([]LogRecord, uint64, error) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		r.mu.Lock()
+		if seq < r.dropped {
+			r.mu.Unlock()
+			return nil, 0, errBackupBehind
+		}
+		head := r.dropped
+		if len(r.records) > 0 {
+			head = r.records[len(r.records)-1].Seq
+		}
+		i := 0
+		for i < len(r.records) && r.records[i].Seq <= seq {
+			i++
+		}
+		if i < len(r.records) {
+			end := min(i+replicationBatch, len(r.records))
+			batch := append([]LogRecord(nil), r.records[i:end]...)
+			r.mu.Unlock()
+			return batch, head, nil
+		}
+		changed := r.changed
+		r.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil, head, nil
+		case <-r.rl.done:
+			return nil, 0, errors.New("servidor encerrado")
+		}
+	}
+}
+
+// knows diz se o registro (seq, t) faz parte do histórico deste primário, ou
+// seja, se um backup nessa posição pode receber só os registros seguintes.
+func (r *replicator) knows(seq uint64, t time.Time) bool {
+	r.rl.logLock.Lock()
+	lastSeq, lastTime := r.rl.seq, r.rl.lastTime
+	r.rl.logLock.Unlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if seq == 0 {
+		return r.dropped == 0
+	}
+	if t.IsZero() || seq < r.dropped {
+		return false
+	}
+	if seq == lastSeq && t.Equal(lastTime) {
+		return true
+	}
+	for _, rec := range r.records {
+		if rec.Seq == seq {
+			return rec.Time.Equal(t)
+		}
+	}
+	return false
+}
+
+// ship mantém um backup atualizado, reconectando quando a conexão cai.
+func (r *replicator) ship(addr string) {
+	backoff := 100 * time.Millisecond
+	for {
+		start := time.Now()
+		err := r.session(addr)
+		r.setOnline(addr, false)
+		select {
+		case <-r.rl.done:
+			return
+		default:
+		}
+		log.Printf("Replicação para %s interrompida: %v", addr, err)
+		if time.Since(start) > 10*time.Second {
+			backoff = 100 * time.Millisecond
+		}
+		select {
+		case <-r.rl.done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 5*time.Second)
+	}
+}
+
+// session conecta no backup, acerta a posição dele e envia registros até
+// um erro (ou até o servidor ser encerrado).
+func (r *replicator) session(addr string) error {
+	client, err := DialPeer(addr, r.rl.cfg.PeerKey, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	// Close interrompe uma chamada em andamento (e a espera em since).
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-r.rl.done:
+			client.Close()
+		case <-stop:
+		}
+	}()
+
+	var st ReplicationStatusReply
+	if err := client.Call("RemoteList.ReplicationStatus", ReplicationStatusArgs{}, &st); err != nil {
+		return err
+	}
+	if !st.Backup {
+		return fmt.Errorf("%s não é um backup", addr)
+	}
+	next := st.LastSeq
+	if !r.knows(st.LastSeq, st.LastTime) {
+		if next, err = r.install(client, addr); err != nil {
+			return err
+		}
+	}
+	r.setAcked(addr, next)
+	r.setOnline(addr, true)
+	log.Printf("Replicação para %s ativa a partir do registro %d.", addr, next)
+
+	heartbeat := r.rl.cfg.HeartbeatInterval
+	if heartbeat <= 0 {
+		heartbeat = defaultHeartbeatInterval
+	}
+	for {
+		batch, head, err := r.since(next, heartbeat)
+		if err == errBackupBehind {
+			if next, err = r.install(client, addr); err != nil {
+				return err
+			}
+			r.setAcked(addr, next)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var reply ApplyLogReply
+		if err := client.Call("Peer.ApplyLog", ApplyLogArgs{PrevSeq: next, Records: batch, PrimarySeq: head}, &reply); err != nil {
+			return err
+		}
+		next = reply.LastSeq
+		r.setAcked(addr, next)
+	}
+}
+
+// install envia ao backup o estado completo e devolve o registro em que ele ficou.
+func (r *replicator) install(client *rpc.Client, addr string) (uint64, error) {
+	args, err := r.rl.captureState()
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("Enviando estado completo para %s (%d listas, registro %d).", addr, len(args.Lists), args.LastSeq)
+	if err := client.Call("Peer.InstallState", args, &InstallStateReply{}); err != nil {
+		return 0, err
+	}
+	return args.LastSeq, nil
+}
+
+func (r *replicator) setOnline(addr string, online bool) {
+	r.mu.Lock()
+	r.online[addr] = online
+	r.mu.Unlock()
+}
+
+func (r *replicator) setAcked(addr string, seq uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.acked[addr] = seq
+	close(r.ackCh)
+	r.ackCh = make(chan struct{})
+}
+
+// waitAcked espera algum backup confirmar o registro seq. Se nenhum confirmar
+// dentro de ReplicationTimeout, a escrita é confirmada só com o disco local
+// (o aviso sai uma vez, até os backups voltarem a acompanhar).
+func (r *replicator) waitAcked(seq uint64) {
+	timeout := r.rl.cfg.ReplicationTimeout
+	if timeout <= 0 {
+		timeout = defaultReplicationTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		r.mu.Lock()
+		for _, acked := range r.acked {
+			if acked >= seq {
+				if r.slow {
+					log.Printf("Backups voltaram a confirmar as escritas.")
+					r.slow = false
+				}
+				r.mu.Unlock()
+				return
+			}
+		}
+		ch := r.ackCh
+		r.mu.Unlock()
+
+		select {
+		case <-ch:
+		case <-r.rl.done:
+			return
+		case <-timer.C:
+			r.mu.Lock()
+			if !r.slow {
+				log.Printf("Nenhum backup confirmou o registro %d em %v; confirmando só com o disco local.", seq, timeout)
+				r.slow = true
+			}
+			r.mu.Unlock()
+			return
+		}
+	}
+}
+
+// status descreve os backups; lastSeq é o último registro do primário.
+func (r *replicator) status(lastSeq uint64) []BackupStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]BackupStatus, 0, len(r.backups))
+	for _, addr := range r.backups {
+		acked := r.acked[addr]
+		st := BackupStatus{Addr: addr, Connected: r.online[addr], AckedSeq: acked}
+		if lastSeq > acked {
+			st.Lag = lastSeq - acked
+		}
+		out = append(out, st)
+	}
+	return out
+}
+
+// captureState fotografa todas as listas (e as transações preparadas) junto
+// com o número do último registro que elas refletem, com os escritores parados
+// só durante a captura (como em createSnapshot).
+func (rl *RemoteList) captureState() (InstallStateArgs, error) {
+	rl.mapMu.RLock()
+	ids, locked := rl.lockAllKeys()
+	rl.logLock.Lock()
+
+	var err error
+	if rl.failedSeq != 0 {
+		// O ABORT pendente precisa ir antes, para o backup não ficar sem ele.
+		err = rl.repairLog()
+	}
+	views := make([]listView, len(locked))
+	for i, ml := range locked {
+		views[i] = ml.captureView()
+	}
+	structs := captureStructures(ids, locked)
+	state := InstallStateArgs{LastSeq: rl.seq, LastTime: rl.lastTime, Expires: captureExpires(ids, locked), Delayed: captureDelayed(ids, locked)}
+	rl.txMu.Lock()
+	state.Prepared, state.Decided = rl.preparedList(), rl.decided.list()
+	rl.txMu.Unlock()
+
+	rl.logLock.Unlock()
+	for _, ml := range locked {
+		ml.mu.Unlock()
+	}
+	rl.mapMu.RUnlock()
+	if err != nil {
+		return InstallStateArgs{}, err
+	}
+
+	state.Lists = listValues(ids, locked, views)
+	state.Structures = structuresOf(structs)
+	return state, nil
+}
@@ -0,0 +1,762 @@
+package remotelist_test
+
+import (
+	"fmt"
+	"math/rand"
+	"net/rpc"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"remotelist/pkg"
+)
+
+// Replicação primário-backup com vários servidores no mesmo processo.
+
+const (
+	replOps     = 2000 // Operações por teste
+	replClients = 8    // Clientes concorrentes
+)
+
+// --- Servidores em processo ---
+
+// setup sobe um primário e nBackups backups.
+func (c *cluster) setup(nBackups int, cfg remotelist.Config) (*node, []*node, error) {
+	primary, err := c.add("primário", false)
+	if err != nil {
+		return nil, nil, err
+	}
+	var backups []*node
+	for i := 0; i < nBackups; i++ {
+		b, err := c.add(fmt.Sprintf("backup%d", i+1), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := b.start(remotelist.Config{Backup: true}); err != nil {
+			return nil, nil, err
+		}
+		backups = append(backups, b)
+		cfg.Backups = append(cfg.Backups, b.addr)
+	}
+	return primary, backups, primary.start(cfg)
+}
+
+// --- Carga e comparação ---
+
+// workload aplica n operações aleatórias no servidor, com replClients clientes
+// em paralelo. Erros esperados ("lista vazia", ...) são ignorados.
+func workload(rl *remotelist.RemoteList, seed int64, n int) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, replClients)
+	for c := 0; c < replClients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed*100 + int64(c)))
+			for i := 0; i < n/replClients; i++ {
+				id := fmt.Sprintf("lista-%02d", r.Intn(40))
+				var err error
+				switch p := r.Intn(100); {
+				case p < 70:
+					err = rl.Append(remotelist.AppendArgs{ListID: id, Value: r.Intn(1000)}, &remotelist.AppendReply{})
+				case p < 88:
+					err = rl.Remove(remotelist.RemoveArgs{ListID: id}, &remotelist.RemoveReply{})
+				case p < 95:
+					values := make([]int, r.Intn(5))
+					for j := range values {
+						values[j] = r.Intn(1000)
+					}
+					err = rl.Peer().ImportList(remotelist.ImportListArgs{ListID: id, Values: values}, &remotelist.ImportListReply{})
+				default:
+					err = rl.Peer().DeleteList(remotelist.DeleteListArgs{ListID: id}, &remotelist.DeleteListReply{})
+				}
+				if err != nil && !expected(err) {
+					errs <- fmt.Errorf("cliente %d: %w", c, err)
+					return
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+func expected(err error) bool {
+	msg := err.Error()
+	return msg == "lista vazia" || msg == "lista não encontrada"
+}
+
+// state lê todas as listas de um servidor.
+func state(rl *remotelist.RemoteList) map[string][]int {
+	var lists remotelist.ListsReply
+	rl.Lists(remotelist.ListsArgs{}, &lists)
+	out := make(map[string][]int, len(lists.ListIDs))
+	for _, id := range lists.ListIDs {
+		var data remotelist.ExportListReply
+		rl.Peer().ExportList(remotelist.ExportListArgs{ListID: id}, &data)
+		if data.Exists {
+			out[id] = data.Values
+		}
+	}
+	return out
+}
+
+// diff descreve a primeira diferença entre dois estados ("" = iguais).
+func diff(want, got map[string][]int) string {
+	for id, w := range want {
+		g, ok := got[id]
+		if !ok {
+			return fmt.Sprintf("lista '%s' não existe", id)
+		}
+		if fmt.Sprint(w) != fmt.Sprint(g) {
+			return fmt.Sprintf("lista '%s' = %v, esperado %v", id, g, w)
+		}
+	}
+	for id := range got {
+		if _, ok := want[id]; !ok {
+			return fmt.Sprintf("lista '%s' sobrando", id)
+		}
+	}
+	return ""
+}
+
+func lastSeq(rl *remotelist.RemoteList) uint64 {
+	var st remotelist.ReplicationStatusReply
+	rl.ReplicationStatus(remotelist.ReplicationStatusArgs{}, &st)
+	return st.LastSeq
+}
+
+// converge espera os backups alcançarem o primário e compara os estados.
+func converge(primary *node, backups ...*node) error {
+	want := state(primary.rl)
+	seq := lastSeq(primary.rl)
+	for _, b := range backups {
+		deadline := time.Now().Add(20 * time.Second)
+		for lastSeq(b.rl) != seq {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("%s parou no registro %d, primário em %d", b.name, lastSeq(b.rl), seq)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if d := diff(want, state(b.rl)); d != "" {
+			return fmt.Errorf("%s diverge do primário: %s", b.name, d)
+		}
+	}
+	return nil
+}
+
+// --- Cenários ---
+
+func TestReplStream(t *testing.T) {
+	c := newCluster(t)
+	primary, backups, err := c.setup(2, remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := workload(primary.rl, 1, replOps); err != nil {
+		t.Fatal(err)
+	}
+	if err := converge(primary, backups...); err != nil {
+		t.Fatal(err)
+	}
+
+	// Com todos em dia, o primário não vê atraso em ninguém (assim que as
+	// confirmações dos últimos lotes chegarem).
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var st remotelist.ReplicationStatusReply
+		primary.rl.ReplicationStatus(remotelist.ReplicationStatusArgs{}, &st)
+		if len(st.Backups) != 2 {
+			t.Fatalf("status com %d backups, esperado 2", len(st.Backups))
+		}
+		var err error
+		for _, b := range st.Backups {
+			if !b.Connected || b.Lag != 0 {
+				err = fmt.Errorf("backup %s: conectado=%v atraso=%d", b.Addr, b.Connected, b.Lag)
+			}
+		}
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Um backup que reinicia (limpo) continua de onde parou.
+	backups[0].stop()
+	if err := workload(primary.rl, 2, replOps/4); err != nil {
+		t.Fatal(err)
+	}
+	if err := backups[0].start(remotelist.Config{Backup: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := workload(primary.rl, 3, replOps/4); err != nil {
+		t.Fatal(err)
+	}
+	if err := converge(primary, backups...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplSyncAck(t *testing.T) {
+	c := newCluster(t)
+	primary, backups, err := c.setup(1, remotelist.Config{SyncReplication: true, ReplicationTimeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup := backups[0]
+
+	// Toda escrita confirmada já foi gravada e aplicada no backup: sem esperar nada,
+	// os dois têm o mesmo estado.
+	if err := workload(primary.rl, 4, replOps); err != nil {
+		t.Fatal(err)
+	}
+	want := state(primary.rl)
+	if d := diff(want, state(backup.rl)); d != "" {
+		t.Fatalf("backup atrás de escritas confirmadas: %s", d)
+	}
+
+	// O primário cai; o backup também (cada um só com o que sincronizou), volta
+	// e é promovido.
+	primary.crash()
+	backup.crash()
+	if err := backup.start(remotelist.Config{Backup: true}); err != nil {
+		t.Fatal(err)
+	}
+	var reply remotelist.PromoteReply
+	if err := backup.rl.Promote(remotelist.PromoteArgs{}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if d := diff(want, state(backup.rl)); d != "" {
+		t.Fatalf("escrita confirmada perdida no failover: %s", d)
+	}
+	if err := backup.rl.Append(remotelist.AppendArgs{ListID: "depois", Value: 1}, &remotelist.AppendReply{}); err != nil {
+		t.Fatalf("novo primário recusou escrita: %v", err)
+	}
+}
+
+func TestReplReject(t *testing.T) {
+	c := newCluster(t)
+	primary, backups, err := c.setup(1, remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup := backups[0]
+	if err := primary.rl.Append(remotelist.AppendArgs{ListID: "a", Value: 1}, &remotelist.AppendReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := converge(primary, backup); err != nil {
+		t.Fatal(err)
+	}
+
+	// Por RPC, como um cliente faria.
+	client, err := rpc.Dial("tcp", backup.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	writes := []struct {
+		method string
+		args   any
+		reply  any
+	}{
+		{"RemoteList.Append", remotelist.AppendArgs{ListID: "a", Value: 2}, &remotelist.AppendReply{}},
+		{"RemoteList.Append", remotelist.AppendArgs{ListID: "nova", Value: 2}, &remotelist.AppendReply{}},
+		{"RemoteList.Remove", remotelist.RemoveArgs{ListID: "a"}, &remotelist.RemoveReply{}},
+		{"Peer.ImportList", remotelist.ImportListArgs{ListID: "a"}, &remotelist.ImportListReply{}},
+		{"Peer.DeleteList", remotelist.DeleteListArgs{ListID: "a"}, &remotelist.DeleteListReply{}},
+	}
+	for _, w := range writes {
+		err := client.Call(w.method, w.args, w.reply)
+		if err == nil || !strings.Contains(err.Error(), "modo backup") {
+			t.Fatalf("backup aceitou %s(%+v): %v", w.method, w.args, err)
+		}
+	}
+	var get remotelist.GetReply
+	if err := client.Call("RemoteList.Get", remotelist.GetArgs{ListID: "a"}, &get); err != nil || get.Value != 1 {
+		t.Fatalf("leitura no backup: %v, %v", get.Value, err)
+	}
+	if d := diff(state(primary.rl), state(backup.rl)); d != "" {
+		t.Fatalf("escrita recusada mudou o backup: %s", d)
+	}
+
+	if err := primary.rl.Peer().ApplyLog(remotelist.ApplyLogArgs{}, &remotelist.ApplyLogReply{}); err == nil {
+		t.Fatalf("primário aceitou ApplyLog")
+	}
+	if err := primary.rl.Promote(remotelist.PromoteArgs{}, &remotelist.PromoteReply{}); err == nil {
+		t.Fatalf("primário aceitou Promote")
+	}
+}
+
+func TestReplFailover(t *testing.T) {
+	c := newCluster(t)
+	primary, backups, err := c.setup(2, remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1, b2 := backups[0], backups[1]
+	if err := workload(primary.rl, 5, replOps); err != nil {
+		t.Fatal(err)
+	}
+	if err := converge(primary, b1, b2); err != nil {
+		t.Fatal(err)
+	}
+
+	// b1 para; b2 recebe mais um pouco; depois b2 também para e o primário
+	// confirma escritas que nenhum backup viu (replicação assíncrona).
+	b1.stop()
+	if err := workload(primary.rl, 6, replOps/4); err != nil {
+		t.Fatal(err)
+	}
+	if err := converge(primary, b2); err != nil {
+		t.Fatal(err)
+	}
+	b2.stop()
+	if err := workload(primary.rl, 7, replOps/4); err != nil {
+		t.Fatal(err)
+	}
+	lost := lastSeq(primary.rl)
+	primary.crash()
+
+	// b2 (o mais adiantado) vira primário, com b1 e o primário antigo de backups.
+	if err := b1.start(remotelist.Config{Backup: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b2.start(remotelist.Config{Backup: true}); err != nil {
+		t.Fatal(err)
+	}
+	var reply remotelist.PromoteReply
+	if err := b2.rl.Promote(remotelist.PromoteArgs{Backups: []string{b1.addr, primary.addr}}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Failover: %d registros do primário antigo perdidos.", lost-reply.LastSeq)
+	if err := primary.start(remotelist.Config{Backup: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := workload(b2.rl, 8, replOps/2); err != nil {
+		t.Fatal(err)
+	}
+	// O primário antigo tinha um histórico que divergiu: precisa ficar igual ao novo.
+	if err := converge(b2, b1, primary); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplBackupFaults(t *testing.T) {
+	c := newCluster(t)
+	primary, err := c.add("primário", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup, err := c.add("backup", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backup.start(remotelist.Config{Backup: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.start(remotelist.Config{Backups: []string{backup.addr}}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := rand.New(rand.NewSource(9))
+	injected := 0 // A queda troca o FaultFS, e com ele a contagem
+	for round := 0; round < 6; round++ {
+		switch round % 3 {
+		case 0:
+			backup.fault.Inject(remotelist.Fault{Op: remotelist.FaultWrite, Nth: 1 + r.Intn(3), Match: "remotelist.log", Torn: true})
+		case 1:
+			backup.fault.Inject(remotelist.Fault{Op: remotelist.FaultSync, Nth: 1 + r.Intn(3), Match: "remotelist.log"})
+		case 2:
+			backup.fault.Inject(remotelist.Fault{Op: remotelist.FaultOpen, Nth: 1, Match: "remotelist.log"})
+			backup.fault.Inject(remotelist.Fault{Op: remotelist.FaultWrite, Nth: 1 + r.Intn(3), Match: "remotelist.log"})
+		}
+		if err := workload(primary.rl, int64(10+round), replOps/6); err != nil {
+			t.Fatal(err)
+		}
+		if err := converge(primary, backup); err != nil {
+			t.Fatalf("rodada %d: %v", round, err)
+		}
+		injected += backup.fault.Injected()
+		backup.fault.Clear()
+
+		// A cada duas rodadas o backup cai: o replay precisa lidar com lotes
+		// repetidos e segmentos abandonados.
+		if round%2 == 1 {
+			backup.crash()
+			if err := backup.start(remotelist.Config{Backup: true}); err != nil {
+				t.Fatal(err)
+			}
+			if err := converge(primary, backup); err != nil {
+				t.Fatalf("rodada %d, depois da queda: %v", round, err)
+			}
+		}
+	}
+	t.Logf("%d falhas injetadas no backup.", injected)
+	if injected == 0 {
+		t.Fatalf("nenhuma falha foi injetada")
+	}
+}
+
+func TestReplBehindBuffer(t *testing.T) {
+	c := newCluster(t)
+	primary, backups, err := c.setup(1, remotelist.Config{ReplicationBuffer: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup := backups[0]
+	if err := workload(primary.rl, 20, replOps/4); err != nil {
+		t.Fatal(err)
+	}
+	if err := converge(primary, backup); err != nil {
+		t.Fatal(err)
+	}
+
+	backup.stop()
+	if err := workload(primary.rl, 21, replOps); err != nil {
+		t.Fatal(err)
+	}
+	if err := backup.start(remotelist.Config{Backup: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := converge(primary, backup); err != nil {
+		t.Fatal(err)
+	}
+
+	// O primário reinicia sem buffer nenhum; o backup está exatamente na mesma
+	// posição e continua com os registros novos.
+	primary.stop()
+	if err := primary.start(primary.cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := workload(primary.rl, 22, replOps/4); err != nil {
+		t.Fatal(err)
+	}
+	if err := converge(primary, backup); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// heartbeat é o intervalo entre sinais de vida do primário nos cenários de leitura.
+const heartbeat = 20 * time.Millisecond
+
+func size(rl *remotelist.RemoteList, id string, read remotelist.ReadOptions) (int, error) {
+	var reply remotelist.SizeReply
+	err := rl.Size(remotelist.SizeArgs{ListID: id, Read: read}, &reply)
+	return reply.Size, err
+}
+
+func lag(rl *remotelist.RemoteList) remotelist.ReplicaLag {
+	var st remotelist.ReplicationStatusReply
+	rl.ReplicationStatus(remotelist.ReplicationStatusArgs{}, &st)
+	return st.Lag
+}
+
+// TestReplReadConsistency usa duas réplicas que conhecem o primário: uma recebe o
+// WAL dele e a outra não (fica para sempre vazia, então toda leitura atendida
+// por ela mesma aparece).
+func TestReplReadConsistency(t *testing.T) {
+	c := newCluster(t)
+	primary, err := c.add("primário", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	follower, err := c.add("réplica", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphan, err := c.add("réplica-sem-wal", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loner, err := c.add("réplica-sem-primário", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []*node{follower, orphan} {
+		if err := n.start(remotelist.Config{Backup: true, Primary: primary.addr}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := loner.start(remotelist.Config{Backup: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.start(remotelist.Config{Backups: []string{follower.addr}, HeartbeatInterval: heartbeat}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Lê as próprias escritas na réplica, uma a uma, sem esperar a replicação.
+	for i := 1; i <= 200; i++ {
+		var reply remotelist.AppendReply
+		if err := primary.rl.Append(remotelist.AppendArgs{ListID: "a", Value: i}, &reply); err != nil {
+			t.Fatal(err)
+		}
+		n, err := size(follower.rl, "a", remotelist.ReadOptions{Consistency: remotelist.ReadYourWrites, Token: reply.Token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != i {
+			t.Fatalf("session: réplica viu %d elementos depois da escrita %d (token %d)", n, i, reply.Token)
+		}
+	}
+
+	// A réplica sem WAL só tem as listas pelo primário.
+	var token uint64
+	for i := 0; i < 3; i++ {
+		var reply remotelist.AppendReply
+		if err := primary.rl.Append(remotelist.AppendArgs{ListID: "b", Value: i}, &reply); err != nil {
+			t.Fatal(err)
+		}
+		token = reply.Token
+	}
+	reads := []struct {
+		name string
+		read remotelist.ReadOptions
+		want int
+	}{
+		{"eventual", remotelist.ReadOptions{}, 0},
+		{"strong", remotelist.ReadOptions{Consistency: remotelist.Strong}, 3},
+		{"bounded", remotelist.ReadOptions{Consistency: remotelist.BoundedStaleness, MaxLag: time.Hour}, 3},
+		{"session", remotelist.ReadOptions{Consistency: remotelist.ReadYourWrites, Token: token}, 3},
+		{"session sem escritas", remotelist.ReadOptions{Consistency: remotelist.ReadYourWrites}, 0},
+	}
+	for _, r := range reads {
+		n, err := size(orphan.rl, "b", r.read)
+		if err != nil {
+			t.Fatalf("%s na réplica sem WAL: %v", r.name, err)
+		}
+		if n != r.want {
+			t.Fatalf("%s na réplica sem WAL: %d elementos, esperado %d", r.name, n, r.want)
+		}
+	}
+	// Leituras que abrem a conexão com o primário ao mesmo tempo: uma conexão
+	// fica, as outras são fechadas.
+	if err := orphan.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if n, err := size(orphan.rl, "b", remotelist.ReadOptions{Consistency: remotelist.Strong}); err != nil || n != 3 {
+				errs <- fmt.Errorf("strong concorrente: (%d, %v), esperado 3", n, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if l := lag(orphan.rl); l.Staleness >= 0 {
+		t.Fatalf("réplica sem WAL informa atraso %v, esperado desconhecido", l.Staleness)
+	}
+	var lists remotelist.ListsReply
+	if err := orphan.rl.Lists(remotelist.ListsArgs{Read: remotelist.ReadOptions{Consistency: remotelist.Strong}}, &lists); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(lists.ListIDs, ",") != "a,b" {
+		t.Fatalf("Lists strong na réplica sem WAL: %v", lists.ListIDs)
+	}
+	var get remotelist.GetReply
+	err = orphan.rl.Get(remotelist.GetArgs{ListID: "b", Index: 5, Read: remotelist.ReadOptions{Consistency: remotelist.Strong}}, &get)
+	if err == nil || err.Error() != "índice fora dos limites" {
+		t.Fatalf("Get strong fora dos limites: %v, esperado o erro do primário", err)
+	}
+
+	// Sem o endereço do primário, só as leituras locais funcionam.
+	if _, err := size(loner.rl, "b", remotelist.ReadOptions{Consistency: remotelist.Strong}); err == nil {
+		t.Fatal("strong numa réplica sem primário configurado não falhou")
+	}
+	if _, err := size(loner.rl, "b", remotelist.ReadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Com o primário parado, a réplica em dia atende enquanto o atraso couber
+	// no limite; depois, a leitura vai para o primário (e falha).
+	if err := converge(primary, follower); err != nil {
+		t.Fatal(err)
+	}
+	primary.stop()
+	bounded := remotelist.ReadOptions{Consistency: remotelist.BoundedStaleness, MaxLag: 500 * time.Millisecond}
+	if n, err := size(follower.rl, "b", bounded); err != nil || n != 3 {
+		t.Fatalf("bounded logo depois da parada: (%d, %v), esperado 3 da réplica", n, err)
+	}
+	if n, err := size(follower.rl, "b", remotelist.ReadOptions{Consistency: remotelist.ReadYourWrites, Token: token}); err != nil || n != 3 {
+		t.Fatalf("session com o primário parado: (%d, %v), esperado 3 da réplica", n, err)
+	}
+	if _, err := size(follower.rl, "b", remotelist.ReadOptions{Consistency: remotelist.Strong}); err == nil {
+		t.Fatal("strong com o primário parado não falhou")
+	}
+	time.Sleep(600 * time.Millisecond)
+	if _, err := size(follower.rl, "b", bounded); err == nil {
+		t.Fatal("bounded com a réplica atrasada além do limite não foi ao primário")
+	}
+	if n, err := size(follower.rl, "b", remotelist.ReadOptions{}); err != nil || n != 3 {
+		t.Fatalf("eventual com o primário parado: (%d, %v), esperado 3", n, err)
+	}
+}
+
+// TestReplReplicaLag faz o disco da réplica falhar por um tempo.
+func TestReplReplicaLag(t *testing.T) {
+	c := newCluster(t)
+	primary, err := c.add("primário", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup, err := c.add("backup", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backup.start(remotelist.Config{Backup: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.start(remotelist.Config{Backups: []string{backup.addr}, HeartbeatInterval: heartbeat}); err != nil {
+		t.Fatal(err)
+	}
+	if err := workload(primary.rl, 1, 200); err != nil {
+		t.Fatal(err)
+	}
+	if err := converge(primary, backup); err != nil {
+		t.Fatal(err)
+	}
+
+	// Parado, mas em dia: os sinais de vida mantêm o atraso perto do intervalo.
+	for i := 0; i < 10; i++ {
+		time.Sleep(heartbeat)
+		if l := lag(backup.rl); l.Records != 0 || l.Staleness < 0 || l.Staleness > 10*heartbeat {
+			t.Fatalf("réplica em dia informa %+v", l)
+		}
+	}
+
+	backup.fault.Inject(remotelist.Fault{Op: remotelist.FaultWrite, Match: "remotelist.log", Sticky: true})
+	for i := 0; i < 20; i++ {
+		if err := primary.rl.Append(remotelist.AppendArgs{ListID: "x", Value: i}, &remotelist.AppendReply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l := lag(backup.rl)
+		if l.Records == lastSeq(primary.rl)-lastSeq(backup.rl) && l.Records > 0 && l.Staleness > 300*time.Millisecond {
+			t.Logf("Atraso com o disco falhando: %+v", l)
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("atraso informado %+v, réplica em %d, primário em %d", l, lastSeq(backup.rl), lastSeq(primary.rl))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	backup.fault.Clear()
+	if err := converge(primary, backup); err != nil {
+		t.Fatal(err)
+	}
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		l := lag(backup.rl)
+		if l.Records == 0 && l.Staleness >= 0 && l.Staleness <= 10*heartbeat {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("réplica recuperada informa %+v", l)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func ttlOf(rl *remotelist.RemoteList, id string) (time.Time, error) {
+	var reply remotelist.TTLReply
+	err := rl.TTL(remotelist.TTLArgs{ListID: id}, &reply)
+	return reply.Expires, err
+}
+
+// TestReplTTL roda o primário sem reaper: o DELETE da lista vencida só é gravado
+// quando uma escrita a recria.
+func TestReplTTL(t *testing.T) {
+	c := newCluster(t)
+	primary, backups, err := c.setup(1, remotelist.Config{ReplicationBuffer: 8, ReapInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup := backups[0]
+	for _, w := range []struct {
+		id string
+		v  int
+	}{{"a", 1}, {"a", 2}, {"b", 3}} {
+		if err := primary.rl.Append(remotelist.AppendArgs{ListID: w.id, Value: w.v}, &remotelist.AppendReply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := make(map[string]time.Time)
+	for id, d := range map[string]time.Duration{"a": 500 * time.Millisecond, "b": time.Hour} {
+		var reply remotelist.SetTTLReply
+		if err := primary.rl.SetTTL(remotelist.SetTTLArgs{ListID: id, TTL: d}, &reply); err != nil {
+			t.Fatal(err)
+		}
+		want[id] = reply.Expires
+	}
+	sameTTL := func(when string) error {
+		for id, w := range want {
+			got, err := ttlOf(backup.rl, id)
+			if err != nil || !got.Equal(w) {
+				return fmt.Errorf("%s: prazo de '%s' no backup = %v, %v; esperado %v", when, id, got, err, w)
+			}
+		}
+		return nil
+	}
+	if err := converge(primary, backup); err != nil {
+		t.Fatal(err)
+	}
+	if err := sameTTL("pelo WAL"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Com o backup parado, o buffer transborda e ele recebe o estado inteiro.
+	backup.stop()
+	for i := 0; i < 20; i++ {
+		if err := primary.rl.Append(remotelist.AppendArgs{ListID: "c", Value: i}, &remotelist.AppendReply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := backup.start(remotelist.Config{Backup: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := converge(primary, backup); err != nil {
+		t.Fatal(err)
+	}
+	if err := sameTTL("pelo estado inteiro"); err != nil {
+		t.Fatal(err)
+	}
+
+	seq := lastSeq(primary.rl)
+	time.Sleep(time.Until(want["a"]) + 50*time.Millisecond)
+	if n, err := size(backup.rl, "a", remotelist.ReadOptions{}); err != nil || n != 0 {
+		t.Fatalf("Size(a) no backup depois do prazo = %d, %v; esperado 0", n, err)
+	}
+	if err := backup.rl.Get(remotelist.GetArgs{ListID: "a"}, &remotelist.GetReply{}); err == nil {
+		t.Fatal("o backup ainda lê a lista vencida")
+	}
+	if lastSeq(primary.rl) != seq || lastSeq(backup.rl) != seq {
+		t.Fatal("registro novo sem escrita nem reaper")
+	}
+
+	// A escrita apaga a lista vencida (DELETE) e começa uma nova.
+	if err := primary.rl.Append(remotelist.AppendArgs{ListID: "a", Value: 9}, &remotelist.AppendReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := converge(primary, backup); err != nil {
+		t.Fatal(err)
+	}
+	var data remotelist.ExportListReply
+	backup.rl.Peer().ExportList(remotelist.ExportListArgs{ListID: "a"}, &data)
+	if fmt.Sprint(data.Values) != "[9]" || !data.Expires.IsZero() {
+		t.Fatalf("lista recriada no backup = %v (prazo %v), esperado [9] sem prazo", data.Values, data.Expires)
+	}
+}
@@ -0,0 +1,46 @@
+package shard
+
+// Admin expõe a administração do Router por RPC (serviço "Router"), separada
+// do serviço "RemoteList" usado pelos clientes.
+type Admin struct {
+	Router *Router
+}
+
+type AddNodeArgs struct {
+	Addr string
+}
+type AddNodeReply struct {
+	Moved int // Listas migradas para o novo servidor
+}
+
+type StatusArgs struct{}
+type StatusReply struct {
+	Nodes   []string
+	Pending int // Listas que ainda esperam migração
+}
+
+type RebalanceArgs struct{}
+type RebalanceReply struct {
+	Pending int
+}
+
+// AddNode coloca um servidor no anel (ver Router.AddNode).
+func (a *Admin) AddNode(args AddNodeArgs, reply *AddNodeReply) error {
+	moved, err := a.Router.AddNode(args.Addr)
+	reply.Moved = moved
+	return err
+}
+
+// Status mostra os servidores do anel e a migração em andamento.
+func (a *Admin) Status(args StatusArgs, reply *StatusReply) error {
+	reply.Nodes = a.Router.Nodes()
+	reply.Pending = a.Router.Pending()
+	return nil
+}
+
+// Rebalance tenta de novo as migrações pendentes.
+func (a *Admin) Rebalance(args RebalanceArgs, reply *RebalanceReply) error {
+	err := a.Router.Rebalance()
+	reply.Pending = a.Router.Pending()
+	return err
+}
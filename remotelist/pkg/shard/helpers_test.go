@@ -0,0 +1,194 @@
+package shard_test
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net"
+	"net/rpc"
+	"os"
+	"testing"
+
+	"remotelist/pkg"
+	"remotelist/pkg/shard"
+)
+
+// TestMain cala o log dos servidores e do roteador, que só aparece com -v.
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+// --- Servidores e roteador em processo ---
+
+// node é um servidor RemoteList rodando neste processo, com a sua porta e o
+// seu disco em memória.
+type node struct {
+	addr string
+	mem  *remotelist.MemFS
+	fs   remotelist.FS // Se não for nil, usado no lugar de mem (ex: um FaultFS)
+	rl   *remotelist.RemoteList
+	l    net.Listener
+}
+
+// cluster guarda os servidores, o roteador e um cliente conectado a ele.
+type cluster struct {
+	nodes  []*node
+	coord  remotelist.FS // Disco do log do coordenador
+	router *shard.Router
+	rl     net.Listener
+	client *rpc.Client
+}
+
+// newCluster devolve um cluster vazio, derrubado no fim do teste.
+func newCluster(t *testing.T) *cluster {
+	c := &cluster{}
+	t.Cleanup(c.close)
+	return c
+}
+
+// addServer sobe um servidor novo (fora do anel até AddNode). fsys, se não
+// for nil, substitui o disco em memória.
+func (c *cluster) addServer(fsys remotelist.FS) (*node, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	n := &node{addr: l.Addr().String(), mem: remotelist.NewMemFS(), fs: fsys}
+	l.Close()
+	if err := n.start(); err != nil {
+		return nil, err
+	}
+	c.nodes = append(c.nodes, n)
+	return n, nil
+}
+
+// addNodes sobe n servidores.
+func (c *cluster) addNodes(n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := c.addServer(nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// start abre o RemoteList e passa a atender no endereço do nó.
+func (n *node) start() error {
+	fsys := n.fs
+	if fsys == nil {
+		fsys = n.mem
+	}
+	fsys.MkdirAll("data", 0755)
+	rl, err := remotelist.NewRemoteListWithConfig(remotelist.Config{Dir: "data", SnapshotInterval: -1, FS: fsys})
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", n.addr)
+	if err != nil {
+		rl.Close()
+		return err
+	}
+	rpcs := rpc.NewServer()
+	if err := rpcs.Register(rl); err != nil {
+		return err
+	}
+	if err := rpcs.RegisterName("Peer", rl.Peer()); err != nil {
+		return err
+	}
+	go serve(l, rpcs)
+	n.rl, n.l = rl, l
+	return nil
+}
+
+// stop fecha a porta e o RemoteList (as conexões abertas morrem com a porta).
+func (n *node) stop() {
+	if n.rl == nil {
+		return
+	}
+	n.l.Close()
+	n.rl.Close()
+	n.rl = nil
+}
+
+// crash simula uma queda: o disco fica só com o que foi sincronizado.
+func (n *node) crash() {
+	n.l.Close()
+	crashed := n.mem.Crash()
+	n.rl.Close() // Só para as goroutines; o que ele gravar fica no disco antigo
+	n.mem, n.rl = crashed, nil
+}
+
+func serve(l net.Listener, rpcs *rpc.Server) {
+	var conns []net.Conn
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conns = append(conns, conn)
+		go rpcs.ServeConn(conn)
+	}
+}
+
+// startRouter sobe o roteador sobre os servidores, com cfg.Nodes preenchido,
+// e conecta c.client a ele.
+func (c *cluster) startRouter(cfg shard.Config) error {
+	cfg.Nodes = nil
+	for _, n := range c.nodes {
+		cfg.Nodes = append(cfg.Nodes, n.addr)
+	}
+	router, err := shard.NewRouter(cfg)
+	if err != nil {
+		return err
+	}
+	c.router = router
+
+	rpcs := rpc.NewServer()
+	if err := rpcs.RegisterName("RemoteList", router); err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	c.rl = l
+	go serve(l, rpcs)
+	c.client, err = c.dial()
+	return err
+}
+
+func (c *cluster) dial() (*rpc.Client, error) {
+	return rpc.Dial("tcp", c.rl.Addr().String())
+}
+
+// stopRouter derruba o roteador (o log dele fica como está no disco).
+func (c *cluster) stopRouter() {
+	if c.client != nil {
+		c.client.Close()
+		c.client = nil
+	}
+	if c.rl != nil {
+		c.rl.Close()
+		c.rl = nil
+	}
+	if c.router != nil {
+		c.router.Close()
+		c.router = nil
+	}
+}
+
+func (c *cluster) close() {
+	c.stopRouter()
+	for _, n := range c.nodes {
+		n.stop()
+	}
+}
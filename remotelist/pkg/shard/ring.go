@@ -0,0 +1,139 @@
+// Package shard distribui as listas do RemoteList entre vários servidores
+// independentes. Um Router fala o mesmo protocolo RPC do servidor
+// ("RemoteList.Append", ...) e encaminha cada chamada para o servidor dono da
+// lista, escolhido por um anel de hash consistente com nós virtuais.
+//
+// Quando um servidor entra no anel, só as listas que passam a ser dele mudam
+// de lugar; elas são copiadas com o servidor no ar, cada uma com um bloqueio
+// de escrita curto (ver Router.AddNode).
+package shard
+
+import (
+	"errors"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// DefaultVNodes é a quantidade padrão de nós virtuais por servidor.
+const DefaultVNodes = 64
+
+// Ring é um anel de hash consistente imutável: adicionar um nó cria outro anel.
+type Ring struct {
+	vnodes int
+	nodes  []string
+	points []uint64 // Posições dos nós virtuais, em ordem crescente
+	owners []string // owners[i] é o nó da posição points[i]
+}
+
+// NewRing cria um anel com os nós dados, cada um com vnodes nós virtuais
+// (vnodes <= 0 usa DefaultVNodes).
+func NewRing(vnodes int, nodes ...string) (*Ring, error) {
+	if vnodes <= 0 {
+		vnodes = DefaultVNodes
+	}
+	r := &Ring{vnodes: vnodes}
+	for _, node := range nodes {
+		if r.Has(node) {
+			return nil, errors.New("nó repetido no anel: " + node)
+		}
+		r.nodes = append(r.nodes, node)
+	}
+	r.build()
+	return r, nil
+}
+
+// With devolve um anel novo com node incluído.
+func (r *Ring) With(node string) (*Ring, error) {
+	return NewRing(r.vnodes, append(r.Nodes(), node)...)
+}
+
+// build calcula as posições de todos os nós virtuais.
+func (r *Ring) build() {
+	type point struct {
+		pos   uint64
+		owner string
+	}
+	points := make([]point, 0, len(r.nodes)*r.vnodes)
+	for _, node := range r.nodes {
+		for i := 0; i < r.vnodes; i++ {
+			points = append(points, point{hashKey(node + "#" + strconv.Itoa(i)), node})
+		}
+	}
+	// Empate de posição (raríssimo) é resolvido pelo nome, para o anel não
+	// depender da ordem em que os nós foram informados.
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].pos != points[j].pos {
+			return points[i].pos < points[j].pos
+		}
+		return points[i].owner < points[j].owner
+	})
+	r.points = make([]uint64, len(points))
+	r.owners = make([]string, len(points))
+	for i, p := range points {
+		r.points[i], r.owners[i] = p.pos, p.owner
+	}
+}
+
+// Owner devolve o nó dono da chave: o primeiro nó virtual no sentido horário.
+// Com o anel vazio devolve "".
+func (r *Ring) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// Successors devolve os n primeiros nós distintos no sentido horário a partir
+// da chave (a lista de preferência dela). Com menos de n nós, devolve todos.
+func (r *Ring) Successors(key string, n int) []string {
+	if len(r.points) == 0 || n <= 0 {
+		return nil
+	}
+	n = min(n, len(r.nodes))
+	h := hashKey(key)
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	out := make([]string, 0, n)
+	for i := 0; len(out) < n; i++ {
+		owner := r.owners[(start+i)%len(r.points)]
+		if !slices.Contains(out, owner) {
+			out = append(out, owner)
+		}
+	}
+	return out
+}
+
+// Nodes devolve uma cópia da lista de nós, na ordem em que entraram.
+func (r *Ring) Nodes() []string {
+	return append([]string(nil), r.nodes...)
+}
+
+// Has diz se node está no anel.
+func (r *Ring) Has(node string) bool {
+	for _, n := range r.nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+// hashKey espalha a chave no espaço de 64 bits. O FNV sozinho agrupa chaves
+// parecidas ("srv#1", "srv#2"); a mistura final (splitmix64) separa elas.
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,562 @@
+package shard
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/rpc"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"remotelist/pkg"
+)
+
+// fenceStripes é a quantidade de locks de migração. Cada lista usa o lock
+// de índice hash(lista) % fenceStripes; uma migração só bloqueia as listas
+// que caem no mesmo lock que a lista sendo movida.
+const fenceStripes = 256
+
+// Config reúne os parâmetros do Router.
+type Config struct {
+	Nodes  []string // Endereços dos servidores RemoteList
+	VNodes int      // Nós virtuais por servidor (0 = DefaultVNodes)
+	// DialTimeout limita a conexão com um servidor (0 = 5s).
+	DialTimeout time.Duration
+	// PeerKey é a chave dos servidores para o serviço Peer, usado na migração
+	// de listas e nas transações (ver remotelist.DialPeer).
+	PeerKey string
+
+	// Transações entre servidores (Transact, Move; ver txn.go).
+	TxLog            string        // Log do coordenador ("" = transações desabilitadas)
+	FS               remotelist.FS // Onde fica o TxLog (nil = OSFS)
+	CoordinatorID    string        // Prefixo dos IDs de transação (padrão "router")
+	RecoveryInterval time.Duration // Entre rodadas de recuperação (0 = 5s)
+}
+
+// Router encaminha as chamadas RemoteList para o servidor dono de cada lista.
+// Registre-o com rpc.RegisterName("RemoteList", router) para que os clientes
+// falem com ele como falariam com um servidor único.
+type Router struct {
+	mu      sync.RWMutex    // Protege ring, prev e pending
+	ring    *Ring           // Anel atual
+	prev    *Ring           // Anel anterior, enquanto houver listas pendentes
+	pending map[string]bool // Listas que ainda estão no dono segundo prev
+
+	fences [fenceStripes]sync.RWMutex // Chamadas seguram RLock; a migração, Lock
+
+	adminMu sync.Mutex // Uma mudança de anel por vez
+
+	connsMu     sync.Mutex
+	conns       map[string]*nodeConn
+	dialTimeout time.Duration
+	peerKey     string
+
+	coord *coordinator // nil se as transações estiverem desabilitadas
+}
+
+// NewRouter cria o roteador. Os servidores não precisam estar no ar ainda:
+// as conexões são abertas na primeira chamada.
+func NewRouter(cfg Config) (*Router, error) {
+	if len(cfg.Nodes) == 0 {
+		return nil, errors.New("informe pelo menos um servidor")
+	}
+	ring, err := NewRing(cfg.VNodes, cfg.Nodes...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DialTimeout == 0 {
+		cfg.DialTimeout = 5 * time.Second
+	}
+	r := &Router{
+		ring:        ring,
+		pending:     make(map[string]bool),
+		conns:       make(map[string]*nodeConn),
+		dialTimeout: cfg.DialTimeout,
+		peerKey:     cfg.PeerKey,
+	}
+	if cfg.TxLog != "" {
+		if r.coord, err = newCoordinator(r, cfg); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Close para a recuperação de transações e fecha as conexões com os servidores.
+func (r *Router) Close() error {
+	if r.coord != nil {
+		r.coord.close()
+	}
+	r.connsMu.Lock()
+	defer r.connsMu.Unlock()
+	for _, c := range r.conns {
+		c.close()
+	}
+	r.conns = make(map[string]*nodeConn)
+	return nil
+}
+
+// --- Métodos RPC (mesma interface do RemoteList) ---
+
+func (r *Router) Append(args remotelist.AppendArgs, reply *remotelist.AppendReply) error {
+	return r.forward(args.ListID, "Append", args, reply)
+}
+
+func (r *Router) Get(args remotelist.GetArgs, reply *remotelist.GetReply) error {
+	return r.forward(args.ListID, "Get", args, reply)
+}
+
+func (r *Router) Remove(args remotelist.RemoveArgs, reply *remotelist.RemoveReply) error {
+	return r.forward(args.ListID, "Remove", args, reply)
+}
+
+func (r *Router) Size(args remotelist.SizeArgs, reply *remotelist.SizeReply) error {
+	return r.forward(args.ListID, "Size", args, reply)
+}
+
+// O prazo do TTL migra junto com a lista (ExportList e ImportList).
+func (r *Router) SetTTL(args remotelist.SetTTLArgs, reply *remotelist.SetTTLReply) error {
+	return r.forward(args.ListID, "SetTTL", args, reply)
+}
+
+func (r *Router) Persist(args remotelist.PersistArgs, reply *remotelist.PersistReply) error {
+	return r.forward(args.ListID, "Persist", args, reply)
+}
+
+func (r *Router) TTL(args remotelist.TTLArgs, reply *remotelist.TTLReply) error {
+	return r.forward(args.ListID, "TTL", args, reply)
+}
+
+// Os itens agendados migram junto com a lista e disparam no dono dela.
+func (r *Router) Schedule(args remotelist.ScheduleArgs, reply *remotelist.ScheduleReply) error {
+	return r.forward(args.ListID, "Schedule", args, reply)
+}
+
+func (r *Router) Unschedule(args remotelist.UnscheduleArgs, reply *remotelist.UnscheduleReply) error {
+	return r.forward(args.ListID, "Unschedule", args, reply)
+}
+
+func (r *Router) Scheduled(args remotelist.ScheduledArgs, reply *remotelist.ScheduledReply) error {
+	return r.forward(args.ListID, "Scheduled", args, reply)
+}
+
+// Os agregados e as visões de uma lista são calculados pelo dono dela.
+func (r *Router) Stats(args remotelist.StatsArgs, reply *remotelist.StatsReply) error {
+	return r.forward(args.ListID, "Stats", args, reply)
+}
+
+func (r *Router) View(args remotelist.ViewArgs, reply *remotelist.ViewReply) error {
+	return r.forward(args.ListID, "View", args, reply)
+}
+
+// GetAt é encaminhado ao dono atual da lista. O histórico não migra junto:
+// o novo dono só conhece a lista a partir da cópia (registro REPLACE).
+func (r *Router) GetAt(args remotelist.GetAtArgs, reply *remotelist.GetAtReply) error {
+	return r.forward(args.ListID, "GetAt", args, reply)
+}
+
+// As chaves dos outros tipos (sets, maps, contadores, sorted sets e streams)
+// ficam no mesmo anel das listas e migram do mesmo jeito (ExportList e
+// ImportList, registro RESTORE no destino).
+func (r *Router) SetAdd(args remotelist.SetAddArgs, reply *remotelist.SetAddReply) error {
+	return r.forward(args.Key, "SetAdd", args, reply)
+}
+
+func (r *Router) SetRemove(args remotelist.SetRemoveArgs, reply *remotelist.SetRemoveReply) error {
+	return r.forward(args.Key, "SetRemove", args, reply)
+}
+
+func (r *Router) SetContains(args remotelist.SetContainsArgs, reply *remotelist.SetContainsReply) error {
+	return r.forward(args.Key, "SetContains", args, reply)
+}
+
+func (r *Router) SetMembers(args remotelist.SetMembersArgs, reply *remotelist.SetMembersReply) error {
+	return r.forward(args.Key, "SetMembers", args, reply)
+}
+
+func (r *Router) MapSet(args remotelist.MapSetArgs, reply *remotelist.MapSetReply) error {
+	return r.forward(args.Key, "MapSet", args, reply)
+}
+
+func (r *Router) MapGet(args remotelist.MapGetArgs, reply *remotelist.MapGetReply) error {
+	return r.forward(args.Key, "MapGet", args, reply)
+}
+
+func (r *Router) MapDelete(args remotelist.MapDeleteArgs, reply *remotelist.MapDeleteReply) error {
+	return r.forward(args.Key, "MapDelete", args, reply)
+}
+
+func (r *Router) CounterIncr(args remotelist.CounterArgs, reply *remotelist.CounterReply) error {
+	return r.forward(args.Key, "CounterIncr", args, reply)
+}
+
+func (r *Router) CounterDecr(args remotelist.CounterArgs, reply *remotelist.CounterReply) error {
+	return r.forward(args.Key, "CounterDecr", args, reply)
+}
+
+func (r *Router) CounterGet(args remotelist.CounterGetArgs, reply *remotelist.CounterGetReply) error {
+	return r.forward(args.Key, "CounterGet", args, reply)
+}
+
+func (r *Router) KeyType(args remotelist.KeyTypeArgs, reply *remotelist.KeyTypeReply) error {
+	return r.forward(args.Key, "KeyType", args, reply)
+}
+
+func (r *Router) SortedAdd(args remotelist.SortedAddArgs, reply *remotelist.SortedAddReply) error {
+	return r.forward(args.Key, "SortedAdd", args, reply)
+}
+
+func (r *Router) SortedPopMin(args remotelist.SortedPopArgs, reply *remotelist.SortedPopReply) error {
+	return r.forward(args.Key, "SortedPopMin", args, reply)
+}
+
+func (r *Router) SortedPopMax(args remotelist.SortedPopArgs, reply *remotelist.SortedPopReply) error {
+	return r.forward(args.Key, "SortedPopMax", args, reply)
+}
+
+func (r *Router) SortedRange(args remotelist.SortedRangeArgs, reply *remotelist.SortedRangeReply) error {
+	return r.forward(args.Key, "SortedRange", args, reply)
+}
+
+func (r *Router) SortedRank(args remotelist.SortedRankArgs, reply *remotelist.SortedRankReply) error {
+	return r.forward(args.Key, "SortedRank", args, reply)
+}
+
+func (r *Router) StreamAppend(args remotelist.StreamAppendArgs, reply *remotelist.StreamAppendReply) error {
+	return r.forward(args.Key, "StreamAppend", args, reply)
+}
+
+func (r *Router) StreamRead(args remotelist.StreamReadArgs, reply *remotelist.StreamReadReply) error {
+	return r.forward(args.Key, "StreamRead", args, reply)
+}
+
+func (r *Router) StreamTrim(args remotelist.StreamTrimArgs, reply *remotelist.StreamTrimReply) error {
+	return r.forward(args.Key, "StreamTrim", args, reply)
+}
+
+func (r *Router) StreamGroupCreate(args remotelist.StreamGroupCreateArgs, reply *remotelist.StreamGroupCreateReply) error {
+	return r.forward(args.Key, "StreamGroupCreate", args, reply)
+}
+
+func (r *Router) StreamReadGroup(args remotelist.StreamReadGroupArgs, reply *remotelist.StreamReadGroupReply) error {
+	return r.forward(args.Key, "StreamReadGroup", args, reply)
+}
+
+func (r *Router) StreamAck(args remotelist.StreamAckArgs, reply *remotelist.StreamAckReply) error {
+	return r.forward(args.Key, "StreamAck", args, reply)
+}
+
+func (r *Router) StreamClaim(args remotelist.StreamClaimArgs, reply *remotelist.StreamClaimReply) error {
+	return r.forward(args.Key, "StreamClaim", args, reply)
+}
+
+func (r *Router) StreamPending(args remotelist.StreamPendingArgs, reply *remotelist.StreamPendingReply) error {
+	return r.forward(args.Key, "StreamPending", args, reply)
+}
+
+// Lists junta as listas de todos os servidores, em ordem alfabética.
+func (r *Router) Lists(args remotelist.ListsArgs, reply *remotelist.ListsReply) error {
+	r.mu.RLock()
+	nodes := r.ring.Nodes()
+	r.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	for _, node := range nodes {
+		var part remotelist.ListsReply
+		if err := r.call(node, "Lists", args, &part); err != nil {
+			return err
+		}
+		for _, id := range part.ListIDs {
+			seen[id] = true
+		}
+	}
+	ids := make([]string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	reply.ListIDs = ids
+	return nil
+}
+
+// Keys junta as chaves de todos os servidores, em ordem alfabética.
+func (r *Router) Keys(args remotelist.KeysArgs, reply *remotelist.KeysReply) error {
+	r.mu.RLock()
+	nodes := r.ring.Nodes()
+	r.mu.RUnlock()
+
+	seen := make(map[string]remotelist.KeyInfo)
+	for _, node := range nodes {
+		var part remotelist.KeysReply
+		if err := r.call(node, "Keys", args, &part); err != nil {
+			return err
+		}
+		for _, k := range part.Keys {
+			seen[k.Key] = k
+		}
+	}
+	keys := make([]remotelist.KeyInfo, 0, len(seen))
+	for _, k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+	reply.Keys = keys
+	return nil
+}
+
+// forward envia a chamada ao dono da lista, respeitando o bloqueio de migração.
+func (r *Router) forward(listID, method string, args, reply any) error {
+	fence := r.fence(listID)
+	fence.RLock()
+	defer fence.RUnlock()
+	return r.call(r.Owner(listID), method, args, reply)
+}
+
+// Owner devolve o servidor onde a lista está agora.
+func (r *Router) Owner(listID string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.pending[listID] {
+		return r.prev.Owner(listID)
+	}
+	return r.ring.Owner(listID)
+}
+
+// Nodes devolve os servidores do anel atual.
+func (r *Router) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.ring.Nodes()
+}
+
+// Pending devolve quantas listas ainda esperam migração.
+func (r *Router) Pending() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.pending)
+}
+
+func (r *Router) fence(listID string) *sync.RWMutex {
+	return &r.fences[hashKey(listID)%fenceStripes]
+}
+
+// --- Entrada de servidores e migração ---
+
+// AddNode coloca um servidor no anel e migra para ele as chaves (listas e
+// demais tipos) que passam a ser dele. Retorna quantas chaves foram movidas.
+//
+// A troca do anel bloqueia todas as chamadas enquanto as chaves dos servidores
+// são enumeradas (uma chamada Keys por servidor). Depois disso, cada chave é
+// copiada com apenas o seu lock de migração bloqueado; as demais continuam
+// atendidas normalmente. Se uma cópia falhar, a lista continua no dono antigo
+// (e acessível) até um Rebalance bem-sucedido.
+func (r *Router) AddNode(addr string) (int, error) {
+	r.adminMu.Lock()
+	defer r.adminMu.Unlock()
+
+	if err := r.migratePending(); err != nil {
+		return 0, fmt.Errorf("migração anterior incompleta: %w", err)
+	}
+
+	r.mu.RLock()
+	old := r.ring
+	r.mu.RUnlock()
+	if old.Has(addr) {
+		return 0, fmt.Errorf("o servidor %s já está no anel", addr)
+	}
+	var probe remotelist.SizeReply
+	if err := r.call(addr, "Size", remotelist.SizeArgs{}, &probe); err != nil {
+		return 0, fmt.Errorf("servidor %s inacessível: %w", addr, err)
+	}
+	next, err := old.With(addr)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	r.lockAllFences()
+	pending := make(map[string]bool)
+	for _, node := range old.Nodes() {
+		var keys remotelist.KeysReply
+		if err := r.call(node, "Keys", remotelist.KeysArgs{}, &keys); err != nil {
+			r.unlockAllFences()
+			return 0, fmt.Errorf("falha ao listar %s: %w", node, err)
+		}
+		for _, k := range keys.Keys {
+			if next.Owner(k.Key) != node {
+				pending[k.Key] = true
+			}
+		}
+	}
+	r.mu.Lock()
+	r.prev, r.ring, r.pending = old, next, pending
+	r.mu.Unlock()
+	r.unlockAllFences()
+	log.Printf("Servidor %s entrou no anel (chamadas bloqueadas por %v); %d listas a migrar.", addr, time.Since(start), len(pending))
+
+	moved := len(pending)
+	if err := r.migratePending(); err != nil {
+		return moved - r.Pending(), err
+	}
+	return moved, nil
+}
+
+// Rebalance tenta de novo a migração das listas que ficaram pendentes.
+func (r *Router) Rebalance() error {
+	r.adminMu.Lock()
+	defer r.adminMu.Unlock()
+	return r.migratePending()
+}
+
+// migratePending move cada lista pendente do dono antigo para o novo.
+// Deve ser chamado com adminMu.
+func (r *Router) migratePending() error {
+	r.mu.RLock()
+	ids := make([]string, 0, len(r.pending))
+	for id := range r.pending {
+		ids = append(ids, id)
+	}
+	r.mu.RUnlock()
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		if err := r.migrate(id); err != nil {
+			return fmt.Errorf("falha ao migrar lista '%s': %w", id, err)
+		}
+	}
+	return nil
+}
+
+// migrate copia uma lista para o novo dono e a apaga do antigo, com as
+// chamadas para ela (e para as listas do mesmo lock) bloqueadas.
+func (r *Router) migrate(listID string) error {
+	fence := r.fence(listID)
+	fence.Lock()
+	defer fence.Unlock()
+
+	r.mu.RLock()
+	from, to := r.prev.Owner(listID), r.ring.Owner(listID)
+	r.mu.RUnlock()
+
+	var data remotelist.ExportListReply
+	if err := r.call(from, "Peer.ExportList", remotelist.ExportListArgs{ListID: listID}, &data); err != nil {
+		return err
+	}
+	if data.Exists {
+		args := remotelist.ImportListArgs{
+			ListID: listID, Values: data.Values, Expires: data.Expires, Delayed: data.Delayed,
+			Structures: data.Structures,
+		}
+		if err := r.call(to, "Peer.ImportList", args, &remotelist.ImportListReply{}); err != nil {
+			return err
+		}
+	}
+
+	// A partir daqui o destino é o dono; se o DeleteList falhar, a cópia antiga
+	// fica órfã na origem, mas nunca mais é lida (e Lists a deduplica).
+	r.mu.Lock()
+	delete(r.pending, listID)
+	if len(r.pending) == 0 {
+		r.prev = nil
+	}
+	r.mu.Unlock()
+
+	if data.Exists {
+		if err := r.call(from, "Peer.DeleteList", remotelist.DeleteListArgs{ListID: listID}, &remotelist.DeleteListReply{}); err != nil {
+			log.Printf("Lista '%s' migrada, mas não apagada de %s: %v", listID, from, err)
+		}
+	}
+	return nil
+}
+
+func (r *Router) lockAllFences() {
+	for i := range r.fences {
+		r.fences[i].Lock()
+	}
+}
+
+func (r *Router) unlockAllFences() {
+	for i := range r.fences {
+		r.fences[i].Unlock()
+	}
+}
+
+// --- Conexões com os servidores ---
+
+// nodeConn é a conexão (reaberta sob demanda) com um servidor.
+type nodeConn struct {
+	addr   string
+	mu     sync.Mutex
+	client *rpc.Client
+}
+
+// call executa "RemoteList.<method>" no servidor addr; um method com o
+// serviço ("Peer.ExportList") é executado como está.
+func (r *Router) call(addr, method string, args, reply any) error {
+	r.connsMu.Lock()
+	c, ok := r.conns[addr]
+	if !ok {
+		c = &nodeConn{addr: addr}
+		r.conns[addr] = c
+	}
+	r.connsMu.Unlock()
+
+	// ErrShutdown significa que a chamada nem foi enviada (a conexão já tinha
+	// caído): é seguro repetir uma vez com uma conexão nova.
+	for attempt := 0; ; attempt++ {
+		client, err := c.get(r.dialTimeout, r.peerKey)
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(method, ".") {
+			method = "RemoteList." + method
+		}
+		err = client.Call(method, args, reply)
+		if err == nil {
+			return nil
+		}
+		var serverErr rpc.ServerError
+		if errors.As(err, &serverErr) {
+			return err // Erro da operação (ex: "lista vazia"), repassado ao cliente
+		}
+		c.reset(client)
+		if err != rpc.ErrShutdown || attempt > 0 {
+			return fmt.Errorf("servidor %s: %w", addr, err)
+		}
+	}
+}
+
+func (c *nodeConn) get(timeout time.Duration, peerKey string) (*rpc.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != nil {
+		return c.client, nil
+	}
+	client, err := remotelist.DialPeer(c.addr, peerKey, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao conectar em %s: %w", c.addr, err)
+	}
+	c.client = client
+	return client, nil
+}
+
+// reset descarta a conexão se ela ainda for a que falhou.
+func (c *nodeConn) reset(failed *rpc.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == failed {
+		c.client.Close()
+		c.client = nil
+	}
+}
+
+func (c *nodeConn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != nil {
+		c.client.Close()
+		c.client = nil
+	}
+}
@@ -0,0 +1,575 @@
+package shard_test
+
+import (
+	"fmt"
+	"math/rand"
+	"net/rpc"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"remotelist/pkg"
+	"remotelist/pkg/shard"
+)
+
+// Roteador de shards com vários servidores RemoteList no mesmo processo, cada
+// um com seu disco e sua porta, e clientes falando com o roteador por TCP.
+
+const (
+	numLists = 500 // Listas criadas em cada teste
+	writers  = 8   // Clientes concorrentes em TestAddNodeUnderLoad
+)
+
+// start sobe n servidores e um roteador sobre eles.
+func (c *cluster) start(n int) error {
+	if err := c.addNodes(n); err != nil {
+		return err
+	}
+	return c.startRouter(shard.Config{})
+}
+
+// --- Verificações ---
+
+// fill cria numLists listas pelo roteador e devolve o conteúdo esperado.
+func fill(client *rpc.Client, r *rand.Rand) (map[string][]int, error) {
+	model := make(map[string][]int)
+	for i := 0; i < numLists; i++ {
+		id := fmt.Sprintf("lista-%04d", i)
+		for j := 0; j < 1+r.Intn(5); j++ {
+			v := r.Intn(1000)
+			if err := client.Call("RemoteList.Append", remotelist.AppendArgs{ListID: id, Value: v}, &remotelist.AppendReply{}); err != nil {
+				return nil, err
+			}
+			model[id] = append(model[id], v)
+		}
+	}
+	return model, nil
+}
+
+// verifyPlacement confere, direto em cada servidor, que cada lista está
+// só no dono dela e com o conteúdo esperado.
+func (c *cluster) verifyPlacement(model map[string][]int) error {
+	where := make(map[string][]string)
+	for _, n := range c.nodes {
+		var lists remotelist.ListsReply
+		if err := n.rl.Lists(remotelist.ListsArgs{}, &lists); err != nil {
+			return err
+		}
+		for _, id := range lists.ListIDs {
+			where[id] = append(where[id], n.addr)
+			var data remotelist.ExportListReply
+			if err := n.rl.Peer().ExportList(remotelist.ExportListArgs{ListID: id}, &data); err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(data.Values, model[id]) && !(len(data.Values) == 0 && len(model[id]) == 0) {
+				return fmt.Errorf("lista '%s' em %s: %v, esperado %v", id, n.addr, data.Values, model[id])
+			}
+		}
+	}
+	for id := range model {
+		owner := c.router.Owner(id)
+		if got := where[id]; len(got) != 1 || got[0] != owner {
+			return fmt.Errorf("lista '%s' está em %v, deveria estar só em %s", id, got, owner)
+		}
+	}
+	if len(where) != len(model) {
+		return fmt.Errorf("%d listas nos servidores, esperado %d", len(where), len(model))
+	}
+	return nil
+}
+
+// verifyThroughRouter lê todas as listas pelo roteador.
+func verifyThroughRouter(client *rpc.Client, model map[string][]int) error {
+	var lists remotelist.ListsReply
+	if err := client.Call("RemoteList.Lists", remotelist.ListsArgs{}, &lists); err != nil {
+		return err
+	}
+	want := make([]string, 0, len(model))
+	for id := range model {
+		want = append(want, id)
+	}
+	sort.Strings(want)
+	if !reflect.DeepEqual(lists.ListIDs, want) {
+		return fmt.Errorf("Lists devolveu %d listas, esperado %d", len(lists.ListIDs), len(want))
+	}
+	for id, data := range model {
+		var size remotelist.SizeReply
+		if err := client.Call("RemoteList.Size", remotelist.SizeArgs{ListID: id}, &size); err != nil {
+			return err
+		}
+		if size.Size != len(data) {
+			return fmt.Errorf("Size(%s) = %d, esperado %d", id, size.Size, len(data))
+		}
+		for i, v := range data {
+			var get remotelist.GetReply
+			if err := client.Call("RemoteList.Get", remotelist.GetArgs{ListID: id, Index: i}, &get); err != nil {
+				return err
+			}
+			if get.Value != v {
+				return fmt.Errorf("Get(%s, %d) = %d, esperado %d", id, i, get.Value, v)
+			}
+		}
+	}
+	return nil
+}
+
+// distribution conta as listas de cada servidor.
+func (c *cluster) distribution() []int {
+	var counts []int
+	for _, n := range c.nodes {
+		var lists remotelist.ListsReply
+		n.rl.Lists(remotelist.ListsArgs{}, &lists)
+		counts = append(counts, len(lists.ListIDs))
+	}
+	return counts
+}
+
+func TestPlacement(t *testing.T) {
+	c := newCluster(t)
+	if err := c.start(3); err != nil {
+		t.Fatal(err)
+	}
+	model, err := fill(c.client, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.verifyPlacement(model); err != nil {
+		t.Fatal(err)
+	}
+	for i, count := range c.distribution() {
+		// Com 64 nós virtuais, cada servidor fica longe de vazio e de tudo.
+		if count < numLists/6 || count > numLists*2/3 {
+			t.Fatalf("distribuição desequilibrada: %v", c.distribution())
+		}
+		t.Logf("Servidor %d: %d listas", i, count)
+	}
+	if err := verifyThroughRouter(c.client, model); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestErrors compara as respostas de borda do roteador com as de um servidor.
+func TestErrors(t *testing.T) {
+	c := newCluster(t)
+	if err := c.start(3); err != nil {
+		t.Fatal(err)
+	}
+	direct, err := rpc.Dial("tcp", c.nodes[0].addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer direct.Close()
+
+	// Uma lista vazia que existe em ambos.
+	for _, client := range []*rpc.Client{direct, c.client} {
+		client.Call("RemoteList.Append", remotelist.AppendArgs{ListID: "vazia", Value: 1}, &remotelist.AppendReply{})
+		client.Call("RemoteList.Remove", remotelist.RemoveArgs{ListID: "vazia"}, &remotelist.RemoveReply{})
+	}
+
+	calls := []struct {
+		method string
+		args   any
+		reply  func() any
+	}{
+		{"Get", remotelist.GetArgs{ListID: "nao-existe"}, func() any { return &remotelist.GetReply{} }},
+		{"Get", remotelist.GetArgs{ListID: "vazia", Index: 0}, func() any { return &remotelist.GetReply{} }},
+		{"Remove", remotelist.RemoveArgs{ListID: "nao-existe"}, func() any { return &remotelist.RemoveReply{} }},
+		{"Remove", remotelist.RemoveArgs{ListID: "vazia"}, func() any { return &remotelist.RemoveReply{} }},
+		{"Size", remotelist.SizeArgs{ListID: "nao-existe"}, func() any { return &remotelist.SizeReply{} }},
+		{"Size", remotelist.SizeArgs{ListID: "vazia"}, func() any { return &remotelist.SizeReply{} }},
+	}
+	for _, call := range calls {
+		want, got := call.reply(), call.reply()
+		wantErr := direct.Call("RemoteList."+call.method, call.args, want)
+		gotErr := c.client.Call("RemoteList."+call.method, call.args, got)
+		if fmt.Sprint(wantErr) != fmt.Sprint(gotErr) || !reflect.DeepEqual(want, got) {
+			t.Fatalf("%s(%+v): roteador (%v, %+v), servidor (%v, %+v)", call.method, call.args, gotErr, got, wantErr, want)
+		}
+	}
+}
+
+// TestAddNodeUnderLoad coloca dois servidores no anel com clientes escrevendo.
+// Cada cliente é o único a escrever nas suas listas, então sabe exatamente o
+// conteúdo delas e confere cada resposta.
+func TestAddNodeUnderLoad(t *testing.T) {
+	c := newCluster(t)
+	if err := c.start(3); err != nil {
+		t.Fatal(err)
+	}
+	model, err := fill(c.client, rand.New(rand.NewSource(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		stop     atomic.Bool
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		ops      atomic.Int64
+		maxLat   atomic.Int64
+	)
+	// Se o teste parar no meio, os clientes param antes do cluster fechar.
+	defer wg.Wait()
+	defer stop.Store(true)
+	ids := make([]string, 0, len(model))
+	for id := range model {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for w := 0; w < writers; w++ {
+		client, err := c.dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		// Listas do cliente w: as de índice w, w+writers, w+2*writers...
+		mine := make(map[string][]int)
+		for i := w; i < len(ids); i += writers {
+			mine[ids[i]] = model[ids[i]]
+		}
+		wg.Add(1)
+		go func(w int, mine map[string][]int) {
+			defer wg.Done()
+			err := runWriter(client, mine, int64(w), &stop, &ops, &maxLat)
+			mu.Lock()
+			if err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("cliente %d: %w", w, err)
+			}
+			for id, data := range mine {
+				model[id] = data
+			}
+			mu.Unlock()
+		}(w, mine)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		n, err := c.addServer(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		moved, err := c.router.AddNode(n.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("AddNode %s: %d listas migradas em %v", n.addr, moved, time.Since(start))
+		// Um servidor novo em um anel de k servidores recebe por volta de 1/k das listas.
+		k := len(c.nodes)
+		if moved < numLists/(3*k) || moved > numLists*3/k {
+			t.Fatalf("%d listas migradas para o servidor %d, esperado perto de %d", moved, k, numLists/k)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	stop.Store(true)
+	wg.Wait()
+	if firstErr != nil {
+		t.Fatal(firstErr)
+	}
+	t.Logf("%d operações durante as migrações, maior latência %v", ops.Load(), time.Duration(maxLat.Load()))
+
+	if err := c.verifyPlacement(model); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyThroughRouter(c.client, model); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runWriter faz operações aleatórias nas listas do cliente até stop,
+// conferindo cada resposta com o conteúdo esperado.
+func runWriter(client *rpc.Client, mine map[string][]int, seed int64, stop *atomic.Bool, ops, maxLat *atomic.Int64) error {
+	r := rand.New(rand.NewSource(seed))
+	ids := make([]string, 0, len(mine))
+	for id := range mine {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for !stop.Load() {
+		id := ids[r.Intn(len(ids))]
+		data := mine[id]
+		start := time.Now()
+		switch op := r.Intn(3); {
+		case op == 0 || len(data) == 0:
+			v := r.Intn(1000)
+			if err := client.Call("RemoteList.Append", remotelist.AppendArgs{ListID: id, Value: v}, &remotelist.AppendReply{}); err != nil {
+				return err
+			}
+			mine[id] = append(data, v)
+		case op == 1:
+			var reply remotelist.RemoveReply
+			if err := client.Call("RemoteList.Remove", remotelist.RemoveArgs{ListID: id}, &reply); err != nil {
+				return err
+			}
+			if reply.Value != data[len(data)-1] {
+				return fmt.Errorf("Remove(%s) = %d, esperado %d", id, reply.Value, data[len(data)-1])
+			}
+			mine[id] = data[:len(data)-1]
+		default:
+			i := r.Intn(len(data))
+			var reply remotelist.GetReply
+			if err := client.Call("RemoteList.Get", remotelist.GetArgs{ListID: id, Index: i}, &reply); err != nil {
+				return err
+			}
+			if reply.Value != data[i] {
+				return fmt.Errorf("Get(%s, %d) = %d, esperado %d", id, i, reply.Value, data[i])
+			}
+		}
+		ops.Add(1)
+		if lat := int64(time.Since(start)); lat > maxLat.Load() {
+			maxLat.Store(lat)
+		}
+	}
+	return nil
+}
+
+// TestRestart reinicia todos os servidores depois de uma migração: as cópias
+// (REPLACE) e remoções (DELETE) precisam ter ido para o WAL.
+func TestRestart(t *testing.T) {
+	c := newCluster(t)
+	if err := c.start(2); err != nil {
+		t.Fatal(err)
+	}
+	model, err := fill(c.client, rand.New(rand.NewSource(3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.addServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.router.AddNode(n.addr); err != nil {
+		t.Fatal(err)
+	}
+	// Um snapshot no meio: parte das operações de migração vai para ele,
+	// parte fica só no log.
+	if err := c.nodes[0].rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range c.nodes {
+		n.stop()
+		if err := n.start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.verifyPlacement(model); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyThroughRouter(c.client, model); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fillKeys cria pelo roteador n chaves de cada tipo que não é lista.
+func fillKeys(client *rpc.Client, n int) error {
+	for i := 0; i < n; i++ {
+		calls := []struct {
+			method      string
+			args, reply any
+		}{
+			{"SetAdd", remotelist.SetAddArgs{Key: fmt.Sprintf("set-%03d", i), Member: i}, &remotelist.SetAddReply{}},
+			{"SetAdd", remotelist.SetAddArgs{Key: fmt.Sprintf("set-%03d", i), Member: -i}, &remotelist.SetAddReply{}},
+			{"MapSet", remotelist.MapSetArgs{Key: fmt.Sprintf("map-%03d", i), Field: "f", Value: i}, &remotelist.MapSetReply{}},
+			{"CounterIncr", remotelist.CounterArgs{Key: fmt.Sprintf("cnt-%03d", i), By: i}, &remotelist.CounterReply{}},
+			{"SortedAdd", remotelist.SortedAddArgs{Key: fmt.Sprintf("zset-%03d", i), Member: i, Score: -i}, &remotelist.SortedAddReply{}},
+			{"SortedAdd", remotelist.SortedAddArgs{Key: fmt.Sprintf("zset-%03d", i), Member: 1000, Score: 0}, &remotelist.SortedAddReply{}},
+			{"StreamAppend", remotelist.StreamAppendArgs{Key: fmt.Sprintf("xs-%03d", i), Value: i}, &remotelist.StreamAppendReply{}},
+			{"StreamAppend", remotelist.StreamAppendArgs{Key: fmt.Sprintf("xs-%03d", i), Value: 2 * i}, &remotelist.StreamAppendReply{}},
+			{"StreamGroupCreate", remotelist.StreamGroupCreateArgs{Key: fmt.Sprintf("xs-%03d", i), Group: "g"}, &remotelist.StreamGroupCreateReply{}},
+			{"StreamReadGroup", remotelist.StreamReadGroupArgs{Key: fmt.Sprintf("xs-%03d", i), Group: "g", Consumer: "c", Count: 1}, &remotelist.StreamReadGroupReply{}},
+		}
+		for _, call := range calls {
+			if err := client.Call("RemoteList."+call.method, call.args, call.reply); err != nil {
+				return fmt.Errorf("%s: %w", call.method, err)
+			}
+		}
+	}
+	return nil
+}
+
+// dumpKeys lê todas as chaves pelo roteador, cada uma num texto.
+func dumpKeys(client *rpc.Client) (map[string]string, error) {
+	var keys remotelist.KeysReply
+	if err := client.Call("RemoteList.Keys", remotelist.KeysArgs{}, &keys); err != nil {
+		return nil, err
+	}
+	out := make(map[string]string)
+	for _, k := range keys.Keys {
+		var err error
+		switch k.Type {
+		case "list":
+			var reply remotelist.SizeReply
+			err = client.Call("RemoteList.Size", remotelist.SizeArgs{ListID: k.Key}, &reply)
+			out[k.Key] = fmt.Sprint("list ", reply.Size)
+		case "set":
+			var reply remotelist.SetMembersReply
+			err = client.Call("RemoteList.SetMembers", remotelist.SetMembersArgs{Key: k.Key}, &reply)
+			out[k.Key] = fmt.Sprint("set ", reply.Members)
+		case "map":
+			var reply remotelist.MapGetReply
+			err = client.Call("RemoteList.MapGet", remotelist.MapGetArgs{Key: k.Key, Field: "f"}, &reply)
+			out[k.Key] = fmt.Sprint("map ", reply.Found, reply.Value)
+		case "counter":
+			var reply remotelist.CounterGetReply
+			err = client.Call("RemoteList.CounterGet", remotelist.CounterGetArgs{Key: k.Key}, &reply)
+			out[k.Key] = fmt.Sprint("counter ", reply.Value)
+		case "sorted":
+			var reply remotelist.SortedRangeReply
+			err = client.Call("RemoteList.SortedRange", remotelist.SortedRangeArgs{Key: k.Key}, &reply)
+			out[k.Key] = fmt.Sprint("sorted ", reply.Items)
+		case "stream":
+			var read remotelist.StreamReadReply
+			var pending remotelist.StreamPendingReply
+			err = client.Call("RemoteList.StreamRead", remotelist.StreamReadArgs{Key: k.Key}, &read)
+			if err == nil {
+				err = client.Call("RemoteList.StreamPending", remotelist.StreamPendingArgs{Key: k.Key, Group: "g"}, &pending)
+			}
+			out[k.Key] = fmt.Sprint("stream ", len(read.Entries), read.Next, pending.Delivered, len(pending.Pending))
+			for _, e := range read.Entries {
+				out[k.Key] += fmt.Sprintf(" %d@%d=%d", e.ID, e.Time.UnixNano(), e.Value)
+			}
+		default:
+			err = fmt.Errorf("chave %s com tipo desconhecido %q", k.Key, k.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// verifyKeyPlacement confere, direto em cada servidor, que cada chave está só
+// no dono dela.
+func (c *cluster) verifyKeyPlacement(want map[string]string) error {
+	where := make(map[string][]string)
+	for _, n := range c.nodes {
+		var keys remotelist.KeysReply
+		if err := n.rl.Keys(remotelist.KeysArgs{}, &keys); err != nil {
+			return err
+		}
+		for _, k := range keys.Keys {
+			where[k.Key] = append(where[k.Key], n.addr)
+		}
+	}
+	for key := range want {
+		owner := c.router.Owner(key)
+		if got := where[key]; len(got) != 1 || got[0] != owner {
+			return fmt.Errorf("chave '%s' está em %v, deveria estar só em %s", key, got, owner)
+		}
+	}
+	if len(where) != len(want) {
+		return fmt.Errorf("%d chaves nos servidores, esperado %d", len(where), len(want))
+	}
+	return nil
+}
+
+// TestStructures migra chaves de todos os tipos: elas passam pelo roteador,
+// mudam de dono inteiras e sobrevivem ao reinício dos servidores.
+func TestStructures(t *testing.T) {
+	c := newCluster(t)
+	if err := c.start(2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fill(c.client, rand.New(rand.NewSource(4))); err != nil {
+		t.Fatal(err)
+	}
+	if err := fillKeys(c.client, 100); err != nil {
+		t.Fatal(err)
+	}
+	want, err := dumpKeys(c.client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != numLists+500 {
+		t.Fatalf("%d chaves, esperado %d", len(want), numLists+500)
+	}
+
+	n, err := c.addServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved, err := c.router.AddNode(n.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved < len(want)/6 {
+		t.Fatalf("só %d de %d chaves migraram", moved, len(want))
+	}
+	check := func(when string) {
+		t.Helper()
+		if err := c.verifyKeyPlacement(want); err != nil {
+			t.Fatalf("%s: %v", when, err)
+		}
+		got, err := dumpKeys(c.client)
+		if err != nil {
+			t.Fatalf("%s: %v", when, err)
+		}
+		for key, w := range want {
+			if got[key] != w {
+				t.Fatalf("%s: chave %s\n  tem    %s\n  devia  %s", when, key, got[key], w)
+			}
+		}
+	}
+	check("depois da migração")
+	for _, n := range c.nodes {
+		n.stop()
+		if err := n.start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check("depois do reinício")
+}
+
+// TestFailedMigration coloca no anel um servidor cujo disco falha em toda
+// escrita: a migração para no meio, as listas continuam acessíveis (no dono
+// antigo ou já no novo) e, com o disco de volta, Rebalance termina a migração.
+func TestFailedMigration(t *testing.T) {
+	c := newCluster(t)
+	if err := c.start(3); err != nil {
+		t.Fatal(err)
+	}
+	model, err := fill(c.client, rand.New(rand.NewSource(4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ffs := remotelist.NewFaultFS(remotelist.NewMemFS())
+	n, err := c.addServer(ffs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// As primeiras listas migram; depois o disco do servidor novo para de gravar.
+	ffs.Inject(remotelist.Fault{Op: remotelist.FaultWrite, Match: ".log.", Nth: 10, Sticky: true})
+	moved, err := c.router.AddNode(n.addr)
+	if err == nil {
+		t.Fatal("AddNode deveria falhar")
+	}
+	pending := c.router.Pending()
+	if pending == 0 {
+		t.Fatal("nenhuma lista ficou pendente")
+	}
+	t.Logf("Migração interrompida: %d listas movidas, %d pendentes (%v)", moved, pending, err)
+	if err := verifyThroughRouter(c.client, model); err != nil {
+		t.Fatalf("com migração pendente: %v", err)
+	}
+
+	ffs.Clear()
+	if err := c.router.Rebalance(); err != nil {
+		t.Fatal(err)
+	}
+	if c.router.Pending() != 0 {
+		t.Fatalf("%d listas ainda pendentes", c.router.Pending())
+	}
+	if err := c.verifyPlacement(model); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyThroughRouter(c.client, model); err != nil {
+		t.Fatal(err)
+	}
+}
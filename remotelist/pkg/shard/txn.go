@@ -0,0 +1,528 @@
+package shard
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"remotelist/pkg"
+)
+
+// --- Transações entre servidores (coordenador do commit em duas fases) ---
+//
+// Transact e Move alteram listas de servidores diferentes de forma atômica:
+//
+//  1. cada servidor envolvido prepara as suas operações (PrepareTx), gravando a
+//     intenção no WAL e segurando as listas; qualquer recusa aborta tudo;
+//  2. com todos preparados, a decisão COMMIT é gravada (com fsync) no log do
+//     coordenador e só então enviada aos servidores (CommitTx).
+//
+// O log do coordenador guarda apenas as decisões de COMMIT ainda não
+// confirmadas por todos os participantes (e um DONE quando são). Uma
+// transação sem COMMIT no log é abortada ("presumed abort"): é o que acontece
+// com as que estavam no meio quando o coordenador caiu.
+//
+// A recuperação roda ao iniciar e a cada RecoveryInterval: reenvia os COMMITs
+// pendentes (um participante fora do ar os recebe quando voltar, ainda com as
+// listas seguras) e aborta as transações deste coordenador que os servidores
+// dizem estar preparadas sem que haja decisão ou transação em andamento.
+
+// defaultRecoveryInterval é o intervalo padrão entre rodadas de recuperação.
+const defaultRecoveryInterval = 5 * time.Second
+
+type TransactArgs struct {
+	Ops []remotelist.TxOp
+}
+type TransactReply struct {
+	Values []int // Para cada operação: o valor retirado (REMOVE) ou inserido (APPEND)
+}
+
+// MoveArgs move o último elemento de From para o final de To.
+type MoveArgs struct {
+	From string
+	To   string
+}
+type MoveReply struct {
+	Value int
+}
+
+var (
+	errNoTxLog = errors.New("transações desabilitadas: configure o log do coordenador (TxLog)")
+	// errUncertain: a decisão pode ter chegado ao disco; a transação fica em
+	// aberto até a recuperação conseguir regravar o log.
+	errUncertain = errors.New("resultado da transação incerto; ela será resolvida pela recuperação")
+)
+
+// Transact executa as operações como uma transação: todas ou nenhuma.
+// As operações de um mesmo servidor são preparadas juntas, na ordem dada.
+func (r *Router) Transact(args TransactArgs, reply *TransactReply) error {
+	if r.coord == nil {
+		return errNoTxLog
+	}
+	if len(args.Ops) == 0 {
+		return errors.New("transação sem operações")
+	}
+	ids := make([]string, len(args.Ops))
+	for i, op := range args.Ops {
+		ids[i] = op.ListID
+	}
+	defer r.fenceLists(ids)()
+
+	var order []string
+	byNode := make(map[string][]int)
+	for i, op := range args.Ops {
+		node := r.Owner(op.ListID)
+		if _, ok := byNode[node]; !ok {
+			order = append(order, node)
+		}
+		byNode[node] = append(byNode[node], i)
+	}
+
+	t := r.coord.begin()
+	defer r.coord.end(t)
+	values := make([]int, len(args.Ops))
+	for _, node := range order {
+		ops := make([]remotelist.TxOp, len(byNode[node]))
+		for j, i := range byNode[node] {
+			ops[j] = args.Ops[i]
+		}
+		vals, err := r.coord.prepare(t, node, ops)
+		if err != nil {
+			r.coord.abort(t)
+			return err
+		}
+		for j, i := range byNode[node] {
+			values[i] = vals[j]
+		}
+	}
+	if err := r.coord.commit(t); err != nil {
+		return err
+	}
+	reply.Values = values
+	return nil
+}
+
+// Move retira o último elemento de From e o coloca no final de To, mesmo que
+// as listas estejam em servidores diferentes.
+func (r *Router) Move(args MoveArgs, reply *MoveReply) error {
+	if r.coord == nil {
+		return errNoTxLog
+	}
+	defer r.fenceLists([]string{args.From, args.To})()
+
+	t := r.coord.begin()
+	defer r.coord.end(t)
+	vals, err := r.coord.prepare(t, r.Owner(args.From), []remotelist.TxOp{{Op: "REMOVE", ListID: args.From}})
+	if err != nil {
+		r.coord.abort(t)
+		return err
+	}
+	// Se as duas listas estiverem no mesmo servidor, este prepare se soma ao anterior.
+	_, err = r.coord.prepare(t, r.Owner(args.To), []remotelist.TxOp{{Op: "APPEND", ListID: args.To, Value: vals[0]}})
+	if err != nil {
+		r.coord.abort(t)
+		return err
+	}
+	if err := r.coord.commit(t); err != nil {
+		return err
+	}
+	reply.Value = vals[0]
+	return nil
+}
+
+// fenceLists segura os locks de migração das listas (cada um uma vez, em
+// ordem) e devolve a função que os libera.
+func (r *Router) fenceLists(ids []string) func() {
+	var stripes []int
+	for _, id := range ids {
+		stripes = append(stripes, int(hashKey(id)%fenceStripes))
+	}
+	sort.Ints(stripes)
+	stripes = slices.Compact(stripes)
+	for _, s := range stripes {
+		r.fences[s].RLock()
+	}
+	return func() {
+		for _, s := range stripes {
+			r.fences[s].RUnlock()
+		}
+	}
+}
+
+// allNodes devolve os servidores do anel atual e do anterior (durante uma migração).
+func (r *Router) allNodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	nodes := r.ring.Nodes()
+	if r.prev != nil {
+		for _, n := range r.prev.Nodes() {
+			if !slices.Contains(nodes, n) {
+				nodes = append(nodes, n)
+			}
+		}
+	}
+	return nodes
+}
+
+// --- Coordenador ---
+
+// tx é uma transação em andamento.
+type tx struct {
+	id    string
+	parts []string // Servidores que podem ter preparado algo
+}
+
+type coordinator struct {
+	r        *Router
+	id       string
+	fsys     remotelist.FS
+	path     string
+	interval time.Duration
+
+	mu        sync.Mutex
+	logFile   remotelist.File
+	broken    bool                // Uma escrita no log falhou: regravar antes da próxima
+	counter   uint64              // Último número de transação usado
+	active    map[string]bool     // Transações em andamento (a recuperação não mexe nelas)
+	committed map[string][]string // COMMITs gravados e ainda não confirmados: transação -> participantes
+	uncertain map[string]bool     // Transações cujo COMMIT pode ou não estar no disco
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+func newCoordinator(r *Router, cfg Config) (*coordinator, error) {
+	c := &coordinator{
+		r:         r,
+		id:        cfg.CoordinatorID,
+		fsys:      cfg.FS,
+		path:      cfg.TxLog,
+		interval:  cfg.RecoveryInterval,
+		active:    make(map[string]bool),
+		committed: make(map[string][]string),
+		uncertain: make(map[string]bool),
+		done:      make(chan struct{}),
+	}
+	if c.id == "" {
+		c.id = "router"
+	}
+	// Os IDs das transações são "<coordenador>.<número>" e a recuperação
+	// reconhece as suas pelo prefixo: com um ponto no identificador, o
+	// coordenador "a" abortaria as transações do "a.1".
+	if err := remotelist.ValidTxID(c.id); err != nil || strings.Contains(c.id, ".") {
+		return nil, fmt.Errorf("identificador do coordenador inválido %q: não pode ter espaços nem pontos", c.id)
+	}
+	if c.fsys == nil {
+		c.fsys = remotelist.OSFS{}
+	}
+	if c.interval == 0 {
+		c.interval = defaultRecoveryInterval
+	}
+	// Os números só crescem, mesmo depois de reiniciar: um ID nunca é reusado.
+	c.counter = uint64(time.Now().UnixNano())
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	c.wg.Add(1)
+	go c.recoveryLoop()
+	return c, nil
+}
+
+func (c *coordinator) close() {
+	c.closeOnce.Do(func() { close(c.done) })
+	c.wg.Wait()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.logFile != nil {
+		c.logFile.Close()
+		c.logFile = nil
+	}
+}
+
+// begin registra uma transação nova.
+func (c *coordinator) begin() *tx {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counter++
+	t := &tx{id: fmt.Sprintf("%s.%d", c.id, c.counter)}
+	c.active[t.id] = true
+	return t
+}
+
+// end tira a transação da lista das em andamento (a não ser que o resultado
+// dela seja incerto).
+func (c *coordinator) end(t *tx) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.uncertain[t.id] {
+		delete(c.active, t.id)
+	}
+}
+
+// prepare envia PrepareTx ao servidor. Ele entra nos participantes mesmo se a
+// chamada falhar: o prepare pode ter acontecido sem a resposta chegar.
+func (c *coordinator) prepare(t *tx, node string, ops []remotelist.TxOp) ([]int, error) {
+	if !slices.Contains(t.parts, node) {
+		t.parts = append(t.parts, node)
+	}
+	var reply remotelist.PrepareTxReply
+	if err := c.r.call(node, "Peer.PrepareTx", remotelist.PrepareTxArgs{TxID: t.id, Ops: ops}, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Values, nil
+}
+
+// abort envia AbortTx aos participantes. Quem não receber é abortado depois
+// pela recuperação.
+func (c *coordinator) abort(t *tx) {
+	for _, node := range t.parts {
+		if err := c.r.call(node, "Peer.AbortTx", remotelist.AbortTxArgs{TxID: t.id}, &remotelist.AbortTxReply{}); err != nil {
+			log.Printf("Transação %s: abort não chegou a %s (fica para a recuperação): %v", t.id, node, err)
+		}
+	}
+}
+
+// commit grava a decisão e a envia aos participantes. Depois da decisão
+// gravada a transação está confirmada, mesmo que algum participante só a
+// receba pela recuperação.
+func (c *coordinator) commit(t *tx) error {
+	if err := c.decide(t); err != nil {
+		if !errors.Is(err, errUncertain) {
+			c.abort(t)
+		}
+		return err
+	}
+	c.deliver(t.id, t.parts)
+	return nil
+}
+
+// decide grava o COMMIT da transação.
+func (c *coordinator) decide(t *tx) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.appendLine(fmt.Sprintf("COMMIT %s %s\n", t.id, strings.Join(t.parts, " ")))
+	if err == nil {
+		c.committed[t.id] = t.parts
+		return nil
+	}
+	log.Printf("Erro crítico de persistência (COMMIT %s): %v", t.id, err)
+	// A linha pode ter chegado ao disco: só é seguro abortar depois de trocar
+	// o log por um sem ela.
+	if rerr := c.rewrite(); rerr != nil {
+		c.uncertain[t.id] = true
+		return fmt.Errorf("%w: %v", errUncertain, err)
+	}
+	return fmt.Errorf("falha ao gravar decisão da transação: %w", err)
+}
+
+// deliver envia CommitTx a todos os participantes; se todos confirmarem, a
+// decisão sai do log.
+func (c *coordinator) deliver(id string, parts []string) bool {
+	ok := true
+	for _, node := range parts {
+		if err := c.r.call(node, "Peer.CommitTx", remotelist.CommitTxArgs{TxID: id}, &remotelist.CommitTxReply{}); err != nil {
+			log.Printf("Transação %s: commit não chegou a %s (nova tentativa em %v): %v", id, node, c.interval, err)
+			ok = false
+		}
+	}
+	if !ok {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.appendLine(fmt.Sprintf("DONE %s\n", id)); err != nil {
+		// Sem o DONE, o COMMIT só é reenviado (e ignorado pelos participantes).
+		log.Printf("Transação %s confirmada, mas o DONE não foi gravado: %v", id, err)
+		return true
+	}
+	delete(c.committed, id)
+	return true
+}
+
+// --- Recuperação ---
+
+func (c *coordinator) recoveryLoop() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		c.recover()
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recover reenvia os COMMITs pendentes e aborta as transações preparadas
+// deste coordenador que não têm decisão nem estão em andamento.
+func (c *coordinator) recover() {
+	c.mu.Lock()
+	if len(c.uncertain) > 0 && c.rewrite() == nil {
+		// O log regravado não tem os COMMITs incertos: agora são abortadas.
+		for id := range c.uncertain {
+			delete(c.active, id)
+		}
+		c.uncertain = make(map[string]bool)
+	}
+	pending := make(map[string][]string, len(c.committed))
+	for id, parts := range c.committed {
+		pending[id] = parts
+	}
+	c.mu.Unlock()
+
+	for id, parts := range pending {
+		c.deliver(id, parts)
+	}
+
+	prefix := c.id + "."
+	for _, node := range c.r.allNodes() {
+		var reply remotelist.PreparedTxsReply
+		if err := c.r.call(node, "Peer.PreparedTxs", remotelist.PreparedTxsArgs{}, &reply); err != nil {
+			continue
+		}
+		for _, ptx := range reply.Txs {
+			if !strings.HasPrefix(ptx.ID, prefix) {
+				continue // De outro coordenador
+			}
+			c.mu.Lock()
+			_, decided := c.committed[ptx.ID]
+			busy := c.active[ptx.ID]
+			c.mu.Unlock()
+			if decided || busy {
+				continue
+			}
+			log.Printf("Transação %s sem decisão em %s: abortando.", ptx.ID, node)
+			if err := c.r.call(node, "Peer.AbortTx", remotelist.AbortTxArgs{TxID: ptx.ID}, &remotelist.AbortTxReply{}); err != nil {
+				log.Printf("Transação %s: abort não chegou a %s: %v", ptx.ID, node, err)
+			}
+		}
+	}
+}
+
+// --- Log do coordenador ---
+//
+// Uma linha por evento: "COMMIT <tx> <participante>..." ou "DONE <tx>". Ao
+// iniciar, o log é lido e regravado só com os COMMITs pendentes; uma última
+// linha incompleta (escrita interrompida) é descartada.
+
+// load lê o log e o compacta.
+func (c *coordinator) load() error {
+	data, err := c.readLog()
+	if err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		line, rest, complete := bytes.Cut(data, []byte("\n"))
+		if !complete {
+			log.Printf("Aviso: última linha do log do coordenador incompleta; descartando.")
+			break
+		}
+		data = rest
+		fields := strings.Fields(string(line))
+		switch {
+		case len(fields) >= 2 && fields[0] == "COMMIT":
+			c.committed[fields[1]] = fields[2:]
+		case len(fields) == 2 && fields[0] == "DONE":
+			delete(c.committed, fields[1])
+		case len(fields) > 0:
+			log.Printf("Aviso: linha inválida no log do coordenador: %q", line)
+		}
+	}
+	if len(c.committed) > 0 {
+		log.Printf("Coordenador: %d transações confirmadas a reenviar.", len(c.committed))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rewrite()
+}
+
+func (c *coordinator) readLog() ([]byte, error) {
+	f, err := c.fsys.OpenFile(c.path, os.O_RDONLY, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("falha ao abrir log do coordenador: %w", err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao ler log do coordenador: %w", err)
+	}
+	return data, nil
+}
+
+// appendLine acrescenta uma linha ao log e sincroniza. Deve ser chamado com c.mu.
+func (c *coordinator) appendLine(line string) error {
+	if c.broken {
+		if err := c.rewrite(); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(c.logFile, line)
+	if err == nil {
+		err = c.logFile.Sync()
+	}
+	if err != nil {
+		c.broken = true
+	}
+	return err
+}
+
+// rewrite troca o log por um com só os COMMITs pendentes: escreve ao lado,
+// sincroniza e renomeia por cima. Deve ser chamado com c.mu.
+func (c *coordinator) rewrite() error {
+	ids := make([]string, 0, len(c.committed))
+	for id := range c.committed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var b strings.Builder
+	for _, id := range ids {
+		fmt.Fprintf(&b, "COMMIT %s %s\n", id, strings.Join(c.committed[id], " "))
+	}
+
+	tmp := c.path + ".tmp"
+	f, err := c.fsys.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("falha ao regravar log do coordenador: %w", err)
+	}
+	_, err = io.WriteString(f, b.String())
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err == nil {
+		err = c.fsys.Rename(tmp, c.path)
+	}
+	if err == nil {
+		err = c.fsys.SyncDir(filepath.Dir(c.path))
+	}
+	if err != nil {
+		return fmt.Errorf("falha ao regravar log do coordenador: %w", err)
+	}
+	if c.logFile != nil {
+		c.logFile.Close()
+	}
+	c.logFile, err = c.fsys.OpenFile(c.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		c.logFile = nil
+		c.broken = true
+		return fmt.Errorf("falha ao abrir log do coordenador: %w", err)
+	}
+	c.broken = false
+	return nil
+}
@@ -0,0 +1,705 @@
+package shard_test
+
+import (
+	"fmt"
+	"math/rand"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"remotelist/pkg"
+	"remotelist/pkg/shard"
+)
+
+// Transações entre servidores: o commit em duas fases coordenado pelo
+// roteador e o lado dos participantes (PrepareTx, CommitTx e AbortTx do
+// RemoteList).
+
+const (
+	txClients        = 8   // Clientes concorrentes em TestTxConcurrent
+	txOps            = 200 // Transações por cliente em TestTxConcurrent
+	txLog            = "coord/tx.log"
+	recoveryInterval = 50 * time.Millisecond
+)
+
+// startTxRouter sobe o roteador com o log do coordenador em c.coord (um disco
+// em memória novo, se ainda não houver).
+func (c *cluster) startTxRouter() error {
+	if c.coord == nil {
+		mem := remotelist.NewMemFS()
+		mem.MkdirAll("coord", 0755)
+		c.coord = mem
+	}
+	return c.startRouter(shard.Config{
+		DialTimeout:      500 * time.Millisecond,
+		TxLog:            txLog,
+		FS:               c.coord,
+		RecoveryInterval: recoveryInterval,
+	})
+}
+
+// --- Auxiliares ---
+
+// contents devolve o conteúdo de todas as listas de todos os servidores.
+func (c *cluster) contents() (map[string][]int, error) {
+	out := make(map[string][]int)
+	for _, n := range c.nodes {
+		var lists remotelist.ListsReply
+		if err := n.rl.Lists(remotelist.ListsArgs{}, &lists); err != nil {
+			return nil, err
+		}
+		for _, id := range lists.ListIDs {
+			vals, err := values(n, id)
+			if err != nil {
+				return nil, err
+			}
+			out[id] = vals
+		}
+	}
+	return out, nil
+}
+
+// values lê a lista direto no servidor.
+func values(n *node, id string) ([]int, error) {
+	var size remotelist.SizeReply
+	if err := n.rl.Size(remotelist.SizeArgs{ListID: id}, &size); err != nil {
+		return nil, err
+	}
+	vals := make([]int, size.Size)
+	for i := range vals {
+		var get remotelist.GetReply
+		if err := n.rl.Get(remotelist.GetArgs{ListID: id, Index: i}, &get); err != nil {
+			return nil, err
+		}
+		vals[i] = get.Value
+	}
+	return vals, nil
+}
+
+func expectValues(n *node, id string, want []int) error {
+	got, err := values(n, id)
+	if err != nil {
+		return err
+	}
+	if len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+		return fmt.Errorf("lista '%s' em %s: %v, esperado %v", id, n.addr, got, want)
+	}
+	return nil
+}
+
+func appendDirect(n *node, id string, vals ...int) error {
+	for _, v := range vals {
+		if err := n.rl.Append(remotelist.AppendArgs{ListID: id, Value: v}, &remotelist.AppendReply{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expectLocked confere que uma escrita de fora na lista é recusada.
+func expectLocked(n *node, id string) error {
+	err := n.rl.Append(remotelist.AppendArgs{ListID: id, Value: 0}, &remotelist.AppendReply{})
+	if err == nil || !strings.Contains(err.Error(), "bloqueada") {
+		return fmt.Errorf("Append em '%s' (preparada): %v, esperado lista bloqueada", id, err)
+	}
+	return nil
+}
+
+func prepared(n *node) ([]remotelist.PreparedTx, error) {
+	var reply remotelist.PreparedTxsReply
+	err := n.rl.Peer().PreparedTxs(remotelist.PreparedTxsArgs{}, &reply)
+	return reply.Txs, err
+}
+
+// waitNoPrepared espera a recuperação decidir todas as transações dos servidores.
+func (c *cluster) waitNoPrepared() error {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		open := 0
+		for _, n := range c.nodes {
+			txs, err := prepared(n)
+			if err != nil {
+				return err
+			}
+			open += len(txs)
+		}
+		if open == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d transações ainda preparadas", open)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// --- Verificações ---
+
+// TestTxConcurrent faz Move e Transact concorrentes entre listas espalhadas
+// pelos servidores. Move não muda o conjunto de elementos; cada Transact
+// confirmado troca um elemento retirado por um novo. No fim, o conteúdo dos
+// servidores tem que ser exatamente o multiconjunto esperado, antes e depois
+// de todos caírem.
+func TestTxConcurrent(t *testing.T) {
+	c := newCluster(t)
+	if err := c.addNodes(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.startTxRouter(); err != nil {
+		t.Fatal(err)
+	}
+	const numLists = 12
+	ids := make([]string, numLists)
+	var mu sync.Mutex
+	want := make(map[int]int) // Valor -> quantidade
+	for i := range ids {
+		ids[i] = fmt.Sprintf("lista-%02d", i)
+		for j := 0; j < 5; j++ {
+			v := i*100 + j
+			if err := c.client.Call("RemoteList.Append", remotelist.AppendArgs{ListID: ids[i], Value: v}, &remotelist.AppendReply{}); err != nil {
+				t.Fatal(err)
+			}
+			want[v]++
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, txClients)
+	var committed, refused int64
+	for w := 0; w < txClients; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			client, err := rpc.Dial("tcp", c.rl.Addr().String())
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer client.Close()
+			r := rand.New(rand.NewSource(int64(w)))
+			for i := 0; i < txOps; i++ {
+				from, to := ids[r.Intn(numLists)], ids[r.Intn(numLists)]
+				if r.Intn(2) == 0 {
+					err = client.Call("RemoteList.Move", shard.MoveArgs{From: from, To: to}, &shard.MoveReply{})
+				} else {
+					fresh := 1_000_000 + w*10_000 + i
+					var reply shard.TransactReply
+					err = client.Call("RemoteList.Transact", shard.TransactArgs{Ops: []remotelist.TxOp{
+						{Op: "REMOVE", ListID: from},
+						{Op: "APPEND", ListID: to, Value: fresh},
+					}}, &reply)
+					if err == nil {
+						mu.Lock()
+						want[reply.Values[0]]--
+						want[fresh]++
+						mu.Unlock()
+					}
+				}
+				mu.Lock()
+				switch {
+				case err == nil:
+					committed++
+				case strings.Contains(err.Error(), "bloqueada") || strings.Contains(err.Error(), "vazia"):
+					refused++
+				default:
+					mu.Unlock()
+					errs <- fmt.Errorf("cliente %d: %v", w, err)
+					return
+				}
+				mu.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%d transações confirmadas, %d recusadas", committed, refused)
+	if committed == 0 {
+		t.Fatal("nenhuma transação confirmada")
+	}
+
+	check := func() error {
+		if err := c.waitNoPrepared(); err != nil {
+			return err
+		}
+		all, err := c.contents()
+		if err != nil {
+			return err
+		}
+		got := make(map[int]int)
+		for _, vals := range all {
+			for _, v := range vals {
+				got[v]++
+			}
+		}
+		for v, n := range want {
+			if got[v] != n {
+				return fmt.Errorf("valor %d aparece %d vezes, esperado %d", v, got[v], n)
+			}
+			delete(got, v)
+		}
+		for v, n := range got {
+			if n != 0 {
+				return fmt.Errorf("valor %d aparece %d vezes, esperado 0", v, n)
+			}
+		}
+		return nil
+	}
+	if err := check(); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range c.nodes {
+		n.crash()
+		if err := n.start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTxParticipantCrash prepara uma transação direto num servidor e o
+// derruba várias vezes antes de decidir.
+func TestTxParticipantCrash(t *testing.T) {
+	c := newCluster(t)
+	if err := c.addNodes(1); err != nil {
+		t.Fatal(err)
+	}
+	n := c.nodes[0]
+	if err := appendDirect(n, "a", 1, 2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendDirect(n, "b", 7); err != nil {
+		t.Fatal(err)
+	}
+
+	var prep remotelist.PrepareTxReply
+	err := n.rl.Peer().PrepareTx(remotelist.PrepareTxArgs{TxID: "t.1", Ops: []remotelist.TxOp{
+		{Op: "REMOVE", ListID: "a"},
+		{Op: "APPEND", ListID: "b", Value: 3},
+	}}, &prep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(prep.Values, []int{3, 3}) {
+		t.Fatalf("PrepareTx devolveu %v, esperado [3 3]", prep.Values)
+	}
+	// Um segundo prepare da mesma transação vê o efeito do primeiro.
+	err = n.rl.Peer().PrepareTx(remotelist.PrepareTxArgs{TxID: "t.1", Ops: []remotelist.TxOp{{Op: "REMOVE", ListID: "a"}}}, &prep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(prep.Values, []int{2}) {
+		t.Fatalf("segundo PrepareTx devolveu %v, esperado [2]", prep.Values)
+	}
+	// Outra transação não consegue as mesmas listas.
+	err = n.rl.Peer().PrepareTx(remotelist.PrepareTxArgs{TxID: "t.2", Ops: []remotelist.TxOp{{Op: "REMOVE", ListID: "b"}}}, &prep)
+	if err == nil || !strings.Contains(err.Error(), "bloqueada") {
+		t.Fatalf("PrepareTx concorrente: %v, esperado lista bloqueada", err)
+	}
+
+	wantTx := []remotelist.PreparedTx{{ID: "t.1", Ops: []remotelist.TxOp{
+		{Op: "REMOVE", ListID: "a"},
+		{Op: "APPEND", ListID: "b", Value: 3},
+		{Op: "REMOVE", ListID: "a"},
+	}}}
+	verifyPrepared := func(stage string) error {
+		txs, err := prepared(n)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(txs, wantTx) {
+			return fmt.Errorf("%s: transações preparadas %+v, esperado %+v", stage, txs, wantTx)
+		}
+		for _, id := range []string{"a", "b"} {
+			if err := expectLocked(n, id); err != nil {
+				return fmt.Errorf("%s: %w", stage, err)
+			}
+		}
+		if err := expectValues(n, "a", []int{1, 2, 3}); err != nil {
+			return fmt.Errorf("%s: %w", stage, err)
+		}
+		return expectValues(n, "b", []int{7})
+	}
+	if err := verifyPrepared("antes da queda"); err != nil {
+		t.Fatal(err)
+	}
+
+	n.crash()
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyPrepared("depois do replay"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Agora coberta por um snapshot (o PREPARE sai do WAL).
+	if err := appendDirect(n, "c", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendDirect(n, "c", 11); err != nil {
+		t.Fatal(err)
+	}
+	n.crash()
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyPrepared("depois do snapshot"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Abortar uma transação desconhecida não faz nada; confirmá-la é um erro.
+	if err := n.rl.Peer().AbortTx(remotelist.AbortTxArgs{TxID: "t.9"}, &remotelist.AbortTxReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.rl.Peer().CommitTx(remotelist.CommitTxArgs{TxID: "t.9"}, &remotelist.CommitTxReply{}); err == nil || !strings.Contains(err.Error(), "não está preparada") {
+		t.Fatalf("CommitTx de uma transação desconhecida: %v", err)
+	}
+	if err := n.rl.Peer().CommitTx(remotelist.CommitTxArgs{TxID: "t.1"}, &remotelist.CommitTxReply{}); err != nil {
+		t.Fatal(err)
+	}
+	// Repetir o COMMIT (como a recuperação faz) não aplica de novo.
+	if err := n.rl.Peer().CommitTx(remotelist.CommitTxArgs{TxID: "t.1"}, &remotelist.CommitTxReply{}); err != nil {
+		t.Fatal(err)
+	}
+	// Um espaço de qualquer tipo quebraria o registro no WAL.
+	for _, id := range []string{"t 9", "t\u00a09", "t\u20039", ""} {
+		err = n.rl.Peer().PrepareTx(remotelist.PrepareTxArgs{TxID: id, Ops: []remotelist.TxOp{{Op: "APPEND", ListID: "c", Value: 9}}}, &prep)
+		if err == nil || !strings.Contains(err.Error(), "identificador de transação inválido") {
+			t.Fatalf("PrepareTx(%q): %v", id, err)
+		}
+	}
+	// Contrariar a decisão, ou preparar de novo a mesma transação, não.
+	if err := n.rl.Peer().AbortTx(remotelist.AbortTxArgs{TxID: "t.1"}, &remotelist.AbortTxReply{}); err == nil || !strings.Contains(err.Error(), "já foi confirmada") {
+		t.Fatalf("AbortTx de uma transação confirmada: %v", err)
+	}
+	err = n.rl.Peer().PrepareTx(remotelist.PrepareTxArgs{TxID: "t.1", Ops: []remotelist.TxOp{{Op: "APPEND", ListID: "a", Value: 9}}}, &prep)
+	if err == nil || !strings.Contains(err.Error(), "já foi decidida") {
+		t.Fatalf("PrepareTx de uma transação confirmada: %v", err)
+	}
+
+	verifyCommitted := func(stage string) error {
+		txs, err := prepared(n)
+		if err != nil {
+			return err
+		}
+		if len(txs) != 0 {
+			return fmt.Errorf("%s: transações ainda preparadas: %+v", stage, txs)
+		}
+		for id, want := range map[string][]int{"a": {1}, "b": {7, 3}, "c": {10, 11}} {
+			if err := expectValues(n, id, want); err != nil {
+				return fmt.Errorf("%s: %w", stage, err)
+			}
+		}
+		return nil
+	}
+	if err := verifyCommitted("depois do commit"); err != nil {
+		t.Fatal(err)
+	}
+	n.crash()
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyCommitted("depois da queda"); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendDirect(n, "a", 5); err != nil {
+		t.Fatal(err)
+	}
+
+	// A decisão sobrevive ao snapshot que tira o COMMIT do WAL: o reenvio
+	// continua aceito, e o de uma transação desconhecida, não.
+	if err := n.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	n.crash()
+	if err := n.start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.rl.Peer().CommitTx(remotelist.CommitTxArgs{TxID: "t.1"}, &remotelist.CommitTxReply{}); err != nil {
+		t.Fatalf("CommitTx repetido depois do snapshot: %v", err)
+	}
+	if err := n.rl.Peer().CommitTx(remotelist.CommitTxArgs{TxID: "t.9"}, &remotelist.CommitTxReply{}); err == nil {
+		t.Fatal("CommitTx de uma transação desconhecida aceito depois do snapshot")
+	}
+	if err := expectValues(n, "a", []int{1, 5}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// O identificador do coordenador é o prefixo dos IDs das transações dele.
+func TestTxCoordinatorID(t *testing.T) {
+	for _, id := range []string{"r 1", "r\u00a01", "r.1"} {
+		_, err := shard.NewRouter(shard.Config{Nodes: []string{"127.0.0.1:1"}, TxLog: filepath.Join(t.TempDir(), "tx.log"), CoordinatorID: id})
+		if err == nil || !strings.Contains(err.Error(), "identificador do coordenador inválido") {
+			t.Errorf("NewRouter com o coordenador %q: %v", id, err)
+		}
+	}
+}
+
+// TestTxDecisionFailure faz o log do coordenador falhar no COMMIT de um Move.
+func TestTxDecisionFailure(t *testing.T) {
+	c := newCluster(t)
+	if err := c.addNodes(2); err != nil {
+		t.Fatal(err)
+	}
+	mem := remotelist.NewMemFS()
+	mem.MkdirAll("coord", 0755)
+	fault := remotelist.NewFaultFS(mem)
+	c.coord = fault
+	if err := c.startTxRouter(); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []int{1, 2} {
+		if err := c.client.Call("RemoteList.Append", remotelist.AppendArgs{ListID: "a", Value: v}, &remotelist.AppendReply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.client.Call("RemoteList.Append", remotelist.AppendArgs{ListID: "b", Value: 3}, &remotelist.AppendReply{}); err != nil {
+		t.Fatal(err)
+	}
+	owner := func(id string) *node {
+		for _, n := range c.nodes {
+			if n.addr == c.router.Owner(id) {
+				return n
+			}
+		}
+		return nil
+	}
+	unchanged := func() error {
+		if err := expectValues(owner("a"), "a", []int{1, 2}); err != nil {
+			return err
+		}
+		return expectValues(owner("b"), "b", []int{3})
+	}
+
+	// O fsync do COMMIT falha, mas o log pode ser regravado: aborta na hora.
+	fault.Inject(remotelist.Fault{Op: remotelist.FaultSync, Match: "tx.log"})
+	err := c.client.Call("RemoteList.Move", shard.MoveArgs{From: "a", To: "b"}, &shard.MoveReply{})
+	if err == nil || strings.Contains(err.Error(), "incerto") {
+		t.Fatalf("Move com falha no COMMIT: %v, esperado erro de gravação", err)
+	}
+	for _, n := range c.nodes {
+		if txs, _ := prepared(n); len(txs) != 0 {
+			t.Fatalf("transação não abortada em %s: %+v", n.addr, txs)
+		}
+	}
+	if err := unchanged(); err != nil {
+		t.Fatal(err)
+	}
+
+	// O disco continua falhando: o resultado fica incerto e as listas seguras.
+	fault.Inject(remotelist.Fault{Op: remotelist.FaultSync, Match: "tx.log", Sticky: true})
+	err = c.client.Call("RemoteList.Move", shard.MoveArgs{From: "a", To: "b"}, &shard.MoveReply{})
+	if err == nil || !strings.Contains(err.Error(), "incerto") {
+		t.Fatalf("Move com disco falhando: %v, esperado resultado incerto", err)
+	}
+	time.Sleep(3 * recoveryInterval)
+	if err := expectLocked(owner("a"), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Com o disco de volta, a recuperação regrava o log e aborta.
+	fault.Clear()
+	if err := c.waitNoPrepared(); err != nil {
+		t.Fatal(err)
+	}
+	if err := unchanged(); err != nil {
+		t.Fatal(err)
+	}
+	var move shard.MoveReply
+	if err := c.client.Call("RemoteList.Move", shard.MoveArgs{From: "a", To: "b"}, &move); err != nil {
+		t.Fatal(err)
+	}
+	if move.Value != 2 {
+		t.Fatalf("Move devolveu %d, esperado 2", move.Value)
+	}
+	if err := expectValues(owner("a"), "a", []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectValues(owner("b"), "b", []int{3, 2}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// prepareAcross prepara a transação id em dois servidores: REMOVE de "x" no
+// primeiro e APPEND do valor retirado em "y" no segundo.
+func (c *cluster) prepareAcross(id string) error {
+	if err := appendDirect(c.nodes[0], "x", 1, 2); err != nil {
+		return err
+	}
+	if err := appendDirect(c.nodes[1], "y", 5); err != nil {
+		return err
+	}
+	var prep remotelist.PrepareTxReply
+	if err := c.nodes[0].rl.Peer().PrepareTx(remotelist.PrepareTxArgs{TxID: id, Ops: []remotelist.TxOp{{Op: "REMOVE", ListID: "x"}}}, &prep); err != nil {
+		return err
+	}
+	return c.nodes[1].rl.Peer().PrepareTx(remotelist.PrepareTxArgs{TxID: id, Ops: []remotelist.TxOp{{Op: "APPEND", ListID: "y", Value: prep.Values[0]}}}, &prep)
+}
+
+// TestTxCoordinatorCrash simula um roteador que caiu entre os prepares e a
+// decisão: o novo roteador (mesmo ID, log sem COMMIT) aborta a transação, e
+// deixa em paz a de outro coordenador.
+func TestTxCoordinatorCrash(t *testing.T) {
+	c := newCluster(t)
+	if err := c.addNodes(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.prepareAcross("router.1"); err != nil {
+		t.Fatal(err)
+	}
+	var prep remotelist.PrepareTxReply
+	if err := c.nodes[0].rl.Peer().PrepareTx(remotelist.PrepareTxArgs{TxID: "outro.1", Ops: []remotelist.TxOp{{Op: "APPEND", ListID: "z", Value: 9}}}, &prep); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.startTxRouter(); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		txs0, err := prepared(c.nodes[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		txs1, err := prepared(c.nodes[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(txs0) == 1 && len(txs1) == 0 {
+			if txs0[0].ID != "outro.1" {
+				t.Fatalf("sobrou a transação %s, esperado outro.1", txs0[0].ID)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("transações ainda preparadas: %+v e %+v", txs0, txs1)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := expectValues(c.nodes[0], "x", []int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectValues(c.nodes[1], "y", []int{5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendDirect(c.nodes[0], "x", 3); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTxCommitRecovery simula um roteador que caiu logo depois de gravar o
+// COMMIT (com uma linha incompleta no fim do log) e um participante fora do
+// ar quando o novo roteador sobe.
+func TestTxCommitRecovery(t *testing.T) {
+	c := newCluster(t)
+	if err := c.addNodes(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.prepareAcross("router.1"); err != nil {
+		t.Fatal(err)
+	}
+	mem := remotelist.NewMemFS()
+	mem.MkdirAll("coord", 0755)
+	f, err := mem.OpenFile(txLog, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(f, "COMMIT router.1 %s %s\nCOMMIT router.2 %s", c.nodes[0].addr, c.nodes[1].addr, c.nodes[0].addr)
+	f.Sync()
+	f.Close()
+	c.coord = mem
+
+	down := c.nodes[1]
+	down.crash()
+	if err := c.startTxRouter(); err != nil {
+		t.Fatal(err)
+	}
+
+	// O servidor no ar recebe o COMMIT; o outro continua com a transação preparada.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		txs, err := prepared(c.nodes[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(txs) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("COMMIT não entregue a %s", c.nodes[0].addr)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := expectValues(c.nodes[0], "x", []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	// Deixa a recuperação tentar (e falhar) algumas vezes com o servidor fora.
+	time.Sleep(3 * recoveryInterval)
+
+	if err := down.start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectLocked(down, "y"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.waitNoPrepared(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectValues(down, "y", []int{5, 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Com todos confirmados, o próximo roteador começa com o log vazio.
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		data, err := mem.ReadFile(txLog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "DONE router.1") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("log do coordenador sem DONE: %q", data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.stopRouter()
+	if err := c.startTxRouter(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := mem.ReadFile(txLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0 {
+		t.Fatalf("log do coordenador depois de reiniciar: %q, esperado vazio", data)
+	}
+
+	all, err := c.contents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(all))
+	for id := range all {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if !reflect.DeepEqual(ids, []string{"x", "y"}) {
+		t.Fatalf("listas %v, esperado [x y]", ids)
+	}
+}
@@ -0,0 +1,690 @@
+package remotelist
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// --- Formato do snapshot ---
+//
+// Versão 2 (a atual): uma linha de cabeçalho "RLSNAP {json}", o corpo,
+// opcionalmente comprimido com gzip ou zstd, e uma linha final
+// "RLSNAP-END crc32c:<hex>" com o CRC-32C de tudo que vem antes dela
+// (cabeçalho e corpo como gravado). O corpo é uma sequência de objetos JSON,
+// um por linha: as listas em ordem de ID, divididas em blocos de até
+// snapshotChunk valores, e depois os objetos "meta" de cada chave com TTL,
+// itens agendados ou de outro tipo, um para cada transação preparada e as
+// últimas transações decididas, em blocos de snapshotChunk. O primeiro meta de
+// uma chave traz o prazo, o contador, o cabeçalho do stream e o primeiro bloco
+// de cada coleção; os membros, campos, itens e entradas seguintes vão em
+// metas de até snapshotChunk, que a leitura junta aos anteriores (um snapshot
+// de antes disso, com um meta só por chave, é lido do mesmo jeito). Tanto a
+// gravação quanto a leitura andam bloco a bloco, numa passada só, sem montar o
+// arquivo inteiro na memória.
+//
+// Versões anteriores, ainda lidas: a 1 é um único objeto JSON com
+// "next_segment", "last_seq", "created_at", "lists"...; a 0 é só o
+// map[string][]int das listas.
+
+const (
+	snapshotMagic   = "RLSNAP "
+	snapshotVersion = 2
+	// snapshotChunk é o máximo de valores de uma lista (ou de membros, campos,
+	// itens e entradas de outra chave) em cada linha do corpo.
+	snapshotChunk = 4096
+	// maxSnapshotHeader limita a linha de cabeçalho lida de um arquivo qualquer.
+	maxSnapshotHeader = 64 << 10
+	// snapshotTrailer começa a linha final, que tem sempre snapshotTrailerLen bytes.
+	snapshotTrailer    = "RLSNAP-END "
+	snapshotTrailerLen = len(snapshotTrailer) + len("crc32c:00000000\n")
+)
+
+// Compressões do corpo do snapshot (Config.SnapshotCompression).
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrSnapshotCorrupt indica um snapshot versionado que não pode ser lido:
+// cabeçalho inválido, arquivo truncado ou checksum que não confere.
+var ErrSnapshotCorrupt = errors.New("snapshot corrompido")
+
+// checkCompression valida o nome de uma compressão.
+func checkCompression(c string) error {
+	switch c {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return nil
+	}
+	return fmt.Errorf("compressão de snapshot desconhecida %q (use gzip ou zstd)", c)
+}
+
+// snapshotHeader é a primeira linha de um snapshot versionado.
+type snapshotHeader struct {
+	Version     int       `json:"version"`
+	NextSegment uint64    `json:"next_segment"`
+	LastSeq     uint64    `json:"last_seq"`
+	CreatedAt   time.Time `json:"created_at"`
+	Compression string    `json:"compression,omitempty"`
+}
+
+// snapshotRecord é uma linha do corpo: um bloco de uma lista ou um "meta".
+// Uma lista vazia é uma linha sem valores.
+type snapshotRecord struct {
+	List   string        `json:"list,omitempty"`
+	Values []int         `json:"values,omitempty"`
+	Meta   *snapshotMeta `json:"meta,omitempty"`
+}
+
+// snapshotMeta é a parte do snapshotState que não são listas. Cada registro
+// traz um bloco de uma chave (ver keyRecords), uma transação preparada ou um
+// bloco das decididas.
+type snapshotMeta struct {
+	Prepared   []PreparedTx             `json:"prepared,omitempty"`
+	Decided    []TxDecision             `json:"decided,omitempty"`
+	Expires    map[string]time.Time     `json:"expires,omitempty"`
+	Delayed    map[string][]DelayedItem `json:"delayed,omitempty"`
+	Structures                          // "sets", "maps", "counters"...
+}
+
+// metaKeys devolve, em ordem, as chaves de s que precisam de um registro meta.
+func (s *snapshotState) metaKeys() []string {
+	seen := make(map[string]bool)
+	add := func(id string) { seen[id] = true }
+	for id := range s.Expires {
+		add(id)
+	}
+	for id := range s.Delayed {
+		add(id)
+	}
+	for id := range s.Sets {
+		add(id)
+	}
+	for id := range s.Maps {
+		add(id)
+	}
+	for id := range s.Counters {
+		add(id)
+	}
+	for id := range s.Sorted {
+		add(id)
+	}
+	for id := range s.Streams {
+		add(id)
+	}
+	for _, v := range s.structs {
+		add(v.id)
+	}
+	ids := make([]string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// keyMeta devolve tudo o que a chave id tem fora das listas. Uma chave
+// fotografada por createSnapshot (views) só é copiada aqui, uma de cada vez.
+func (s *snapshotState) keyMeta(id string, views map[string]structView) *snapshotMeta {
+	m := &snapshotMeta{}
+	if t, ok := s.Expires[id]; ok {
+		m.Expires = map[string]time.Time{id: t}
+	}
+	if items, ok := s.Delayed[id]; ok {
+		m.Delayed = map[string][]DelayedItem{id: items}
+	}
+	if v, ok := views[id]; ok {
+		m.Structures = structuresOf([]structView{v})
+		return m
+	}
+	if v, ok := s.Sets[id]; ok {
+		m.Sets = map[string][]int{id: v}
+	}
+	if v, ok := s.Maps[id]; ok {
+		m.Maps = map[string]map[string]int{id: v}
+	}
+	if v, ok := s.Counters[id]; ok {
+		m.Counters = map[string]int{id: v}
+	}
+	if v, ok := s.Sorted[id]; ok {
+		m.Sorted = map[string][]ScoredMember{id: v}
+	}
+	if v, ok := s.Streams[id]; ok {
+		m.Streams = map[string]StreamState{id: v}
+	}
+	return m
+}
+
+// keyRecords divide o meta m da chave id nos registros gravados no corpo: o
+// primeiro com o prazo, o contador, o cabeçalho do stream e o primeiro bloco
+// de cada coleção, e um registro para cada bloco seguinte.
+func keyRecords(id string, m *snapshotMeta) []*snapshotMeta {
+	first := &snapshotMeta{Expires: m.Expires}
+	first.Counters = m.Counters
+	records := []*snapshotMeta{first}
+	next := func() *snapshotMeta {
+		r := &snapshotMeta{}
+		records = append(records, r)
+		return r
+	}
+	if items, ok := m.Delayed[id]; ok {
+		splitChunks(items, func(r *snapshotMeta, c []DelayedItem) { r.Delayed = map[string][]DelayedItem{id: c} }, first, next)
+	}
+	if members, ok := m.Sets[id]; ok {
+		splitChunks(members, func(r *snapshotMeta, c []int) { r.Sets = map[string][]int{id: c} }, first, next)
+	}
+	if hash, ok := m.Maps[id]; ok {
+		fields := make([]string, 0, len(hash))
+		for field := range hash {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		splitChunks(fields, func(r *snapshotMeta, c []string) {
+			part := make(map[string]int, len(c))
+			for _, field := range c {
+				part[field] = hash[field]
+			}
+			r.Maps = map[string]map[string]int{id: part}
+		}, first, next)
+	}
+	if items, ok := m.Sorted[id]; ok {
+		splitChunks(items, func(r *snapshotMeta, c []ScoredMember) { r.Sorted = map[string][]ScoredMember{id: c} }, first, next)
+	}
+	if st, ok := m.Streams[id]; ok {
+		first.Streams = map[string]StreamState{id: {Next: st.Next, Groups: st.Groups}}
+		splitChunks(st.Entries, func(r *snapshotMeta, c []StreamEntry) {
+			if r == first {
+				r.Streams[id] = StreamState{Next: st.Next, Groups: st.Groups, Entries: c}
+			} else {
+				r.Streams = map[string]StreamState{id: {Entries: c}}
+			}
+		}, first, next)
+	}
+	return records
+}
+
+// splitChunks põe em first o primeiro bloco de items (vazio, se items for) e
+// cada bloco seguinte num registro novo de next.
+func splitChunks[T any](items []T, put func(r *snapshotMeta, chunk []T), first *snapshotMeta, next func() *snapshotMeta) {
+	chunk := items[:min(len(items), snapshotChunk)]
+	put(first, chunk)
+	for items = items[len(chunk):]; len(items) > 0; items = items[len(chunk):] {
+		chunk = items[:min(len(items), snapshotChunk)]
+		put(next(), chunk)
+	}
+}
+
+// merge junta a s um registro meta lido do corpo. Os blocos de uma mesma chave
+// se somam aos já lidos.
+func (s *snapshotState) merge(m *snapshotMeta) {
+	s.Prepared = append(s.Prepared, m.Prepared...)
+	s.Decided = append(s.Decided, m.Decided...)
+	mergeMap(&s.Expires, m.Expires)
+	appendMap(&s.Delayed, m.Delayed)
+	appendMap(&s.Sets, m.Sets)
+	for id, hash := range m.Maps {
+		if s.Maps == nil {
+			s.Maps = make(map[string]map[string]int)
+		}
+		if s.Maps[id] == nil {
+			s.Maps[id] = make(map[string]int, len(hash))
+		}
+		for field, v := range hash {
+			s.Maps[id][field] = v
+		}
+	}
+	mergeMap(&s.Counters, m.Counters)
+	appendMap(&s.Sorted, m.Sorted)
+	for id, st := range m.Streams {
+		if s.Streams == nil {
+			s.Streams = make(map[string]StreamState)
+		}
+		cur := s.Streams[id]
+		cur.Entries = append(cur.Entries, st.Entries...)
+		if st.Next != 0 {
+			cur.Next = st.Next
+		}
+		if st.Groups != nil {
+			cur.Groups = st.Groups
+		}
+		s.Streams[id] = cur
+	}
+}
+
+// appendMap acrescenta cada bloco de src ao da mesma chave em dst (criando a
+// chave mesmo com um bloco vazio).
+func appendMap[V any](dst *map[string][]V, src map[string][]V) {
+	if len(src) == 0 {
+		return
+	}
+	if *dst == nil {
+		*dst = make(map[string][]V, len(src))
+	}
+	for k, v := range src {
+		(*dst)[k] = append((*dst)[k], v...)
+	}
+}
+
+func mergeMap[V any](dst *map[string]V, src map[string]V) {
+	if len(src) == 0 {
+		return
+	}
+	if *dst == nil {
+		*dst = make(map[string]V, len(src))
+	}
+	for k, v := range src {
+		(*dst)[k] = v
+	}
+}
+
+// namedView é uma lista fotografada por createSnapshot, gravada direto dos
+// blocos da ManagedList (sem a cópia de listValues).
+type namedView struct {
+	id   string
+	view listView
+}
+
+// forEachList chama fn com cada lista do snapshot, em ordem de ID e em blocos
+// de até snapshotChunk valores (uma chamada com chunk vazio para uma lista vazia).
+func (s *snapshotState) forEachList(fn func(id string, chunk []int) error) error {
+	if s.views == nil {
+		ids := make([]string, 0, len(s.Lists))
+		for id := range s.Lists {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			if err := eachChunk(id, [][]int{s.Lists[id]}, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	views := append([]namedView(nil), s.views...)
+	sort.Slice(views, func(i, j int) bool { return views[i].id < views[j].id })
+	for _, v := range views {
+		if err := eachChunk(v.id, v.view.chunks, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func eachChunk(id string, data [][]int, fn func(id string, chunk []int) error) error {
+	sent := false
+	for _, block := range data {
+		for len(block) > 0 {
+			chunk := block[:min(len(block), snapshotChunk)]
+			block = block[len(chunk):]
+			if err := fn(id, chunk); err != nil {
+				return err
+			}
+			sent = true
+		}
+	}
+	if !sent {
+		return fn(id, nil)
+	}
+	return nil
+}
+
+// encodeSnapshotBody grava o corpo de s em w.
+func encodeSnapshotBody(w io.Writer, s *snapshotState) error {
+	enc := json.NewEncoder(w)
+	err := s.forEachList(func(id string, chunk []int) error {
+		return enc.Encode(snapshotRecord{List: id, Values: chunk})
+	})
+	if err != nil {
+		return err
+	}
+	views := make(map[string]structView, len(s.structs))
+	for _, v := range s.structs {
+		views[v.id] = v
+	}
+	for _, id := range s.metaKeys() {
+		for _, m := range keyRecords(id, s.keyMeta(id, views)) {
+			if err := enc.Encode(snapshotRecord{Meta: m}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, tx := range s.Prepared {
+		if err := enc.Encode(snapshotRecord{Meta: &snapshotMeta{Prepared: []PreparedTx{tx}}}); err != nil {
+			return err
+		}
+	}
+	for decided := s.Decided; len(decided) > 0; {
+		chunk := decided[:min(len(decided), snapshotChunk)]
+		decided = decided[len(chunk):]
+		if err := enc.Encode(snapshotRecord{Meta: &snapshotMeta{Decided: chunk}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatChecksum(crc hash.Hash32) string {
+	return fmt.Sprintf("crc32c:%08x", crc.Sum32())
+}
+
+// compressor envolve w com a compressão c. Close termina o fluxo comprimido
+// (mas não fecha w).
+func compressor(w io.Writer, c string) (io.WriteCloser, error) {
+	switch c {
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return nil, checkCompression(c)
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// decompressor lê o corpo com a compressão c. Close libera o descompressor.
+func decompressor(r io.Reader, c string) (io.ReadCloser, error) {
+	switch c {
+	case CompressionNone:
+		return io.NopCloser(r), nil
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, checkCompression(c)
+}
+
+// writeSnapshotFile grava o snapshot de forma atômica: escreve em um arquivo
+// temporário, sincroniza e só então renomeia por cima do arquivo final.
+// O corpo é comprimido com compression.
+func writeSnapshotFile(fsys FS, path string, snapshotData snapshotState, compression string) error {
+	tempFile := path + ".tmp"
+	file, err := createFile(fsys, tempFile)
+	if err != nil {
+		return fmt.Errorf("falha ao criar arquivo temp de snapshot: %w", err)
+	}
+	fail := func(format string, err error) error {
+		file.Close()
+		fsys.Remove(tempFile)
+		return fmt.Errorf(format, err)
+	}
+
+	if err := writeSnapshotStream(file, &snapshotData, compression); err != nil {
+		return fail("falha ao gravar snapshot: %w", err)
+	}
+
+	if err := file.Sync(); err != nil {
+		return fail("falha ao sincronizar arquivo temp: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("falha ao fechar arquivo temp: %w", err)
+	}
+
+	if err := fsys.Rename(tempFile, path); err != nil {
+		return fmt.Errorf("falha ao renomear snapshot final: %w", err)
+	}
+	return syncDir(fsys, filepath.Dir(path))
+}
+
+// writeSnapshotStream grava o cabeçalho, o corpo comprimido e a linha final em w.
+func writeSnapshotStream(w io.Writer, s *snapshotState, compression string) error {
+	header, err := json.Marshal(snapshotHeader{
+		Version:     snapshotVersion,
+		NextSegment: s.NextSegment,
+		LastSeq:     s.LastSeq,
+		CreatedAt:   s.CreatedAt,
+		Compression: compression,
+	})
+	if err != nil {
+		return err
+	}
+	crc := crc32.New(castagnoli)
+	out := bufio.NewWriterSize(io.MultiWriter(w, crc), 64<<10)
+	out.WriteString(snapshotMagic)
+	out.Write(header)
+	out.WriteByte('\n')
+
+	zw, err := compressor(out, compression)
+	if err != nil {
+		return err
+	}
+	body := bufio.NewWriterSize(zw, 64<<10)
+	if err := encodeSnapshotBody(body, s); err != nil {
+		return fmt.Errorf("falha ao serializar (json) snapshot: %w", err)
+	}
+	if err := body.Flush(); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, snapshotTrailer+formatChecksum(crc)+"\n")
+	return err
+}
+
+// readSnapshot lê um snapshot de qualquer versão. As listas são entregues a
+// onList bloco a bloco, na ordem do arquivo (blocos seguidos da mesma lista
+// devem ser concatenados; chunk só vale durante a chamada); o resto do estado
+// volta em snapshotState, com Lists nil. O erro do checksum só aparece no fim,
+// então quem chama deve descartar o que recebeu se houver erro.
+func readSnapshot(r io.Reader, onList func(id string, chunk []int)) (snapshotState, error) {
+	raw := bufio.NewReaderSize(r, 64<<10)
+	if ok, err := hasSnapshotMagic(raw); err != nil {
+		return snapshotState{}, err
+	} else if !ok {
+		return decodeLegacySnapshot(raw, onList)
+	}
+	tr := &trailerReader{r: raw, n: snapshotTrailerLen, hash: crc32.New(castagnoli)}
+	br := bufio.NewReaderSize(tr, 64<<10)
+	header, err := readSnapshotHeader(br)
+	if err != nil {
+		return snapshotState{}, err
+	}
+	state := snapshotState{
+		Version:     header.Version,
+		NextSegment: header.NextSegment,
+		LastSeq:     header.LastSeq,
+		CreatedAt:   header.CreatedAt,
+		Compression: header.Compression,
+	}
+
+	zr, err := decompressor(br, header.Compression)
+	if err != nil {
+		return snapshotState{}, fmt.Errorf("%w: falha ao descomprimir: %w", ErrSnapshotCorrupt, err)
+	}
+	defer zr.Close()
+	dec := json.NewDecoder(zr)
+	var rec snapshotRecord
+	for {
+		rec.List, rec.Values, rec.Meta = "", rec.Values[:0], nil
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return snapshotState{}, fmt.Errorf("%w: falha ao decodificar o corpo: %w", ErrSnapshotCorrupt, err)
+		}
+		if rec.Meta != nil {
+			state.merge(rec.Meta)
+			continue
+		}
+		onList(rec.List, rec.Values) // Sem "list", é a lista de ID vazio
+	}
+
+	// O checksum cobre tudo até a linha final, inclusive o que o descompressor
+	// não precisou ler.
+	if _, err := io.Copy(io.Discard, br); err != nil {
+		return snapshotState{}, err
+	}
+	trailer := string(tr.buf)
+	checksum, ok := strings.CutPrefix(trailer, snapshotTrailer)
+	if len(trailer) != snapshotTrailerLen || !ok || !strings.HasSuffix(checksum, "\n") {
+		return snapshotState{}, fmt.Errorf("%w: arquivo sem a linha final (truncado)", ErrSnapshotCorrupt)
+	}
+	state.Checksum = strings.TrimSuffix(checksum, "\n")
+	if got := formatChecksum(tr.hash); got != state.Checksum {
+		return snapshotState{}, fmt.Errorf("%w: checksum %s na linha final, %s no arquivo", ErrSnapshotCorrupt, state.Checksum, got)
+	}
+	return state, nil
+}
+
+// trailerReader entrega tudo o que lê de r menos os últimos n bytes, que ficam
+// em buf quando r acaba. hash recebe o que foi entregue.
+type trailerReader struct {
+	r     io.Reader
+	n     int
+	hash  hash.Hash32
+	buf   []byte // Lido de r e ainda não entregue
+	chunk []byte
+	err   error
+}
+
+func (t *trailerReader) Read(p []byte) (int, error) {
+	if t.chunk == nil {
+		t.chunk = make([]byte, 32<<10)
+	}
+	for len(t.buf) <= t.n && t.err == nil {
+		m, err := t.r.Read(t.chunk)
+		t.buf = append(t.buf, t.chunk[:m]...)
+		t.err = err
+	}
+	if len(t.buf) <= t.n {
+		return 0, t.err
+	}
+	m := copy(p, t.buf[:len(t.buf)-t.n])
+	t.hash.Write(p[:m])
+	t.buf = t.buf[:copy(t.buf, t.buf[m:])]
+	return m, nil
+}
+
+// hasSnapshotMagic diz se br começa com o cabeçalho de um snapshot versionado.
+// É falso para os formatos antigos (nada é consumido de br).
+func hasSnapshotMagic(br *bufio.Reader) (bool, error) {
+	peek, err := br.Peek(len(snapshotMagic))
+	if bytes.Equal(peek, []byte(snapshotMagic)) {
+		return true, nil
+	}
+	// Um erro de leitura aqui (ErrDecrypt, por exemplo) não pode virar "formato antigo".
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return false, nil
+}
+
+// readSnapshotHeader lê a linha de cabeçalho de um snapshot versionado.
+func readSnapshotHeader(br *bufio.Reader) (header snapshotHeader, err error) {
+	line, err := br.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) || len(line) > maxSnapshotHeader {
+		return header, fmt.Errorf("%w: cabeçalho grande demais", ErrSnapshotCorrupt)
+	}
+	if err != nil {
+		return header, fmt.Errorf("%w: cabeçalho incompleto: %w", ErrSnapshotCorrupt, err)
+	}
+	if err := json.Unmarshal(line[len(snapshotMagic):], &header); err != nil {
+		return header, fmt.Errorf("%w: cabeçalho inválido: %w", ErrSnapshotCorrupt, err)
+	}
+	if header.Version < 2 || header.Version > snapshotVersion {
+		return header, fmt.Errorf("%w: versão %d não suportada (até %d)", ErrSnapshotCorrupt, header.Version, snapshotVersion)
+	}
+	if err := checkCompression(header.Compression); err != nil {
+		return header, fmt.Errorf("%w: %w", ErrSnapshotCorrupt, err)
+	}
+	return header, nil
+}
+
+// decodeLegacySnapshot lê os formatos sem cabeçalho (versões 0 e 1), que são
+// um único objeto JSON e por isso são lidos inteiros.
+func decodeLegacySnapshot(r io.Reader, onList func(id string, chunk []int)) (snapshotState, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return snapshotState{}, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return snapshotState{}, fmt.Errorf("falha ao decodificar (json) snapshot: %w", err)
+	}
+
+	var state snapshotState
+	// No formato 0 todo valor é um array; "next_segment" numérico só existe no 1.
+	if next, ok := raw["next_segment"]; ok && len(next) > 0 && next[0] != '[' {
+		if err := json.Unmarshal(content, &state); err != nil {
+			return snapshotState{}, fmt.Errorf("falha ao decodificar (json) snapshot: %w", err)
+		}
+		for id, values := range state.Lists {
+			onList(id, values)
+		}
+		state.Lists = nil
+		state.Version = 1
+		return state, nil
+	}
+
+	for id, data := range raw {
+		var values []int
+		if err := json.Unmarshal(data, &values); err != nil {
+			return snapshotState{}, fmt.Errorf("falha ao decodificar (json) snapshot: %w", err)
+		}
+		onList(id, values)
+	}
+	return state, nil
+}
+
+// decodeSnapshot lê um snapshot inteiro, com as listas em Lists.
+func decodeSnapshot(r io.Reader) (snapshotState, error) {
+	lists := make(map[string][]int)
+	state, err := readSnapshot(r, func(id string, chunk []int) {
+		lists[id] = append(lists[id], chunk...)
+	})
+	if err != nil {
+		return snapshotState{}, err
+	}
+	state.Lists = lists
+	return state, nil
+}
+
+// decodeSnapshotInfo lê só o cabeçalho de um snapshot (Lists fica nil e o
+// checksum, que fica no fim, não é lido). Os formatos antigos não têm
+// cabeçalho e são lidos inteiros.
+func decodeSnapshotInfo(r io.Reader) (snapshotState, error) {
+	br := bufio.NewReader(r)
+	if ok, err := hasSnapshotMagic(br); err != nil {
+		return snapshotState{}, err
+	} else if !ok {
+		return decodeLegacySnapshot(br, func(string, []int) {})
+	}
+	header, err := readSnapshotHeader(br)
+	if err != nil {
+		return snapshotState{}, err
+	}
+	return snapshotState{
+		Version:     header.Version,
+		NextSegment: header.NextSegment,
+		LastSeq:     header.LastSeq,
+		CreatedAt:   header.CreatedAt,
+		Compression: header.Compression,
+	}, nil
+}
@@ -0,0 +1,762 @@
+package remotelist_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"remotelist/pkg"
+)
+
+// Formato versionado do snapshot (cabeçalho, corpo em blocos e compressão e
+// linha final com checksum), com o RemoteList sobre um MemFS.
+
+const (
+	snapshotName = "remotelist.json"
+	snapMagic    = "RLSNAP "
+	snapTrailer  = "RLSNAP-END "
+)
+
+// trailerLine reconhece a linha final do snapshot.
+var trailerLine = regexp.MustCompile(snapTrailer + `crc32c:[0-9a-f]{8}\n$`)
+
+// newSnapHarness devolve um harness com o disco vazio, fechado no fim do
+// teste.
+func newSnapHarness(t *testing.T) *snapHarness {
+	mem := remotelist.NewMemFS()
+	mem.MkdirAll(dataDir, 0755)
+	h := &snapHarness{mem: mem}
+	t.Cleanup(h.close)
+	return h
+}
+
+var compressions = []string{remotelist.CompressionNone, remotelist.CompressionGzip, remotelist.CompressionZstd}
+
+// snapHarness guarda o servidor em teste e o sistema de arquivos dele.
+type snapHarness struct {
+	mem *remotelist.MemFS
+	rl  *remotelist.RemoteList
+}
+
+// open abre o servidor com a compressão dada e a chave key (nil = sem
+// criptografia).
+func (h *snapHarness) open(compression string, key []byte) error {
+	rl, err := remotelist.NewRemoteListWithConfig(remotelist.Config{
+		Dir: dataDir, FS: h.mem, SnapshotInterval: -1,
+		SnapshotCompression: compression, EncryptionKey: key,
+	})
+	if err != nil {
+		return err
+	}
+	h.rl = rl
+	return nil
+}
+
+func (h *snapHarness) close() {
+	if h.rl != nil {
+		h.rl.Close()
+		h.rl = nil
+	}
+}
+
+// restart fecha o servidor e o reabre.
+func (h *snapHarness) restart(compression string, key []byte) error {
+	h.close()
+	return h.open(compression, key)
+}
+
+// populate grava um pouco de cada tipo de chave. A lista "grande" ocupa
+// vários blocos do corpo e "vazia" não tem nenhum valor.
+func (h *snapHarness) populate() error {
+	rl := h.rl
+	for i := 0; i < 10000; i++ {
+		if err := rl.Append(remotelist.AppendArgs{ListID: "grande", Value: i * 7}, &remotelist.AppendReply{}); err != nil {
+			return err
+		}
+	}
+	if err := rl.Peer().ImportList(remotelist.ImportListArgs{ListID: "vazia"}, &remotelist.ImportListReply{}); err != nil {
+		return err
+	}
+	for _, v := range []int{3, -1, 42} {
+		if err := rl.Append(remotelist.AppendArgs{ListID: "temporaria", Value: v}, &remotelist.AppendReply{}); err != nil {
+			return err
+		}
+	}
+	if err := rl.SetTTL(remotelist.SetTTLArgs{ListID: "temporaria", TTL: time.Hour}, &remotelist.SetTTLReply{}); err != nil {
+		return err
+	}
+	if err := rl.Schedule(remotelist.ScheduleArgs{ListID: "temporaria", Value: 99, Delay: time.Hour}, &remotelist.ScheduleReply{}); err != nil {
+		return err
+	}
+	for _, m := range []int{5, 1, 9} {
+		if err := rl.SetAdd(remotelist.SetAddArgs{Key: "conjunto", Member: m}, &remotelist.SetAddReply{}); err != nil {
+			return err
+		}
+	}
+	if err := rl.MapSet(remotelist.MapSetArgs{Key: "mapa", Field: "f0", Value: 10}, &remotelist.MapSetReply{}); err != nil {
+		return err
+	}
+	return rl.CounterIncr(remotelist.CounterArgs{Key: "contador", By: 4}, &remotelist.CounterReply{})
+}
+
+// dump lê todas as chaves do servidor pelos métodos RPC.
+func (h *snapHarness) dump() (map[string]string, error) {
+	rl := h.rl
+	var keys remotelist.KeysReply
+	if err := rl.Keys(remotelist.KeysArgs{}, &keys); err != nil {
+		return nil, err
+	}
+	out := make(map[string]string)
+	for _, k := range keys.Keys {
+		switch k.Type {
+		case "list":
+			var view remotelist.ViewReply
+			if err := rl.View(remotelist.ViewArgs{ListID: k.Key, Limit: 100000}, &view); err != nil {
+				return nil, err
+			}
+			var ttl remotelist.TTLReply
+			if err := rl.TTL(remotelist.TTLArgs{ListID: k.Key}, &ttl); err != nil {
+				return nil, err
+			}
+			var scheduled remotelist.ScheduledReply
+			if err := rl.Scheduled(remotelist.ScheduledArgs{ListID: k.Key}, &scheduled); err != nil {
+				return nil, err
+			}
+			out[k.Key] = fmt.Sprintf("list %v ttl=%v %d", view.Values, ttl.HasTTL, ttl.Expires.UnixNano())
+			for _, item := range scheduled.Items {
+				out[k.Key] += fmt.Sprintf(" agendado %d %d %d", item.ID, item.Value, item.Due.UnixNano())
+			}
+		case "set":
+			var reply remotelist.SetMembersReply
+			if err := rl.SetMembers(remotelist.SetMembersArgs{Key: k.Key}, &reply); err != nil {
+				return nil, err
+			}
+			out[k.Key] = fmt.Sprint("set ", reply.Members)
+		case "map":
+			var reply remotelist.MapGetReply
+			if err := rl.MapGet(remotelist.MapGetArgs{Key: k.Key, Field: "f0"}, &reply); err != nil {
+				return nil, err
+			}
+			out[k.Key] = fmt.Sprint("map f0=", reply.Value)
+		case "counter":
+			var reply remotelist.CounterGetReply
+			if err := rl.CounterGet(remotelist.CounterGetArgs{Key: k.Key}, &reply); err != nil {
+				return nil, err
+			}
+			out[k.Key] = fmt.Sprint("counter ", reply.Value)
+		default:
+			return nil, fmt.Errorf("chave %s com tipo inesperado %q", k.Key, k.Type)
+		}
+	}
+	return out, nil
+}
+
+// same confere que o servidor tem o estado want.
+func (h *snapHarness) same(want map[string]string, when string) error {
+	got, err := h.dump()
+	if err != nil {
+		return fmt.Errorf("%s: %w", when, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		return fmt.Errorf("%s: estado diferente:\n  agora %.300v\n  antes %.300v", when, got, want)
+	}
+	return nil
+}
+
+// snapshotFile devolve o conteúdo do snapshot.
+func (h *snapHarness) snapshotFile() ([]byte, error) {
+	return h.mem.ReadFile(filepath.Join(dataDir, snapshotName))
+}
+
+// overwrite troca o conteúdo de um arquivo de dados.
+func (h *snapHarness) overwrite(name string, content []byte) error {
+	f, err := h.mem.OpenFile(filepath.Join(dataDir, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// header separa a linha de cabeçalho do corpo do snapshot.
+func header(content []byte) (map[string]any, []byte, error) {
+	if !bytes.HasPrefix(content, []byte(snapMagic)) {
+		return nil, nil, fmt.Errorf("snapshot não começa com %q: %.40q", snapMagic, content)
+	}
+	line, body, ok := bytes.Cut(content, []byte("\n"))
+	if !ok {
+		return nil, nil, errors.New("snapshot sem fim de linha no cabeçalho")
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(line[len(snapMagic):], &fields); err != nil {
+		return nil, nil, fmt.Errorf("cabeçalho: %w", err)
+	}
+	return fields, body, nil
+}
+
+// expectCorrupt confere que abrir o servidor falha com ErrSnapshotCorrupt e
+// que o snapshot continua com o conteúdo content.
+func (h *snapHarness) expectCorrupt(when, compression string, content []byte) error {
+	h.close()
+	err := h.open(compression, nil)
+	if err == nil {
+		return fmt.Errorf("%s: servidor abriu sem erro", when)
+	}
+	if !errors.Is(err, remotelist.ErrSnapshotCorrupt) {
+		return fmt.Errorf("%s: erro %v, esperado ErrSnapshotCorrupt", when, err)
+	}
+	now, err := h.snapshotFile()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(now, content) {
+		return fmt.Errorf("%s: o snapshot foi sobrescrito", when)
+	}
+	return nil
+}
+
+// TestSnapRoundTrip: cada compressão devolve o mesmo estado depois de reiniciar.
+func TestSnapRoundTrip(t *testing.T) {
+	h := newSnapHarness(t)
+	for _, c := range compressions {
+		h.close()
+		h.mem = remotelist.NewMemFS()
+		h.mem.MkdirAll(dataDir, 0755)
+		if err := h.open(c, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.populate(); err != nil {
+			t.Fatal(err)
+		}
+		want, err := h.dump()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := h.rl.Snapshot(); err != nil {
+			t.Fatalf("%q: Snapshot: %v", c, err)
+		}
+		if err := h.restart(c, nil); err != nil {
+			t.Fatalf("%q: %v", c, err)
+		}
+		if err := h.same(want, fmt.Sprintf("%q depois de reiniciar", c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestSnapHeader: o cabeçalho descreve o corpo, o arquivo termina com o
+// checksum, e o corpo comprimido é menor e não traz os registros em claro.
+func TestSnapHeader(t *testing.T) {
+	h := newSnapHarness(t)
+	sizes := make(map[string]int)
+	for _, c := range compressions {
+		h.close()
+		h.mem = remotelist.NewMemFS()
+		h.mem.MkdirAll(dataDir, 0755)
+		if err := h.open(c, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.populate(); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.rl.Snapshot(); err != nil {
+			t.Fatal(err)
+		}
+		content, err := h.snapshotFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields, body, err := header(content)
+		if err != nil {
+			t.Fatalf("%q: %v", c, err)
+		}
+		if fields["version"] != float64(2) {
+			t.Fatalf("%q: versão %v no cabeçalho", c, fields["version"])
+		}
+		if got, _ := fields["compression"].(string); got != c {
+			t.Fatalf("%q: compressão %q no cabeçalho", c, got)
+		}
+		if !trailerLine.Match(content) {
+			t.Fatalf("%q: snapshot sem a linha final: %q", c, content[max(0, len(content)-40):])
+		}
+		if plain := bytes.Contains(body, []byte(`"list":"grande"`)); plain != (c == remotelist.CompressionNone) {
+			t.Fatalf("%q: registros em claro no corpo: %v", c, plain)
+		}
+		sizes[c] = len(content)
+	}
+	for _, c := range compressions[1:] {
+		if sizes[c] >= sizes[remotelist.CompressionNone] {
+			t.Fatalf("snapshot %q com %d bytes, sem compressão %d", c, sizes[c], sizes[remotelist.CompressionNone])
+		}
+	}
+}
+
+// TestSnapSwitch: cada reinício lê o snapshot anterior e grava com outra
+// compressão.
+func TestSnapSwitch(t *testing.T) {
+	h := newSnapHarness(t)
+	order := []string{remotelist.CompressionGzip, remotelist.CompressionZstd, remotelist.CompressionNone, remotelist.CompressionGzip}
+	if err := h.open(order[0], nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.populate(); err != nil {
+		t.Fatal(err)
+	}
+	want, err := h.dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range order[1:] {
+		if err := h.rl.Snapshot(); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.restart(c, nil); err != nil {
+			t.Fatalf("reabrindo com %q: %v", c, err)
+		}
+		if err := h.same(want, fmt.Sprintf("reaberto com %q", c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestSnapLegacy: os formatos 0 e 1 continuam sendo lidos.
+func TestSnapLegacy(t *testing.T) {
+	h := newSnapHarness(t)
+	legacy := map[string]string{
+		"formato 0": `{"a":[1,2,3],"b":[]}`,
+		"formato 1": `{"next_segment":1,"last_seq":0,"created_at":"2024-01-02T03:04:05Z","lists":{"a":[1,2,3],"b":[]}}`,
+	}
+	for _, name := range []string{"formato 0", "formato 1"} {
+		h.close()
+		h.mem = remotelist.NewMemFS()
+		h.mem.MkdirAll(dataDir, 0755)
+		if err := h.overwrite(snapshotName, []byte(legacy[name])); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.open(remotelist.CompressionZstd, nil); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		want := map[string]string{
+			"a": fmt.Sprintf("list %v ttl=false %d", []int{1, 2, 3}, time.Time{}.UnixNano()),
+			"b": fmt.Sprintf("list %v ttl=false %d", []int(nil), time.Time{}.UnixNano()),
+		}
+		if err := h.same(want, name); err != nil {
+			t.Fatal(err)
+		}
+		// O log é aplicado por cima do snapshot antigo.
+		if err := h.rl.Append(remotelist.AppendArgs{ListID: "a", Value: 4}, &remotelist.AppendReply{}); err != nil {
+			t.Fatal(err)
+		}
+		want["a"] = fmt.Sprintf("list %v ttl=false %d", []int{1, 2, 3, 4}, time.Time{}.UnixNano())
+		if err := h.restart(remotelist.CompressionZstd, nil); err != nil {
+			t.Fatalf("%s, com log: %v", name, err)
+		}
+		if err := h.same(want, name+" com o log"); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.rl.Snapshot(); err != nil {
+			t.Fatal(err)
+		}
+		content, err := h.snapshotFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := header(content); err != nil {
+			t.Fatalf("%s, snapshot seguinte: %v", name, err)
+		}
+		if err := h.restart(remotelist.CompressionZstd, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.same(want, name+" convertido"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestSnapCorrupt: um byte trocado no corpo (que continua JSON válido) só é
+// percebido pelo checksum.
+func TestSnapCorrupt(t *testing.T) {
+	h := newSnapHarness(t)
+	if err := h.open(remotelist.CompressionNone, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.populate(); err != nil {
+		t.Fatal(err)
+	}
+	want, err := h.dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	h.close()
+	original, err := h.snapshotFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, body, err := header(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Troca um dígito de um valor no meio da lista grande.
+	at := len(original) - len(body) + len(body)/2
+	for original[at] < '1' || original[at] > '8' {
+		at++
+	}
+	tampered := append([]byte(nil), original...)
+	tampered[at]++
+	if err := h.overwrite(snapshotName, tampered); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expectCorrupt("dígito trocado", remotelist.CompressionNone, tampered); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.overwrite(snapshotName, original); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.open(remotelist.CompressionNone, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.same(want, "depois de desfazer a troca"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSnapMeta: cada chave que não é só uma lista tem o seu registro meta.
+func TestSnapMeta(t *testing.T) {
+	h := newSnapHarness(t)
+	if err := h.open(remotelist.CompressionNone, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.populate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := h.snapshotFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, body, err := header(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{"temporaria", "conjunto", "mapa", "contador"}
+	var metas []string
+	for _, line := range strings.Split(string(body), "\n") {
+		if strings.Contains(line, `"meta":`) {
+			metas = append(metas, line)
+		}
+	}
+	if len(metas) != len(keys) {
+		t.Fatalf("%d registros meta, esperado %d: %q", len(metas), len(keys), metas)
+	}
+	for _, line := range metas {
+		var found []string
+		for _, key := range keys {
+			if strings.Contains(line, `"`+key+`"`) {
+				found = append(found, key)
+			}
+		}
+		if len(found) != 1 {
+			t.Fatalf("registro meta com as chaves %v: %s", found, line)
+		}
+	}
+}
+
+// TestSnapChunkedKeys: uma chave grande de outro tipo vai em vários registros
+// meta de até 4096 elementos, que a leitura junta de volta.
+func TestSnapChunkedKeys(t *testing.T) {
+	h := newSnapHarness(t)
+	if err := h.open(remotelist.CompressionNone, nil); err != nil {
+		t.Fatal(err)
+	}
+	rl := h.rl
+	for i := 0; i < 9000; i++ {
+		if err := rl.SetAdd(remotelist.SetAddArgs{Key: "conjunto", Member: i * 3}, &remotelist.SetAddReply{}); err != nil {
+			t.Fatal(err)
+		}
+		if i >= 5000 {
+			continue
+		}
+		if err := rl.MapSet(remotelist.MapSetArgs{Key: "mapa", Field: fmt.Sprintf("f%d", i), Value: i}, &remotelist.MapSetReply{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := rl.SortedAdd(remotelist.SortedAddArgs{Key: "ranking", Member: i, Score: 9000 - i}, &remotelist.SortedAddReply{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := rl.StreamAppend(remotelist.StreamAppendArgs{Key: "eventos", Value: i}, &remotelist.StreamAppendReply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rl.SetTTL(remotelist.SetTTLArgs{ListID: "conjunto", TTL: time.Hour}, &remotelist.SetTTLReply{}); err != nil {
+		t.Fatal(err)
+	}
+	want, err := dumpStructs(rl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := h.snapshotFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, body, err := header(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := map[string]int{}
+	for _, line := range strings.Split(string(body), "\n") {
+		var rec struct {
+			Meta *struct {
+				Expires map[string]time.Time                 `json:"expires"`
+				Sets    map[string][]int                     `json:"sets"`
+				Maps    map[string]map[string]int            `json:"maps"`
+				Sorted  map[string][]remotelist.ScoredMember `json:"sorted"`
+				Streams map[string]remotelist.StreamState    `json:"streams"`
+			} `json:"meta"`
+		}
+		if json.Unmarshal([]byte(line), &rec) != nil || rec.Meta == nil {
+			continue
+		}
+		m := rec.Meta
+		sizes := map[string]int{}
+		for id, v := range m.Sets {
+			sizes[id] = len(v)
+		}
+		for id, v := range m.Maps {
+			sizes[id] = len(v)
+		}
+		for id, v := range m.Sorted {
+			sizes[id] = len(v)
+		}
+		for id, v := range m.Streams {
+			sizes[id] = len(v.Entries)
+		}
+		for id := range m.Expires {
+			if records[id] != 0 {
+				t.Fatalf("o prazo de %s fora do primeiro registro: %.200s", id, line)
+			}
+		}
+		if len(sizes) != 1 {
+			t.Fatalf("registro meta com %d chaves: %.200s", len(sizes), line)
+		}
+		for id, n := range sizes {
+			if n > 4096 {
+				t.Fatalf("registro de %s com %d elementos", id, n)
+			}
+			records[id]++
+		}
+	}
+	if want := map[string]int{"conjunto": 3, "mapa": 2, "ranking": 2, "eventos": 2}; !reflect.DeepEqual(records, want) {
+		t.Fatalf("registros meta por chave: %v, esperado %v", records, want)
+	}
+
+	if err := h.restart(remotelist.CompressionNone, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := dumpStructs(h.rl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatal("chaves diferentes depois de ler o snapshot em blocos")
+	}
+	var field remotelist.MapGetReply
+	if err := h.rl.MapGet(remotelist.MapGetArgs{Key: "mapa", Field: "f4999"}, &field); err != nil || !field.Found || field.Value != 4999 {
+		t.Fatalf("campo do último bloco do map: %+v, %v", field, err)
+	}
+	var ttl remotelist.TTLReply
+	if err := h.rl.TTL(remotelist.TTLArgs{ListID: "conjunto"}, &ttl); err != nil || !ttl.HasTTL {
+		t.Fatalf("prazo do set: %+v, %v", ttl, err)
+	}
+}
+
+// TestSnapMetaOldFormat: um snapshot de antes dos blocos, com a chave inteira
+// num só registro meta, continua sendo lido.
+func TestSnapMetaOldFormat(t *testing.T) {
+	h := newSnapHarness(t)
+	body := `{"list":"a","values":[1,2]}` + "\n" +
+		`{"meta":{"expires":{"s":"2999-01-01T00:00:00Z"},"sets":{"s":[1,5,9]}}}` + "\n" +
+		`{"meta":{"maps":{"m":{"f0":7,"f1":8}}}}` + "\n"
+	content := snapMagic + `{"version":2,"next_segment":1,"last_seq":0,"created_at":"2024-01-02T03:04:05Z"}` + "\n" + body
+	content += fmt.Sprintf("%scrc32c:%08x\n", snapTrailer, crc32.Checksum([]byte(content), crc32.MakeTable(crc32.Castagnoli)))
+	if err := h.overwrite(snapshotName, []byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.open(remotelist.CompressionNone, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := dumpStructs(h.rl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a": "list [1 2]", "s": "set [1 5 9]", "m": "map map[f0:7 f1:8]"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("snapshot antigo: %q, esperado %q", got, want)
+	}
+	var ttl remotelist.TTLReply
+	if err := h.rl.TTL(remotelist.TTLArgs{ListID: "s"}, &ttl); err != nil || !ttl.HasTTL {
+		t.Fatalf("prazo do set: %+v, %v", ttl, err)
+	}
+}
+
+// TestSnapHeaderChecksum: o checksum também cobre o cabeçalho.
+func TestSnapHeaderChecksum(t *testing.T) {
+	h := newSnapHarness(t)
+	if err := h.open(remotelist.CompressionGzip, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.populate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	h.close()
+	content, err := h.snapshotFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, _, err := header(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Um last_seq maior faria o replay pular registros do log.
+	seq := fmt.Sprintf(`"last_seq":%v,`, fields["last_seq"])
+	tampered := bytes.Replace(content, []byte(seq), []byte(fmt.Sprintf(`"last_seq":%v,`, fields["last_seq"].(float64)+5)), 1)
+	if bytes.Equal(tampered, content) {
+		t.Fatalf("cabeçalho sem %s", seq)
+	}
+	if err := h.overwrite(snapshotName, tampered); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expectCorrupt("last_seq trocado", remotelist.CompressionGzip, tampered); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSnapTruncated: o fim de um snapshot comprimido se perdeu, ou só a linha
+// final.
+func TestSnapTruncated(t *testing.T) {
+	h := newSnapHarness(t)
+	for _, c := range compressions {
+		h.close()
+		h.mem = remotelist.NewMemFS()
+		h.mem.MkdirAll(dataDir, 0755)
+		if err := h.open(c, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.populate(); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.rl.Snapshot(); err != nil {
+			t.Fatal(err)
+		}
+		h.close()
+		content, err := h.snapshotFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		end := trailerLine.FindIndex(content)
+		if end == nil {
+			t.Fatalf("%q: snapshot sem a linha final", c)
+		}
+		for _, cut := range [][]byte{content[:len(content)*2/3], content[:end[0]], content[:len(content)-1]} {
+			if err := h.overwrite(snapshotName, cut); err != nil {
+				t.Fatal(err)
+			}
+			if err := h.expectCorrupt(fmt.Sprintf("%q cortado em %d de %d bytes", c, len(cut), len(content)), c, cut); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// TestSnapVersion: um cabeçalho de uma versão futura não é lido como se fosse
+// a atual.
+func TestSnapVersion(t *testing.T) {
+	h := newSnapHarness(t)
+	if err := h.open(remotelist.CompressionNone, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.populate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	h.close()
+	content, err := h.snapshotFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	future := bytes.Replace(content, []byte(`"version":2`), []byte(`"version":3`), 1)
+	if bytes.Equal(future, content) {
+		t.Fatal(`cabeçalho sem "version":2`)
+	}
+	if err := h.overwrite(snapshotName, future); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expectCorrupt("versão 3", remotelist.CompressionNone, future); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSnapEncrypted: o corpo é comprimido antes de ser cifrado.
+func TestSnapEncrypted(t *testing.T) {
+	h := newSnapHarness(t)
+	if err := h.open(remotelist.CompressionZstd, key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.populate(); err != nil {
+		t.Fatal(err)
+	}
+	want, err := h.dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := h.snapshotFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.HasPrefix(content, []byte(snapMagic)) {
+		t.Fatal("cabeçalho do snapshot em claro com a criptografia ligada")
+	}
+	if err := h.restart(remotelist.CompressionZstd, key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.same(want, "depois de reiniciar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.restart(remotelist.CompressionGzip, key1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.same(want, "reaberto com gzip"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,452 @@
+package remotelist
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// --- Sorted sets (filas de prioridade e rankings) ---
+//
+// Uma chave do tipo "sorted" guarda membros inteiros, cada um com uma
+// pontuação, em ordem crescente de (pontuação, membro). SortedAdd coloca um
+// membro (ou muda a pontuação dele), SortedPopMin e SortedPopMax tiram os
+// primeiros ou os últimos, SortedRange lê uma faixa de pontuações e SortedRank
+// dá a posição de um membro.
+//
+// Os membros ficam numa skiplist com o tamanho de cada salto (span), o que deixa
+// inserção, remoção, posição e busca por pontuação em O(log n), e num map
+// membro → pontuação. A chave segue o modelo das outras (ver structures.go):
+// lock por chave, TTL, limites (cada membro é um elemento) e, no snapshot,
+// copy-on-write: a primeira escrita depois dele reconstrói a skiplist.
+//
+// No WAL: "ZADD <chave> <membro> <pontuação>" e "ZPOPMIN|ZPOPMAX <chave> <n>".
+// Como o REMOVE das listas, o pop não grava quem saiu: o replay sobre o mesmo
+// estado tira os mesmos membros.
+
+// ScoredMember é um membro de um sorted set com a sua pontuação.
+type ScoredMember struct {
+	Member int `json:"member"`
+	Score  int `json:"score"`
+}
+
+type SortedAddArgs struct {
+	Key    string
+	Member int
+	Score  int
+}
+type SortedAddReply struct {
+	Added bool // false se o membro já existia (e teve a pontuação trocada)
+	Token uint64
+}
+
+type SortedPopArgs struct {
+	Key   string
+	Count int // Quantos tirar (0 = 1); menos se o sorted set tiver menos membros
+}
+type SortedPopReply struct {
+	Items []ScoredMember // Na ordem em que saíram
+	Token uint64
+}
+
+type SortedRangeArgs struct {
+	Key     string
+	Min     *int // Menor pontuação (nil = sem limite)
+	Max     *int // Maior pontuação (nil = sem limite)
+	Reverse bool // Da maior pontuação para a menor
+	Offset  int
+	Limit   int // Tamanho da página (0 = defaultPageSize; no máximo maxPageSize)
+	Read    ReadOptions
+}
+type SortedRangeReply struct {
+	Items []ScoredMember
+	Total int // Membros com a pontuação na faixa, para paginar
+}
+
+type SortedRankArgs struct {
+	Key     string
+	Member  int
+	Reverse bool // Posição a partir da maior pontuação
+	Read    ReadOptions
+}
+type SortedRankReply struct {
+	Found bool
+	Rank  int // A partir de 0
+	Score int
+}
+
+// SortedAdd coloca o membro no sorted set 'key' com a pontuação dada, criando
+// o sorted set se preciso.
+func (rl *RemoteList) SortedAdd(args SortedAddArgs, reply *SortedAddReply) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	ml, err := rl.lockOrCreateKey(args.Key, KindSorted)
+	if err != nil {
+		return err
+	}
+	defer ml.mu.Unlock()
+	score, exists := ml.sorted.scores.get(args.Member)
+	if exists && score == args.Score {
+		return nil
+	}
+	if !exists {
+		if err := rl.reserveElements(args.Key, ml.size(), 1); err != nil {
+			return err
+		}
+	}
+	seq, err := rl.writeKey(ml, LogRecord{Op: "ZADD", ListID: args.Key, Value: args.Member, Score: args.Score})
+	if err != nil {
+		if !exists {
+			rl.releaseElements(1)
+		}
+		return err
+	}
+	reply.Added, reply.Token = !exists, seq
+	return nil
+}
+
+// SortedPopMin tira os membros de menor pontuação do sorted set 'key'.
+func (rl *RemoteList) SortedPopMin(args SortedPopArgs, reply *SortedPopReply) error {
+	return rl.popSorted(args, "ZPOPMIN", reply)
+}
+
+// SortedPopMax tira os membros de maior pontuação do sorted set 'key'.
+func (rl *RemoteList) SortedPopMax(args SortedPopArgs, reply *SortedPopReply) error {
+	return rl.popSorted(args, "ZPOPMAX", reply)
+}
+
+func (rl *RemoteList) popSorted(args SortedPopArgs, op string, reply *SortedPopReply) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	if args.Count < 0 {
+		return fmt.Errorf("quantidade inválida: %d", args.Count)
+	}
+	ml, err := rl.lockKey(args.Key, KindSorted)
+	if ml == nil || err != nil {
+		return err
+	}
+	defer ml.mu.Unlock()
+	n := min(max(args.Count, 1), ml.sorted.len())
+	if n == 0 {
+		return nil
+	}
+	// Os membros que vão sair, lidos antes: o registro só diz quantos.
+	reply.Items = ml.sorted.peek(n, op == "ZPOPMAX")
+	seq, err := rl.writeKey(ml, LogRecord{Op: op, ListID: args.Key, Value: n})
+	if err != nil {
+		reply.Items = nil
+		return err
+	}
+	rl.releaseElements(n)
+	reply.Token = seq
+	return nil
+}
+
+// SortedRange devolve uma página dos membros do sorted set 'key' com a
+// pontuação entre Min e Max.
+func (rl *RemoteList) SortedRange(args SortedRangeArgs, reply *SortedRangeReply) error {
+	if forwarded, err := rl.routeRead(args.Read, "SortedRange", args, reply); forwarded {
+		return err
+	}
+	if args.Offset < 0 || args.Limit < 0 {
+		return fmt.Errorf("página inválida: offset %d, limit %d", args.Offset, args.Limit)
+	}
+	if args.Min != nil && args.Max != nil && *args.Min > *args.Max {
+		return fmt.Errorf("intervalo vazio: mínimo %d maior que o máximo %d", *args.Min, *args.Max)
+	}
+	limit := args.Limit
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+	limit = min(limit, maxPageSize)
+
+	ml, err := rl.rlockKey(args.Key, KindSorted)
+	if ml == nil || err != nil {
+		return err
+	}
+	defer ml.mu.RUnlock()
+	sl := ml.sorted.list
+	// [first, end) são as posições dos membros na faixa.
+	first, end := 0, sl.length
+	if args.Min != nil {
+		first = sl.countBefore(*args.Min, false)
+	}
+	if args.Max != nil {
+		end = sl.countBefore(*args.Max, true)
+	}
+	reply.Total = max(end-first, 0)
+	n := min(limit, reply.Total-min(args.Offset, reply.Total))
+	if n == 0 {
+		return nil
+	}
+	reply.Items = make([]ScoredMember, 0, n)
+	if !args.Reverse {
+		for x := sl.at(first + args.Offset); len(reply.Items) < n; x = x.level[0].forward {
+			reply.Items = append(reply.Items, ScoredMember{Member: x.member, Score: x.score})
+		}
+	} else {
+		for x := sl.at(end - 1 - args.Offset); len(reply.Items) < n; x = x.backward {
+			reply.Items = append(reply.Items, ScoredMember{Member: x.member, Score: x.score})
+		}
+	}
+	return nil
+}
+
+// SortedRank informa a posição e a pontuação do membro no sorted set 'key'.
+func (rl *RemoteList) SortedRank(args SortedRankArgs, reply *SortedRankReply) error {
+	if forwarded, err := rl.routeRead(args.Read, "SortedRank", args, reply); forwarded {
+		return err
+	}
+	ml, err := rl.rlockKey(args.Key, KindSorted)
+	if ml == nil || err != nil {
+		return err
+	}
+	defer ml.mu.RUnlock()
+	score, ok := ml.sorted.scores.get(args.Member)
+	if !ok {
+		return nil
+	}
+	reply.Found, reply.Score = true, score
+	reply.Rank = ml.sorted.list.rank(args.Member, score)
+	if args.Reverse {
+		reply.Rank = ml.sorted.len() - 1 - reply.Rank
+	}
+	return nil
+}
+
+// --- sortedSet ---
+
+// sortedSet é o conteúdo de uma chave do tipo sorted. Um snapshot só vê as
+// pontuações (ver sortedItems): a skiplist nunca é compartilhada.
+type sortedSet struct {
+	scores *cowMap[int, int] // membro -> pontuação
+	list   *skiplist
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{scores: newCowMap[int, int](hashInteger[int]), list: newSkiplist()}
+}
+
+func (z *sortedSet) len() int { return z.list.length }
+
+// add coloca o membro com a pontuação dada (ou troca a pontuação dele).
+func (z *sortedSet) add(member, score int) {
+	if old, ok := z.scores.get(member); ok {
+		if old == score {
+			return
+		}
+		z.list.delete(member, old)
+	}
+	z.list.insert(member, score)
+	z.scores.set(member, score)
+}
+
+// peek devolve os n primeiros membros (ou os n últimos, com last).
+func (z *sortedSet) peek(n int, last bool) []ScoredMember {
+	out := make([]ScoredMember, 0, n)
+	x := z.list.head.level[0].forward
+	if last {
+		x = z.list.tail
+	}
+	for ; x != nil && len(out) < n; x = x.next(last) {
+		out = append(out, ScoredMember{Member: x.member, Score: x.score})
+	}
+	return out
+}
+
+// pop tira os n primeiros membros (ou os n últimos, com last).
+func (z *sortedSet) pop(n int, last bool) {
+	for _, it := range z.peek(n, last) {
+		z.list.delete(it.Member, it.Score)
+		z.scores.delete(it.Member)
+	}
+}
+
+// sortedItems ordena as pontuações fotografadas de um sorted set, como na
+// skiplist: por (pontuação, membro).
+func sortedItems(scores cowView[int, int]) []ScoredMember {
+	out := make([]ScoredMember, 0, scores.len())
+	scores.each(func(member, score int) { out = append(out, ScoredMember{Member: member, Score: score}) })
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score < out[j].Score
+		}
+		return out[i].Member < out[j].Member
+	})
+	return out
+}
+
+// --- skiplist ---
+
+// skipMaxLevel é a altura máxima de um nó: com p = 1/4, suficiente para 2^64
+// elementos.
+const skipMaxLevel = 32
+
+// skiplist mantém os membros em ordem de (pontuação, membro). Cada salto
+// guarda quantos elementos pula (span), para calcular posições.
+type skiplist struct {
+	head   *skipNode // Nó sentinela, sem membro, com skipMaxLevel níveis
+	tail   *skipNode
+	length int
+	level  int // Altura do nó mais alto
+}
+
+type skipNode struct {
+	member, score int
+	backward      *skipNode // Nó anterior no nível 0 (nil no primeiro)
+	level         []skipLevel
+}
+
+type skipLevel struct {
+	forward *skipNode
+	span    int
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{head: &skipNode{level: make([]skipLevel, skipMaxLevel)}, level: 1}
+}
+
+// before diz se o nó vem antes de (score, member).
+func (x *skipNode) before(score, member int) bool {
+	return x.score < score || (x.score == score && x.member < member)
+}
+
+func (x *skipNode) next(backward bool) *skipNode {
+	if backward {
+		return x.backward
+	}
+	return x.level[0].forward
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skipMaxLevel && rand.Intn(4) == 0 {
+		level++
+	}
+	return level
+}
+
+// insert coloca (member, score), que ainda não pode estar na lista.
+func (sl *skiplist) insert(member, score int) {
+	var update [skipMaxLevel]*skipNode
+	var rank [skipMaxLevel]int // Posição de update[i]
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	level := randomLevel()
+	for i := sl.level; i < level; i++ {
+		update[i] = sl.head
+		update[i].level[i].span = sl.length
+	}
+	sl.level = max(sl.level, level)
+
+	x = &skipNode{member: member, score: score, level: make([]skipLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < sl.level; i++ {
+		update[i].level[i].span++
+	}
+	if update[0] != sl.head {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
+}
+
+// delete tira (member, score) e diz se ele estava na lista.
+func (sl *skiplist) delete(member, score int) bool {
+	var update [skipMaxLevel]*skipNode
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+	for i := 0; i < sl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+	for sl.level > 1 && sl.head.level[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+	return true
+}
+
+// countBefore conta os membros com pontuação menor que score (ou menor ou
+// igual, com inclusive).
+func (sl *skiplist) countBefore(score int, inclusive bool) int {
+	n := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for f := x.level[i].forward; f != nil && (f.score < score || (inclusive && f.score == score)); f = x.level[i].forward {
+			n += x.level[i].span
+			x = f
+		}
+	}
+	return n
+}
+
+// rank é a posição (a partir de 0) de (member, score), que está na lista.
+func (sl *skiplist) rank(member, score int) int {
+	n := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for f := x.level[i].forward; f != nil && !(score < f.score || (score == f.score && member < f.member)); f = x.level[i].forward {
+			n += x.level[i].span
+			x = f
+		}
+	}
+	return n - 1
+}
+
+// at devolve o nó na posição i (a partir de 0), ou nil.
+func (sl *skiplist) at(i int) *skipNode {
+	if i < 0 || i >= sl.length {
+		return nil
+	}
+	traversed := 0
+	x := sl.head
+	for lvl := sl.level - 1; lvl >= 0; lvl-- {
+		for x.level[lvl].forward != nil && traversed+x.level[lvl].span <= i+1 {
+			traversed += x.level[lvl].span
+			x = x.level[lvl].forward
+		}
+		if traversed == i+1 {
+			return x
+		}
+	}
+	return nil
+}
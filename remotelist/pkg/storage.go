@@ -0,0 +1,153 @@
+package remotelist
+
+import (
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// --- Abstração de armazenamento ---
+//
+// Toda a persistência (log, snapshot, arquivo histórico) passa por um FS.
+// Em produção é o sistema de arquivos do SO (OSFS); MemFS e FaultFS permitem
+// exercitar quedas e falhas de disco sem tocar no disco de verdade.
+
+// File é um arquivo aberto pelo FS.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+	// Sync garante que o que foi escrito chegou ao armazenamento estável.
+	Sync() error
+}
+
+// FS é o conjunto mínimo de operações de arquivo usado pelo RemoteList.
+// Os erros de arquivo inexistente devem satisfazer errors.Is(err, fs.ErrNotExist).
+type FS interface {
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Remove(name string) error
+	Rename(oldpath, newpath string) error
+	Stat(name string) (fs.FileInfo, error)
+	MkdirAll(dir string, perm os.FileMode) error
+	// ReadDir devolve os nomes dos arquivos de dir, em ordem alfabética.
+	ReadDir(dir string) ([]string, error)
+	// SyncDir torna duráveis as criações, remoções e renomeações em dir.
+	SyncDir(dir string) error
+}
+
+// OSFS é o FS real, sobre o pacote os.
+type OSFS struct{}
+
+func (OSFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+func (OSFS) Remove(name string) error                    { return os.Remove(name) }
+func (OSFS) Rename(oldpath, newpath string) error        { return os.Rename(oldpath, newpath) }
+func (OSFS) Stat(name string) (fs.FileInfo, error)       { return os.Stat(name) }
+func (OSFS) MkdirAll(dir string, perm os.FileMode) error { return os.MkdirAll(dir, perm) }
+
+func (OSFS) ReadDir(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// SyncDir sincroniza o diretório para que criações e renomeações sobrevivam a uma queda.
+// Em sistemas que não suportam fsync de diretório (Windows) o erro é só logado.
+func (OSFS) SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		log.Printf("Aviso: fsync do diretório %s falhou: %v", dir, err)
+	}
+	return nil
+}
+
+// fsOrDefault devolve fsys, ou o OSFS se ele for nil.
+func fsOrDefault(fsys FS) FS {
+	if fsys == nil {
+		return OSFS{}
+	}
+	return fsys
+}
+
+// openRead abre um arquivo só para leitura.
+func openRead(fsys FS, name string) (File, error) {
+	return fsys.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// createFile cria (ou zera) um arquivo para escrita.
+func createFile(fsys FS, name string) (File, error) {
+	return fsys.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+// readFile lê um arquivo inteiro.
+func readFile(fsys FS, name string) ([]byte, error) {
+	f, err := openRead(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// writeFileSync grava um arquivo inteiro e o sincroniza.
+func writeFileSync(fsys FS, name string, content []byte) error {
+	f, err := createFile(fsys, name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir sincroniza o diretório dir ("" = diretório atual).
+func syncDir(fsys FS, dir string) error {
+	return fsys.SyncDir(dirOrDot(dir))
+}
+
+// moveFile move src para dst, copiando quando um rename não é possível
+// (ex: diretórios em discos diferentes).
+func moveFile(fsys FS, src, dst string) error {
+	if err := fsys.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := fsys.Rename(src, dst); err == nil {
+		return nil
+	}
+	content, err := readFile(fsys, src)
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(fsys, dst, content); err != nil {
+		return err
+	}
+	return fsys.Remove(src)
+}
+
+// sortedNames ordena nomes de arquivos (usado pelas implementações de ReadDir).
+func sortedNames(names []string) []string {
+	sort.Strings(names)
+	return names
+}
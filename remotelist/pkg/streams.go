@@ -0,0 +1,636 @@
+package remotelist
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// --- Streams ---
+//
+// Uma chave do tipo "stream" é um log de eventos só de acréscimo: cada
+// StreamAppend recebe do servidor um ID (1, 2, 3...; nunca reaproveitado, nem
+// depois de um corte) e o instante em que foi gravado, e a entrada não muda
+// mais. StreamRead lê a partir de um ID qualquer, sem estado no servidor.
+//
+// Um grupo de consumidores (StreamGroupCreate) entrega cada entrada a um só
+// consumidor do grupo (StreamReadGroup). A entrada fica pendente até o
+// consumidor confirmá-la (StreamAck); uma pendente parada há tempo demais pode
+// ser tomada por outro consumidor (StreamClaim). O offset confirmado de cada
+// consumidor é o maior ID que ele confirmou; o do grupo é o último ID antes da
+// primeira entrada ainda pendente (ver StreamPending).
+//
+// StreamTrim corta as entradas mais antigas, por quantidade ou por idade (e
+// StreamAppend pode cortar por quantidade na mesma chamada). As pendentes que
+// saem no corte deixam de estar pendentes.
+//
+// No WAL: "XADD <chave> <valor>", "XTRIM <chave> <primeiro ID mantido>",
+// "XGROUP <chave> <grupo> <último ID entregue>", "XREADGROUP <chave> <grupo>
+// <consumidor> <n>", "XACK <chave> <grupo> <IDs...>" e "XCLAIM <chave> <grupo>
+// <consumidor> <IDs...>". O instante de uma entrada, e o de cada entrega, é o
+// do registro: o replay e os backups chegam ao mesmo estado.
+
+type StreamEntry struct {
+	ID    uint64    `json:"id"`
+	Time  time.Time `json:"time"`
+	Value int       `json:"value"`
+}
+
+// PendingEntry é uma entrada entregue a um consumidor e ainda não confirmada.
+type PendingEntry struct {
+	ID         uint64    `json:"id"`
+	Consumer   string    `json:"consumer"`
+	Delivered  time.Time `json:"delivered"`  // Última entrega (StreamReadGroup ou StreamClaim)
+	Deliveries int       `json:"deliveries"` // Quantas vezes foi entregue
+}
+
+type StreamAppendArgs struct {
+	Key    string
+	Value  int
+	MaxLen int // Se > 0, antes corta as mais antigas para ficar com no máximo MaxLen
+}
+type StreamAppendReply struct {
+	ID    uint64
+	Token uint64
+}
+
+type StreamReadArgs struct {
+	Key   string
+	From  uint64 // Primeiro ID lido (0 = o mais antigo)
+	Count int    // 0 = defaultPageSize; no máximo maxPageSize
+	Read  ReadOptions
+}
+type StreamReadReply struct {
+	Entries []StreamEntry
+	First   uint64 // ID mais antigo ainda guardado (From menor que ele = entradas cortadas)
+	Next    uint64 // ID que a próxima entrada vai receber
+}
+
+type StreamTrimArgs struct {
+	Key    string
+	MaxLen int           // Se > 0, fica com no máximo MaxLen entradas
+	MaxAge time.Duration // Se > 0, corta as entradas mais velhas que isso
+}
+type StreamTrimReply struct {
+	Removed int
+	Token   uint64
+}
+
+type StreamGroupCreateArgs struct {
+	Key    string
+	Group  string
+	From   uint64 // Primeiro ID entregue ao grupo (0 = o mais antigo)
+	Latest bool   // Entrega só o que for acrescentado depois (ignora From)
+}
+type StreamGroupCreateReply struct {
+	Token uint64
+}
+
+type StreamReadGroupArgs struct {
+	Key      string
+	Group    string
+	Consumer string
+	Count    int // 0 = defaultPageSize; no máximo maxPageSize
+}
+type StreamReadGroupReply struct {
+	Entries []StreamEntry // Entradas novas para o grupo, agora pendentes com Consumer
+	Token   uint64
+}
+
+type StreamAckArgs struct {
+	Key   string
+	Group string
+	IDs   []uint64
+}
+type StreamAckReply struct {
+	Acked int // IDs que estavam pendentes
+	Token uint64
+}
+
+type StreamClaimArgs struct {
+	Key      string
+	Group    string
+	Consumer string
+	MinIdle  time.Duration // Só as pendentes entregues há pelo menos MinIdle
+	Count    int           // 0 = defaultPageSize; no máximo maxPageSize
+}
+type StreamClaimReply struct {
+	Entries []StreamEntry // As entradas tomadas, das mais antigas para as mais novas
+	Token   uint64
+}
+
+type StreamPendingArgs struct {
+	Key   string
+	Group string
+	Read  ReadOptions
+}
+type StreamPendingReply struct {
+	Pending   []PendingEntry    // Em ordem de ID
+	Consumers map[string]uint64 // Offset confirmado de cada consumidor
+	Delivered uint64            // Último ID entregue ao grupo
+	Committed uint64            // Offset confirmado do grupo
+}
+
+var errGroupNotFound = errors.New("grupo não encontrado")
+
+// StreamAppend acrescenta uma entrada ao stream 'key', criando o stream se preciso.
+func (rl *RemoteList) StreamAppend(args StreamAppendArgs, reply *StreamAppendReply) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	if args.MaxLen < 0 {
+		return fmt.Errorf("MaxLen inválido: %d", args.MaxLen)
+	}
+	ml, err := rl.lockOrCreateKey(args.Key, KindStream)
+	if err != nil {
+		return err
+	}
+	defer ml.mu.Unlock()
+	// Com MaxLen, o corte vem antes: a entrada nova já cabe no espaço liberado.
+	if args.MaxLen > 0 && ml.size() >= args.MaxLen {
+		if _, err := rl.trimStream(ml, args.Key, ml.stream.entries[ml.size()-args.MaxLen+1].ID); err != nil {
+			return err
+		}
+	}
+	if err := rl.reserveElements(args.Key, ml.size(), 1); err != nil {
+		return err
+	}
+	reply.ID = ml.stream.next
+	seq, err := rl.writeKey(ml, LogRecord{Op: "XADD", ListID: args.Key, Value: args.Value})
+	if err != nil {
+		rl.releaseElements(1)
+		return err
+	}
+	reply.Token = seq
+	return nil
+}
+
+// StreamRead lê as entradas do stream 'key' a partir de From.
+func (rl *RemoteList) StreamRead(args StreamReadArgs, reply *StreamReadReply) error {
+	if forwarded, err := rl.routeRead(args.Read, "StreamRead", args, reply); forwarded {
+		return err
+	}
+	count, err := pageCount(args.Count)
+	if err != nil {
+		return err
+	}
+	ml, err := rl.rlockKey(args.Key, KindStream)
+	if ml == nil || err != nil {
+		reply.First, reply.Next = 1, 1
+		return err
+	}
+	defer ml.mu.RUnlock()
+	s := ml.stream
+	reply.First, reply.Next = s.next, s.next
+	if len(s.entries) > 0 {
+		reply.First = s.entries[0].ID
+	}
+	i := s.index(args.From)
+	reply.Entries = append([]StreamEntry(nil), s.entries[i:min(i+count, len(s.entries))]...)
+	return nil
+}
+
+// StreamTrim corta as entradas mais antigas do stream 'key'.
+func (rl *RemoteList) StreamTrim(args StreamTrimArgs, reply *StreamTrimReply) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	if args.MaxLen < 0 || args.MaxAge < 0 {
+		return fmt.Errorf("corte inválido: MaxLen %d, MaxAge %v", args.MaxLen, args.MaxAge)
+	}
+	ml, err := rl.lockKey(args.Key, KindStream)
+	if ml == nil || err != nil {
+		return err
+	}
+	defer ml.mu.Unlock()
+	s := ml.stream
+	cut := 0 // Quantas entradas saem
+	if args.MaxLen > 0 {
+		cut = max(len(s.entries)-args.MaxLen, 0)
+	}
+	if args.MaxAge > 0 {
+		oldest := time.Now().Add(-args.MaxAge)
+		cut = max(cut, sort.Search(len(s.entries), func(i int) bool { return !s.entries[i].Time.Before(oldest) }))
+	}
+	if cut == 0 {
+		return nil
+	}
+	first := s.next
+	if cut < len(s.entries) {
+		first = s.entries[cut].ID
+	}
+	if reply.Token, err = rl.trimStream(ml, args.Key, first); err != nil {
+		return err
+	}
+	reply.Removed = cut
+	return nil
+}
+
+// trimStream grava e aplica o corte das entradas anteriores a first.
+func (rl *RemoteList) trimStream(ml *ManagedList, key string, first uint64) (uint64, error) {
+	before := ml.size()
+	seq, err := rl.writeKey(ml, LogRecord{Op: "XTRIM", ListID: key, Target: first})
+	if err != nil {
+		return 0, err
+	}
+	rl.releaseElements(before - ml.size())
+	return seq, nil
+}
+
+// StreamGroupCreate cria um grupo de consumidores no stream 'key', criando o
+// stream se preciso.
+func (rl *RemoteList) StreamGroupCreate(args StreamGroupCreateArgs, reply *StreamGroupCreateReply) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	if err := validName("grupo", args.Group); err != nil {
+		return err
+	}
+	ml, err := rl.lockOrCreateKey(args.Key, KindStream)
+	if err != nil {
+		return err
+	}
+	defer ml.mu.Unlock()
+	s := ml.stream
+	if _, exists := s.groups[args.Group]; exists {
+		return fmt.Errorf("o grupo '%s' já existe", args.Group)
+	}
+	delivered := max(args.From, 1) - 1
+	if args.Latest {
+		delivered = s.next - 1
+	}
+	reply.Token, err = rl.writeKey(ml, LogRecord{Op: "XGROUP", ListID: args.Key, Field: args.Group, Target: delivered})
+	return err
+}
+
+// StreamReadGroup entrega ao consumidor as próximas entradas do grupo.
+func (rl *RemoteList) StreamReadGroup(args StreamReadGroupArgs, reply *StreamReadGroupReply) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	count, err := pageCount(args.Count)
+	if err != nil {
+		return err
+	}
+	if err := validName("consumidor", args.Consumer); err != nil {
+		return err
+	}
+	ml, g, err := rl.lockGroup(args.Key, args.Group)
+	if err != nil {
+		return err
+	}
+	defer ml.mu.Unlock()
+	s := ml.stream
+	i := s.index(g.delivered + 1)
+	n := min(count, len(s.entries)-i)
+	if n == 0 {
+		return nil
+	}
+	entries := append([]StreamEntry(nil), s.entries[i:i+n]...)
+	seq, err := rl.writeKey(ml, LogRecord{Op: "XREADGROUP", ListID: args.Key, Field: args.Group, Consumer: args.Consumer, Value: n})
+	if err != nil {
+		return err
+	}
+	reply.Entries, reply.Token = entries, seq
+	return nil
+}
+
+// StreamAck confirma entradas pendentes do grupo.
+func (rl *RemoteList) StreamAck(args StreamAckArgs, reply *StreamAckReply) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	ml, g, err := rl.lockGroup(args.Key, args.Group)
+	if err != nil {
+		return err
+	}
+	defer ml.mu.Unlock()
+	var ids []int
+	for _, id := range args.IDs {
+		if _, ok := g.pending.get(id); ok && !containsID(ids, id) {
+			ids = append(ids, int(id))
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	seq, err := rl.writeKey(ml, LogRecord{Op: "XACK", ListID: args.Key, Field: args.Group, Values: ids})
+	if err != nil {
+		return err
+	}
+	reply.Acked, reply.Token = len(ids), seq
+	return nil
+}
+
+// StreamClaim passa ao consumidor as entradas pendentes do grupo que foram
+// entregues há pelo menos MinIdle, a qualquer consumidor.
+func (rl *RemoteList) StreamClaim(args StreamClaimArgs, reply *StreamClaimReply) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	count, err := pageCount(args.Count)
+	if err != nil {
+		return err
+	}
+	if err := validName("consumidor", args.Consumer); err != nil {
+		return err
+	}
+	ml, g, err := rl.lockGroup(args.Key, args.Group)
+	if err != nil {
+		return err
+	}
+	defer ml.mu.Unlock()
+	now := time.Now()
+	var ids []int
+	for _, p := range sortedPending(g.pending.each) {
+		if len(ids) == count {
+			break
+		}
+		if now.Sub(p.Delivered) >= args.MinIdle {
+			ids = append(ids, int(p.ID))
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	seq, err := rl.writeKey(ml, LogRecord{Op: "XCLAIM", ListID: args.Key, Field: args.Group, Consumer: args.Consumer, Values: ids})
+	if err != nil {
+		return err
+	}
+	s := ml.stream
+	for _, id := range ids {
+		reply.Entries = append(reply.Entries, s.entries[s.index(uint64(id))])
+	}
+	reply.Token = seq
+	return nil
+}
+
+// StreamPending mostra as entradas pendentes e os offsets confirmados do grupo.
+func (rl *RemoteList) StreamPending(args StreamPendingArgs, reply *StreamPendingReply) error {
+	if forwarded, err := rl.routeRead(args.Read, "StreamPending", args, reply); forwarded {
+		return err
+	}
+	ml, err := rl.rlockKey(args.Key, KindStream)
+	if err != nil {
+		return err
+	}
+	if ml == nil {
+		return errGroupNotFound
+	}
+	defer ml.mu.RUnlock()
+	g, ok := ml.stream.groups[args.Group]
+	if !ok {
+		return errGroupNotFound
+	}
+	reply.Pending = sortedPending(g.pending.each)
+	reply.Consumers = consumerOffsets(g.consumers.each)
+	reply.Delivered, reply.Committed = g.delivered, g.delivered
+	if len(reply.Pending) > 0 {
+		reply.Committed = reply.Pending[0].ID - 1
+	}
+	return nil
+}
+
+// lockGroup bloqueia para escrita o stream 'key' que tem o grupo.
+func (rl *RemoteList) lockGroup(key, group string) (*ManagedList, *streamGroup, error) {
+	ml, err := rl.lockKey(key, KindStream)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ml == nil {
+		return nil, nil, errGroupNotFound
+	}
+	g, ok := ml.stream.groups[group]
+	if !ok {
+		ml.mu.Unlock()
+		return nil, nil, errGroupNotFound
+	}
+	return ml, g, nil
+}
+
+// validName confere um nome de grupo ou de consumidor, que vai numa linha do WAL.
+func validName(what, name string) error {
+	if err := validField(name); err != nil {
+		return fmt.Errorf("%s inválido %q: não pode ser vazio nem ter espaços", what, name)
+	}
+	return nil
+}
+
+// pageCount aplica o padrão e o máximo a uma quantidade pedida.
+func pageCount(count int) (int, error) {
+	if count < 0 {
+		return 0, fmt.Errorf("quantidade inválida: %d", count)
+	}
+	if count == 0 {
+		return defaultPageSize, nil
+	}
+	return min(count, maxPageSize), nil
+}
+
+func containsID(ids []int, id uint64) bool {
+	for _, v := range ids {
+		if uint64(v) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// --- stream ---
+
+// stream é o conteúdo de uma chave do tipo stream.
+type stream struct {
+	// entries são as entradas guardadas, em ordem de ID. Elas só são
+	// acrescentadas no fim ou cortadas no início, nunca alteradas: um snapshot
+	// pode ver o mesmo array (ver capture).
+	entries []StreamEntry
+	next    uint64 // ID da próxima entrada
+	groups  map[string]*streamGroup
+}
+
+type streamGroup struct {
+	delivered uint64 // Último ID entregue
+	pending   *cowMap[uint64, PendingEntry]
+	consumers *cowMap[string, uint64] // Consumidor -> offset confirmado
+}
+
+func newStream() *stream {
+	return &stream{next: 1, groups: make(map[string]*streamGroup)}
+}
+
+func newStreamGroup(delivered uint64) *streamGroup {
+	return &streamGroup{
+		delivered: delivered,
+		pending:   newCowMap[uint64, PendingEntry](hashInteger[uint64]),
+		consumers: newCowMap[string, uint64](hashString),
+	}
+}
+
+// index é a posição da primeira entrada com ID >= id.
+func (s *stream) index(id uint64) int {
+	return sort.Search(len(s.entries), func(i int) bool { return s.entries[i].ID >= id })
+}
+
+// apply aplica um registro XADD, XTRIM, XGROUP, XREADGROUP, XACK ou XCLAIM.
+// Os dois últimos ignoram IDs que não estão pendentes.
+func (s *stream) apply(rec LogRecord) {
+	if rec.Op == "XADD" {
+		s.entries = append(s.entries, StreamEntry{ID: s.next, Time: rec.Time, Value: rec.Value})
+		s.next++
+		return
+	}
+	if rec.Op == "XTRIM" {
+		// Fatiar não copia; o próximo append que realocar leva só o que ficou.
+		s.entries = s.entries[s.index(rec.Target):]
+		for _, g := range s.groups {
+			var cut []uint64
+			g.pending.each(func(id uint64, _ PendingEntry) {
+				if id < rec.Target {
+					cut = append(cut, id)
+				}
+			})
+			for _, id := range cut {
+				g.pending.delete(id)
+			}
+		}
+		return
+	}
+	if rec.Op == "XGROUP" {
+		s.groups[rec.Field] = newStreamGroup(rec.Target)
+		return
+	}
+	g := s.groups[rec.Field]
+	if g == nil {
+		return
+	}
+	switch rec.Op {
+	case "XREADGROUP":
+		i := s.index(g.delivered + 1)
+		for _, e := range s.entries[i:min(i+rec.Value, len(s.entries))] {
+			g.pending.set(e.ID, PendingEntry{ID: e.ID, Consumer: rec.Consumer, Delivered: rec.Time, Deliveries: 1})
+			g.delivered = e.ID
+		}
+		if _, ok := g.consumers.get(rec.Consumer); !ok {
+			g.consumers.set(rec.Consumer, 0)
+		}
+	case "XACK":
+		for _, v := range rec.Values {
+			if p, ok := g.pending.get(uint64(v)); ok {
+				offset, _ := g.consumers.get(p.Consumer)
+				g.consumers.set(p.Consumer, max(offset, p.ID))
+				g.pending.delete(p.ID)
+			}
+		}
+	case "XCLAIM":
+		for _, v := range rec.Values {
+			if p, ok := g.pending.get(uint64(v)); ok {
+				p.Consumer, p.Delivered = rec.Consumer, rec.Time
+				p.Deliveries++
+				g.pending.set(p.ID, p)
+			}
+		}
+		if _, ok := g.consumers.get(rec.Consumer); !ok {
+			g.consumers.set(rec.Consumer, 0)
+		}
+	}
+}
+
+// check confere se o registro pode ser aplicado (o grupo existe, ou não existe
+// no XGROUP), para que o replay aponte um log inconsistente.
+func (s *stream) check(rec LogRecord) error {
+	switch rec.Op {
+	case "XADD", "XTRIM":
+		return nil
+	case "XGROUP":
+		if _, ok := s.groups[rec.Field]; ok {
+			return fmt.Errorf("XGROUP de grupo já existente ('%s')", rec.Field)
+		}
+		return nil
+	}
+	if _, ok := s.groups[rec.Field]; !ok {
+		return fmt.Errorf("%s em grupo inexistente ('%s')", rec.Op, rec.Field)
+	}
+	return nil
+}
+
+// sortedPending devolve as entradas pendentes percorridas por each (de um
+// grupo ou da visão dele), em ordem de ID.
+func sortedPending(each func(func(uint64, PendingEntry))) []PendingEntry {
+	out := []PendingEntry{}
+	each(func(_ uint64, p PendingEntry) { out = append(out, p) })
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// consumerOffsets copia os offsets percorridos por each.
+func consumerOffsets(each func(func(string, uint64))) map[string]uint64 {
+	out := make(map[string]uint64)
+	each(func(name string, offset uint64) { out[name] = offset })
+	return out
+}
+
+// streamView é um stream fotografado por capture.
+type streamView struct {
+	entries []StreamEntry
+	next    uint64
+	groups  map[string]groupView
+}
+
+type groupView struct {
+	delivered uint64
+	pending   cowView[uint64, PendingEntry]
+	consumers cowView[string, uint64]
+}
+
+// capture fotografa o stream sem copiar as entradas nem as pendências: as
+// entradas ficam no mesmo array, que só cresce depois do fim visto pela
+// fotografia, e as pendências e os offsets são cowMaps (ver cowMap.capture).
+func (s *stream) capture(share bool) streamView {
+	v := streamView{entries: s.entries, next: s.next, groups: make(map[string]groupView, len(s.groups))}
+	for name, g := range s.groups {
+		v.groups[name] = groupView{delivered: g.delivered, pending: g.pending.capture(share), consumers: g.consumers.capture(share)}
+	}
+	return v
+}
+
+// --- Snapshot ---
+
+// StreamState é um stream no snapshot e no estado enviado a um backup.
+type StreamState struct {
+	Next    uint64                `json:"next"`
+	Entries []StreamEntry         `json:"entries"`
+	Groups  map[string]GroupState `json:"groups,omitempty"`
+}
+
+type GroupState struct {
+	Delivered uint64            `json:"delivered"`
+	Pending   []PendingEntry    `json:"pending,omitempty"`
+	Consumers map[string]uint64 `json:"consumers,omitempty"`
+}
+
+func (v streamView) state() StreamState {
+	st := StreamState{Next: v.next, Entries: append([]StreamEntry{}, v.entries...)}
+	for name, g := range v.groups {
+		if st.Groups == nil {
+			st.Groups = make(map[string]GroupState)
+		}
+		st.Groups[name] = GroupState{Delivered: g.delivered, Pending: sortedPending(g.pending.each), Consumers: consumerOffsets(g.consumers.each)}
+	}
+	return st
+}
+
+func streamFromState(st StreamState) *stream {
+	s := &stream{entries: append([]StreamEntry(nil), st.Entries...), next: max(st.Next, 1), groups: make(map[string]*streamGroup)}
+	for name, gs := range st.Groups {
+		g := newStreamGroup(gs.Delivered)
+		for _, p := range gs.Pending {
+			g.pending.set(p.ID, p)
+		}
+		for name, offset := range gs.Consumers {
+			g.consumers.set(name, offset)
+		}
+		s.groups[name] = g
+	}
+	return s
+}
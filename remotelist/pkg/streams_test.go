@@ -0,0 +1,684 @@
+package remotelist_test
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"remotelist/pkg"
+)
+
+// Streams (ver streams.go): IDs, leituras, grupos de consumidores, claims e
+// cortes, com o RemoteList sobre um MemFS, que simula quedas.
+
+const streamOps = 2000 // Operações aleatórias em cada rodada
+
+// --- Estado ---
+
+// streamGroups são os grupos que streamWorkload cria; dumpStreams só os
+// encontra pelo nome.
+var streamGroups = []string{"g0", "g1"}
+
+// dumpStreams lê todos os streams do servidor pelos métodos RPC, com os
+// instantes em nanossegundos (o replay tem de reproduzi-los exatamente).
+func dumpStreams(rl *remotelist.RemoteList) (map[string]string, error) {
+	var keys remotelist.KeysReply
+	if err := rl.Keys(remotelist.KeysArgs{}, &keys); err != nil {
+		return nil, err
+	}
+	out := make(map[string]string)
+	for _, k := range keys.Keys {
+		if k.Type != "stream" {
+			return nil, fmt.Errorf("chave %s com tipo %q", k.Key, k.Type)
+		}
+		entries, first, next, err := readAll(rl, k.Key, 0)
+		if err != nil {
+			return nil, err
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "de %d, próximo %d:", first, next)
+		for _, e := range entries {
+			fmt.Fprintf(&b, " %d@%d=%d", e.ID, e.Time.UnixNano(), e.Value)
+		}
+		for _, g := range streamGroups {
+			var reply remotelist.StreamPendingReply
+			err := rl.StreamPending(remotelist.StreamPendingArgs{Key: k.Key, Group: g}, &reply)
+			if err != nil && err.Error() == "grupo não encontrado" {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, "\n  %s entregue %d, confirmado %d, consumidores %v, pendentes", g, reply.Delivered, reply.Committed, reply.Consumers)
+			for _, p := range reply.Pending {
+				fmt.Fprintf(&b, " %d:%s@%d#%d", p.ID, p.Consumer, p.Delivered.UnixNano(), p.Deliveries)
+			}
+		}
+		out[k.Key] = b.String()
+	}
+	return out, nil
+}
+
+// readAll lê o stream inteiro a partir de from, em páginas.
+func readAll(rl *remotelist.RemoteList, key string, from uint64) (entries []remotelist.StreamEntry, first, next uint64, err error) {
+	for {
+		var reply remotelist.StreamReadReply
+		if err := rl.StreamRead(remotelist.StreamReadArgs{Key: key, From: from, Count: 37}, &reply); err != nil {
+			return nil, 0, 0, err
+		}
+		first, next = reply.First, reply.Next
+		if len(reply.Entries) == 0 {
+			return entries, first, next, nil
+		}
+		entries = append(entries, reply.Entries...)
+		from = reply.Entries[len(reply.Entries)-1].ID + 1
+	}
+}
+
+// sameStreams compara o estado de dois servidores.
+func sameStreams(got, want map[string]string, when string) error {
+	if reflect.DeepEqual(got, want) {
+		return nil
+	}
+	for k, w := range want {
+		if got[k] != w {
+			return fmt.Errorf("%s: stream %s\n  tem    %s\n  devia  %s", when, k, got[k], w)
+		}
+	}
+	return fmt.Errorf("%s: chaves %d, esperado %d", when, len(got), len(want))
+}
+
+// expectStreams compara o servidor com um estado lido antes.
+func expectStreams(rl *remotelist.RemoteList, want map[string]string, when string) error {
+	got, err := dumpStreams(rl)
+	if err != nil {
+		return fmt.Errorf("%s: %w", when, err)
+	}
+	return sameStreams(got, want, when)
+}
+
+// convergeStreams espera o backup ficar igual ao estado lido do primário.
+func convergeStreams(backup *node, want map[string]string, when string) error {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := expectStreams(backup.rl, want, when)
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// streamWorkload faz n operações aleatórias em três streams, com os grupos g0
+// e g1 e os consumidores c0..c2.
+func streamWorkload(rl *remotelist.RemoteList, seed int64, n int) error {
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("x%d", r.Intn(3))
+		group := streamGroups[r.Intn(len(streamGroups))]
+		consumer := fmt.Sprintf("c%d", r.Intn(3))
+		var err error
+		switch op := r.Intn(10); {
+		case op < 4:
+			args := remotelist.StreamAppendArgs{Key: key, Value: r.Intn(1000)}
+			if r.Intn(10) == 0 {
+				args.MaxLen = 20 + r.Intn(20)
+			}
+			err = rl.StreamAppend(args, &remotelist.StreamAppendReply{})
+		case op == 4:
+			err = rl.StreamGroupCreate(remotelist.StreamGroupCreateArgs{Key: key, Group: group, From: uint64(r.Intn(5))}, &remotelist.StreamGroupCreateReply{})
+			if err != nil && strings.Contains(err.Error(), "já existe") {
+				err = nil
+			}
+		case op < 7:
+			err = rl.StreamReadGroup(remotelist.StreamReadGroupArgs{Key: key, Group: group, Consumer: consumer, Count: 1 + r.Intn(4)}, &remotelist.StreamReadGroupReply{})
+		case op == 7:
+			var p remotelist.StreamPendingReply
+			if err = rl.StreamPending(remotelist.StreamPendingArgs{Key: key, Group: group}, &p); err == nil {
+				var ids []uint64
+				for _, e := range p.Pending {
+					if r.Intn(2) == 0 {
+						ids = append(ids, e.ID)
+					}
+				}
+				err = rl.StreamAck(remotelist.StreamAckArgs{Key: key, Group: group, IDs: append(ids, 999999)}, &remotelist.StreamAckReply{})
+			}
+		case op == 8:
+			err = rl.StreamClaim(remotelist.StreamClaimArgs{Key: key, Group: group, Consumer: consumer, Count: 1 + r.Intn(3)}, &remotelist.StreamClaimReply{})
+		default:
+			err = rl.StreamTrim(remotelist.StreamTrimArgs{Key: key, MaxLen: 10 + r.Intn(30)}, &remotelist.StreamTrimReply{})
+		}
+		if err != nil && err.Error() != "grupo não encontrado" {
+			return fmt.Errorf("operação %d em %s: %w", i, key, err)
+		}
+	}
+	return nil
+}
+
+// --- Cenários ---
+
+func appendValues(rl *remotelist.RemoteList, key string, values ...int) ([]uint64, error) {
+	var ids []uint64
+	for _, v := range values {
+		var reply remotelist.StreamAppendReply
+		if err := rl.StreamAppend(remotelist.StreamAppendArgs{Key: key, Value: v}, &reply); err != nil {
+			return nil, err
+		}
+		ids = append(ids, reply.ID)
+	}
+	return ids, nil
+}
+
+func entryIDs(entries []remotelist.StreamEntry) []uint64 {
+	ids := []uint64{}
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestStreamIDs(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := n.rl
+	var reply remotelist.StreamReadReply
+	if err := rl.StreamRead(remotelist.StreamReadArgs{Key: "x"}, &reply); err != nil || len(reply.Entries) != 0 || reply.Next != 1 {
+		t.Fatalf("stream inexistente: %v, %+v", err, reply)
+	}
+
+	const writers, each = 8, 250
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	values := make(map[int]uint64) // valor -> ID recebido
+	var mu sync.Mutex
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				v := w*each + i
+				ids, err := appendValues(rl, "x", v)
+				if err != nil {
+					errs <- err
+					return
+				}
+				mu.Lock()
+				values[v] = ids[0]
+				mu.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	entries, first, next, err := readAll(rl, "x", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != writers*each || first != 1 || next != writers*each+1 {
+		t.Fatalf("%d entradas de %d a %d, esperado %d", len(entries), first, next-1, writers*each)
+	}
+	for i, e := range entries {
+		if e.ID != uint64(i+1) || values[e.Value] != e.ID {
+			t.Fatalf("entrada %d: %+v, o append de %d recebeu o ID %d", i, e, e.Value, values[e.Value])
+		}
+		if i > 0 && e.Time.Before(entries[i-1].Time) {
+			t.Fatalf("entrada %d gravada antes da anterior", e.ID)
+		}
+	}
+	// A partir de um ID qualquer, e depois do fim.
+	for _, from := range []uint64{1, 2, 999, 1500, 2000, 2001, 5000} {
+		got, _, _, err := readAll(rl, "x", from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := entries[min(int(max(from, 1))-1, len(entries)):]
+		if !reflect.DeepEqual(entryIDs(got), entryIDs(want)) {
+			t.Fatalf("leitura a partir de %d: %d entradas, esperado %d", from, len(got), len(want))
+		}
+	}
+	if err := rl.StreamRead(remotelist.StreamReadArgs{Key: "x", Count: -1}, &remotelist.StreamReadReply{}); err == nil {
+		t.Fatal("quantidade negativa aceita")
+	}
+	if err := rl.SetAdd(remotelist.SetAddArgs{Key: "s", Member: 1}, &remotelist.SetAddReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := wrongType(rl.StreamAppend(remotelist.StreamAppendArgs{Key: "s", Value: 1}, &remotelist.StreamAppendReply{}), "StreamAppend num set"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wrongType(rl.Append(remotelist.AppendArgs{ListID: "x", Value: 1}, &remotelist.AppendReply{}), "Append num stream"); err != nil {
+		t.Fatal(err)
+	}
+	var kt remotelist.KeyTypeReply
+	if err := rl.KeyType(remotelist.KeyTypeArgs{Key: "x"}, &kt); err != nil || kt.Type != "stream" {
+		t.Fatalf("KeyType: %v, %+v", err, kt)
+	}
+}
+
+func TestStreamGroups(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := n.rl
+	if _, err := appendValues(rl, "x", 10, 11, 12, 13, 14, 15); err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range []remotelist.StreamGroupCreateArgs{{Key: "x", Group: "a"}, {Key: "x", Group: "b", From: 4}, {Key: "x", Group: "new", Latest: true}} {
+		if err := rl.StreamGroupCreate(g, &remotelist.StreamGroupCreateReply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rl.StreamGroupCreate(remotelist.StreamGroupCreateArgs{Key: "x", Group: "a"}, &remotelist.StreamGroupCreateReply{}); err == nil {
+		t.Fatal("grupo repetido aceito")
+	}
+	if err := rl.StreamGroupCreate(remotelist.StreamGroupCreateArgs{Key: "x", Group: "com espaço"}, &remotelist.StreamGroupCreateReply{}); err == nil {
+		t.Fatal("nome de grupo com espaço aceito")
+	}
+	if err := rl.StreamReadGroup(remotelist.StreamReadGroupArgs{Key: "x", Group: "nada", Consumer: "c"}, &remotelist.StreamReadGroupReply{}); err == nil {
+		t.Fatal("leitura de grupo inexistente sem erro")
+	}
+
+	// No grupo a, os consumidores dividem as entradas.
+	read := func(group, consumer string, count int) ([]uint64, error) {
+		var reply remotelist.StreamReadGroupReply
+		err := rl.StreamReadGroup(remotelist.StreamReadGroupArgs{Key: "x", Group: group, Consumer: consumer, Count: count}, &reply)
+		return entryIDs(reply.Entries), err
+	}
+	steps := []struct {
+		group, consumer string
+		count           int
+		want            []uint64
+	}{
+		{"a", "c1", 2, []uint64{1, 2}},
+		{"a", "c2", 3, []uint64{3, 4, 5}},
+		{"a", "c1", 5, []uint64{6}},
+		{"a", "c2", 5, []uint64{}},
+		{"b", "c1", 0, []uint64{4, 5, 6}},
+		{"new", "c1", 0, []uint64{}},
+	}
+	for _, st := range steps {
+		got, err := read(st.group, st.consumer, st.count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, st.want) {
+			t.Fatalf("grupo %s, %s: %v, esperado %v", st.group, st.consumer, got, st.want)
+		}
+	}
+	if _, err := appendValues(rl, "x", 16); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := read("new", "c3", 0); err != nil || !reflect.DeepEqual(got, []uint64{7}) {
+		t.Fatalf("grupo new depois do append: %v, %v", got, err)
+	}
+
+	ack := func(ids ...uint64) (int, error) {
+		var reply remotelist.StreamAckReply
+		err := rl.StreamAck(remotelist.StreamAckArgs{Key: "x", Group: "a", IDs: ids}, &reply)
+		return reply.Acked, err
+	}
+	pending := func() (remotelist.StreamPendingReply, error) {
+		var reply remotelist.StreamPendingReply
+		err := rl.StreamPending(remotelist.StreamPendingArgs{Key: "x", Group: "a"}, &reply)
+		return reply, err
+	}
+	// Acks fora de ordem: o offset do grupo para na primeira pendente.
+	if acked, err := ack(2, 4, 4, 99); err != nil || acked != 2 {
+		t.Fatalf("ack de 2 e 4: %d, %v", acked, err)
+	}
+	p, err := pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Committed != 0 || p.Delivered != 6 || !reflect.DeepEqual(p.Consumers, map[string]uint64{"c1": 2, "c2": 4}) {
+		t.Fatalf("depois de ack de 2 e 4: %+v", p)
+	}
+	if !reflect.DeepEqual(pendingIDs(p), []uint64{1, 3, 5, 6}) {
+		t.Fatalf("pendentes %v, esperado [1 3 5 6]", pendingIDs(p))
+	}
+	if acked, err := ack(1, 3); err != nil || acked != 2 {
+		t.Fatalf("ack de 1 e 3: %d, %v", acked, err)
+	}
+	if p, err = pending(); err != nil || p.Committed != 4 {
+		t.Fatalf("offset do grupo depois de ack até 4: %+v, %v", p, err)
+	}
+	if acked, err := ack(5, 6); err != nil || acked != 2 {
+		t.Fatalf("ack de 5 e 6: %d, %v", acked, err)
+	}
+	if p, err = pending(); err != nil || p.Committed != 6 || len(p.Pending) != 0 || p.Consumers["c1"] != 6 || p.Consumers["c2"] != 5 {
+		t.Fatalf("tudo confirmado: %+v, %v", p, err)
+	}
+	// O grupo b não é afetado pelos acks de a.
+	var pb remotelist.StreamPendingReply
+	if err := rl.StreamPending(remotelist.StreamPendingArgs{Key: "x", Group: "b"}, &pb); err != nil || !reflect.DeepEqual(pendingIDs(pb), []uint64{4, 5, 6}) || pb.Committed != 3 {
+		t.Fatalf("grupo b: %+v, %v", pb, err)
+	}
+}
+
+func pendingIDs(p remotelist.StreamPendingReply) []uint64 {
+	ids := []uint64{}
+	for _, e := range p.Pending {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestStreamClaim(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := n.rl
+	if _, err := appendValues(rl, "x", 1, 2, 3, 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.StreamGroupCreate(remotelist.StreamGroupCreateArgs{Key: "x", Group: "g"}, &remotelist.StreamGroupCreateReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.StreamReadGroup(remotelist.StreamReadGroupArgs{Key: "x", Group: "g", Consumer: "c1", Count: 3}, &remotelist.StreamReadGroupReply{}); err != nil {
+		t.Fatal(err)
+	}
+	claim := func(consumer string, idle time.Duration, count int) ([]uint64, error) {
+		var reply remotelist.StreamClaimReply
+		err := rl.StreamClaim(remotelist.StreamClaimArgs{Key: "x", Group: "g", Consumer: consumer, MinIdle: idle, Count: count}, &reply)
+		return entryIDs(reply.Entries), err
+	}
+	const idle = 200 * time.Millisecond
+	if got, err := claim("c2", idle, 0); err != nil || len(got) != 0 {
+		t.Fatalf("claim antes de MinIdle: %v, %v", got, err)
+	}
+	if err := rl.StreamAck(remotelist.StreamAckArgs{Key: "x", Group: "g", IDs: []uint64{2}}, &remotelist.StreamAckReply{}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(idle)
+	if got, err := claim("c2", idle, 1); err != nil || !reflect.DeepEqual(got, []uint64{1}) {
+		t.Fatalf("claim de uma: %v, %v", got, err)
+	}
+	// A entrada recém-tomada não está parada; a outra, sim.
+	if got, err := claim("c3", idle, 0); err != nil || !reflect.DeepEqual(got, []uint64{3}) {
+		t.Fatalf("claim do resto: %v, %v", got, err)
+	}
+	var p remotelist.StreamPendingReply
+	if err := rl.StreamPending(remotelist.StreamPendingArgs{Key: "x", Group: "g"}, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Pending) != 2 || p.Pending[0].Consumer != "c2" || p.Pending[1].Consumer != "c3" ||
+		p.Pending[0].Deliveries != 2 || p.Pending[1].Deliveries != 2 || time.Since(p.Pending[0].Delivered) >= idle {
+		t.Fatalf("pendentes depois dos claims: %+v", p.Pending)
+	}
+	// Só o dono atual confirma e move o próprio offset.
+	if err := rl.StreamAck(remotelist.StreamAckArgs{Key: "x", Group: "g", IDs: []uint64{1, 3}}, &remotelist.StreamAckReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.StreamPending(remotelist.StreamPendingArgs{Key: "x", Group: "g"}, &p); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]uint64{"c1": 2, "c2": 1, "c3": 3}; !reflect.DeepEqual(p.Consumers, want) || p.Committed != 3 {
+		t.Fatalf("offsets depois dos acks: %+v, esperado %v e 3", p, want)
+	}
+}
+
+func TestStreamTrim(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{MaxListElements: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := n.rl
+	if _, err := appendValues(rl, "x", 1, 2, 3, 4, 5); err != nil {
+		t.Fatal(err)
+	}
+	err = rl.StreamAppend(remotelist.StreamAppendArgs{Key: "x", Value: 6}, &remotelist.StreamAppendReply{})
+	if remotelist.LimitCode(err) != remotelist.CodeListFull {
+		t.Fatalf("sexta entrada: erro %v, esperado %s", err, remotelist.CodeListFull)
+	}
+	// Com MaxLen, o append cabe no limite.
+	var ar remotelist.StreamAppendReply
+	if err := rl.StreamAppend(remotelist.StreamAppendArgs{Key: "x", Value: 6, MaxLen: 5}, &ar); err != nil || ar.ID != 6 {
+		t.Fatalf("append com MaxLen: %+v, %v", ar, err)
+	}
+	if err := rl.StreamGroupCreate(remotelist.StreamGroupCreateArgs{Key: "x", Group: "g"}, &remotelist.StreamGroupCreateReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.StreamReadGroup(remotelist.StreamReadGroupArgs{Key: "x", Group: "g", Consumer: "c", Count: 3}, &remotelist.StreamReadGroupReply{}); err != nil {
+		t.Fatal(err)
+	}
+	var tr remotelist.StreamTrimReply
+	if err := rl.StreamTrim(remotelist.StreamTrimArgs{Key: "x", MaxLen: 2}, &tr); err != nil || tr.Removed != 3 {
+		t.Fatalf("corte para 2: %+v, %v", tr, err)
+	}
+	entries, first, next, err := readAll(rl, "x", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entryIDs(entries), []uint64{5, 6}) || first != 5 || next != 7 {
+		t.Fatalf("depois do corte: %v, de %d, próximo %d", entryIDs(entries), first, next)
+	}
+	// As pendentes cortadas (2, 3, 4) somem; o grupo continua de onde estava.
+	var p remotelist.StreamPendingReply
+	if err := rl.StreamPending(remotelist.StreamPendingArgs{Key: "x", Group: "g"}, &p); err != nil || len(p.Pending) != 0 || p.Delivered != 4 {
+		t.Fatalf("pendentes depois do corte: %+v, %v", p, err)
+	}
+	var gr remotelist.StreamReadGroupReply
+	if err := rl.StreamReadGroup(remotelist.StreamReadGroupArgs{Key: "x", Group: "g", Consumer: "c"}, &gr); err != nil || !reflect.DeepEqual(entryIDs(gr.Entries), []uint64{5, 6}) {
+		t.Fatalf("grupo depois do corte: %v, %v", entryIDs(gr.Entries), err)
+	}
+
+	// Por idade: as entradas antigas saem, as novas ficam.
+	time.Sleep(150 * time.Millisecond)
+	ids, err := appendValues(rl, "x", 7, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids[0] != 7 {
+		t.Fatalf("ID depois do corte: %d, esperado 7", ids[0])
+	}
+	if err := rl.StreamTrim(remotelist.StreamTrimArgs{Key: "x", MaxAge: 100 * time.Millisecond}, &tr); err != nil || tr.Removed != 2 {
+		t.Fatalf("corte por idade: %+v, %v", tr, err)
+	}
+	if entries, _, _, err = readAll(rl, "x", 0); err != nil || !reflect.DeepEqual(entryIDs(entries), []uint64{7, 8}) {
+		t.Fatalf("depois do corte por idade: %v, %v", entryIDs(entries), err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if err := rl.StreamTrim(remotelist.StreamTrimArgs{Key: "x", MaxAge: 100 * time.Millisecond}, &tr); err != nil || tr.Removed != 2 {
+		t.Fatalf("corte de tudo: %+v, %v", tr, err)
+	}
+	// Vazio, o stream continua existindo e não reaproveita IDs, também depois da queda.
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	rl = n.rl
+	if ids, err = appendValues(rl, "x", 9); err != nil || ids[0] != 9 {
+		t.Fatalf("append depois de cortar tudo e da queda: %v, %v", ids, err)
+	}
+	var st remotelist.LimitStatsReply
+	if err := rl.LimitStats(remotelist.LimitStatsArgs{}, &st); err != nil || st.Elements != 1 {
+		t.Fatalf("elementos depois dos cortes: %d, %v", st.Elements, err)
+	}
+	if err := rl.StreamTrim(remotelist.StreamTrimArgs{Key: "x", MaxLen: -1}, &tr); err == nil {
+		t.Fatal("MaxLen negativo aceito")
+	}
+}
+
+func TestStreamReplay(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := streamWorkload(n.rl, 1, streamOps); err != nil {
+		t.Fatal(err)
+	}
+	want, err := dumpStreams(n.rl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectStreams(n.rl, want, "replay do WAL"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := n.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := streamWorkload(n.rl, 2, streamOps); err != nil {
+		t.Fatal(err)
+	}
+	if want, err = dumpStreams(n.rl); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectStreams(n.rl, want, "snapshot e WAL"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Snapshots no meio das escritas não podem ver as seguintes (copy-on-write).
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	snapErr := make(chan error, 1)
+	rl := n.rl
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := rl.Snapshot(); err != nil {
+				snapErr <- err
+				return
+			}
+		}
+	}()
+	err = streamWorkload(rl, 3, streamOps)
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-snapErr:
+		t.Fatal(err)
+	default:
+	}
+	if want, err = dumpStreams(rl); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectStreams(n.rl, want, "snapshots concorrentes"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamBackup(t *testing.T) {
+	c := newCluster(t)
+	backup, err := c.start(remotelist.Config{Backup: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary, err := c.start(remotelist.Config{Backups: []string{backup.addr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := streamWorkload(primary.rl, 4, streamOps); err != nil {
+		t.Fatal(err)
+	}
+	want, err := dumpStreams(primary.rl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := convergeStreams(backup, want, "backup pelo WAL"); err != nil {
+		t.Fatal(err)
+	}
+	if err := backup.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectStreams(backup.rl, want, "backup depois da queda"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Um backup novo, depois de o primário ter descartado o WAL antigo, só
+	// pode receber o estado inteiro.
+	if err := primary.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	primary.stop()
+	fresh, err := c.start(remotelist.Config{Backup: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary.cfg.Backups = []string{fresh.addr}
+	if err := primary.start(primary.cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := streamWorkload(primary.rl, 5, streamOps/4); err != nil {
+		t.Fatal(err)
+	}
+	if want, err = dumpStreams(primary.rl); err != nil {
+		t.Fatal(err)
+	}
+	if err := convergeStreams(fresh, want, "backup pelo estado instalado"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fresh.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectStreams(fresh.rl, want, "backup instalado depois da queda"); err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != 3 {
+		t.Fatalf("%d streams, esperado x0..x2", len(want))
+	}
+}
+
+// Um stream migra inteiro: IDs, instantes, grupos e pendências.
+func TestStreamMigration(t *testing.T) {
+	c := newCluster(t)
+	src, err := c.start(remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := c.start(remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := streamWorkload(src.rl, 8, streamOps); err != nil {
+		t.Fatal(err)
+	}
+	want, err := dumpStreams(src.rl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := moveKeys(src.rl, dst.rl); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectStreams(dst.rl, want, "destino"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectStreams(dst.rl, want, "destino depois da queda"); err != nil {
+		t.Fatal(err)
+	}
+}
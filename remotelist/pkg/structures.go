@@ -0,0 +1,757 @@
+package remotelist
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// --- Sets, maps e contadores ---
+//
+// Além de uma lista, uma chave pode guardar um set de inteiros, um map de
+// campos (texto) para inteiros, um contador, um sorted set (ver sortedset.go) ou
+// um stream (ver streams.go). Todas as chaves ficam no mesmo
+// map (rl.lists), cada uma numa ManagedList: o lock por chave, o TTL, o
+// DELETE, os limites de elementos (um contador não conta) e o snapshot com
+// copy-on-write valem para todos os tipos. O tipo é fixado quando a chave é
+// criada; uma operação de outro tipo é recusada com WRONGTYPE, e a chave só
+// muda de tipo depois de apagada.
+//
+// No WAL: "SADD|SREM <chave> <membro>", "HSET <chave> <campo> <valor>",
+// "HDEL <chave> <campo>" e "INCR <chave> <delta>" (CounterDecr grava o delta
+// negativo). Por isso um campo de map não pode ter espaços.
+//
+// Ler uma chave que não existe não é erro: o set não tem membros, o map não tem
+// o campo e o contador vale zero. Lists só mostra as listas; Keys mostra todas
+// as chaves com o tipo.
+//
+// O roteador de shards encaminha as operações de todos os tipos ao dono da
+// chave e, ao migrar, copia a chave inteira: ExportList devolve o conteúdo em
+// Structures e ImportList o grava num registro "RESTORE <chave> <json>". Os
+// tipos novos ainda não passam pela recuperação no tempo (RestoreState e GetAt)
+// nem pelo armazenamento bolt.
+
+// Kind é o tipo de uma chave.
+type Kind uint8
+
+const (
+	KindList Kind = iota
+	KindSet
+	KindMap
+	KindCounter
+	KindSorted // Sorted set (ver sortedset.go)
+	KindStream // Stream (ver streams.go)
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindList:
+		return "list"
+	case KindSet:
+		return "set"
+	case KindMap:
+		return "map"
+	case KindCounter:
+		return "counter"
+	case KindSorted:
+		return "sorted"
+	case KindStream:
+		return "stream"
+	}
+	return fmt.Sprintf("Kind(%d)", uint8(k))
+}
+
+// CodeWrongType começa o erro de uma operação feita numa chave de outro tipo.
+const CodeWrongType = "WRONGTYPE"
+
+func errWrongType(key string, got, want Kind) error {
+	return fmt.Errorf("%s: a chave '%s' é do tipo %s, não %s", CodeWrongType, key, got, want)
+}
+
+// recordKinds é o tipo da chave tocada por cada registro dos tipos novos.
+var recordKinds = map[string]Kind{
+	"SADD": KindSet, "SREM": KindSet,
+	"HSET": KindMap, "HDEL": KindMap,
+	"INCR": KindCounter,
+	"ZADD": KindSorted, "ZPOPMIN": KindSorted, "ZPOPMAX": KindSorted,
+	"XADD": KindStream, "XTRIM": KindStream, "XGROUP": KindStream,
+	"XREADGROUP": KindStream, "XACK": KindStream, "XCLAIM": KindStream,
+}
+
+type SetAddArgs struct {
+	Key    string
+	Member int
+}
+type SetAddReply struct {
+	Added bool   // false se o membro já estava no set
+	Token uint64 // Número do registro da escrita, para leituras ReadYourWrites
+}
+
+type SetRemoveArgs struct {
+	Key    string
+	Member int
+}
+type SetRemoveReply struct {
+	Removed bool // false se o membro não estava no set
+	Token   uint64
+}
+
+type SetContainsArgs struct {
+	Key    string
+	Member int
+	Read   ReadOptions
+}
+type SetContainsReply struct {
+	Contains bool
+}
+
+type SetMembersArgs struct {
+	Key  string
+	Read ReadOptions
+}
+type SetMembersReply struct {
+	Members []int // Em ordem crescente
+}
+
+type MapSetArgs struct {
+	Key   string
+	Field string
+	Value int
+}
+type MapSetReply struct {
+	Created bool // false se o campo já existia (e foi sobrescrito)
+	Token   uint64
+}
+
+type MapGetArgs struct {
+	Key   string
+	Field string
+	Read  ReadOptions
+}
+type MapGetReply struct {
+	Value int
+	Found bool
+}
+
+type MapDeleteArgs struct {
+	Key   string
+	Field string
+}
+type MapDeleteReply struct {
+	Deleted bool // false se o campo não existia
+	Token   uint64
+}
+
+type CounterArgs struct {
+	Key string
+	By  int // Quanto somar (CounterIncr) ou subtrair (CounterDecr); 0 = 1
+}
+type CounterReply struct {
+	Value int // Valor depois da operação
+	Token uint64
+}
+
+type CounterGetArgs struct {
+	Key  string
+	Read ReadOptions
+}
+type CounterGetReply struct {
+	Value int
+}
+
+type KeyTypeArgs struct {
+	Key  string
+	Read ReadOptions
+}
+type KeyTypeReply struct {
+	Exists bool
+	Type   string // "list", "set", "map", "counter", "sorted" ou "stream"
+}
+
+type KeysArgs struct {
+	Read ReadOptions
+}
+type KeysReply struct {
+	Keys []KeyInfo // Em ordem alfabética
+}
+type KeyInfo struct {
+	Key  string
+	Type string
+}
+
+// --- Sets ---
+
+// SetAdd coloca o membro no set 'key', criando o set se preciso.
+func (rl *RemoteList) SetAdd(args SetAddArgs, reply *SetAddReply) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	ml, err := rl.lockOrCreateKey(args.Key, KindSet)
+	if err != nil {
+		return err
+	}
+	defer ml.mu.Unlock()
+	if _, ok := ml.set.get(args.Member); ok {
+		return nil
+	}
+	if err := rl.reserveElements(args.Key, ml.size(), 1); err != nil {
+		return err
+	}
+	seq, err := rl.writeKey(ml, LogRecord{Op: "SADD", ListID: args.Key, Value: args.Member})
+	if err != nil {
+		rl.releaseElements(1)
+		return err
+	}
+	reply.Added, reply.Token = true, seq
+	return nil
+}
+
+// SetRemove tira o membro do set 'key'.
+func (rl *RemoteList) SetRemove(args SetRemoveArgs, reply *SetRemoveReply) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	ml, err := rl.lockKey(args.Key, KindSet)
+	if ml == nil || err != nil {
+		return err
+	}
+	defer ml.mu.Unlock()
+	if _, ok := ml.set.get(args.Member); !ok {
+		return nil
+	}
+	seq, err := rl.writeKey(ml, LogRecord{Op: "SREM", ListID: args.Key, Value: args.Member})
+	if err != nil {
+		return err
+	}
+	rl.releaseElements(1)
+	reply.Removed, reply.Token = true, seq
+	return nil
+}
+
+// SetContains diz se o membro está no set 'key'.
+func (rl *RemoteList) SetContains(args SetContainsArgs, reply *SetContainsReply) error {
+	if forwarded, err := rl.routeRead(args.Read, "SetContains", args, reply); forwarded {
+		return err
+	}
+	ml, err := rl.rlockKey(args.Key, KindSet)
+	if ml == nil || err != nil {
+		return err
+	}
+	defer ml.mu.RUnlock()
+	_, reply.Contains = ml.set.get(args.Member)
+	return nil
+}
+
+// SetMembers devolve os membros do set 'key'.
+func (rl *RemoteList) SetMembers(args SetMembersArgs, reply *SetMembersReply) error {
+	if forwarded, err := rl.routeRead(args.Read, "SetMembers", args, reply); forwarded {
+		return err
+	}
+	ml, err := rl.rlockKey(args.Key, KindSet)
+	if ml == nil || err != nil {
+		return err
+	}
+	reply.Members = setMembers(ml.set.each)
+	ml.mu.RUnlock()
+	return nil
+}
+
+// --- Maps ---
+
+// MapSet grava o campo do map 'key', criando o map se preciso.
+func (rl *RemoteList) MapSet(args MapSetArgs, reply *MapSetReply) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	if err := validField(args.Field); err != nil {
+		return err
+	}
+	ml, err := rl.lockOrCreateKey(args.Key, KindMap)
+	if err != nil {
+		return err
+	}
+	defer ml.mu.Unlock()
+	_, exists := ml.hash.get(args.Field)
+	if !exists {
+		if err := rl.reserveElements(args.Key, ml.size(), 1); err != nil {
+			return err
+		}
+	}
+	seq, err := rl.writeKey(ml, LogRecord{Op: "HSET", ListID: args.Key, Field: args.Field, Value: args.Value})
+	if err != nil {
+		if !exists {
+			rl.releaseElements(1)
+		}
+		return err
+	}
+	reply.Created, reply.Token = !exists, seq
+	return nil
+}
+
+// MapGet lê o campo do map 'key'.
+func (rl *RemoteList) MapGet(args MapGetArgs, reply *MapGetReply) error {
+	if forwarded, err := rl.routeRead(args.Read, "MapGet", args, reply); forwarded {
+		return err
+	}
+	ml, err := rl.rlockKey(args.Key, KindMap)
+	if ml == nil || err != nil {
+		return err
+	}
+	defer ml.mu.RUnlock()
+	reply.Value, reply.Found = ml.hash.get(args.Field)
+	return nil
+}
+
+// MapDelete apaga o campo do map 'key'.
+func (rl *RemoteList) MapDelete(args MapDeleteArgs, reply *MapDeleteReply) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	ml, err := rl.lockKey(args.Key, KindMap)
+	if ml == nil || err != nil {
+		return err
+	}
+	defer ml.mu.Unlock()
+	if _, ok := ml.hash.get(args.Field); !ok {
+		return nil
+	}
+	seq, err := rl.writeKey(ml, LogRecord{Op: "HDEL", ListID: args.Key, Field: args.Field})
+	if err != nil {
+		return err
+	}
+	rl.releaseElements(1)
+	reply.Deleted, reply.Token = true, seq
+	return nil
+}
+
+// --- Contadores ---
+
+// CounterIncr soma args.By (ou 1) ao contador 'key', que começa em zero.
+func (rl *RemoteList) CounterIncr(args CounterArgs, reply *CounterReply) error {
+	return rl.addCounter(args.Key, counterStep(args.By), reply)
+}
+
+// CounterDecr subtrai args.By (ou 1) do contador 'key'.
+func (rl *RemoteList) CounterDecr(args CounterArgs, reply *CounterReply) error {
+	return rl.addCounter(args.Key, -counterStep(args.By), reply)
+}
+
+func counterStep(by int) int {
+	if by == 0 {
+		return 1
+	}
+	return by
+}
+
+func (rl *RemoteList) addCounter(key string, delta int, reply *CounterReply) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	ml, err := rl.lockOrCreateKey(key, KindCounter)
+	if err != nil {
+		return err
+	}
+	defer ml.mu.Unlock()
+	seq, err := rl.writeKey(ml, LogRecord{Op: "INCR", ListID: key, Value: delta})
+	if err != nil {
+		return err
+	}
+	reply.Value, reply.Token = ml.counter, seq
+	return nil
+}
+
+// CounterGet lê o contador 'key'.
+func (rl *RemoteList) CounterGet(args CounterGetArgs, reply *CounterGetReply) error {
+	if forwarded, err := rl.routeRead(args.Read, "CounterGet", args, reply); forwarded {
+		return err
+	}
+	ml, err := rl.rlockKey(args.Key, KindCounter)
+	if ml == nil || err != nil {
+		return err
+	}
+	reply.Value = ml.counter
+	ml.mu.RUnlock()
+	return nil
+}
+
+// --- Chaves ---
+
+// KeyType informa o tipo da chave 'key'.
+func (rl *RemoteList) KeyType(args KeyTypeArgs, reply *KeyTypeReply) error {
+	if forwarded, err := rl.routeRead(args.Read, "KeyType", args, reply); forwarded {
+		return err
+	}
+	ml, exists := rl.getList(args.Key)
+	if !exists {
+		return nil
+	}
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+	if ml.deleted || ml.expired(time.Now()) {
+		return nil
+	}
+	reply.Exists, reply.Type = true, ml.kind.String()
+	return nil
+}
+
+// Keys devolve todas as chaves, de todos os tipos.
+func (rl *RemoteList) Keys(args KeysArgs, reply *KeysReply) error {
+	if forwarded, err := rl.routeRead(args.Read, "Keys", args, reply); forwarded {
+		return err
+	}
+	now := time.Now()
+	rl.mapMu.RLock()
+	for id, ml := range rl.lists {
+		ml.mu.RLock()
+		if !ml.expired(now) {
+			reply.Keys = append(reply.Keys, KeyInfo{Key: id, Type: ml.kind.String()})
+		}
+		ml.mu.RUnlock()
+	}
+	rl.mapMu.RUnlock()
+	sort.Slice(reply.Keys, func(i, j int) bool { return reply.Keys[i].Key < reply.Keys[j].Key })
+	return nil
+}
+
+// --- Auxiliares ---
+
+// lockKey bloqueia para escrita a chave do tipo kind, se ela existe (senão
+// devolve nil, sem erro).
+func (rl *RemoteList) lockKey(key string, kind Kind) (*ManagedList, error) {
+	ml, err := rl.lockExisting(key)
+	if errors.Is(err, errListNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ml.kind != kind {
+		ml.mu.Unlock()
+		return nil, errWrongType(key, ml.kind, kind)
+	}
+	return ml, nil
+}
+
+// rlockKey bloqueia para leitura a chave do tipo kind, se ela existe (senão
+// devolve nil, sem erro).
+func (rl *RemoteList) rlockKey(key string, kind Kind) (*ManagedList, error) {
+	ml, exists := rl.getList(key)
+	if !exists {
+		return nil, nil
+	}
+	ml.mu.RLock()
+	if ml.deleted || ml.expired(time.Now()) {
+		ml.mu.RUnlock()
+		return nil, nil
+	}
+	if ml.kind != kind {
+		ml.mu.RUnlock()
+		return nil, errWrongType(key, ml.kind, kind)
+	}
+	return ml, nil
+}
+
+// writeKey grava rec no log e o aplica à chave, já bloqueada para escrita,
+// com o instante em que foi gravado (o mesmo que o replay verá).
+func (rl *RemoteList) writeKey(ml *ManagedList, rec LogRecord) (uint64, error) {
+	stamped, err := rl.logStamped(rec)
+	if err != nil {
+		log.Printf("Erro crítico de persistência (%s): %v", rec.Op, err)
+		return 0, fmt.Errorf("falha ao gravar no disco (WAL): %w", err)
+	}
+	ml.applyStructure(stamped)
+	return stamped.Seq, nil
+}
+
+// validField confere que o campo pode ser gravado numa linha do WAL.
+func validField(field string) error {
+	if field == "" || strings.IndexFunc(field, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("campo inválido %q: não pode ser vazio nem ter espaços", field)
+	}
+	return nil
+}
+
+// newKey cria uma chave vazia do tipo kind.
+func newKey(kind Kind) *ManagedList {
+	ml := &ManagedList{kind: kind}
+	switch kind {
+	case KindSet:
+		ml.set = newCowMap[int, struct{}](hashInteger[int])
+	case KindMap:
+		ml.hash = newCowMap[string, int](hashString)
+	case KindSorted:
+		ml.sorted = newSortedSet()
+	case KindStream:
+		ml.stream = newStream()
+	}
+	return ml
+}
+
+// size é o número de elementos da chave, para os limites (ver limits.go). Um
+// contador não tem elementos. Deve ser chamado com ml.mu bloqueado.
+func (ml *ManagedList) size() int {
+	switch ml.kind {
+	case KindSet:
+		return ml.set.len()
+	case KindMap:
+		return ml.hash.len()
+	case KindCounter:
+		return 0
+	case KindSorted:
+		return ml.sorted.len()
+	case KindStream:
+		return len(ml.stream.entries)
+	}
+	return ml.length + len(ml.delayed) // Os agendados já contam nos limites
+}
+
+// applyStructure aplica um registro dos tipos novos à chave, que já é do tipo
+// do registro. Deve ser chamado com ml.mu bloqueado para escrita.
+func (ml *ManagedList) applyStructure(rec LogRecord) {
+	switch rec.Op {
+	case "SADD":
+		ml.set.set(rec.Value, struct{}{})
+	case "SREM":
+		ml.set.delete(rec.Value)
+	case "HSET":
+		ml.hash.set(rec.Field, rec.Value)
+	case "HDEL":
+		ml.hash.delete(rec.Field)
+	case "INCR":
+		ml.counter += rec.Value
+	case "ZADD":
+		ml.sorted.add(rec.Value, rec.Score)
+	case "ZPOPMIN", "ZPOPMAX":
+		ml.sorted.pop(rec.Value, rec.Op == "ZPOPMAX")
+	case "XADD", "XTRIM", "XGROUP", "XREADGROUP", "XACK", "XCLAIM":
+		ml.stream.apply(rec)
+	}
+}
+
+// applyStructureRecord é o applyRecord dos tipos novos: cria a chave se
+// preciso e recusa uma chave de outro tipo.
+func applyStructureRecord(lists map[string]*ManagedList, rec LogRecord) error {
+	kind := recordKinds[rec.Op]
+	ml, exists := lists[rec.ListID]
+	if !exists {
+		ml = newKey(kind)
+		lists[rec.ListID] = ml
+	}
+	if ml.kind != kind {
+		return errWrongType(rec.ListID, ml.kind, kind)
+	}
+	if kind == KindStream {
+		if err := ml.stream.check(rec); err != nil {
+			return err
+		}
+	}
+	ml.applyStructure(rec)
+	return nil
+}
+
+// setMembers devolve em ordem os membros percorridos por each (de um set ou
+// da visão dele).
+func setMembers(each func(func(int, struct{}))) []int {
+	out := []int{}
+	each(func(v int, _ struct{}) { out = append(out, v) })
+	sort.Ints(out)
+	return out
+}
+
+// --- Snapshot ---
+
+// Structures são as chaves que não são listas, no snapshot e no estado enviado
+// a um backup.
+type Structures struct {
+	Sets     map[string][]int          `json:"sets,omitempty"`
+	Maps     map[string]map[string]int `json:"maps,omitempty"`
+	Counters map[string]int            `json:"counters,omitempty"`
+	Sorted   map[string][]ScoredMember `json:"sorted,omitempty"` // Em ordem crescente
+	Streams  map[string]StreamState    `json:"streams,omitempty"`
+}
+
+// structView é uma chave que não é lista, fotografada por captureStructures.
+type structView struct {
+	id      string
+	kind    Kind
+	set     cowView[int, struct{}]
+	hash    cowView[string, int]
+	counter int
+	sorted  cowView[int, int] // Membro -> pontuação
+	stream  streamView
+}
+
+// captureStructures fotografa as chaves que não são listas sem copiar os
+// dados: depois, cada escrita copia só o balde que altera (ver cowmap.go).
+// Deve ser chamado com as chaves bloqueadas para escrita.
+func captureStructures(ids []string, lists []*ManagedList) []structView {
+	var views []structView
+	for i, ml := range lists {
+		if ml.kind != KindList {
+			views = append(views, ml.structView(ids[i], true))
+		}
+	}
+	return views
+}
+
+// structView fotografa a chave, que não é lista (ver cowMap.capture). Sem
+// share, basta o lock de leitura.
+func (ml *ManagedList) structView(id string, share bool) structView {
+	v := structView{id: id, kind: ml.kind, counter: ml.counter}
+	switch ml.kind {
+	case KindSet:
+		v.set = ml.set.capture(share)
+	case KindMap:
+		v.hash = ml.hash.capture(share)
+	case KindSorted:
+		v.sorted = ml.sorted.scores.capture(share)
+	case KindStream:
+		v.stream = ml.stream.capture(share)
+	}
+	return v
+}
+
+// structuresOf copia as fotografias de captureStructures (sem locks).
+func structuresOf(views []structView) Structures {
+	var s Structures
+	for _, v := range views {
+		switch v.kind {
+		case KindSet:
+			if s.Sets == nil {
+				s.Sets = make(map[string][]int)
+			}
+			s.Sets[v.id] = setMembers(v.set.each)
+		case KindMap:
+			if s.Maps == nil {
+				s.Maps = make(map[string]map[string]int)
+			}
+			hash := make(map[string]int, v.hash.len())
+			v.hash.each(func(field string, value int) { hash[field] = value })
+			s.Maps[v.id] = hash
+		case KindCounter:
+			if s.Counters == nil {
+				s.Counters = make(map[string]int)
+			}
+			s.Counters[v.id] = v.counter
+		case KindSorted:
+			if s.Sorted == nil {
+				s.Sorted = make(map[string][]ScoredMember)
+			}
+			s.Sorted[v.id] = sortedItems(v.sorted)
+		case KindStream:
+			if s.Streams == nil {
+				s.Streams = make(map[string]StreamState)
+			}
+			s.Streams[v.id] = v.stream.state()
+		}
+	}
+	return s
+}
+
+// restoreStructures cria em lists as chaves de s.
+func restoreStructures(lists map[string]*ManagedList, s Structures) {
+	for id, members := range s.Sets {
+		ml := newKey(KindSet)
+		for _, v := range members {
+			ml.set.set(v, struct{}{})
+		}
+		lists[id] = ml
+	}
+	for id, hash := range s.Maps {
+		ml := newKey(KindMap)
+		for field, v := range hash {
+			ml.hash.set(field, v)
+		}
+		lists[id] = ml
+	}
+	for id, v := range s.Counters {
+		ml := newKey(KindCounter)
+		ml.counter = v
+		lists[id] = ml
+	}
+	for id, items := range s.Sorted {
+		ml := newKey(KindSorted)
+		for _, it := range items {
+			ml.sorted.add(it.Member, it.Score)
+		}
+		lists[id] = ml
+	}
+	for id, st := range s.Streams {
+		ml := newKey(KindStream)
+		ml.stream = streamFromState(st)
+		lists[id] = ml
+	}
+}
+
+// single devolve o tipo da chave id, se s tiver só ela (como o de ExportList
+// e o de um registro RESTORE).
+func (s Structures) single(id string) (Kind, bool) {
+	if len(s.Sets)+len(s.Maps)+len(s.Counters)+len(s.Sorted)+len(s.Streams) != 1 {
+		return 0, false
+	}
+	if _, ok := s.Sets[id]; ok {
+		return KindSet, true
+	}
+	if _, ok := s.Maps[id]; ok {
+		return KindMap, true
+	}
+	if _, ok := s.Counters[id]; ok {
+		return KindCounter, true
+	}
+	if _, ok := s.Sorted[id]; ok {
+		return KindSorted, true
+	}
+	if _, ok := s.Streams[id]; ok {
+		return KindStream, true
+	}
+	return 0, false
+}
+
+// restoreKey aplica um RESTORE: a chave id passa a ter o conteúdo dela em s.
+// Uma chave que já existe é alterada no lugar, para quem já tem o ponteiro.
+func restoreKey(lists map[string]*ManagedList, id string, s Structures) {
+	fresh := make(map[string]*ManagedList, 1)
+	restoreStructures(fresh, s)
+	if ml, exists := lists[id]; exists {
+		ml.restore(fresh[id])
+		return
+	}
+	lists[id] = fresh[id]
+}
+
+// restore troca o conteúdo da chave pelo de fresh, uma chave recém-criada que
+// não é lista, e tira o TTL (como o REPLACE). Deve ser chamado com ml.mu
+// bloqueado para escrita.
+func (ml *ManagedList) restore(fresh *ManagedList) {
+	ml.replace(nil)
+	ml.expires, ml.delayed = time.Time{}, nil
+	ml.kind, ml.counter = fresh.kind, fresh.counter
+	ml.set, ml.hash, ml.sorted, ml.stream = fresh.set, fresh.hash, fresh.sorted, fresh.stream
+}
+
+// listValues copia as visões das chaves que são listas.
+func listValues(ids []string, lists []*ManagedList, views []listView) map[string][]int {
+	out := make(map[string][]int, len(views))
+	for i, view := range views {
+		if lists[i].kind == KindList {
+			out[ids[i]] = view.values()
+		}
+	}
+	return out
+}
+
+// namedViews é listValues sem a cópia: as visões das listas, para serem
+// gravadas direto no snapshot (ver snapshot.go).
+func namedViews(ids []string, lists []*ManagedList, views []listView) []namedView {
+	out := make([]namedView, 0, len(views))
+	for i, view := range views {
+		if lists[i].kind == KindList {
+			out = append(out, namedView{id: ids[i], view: view})
+		}
+	}
+	return out
+}
@@ -0,0 +1,904 @@
+package remotelist_test
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"remotelist/pkg"
+)
+
+// Sets, maps, contadores e sorted sets (ver structures.go e sortedset.go)
+// contra um modelo em memória, com o RemoteList sobre um MemFS, que simula
+// quedas.
+
+const structOps = 2000 // Operações aleatórias em cada rodada
+
+// --- Modelo ---
+
+// structModel é o estado esperado, no mesmo formato de dumpStructs.
+type structModel struct {
+	lists    map[string][]int
+	sets     map[string]map[int]bool
+	maps     map[string]map[string]int
+	counters map[string]int
+	sorted   map[string]map[int]int // membro -> pontuação
+}
+
+func newStructModel() *structModel {
+	return &structModel{lists: map[string][]int{}, sets: map[string]map[int]bool{}, maps: map[string]map[string]int{},
+		counters: map[string]int{}, sorted: map[string]map[int]int{}}
+}
+
+// sortedItems devolve o sorted set do modelo em ordem de (pontuação, membro).
+func (m *structModel) sortedItems(key string) []remotelist.ScoredMember {
+	items := []remotelist.ScoredMember{}
+	for member, score := range m.sorted[key] {
+		items = append(items, remotelist.ScoredMember{Member: member, Score: score})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Score != items[j].Score {
+			return items[i].Score < items[j].Score
+		}
+		return items[i].Member < items[j].Member
+	})
+	return items
+}
+
+// pop tira n membros do sorted set do modelo e os devolve, na ordem do pop.
+func (m *structModel) pop(key string, n int, last bool) []remotelist.ScoredMember {
+	items := m.sortedItems(key)
+	if last {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+	items = items[:min(n, len(items))]
+	for _, it := range items {
+		delete(m.sorted[key], it.Member)
+	}
+	return items
+}
+
+func (m *structModel) dump() map[string]string {
+	out := make(map[string]string)
+	for k, v := range m.lists {
+		out[k] = fmt.Sprint("list ", v)
+	}
+	for k, set := range m.sets {
+		members := []int{}
+		for v := range set {
+			members = append(members, v)
+		}
+		sort.Ints(members)
+		out[k] = fmt.Sprint("set ", members)
+	}
+	for k, v := range m.maps {
+		out[k] = fmt.Sprint("map ", v)
+	}
+	for k, v := range m.counters {
+		out[k] = fmt.Sprint("counter ", v)
+	}
+	for k := range m.sorted {
+		out[k] = fmt.Sprint("sorted ", m.sortedItems(k))
+	}
+	return out
+}
+
+// dumpStructs lê todas as chaves do servidor pelos métodos RPC.
+func dumpStructs(rl *remotelist.RemoteList) (map[string]string, error) {
+	var keys remotelist.KeysReply
+	if err := rl.Keys(remotelist.KeysArgs{}, &keys); err != nil {
+		return nil, err
+	}
+	out := make(map[string]string)
+	for _, k := range keys.Keys {
+		switch k.Type {
+		case "list":
+			var reply remotelist.ViewReply
+			if err := rl.View(remotelist.ViewArgs{ListID: k.Key, Limit: 10000}, &reply); err != nil {
+				return nil, err
+			}
+			out[k.Key] = fmt.Sprint("list ", append([]int{}, reply.Values...))
+		case "set":
+			var reply remotelist.SetMembersReply
+			if err := rl.SetMembers(remotelist.SetMembersArgs{Key: k.Key}, &reply); err != nil {
+				return nil, err
+			}
+			out[k.Key] = fmt.Sprint("set ", append([]int{}, reply.Members...))
+		case "map":
+			// Não há leitura do map inteiro: os campos do workload são f0..f9.
+			fields := map[string]int{}
+			for i := 0; i < 10; i++ {
+				var reply remotelist.MapGetReply
+				if err := rl.MapGet(remotelist.MapGetArgs{Key: k.Key, Field: fmt.Sprintf("f%d", i)}, &reply); err != nil {
+					return nil, err
+				}
+				if reply.Found {
+					fields[fmt.Sprintf("f%d", i)] = reply.Value
+				}
+			}
+			out[k.Key] = fmt.Sprint("map ", fields)
+		case "counter":
+			var reply remotelist.CounterGetReply
+			if err := rl.CounterGet(remotelist.CounterGetArgs{Key: k.Key}, &reply); err != nil {
+				return nil, err
+			}
+			out[k.Key] = fmt.Sprint("counter ", reply.Value)
+		case "sorted":
+			var reply remotelist.SortedRangeReply
+			if err := rl.SortedRange(remotelist.SortedRangeArgs{Key: k.Key, Limit: 10000}, &reply); err != nil {
+				return nil, err
+			}
+			out[k.Key] = fmt.Sprint("sorted ", append([]remotelist.ScoredMember{}, reply.Items...))
+		case "stream":
+			var reply remotelist.StreamReadReply
+			if err := rl.StreamRead(remotelist.StreamReadArgs{Key: k.Key, Count: 10000}, &reply); err != nil {
+				return nil, err
+			}
+			out[k.Key] = fmt.Sprint("stream next=", reply.Next)
+			for _, e := range reply.Entries {
+				out[k.Key] += fmt.Sprintf(" %d:%d@%d", e.ID, e.Value, e.Time.UnixNano())
+			}
+		default:
+			return nil, fmt.Errorf("chave %s com tipo desconhecido %q", k.Key, k.Type)
+		}
+	}
+	return out, nil
+}
+
+// expectStructs compara o servidor com o modelo.
+func expectStructs(rl *remotelist.RemoteList, m *structModel, when string) error {
+	got, err := dumpStructs(rl)
+	if err != nil {
+		return fmt.Errorf("%s: %w", when, err)
+	}
+	if want := m.dump(); !reflect.DeepEqual(got, want) {
+		return fmt.Errorf("%s: estado diferente do modelo:\n  servidor %v\n  modelo   %v", when, got, want)
+	}
+	return nil
+}
+
+// convergeStructs espera o backup ficar igual ao modelo.
+func convergeStructs(backup *node, m *structModel, when string) error {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := expectStructs(backup.rl, m, when)
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// structWorkload faz n operações aleatórias no servidor e no modelo. O tipo
+// de cada chave vem do nome: l*, s*, m*, c* e z*.
+func structWorkload(rl *remotelist.RemoteList, m *structModel, seed int64, n int) error {
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("%d", r.Intn(3))
+		v := r.Intn(20)
+		field := fmt.Sprintf("f%d", r.Intn(10))
+		var err error
+		switch op := r.Intn(10); op {
+		case 0:
+			key = "l" + key
+			err = rl.Append(remotelist.AppendArgs{ListID: key, Value: v}, &remotelist.AppendReply{})
+			m.lists[key] = append(m.lists[key], v)
+		case 1, 2:
+			key = "s" + key
+			err = rl.SetAdd(remotelist.SetAddArgs{Key: key, Member: v}, &remotelist.SetAddReply{})
+			if m.sets[key] == nil {
+				m.sets[key] = map[int]bool{}
+			}
+			m.sets[key][v] = true
+		case 3:
+			key = "s" + key
+			err = rl.SetRemove(remotelist.SetRemoveArgs{Key: key, Member: v}, &remotelist.SetRemoveReply{})
+			delete(m.sets[key], v)
+		case 4, 5:
+			key = "m" + key
+			err = rl.MapSet(remotelist.MapSetArgs{Key: key, Field: field, Value: v}, &remotelist.MapSetReply{})
+			if m.maps[key] == nil {
+				m.maps[key] = map[string]int{}
+			}
+			m.maps[key][field] = v
+		case 6:
+			key = "m" + key
+			err = rl.MapDelete(remotelist.MapDeleteArgs{Key: key, Field: field}, &remotelist.MapDeleteReply{})
+			delete(m.maps[key], field)
+		case 7:
+			key = "c" + key
+			var reply remotelist.CounterReply
+			if r.Intn(2) == 0 {
+				err = rl.CounterIncr(remotelist.CounterArgs{Key: key, By: v}, &reply)
+				m.counters[key] += max(v, 1)
+			} else {
+				err = rl.CounterDecr(remotelist.CounterArgs{Key: key, By: v}, &reply)
+				m.counters[key] -= max(v, 1)
+			}
+			if err == nil && reply.Value != m.counters[key] {
+				err = fmt.Errorf("contador %s = %d, esperado %d", key, reply.Value, m.counters[key])
+			}
+		case 8:
+			key = "z" + key
+			score := r.Intn(10)
+			err = rl.SortedAdd(remotelist.SortedAddArgs{Key: key, Member: v, Score: score}, &remotelist.SortedAddReply{})
+			if m.sorted[key] == nil {
+				m.sorted[key] = map[int]int{}
+			}
+			m.sorted[key][v] = score
+		case 9:
+			key = "z" + key
+			var reply remotelist.SortedPopReply
+			count, last := r.Intn(3), r.Intn(2) == 0
+			if last {
+				err = rl.SortedPopMax(remotelist.SortedPopArgs{Key: key, Count: count}, &reply)
+			} else {
+				err = rl.SortedPopMin(remotelist.SortedPopArgs{Key: key, Count: count}, &reply)
+			}
+			want := m.pop(key, max(count, 1), last)
+			if err == nil && len(reply.Items)+len(want) > 0 && !reflect.DeepEqual(reply.Items, want) {
+				err = fmt.Errorf("pop devolveu %v, esperado %v", reply.Items, want)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("operação %d em %s: %w", i, key, err)
+		}
+	}
+	return nil
+}
+
+func wrongType(err error, what string) error {
+	if err == nil || !strings.HasPrefix(err.Error(), remotelist.CodeWrongType) {
+		return fmt.Errorf("%s: erro %v, esperado %s", what, err, remotelist.CodeWrongType)
+	}
+	return nil
+}
+
+// --- Cenários ---
+
+func TestStructTypes(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := n.rl
+	m := newStructModel()
+	if err := rl.Append(remotelist.AppendArgs{ListID: "l", Value: 1}, &remotelist.AppendReply{}); err != nil {
+		t.Fatal(err)
+	}
+	m.lists["l"] = []int{1}
+	var added remotelist.SetAddReply
+	if err := rl.SetAdd(remotelist.SetAddArgs{Key: "s", Member: 1}, &added); err != nil || !added.Added {
+		t.Fatalf("SetAdd: %v, %+v", err, added)
+	}
+	added = remotelist.SetAddReply{}
+	if err := rl.SetAdd(remotelist.SetAddArgs{Key: "s", Member: 1}, &added); err != nil || added.Added {
+		t.Fatalf("SetAdd repetido: %v, %+v", err, added)
+	}
+	m.sets["s"] = map[int]bool{1: true}
+	if err := rl.MapSet(remotelist.MapSetArgs{Key: "m", Field: "f1", Value: 1}, &remotelist.MapSetReply{}); err != nil {
+		t.Fatal(err)
+	}
+	m.maps["m"] = map[string]int{"f1": 1}
+	var counter remotelist.CounterReply
+	if err := rl.CounterDecr(remotelist.CounterArgs{Key: "c"}, &counter); err != nil || counter.Value != -1 {
+		t.Fatalf("CounterDecr sem By: %v, %+v", err, counter)
+	}
+	m.counters["c"] = -1
+
+	calls := []struct {
+		what string
+		err  error
+	}{
+		{"Append em set", rl.Append(remotelist.AppendArgs{ListID: "s", Value: 1}, &remotelist.AppendReply{})},
+		{"Get em map", rl.Get(remotelist.GetArgs{ListID: "m"}, &remotelist.GetReply{})},
+		{"Remove em contador", rl.Remove(remotelist.RemoveArgs{ListID: "c"}, &remotelist.RemoveReply{})},
+		{"Size em set", rl.Size(remotelist.SizeArgs{ListID: "s"}, &remotelist.SizeReply{})},
+		{"Stats em map", rl.Stats(remotelist.StatsArgs{ListID: "m"}, &remotelist.StatsReply{})},
+		{"SetAdd em lista", rl.SetAdd(remotelist.SetAddArgs{Key: "l", Member: 1}, &remotelist.SetAddReply{})},
+		{"SetRemove em map", rl.SetRemove(remotelist.SetRemoveArgs{Key: "m", Member: 1}, &remotelist.SetRemoveReply{})},
+		{"SetMembers em contador", rl.SetMembers(remotelist.SetMembersArgs{Key: "c"}, &remotelist.SetMembersReply{})},
+		{"MapSet em set", rl.MapSet(remotelist.MapSetArgs{Key: "s", Field: "a"}, &remotelist.MapSetReply{})},
+		{"MapGet em lista", rl.MapGet(remotelist.MapGetArgs{Key: "l", Field: "a"}, &remotelist.MapGetReply{})},
+		{"CounterIncr em map", rl.CounterIncr(remotelist.CounterArgs{Key: "m"}, &remotelist.CounterReply{})},
+		{"CounterGet em set", rl.CounterGet(remotelist.CounterGetArgs{Key: "s"}, &remotelist.CounterGetReply{})},
+		{"SortedAdd em map", rl.SortedAdd(remotelist.SortedAddArgs{Key: "m"}, &remotelist.SortedAddReply{})},
+		{"SortedRange em lista", rl.SortedRange(remotelist.SortedRangeArgs{Key: "l"}, &remotelist.SortedRangeReply{})},
+		{"PrepareTx com set", rl.Peer().PrepareTx(remotelist.PrepareTxArgs{TxID: "tx", Ops: []remotelist.TxOp{{Op: "APPEND", ListID: "s", Value: 1}}}, &remotelist.PrepareTxReply{})},
+	}
+	for _, call := range calls {
+		if err := wrongType(call.err, call.what); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, field := range []string{"", "a b"} {
+		if err := rl.MapSet(remotelist.MapSetArgs{Key: "m", Field: field}, &remotelist.MapSetReply{}); err == nil {
+			t.Fatalf("MapSet com campo %q: sem erro", field)
+		}
+	}
+
+	// Chaves inexistentes são lidas como vazias.
+	var members remotelist.SetMembersReply
+	var got remotelist.MapGetReply
+	var value remotelist.CounterGetReply
+	var removed remotelist.SetRemoveReply
+	var kind remotelist.KeyTypeReply
+	if err := errors.Join(
+		rl.SetMembers(remotelist.SetMembersArgs{Key: "nada"}, &members),
+		rl.MapGet(remotelist.MapGetArgs{Key: "nada", Field: "a"}, &got),
+		rl.CounterGet(remotelist.CounterGetArgs{Key: "nada"}, &value),
+		rl.SetRemove(remotelist.SetRemoveArgs{Key: "nada", Member: 1}, &removed),
+		rl.KeyType(remotelist.KeyTypeArgs{Key: "nada"}, &kind),
+	); err != nil || len(members.Members) != 0 || got.Found || value.Value != 0 || removed.Removed || kind.Exists {
+		t.Fatalf("chave inexistente: %v", err)
+	}
+	var lists remotelist.ListsReply
+	if err := rl.Lists(remotelist.ListsArgs{}, &lists); err != nil || !reflect.DeepEqual(lists.ListIDs, []string{"l"}) {
+		t.Fatalf("Lists: %v, %v (esperado só a lista)", err, lists.ListIDs)
+	}
+	if err := rl.KeyType(remotelist.KeyTypeArgs{Key: "m"}, &kind); err != nil || kind.Type != "map" {
+		t.Fatalf("KeyType(m): %v, %+v", err, kind)
+	}
+	if err := expectStructs(rl, m, "depois das recusas"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Apagada, a chave pode voltar com outro tipo, também no replay.
+	if err := rl.Peer().DeleteList(remotelist.DeleteListArgs{ListID: "s"}, &remotelist.DeleteListReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.CounterIncr(remotelist.CounterArgs{Key: "s", By: 5}, &remotelist.CounterReply{}); err != nil {
+		t.Fatalf("CounterIncr no set apagado: %v", err)
+	}
+	delete(m.sets, "s")
+	m.counters["s"] = 5
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectStructs(n.rl, m, "depois da queda"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStructReplay(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newStructModel()
+	if err := structWorkload(n.rl, m, 1, structOps); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectStructs(n.rl, m, "replay do WAL"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := n.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := structWorkload(n.rl, m, 2, structOps); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectStructs(n.rl, m, "snapshot e WAL"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Snapshots no meio das escritas: cada um é uma fotografia de um instante,
+	// e as escritas seguintes não podem alterá-lo (copy-on-write).
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	snapErr := make(chan error, 1)
+	rl := n.rl
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := rl.Snapshot(); err != nil {
+				snapErr <- err
+				return
+			}
+		}
+	}()
+	err = structWorkload(rl, m, 3, structOps)
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-snapErr:
+		t.Fatal(err)
+	default:
+	}
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectStructs(n.rl, m, "snapshots concorrentes"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStructBackup(t *testing.T) {
+	c := newCluster(t)
+	backup, err := c.start(remotelist.Config{Backup: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary, err := c.start(remotelist.Config{Backups: []string{backup.addr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newStructModel()
+	if err := structWorkload(primary.rl, m, 4, structOps); err != nil {
+		t.Fatal(err)
+	}
+	if err := convergeStructs(backup, m, "backup pelo WAL"); err != nil {
+		t.Fatal(err)
+	}
+	if err := backup.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectStructs(backup.rl, m, "backup depois da queda"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Um backup novo, depois de o primário ter descartado o WAL antigo, só
+	// pode receber o estado inteiro.
+	if err := primary.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	primary.stop()
+	fresh, err := c.start(remotelist.Config{Backup: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary.cfg.Backups = []string{fresh.addr}
+	if err := primary.start(primary.cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := structWorkload(primary.rl, m, 5, structOps/4); err != nil {
+		t.Fatal(err)
+	}
+	if err := convergeStructs(fresh, m, "backup pelo estado instalado"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fresh.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectStructs(fresh.rl, m, "backup instalado depois da queda"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// moveKeys copia todas as chaves de from para to, como o roteador de shards
+// (ExportList e ImportList).
+func moveKeys(from, to *remotelist.RemoteList) error {
+	var keys remotelist.KeysReply
+	if err := from.Keys(remotelist.KeysArgs{}, &keys); err != nil {
+		return err
+	}
+	for _, k := range keys.Keys {
+		var data remotelist.ExportListReply
+		if err := from.Peer().ExportList(remotelist.ExportListArgs{ListID: k.Key}, &data); err != nil {
+			return fmt.Errorf("ExportList(%s): %w", k.Key, err)
+		}
+		if !data.Exists {
+			return fmt.Errorf("ExportList(%s): chave inexistente", k.Key)
+		}
+		args := remotelist.ImportListArgs{
+			ListID: k.Key, Values: data.Values, Expires: data.Expires, Delayed: data.Delayed,
+			Structures: data.Structures,
+		}
+		if err := to.Peer().ImportList(args, &remotelist.ImportListReply{}); err != nil {
+			return fmt.Errorf("ImportList(%s): %w", k.Key, err)
+		}
+	}
+	return nil
+}
+
+// As chaves de todos os tipos migram inteiras (registro RESTORE), com o prazo,
+// e o destino as replica e as reconstrói depois de uma queda.
+func TestStructMigration(t *testing.T) {
+	c := newCluster(t)
+	src, err := c.start(remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup, err := c.start(remotelist.Config{Backup: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := c.start(remotelist.Config{Backups: []string{backup.addr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newStructModel()
+	if err := structWorkload(src.rl, m, 6, structOps); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.rl.SetTTL(remotelist.SetTTLArgs{ListID: "s0", TTL: time.Hour}, &remotelist.SetTTLReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := moveKeys(src.rl, dst.rl); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectStructs(dst.rl, m, "destino"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Uma segunda cópia substitui as chaves que o destino já tem.
+	if err := structWorkload(src.rl, m, 7, structOps/4); err != nil {
+		t.Fatal(err)
+	}
+	if err := moveKeys(src.rl, dst.rl); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectStructs(dst.rl, m, "destino depois da segunda cópia"); err != nil {
+		t.Fatal(err)
+	}
+	if err := convergeStructs(backup, m, "backup do destino"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectStructs(dst.rl, m, "destino depois da queda"); err != nil {
+		t.Fatal(err)
+	}
+	var ttl remotelist.TTLReply
+	if err := dst.rl.TTL(remotelist.TTLArgs{ListID: "s0"}, &ttl); err != nil || ttl.Expires.IsZero() {
+		t.Fatalf("TTL(s0) no destino = %+v, %v; esperado o prazo da origem", ttl, err)
+	}
+
+	// Uma chave que não é lista não leva valores junto.
+	bad := remotelist.ImportListArgs{ListID: "c0", Values: []int{1}, Structures: remotelist.Structures{Counters: map[string]int{"c0": 1}}}
+	if err := dst.rl.Peer().ImportList(bad, &remotelist.ImportListReply{}); err == nil {
+		t.Fatal("ImportList de contador com valores: sem erro")
+	}
+}
+
+func TestStructTTL(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := n.rl
+	for _, member := range []int{3, 1, 2} {
+		if err := rl.SetAdd(remotelist.SetAddArgs{Key: "k", Member: member}, &remotelist.SetAddReply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rl.SetTTL(remotelist.SetTTLArgs{ListID: "k", TTL: 100 * time.Millisecond}, &remotelist.SetTTLReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	rl = n.rl
+	m := newStructModel()
+	m.sets["k"] = map[int]bool{1: true, 2: true, 3: true}
+	if err := expectStructs(rl, m, "antes do prazo"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	var kind remotelist.KeyTypeReply
+	var members remotelist.SetMembersReply
+	if err := errors.Join(
+		rl.KeyType(remotelist.KeyTypeArgs{Key: "k"}, &kind),
+		rl.SetMembers(remotelist.SetMembersArgs{Key: "k"}, &members),
+	); err != nil || kind.Exists || len(members.Members) != 0 {
+		t.Fatalf("set vencido: %v, %+v, %v", err, kind, members.Members)
+	}
+	// A chave vencida dá lugar a um map.
+	if err := rl.MapSet(remotelist.MapSetArgs{Key: "k", Field: "f1", Value: 7}, &remotelist.MapSetReply{}); err != nil {
+		t.Fatalf("MapSet na chave vencida: %v", err)
+	}
+	m = newStructModel()
+	m.maps["k"] = map[string]int{"f1": 7}
+	if err := expectStructs(rl, m, "depois do prazo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectStructs(n.rl, m, "depois da queda"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStructLimits(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{MaxListElements: 3, MaxTotalElements: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := n.rl
+	for i := 0; i < 3; i++ {
+		if err := rl.SetAdd(remotelist.SetAddArgs{Key: "s", Member: i}, &remotelist.SetAddReply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = rl.SetAdd(remotelist.SetAddArgs{Key: "s", Member: 9}, &remotelist.SetAddReply{})
+	if code := remotelist.LimitCode(err); code != remotelist.CodeListFull {
+		t.Fatalf("quarto membro: erro %v, esperado %s", err, remotelist.CodeListFull)
+	}
+	// Um membro repetido não é um elemento novo.
+	if err := rl.SetAdd(remotelist.SetAddArgs{Key: "s", Member: 0}, &remotelist.SetAddReply{}); err != nil {
+		t.Fatalf("membro repetido no set cheio: %v", err)
+	}
+	for _, field := range []string{"a", "b"} {
+		if err := rl.MapSet(remotelist.MapSetArgs{Key: "m", Field: field}, &remotelist.MapSetReply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = rl.MapSet(remotelist.MapSetArgs{Key: "m", Field: "c"}, &remotelist.MapSetReply{})
+	if code := remotelist.LimitCode(err); code != remotelist.CodeStoreFull {
+		t.Fatalf("sexto elemento: erro %v, esperado %s", err, remotelist.CodeStoreFull)
+	}
+	if err := rl.MapSet(remotelist.MapSetArgs{Key: "m", Field: "a", Value: 1}, &remotelist.MapSetReply{}); err != nil {
+		t.Fatalf("campo existente no servidor cheio: %v", err)
+	}
+	if err := rl.CounterIncr(remotelist.CounterArgs{Key: "c"}, &remotelist.CounterReply{}); err != nil {
+		t.Fatalf("contador no servidor cheio: %v", err)
+	}
+	if err := rl.SetRemove(remotelist.SetRemoveArgs{Key: "s", Member: 0}, &remotelist.SetRemoveReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.MapSet(remotelist.MapSetArgs{Key: "m", Field: "c"}, &remotelist.MapSetReply{}); err != nil {
+		t.Fatalf("campo depois de liberar espaço: %v", err)
+	}
+	if err := rl.Peer().DeleteList(remotelist.DeleteListArgs{ListID: "m"}, &remotelist.DeleteListReply{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	var st remotelist.LimitStatsReply
+	if err := n.rl.LimitStats(remotelist.LimitStatsArgs{}, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Elements != 2 {
+		t.Fatalf("%d elementos depois da queda, esperado 2 (o set)", st.Elements)
+	}
+}
+
+func TestStructSorted(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := n.rl
+	m := newStructModel()
+	r := rand.New(rand.NewSource(6))
+	// Pontuações em uma faixa pequena: muitos empates, desfeitos pelo membro.
+	for i := 0; i < 3000; i++ {
+		member, score := r.Intn(2000), r.Intn(200)-100
+		if err := rl.SortedAdd(remotelist.SortedAddArgs{Key: "z", Member: member, Score: score}, &remotelist.SortedAddReply{}); err != nil {
+			t.Fatal(err)
+		}
+		if m.sorted["z"] == nil {
+			m.sorted["z"] = map[int]int{}
+		}
+		m.sorted["z"][member] = score
+	}
+
+	all := m.sortedItems("z")
+	for i, it := range all {
+		var rank remotelist.SortedRankReply
+		if err := rl.SortedRank(remotelist.SortedRankArgs{Key: "z", Member: it.Member}, &rank); err != nil {
+			t.Fatal(err)
+		}
+		if !rank.Found || rank.Rank != i || rank.Score != it.Score {
+			t.Fatalf("SortedRank(%d) = %+v, esperado posição %d e pontuação %d", it.Member, rank, i, it.Score)
+		}
+		rank = remotelist.SortedRankReply{}
+		if err := rl.SortedRank(remotelist.SortedRankArgs{Key: "z", Member: it.Member, Reverse: true}, &rank); err != nil {
+			t.Fatal(err)
+		}
+		if rank.Rank != len(all)-1-i {
+			t.Fatalf("SortedRank(%d) do fim = %d, esperado %d", it.Member, rank.Rank, len(all)-1-i)
+		}
+	}
+
+	for round := 0; round < 50; round++ {
+		lo := r.Intn(240) - 120
+		hi := lo + r.Intn(60)
+		args := remotelist.SortedRangeArgs{Key: "z", Reverse: round%2 == 1}
+		if round%5 != 0 {
+			args.Min = &lo
+		}
+		if round%7 != 0 {
+			args.Max = &hi
+		}
+		want := []remotelist.ScoredMember{}
+		for _, it := range all {
+			if (args.Min == nil || it.Score >= *args.Min) && (args.Max == nil || it.Score <= *args.Max) {
+				want = append(want, it)
+			}
+		}
+		if args.Reverse {
+			for i, j := 0, len(want)-1; i < j; i, j = i+1, j-1 {
+				want[i], want[j] = want[j], want[i]
+			}
+		}
+		got := []remotelist.ScoredMember{}
+		for {
+			args.Offset, args.Limit = len(got), 1+r.Intn(200)
+			var reply remotelist.SortedRangeReply
+			if err := rl.SortedRange(args, &reply); err != nil {
+				t.Fatal(err)
+			}
+			if reply.Total != len(want) {
+				t.Fatalf("faixa [%d, %d]: total %d, esperado %d", lo, hi, reply.Total, len(want))
+			}
+			if len(reply.Items) == 0 {
+				break
+			}
+			got = append(got, reply.Items...)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("faixa [%d, %d] (rodada %d): páginas não batem com a ordenação do cliente", lo, hi, round)
+		}
+	}
+
+	// Os pops saem na ordem, nas duas pontas, até esvaziar.
+	for len(m.sorted["z"]) > 0 {
+		count, last := 1+r.Intn(50), r.Intn(2) == 0
+		var reply remotelist.SortedPopReply
+		var err error
+		if last {
+			err = rl.SortedPopMax(remotelist.SortedPopArgs{Key: "z", Count: count}, &reply)
+		} else {
+			err = rl.SortedPopMin(remotelist.SortedPopArgs{Key: "z", Count: count}, &reply)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := m.pop("z", count, last); !reflect.DeepEqual(reply.Items, want) {
+			t.Fatalf("pop de %d: %v, esperado %v", count, reply.Items, want)
+		}
+	}
+	var empty remotelist.SortedPopReply
+	if err := rl.SortedPopMin(remotelist.SortedPopArgs{Key: "z"}, &empty); err != nil || len(empty.Items) != 0 {
+		t.Fatalf("pop do sorted set vazio: %v, %v", err, empty.Items)
+	}
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectStructs(n.rl, m, "depois da queda"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestStructWriteAfterSnapshot mede a primeira escrita em cada tipo de chave
+// grande logo depois de um snapshot: ela copia só um balde dos dados, não a
+// chave inteira.
+func TestStructWriteAfterSnapshot(t *testing.T) {
+	const (
+		members  = 10000
+		maxAlloc = 192 << 10 // Uma cópia inteira de qualquer das chaves passa de 256 KiB
+		maxDelay = 50 * time.Millisecond
+	)
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := n.rl
+	for i := 0; i < members; i++ {
+		if err := rl.SetAdd(remotelist.SetAddArgs{Key: "s", Member: i}, &remotelist.SetAddReply{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := rl.MapSet(remotelist.MapSetArgs{Key: "m", Field: fmt.Sprint("f", i), Value: i}, &remotelist.MapSetReply{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := rl.SortedAdd(remotelist.SortedAddArgs{Key: "z", Member: i, Score: i % 100}, &remotelist.SortedAddReply{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := rl.StreamAppend(remotelist.StreamAppendArgs{Key: "x", Value: i}, &remotelist.StreamAppendReply{}); err != nil {
+			t.Fatal(err)
+		}
+		// Segmentos curtos: o Sync do MemFS copia o arquivo inteiro.
+		if i%2000 == 1999 {
+			if err := rl.Snapshot(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// Todas as entradas do stream ficam pendentes no grupo.
+	if err := rl.StreamGroupCreate(remotelist.StreamGroupCreateArgs{Key: "x", Group: "g"}, &remotelist.StreamGroupCreateReply{}); err != nil {
+		t.Fatal(err)
+	}
+	for read := 0; read < members; {
+		var reply remotelist.StreamReadGroupReply
+		if err := rl.StreamReadGroup(remotelist.StreamReadGroupArgs{Key: "x", Group: "g", Consumer: "c", Count: 10000}, &reply); err != nil {
+			t.Fatal(err)
+		}
+		read += len(reply.Entries)
+	}
+
+	writes := []struct {
+		what  string
+		write func() error
+	}{
+		{"SetAdd", func() error {
+			return rl.SetAdd(remotelist.SetAddArgs{Key: "s", Member: -1}, &remotelist.SetAddReply{})
+		}},
+		{"MapSet", func() error {
+			return rl.MapSet(remotelist.MapSetArgs{Key: "m", Field: "f0", Value: -1}, &remotelist.MapSetReply{})
+		}},
+		{"SortedAdd", func() error {
+			return rl.SortedAdd(remotelist.SortedAddArgs{Key: "z", Member: 0, Score: -1}, &remotelist.SortedAddReply{})
+		}},
+		{"StreamAck", func() error {
+			return rl.StreamAck(remotelist.StreamAckArgs{Key: "x", Group: "g", IDs: []uint64{1}}, &remotelist.StreamAckReply{})
+		}},
+	}
+	for _, w := range writes {
+		if err := rl.Snapshot(); err != nil {
+			t.Fatal(err)
+		}
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		err := w.write()
+		delay := time.Since(start)
+		runtime.ReadMemStats(&after)
+		if err != nil {
+			t.Fatalf("%s: %v", w.what, err)
+		}
+		alloc := after.TotalAlloc - before.TotalAlloc
+		t.Logf("%s depois do snapshot: %v, %d bytes alocados", w.what, delay, alloc)
+		if alloc > maxAlloc {
+			t.Errorf("%s depois do snapshot alocou %d bytes (máximo %d): a chave foi copiada inteira", w.what, alloc, maxAlloc)
+		}
+		if delay > maxDelay {
+			t.Errorf("%s depois do snapshot levou %v (máximo %v)", w.what, delay, maxDelay)
+		}
+	}
+
+	// O snapshot tirado entre as escritas ainda tem os valores de antes delas.
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	rl = n.rl
+	var members2 remotelist.SetMembersReply
+	if err := rl.SetMembers(remotelist.SetMembersArgs{Key: "s"}, &members2); err != nil || len(members2.Members) != members+1 || members2.Members[0] != -1 {
+		t.Fatalf("set depois da queda: %v, %d membros", err, len(members2.Members))
+	}
+	var field remotelist.MapGetReply
+	if err := rl.MapGet(remotelist.MapGetArgs{Key: "m", Field: "f0"}, &field); err != nil || field.Value != -1 {
+		t.Fatalf("map depois da queda: %v, %+v", err, field)
+	}
+	var pending remotelist.StreamPendingReply
+	if err := rl.StreamPending(remotelist.StreamPendingArgs{Key: "x", Group: "g"}, &pending); err != nil || len(pending.Pending) != members-1 {
+		t.Fatalf("pendentes depois da queda: %v, %d", err, len(pending.Pending))
+	}
+}
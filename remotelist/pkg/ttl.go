@@ -0,0 +1,244 @@
+package remotelist
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// --- TTL de listas ---
+//
+// SetTTL dá a uma lista um prazo de validade; Persist o retira. O prazo é um
+// instante absoluto, gravado no WAL (registro EXPIRE) e no snapshot, então
+// continua valendo depois de uma reinicialização, sem ser renovado por ela.
+//
+// Uma lista vencida deixa de existir para as leituras no mesmo instante, mesmo
+// que ainda esteja no map: Get, Remove e ExportList a tratam como inexistente,
+// Size como vazia e Lists não a mostra. Quem a apaga de fato (registro DELETE) é
+// o reaper, que varre as listas a cada Config.ReapInterval, ou a primeira
+// escrita que a recriaria (Append, ImportList, PrepareTx), que recomeça numa
+// lista nova.
+//
+// Uma lista segura por uma transação não vence até a decisão (ver expired).
+// Num backup, o reaper não roda: o DELETE vem do primário, e até lá as leituras
+// escondem a lista pelo relógio local.
+
+// Intervalo padrão entre varreduras do reaper.
+const defaultReapInterval = time.Second
+
+var errListNotFound = errors.New("lista não encontrada")
+
+type SetTTLArgs struct {
+	ListID string
+	TTL    time.Duration
+}
+type SetTTLReply struct {
+	Expires time.Time
+	Token   uint64 // Número do registro da escrita, para leituras ReadYourWrites
+}
+
+type PersistArgs struct {
+	ListID string
+}
+type PersistReply struct {
+	HadTTL bool   // false se a lista não tinha TTL
+	Token  uint64 // Número do registro da escrita, para leituras ReadYourWrites
+}
+
+type TTLArgs struct {
+	ListID string
+	Read   ReadOptions
+}
+type TTLReply struct {
+	HasTTL  bool
+	Expires time.Time
+	TTL     time.Duration // Tempo restante
+}
+
+// SetTTL faz a lista 'list_id' expirar daqui a args.TTL, substituindo um prazo
+// anterior. A lista precisa existir.
+func (rl *RemoteList) SetTTL(args SetTTLArgs, reply *SetTTLReply) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	if args.TTL <= 0 {
+		return fmt.Errorf("TTL inválido: %v (deve ser positivo)", args.TTL)
+	}
+	ml, err := rl.lockExisting(args.ListID)
+	if err != nil {
+		return err
+	}
+	defer ml.mu.Unlock()
+
+	expires := time.Now().Add(args.TTL)
+	seq, err := rl.logRecord(LogRecord{Op: "EXPIRE", ListID: args.ListID, Expires: expires})
+	if err != nil {
+		log.Printf("Erro crítico de persistência (SetTTL): %v", err)
+		return fmt.Errorf("falha ao gravar no disco (WAL): %w", err)
+	}
+	ml.expires = expires
+	reply.Expires, reply.Token = expires, seq
+	return nil
+}
+
+// Persist retira o TTL da lista 'list_id'.
+func (rl *RemoteList) Persist(args PersistArgs, reply *PersistReply) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	ml, err := rl.lockExisting(args.ListID)
+	if err != nil {
+		return err
+	}
+	defer ml.mu.Unlock()
+	if ml.expires.IsZero() {
+		return nil
+	}
+
+	seq, err := rl.logRecord(LogRecord{Op: "PERSIST", ListID: args.ListID})
+	if err != nil {
+		log.Printf("Erro crítico de persistência (Persist): %v", err)
+		return fmt.Errorf("falha ao gravar no disco (WAL): %w", err)
+	}
+	ml.expires = time.Time{}
+	reply.HadTTL, reply.Token = true, seq
+	return nil
+}
+
+// TTL informa o prazo da lista 'list_id' e quanto falta para ele.
+func (rl *RemoteList) TTL(args TTLArgs, reply *TTLReply) error {
+	if forwarded, err := rl.routeRead(args.Read, "TTL", args, reply); forwarded {
+		return err
+	}
+	ml, exists := rl.getList(args.ListID)
+	if !exists {
+		return errListNotFound
+	}
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+	now := time.Now()
+	if ml.deleted || ml.expired(now) {
+		return errListNotFound
+	}
+	if !ml.expires.IsZero() {
+		reply.HasTTL, reply.Expires = true, ml.expires
+		reply.TTL = max(ml.expires.Sub(now), 0)
+	}
+	return nil
+}
+
+// lockExisting bloqueia para escrita uma lista que existe e não venceu.
+func (rl *RemoteList) lockExisting(listID string) (*ManagedList, error) {
+	ml, exists := rl.getList(listID)
+	if !exists {
+		return nil, errListNotFound
+	}
+	ml.mu.Lock()
+	if ml.deleted || ml.expired(time.Now()) {
+		ml.mu.Unlock()
+		return nil, errListNotFound
+	}
+	if ml.txn != "" {
+		ml.mu.Unlock()
+		return nil, errListLocked
+	}
+	return ml, nil
+}
+
+// reap apaga a lista se o TTL dela venceu (registro DELETE). Não pode ser
+// chamado com nenhuma lista bloqueada: o lock do map vem antes.
+func (rl *RemoteList) reap(listID string) error {
+	rl.mapMu.Lock()
+	defer rl.mapMu.Unlock()
+	ml, exists := rl.lists[listID]
+	if !exists {
+		return nil
+	}
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	// Outro reap (ou um Persist) pode ter chegado antes.
+	if !ml.expired(time.Now()) {
+		return nil
+	}
+
+	if _, err := rl.logRecord(LogRecord{Op: "DELETE", ListID: listID}); err != nil {
+		log.Printf("Erro crítico de persistência (TTL): %v", err)
+		return fmt.Errorf("falha ao gravar no disco (WAL): %w", err)
+	}
+	delete(rl.lists, listID)
+	rl.releaseElements(ml.size())
+	ml.replace(nil)
+	ml.deleted = true
+	log.Printf("Lista '%s' expirou e foi apagada.", listID)
+	return nil
+}
+
+// reaper apaga as listas vencidas a cada Config.ReapInterval.
+func (rl *RemoteList) reaper() {
+	interval := rl.cfg.ReapInterval
+	if interval == 0 {
+		interval = defaultReapInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rl.done:
+			return
+		case <-ticker.C:
+		}
+		if rl.readOnly.Load() {
+			continue
+		}
+		for _, id := range rl.expiredLists() {
+			if err := rl.reap(id); err != nil {
+				log.Printf("Erro ao apagar lista expirada '%s': %v", id, err)
+				break
+			}
+		}
+	}
+}
+
+// expiredLists devolve os nomes das listas com o TTL vencido.
+func (rl *RemoteList) expiredLists() []string {
+	now := time.Now()
+	rl.mapMu.RLock()
+	defer rl.mapMu.RUnlock()
+	var ids []string
+	for id, ml := range rl.lists {
+		ml.mu.RLock()
+		if ml.expired(now) {
+			ids = append(ids, id)
+		}
+		ml.mu.RUnlock()
+	}
+	return ids
+}
+
+// captureExpires devolve os prazos das listas que têm TTL, para o snapshot ou
+// para um backup. Deve ser chamado com as listas bloqueadas.
+func captureExpires(ids []string, lists []*ManagedList) map[string]time.Time {
+	var expires map[string]time.Time
+	for i, ml := range lists {
+		if ml.expires.IsZero() {
+			continue
+		}
+		if expires == nil {
+			expires = make(map[string]time.Time)
+		}
+		expires[ids[i]] = ml.expires
+	}
+	return expires
+}
+
+// restoreExpires devolve às listas os prazos de captureExpires. Prazos de
+// listas que não estão em lists são ignorados.
+func restoreExpires(lists map[string]*ManagedList, expires map[string]time.Time) {
+	for id, t := range expires {
+		if ml := lists[id]; ml != nil {
+			ml.expires = t
+		}
+	}
+}
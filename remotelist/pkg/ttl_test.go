@@ -0,0 +1,482 @@
+package remotelist_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"remotelist/pkg"
+)
+
+// TTL das listas (SetTTL, Persist e o reaper) com o RemoteList sobre um
+// MemFS, que simula quedas.
+
+// newTTLHarness devolve um harness com o disco vazio, fechado no fim do teste.
+func newTTLHarness(t *testing.T) *ttlHarness {
+	mem := remotelist.NewMemFS()
+	mem.MkdirAll(dataDir, 0755)
+	h := &ttlHarness{mem: mem}
+	t.Cleanup(h.close)
+	return h
+}
+
+// Prazo curto usado nos cenários e folga para o relógio passar dele.
+const (
+	ttl   = 100 * time.Millisecond
+	slack = 50 * time.Millisecond
+)
+
+// ttlHarness guarda o servidor em teste e o sistema de arquivos dele.
+type ttlHarness struct {
+	mem *remotelist.MemFS
+	rl  *remotelist.RemoteList
+}
+
+// open abre o servidor; reap é o intervalo do reaper (negativo desliga).
+func (h *ttlHarness) open(reap time.Duration) error {
+	rl, err := remotelist.NewRemoteListWithConfig(remotelist.Config{Dir: dataDir, FS: h.mem, SnapshotInterval: -1, ReapInterval: reap})
+	if err != nil {
+		return err
+	}
+	h.rl = rl
+	return nil
+}
+
+func (h *ttlHarness) close() {
+	if h.rl != nil {
+		h.rl.Close()
+		h.rl = nil
+	}
+}
+
+// crash abandona o servidor sem Close e reabre sobre o que estava sincronizado.
+func (h *ttlHarness) crash(reap time.Duration) error {
+	h.rl = nil
+	h.mem = h.mem.Crash()
+	return h.open(reap)
+}
+
+// fill cria a lista com os valores dados.
+func (h *ttlHarness) fill(list string, values ...int) error {
+	for _, v := range values {
+		if err := h.rl.Append(remotelist.AppendArgs{ListID: list, Value: v}, &remotelist.AppendReply{}); err != nil {
+			return fmt.Errorf("Append(%s, %d): %w", list, v, err)
+		}
+	}
+	return nil
+}
+
+func (h *ttlHarness) expire(list string, d time.Duration) (time.Time, error) {
+	var reply remotelist.SetTTLReply
+	if err := h.rl.SetTTL(remotelist.SetTTLArgs{ListID: list, TTL: d}, &reply); err != nil {
+		return time.Time{}, fmt.Errorf("SetTTL(%s): %w", list, err)
+	}
+	return reply.Expires, nil
+}
+
+// state lê todas as listas visíveis pelos métodos RPC.
+func (h *ttlHarness) state() map[string][]int {
+	var lists remotelist.ListsReply
+	h.rl.Lists(remotelist.ListsArgs{}, &lists)
+	out := make(map[string][]int, len(lists.ListIDs))
+	for _, id := range lists.ListIDs {
+		var data remotelist.ExportListReply
+		h.rl.Peer().ExportList(remotelist.ExportListArgs{ListID: id}, &data)
+		if data.Exists {
+			out[id] = data.Values
+		}
+	}
+	return out
+}
+
+func (h *ttlHarness) expect(when string, want map[string][]int) error {
+	if got := h.state(); !reflect.DeepEqual(got, want) {
+		return fmt.Errorf("%s: estado %v, esperado %v", when, got, want)
+	}
+	return nil
+}
+
+func (h *ttlHarness) lastSeq() uint64 {
+	var st remotelist.ReplicationStatusReply
+	h.rl.ReplicationStatus(remotelist.ReplicationStatusArgs{}, &st)
+	return st.LastSeq
+}
+
+// absent confere que todas as leituras tratam a lista como inexistente.
+func absent(rl *remotelist.RemoteList, list string) error {
+	notFound := func(method string, err error) error {
+		if err == nil || err.Error() != "lista não encontrada" {
+			return fmt.Errorf("%s(%s) = %v, esperado lista não encontrada", method, list, err)
+		}
+		return nil
+	}
+	if err := notFound("Get", rl.Get(remotelist.GetArgs{ListID: list}, &remotelist.GetReply{})); err != nil {
+		return err
+	}
+	if err := notFound("Remove", rl.Remove(remotelist.RemoveArgs{ListID: list}, &remotelist.RemoveReply{})); err != nil {
+		return err
+	}
+	if err := notFound("TTL", rl.TTL(remotelist.TTLArgs{ListID: list}, &remotelist.TTLReply{})); err != nil {
+		return err
+	}
+	if err := notFound("SetTTL", rl.SetTTL(remotelist.SetTTLArgs{ListID: list, TTL: time.Hour}, &remotelist.SetTTLReply{})); err != nil {
+		return err
+	}
+	var size remotelist.SizeReply
+	if err := rl.Size(remotelist.SizeArgs{ListID: list}, &size); err != nil || size.Size != 0 {
+		return fmt.Errorf("Size(%s) = %d, %v; esperado 0", list, size.Size, err)
+	}
+	var data remotelist.ExportListReply
+	if err := rl.Peer().ExportList(remotelist.ExportListArgs{ListID: list}, &data); err != nil || data.Exists {
+		return fmt.Errorf("ExportList(%s) = %v, %v; esperado inexistente", list, data.Exists, err)
+	}
+	var lists remotelist.ListsReply
+	rl.Lists(remotelist.ListsArgs{}, &lists)
+	for _, id := range lists.ListIDs {
+		if id == list {
+			return fmt.Errorf("Lists ainda mostra '%s'", list)
+		}
+	}
+	return nil
+}
+
+// --- Cenários ---
+
+func TestTTLHidden(t *testing.T) {
+	h := newTTLHarness(t)
+	if err := h.open(-1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill("a", 1, 2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill("b", 4); err != nil {
+		t.Fatal(err)
+	}
+	expires, err := h.expire("a", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply remotelist.TTLReply
+	if err := h.rl.TTL(remotelist.TTLArgs{ListID: "a"}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if !reply.HasTTL || !reply.Expires.Equal(expires) || reply.TTL <= 0 || reply.TTL > ttl {
+		t.Fatalf("TTL(a) = %+v, esperado prazo %v", reply, expires)
+	}
+	if err := h.expect("antes do prazo", map[string][]int{"a": {1, 2, 3}, "b": {4}}); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Until(expires) + slack)
+	if err := absent(h.rl, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expect("depois do prazo", map[string][]int{"b": {4}}); err != nil {
+		t.Fatal(err)
+	}
+	// Sem reaper, nada foi gravado: a lista só está escondida.
+	seq := h.lastSeq()
+	time.Sleep(slack)
+	if h.lastSeq() != seq {
+		t.Fatal("algo apagou a lista com o reaper desligado")
+	}
+
+	if err := h.rl.SetTTL(remotelist.SetTTLArgs{ListID: "b", TTL: 0}, &remotelist.SetTTLReply{}); err == nil {
+		t.Fatal("SetTTL com TTL zero foi aceito")
+	}
+	if err := h.rl.SetTTL(remotelist.SetTTLArgs{ListID: "nenhuma", TTL: ttl}, &remotelist.SetTTLReply{}); err == nil {
+		t.Fatal("SetTTL numa lista inexistente foi aceito")
+	}
+}
+
+func TestTTLReaper(t *testing.T) {
+	h := newTTLHarness(t)
+	if err := h.open(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if err := h.fill(id, 1, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := h.expire("a", ttl); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.expire("b", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	seq := h.lastSeq()
+
+	// O reaper grava um DELETE (um registro) só para a lista vencida.
+	deadline := time.Now().Add(ttl + 5*time.Second)
+	for h.lastSeq() == seq {
+		if time.Now().After(deadline) {
+			t.Fatal("o reaper não apagou a lista vencida")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(10 * slack)
+	if got := h.lastSeq(); got != seq+1 {
+		t.Fatalf("o reaper gravou %d registros, esperado 1", got-seq)
+	}
+	if err := h.expect("depois do reaper", map[string][]int{"b": {1, 2}, "c": {1, 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// O DELETE está no WAL: a lista não volta nem com o prazo no replay.
+	if err := h.crash(-1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expect("depois da queda", map[string][]int{"b": {1, 2}, "c": {1, 2}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTTLPersist(t *testing.T) {
+	h := newTTLHarness(t)
+	if err := h.open(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill("a", 7); err != nil {
+		t.Fatal(err)
+	}
+	expires, err := h.expire("a", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply remotelist.PersistReply
+	if err := h.rl.Persist(remotelist.PersistArgs{ListID: "a"}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if !reply.HadTTL {
+		t.Fatal("Persist não encontrou o prazo")
+	}
+	var again remotelist.PersistReply
+	if err := h.rl.Persist(remotelist.PersistArgs{ListID: "a"}, &again); err != nil || again.HadTTL {
+		t.Fatalf("segundo Persist = %v, %v; esperado sem prazo", again.HadTTL, err)
+	}
+
+	time.Sleep(time.Until(expires) + slack)
+	if err := h.expect("depois do prazo antigo", map[string][]int{"a": {7}}); err != nil {
+		t.Fatal(err)
+	}
+	var ttlReply remotelist.TTLReply
+	if err := h.rl.TTL(remotelist.TTLArgs{ListID: "a"}, &ttlReply); err != nil || ttlReply.HasTTL {
+		t.Fatalf("TTL(a) = %+v, %v; esperado sem prazo", ttlReply, err)
+	}
+	if err := h.crash(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expect("depois da queda", map[string][]int{"a": {7}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTTLRecreate(t *testing.T) {
+	h := newTTLHarness(t)
+	if err := h.open(-1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill("a", 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill("t", 3); err != nil {
+		t.Fatal(err)
+	}
+	expires, err := h.expire("a", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.expire("t", ttl); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Until(expires) + slack)
+
+	if err := h.fill("a", 9); err != nil {
+		t.Fatal(err)
+	}
+	ops := []remotelist.TxOp{{Op: "APPEND", ListID: "t", Value: 8}}
+	if err := h.rl.Peer().PrepareTx(remotelist.PrepareTxArgs{TxID: "tx1", Ops: ops}, &remotelist.PrepareTxReply{}); err != nil {
+		t.Fatalf("PrepareTx: %v", err)
+	}
+	if err := h.rl.Peer().CommitTx(remotelist.CommitTxArgs{TxID: "tx1"}, &remotelist.CommitTxReply{}); err != nil {
+		t.Fatalf("CommitTx: %v", err)
+	}
+	want := map[string][]int{"a": {9}, "t": {8}}
+	if err := h.expect("depois de recriar", want); err != nil {
+		t.Fatal(err)
+	}
+	var reply remotelist.TTLReply
+	if err := h.rl.TTL(remotelist.TTLArgs{ListID: "a"}, &reply); err != nil || reply.HasTTL {
+		t.Fatalf("TTL(a) = %+v, %v; a lista nova não deveria ter prazo", reply, err)
+	}
+	if err := h.crash(-1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expect("depois da queda", want); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTTLRestart(t *testing.T) {
+	h := newTTLHarness(t)
+	if err := h.open(-1); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"wal", "snap", "gone"} {
+		if err := h.fill(id, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	long := 10 * ttl
+	snapExpires, err := h.expire("snap", long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.expire("gone", ttl); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	// Este prazo só está no WAL, depois do snapshot.
+	walExpires, err := h.expire("wal", long)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// "gone" vence com o servidor parado: volta escondida e o reaper a apaga.
+	if err := h.crash(-1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(ttl + slack)
+	for id, want := range map[string]time.Time{"snap": snapExpires, "wal": walExpires} {
+		var reply remotelist.TTLReply
+		if err := h.rl.TTL(remotelist.TTLArgs{ListID: id}, &reply); err != nil {
+			t.Fatalf("TTL(%s): %v", id, err)
+		}
+		if !reply.Expires.Equal(want) {
+			t.Fatalf("prazo de '%s' = %v depois da queda, esperado %v", id, reply.Expires, want)
+		}
+	}
+	if err := absent(h.rl, "gone"); err != nil {
+		t.Fatalf("depois da queda: %v", err)
+	}
+
+	h.close()
+	if err := h.open(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Until(walExpires) + slack)
+	if err := h.expect("depois dos prazos", map[string][]int{}); err != nil {
+		t.Fatal(err)
+	}
+	// O snapshot seguinte não traz de volta nenhuma lista.
+	if err := h.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.crash(-1); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.expect("depois do snapshot e da queda", map[string][]int{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTTLTransaction(t *testing.T) {
+	h := newTTLHarness(t)
+	if err := h.open(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill("a", 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	expires, err := h.expire("a", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := []remotelist.TxOp{{Op: "REMOVE", ListID: "a"}}
+	if err := h.rl.Peer().PrepareTx(remotelist.PrepareTxArgs{TxID: "tx1", Ops: ops}, &remotelist.PrepareTxReply{}); err != nil {
+		t.Fatalf("PrepareTx: %v", err)
+	}
+	if err := h.rl.SetTTL(remotelist.SetTTLArgs{ListID: "a", TTL: time.Hour}, &remotelist.SetTTLReply{}); err == nil {
+		t.Fatal("SetTTL numa lista segura por transação foi aceito")
+	}
+
+	// Passado o prazo, a lista continua lá (nem o reaper a toca) até a decisão.
+	time.Sleep(time.Until(expires) + 5*slack)
+	var size remotelist.SizeReply
+	if err := h.rl.Size(remotelist.SizeArgs{ListID: "a"}, &size); err != nil || size.Size != 2 {
+		t.Fatalf("Size(a) = %d, %v com a transação pendente; esperado 2", size.Size, err)
+	}
+	// Também depois de uma queda, com a transação restaurada pelo replay.
+	if err := h.crash(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * slack)
+	if err := h.rl.Size(remotelist.SizeArgs{ListID: "a"}, &size); err != nil || size.Size != 2 {
+		t.Fatalf("Size(a) = %d, %v depois da queda; esperado 2", size.Size, err)
+	}
+
+	if err := h.rl.Peer().CommitTx(remotelist.CommitTxArgs{TxID: "tx1"}, &remotelist.CommitTxReply{}); err != nil {
+		t.Fatalf("CommitTx: %v", err)
+	}
+	if err := absent(h.rl, "a"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTTLMigration(t *testing.T) {
+	h := newTTLHarness(t)
+	if err := h.open(-1); err != nil {
+		t.Fatal(err)
+	}
+	dst := &ttlHarness{mem: remotelist.NewMemFS()}
+	dst.mem.MkdirAll(dataDir, 0755)
+	if err := dst.open(-1); err != nil {
+		t.Fatal(err)
+	}
+	defer dst.close()
+
+	if err := h.fill("a", 1, 2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.fill("b", 4); err != nil {
+		t.Fatal(err)
+	}
+	expires, err := h.expire("a", 2*ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b"} {
+		var data remotelist.ExportListReply
+		if err := h.rl.Peer().ExportList(remotelist.ExportListArgs{ListID: id}, &data); err != nil {
+			t.Fatal(err)
+		}
+		args := remotelist.ImportListArgs{ListID: id, Values: data.Values, Expires: data.Expires}
+		if err := dst.rl.Peer().ImportList(args, &remotelist.ImportListReply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Importar por cima de uma lista com prazo deixa só o prazo da origem.
+	if _, err := dst.expire("b", ttl); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.rl.Peer().ImportList(remotelist.ImportListArgs{ListID: "b", Values: []int{4}}, &remotelist.ImportListReply{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dst.crash(-1); err != nil {
+		t.Fatal(err)
+	}
+	var reply remotelist.TTLReply
+	if err := dst.rl.TTL(remotelist.TTLArgs{ListID: "a"}, &reply); err != nil || !reply.Expires.Equal(expires) {
+		t.Fatalf("TTL(a) no destino = %+v, %v; esperado prazo %v", reply, err, expires)
+	}
+	time.Sleep(time.Until(expires) + slack)
+	if err := dst.expect("depois do prazo", map[string][]int{"b": {4}}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,534 @@
+package remotelist
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// --- Transações distribuídas (lado do participante) ---
+//
+// Um coordenador (remotelist/pkg/shard) faz commit em duas fases de operações
+// em listas de servidores diferentes:
+//
+//  1. PrepareTx grava a intenção (registro PREPARE, com fsync) e marca as
+//     listas como seguras pela transação: até a decisão, escritas de fora
+//     nelas recebem errListLocked. Ele também devolve o que cada REMOVE vai
+//     retirar, para o coordenador usar nas operações seguintes;
+//  2. CommitTx grava o COMMIT e aplica as operações; AbortTx grava o ROLLBACK.
+//     Ambos liberam as listas.
+//
+// As transações preparadas sobrevivem a quedas: o replay (ou o snapshot, que
+// as guarda junto com as listas) as restaura, ainda segurando as listas, até o
+// coordenador mandar a decisão. PreparedTxs as lista para a recuperação. Os
+// quatro métodos ficam no serviço Peer (ver peer.go).
+//
+// As últimas maxDecidedTxs decisões também ficam guardadas (no snapshot e no
+// estado dos backups), porque o coordenador reenvia o COMMIT até todos os
+// participantes confirmarem: repetir a decisão já tomada não faz nada, mas um
+// CommitTx de uma transação que este servidor abortou, ou nunca preparou, é
+// um erro, em vez de uma confirmação falsa. Um AbortTx de uma transação
+// desconhecida não faz nada (a recuperação aborta o que pode não ter chegado).
+
+// TxOp é uma operação de uma transação: "APPEND" (com Value) ou "REMOVE" (do
+// último elemento).
+type TxOp struct {
+	Op     string `json:"op"`
+	ListID string `json:"list"`
+	Value  int    `json:"value,omitempty"`
+}
+
+// maxDecidedTxs é quantas decisões (as mais recentes) são lembradas.
+const maxDecidedTxs = 10000
+
+// PreparedTx é uma transação preparada e ainda não decidida.
+type PreparedTx struct {
+	ID  string `json:"id"`
+	Ops []TxOp `json:"ops"`
+}
+
+// TxDecision é a decisão tomada sobre uma transação (ver txDecisions).
+type TxDecision struct {
+	ID     string `json:"id"`
+	Commit bool   `json:"commit,omitempty"`
+}
+
+type PrepareTxArgs struct {
+	TxID string
+	Ops  []TxOp
+}
+type PrepareTxReply struct {
+	Values []int // Para cada operação: o valor retirado (REMOVE) ou inserido (APPEND)
+}
+
+type CommitTxArgs struct {
+	TxID string
+}
+type CommitTxReply struct{}
+
+type AbortTxArgs struct {
+	TxID string
+}
+type AbortTxReply struct{}
+
+type PreparedTxsArgs struct{}
+type PreparedTxsReply struct {
+	Txs []PreparedTx
+}
+
+var errListLocked = errors.New("lista bloqueada por uma transação em andamento")
+
+// prepareTx prepara as operações da transação neste servidor. Pode ser chamado
+// mais de uma vez para a mesma transação: as operações novas se somam às já
+// preparadas (e veem o efeito delas).
+func (rl *RemoteList) prepareTx(args PrepareTxArgs, reply *PrepareTxReply) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	if err := ValidTxID(args.TxID); err != nil {
+		return err
+	}
+	if len(args.Ops) == 0 {
+		return errors.New("transação sem operações")
+	}
+	for _, op := range args.Ops {
+		if op.Op != "APPEND" && op.Op != "REMOVE" {
+			return fmt.Errorf("operação de transação inválida %q", op.Op)
+		}
+	}
+
+	rl.txMu.Lock()
+	var prior []TxOp
+	if tx := rl.prepared[args.TxID]; tx != nil {
+		prior = tx.Ops
+	}
+	_, decided := rl.decided.get(args.TxID)
+	rl.txMu.Unlock()
+	if decided {
+		return fmt.Errorf("transação %s já foi decidida neste servidor", args.TxID)
+	}
+
+	all := append(append([]TxOp(nil), prior...), args.Ops...)
+	locked, err := rl.lockTxLists(all)
+	if err != nil {
+		return err
+	}
+	defer unlockTxLists(locked)
+	for _, ml := range locked {
+		if ml.txn != "" && ml.txn != args.TxID {
+			return errListLocked
+		}
+	}
+
+	values, err := simulateTx(locked, all)
+	if err != nil {
+		return err
+	}
+	if err := rl.checkTxLimits(locked, all); err != nil {
+		return err
+	}
+
+	rec := LogRecord{Op: "PREPARE", TxID: args.TxID, TxOps: args.Ops}
+	if _, err := rl.logRecord(rec); err != nil {
+		log.Printf("Erro crítico de persistência (PrepareTx): %v", err)
+		return fmt.Errorf("falha ao gravar no disco (WAL): %w", err)
+	}
+	rl.txMu.Lock()
+	rl.holdTxn(locked, args.TxID, args.Ops)
+	rl.txMu.Unlock()
+
+	reply.Values = values[len(prior):]
+	return nil
+}
+
+// commitTx aplica as operações preparadas da transação e libera as listas.
+func (rl *RemoteList) commitTx(args CommitTxArgs, reply *CommitTxReply) error {
+	return rl.finishTx(args.TxID, true)
+}
+
+// abortTx descarta as operações preparadas da transação e libera as listas.
+func (rl *RemoteList) abortTx(args AbortTxArgs, reply *AbortTxReply) error {
+	return rl.finishTx(args.TxID, false)
+}
+
+// preparedTxs lista as transações preparadas e ainda não decididas, em ordem de ID.
+func (rl *RemoteList) preparedTxs(args PreparedTxsArgs, reply *PreparedTxsReply) error {
+	rl.txMu.Lock()
+	defer rl.txMu.Unlock()
+	reply.Txs = rl.preparedList()
+	return nil
+}
+
+// finishTx grava a decisão (COMMIT ou ROLLBACK) e a aplica.
+func (rl *RemoteList) finishTx(id string, commit bool) error {
+	if err := rl.checkWritable(); err != nil {
+		return err
+	}
+	rl.txMu.Lock()
+	tx := rl.prepared[id]
+	rl.txMu.Unlock()
+	if tx == nil {
+		return rl.checkDecided(id, commit)
+	}
+
+	locked, err := rl.lockTxLists(tx.Ops)
+	if err != nil {
+		return err
+	}
+	defer unlockTxLists(locked)
+	rl.txMu.Lock()
+	current := rl.prepared[id]
+	rl.txMu.Unlock()
+	if current != tx {
+		// Decidida por outra chamada enquanto esperávamos os locks.
+		return rl.checkDecided(id, commit)
+	}
+
+	rec := LogRecord{Op: "ROLLBACK", TxID: id}
+	if commit {
+		rec = LogRecord{Op: "COMMIT", TxID: id, TxOps: tx.Ops}
+	}
+	if _, err := rl.logRecord(rec); err != nil {
+		log.Printf("Erro crítico de persistência (%s): %v", rec.Op, err)
+		return fmt.Errorf("falha ao gravar no disco (WAL): %w", err)
+	}
+	if commit {
+		// simulateTx já garantiu no prepare que nenhum REMOVE encontra lista vazia,
+		// e as listas não mudaram desde então.
+		for _, op := range tx.Ops {
+			ml := locked[op.ListID]
+			if op.Op == "APPEND" {
+				ml.push(op.Value)
+			} else {
+				ml.pop()
+			}
+		}
+		rl.releaseElements(-netGrowth(tx.Ops))
+	}
+	rl.txMu.Lock()
+	rl.releaseTxn(locked, id)
+	rl.decided.add(id, commit)
+	rl.txMu.Unlock()
+	log.Printf("Transação %s: %s.", id, rec.Op)
+	return nil
+}
+
+// checkDecided responde a um CommitTx ou AbortTx de uma transação que não
+// está preparada aqui: repetir a decisão já tomada não é erro, contrariá-la
+// é, e confirmar uma transação desconhecida também.
+func (rl *RemoteList) checkDecided(id string, commit bool) error {
+	rl.txMu.Lock()
+	committed, known := rl.decided.get(id)
+	rl.txMu.Unlock()
+	switch {
+	case commit && !known:
+		return fmt.Errorf("transação %s não está preparada neste servidor", id)
+	case commit && !committed:
+		return fmt.Errorf("transação %s já foi abortada neste servidor", id)
+	case !commit && committed:
+		return fmt.Errorf("transação %s já foi confirmada neste servidor", id)
+	}
+	return nil
+}
+
+// ValidTxID confere um identificador de transação, que vai num campo do WAL
+// (ver wal.go): ele não pode ser vazio nem ter espaços (de qualquer tipo) ou
+// caracteres de controle.
+func ValidTxID(id string) error {
+	bad := func(r rune) bool { return unicode.IsSpace(r) || !unicode.IsPrint(r) }
+	if id == "" || !utf8.ValidString(id) || strings.IndexFunc(id, bad) >= 0 {
+		return fmt.Errorf("identificador de transação inválido %q", id)
+	}
+	return nil
+}
+
+// checkTxLimits confere os limites de elementos (ver limits.go) com o efeito
+// de todas as operações da transação. Nada é reservado: o total só muda no
+// COMMIT, então transações preparadas ao mesmo tempo podem passar um pouco do
+// limite total.
+func (rl *RemoteList) checkTxLimits(lists map[string]*ManagedList, ops []TxOp) error {
+	growth := make(map[string]int)
+	for _, op := range ops {
+		growth[op.ListID] += netGrowth([]TxOp{op})
+	}
+	for id, n := range growth {
+		if err := rl.reserveElements(id, lists[id].size(), n); err != nil {
+			return err
+		}
+		rl.releaseElements(max(n, 0))
+	}
+	return nil
+}
+
+// netGrowth é quantos elementos as operações acrescentam (APPENDs menos REMOVEs).
+func netGrowth(ops []TxOp) int {
+	n := 0
+	for _, op := range ops {
+		if op.Op == "APPEND" {
+			n++
+		} else {
+			n--
+		}
+	}
+	return n
+}
+
+// lockTxLists bloqueia para escrita as listas usadas pelas operações, em ordem
+// de nome (a mesma em todas as transações e no snapshot, para não haver
+// deadlock). Listas que recebem APPEND são criadas se preciso; as outras
+// precisam existir. Uma lista com o TTL vencido é apagada antes (ver reap),
+// sem nenhuma outra lista bloqueada, e a busca recomeça.
+func (rl *RemoteList) lockTxLists(ops []TxOp) (map[string]*ManagedList, error) {
+	create := make(map[string]bool)
+	for _, op := range ops {
+		create[op.ListID] = create[op.ListID] || op.Op == "APPEND"
+	}
+	ids := make([]string, 0, len(create))
+	for id := range create {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for {
+		locked, retry, err := rl.tryLockTxLists(ids, create)
+		if err != nil || retry == "" {
+			return locked, err
+		}
+		// Se a lista foi apagada por outro (e não venceu), reap não faz nada.
+		if err := rl.reap(retry); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// tryLockTxLists é uma tentativa de lockTxLists. Se uma lista a criar estiver
+// com o TTL vencido, ou tiver sido apagada entre a busca e o lock, solta todas
+// e devolve o nome dela.
+//
+// Todas as chaves são buscadas (e criadas) antes do primeiro lock de lista:
+// rl.mapMu vem antes das listas, e o snapshot segura o read lock dele enquanto
+// espera por elas.
+func (rl *RemoteList) tryLockTxLists(ids []string, create map[string]bool) (map[string]*ManagedList, string, error) {
+	keys := make([]*ManagedList, len(ids))
+	for i, id := range ids {
+		if create[id] {
+			keys[i] = rl.getOrCreateKey(id, KindList)
+			continue
+		}
+		ml, exists := rl.getList(id)
+		if !exists {
+			return nil, "", fmt.Errorf("lista '%s' não encontrada", id)
+		}
+		keys[i] = ml
+	}
+
+	locked := make(map[string]*ManagedList, len(ids))
+	now := time.Now()
+	for i, id := range ids {
+		ml := keys[i]
+		ml.mu.Lock()
+		if create[id] && (ml.deleted || ml.expired(now)) {
+			ml.mu.Unlock()
+			unlockTxLists(locked)
+			return nil, id, nil
+		}
+		if ml.deleted || ml.expired(now) {
+			ml.mu.Unlock()
+			unlockTxLists(locked)
+			return nil, "", fmt.Errorf("lista '%s' não encontrada", id)
+		}
+		if ml.kind != KindList {
+			ml.mu.Unlock()
+			unlockTxLists(locked)
+			return nil, "", errWrongType(id, ml.kind, KindList)
+		}
+		locked[id] = ml
+	}
+	return locked, "", nil
+}
+
+func unlockTxLists(locked map[string]*ManagedList) {
+	for _, ml := range locked {
+		ml.mu.Unlock()
+	}
+}
+
+// simulateTx calcula o valor de cada operação sem alterar as listas.
+// Deve ser chamado com as listas bloqueadas.
+func simulateTx(lists map[string]*ManagedList, ops []TxOp) ([]int, error) {
+	type state struct {
+		popped int   // Elementos originais já retirados
+		added  []int // Elementos inseridos pela transação
+	}
+	states := make(map[string]*state)
+	values := make([]int, len(ops))
+	for i, op := range ops {
+		st := states[op.ListID]
+		if st == nil {
+			st = &state{}
+			states[op.ListID] = st
+		}
+		ml := lists[op.ListID]
+		switch {
+		case op.Op == "APPEND":
+			st.added = append(st.added, op.Value)
+			values[i] = op.Value
+		case len(st.added) > 0:
+			values[i] = st.added[len(st.added)-1]
+			st.added = st.added[:len(st.added)-1]
+		case st.popped < ml.len():
+			values[i] = ml.at(ml.len() - 1 - st.popped)
+			st.popped++
+		default:
+			return nil, fmt.Errorf("lista '%s' vazia", op.ListID)
+		}
+	}
+	return values, nil
+}
+
+// holdTxn registra a transação preparada e marca as listas dela.
+// Deve ser chamado com rl.txMu e as listas bloqueadas (ou no replay).
+func (rl *RemoteList) holdTxn(lists map[string]*ManagedList, id string, ops []TxOp) {
+	if rl.prepared == nil {
+		rl.prepared = make(map[string]*PreparedTx)
+	}
+	tx := rl.prepared[id]
+	if tx == nil {
+		tx = &PreparedTx{ID: id}
+		rl.prepared[id] = tx
+	}
+	tx.Ops = append(tx.Ops, ops...)
+	for _, op := range ops {
+		lists[op.ListID].txn = id
+	}
+}
+
+// releaseTxn esquece a transação e libera as listas dela.
+// Mesmas regras de holdTxn.
+func (rl *RemoteList) releaseTxn(lists map[string]*ManagedList, id string) {
+	tx := rl.prepared[id]
+	if tx == nil {
+		return
+	}
+	for _, op := range tx.Ops {
+		if ml := lists[op.ListID]; ml != nil && ml.txn == id {
+			ml.txn = ""
+		}
+	}
+	delete(rl.prepared, id)
+}
+
+// trackTxn atualiza as transações preparadas com um registro já aplicado por
+// applyRecord (replay e backups). As listas não podem estar em uso por outras
+// goroutines, ou precisam estar bloqueadas.
+func (rl *RemoteList) trackTxn(lists map[string]*ManagedList, rec LogRecord) {
+	rl.txMu.Lock()
+	defer rl.txMu.Unlock()
+	switch rec.Op {
+	case "PREPARE":
+		for _, op := range rec.TxOps {
+			if lists[op.ListID] == nil {
+				lists[op.ListID] = newManagedList(nil)
+			}
+		}
+		rl.holdTxn(lists, rec.TxID, rec.TxOps)
+	case "COMMIT", "ROLLBACK":
+		rl.releaseTxn(lists, rec.TxID)
+		rl.decided.add(rec.TxID, rec.Op == "COMMIT")
+	}
+}
+
+// restorePrepared instala as transações preparadas e as decididas de um
+// snapshot ou de um estado recebido do primário. Mesmas regras de trackTxn.
+func (rl *RemoteList) restorePrepared(lists map[string]*ManagedList, txs []PreparedTx, decided []TxDecision) {
+	rl.txMu.Lock()
+	defer rl.txMu.Unlock()
+	rl.decided = txDecisions{}
+	for _, d := range decided {
+		rl.decided.add(d.ID, d.Commit)
+	}
+	rl.prepared = make(map[string]*PreparedTx, len(txs))
+	for _, tx := range txs {
+		for _, op := range tx.Ops {
+			if lists[op.ListID] == nil {
+				lists[op.ListID] = newManagedList(nil)
+			}
+		}
+		rl.holdTxn(lists, tx.ID, tx.Ops)
+	}
+}
+
+// txLists devolve as listas tocadas pelo registro (para applyReplicated).
+// Deve ser chamado com o lock exclusivo de rl.mapMu.
+func (rl *RemoteList) txLists(rec LogRecord) []string {
+	ops := rec.TxOps
+	if rec.Op == "ROLLBACK" {
+		rl.txMu.Lock()
+		if tx := rl.prepared[rec.TxID]; tx != nil {
+			ops = tx.Ops
+		}
+		rl.txMu.Unlock()
+	}
+	seen := make(map[string]bool)
+	var ids []string
+	for _, op := range ops {
+		if !seen[op.ListID] {
+			seen[op.ListID] = true
+			ids = append(ids, op.ListID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// preparedList devolve as transações preparadas em ordem de ID.
+// Deve ser chamado com rl.txMu.
+func (rl *RemoteList) preparedList() []PreparedTx {
+	out := make([]PreparedTx, 0, len(rl.prepared))
+	for _, tx := range rl.prepared {
+		out = append(out, PreparedTx{ID: tx.ID, Ops: append([]TxOp(nil), tx.Ops...)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// txDecisions guarda as últimas maxDecidedTxs decisões, na ordem em que
+// foram tomadas. Protegido por rl.txMu.
+type txDecisions struct {
+	commit map[string]bool
+	order  []string
+}
+
+func (d *txDecisions) add(id string, commit bool) {
+	if d.commit == nil {
+		d.commit = make(map[string]bool)
+	}
+	if _, ok := d.commit[id]; !ok {
+		d.order = append(d.order, id)
+	}
+	d.commit[id] = commit
+	for len(d.order) > maxDecidedTxs {
+		delete(d.commit, d.order[0])
+		d.order = d.order[1:]
+	}
+}
+
+// get diz se a transação foi confirmada e se a decisão é conhecida.
+func (d *txDecisions) get(id string) (commit, known bool) {
+	commit, known = d.commit[id]
+	return commit, known
+}
+
+// list devolve as decisões na ordem em que foram tomadas.
+func (d *txDecisions) list() []TxDecision {
+	out := make([]TxDecision, len(d.order))
+	for i, id := range d.order {
+		out[i] = TxDecision{ID: id, Commit: d.commit[id]}
+	}
+	return out
+}
@@ -0,0 +1,99 @@
+package remotelist_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"remotelist/pkg"
+)
+
+// TestTxSnapshotLockOrder roda PrepareTx (criando listas que vêm depois, na
+// ordem de nome, de uma já existente) ao mesmo tempo que snapshots. As
+// transações não podem buscar chaves no map com uma lista bloqueada, e o
+// snapshot bloqueia as listas na mesma ordem delas: senão os dois se
+// esperam para sempre.
+func TestTxSnapshotLockOrder(t *testing.T) {
+	mem := remotelist.NewMemFS()
+	mem.MkdirAll(dataDir, 0755)
+	rl, err := remotelist.NewRemoteListWithConfig(remotelist.Config{Dir: dataDir, FS: mem, SnapshotInterval: -1, ReapInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+
+	const workers, rounds = 8, 300
+	for w := 0; w < workers; w++ {
+		id := fmt.Sprintf("a%d", w)
+		if err := rl.Append(remotelist.AppendArgs{ListID: id, Value: w}, &remotelist.AppendReply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers+1)
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := rl.Snapshot(); err != nil {
+				errs <- fmt.Errorf("Snapshot: %w", err)
+				return
+			}
+		}
+	}()
+	var txs sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		txs.Add(1)
+		go func(w int) {
+			defer txs.Done()
+			for i := 0; i < rounds; i++ {
+				txID := fmt.Sprintf("tx-%d-%d", w, i)
+				ops := []remotelist.TxOp{
+					{Op: "APPEND", ListID: fmt.Sprintf("a%d", w), Value: i},
+					{Op: "APPEND", ListID: fmt.Sprintf("z%d-%d", w, i), Value: i},
+				}
+				if err := rl.Peer().PrepareTx(remotelist.PrepareTxArgs{TxID: txID, Ops: ops}, &remotelist.PrepareTxReply{}); err != nil {
+					errs <- fmt.Errorf("PrepareTx(%s): %w", txID, err)
+					return
+				}
+				if err := rl.Peer().CommitTx(remotelist.CommitTxArgs{TxID: txID}, &remotelist.CommitTxReply{}); err != nil {
+					errs <- fmt.Errorf("CommitTx(%s): %w", txID, err)
+					return
+				}
+			}
+		}(w)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		txs.Wait()
+		close(stop)
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("PrepareTx e Snapshot não terminaram: deadlock")
+	}
+	close(errs)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	var size remotelist.SizeReply
+	if err := rl.Size(remotelist.SizeArgs{ListID: "a0"}, &size); err != nil {
+		t.Fatal(err)
+	}
+	if size.Size != rounds+1 {
+		t.Fatalf("a0 com %d elementos, esperado %d", size.Size, rounds+1)
+	}
+}
@@ -0,0 +1,708 @@
+package remotelist
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// O WAL é dividido em segmentos numerados: remotelist.log.000001, remotelist.log.000002...
+// Só o segmento mais novo recebe escritas. Um snapshot sela o segmento atual, abre
+// o próximo e, depois de gravado, apaga (ou arquiva) os segmentos que ele cobre.
+
+// segmentName devolve o nome do arquivo do segmento n.
+func segmentName(n uint64) string {
+	return fmt.Sprintf("%s.%06d", logFile, n)
+}
+
+// parseSegmentName extrai o número de um nome de segmento.
+func parseSegmentName(name string) (uint64, bool) {
+	suffix, ok := strings.CutPrefix(name, logFile+".")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(suffix, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// listSegments retorna, em ordem crescente, os números dos segmentos em dir.
+func listSegments(fsys FS, dir string) ([]uint64, error) {
+	names, err := fsys.ReadDir(dirOrDot(dir))
+	if err != nil {
+		return nil, err
+	}
+	var segments []uint64
+	for _, name := range names {
+		if n, ok := parseSegmentName(name); ok {
+			segments = append(segments, n)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+// openSegment cria (ou reabre) o segmento n para escrita no final.
+func (rl *RemoteList) openSegment(n uint64) error {
+	f, err := rl.fs.OpenFile(rl.path(segmentName(n)), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("falha ao abrir segmento %d do log: %w", n, err)
+	}
+	rl.logFile = f
+	rl.segment = n
+	return nil
+}
+
+// rotateSegment sela o segmento atual e passa a escrever no próximo.
+// Retorna o número do novo segmento. Deve ser chamado com rl.logLock.
+func (rl *RemoteList) rotateSegment() (uint64, error) {
+	next := rl.segment + 1
+	f, err := rl.fs.OpenFile(rl.path(segmentName(next)), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("falha ao abrir segmento %d do log: %w", next, err)
+	}
+	if err := syncDir(rl.fs, rl.cfg.Dir); err != nil {
+		f.Close()
+		return 0, err
+	}
+	// O segmento antigo já teve cada registro sincronizado por logOperation.
+	if err := rl.logFile.Close(); err != nil {
+		log.Printf("Erro ao fechar segmento %d do log: %v", rl.segment, err)
+	}
+	rl.logFile = f
+	rl.segment = next
+	rl.resealAbandoned()
+	return next, nil
+}
+
+// resealSegment grava o bloco final no segmento n, se ele ficou sem (ver
+// EncryptedFS.reseal).
+func (rl *RemoteList) resealSegment(n uint64) error {
+	e, ok := rl.fs.(*EncryptedFS)
+	if !ok {
+		return nil
+	}
+	return e.reseal(rl.path(segmentName(n)))
+}
+
+// resealAbandoned grava o bloco final nos segmentos abandonados por repairLog.
+// Os que falharem ficam para a próxima troca de segmento.
+// Deve ser chamado com rl.logLock.
+func (rl *RemoteList) resealAbandoned() {
+	pending := rl.abandoned[:0]
+	for _, n := range rl.abandoned {
+		if err := rl.resealSegment(n); err != nil {
+			log.Printf("Erro ao fechar o segmento abandonado %d: %v", n, err)
+			pending = append(pending, n)
+		}
+	}
+	rl.abandoned = pending
+}
+
+// retireSegments remove os segmentos anteriores a 'next', que já estão cobertos
+// pelo snapshot gravado. Com Config.ArchiveDir, eles são movidos para lá.
+func (rl *RemoteList) retireSegments(next uint64) error {
+	segments, err := listSegments(rl.fs, rl.cfg.Dir)
+	if err != nil {
+		return err
+	}
+	for _, n := range segments {
+		if n >= next {
+			break
+		}
+		name := segmentName(n)
+		retired, err := rl.history.retire(n, func() error {
+			if rl.cfg.ArchiveDir != "" {
+				if err := moveFile(rl.fs, rl.path(name), filepath.Join(rl.cfg.ArchiveDir, name)); err != nil {
+					return fmt.Errorf("falha ao arquivar segmento %d: %w", n, err)
+				}
+				return nil
+			}
+			if err := rl.fs.Remove(rl.path(name)); err != nil {
+				return fmt.Errorf("falha ao apagar segmento %d: %w", n, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// Um GetAt está lendo este segmento e os seguintes: ficam para o
+		// próximo snapshot (o replay já pula os anteriores a next).
+		if !retired {
+			break
+		}
+	}
+	return nil
+}
+
+// migrateLegacyLog renomeia o antigo remotelist.log (arquivo único) para o
+// segmento 0, o mais antigo possível, para que ele seja reaplicado normalmente.
+func (rl *RemoteList) migrateLegacyLog() error {
+	legacy := rl.path(logFile)
+	info, err := rl.fs.Stat(legacy)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return rl.fs.Remove(legacy)
+	}
+	log.Println("Migrando remotelist.log para o segmento 0 do WAL.")
+	return rl.fs.Rename(legacy, rl.path(segmentName(0)))
+}
+
+// LogRecord é uma linha do log. Seq é o número de sequência (LSN) global e
+// Time o instante em que a operação foi logada. Registros do formato antigo
+// ("APPEND lista 10") não têm Seq nem Time: ficam com zero.
+//
+// Um registro ABORT anula o registro de número Target: a escrita dele falhou
+// (o cliente recebeu erro e a memória não mudou), mas a linha pode ter chegado
+// ao disco inteira ou pela metade.
+//
+// REPLACE troca todo o conteúdo de uma lista por Values e DELETE apaga a lista;
+// são usados na migração de listas entre servidores (ImportList e DeleteList).
+// RESTORE é o REPLACE das chaves que não são listas: State traz todo o
+// conteúdo da chave.
+//
+// PREPARE, COMMIT e ROLLBACK são as etapas de uma transação distribuída (ver
+// txn.go). PREPARE só registra a intenção (TxOps) e não muda as listas; COMMIT
+// repete as operações e as aplica, então pode ser reaplicado sem o PREPARE
+// (que pode estar em um segmento já coberto por snapshot).
+//
+// EXPIRE dá à lista o prazo absoluto Expires (ver ttl.go) e PERSIST tira o
+// prazo. A lista some com um DELETE quando o prazo vence.
+//
+// DELAY agenda Value para o instante Due (o ID do item é o Seq do registro),
+// FIRE move os Value primeiros itens agendados para o fim da lista e UNDELAY
+// cancela o item Target (ver delayed.go).
+type LogRecord struct {
+	Seq      uint64
+	Time     time.Time
+	Op       string
+	ListID   string
+	Value    int
+	Values   []int
+	Target   uint64
+	TxID     string
+	TxOps    []TxOp
+	Expires  time.Time
+	Due      time.Time   // Instante de disparo de um item agendado (DELAY)
+	Field    string      // Campo de um map (HSET, HDEL)
+	Score    int         // Pontuação de um membro de sorted set (ZADD)
+	Consumer string      // Consumidor de um grupo de stream (XREADGROUP, XCLAIM)
+	State    *Structures // Conteúdo de uma chave que não é lista (RESTORE)
+}
+
+// encode formata o registro como uma linha do log:
+// "<seq> <unix-nano> APPEND <lista> <valor>", "<seq> <unix-nano> REMOVE <lista>",
+// "<seq> <unix-nano> REPLACE <lista> <valores...>", "<seq> <unix-nano> DELETE <lista>",
+// "<seq> <unix-nano> ABORT <seq anulado>",
+// "<seq> <unix-nano> EXPIRE <lista> <prazo unix-nano>", "<seq> <unix-nano> PERSIST <lista>",
+// "<seq> <unix-nano> DELAY <lista> <valor> <disparo unix-nano>", "<seq> <unix-nano> FIRE <lista> <n>",
+// "<seq> <unix-nano> UNDELAY <lista> <ID>",
+// "<seq> <unix-nano> PREPARE|COMMIT <tx> APPEND <lista> <valor> REMOVE <lista> ...",
+// "<seq> <unix-nano> ROLLBACK <tx>" ou, nos outros tipos de chave (ver structures.go),
+// "<seq> <unix-nano> SADD|SREM <chave> <membro>", "<seq> <unix-nano> HSET <chave> <campo> <valor>",
+// "<seq> <unix-nano> HDEL <chave> <campo>", "<seq> <unix-nano> INCR <chave> <delta>",
+// "<seq> <unix-nano> ZADD <chave> <membro> <pontuação>", "<seq> <unix-nano> ZPOPMIN|ZPOPMAX <chave> <n>",
+// "<seq> <unix-nano> RESTORE <chave> <conteúdo em JSON>" e os registros dos
+// streams (ver streams.go).
+//
+// Os campos são separados por espaços, então o nome de uma chave vazia ou com
+// espaços vai entre aspas (ver logKey). Campos, grupos, consumidores e IDs de
+// transação não podem ter espaços (ver validField).
+func (r LogRecord) encode() (string, error) {
+	switch r.Op {
+	case "PREPARE", "COMMIT":
+		var b strings.Builder
+		fmt.Fprintf(&b, "%d %d %s %s", r.Seq, r.Time.UnixNano(), r.Op, r.TxID)
+		for _, op := range r.TxOps {
+			switch op.Op {
+			case "APPEND":
+				fmt.Fprintf(&b, " %s %s %d", op.Op, logKey(op.ListID), op.Value)
+			case "REMOVE":
+				fmt.Fprintf(&b, " %s %s", op.Op, logKey(op.ListID))
+			default:
+				return "", errors.New("operação de transação inválida")
+			}
+		}
+		b.WriteByte('\n')
+		return b.String(), nil
+	case "ROLLBACK":
+		return fmt.Sprintf("%d %d %s %s\n", r.Seq, r.Time.UnixNano(), r.Op, r.TxID), nil
+	case "APPEND", "FIRE", "SADD", "SREM", "INCR", "ZPOPMIN", "ZPOPMAX", "XADD":
+		return fmt.Sprintf("%d %d %s %s %d\n", r.Seq, r.Time.UnixNano(), r.Op, logKey(r.ListID), r.Value), nil
+	case "DELAY":
+		return fmt.Sprintf("%d %d %s %s %d %d\n", r.Seq, r.Time.UnixNano(), r.Op, logKey(r.ListID), r.Value, r.Due.UnixNano()), nil
+	case "UNDELAY", "XTRIM":
+		return fmt.Sprintf("%d %d %s %s %d\n", r.Seq, r.Time.UnixNano(), r.Op, logKey(r.ListID), r.Target), nil
+	case "XGROUP":
+		return fmt.Sprintf("%d %d %s %s %s %d\n", r.Seq, r.Time.UnixNano(), r.Op, logKey(r.ListID), r.Field, r.Target), nil
+	case "XREADGROUP":
+		return fmt.Sprintf("%d %d %s %s %s %s %d\n", r.Seq, r.Time.UnixNano(), r.Op, logKey(r.ListID), r.Field, r.Consumer, r.Value), nil
+	case "XACK", "XCLAIM":
+		var b strings.Builder
+		fmt.Fprintf(&b, "%d %d %s %s %s", r.Seq, r.Time.UnixNano(), r.Op, logKey(r.ListID), r.Field)
+		if r.Op == "XCLAIM" {
+			fmt.Fprintf(&b, " %s", r.Consumer)
+		}
+		for _, v := range r.Values {
+			b.WriteByte(' ')
+			b.WriteString(strconv.Itoa(v))
+		}
+		b.WriteByte('\n')
+		return b.String(), nil
+	case "ZADD":
+		return fmt.Sprintf("%d %d %s %s %d %d\n", r.Seq, r.Time.UnixNano(), r.Op, logKey(r.ListID), r.Value, r.Score), nil
+	case "HSET":
+		return fmt.Sprintf("%d %d %s %s %s %d\n", r.Seq, r.Time.UnixNano(), r.Op, logKey(r.ListID), r.Field, r.Value), nil
+	case "HDEL":
+		return fmt.Sprintf("%d %d %s %s %s\n", r.Seq, r.Time.UnixNano(), r.Op, logKey(r.ListID), r.Field), nil
+	case "REMOVE":
+		return fmt.Sprintf("%d %d %s %s\n", r.Seq, r.Time.UnixNano(), r.Op, logKey(r.ListID)), nil
+	case "REPLACE":
+		var b strings.Builder
+		fmt.Fprintf(&b, "%d %d %s %s", r.Seq, r.Time.UnixNano(), r.Op, logKey(r.ListID))
+		for _, v := range r.Values {
+			b.WriteByte(' ')
+			b.WriteString(strconv.Itoa(v))
+		}
+		b.WriteByte('\n')
+		return b.String(), nil
+	case "RESTORE":
+		state, err := json.Marshal(r.State)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d %d %s %s %s\n", r.Seq, r.Time.UnixNano(), r.Op, logKey(r.ListID), escapeSpaces(state)), nil
+	case "DELETE", "PERSIST":
+		return fmt.Sprintf("%d %d %s %s\n", r.Seq, r.Time.UnixNano(), r.Op, logKey(r.ListID)), nil
+	case "EXPIRE":
+		return fmt.Sprintf("%d %d %s %s %d\n", r.Seq, r.Time.UnixNano(), r.Op, logKey(r.ListID), r.Expires.UnixNano()), nil
+	case "ABORT":
+		return fmt.Sprintf("%d %d %s %d\n", r.Seq, r.Time.UnixNano(), r.Op, r.Target), nil
+	}
+	return "", errors.New("operação de log inválida")
+}
+
+// parseRecord interpreta uma linha do log (formato novo ou antigo).
+func parseRecord(line string) (LogRecord, error) {
+	var rec LogRecord
+	parts := strings.Fields(line) // strings.Fields lida com espaços
+
+	// Formato novo: os dois primeiros campos são numéricos (seq e timestamp).
+	// No antigo o primeiro campo é sempre o nome da operação.
+	if len(parts) > 0 {
+		if seq, err := strconv.ParseUint(parts[0], 10, 64); err == nil {
+			if len(parts) < 2 {
+				return rec, errors.New("registro sem timestamp")
+			}
+			nanos, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return rec, errors.New("timestamp inválido")
+			}
+			rec.Seq = seq
+			rec.Time = time.Unix(0, nanos)
+			parts = parts[2:]
+		}
+	}
+
+	if len(parts) < 2 {
+		return rec, errors.New("linha de log mal formatada")
+	}
+	if parts[0] == "ABORT" {
+		target, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil || rec.Seq == 0 {
+			return rec, errors.New("ABORT mal formatado")
+		}
+		rec.Op, rec.Target = parts[0], target
+		return rec, nil
+	}
+	switch parts[0] {
+	case "PREPARE", "COMMIT":
+		ops, err := parseTxOps(parts[2:])
+		if err != nil {
+			return rec, fmt.Errorf("%s mal formatado: %w", parts[0], err)
+		}
+		rec.Op, rec.TxID, rec.TxOps = parts[0], parts[1], ops
+		return rec, nil
+	case "ROLLBACK":
+		rec.Op, rec.TxID = parts[0], parts[1]
+		return rec, nil
+	}
+	rec.Op = parts[0]
+	id, err := parseLogKey(parts[1])
+	if err != nil {
+		return rec, fmt.Errorf("%s com chave inválida", rec.Op)
+	}
+	rec.ListID = id
+
+	switch rec.Op {
+	case "APPEND", "FIRE", "SADD", "SREM", "INCR", "ZPOPMIN", "ZPOPMAX", "XADD":
+		if len(parts) < 3 {
+			return rec, fmt.Errorf("%s mal formatado", rec.Op)
+		}
+		val, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return rec, fmt.Errorf("%s com valor inválido", rec.Op)
+		}
+		rec.Value = val
+	case "HSET":
+		if len(parts) < 4 {
+			return rec, errors.New("HSET mal formatado")
+		}
+		val, err := strconv.Atoi(parts[3])
+		if err != nil {
+			return rec, errors.New("HSET com valor inválido")
+		}
+		rec.Field, rec.Value = parts[2], val
+	case "ZADD":
+		if len(parts) < 4 {
+			return rec, errors.New("ZADD mal formatado")
+		}
+		member, err1 := strconv.Atoi(parts[2])
+		score, err2 := strconv.Atoi(parts[3])
+		if err1 != nil || err2 != nil {
+			return rec, errors.New("ZADD com valor inválido")
+		}
+		rec.Value, rec.Score = member, score
+	case "DELAY":
+		if len(parts) < 4 {
+			return rec, errors.New("DELAY mal formatado")
+		}
+		val, err1 := strconv.Atoi(parts[2])
+		nanos, err2 := strconv.ParseInt(parts[3], 10, 64)
+		if err1 != nil || err2 != nil {
+			return rec, errors.New("DELAY com valor ou instante inválido")
+		}
+		rec.Value, rec.Due = val, time.Unix(0, nanos)
+	case "UNDELAY", "XTRIM", "XGROUP":
+		fields := parts[2:]
+		if rec.Op == "XGROUP" {
+			if len(fields) < 2 {
+				return rec, errors.New("XGROUP mal formatado")
+			}
+			rec.Field, fields = fields[0], fields[1:]
+		}
+		if len(fields) < 1 {
+			return rec, fmt.Errorf("%s mal formatado", rec.Op)
+		}
+		target, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return rec, fmt.Errorf("%s com ID inválido", rec.Op)
+		}
+		rec.Target = target
+	case "XREADGROUP":
+		if len(parts) < 5 {
+			return rec, errors.New("XREADGROUP mal formatado")
+		}
+		n, err := strconv.Atoi(parts[4])
+		if err != nil {
+			return rec, errors.New("XREADGROUP com quantidade inválida")
+		}
+		rec.Field, rec.Consumer, rec.Value = parts[2], parts[3], n
+	case "XACK", "XCLAIM":
+		fields := parts[2:]
+		if len(fields) < 1 || (rec.Op == "XCLAIM" && len(fields) < 2) {
+			return rec, fmt.Errorf("%s mal formatado", rec.Op)
+		}
+		rec.Field, fields = fields[0], fields[1:]
+		if rec.Op == "XCLAIM" {
+			rec.Consumer, fields = fields[0], fields[1:]
+		}
+		for _, field := range fields {
+			id, err := strconv.Atoi(field)
+			if err != nil {
+				return rec, fmt.Errorf("%s com ID inválido", rec.Op)
+			}
+			rec.Values = append(rec.Values, id)
+		}
+	case "HDEL":
+		if len(parts) < 3 {
+			return rec, errors.New("HDEL mal formatado")
+		}
+		rec.Field = parts[2]
+	case "REPLACE":
+		rec.Values = make([]int, 0, len(parts)-2)
+		for _, field := range parts[2:] {
+			val, err := strconv.Atoi(field)
+			if err != nil {
+				return rec, errors.New("REPLACE com valor inválido")
+			}
+			rec.Values = append(rec.Values, val)
+		}
+	case "RESTORE":
+		if len(parts) != 3 {
+			return rec, errors.New("RESTORE mal formatado")
+		}
+		var state Structures
+		if err := json.Unmarshal([]byte(parts[2]), &state); err != nil {
+			return rec, errors.New("RESTORE com conteúdo inválido")
+		}
+		if _, ok := state.single(rec.ListID); !ok {
+			return rec, errors.New("RESTORE sem o conteúdo da chave")
+		}
+		rec.State = &state
+	case "EXPIRE":
+		if len(parts) < 3 {
+			return rec, errors.New("EXPIRE mal formatado")
+		}
+		nanos, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return rec, errors.New("EXPIRE com prazo inválido")
+		}
+		rec.Expires = time.Unix(0, nanos)
+	case "REMOVE", "DELETE", "PERSIST":
+	default:
+		return rec, fmt.Errorf("operação de log desconhecida %q", rec.Op)
+	}
+	return rec, nil
+}
+
+// logKey escreve o nome de uma chave como um campo do log. Um nome vazio, com
+// espaços, com caracteres não imprimíveis ou começando por aspas vai entre
+// aspas, com os espaços escapados; os demais vão como estão, como no formato
+// antigo.
+func logKey(id string) string {
+	plain := id != "" && id[0] != '"' && utf8.ValidString(id) &&
+		strings.IndexFunc(id, func(r rune) bool { return r == ' ' || !strconv.IsPrint(r) }) < 0
+	if plain {
+		return id
+	}
+	// strconv.Quote já escapa os outros espaços (não imprimíveis).
+	return strings.ReplaceAll(strconv.Quote(id), " ", `\x20`)
+}
+
+// parseLogKey desfaz logKey.
+func parseLogKey(field string) (string, error) {
+	if !strings.HasPrefix(field, `"`) {
+		return field, nil
+	}
+	return strconv.Unquote(field)
+}
+
+// escapeSpaces troca os espaços de um JSON (que só podem estar dentro das
+// strings) por escapes \uXXXX, para que ele seja um único campo do log.
+func escapeSpaces(data []byte) string {
+	var b strings.Builder
+	for _, r := range string(data) {
+		if unicode.IsSpace(r) {
+			fmt.Fprintf(&b, `\u%04x`, r)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// parseTxOps interpreta as operações de um PREPARE ou COMMIT.
+func parseTxOps(fields []string) ([]TxOp, error) {
+	var ops []TxOp
+	for len(fields) > 0 {
+		switch {
+		case fields[0] == "APPEND" && len(fields) >= 3:
+			v, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, errors.New("valor inválido")
+			}
+			id, err := parseLogKey(fields[1])
+			if err != nil {
+				return nil, errors.New("chave inválida")
+			}
+			ops = append(ops, TxOp{Op: "APPEND", ListID: id, Value: v})
+			fields = fields[3:]
+		case fields[0] == "REMOVE" && len(fields) >= 2:
+			id, err := parseLogKey(fields[1])
+			if err != nil {
+				return nil, errors.New("chave inválida")
+			}
+			ops = append(ops, TxOp{Op: "REMOVE", ListID: id})
+			fields = fields[2:]
+		default:
+			return nil, fmt.Errorf("operação inválida %q", fields[0])
+		}
+	}
+	return ops, nil
+}
+
+// scanLog lê um log linha a linha e chama fn com o número da linha (a partir de 1),
+// o texto e o registro interpretado (ou o erro de interpretação).
+// Se fn retornar erro, a leitura para e o erro é devolvido.
+func scanLog(r io.Reader, fn func(lineNo int, line string, rec LogRecord, err error) error) error {
+	reader := bufio.NewReader(r)
+	lineNo := 0
+	for {
+		line, readErr := reader.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return fmt.Errorf("erro ao escanear arquivo de log: %w", readErr)
+		}
+		if line == "" && readErr == io.EOF {
+			return nil
+		}
+		lineNo++
+		complete := strings.HasSuffix(line, "\n")
+		line = strings.TrimRight(line, "\r\n")
+		if strings.TrimSpace(line) != "" {
+			rec, err := parseRecord(line)
+			// Uma última linha sem '\n' é uma escrita interrompida: mesmo que ela
+			// pareça válida, o valor pode ter sido cortado no meio.
+			if err == nil && !complete {
+				err = errors.New("registro incompleto (escrita interrompida)")
+			}
+			if err := fn(lineNo, line, rec, err); err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+	}
+}
+
+// applyRecord aplica um registro sobre um conjunto de listas, criando a lista se
+// preciso. É o mesmo caminho usado no replay e na reconstrução de estados antigos.
+// As listas não podem estar em uso por outras goroutines.
+func applyRecord(lists map[string]*ManagedList, rec LogRecord) error {
+	switch rec.Op {
+	case "ABORT":
+		return nil // Só afeta o replay do registro anulado (ver collectAborted).
+	case "PREPARE", "ROLLBACK":
+		return nil // Só seguram ou liberam as listas (ver trackTxn).
+	case "COMMIT":
+		for _, op := range rec.TxOps {
+			if err := applyRecord(lists, LogRecord{Op: op.Op, ListID: op.ListID, Value: op.Value}); err != nil {
+				return fmt.Errorf("transação %s: %w", rec.TxID, err)
+			}
+		}
+		return nil
+	case "DELETE":
+		delete(lists, rec.ListID)
+		return nil
+	case "REPLACE":
+		lists[rec.ListID] = newManagedList(rec.Values)
+		return nil
+	case "RESTORE":
+		restoreKey(lists, rec.ListID, *rec.State)
+		return nil
+	case "EXPIRE", "PERSIST":
+		ml, exists := lists[rec.ListID]
+		if !exists {
+			return fmt.Errorf("%s em lista inexistente ('%s')", rec.Op, rec.ListID)
+		}
+		ml.expires = rec.Expires // Zero no PERSIST
+		return nil
+	case "SADD", "SREM", "HSET", "HDEL", "INCR", "ZADD", "ZPOPMIN", "ZPOPMAX",
+		"XADD", "XTRIM", "XGROUP", "XREADGROUP", "XACK", "XCLAIM":
+		return applyStructureRecord(lists, rec)
+	}
+	ml, exists := lists[rec.ListID]
+	if !exists {
+		ml = newManagedList(nil)
+		lists[rec.ListID] = ml
+	}
+	if ml.kind != KindList {
+		return errWrongType(rec.ListID, ml.kind, KindList)
+	}
+
+	switch rec.Op {
+	case "APPEND":
+		ml.push(rec.Value)
+	case "REMOVE":
+		if ml.len() == 0 {
+			return fmt.Errorf("REMOVE em lista vazia ('%s')", rec.ListID)
+		}
+		ml.pop()
+	case "DELAY":
+		ml.schedule(DelayedItem{ID: rec.Seq, Value: rec.Value, Due: rec.Due})
+	case "FIRE":
+		if rec.Value <= 0 || rec.Value > len(ml.delayed) {
+			return fmt.Errorf("FIRE de %d itens com %d agendados ('%s')", rec.Value, len(ml.delayed), rec.ListID)
+		}
+		ml.fire(rec.Value)
+	case "UNDELAY":
+		if !ml.unschedule(rec.Target) {
+			return fmt.Errorf("UNDELAY de item não agendado (%d em '%s')", rec.Target, rec.ListID)
+		}
+	default:
+		return fmt.Errorf("operação de log desconhecida %q", rec.Op)
+	}
+	return nil
+}
+
+// collectAborted percorre os arquivos de log em paths e devolve os números dos
+// registros anulados por ABORT. O replay precisa deles antes de aplicar qualquer
+// registro, pois o ABORT sempre fica depois do registro que anula. O último de
+// paths é o segmento mais novo (ver openTail).
+func collectAborted(fsys FS, paths []string) (map[uint64]bool, error) {
+	aborted := make(map[uint64]bool)
+	for i, path := range paths {
+		open := openRead
+		if i == len(paths)-1 {
+			open = openTail
+		}
+		f, err := open(fsys, path)
+		if err != nil {
+			return nil, err
+		}
+		err = scanLog(f, func(lineNo int, line string, rec LogRecord, err error) error {
+			if err == nil && rec.Op == "ABORT" {
+				aborted[rec.Target] = true
+			}
+			return nil
+		})
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return aborted, nil
+}
+
+// repairLog é chamado antes da próxima escrita depois de uma falha no log.
+// O segmento com o registro que falhou é abandonado (uma linha pela metade nunca
+// é emendada com a seguinte) e o novo segmento começa com um ABORT dele.
+// Enquanto o ABORT não estiver no disco, nenhuma operação nova é logada.
+// Deve ser chamado com rl.logLock.
+func (rl *RemoteList) repairLog() error {
+	// Sem o bloco final, o segmento abandonado não poderia ser lido depois de
+	// deixar de ser o mais novo: rotateSegment o regrava com ele.
+	if !slices.Contains(rl.abandoned, rl.segment) {
+		rl.abandoned = append(rl.abandoned, rl.segment)
+	}
+	if _, err := rl.rotateSegment(); err != nil {
+		return err
+	}
+	rec := LogRecord{Seq: rl.seq + 1, Time: time.Now(), Op: "ABORT", Target: rl.failedSeq}
+	rl.seq = rec.Seq
+	line, err := rec.encode()
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(rl.logFile, line); err != nil {
+		return err
+	}
+	if err := rl.logFile.Sync(); err != nil {
+		return err
+	}
+	log.Printf("Log reparado: registro %d anulado no segmento %d.", rl.failedSeq, rl.segment)
+	rl.failedSeq = 0
+	rl.logged(rec)
+	return nil
+}
@@ -0,0 +1,248 @@
+package remotelist_test
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"remotelist/pkg"
+)
+
+// Segmentos do WAL, o formato dos registros e o replay, com o RemoteList
+// sobre um MemFS.
+
+// segments devolve os segmentos do log no diretório de dados, em ordem.
+func segments(mem *remotelist.MemFS) ([]string, error) {
+	names, err := mem.ReadDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, name := range names {
+		if strings.HasPrefix(name, "remotelist.log.") {
+			out = append(out, name)
+		}
+	}
+	return out, nil
+}
+
+// appendList acrescenta os valores à lista id.
+func appendList(rl *remotelist.RemoteList, id string, values ...int) error {
+	for _, v := range values {
+		if err := rl.Append(remotelist.AppendArgs{ListID: id, Value: v}, &remotelist.AppendReply{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expectList confere o conteúdo da lista id.
+func expectList(rl *remotelist.RemoteList, id string, want []int) error {
+	var data remotelist.ExportListReply
+	if err := rl.Peer().ExportList(remotelist.ExportListArgs{ListID: id}, &data); err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(data.Values, want) {
+		return fmt.Errorf("lista %q = %v, esperado %v", id, data.Values, want)
+	}
+	return nil
+}
+
+// recordLine é um registro no formato "<seq> <unix-nano> <op> <campos...>".
+var recordLine = regexp.MustCompile(`^(\d+) (\d+) (APPEND l (\d+))$`)
+
+// Cada snapshot sela o segmento atual e apaga os que ele cobre; os registros
+// do segmento novo continuam a numeração.
+func TestWALRotation(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	first, err := segments(n.mem)
+	if err != nil || len(first) != 1 {
+		t.Fatalf("segmentos ao abrir: %v, %v", first, err)
+	}
+	for round := 0; round < 2; round++ {
+		if err := appendList(n.rl, "l", 3*round, 3*round+1, 3*round+2); err != nil {
+			t.Fatal(err)
+		}
+		if err := n.rl.Snapshot(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := appendList(n.rl, "l", 6, 7); err != nil {
+		t.Fatal(err)
+	}
+
+	segs, err := segments(n.mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	number := func(name string) int {
+		v, _ := strconv.Atoi(strings.TrimPrefix(name, "remotelist.log."))
+		return v
+	}
+	if len(segs) != 1 || number(segs[0]) != number(first[0])+2 {
+		t.Fatalf("segmentos depois de dois snapshots: %v (o primeiro era %s)", segs, first[0])
+	}
+	data, err := n.mem.ReadFile(dataDir + "/" + segs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("segmento atual com %d registros, esperado 2:\n%s", len(lines), data)
+	}
+	for i, line := range lines {
+		m := recordLine.FindStringSubmatch(line)
+		if m == nil {
+			t.Fatalf("registro fora do formato: %q", line)
+		}
+		seq, _ := strconv.Atoi(m[1])
+		nanos, _ := strconv.ParseInt(m[2], 10, 64)
+		if seq != 7+i || m[4] != strconv.Itoa(6+i) {
+			t.Fatalf("registro %q: esperado seq %d com o valor %d", line, 7+i, 6+i)
+		}
+		if at := time.Unix(0, nanos); at.Before(start) || at.After(time.Now()) {
+			t.Fatalf("registro %q: instante %v fora do teste", line, at)
+		}
+	}
+
+	// Depois da queda, o snapshot e o segmento atual refazem a lista, e a
+	// numeração continua de onde parou.
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectList(n.rl, "l", []int{0, 1, 2, 3, 4, 5, 6, 7}); err != nil {
+		t.Fatal(err)
+	}
+	var reply remotelist.AppendReply
+	if err := n.rl.Append(remotelist.AppendArgs{ListID: "l", Value: 8}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Token != 9 {
+		t.Fatalf("registro depois da queda com seq %d, esperado 9", reply.Token)
+	}
+}
+
+// Um log do formato antigo (remotelist.log, sem seq nem instante) vira o
+// segmento 0 e é reaplicado; os registros novos vêm depois dele.
+func TestWALOldFormat(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.add("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.mem.MkdirAll(dataDir, 0755)
+	f, err := n.mem.OpenFile(dataDir+"/remotelist.log", os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(f, "APPEND l 1\nAPPEND l 2\nAPPEND m 3\nREMOVE l\n  \nAPPEND l 4\n")
+	if err := f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if err := n.start(remotelist.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, check := range []func() error{
+		func() error { return expectList(n.rl, "l", []int{1, 4}) },
+		func() error { return expectList(n.rl, "m", []int{3}) },
+	} {
+		if err := check(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := n.mem.Stat(dataDir + "/remotelist.log"); err == nil {
+		t.Fatal("remotelist.log continua no diretório depois da migração")
+	}
+	if err := appendList(n.rl, "l", 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectList(n.rl, "l", []int{1, 4, 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.rl.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.reboot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectList(n.rl, "m", []int{3}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Nomes de chave que o formato do log separa por espaços (vazio, com espaços,
+// com aspas ou quebras de linha) sobrevivem ao replay e ao snapshot.
+func TestWALKeyNames(t *testing.T) {
+	c := newCluster(t)
+	n, err := c.start(remotelist.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"", "a b", " ", "tab\tx", `"aspas"`, "nbsp\u00a0x", "linha\nquebrada", `\x20`, "l"}
+	for i, id := range names {
+		if err := appendList(n.rl, id, i, 10+i); err != nil {
+			t.Fatalf("Append em %q: %v", id, err)
+		}
+	}
+	if err := n.rl.Remove(remotelist.RemoveArgs{ListID: ""}, &remotelist.RemoveReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.rl.SetAdd(remotelist.SetAddArgs{Key: "um set", Member: 1}, &remotelist.SetAddReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.rl.MapSet(remotelist.MapSetArgs{Key: "um\u00a0map", Field: "f1", Value: 2}, &remotelist.MapSetReply{}); err != nil {
+		t.Fatal(err)
+	}
+	// Uma chave com espaços num RESTORE (migração) e numa transação.
+	restore := remotelist.ImportListArgs{ListID: "set importado", Structures: remotelist.Structures{Sets: map[string][]int{"set importado": {3, 4}}}}
+	if err := n.rl.Peer().ImportList(restore, &remotelist.ImportListReply{}); err != nil {
+		t.Fatal(err)
+	}
+	tx := remotelist.PrepareTxArgs{TxID: "tx1", Ops: []remotelist.TxOp{{Op: "APPEND", ListID: "a b", Value: 99}, {Op: "REMOVE", ListID: " "}}}
+	if err := n.rl.Peer().PrepareTx(tx, &remotelist.PrepareTxReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.rl.Peer().CommitTx(remotelist.CommitTxArgs{TxID: "tx1"}, &remotelist.CommitTxReply{}); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := dumpStructs(n.rl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != len(names)+3 || want[""] != "list [0]" || want["a b"] != "list [1 11 99]" || want[" "] != "list [2]" {
+		t.Fatalf("estado antes da queda: %q", want)
+	}
+	for _, step := range []string{"replay", "snapshot"} {
+		if step == "snapshot" {
+			if err := n.rl.Snapshot(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := n.reboot(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := dumpStructs(n.rl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("depois do %s:\n  tem    %q\n  devia  %q", step, got, want)
+		}
+	}
+}
@@ -1,48 +1,152 @@
 package main
 
 import (
-	"remotelist/pkg"
+	"flag"
+	"fmt"
 	"log"
 	"net"
 	"net/rpc"
+	"os"
+	"path/filepath"
+	"remotelist/pkg"
+	"strings"
+	"time"
 )
 
 func main() {
+	archiveDir := flag.String("archive", "", "diretório para arquivar snapshots e segmentos antigos do log (habilita GetAt e remotelist-restore)")
+	engine := flag.String("engine", "wal", "armazenamento: wal (memória + log + snapshot) ou bolt (banco bbolt em remotelist.db)")
+	boltBatch := flag.Duration("bolt-batch", 0, "com -engine bolt, quanto uma escrita espera para dividir o fsync com outras (0 = não agrupa)")
+	listen := flag.String("listen", "[localhost]:5000", "endereço TCP do servidor")
+	dir := flag.String("dir", "", "diretório dos arquivos de dados (vazio = diretório atual)")
+	backup := flag.Bool("backup", false, "começa como backup: recusa escritas e recebe o WAL do primário (promova com o comando promote do remotelist-shell)")
+	backups := flag.String("backups", "", "backups que recebem o WAL deste servidor, separados por vírgula")
+	syncRepl := flag.Bool("sync-replication", false, "confirma cada escrita só depois que um backup a gravar (com -backups)")
+	primary := flag.String("primary", "", "com -backup: endereço do primário, para onde vão as leituras strong (ou atrasadas demais)")
+	reapInterval := flag.Duration("reap-interval", 0, "intervalo entre as varreduras que apagam listas com TTL vencido (0 = 1s; negativo desliga)")
+	scheduleInterval := flag.Duration("schedule-interval", 0, "tempo máximo entre as verificações dos itens agendados (0 = 1s; negativo desliga o disparo)")
+	maxList := flag.Int("max-list", 0, "máximo de elementos em uma lista (0 = sem limite)")
+	maxTotal := flag.Int("max-total", 0, "máximo de elementos somando todas as listas (0 = sem limite)")
+	rate := flag.Float64("rate", 0, "pedidos por segundo aceitos de cada conexão (0 = sem limite)")
+	rateBurst := flag.Int("rate-burst", 0, "pedidos que uma conexão pode fazer de uma vez, em rajada (0 = o valor de -rate)")
+	maxConns := flag.Int("max-conns", 0, "conexões atendidas ao mesmo tempo; as outras esperam (0 = sem limite)")
+	idleTimeout := flag.Duration("idle-timeout", 0, "fecha conexões sem pedidos por esse tempo (0 = nunca)")
+	readTimeout := flag.Duration("read-timeout", 0, "tempo máximo para um pedido chegar por inteiro (0 = sem limite)")
+	writeTimeout := flag.Duration("write-timeout", 0, "tempo máximo para uma resposta ser escrita (0 = sem limite)")
+	snapshotCompression := flag.String("snapshot-compression", "", "compressão dos snapshots: gzip, zstd ou vazio (nenhuma)")
+	keyFile := flag.String("key-file", "", "arquivo com a chave AES (hex) que cifra o log e os snapshots (vazio = REMOTELIST_KEY_FILE ou REMOTELIST_KEY)")
+	oldKeyFiles := flag.String("old-key-files", "", "chaves antigas, separadas por vírgula, só para ler (e recifrar) dados gravados com elas")
+	migratePlaintext := flag.Bool("migrate-plaintext", false, "com chave, aceita nesta inicialização os dados gravados sem criptografia e os recifra")
+	flag.Parse()
+
 	log.Println("Iniciando servidor RemoteList...")
 
 	// 1. Cria a instância do serviço.
-	// O construtor NewRemoteList() agora cuida de carregar do disco
-	// e iniciar a rotina de snapshot.
-	list := remotelist.NewRemoteList()
+	// O construtor cuida de carregar do disco e iniciar a rotina de snapshot.
+	// O serviço Peer (replicação, migração e transações) só atende conexões
+	// que apresentam esta chave; sem ela, só conexões locais.
+	peerKey := os.Getenv("REMOTELIST_PEER_KEY")
+	var list, peer any
+	connCfg := remotelist.ConnConfig{MaxConns: *maxConns, IdleTimeout: *idleTimeout, ReadTimeout: *readTimeout, WriteTimeout: *writeTimeout, PeerKey: peerKey}
+	switch *engine {
+	case "wal":
+		cfg := remotelist.Config{Dir: *dir, ArchiveDir: *archiveDir, Backup: *backup, SyncReplication: *syncRepl, Primary: *primary, ReapInterval: *reapInterval,
+			ScheduleInterval: *scheduleInterval, MaxListElements: *maxList, MaxTotalElements: *maxTotal, RateLimit: *rate, RateBurst: *rateBurst,
+			SnapshotCompression: *snapshotCompression, MigratePlaintext: *migratePlaintext, PeerKey: peerKey}
+		if *backups != "" {
+			cfg.Backups = strings.Split(*backups, ",")
+		}
+		var oldKeys []string
+		if *oldKeyFiles != "" {
+			oldKeys = strings.Split(*oldKeyFiles, ",")
+		}
+		var err error
+		if cfg.EncryptionKey, err = remotelist.LoadKey(*keyFile); err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		if cfg.OldEncryptionKeys, err = remotelist.LoadOldKeys(oldKeys); err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		rl, err := remotelist.NewRemoteListWithConfig(cfg)
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		list, peer = rl, rl.Peer()
+		// Os limites de pedidos e as métricas de recusas ficam na conexão.
+		connCfg.Limits = rl
+	case "bolt":
+		bl, err := openBolt(*dir, *boltBatch)
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		list, peer = bl, bl.Peer()
+	default:
+		log.Fatalf("FATAL: armazenamento desconhecido %q", *engine)
+	}
 
 	// 2. Configura o servidor RPC
 	rpcs := rpc.NewServer()
-	// Registra a instância 'list'. O RPC vai expor os métodos
-	// do tipo 'RemoteList' (Append, Get, Remove, Size)
-	err := rpcs.Register(list)
+	// Registra a instância 'list' com o nome 'RemoteList' (os dois armazenamentos
+	// expõem os mesmos métodos: Append, Get, Remove, Size, Lists; GetAt só no wal)
+	err := rpcs.RegisterName("RemoteList", list)
 	if err != nil {
 		log.Fatalf("Falha ao registrar o serviço RPC: %v", err)
 	}
+	// Os métodos chamados pelos outros servidores ficam no serviço Peer, fora
+	// do limite de pedidos e só para quem tem a chave.
+	if err := rpcs.RegisterName("Peer", peer); err != nil {
+		log.Fatalf("Falha ao registrar o serviço RPC: %v", err)
+	}
+
+	// A tabela de conexões, para diagnóstico (comando conns do remotelist-shell).
+	conns := remotelist.NewConnServer(rpcs, connCfg)
+	if err := rpcs.RegisterName("Conns", conns); err != nil {
+		log.Fatalf("Falha ao registrar o serviço RPC: %v", err)
+	}
 
 	// 3. Ouve por conexões TCP
-	l, e := net.Listen("tcp", "[localhost]:5000")
+	l, e := net.Listen("tcp", *listen)
 	if e != nil {
-		log.Fatalf("Erro ao escutar na porta :5000: %v", e)
+		log.Fatalf("Erro ao escutar em %s: %v", *listen, e)
 	}
 	defer l.Close()
-	log.Println("Servidor escutando na porta :5000")
+	log.Printf("Servidor escutando em %s", *listen)
 
-	// 4. Loop de aceitação de conexões
-	for {
-		conn, err := l.Accept()
-		if err != nil {
-			// Se houver erro no Accept, loga e continua (a menos que seja um erro fatal)
-			log.Printf("Erro ao aceitar conexão: %v", err)
-			continue
-		}
-		// Lança uma goroutine para lidar com cada cliente
-		// Isso permite que múltiplos clientes sejam atendidos simultaneamente
-		log.Printf("Nova conexão de %s", conn.RemoteAddr())
-		go rpcs.ServeConn(conn)
+	// 4. Loop de aceitação de conexões: cada cliente é atendido numa goroutine,
+	// dentro dos limites de -max-conns e dos timeouts.
+	if err := conns.Serve(l); err != nil {
+		log.Fatalf("Erro ao aceitar conexões: %v", err)
+	}
+}
+
+// openBolt abre o banco bbolt. Na primeira vez, se o diretório tiver dados no
+// formato WAL + snapshot, eles são importados (os arquivos antigos não são
+// tocados); a importação fica marcada no banco e não se repete.
+func openBolt(dir string, batchDelay time.Duration) (*remotelist.BoltList, error) {
+	bl, err := remotelist.OpenBoltList(remotelist.BoltConfig{Path: filepath.Join(dir, "remotelist.db"), MaxBatchDelay: batchDelay})
+	if err != nil {
+		return nil, err
+	}
+	imported, err := bl.Imported()
+	if err != nil {
+		bl.Close()
+		return nil, err
+	}
+	if imported {
+		return bl, nil
+	}
+	st, err := remotelist.LoadOffline(dir)
+	if err != nil {
+		bl.Close()
+		return nil, fmt.Errorf("falha ao ler os dados antigos de %s: %w", dir, err)
+	}
+	n, err := bl.ImportOffline(st, time.Now())
+	if err != nil {
+		bl.Close()
+		return nil, fmt.Errorf("falha ao importar os dados antigos: %w", err)
+	}
+	if n > 0 {
+		log.Printf("%d listas importadas do snapshot e do log para o bbolt.", n)
 	}
-}
\ No newline at end of file
+	return bl, nil
+}